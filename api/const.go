@@ -1,8 +1,18 @@
 package api
 
 const (
-	// API_VERSION is the API version of Convoy daemon
-	API_VERSION = "1"
+	// API_VERSION is the API version of Convoy daemon, as <major>.<minor>,
+	// see docs/api.md for the compatibility policy
+	API_VERSION = "1.19"
+	// API_MAJOR_VERSION is the major version of API_VERSION, which prefixes
+	// paths of the API, e.g. /v1/volumes/list
+	API_MAJOR_VERSION = "1"
+	// API_VERSION_HEADER carries the API version a client speaks in
+	// requests, and API_VERSION of the daemon in responses
+	API_VERSION_HEADER = "Convoy-API-Version"
+	// NEXT_PAGE_HEADER has the value of "after" for the next page of a list
+	// with "limit", if there are more left
+	NEXT_PAGE_HEADER = "Convoy-Next-Page"
 
 	KEY_NAME       = "name"
 	KEY_BACKUP_URL = "backup"
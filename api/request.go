@@ -1,25 +1,67 @@
 package api
 
 type VolumeMountRequest struct {
-	VolumeName string
-	MountPoint string
-	Verbose    bool
+	VolumeName   string
+	MountPoint   string
+	ReadOnly     bool
+	SubPath      string
+	SELinuxLabel string
+	Verbose      bool
 }
 
 type VolumeUmountRequest struct {
 	VolumeName string
 }
 
+type VolumePublishRequest struct {
+	VolumeName string
+	TargetPath string
+	ReadOnly   bool
+}
+
+type VolumeUnpublishRequest struct {
+	VolumeName string
+	TargetPath string
+}
+
 type VolumeCreateRequest struct {
-	Name           string
-	DriverName     string
-	Size           int64
-	BackupURL      string
-	DriverVolumeID string
-	Type           string
-	IOPS           int64
-	PrepareForVM   bool
-	Verbose        bool
+	Name            string
+	DriverName      string
+	Size            int64
+	BackupURL       string
+	DriverVolumeID  string
+	Type            string
+	IOPS            int64
+	PrepareForVM    bool
+	Filesystem      string
+	MkfsOptions     string
+	MountOptions    string
+	EncryptionKey   string
+	KmsKeyID        string
+	BackupBlockSize string
+	FsFreeze        string
+	Labels          map[string]string
+	Verbose         bool
+	Async           bool
+}
+
+type VolumeCloneRequest struct {
+	VolumeName string
+	Name       string
+	Labels     map[string]string
+	Verbose    bool
+	Async      bool
+}
+
+type VolumeExpandRequest struct {
+	VolumeName string
+	Size       int64
+	Verbose    bool
+}
+
+type VolumeRestoreRequest struct {
+	Volumes []VolumeCreateRequest
+	DryRun  bool
 }
 
 type VolumeDeleteRequest struct {
@@ -31,10 +73,19 @@ type VolumeInspectRequest struct {
 	VolumeName string
 }
 
+type VolumeTimelineRequest struct {
+	VolumeName string
+	URL        string
+}
+
 type SnapshotCreateRequest struct {
 	Name       string
 	VolumeName string
+	KmsKeyID   string
+	FsFreeze   bool
+	Labels     map[string]string
 	Verbose    bool
+	Async      bool
 }
 
 type SnapshotDeleteRequest struct {
@@ -49,14 +100,127 @@ type BackupListRequest struct {
 	URL          string
 	VolumeName   string
 	SnapshotName string
+	Filters      []string
+	Since        string
+	Until        string
 }
 
 type BackupCreateRequest struct {
 	URL          string
 	SnapshotName string
+	Labels       map[string]string
 	Verbose      bool
+	Async        bool
 }
 
 type BackupDeleteRequest struct {
 	URL string
 }
+
+type BackupIndexRequest struct {
+	URL     string
+	Rebuild bool
+}
+
+type BackupReplicateRequest struct {
+	URL     string
+	DestURL string
+	Verbose bool
+}
+
+type BackupExportRequest struct {
+	URL string
+}
+
+type BackupMigrateRequest struct {
+	URL    string
+	DryRun bool
+}
+
+type BackupRotateKeyRequest struct {
+	URL string
+	Key string
+}
+
+type BackupMountRequest struct {
+	URL        string
+	DriverName string
+	MountPoint string
+	Verbose    bool
+}
+
+type BackupUmountRequest struct {
+	VolumeName string
+}
+
+type BackupPruneRequest struct {
+	URL        string
+	VolumeName string
+	Retention  string
+	DryRun     bool
+}
+
+type ScheduleSetRequest struct {
+	VolumeName string
+	Interval   string
+	Cron       string
+	URL        string
+	Retention  string
+}
+
+type ScheduleDeleteRequest struct {
+	VolumeName string
+}
+
+type ScheduleRunRequest struct {
+	VolumeName string
+}
+
+type ScheduleExportRequest struct {
+	Format      string
+	VolumeNames []string
+	Binary      string
+	Socket      string
+	Image       string
+	Namespace   string
+	Node        string
+}
+
+type HookSetRequest struct {
+	VolumeName   string
+	PreSnapshot  string
+	PostSnapshot string
+	Timeout      string
+	OnFailure    string
+}
+
+type HookDeleteRequest struct {
+	VolumeName string
+}
+
+type JobInspectRequest struct {
+	ID string
+}
+
+type JobCancelRequest struct {
+	ID string
+}
+
+type LoggingSetRequest struct {
+	Levels string
+}
+
+// MaintenanceSetRequest enters maintenance if Enabled, or leaves it. Drain
+// releases volumes not mounted from the host as well on entering
+type MaintenanceSetRequest struct {
+	Enabled bool
+	Reason  string
+	Drain   bool
+}
+
+// LeaseBreakRequest releases volume held by another daemon, only if it's
+// Holder if specified
+type LeaseBreakRequest struct {
+	VolumeName string
+	Holder     string
+}
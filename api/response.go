@@ -17,17 +17,52 @@ type ErrorResponse struct {
 type VolumeResponse struct {
 	Name        string
 	Driver      string
+	State       string
+	Error       string `json:",omitempty"`
 	MountPoint  string
 	CreatedTime string
+	LastMounted string            `json:",omitempty"`
+	LastIO      string            `json:",omitempty"`
+	Labels      map[string]string `json:",omitempty"`
 	DriverInfo  map[string]string
 	Snapshots   map[string]SnapshotResponse
 }
 
+// VolumeUsageResponse tells space provisioned for and used by the volume. Used
+// is the space taken in the backend as reported by driver, or by filesystem of
+// the mounted volume otherwise, -1 if unknown. Filesystem fields are only
+// reported for volumes mounted.
+type VolumeUsageResponse struct {
+	Name           string
+	Driver         string
+	State          string
+	MountPoint     string
+	Size           int64
+	Used           int64
+	FilesystemSize int64  `json:",omitempty"`
+	FilesystemUsed int64  `json:",omitempty"`
+	Error          string `json:",omitempty"`
+}
+
+type VolumeRestoreResult struct {
+	Name        string
+	Driver      string
+	BackupURL   string
+	Size        int64  `json:",omitempty"`
+	CreatedTime string `json:",omitempty"`
+}
+
+type VolumeRestoreResponse struct {
+	DryRun  bool
+	Volumes []VolumeRestoreResult
+}
+
 type SnapshotResponse struct {
 	Name            string
 	VolumeName      string `json:",omitempty"`
 	VolumeCreatedAt string `json:",omitempty"`
 	CreatedTime     string
+	Labels          map[string]string `json:",omitempty"`
 	DriverInfo      map[string]string
 }
 
@@ -35,6 +70,80 @@ type BackupURLResponse struct {
 	URL string
 }
 
+type VolumeEvent struct {
+	Time   string
+	Age    string `json:",omitempty"`
+	Object string
+	Event  string
+	Name   string `json:",omitempty"`
+	Detail string `json:",omitempty"`
+	// Error is set if the operation of the event failed
+	Error string `json:",omitempty"`
+}
+
+/*
+Event is an event streamed by /events and sent to webhooks. Events of volumes
+are the same as in their timelines, others have no VolumeName, e.g. driver
+health and alert events.
+*/
+type Event struct {
+	VolumeName string `json:",omitempty"`
+	Host       string
+	VolumeEvent
+}
+
+type VolumeTimelineResponse struct {
+	VolumeName string
+	Events     []VolumeEvent
+}
+
+type LatencyResponse struct {
+	Count    int
+	P50      string            `json:",omitempty"`
+	P95      string            `json:",omitempty"`
+	P99      string            `json:",omitempty"`
+	SLOs     map[string]string `json:",omitempty"`
+	Breached []string          `json:",omitempty"`
+}
+
+type StatsResponse struct {
+	Window    string
+	Latencies map[string]LatencyResponse
+}
+
+type ScheduleResponse struct {
+	VolumeName    string
+	Interval      string `json:",omitempty"`
+	Cron          string `json:",omitempty"`
+	URL           string
+	CreatedTime   string
+	NextRun       string
+	LastRun       string
+	LastSnapshot  string
+	LastBackupURL string
+	LastError     string
+	Retention     string `json:",omitempty"`
+	Running       bool
+}
+
+type ScheduleExportFile struct {
+	Name    string
+	Content string
+}
+
+type ScheduleExportResponse struct {
+	Files []ScheduleExportFile
+}
+
+type BackupPruneResponse struct {
+	VolumeName string
+	Retention  string
+	Kept       []string
+	Pruned     []string
+	Locked     []string `json:",omitempty"`
+	DryRun     bool     `json:",omitempty"`
+}
+
 // ResponseError would generate a error information in JSON format for output
 func ResponseError(format string, a ...interface{}) {
 	response := ErrorResponse{Error: fmt.Sprintf(format, a...)}
@@ -81,3 +190,81 @@ func ResponseOutput(v interface{}) ([]byte, error) {
 	}
 	return j, nil
 }
+
+type JobResponse struct {
+	ID           string
+	Type         string
+	State        string
+	VolumeName   string `json:",omitempty"`
+	Result       string `json:",omitempty"`
+	Error        string `json:",omitempty"`
+	CreatedTime  string
+	StartedTime  string `json:",omitempty"`
+	FinishedTime string `json:",omitempty"`
+}
+
+type ComponentHealth struct {
+	Healthy   bool
+	Error     string `json:",omitempty"`
+	CheckedAt string `json:",omitempty"`
+}
+
+type HealthResponse struct {
+	Status     string
+	Components map[string]ComponentHealth
+}
+
+// QuotaResponse is quota of a driver, zero for unlimited, and its usage
+type QuotaResponse struct {
+	Driver        string
+	MaxVolumes    int
+	MaxTotalSize  int64
+	MaxVolumeSize int64
+	Volumes       int
+	TotalSize     int64
+}
+
+// LeaseResponse is the daemon holding a coordinated volume, empty if none
+type LeaseResponse struct {
+	VolumeName string
+	Driver     string
+	Holder     string
+	HeldHere   bool
+	Mounted    bool
+}
+
+// AuditRecord is a request recorded in the audit log, see /audit
+type AuditRecord struct {
+	Time       string
+	Caller     string
+	RemoteAddr string `json:",omitempty"`
+	Method     string
+	Route      string
+	Volumes    []string               `json:",omitempty"`
+	Parameters map[string]interface{} `json:",omitempty"`
+	StatusCode int
+	Error      string `json:",omitempty"`
+	Duration   string
+}
+
+type LoggingResponse struct {
+	Format string
+	Levels string
+}
+
+// MaintenanceResponse tells whether daemon is in maintenance, since when and
+// why
+type MaintenanceResponse struct {
+	Enabled bool
+	Reason  string `json:",omitempty"`
+	Since   string `json:",omitempty"`
+}
+
+type HookResponse struct {
+	VolumeName   string
+	PreSnapshot  string
+	PostSnapshot string
+	Timeout      string
+	OnFailure    string
+	CreatedTime  string
+}
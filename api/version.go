@@ -0,0 +1,27 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseVersion parses API version <major>.<minor>, or <major> alone as of
+// clients before API version 1.1, whose minor is 0
+func ParseVersion(version string) (int, int, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("Invalid API version %q", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || major < 0 {
+		return 0, 0, fmt.Errorf("Invalid API version %q", version)
+	}
+	minor := 0
+	if len(parts) == 2 {
+		if minor, err = strconv.Atoi(parts[1]); err != nil || minor < 0 {
+			return 0, 0, fmt.Errorf("Invalid API version %q", version)
+		}
+	}
+	return major, minor, nil
+}
@@ -0,0 +1,103 @@
+/*
+Package cbt tracks blocks changed between snapshots of a volume, so
+incremental block backups only read the blocks written since the last backup,
+rather than comparing every block of the snapshots.
+
+Snapshots which are copies of an image file sharing extents with it, e.g.
+`cp --reflink` on xfs or btrfs, are tracked by extents of the files: a block
+mapped to the same physical extent in both files cannot have changed, since
+writing it would have copied the extent first.
+*/
+package cbt
+
+import (
+	"errors"
+
+	"github.com/rancher/convoy/metadata"
+)
+
+var (
+	// ErrNotTracked means changes cannot be told without comparing data,
+	// e.g. filesystem doesn't share extents between the files
+	ErrNotTracked = errors.New("Changed blocks are not tracked")
+)
+
+// Bitmap records which blocks of blockSize of a volume have changed
+type Bitmap struct {
+	blockSize int64
+	blocks    int64
+	bits      []uint64
+}
+
+// NewBitmap returns bitmap of a volume of size, with no block changed. The
+// last block may be partial.
+func NewBitmap(size, blockSize int64) *Bitmap {
+	blocks := (size + blockSize - 1) / blockSize
+	return &Bitmap{
+		blockSize: blockSize,
+		blocks:    blocks,
+		bits:      make([]uint64, (blocks+63)/64),
+	}
+}
+
+func (b *Bitmap) BlockSize() int64 {
+	return b.blockSize
+}
+
+// Set marks blocks overlapping length bytes at offset as changed. Range
+// beyond the volume is ignored.
+func (b *Bitmap) Set(offset, length int64) {
+	if length <= 0 {
+		return
+	}
+	end := (offset + length + b.blockSize - 1) / b.blockSize
+	if end > b.blocks {
+		end = b.blocks
+	}
+	for block := offset / b.blockSize; block < end; block++ {
+		b.bits[block/64] |= 1 << uint(block%64)
+	}
+}
+
+func (b *Bitmap) IsSet(block int64) bool {
+	if block < 0 || block >= b.blocks {
+		return false
+	}
+	return b.bits[block/64]&(1<<uint(block%64)) != 0
+}
+
+// Count returns the number of changed blocks
+func (b *Bitmap) Count() int64 {
+	count := int64(0)
+	for block := int64(0); block < b.blocks; block++ {
+		if b.IsSet(block) {
+			count++
+		}
+	}
+	return count
+}
+
+// Mappings returns changed blocks, with consecutive ones merged, as
+// CompareSnapshot() of DeltaBlockBackupOperations does
+func (b *Bitmap) Mappings() *metadata.Mappings {
+	mappings := &metadata.Mappings{
+		Mappings:  []metadata.Mapping{},
+		BlockSize: b.blockSize,
+	}
+	for block := int64(0); block < b.blocks; block++ {
+		if !b.IsSet(block) {
+			continue
+		}
+		offset := block * b.blockSize
+		last := len(mappings.Mappings) - 1
+		if last >= 0 && mappings.Mappings[last].Offset+mappings.Mappings[last].Size == offset {
+			mappings.Mappings[last].Size += b.blockSize
+			continue
+		}
+		mappings.Mappings = append(mappings.Mappings, metadata.Mapping{
+			Offset: offset,
+			Size:   b.blockSize,
+		})
+	}
+	return mappings
+}
@@ -0,0 +1,95 @@
+// +build linux
+
+package cbt
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/convoy/metadata"
+
+	. "gopkg.in/check.v1"
+)
+
+const (
+	testBlockSize = 4096
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestBitmap(c *C) {
+	// Last block is partial
+	bitmap := NewBitmap(10*testBlockSize+1, testBlockSize)
+	c.Assert(bitmap.Count(), Equals, int64(0))
+
+	bitmap.Set(0, 1)
+	bitmap.Set(testBlockSize+1, testBlockSize)
+	bitmap.Set(10*testBlockSize, testBlockSize)
+	bitmap.Set(20*testBlockSize, testBlockSize)
+	c.Assert(bitmap.Count(), Equals, int64(4))
+	for block, set := range []bool{true, true, true, false, false, false, false, false, false, false, true} {
+		c.Assert(bitmap.IsSet(int64(block)), Equals, set)
+	}
+	c.Assert(bitmap.IsSet(11), Equals, false)
+	c.Assert(bitmap.Mappings(), DeepEquals, &metadata.Mappings{
+		Mappings: []metadata.Mapping{
+			{Offset: 0, Size: 3 * testBlockSize},
+			{Offset: 10 * testBlockSize, Size: testBlockSize},
+		},
+		BlockSize: testBlockSize,
+	})
+}
+
+func (s *TestSuite) TestDiffExtents(c *C) {
+	size := int64(16 * testBlockSize)
+	extents := []extent{
+		// Shared
+		{logical: 0, physical: 100 * testBlockSize, length: 2 * testBlockSize, flags: FIEMAP_EXTENT_SHARED},
+		// Rewritten
+		{logical: 2 * testBlockSize, physical: 200 * testBlockSize, length: testBlockSize},
+		// Shared, but moved within the file
+		{logical: 3 * testBlockSize, physical: 300 * testBlockSize, length: testBlockSize, flags: FIEMAP_EXTENT_SHARED},
+		// Written into a hole
+		{logical: 6 * testBlockSize, physical: 400 * testBlockSize, length: testBlockSize},
+		// Preallocated, reads as zeros like a hole
+		{logical: 8 * testBlockSize, physical: 500 * testBlockSize, length: testBlockSize, flags: FIEMAP_EXTENT_UNWRITTEN},
+		// Not allocated yet
+		{logical: 10 * testBlockSize, physical: 0, length: testBlockSize, flags: FIEMAP_EXTENT_DELALLOC},
+	}
+	compareExtents := []extent{
+		{logical: 0, physical: 100 * testBlockSize, length: 3 * testBlockSize, flags: FIEMAP_EXTENT_SHARED},
+		{logical: 3 * testBlockSize, physical: 301 * testBlockSize, length: testBlockSize, flags: FIEMAP_EXTENT_SHARED},
+		// Punched
+		{logical: 12 * testBlockSize, physical: 600 * testBlockSize, length: testBlockSize},
+	}
+	bitmap := diffExtents(extents, compareExtents, size, testBlockSize)
+	changed := []int64{}
+	for block := int64(0); block < 16; block++ {
+		if bitmap.IsSet(block) {
+			changed = append(changed, block)
+		}
+	}
+	c.Assert(changed, DeepEquals, []int64{2, 3, 6, 10, 12})
+}
+
+func (s *TestSuite) TestChangedBlocksNotShared(c *C) {
+	dir := c.MkDir()
+	data := make([]byte, 4*testBlockSize)
+	for i := range data {
+		data[i] = 'a'
+	}
+	file := filepath.Join(dir, "snap2")
+	compareFile := filepath.Join(dir, "snap1")
+	c.Assert(ioutil.WriteFile(file, data, 0600), IsNil)
+	c.Assert(ioutil.WriteFile(compareFile, data, 0600), IsNil)
+
+	// Copies not sharing extents, or on filesystem without extents, cannot
+	// be told apart without reading them
+	_, err := ChangedBlocks(file, compareFile, testBlockSize)
+	c.Assert(err, Equals, ErrNotTracked)
+}
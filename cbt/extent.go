@@ -0,0 +1,192 @@
+// +build linux
+
+package cbt
+
+import (
+	"math"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// From linux/fs.h and linux/fiemap.h
+	FS_IOC_FIEMAP = 0xC020660B
+
+	FIEMAP_FLAG_SYNC = 0x1
+
+	FIEMAP_EXTENT_LAST         = 0x1
+	FIEMAP_EXTENT_UNKNOWN      = 0x2
+	FIEMAP_EXTENT_DELALLOC     = 0x4
+	FIEMAP_EXTENT_ENCODED      = 0x8
+	FIEMAP_EXTENT_NOT_ALIGNED  = 0x100
+	FIEMAP_EXTENT_DATA_INLINE  = 0x200
+	FIEMAP_EXTENT_DATA_TAIL    = 0x400
+	FIEMAP_EXTENT_UNWRITTEN    = 0x800
+	FIEMAP_EXTENT_SHARED       = 0x2000
+	FIEMAP_EXTENTS_PER_REQUEST = 256
+
+	// Physical address of extents with these flags doesn't tell where the
+	// data is
+	unreliableExtentFlags = FIEMAP_EXTENT_UNKNOWN | FIEMAP_EXTENT_DELALLOC | FIEMAP_EXTENT_ENCODED |
+		FIEMAP_EXTENT_NOT_ALIGNED | FIEMAP_EXTENT_DATA_INLINE | FIEMAP_EXTENT_DATA_TAIL
+)
+
+// fiemapExtent and fiemap are struct fiemap_extent and struct fiemap of
+// linux/fiemap.h
+type fiemapExtent struct {
+	Logical    uint64
+	Physical   uint64
+	Length     uint64
+	reserved64 [2]uint64
+	Flags      uint32
+	reserved   [3]uint32
+}
+
+type fiemap struct {
+	Start         uint64
+	Length        uint64
+	Flags         uint32
+	MappedExtents uint32
+	ExtentCount   uint32
+	reserved      uint32
+	Extents       [FIEMAP_EXTENTS_PER_REQUEST]fiemapExtent
+}
+
+type extent struct {
+	logical  int64
+	physical int64
+	length   int64
+	flags    uint32
+}
+
+// getExtents returns extents of data in file, in ascending order of logical
+// offset, after flushing data not allocated yet
+func getExtents(file string) ([]extent, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	extents := []extent{}
+	fm := &fiemap{}
+	start := uint64(0)
+	for {
+		*fm = fiemap{
+			Start:       start,
+			Length:      math.MaxUint64 - start,
+			Flags:       FIEMAP_FLAG_SYNC,
+			ExtentCount: FIEMAP_EXTENTS_PER_REQUEST,
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), FS_IOC_FIEMAP, uintptr(unsafe.Pointer(fm))); errno != 0 {
+			if errno == syscall.EOPNOTSUPP || errno == syscall.ENOTTY {
+				return nil, 0, ErrNotTracked
+			}
+			return nil, 0, errno
+		}
+		if fm.MappedExtents == 0 {
+			return extents, st.Size(), nil
+		}
+		for _, e := range fm.Extents[:fm.MappedExtents] {
+			extents = append(extents, extent{
+				logical:  int64(e.Logical),
+				physical: int64(e.Physical),
+				length:   int64(e.Length),
+				flags:    e.Flags,
+			})
+			if e.Flags&FIEMAP_EXTENT_LAST != 0 {
+				return extents, st.Size(), nil
+			}
+			start = e.Logical + e.Length
+		}
+	}
+}
+
+/*
+lookupExtent returns where logical offset pos is stored in extents, starting
+from extents[*i], and the end of the range stored the same way. Physical
+address is -1 for holes and unwritten extents, which read as zeros. It
+returns false if where it's stored cannot be told.
+*/
+func lookupExtent(extents []extent, i *int, pos int64) (int64, int64, bool) {
+	for *i < len(extents) && extents[*i].logical+extents[*i].length <= pos {
+		*i++
+	}
+	if *i >= len(extents) {
+		return -1, math.MaxInt64, true
+	}
+	ext := extents[*i]
+	if ext.logical > pos {
+		return -1, ext.logical, true
+	}
+	end := ext.logical + ext.length
+	if ext.flags&FIEMAP_EXTENT_UNWRITTEN != 0 {
+		return -1, end, true
+	}
+	if ext.flags&unreliableExtentFlags != 0 {
+		return 0, end, false
+	}
+	return ext.physical + pos - ext.logical, end, true
+}
+
+func hasSharedExtent(extents []extent) bool {
+	for _, ext := range extents {
+		if ext.flags&FIEMAP_EXTENT_SHARED != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ChangedBlocks returns bitmap of blocks of blockSize which may differ between
+file and compareFile, without reading them. Blocks stored in the same
+physical extents of both files, or holes in both, are unchanged. It returns
+ErrNotTracked if the files don't share extents, e.g. filesystem doesn't
+support reflink, since every block with data would be taken as changed.
+*/
+func ChangedBlocks(file, compareFile string, blockSize int64) (*Bitmap, error) {
+	extents, size, err := getExtents(file)
+	if err != nil {
+		return nil, err
+	}
+	compareExtents, compareSize, err := getExtents(compareFile)
+	if err != nil {
+		return nil, err
+	}
+	if !hasSharedExtent(extents) || !hasSharedExtent(compareExtents) {
+		return nil, ErrNotTracked
+	}
+	if compareSize > size {
+		size = compareSize
+	}
+	return diffExtents(extents, compareExtents, size, blockSize), nil
+}
+
+// diffExtents returns bitmap of blocks of files of size not stored the
+// same way in extents and compareExtents
+func diffExtents(extents, compareExtents []extent, size, blockSize int64) *Bitmap {
+	bitmap := NewBitmap(size, blockSize)
+	i, j := 0, 0
+	for pos := int64(0); pos < size; {
+		physical, end, ok := lookupExtent(extents, &i, pos)
+		comparePhysical, compareEnd, compareOK := lookupExtent(compareExtents, &j, pos)
+		if compareEnd < end {
+			end = compareEnd
+		}
+		if end > size {
+			end = size
+		}
+		if !ok || !compareOK || physical != comparePhysical {
+			bitmap.Set(pos, end-pos)
+		}
+		pos = end
+	}
+	return bitmap
+}
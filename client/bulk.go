@@ -0,0 +1,105 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/util"
+)
+
+/*
+Bulk operations select volumes by the same filters as "convoy list" instead of
+names, e.g. "convoy delete --filter name=ci-* --older-than 7d". Selected
+volumes are shown and the user has to confirm before anything is done, unless
+--yes is given, so a filter too broad doesn't wipe out the fleet by accident.
+*/
+
+var (
+	volumeListFilterFields = []string{util.NAME_FILTER, util.DRIVER_FILTER, util.STATE_FILTER}
+
+	confirmFlag = cli.BoolFlag{
+		Name:  "yes, y",
+		Usage: "don't ask for confirmation of volumes selected by --filter or --older-than",
+	}
+)
+
+// addVolumeSelectQuery adds --filter and --older-than of the command to the
+// query of /volumes/list
+func addVolumeSelectQuery(c *cli.Context, v url.Values) error {
+	for _, filter := range c.StringSlice("filter") {
+		if _, err := util.ParseListFilter(filter, volumeListFilterFields); err != nil {
+			return err
+		}
+		v.Add("filter", filter)
+	}
+	if olderThan := c.String("older-than"); olderThan != "" {
+		if _, err := util.ParseDuration(olderThan); err != nil {
+			return err
+		}
+		v.Set("older_than", olderThan)
+	}
+	return nil
+}
+
+func isBulkSelect(c *cli.Context) bool {
+	return len(c.StringSlice("filter")) != 0 || c.String("older-than") != ""
+}
+
+// selectVolumes returns sorted names of volumes matching --filter and
+// --older-than of the command
+func selectVolumes(c *cli.Context) ([]string, error) {
+	v := url.Values{}
+	if err := addVolumeSelectQuery(c, v); err != nil {
+		return nil, err
+	}
+	v.Set("fields", "Name")
+
+	rc, _, _, err := client.clientRequest("GET", "/volumes/list?"+v.Encode(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	volumes := make(map[string]interface{})
+	if err := json.NewDecoder(rc).Decode(&volumes); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// confirmBulk shows the volumes selected for action, e.g. "delete", and asks
+// the user to confirm on stdin unless --yes is given
+func confirmBulk(c *cli.Context, action string, names []string) error {
+	if c.Bool("yes") {
+		return nil
+	}
+	return confirmVolumes(os.Stdin, os.Stderr, action, names)
+}
+
+func confirmVolumes(in io.Reader, out io.Writer, action string, names []string) error {
+	fmt.Fprintf(out, "Going to %v %d volume(s):\n", action, len(names))
+	for _, name := range names {
+		fmt.Fprintln(out, "  "+name)
+	}
+	fmt.Fprint(out, "Continue? [y/N] ")
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(out)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("Aborted, nothing was done. Specify --yes to %v without confirmation", action)
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+// fakeDaemon answers volume list with volumes, and records the queries of
+// the lists and the volumes deleted
+type fakeDaemon struct {
+	volumes []string
+	queries []url.Values
+	deleted []string
+}
+
+func (f *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/volumes/list":
+		f.queries = append(f.queries, r.URL.Query())
+		resp := map[string]map[string]string{}
+		for _, name := range f.volumes {
+			resp[name] = map[string]string{"Name": name}
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "/v1/volumes/":
+		request := &api.VolumeDeleteRequest{}
+		json.NewDecoder(r.Body).Decode(request)
+		f.deleted = append(f.deleted, request.VolumeName)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveFakeDaemon points client at daemon, until the returned func is called
+func serveFakeDaemon(daemon http.Handler) func() {
+	server := httptest.NewServer(daemon)
+	saved := client
+	client = convoyClient{
+		addr:      server.Listener.Addr().String(),
+		scheme:    "http",
+		transport: &http.Transport{},
+	}
+	return func() {
+		client = saved
+		server.Close()
+	}
+}
+
+// commandContext parses args by the flags of cmd, as the CLI does
+func commandContext(c *C, cmd cli.Command, args ...string) *cli.Context {
+	set := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	for _, f := range cmd.Flags {
+		// Values of slice flags are shared by every parse otherwise
+		if slice, ok := f.(cli.StringSliceFlag); ok {
+			slice.Value = &cli.StringSlice{}
+			f = slice
+		}
+		f.Apply(set)
+	}
+	c.Assert(set.Parse(args), IsNil)
+	return cli.NewContext(nil, set, nil)
+}
+
+func (s *TestSuite) TestSelectVolumes(c *C) {
+	f := &fakeDaemon{volumes: []string{"ci-2", "ci-1"}}
+	defer serveFakeDaemon(f)()
+
+	ctx := commandContext(c, volumeDeleteCmd, "--filter", "name=ci-*", "--filter", "driver=vfs", "--older-than", "7d")
+	c.Assert(isBulkSelect(ctx), Equals, true)
+	names, err := selectVolumes(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"ci-1", "ci-2"})
+	c.Assert(f.queries, HasLen, 1)
+	c.Assert(f.queries[0]["filter"], DeepEquals, []string{"name=ci-*", "driver=vfs"})
+	c.Assert(f.queries[0].Get("older_than"), Equals, "7d")
+	c.Assert(f.queries[0].Get("fields"), Equals, "Name")
+
+	// Invalid selections are refused before asking daemon
+	for _, args := range [][]string{
+		{"--filter", "size=1G"},
+		{"--filter", "name"},
+		{"--older-than", "forever"},
+	} {
+		_, err := selectVolumes(commandContext(c, volumeDeleteCmd, args...))
+		c.Assert(err, NotNil, Commentf("%v", args))
+	}
+	c.Assert(f.queries, HasLen, 1)
+
+	c.Assert(isBulkSelect(commandContext(c, volumeDeleteCmd, "vol1")), Equals, false)
+}
+
+func (s *TestSuite) TestConfirmVolumes(c *C) {
+	names := []string{"ci-1", "ci-2"}
+	for answer, confirmed := range map[string]bool{
+		"y\n":   true,
+		"Yes\n": true,
+		"y":     true,
+		"\n":    false,
+		"n\n":   false,
+		"yep\n": false,
+		"":      false,
+	} {
+		out := &bytes.Buffer{}
+		err := confirmVolumes(strings.NewReader(answer), out, "delete", names)
+		c.Assert(err == nil, Equals, confirmed, Commentf("%q", answer))
+		if !confirmed {
+			c.Assert(err, ErrorMatches, "Aborted, nothing was done.*")
+		}
+		c.Assert(out.String(), Matches, "(?s)Going to delete 2 volume\\(s\\):\n  ci-1\n  ci-2\nContinue\\? \\[y/N\\] .*")
+	}
+}
+
+func (s *TestSuite) TestBulkDelete(c *C) {
+	f := &fakeDaemon{volumes: []string{"ci-2", "ci-1"}}
+	defer serveFakeDaemon(f)()
+
+	c.Assert(doVolumeDelete(commandContext(c, volumeDeleteCmd, "--filter", "name=ci-*", "--yes")), IsNil)
+	c.Assert(f.deleted, DeepEquals, []string{"ci-1", "ci-2"})
+
+	// Named volumes and selection don't mix
+	f.deleted = nil
+	err := doVolumeDelete(commandContext(c, volumeDeleteCmd, "--filter", "name=ci-*", "--yes", "prod"))
+	c.Assert(err, ErrorMatches, "Cannot delete named volumes with --filter or --older-than")
+	c.Assert(f.deleted, HasLen, 0)
+
+	// Nothing to confirm if nothing matched
+	f.volumes = nil
+	c.Assert(doVolumeDelete(commandContext(c, volumeDeleteCmd, "--filter", "name=ci-*")), IsNil)
+	c.Assert(f.deleted, HasLen, 0)
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -18,6 +19,7 @@ import (
 type convoyClient struct {
 	addr      string
 	scheme    string
+	token     string
 	transport *http.Transport
 }
 
@@ -51,17 +53,23 @@ func (c *convoyClient) httpClient() *http.Client {
 }
 
 func getRequestPath(path string) string {
-	return fmt.Sprintf("/v1%s", path)
+	return fmt.Sprintf("/v%v%s", api.API_MAJOR_VERSION, path)
 }
 
-func (c *convoyClient) clientRequest(method, path string, in io.Reader, headers map[string][]string) (io.ReadCloser, string, int, error) {
+func (c *convoyClient) clientRequest(method, path string, in io.Reader, headers map[string][]string) (io.ReadCloser, http.Header, int, error) {
 	req, err := http.NewRequest(method, getRequestPath(path), in)
 	if err != nil {
-		return nil, "", -1, err
+		return nil, nil, -1, err
 	}
-	req.Header.Set("User-Agent", "Convoy-Client/"+api.API_VERSION)
+	// Daemons before API version 1.1 only know the major version in user
+	// agent
+	req.Header.Set("User-Agent", "Convoy-Client/"+api.API_MAJOR_VERSION)
+	req.Header.Set(api.API_VERSION_HEADER, api.API_VERSION)
 	req.URL.Host = c.addr
 	req.URL.Scheme = c.scheme
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
 
 	resp, err := c.httpClient().Do(req)
 	statusCode := -1
@@ -69,19 +77,19 @@ func (c *convoyClient) clientRequest(method, path string, in io.Reader, headers
 		statusCode = resp.StatusCode
 	}
 	if err != nil {
-		return nil, "", statusCode, err
+		return nil, nil, statusCode, err
 	}
 	if statusCode < 200 || statusCode >= 400 {
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return nil, "", statusCode, err
+			return nil, nil, statusCode, err
 		}
 		if len(body) == 0 {
-			return nil, "", statusCode, fmt.Errorf("Incompatable version")
+			return nil, nil, statusCode, fmt.Errorf("Incompatable version")
 		}
-		return nil, "", statusCode, fmt.Errorf("Error response from server, %v", string(body))
+		return nil, nil, statusCode, fmt.Errorf("Error response from server, %v", string(body))
 	}
-	return resp.Body, resp.Header.Get("Context-Type"), statusCode, nil
+	return resp.Body, resp.Header, statusCode, nil
 }
 
 func sendRequest(method, request string, data interface{}) (io.ReadCloser, error) {
@@ -107,8 +115,17 @@ func sendRequestAndPrint(method, request string, data interface{}) error {
 	if err != nil {
 		return err
 	}
-	fmt.Println(string(b))
-	return nil
+	return printOutput(b)
+}
+
+// getResponse decodes the JSON response of the request into v
+func getResponse(method, request string, data, v interface{}) error {
+	rc, err := sendRequest(method, request, data)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(rc).Decode(v)
 }
 
 func cmdNotFound(c *cli.Context, command string) {
@@ -128,6 +145,27 @@ func NewCli(version string) *cli.App {
 			Value: "/var/run/convoy/convoy.sock",
 			Usage: "Specify unix domain socket for communication between server and client",
 		},
+		cli.StringFlag{
+			Name:  "host",
+			Usage: "Address of daemon listening with --listen, e.g. convoy.example.com:9410, to communicate over TLS instead of unix domain socket",
+		},
+		cli.StringFlag{
+			Name:   "token",
+			Usage:  "Token authenticating client to daemon of --host with --auth-config",
+			EnvVar: "CONVOY_TOKEN",
+		},
+		cli.StringFlag{
+			Name:  "tls-ca",
+			Usage: "PEM encoded CA certificates to verify daemon of --host, system CAs by default",
+		},
+		cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "PEM encoded client certificate presented to daemon of --host, required if daemon has --tls-client-ca",
+		},
+		cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "PEM encoded private key of --tls-cert",
+		},
 		cli.BoolFlag{
 			Name:  "debug, d",
 			Usage: "Enable debug level log with client or not",
@@ -136,21 +174,43 @@ func NewCli(version string) *cli.App {
 			Name:  "verbose",
 			Usage: "Verbose level output for client, for create volume/snapshot etc",
 		},
+		formatCliFlag,
+		quietCliFlag,
 	}
 	app.CommandNotFound = cmdNotFound
 	app.Before = initClient
-	app.Commands = []cli.Command{
+	app.EnableBashCompletion = true
+	cli.BashCompletionFlag.Usage = "print completions of the command line instead of running it, used by scripts of completion"
+	app.Commands = withCompletion(withOutputFlags([]cli.Command{
 		daemonCmd,
+		recoverCmd,
+		restoreCmd,
 		infoCmd,
+		statsCmd,
+		logLevelCmd,
+		maintenanceCmd,
+		eventsCmd,
+		quotaCmd,
+		auditCmd,
+		leaseCmd,
 		volumeCreateCmd,
+		volumeCloneCmd,
+		volumeExpandCmd,
 		volumeDeleteCmd,
 		volumeMountCmd,
 		volumeUmountCmd,
 		volumeListCmd,
+		volumeDfCmd,
 		volumeInspectCmd,
+		volumeCmd,
 		snapshotCmd,
 		backupCmd,
-	}
+		scheduleCmd,
+		hookCmd,
+		jobCmd,
+		conformanceCmd,
+		completionCmd,
+	}))
 	return app
 }
 
@@ -164,6 +224,25 @@ func initClient(c *cli.Context) error {
 	if debug {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
+	if host := c.GlobalString("host"); host != "" {
+		config, err := util.NewClientTLSConfig(c.GlobalString("tls-ca"),
+			c.GlobalString("tls-cert"), c.GlobalString("tls-key"))
+		if err != nil {
+			return err
+		}
+		client.addr = host
+		client.scheme = "https"
+		client.token = c.GlobalString("token")
+		client.transport = &http.Transport{
+			DisableCompression: true,
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout(network, addr, 10*time.Second)
+			},
+			TLSClientConfig:     config,
+			TLSHandshakeTimeout: 10 * time.Second,
+		}
+		return nil
+	}
 	client.addr = sockFile
 	client.scheme = "http"
 	client.transport = &http.Transport{
@@ -0,0 +1,243 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/logging"
+)
+
+var (
+	completionCmd = cli.Command{
+		Name:   "completion",
+		Usage:  "print script completing commands, and names of volumes, snapshots and backups known to daemon, for shell: completion bash|zsh|fish",
+		Action: cmdCompletion,
+	}
+
+	// Completions of names of arguments in usage of commands
+	argCompleters = map[string]func(c *cli.Context){
+		"<volume>":   completeVolumes,
+		"<snapshot>": completeSnapshots,
+		"<backup>":   completeBackups,
+		"<dest>":     completeDestinations,
+	}
+
+	completionScripts = map[string]string{
+		"bash": `# convoy completion for bash, e.g. in ~/.bashrc:
+#   source <(convoy completion bash)
+_convoy() {
+	local cur words cword
+	if declare -F _get_comp_words_by_ref >/dev/null; then
+		_get_comp_words_by_ref -n =: cur words cword
+	else
+		cur="${COMP_WORDS[COMP_CWORD]}"
+		words=("${COMP_WORDS[@]}")
+		cword=$COMP_CWORD
+	fi
+	local IFS=$'\n'
+	COMPREPLY=($(compgen -W "$("${words[0]}" "${words[@]:1:cword-1}" --generate-bash-completion 2>/dev/null)" -- "$cur"))
+	# URLs of backups have "&"
+	local i
+	for i in "${!COMPREPLY[@]}"; do
+		COMPREPLY[i]=$(printf '%q' "${COMPREPLY[i]}")
+	done
+	if declare -F __ltrim_colon_completions >/dev/null; then
+		__ltrim_colon_completions "$cur"
+	fi
+}
+complete -o default -F _convoy convoy
+`,
+		"zsh": `#compdef convoy
+# convoy completion for zsh, e.g. in ~/.zshrc after compinit:
+#   source <(convoy completion zsh)
+_convoy() {
+	local -a completions
+	completions=("${(@f)$("${words[1]}" "${(@)words[2,CURRENT-1]}" --generate-bash-completion 2>/dev/null)}")
+	completions=(${completions:#})
+	if (( ${#completions} )); then
+		compadd -- "${completions[@]}"
+	else
+		_files
+	fi
+}
+compdef _convoy convoy
+`,
+		"fish": `# convoy completion for fish, e.g.:
+#   convoy completion fish > ~/.config/fish/completions/convoy.fish
+function __convoy_complete
+	set -l tokens (commandline -opc)
+	$tokens[1] $tokens[2..-1] --generate-bash-completion 2>/dev/null
+end
+complete -c convoy -f -a '(__convoy_complete)'
+`,
+	}
+)
+
+func cmdCompletion(c *cli.Context) {
+	if err := doCompletion(c); err != nil {
+		panic(err)
+	}
+}
+
+func doCompletion(c *cli.Context) error {
+	shell := c.Args().First()
+	script, exists := completionScripts[shell]
+	if !exists {
+		return fmt.Errorf("Invalid shell %q, should be bash, zsh or fish", shell)
+	}
+	fmt.Print(script)
+	return nil
+}
+
+/*
+withCompletion completes arguments of commands, and their subcommands, by
+the first argument in their usage, e.g. names of volumes for
+"delete <volume>". Arguments of flags, e.g. "--volume <volume>", are not
+completed.
+*/
+func withCompletion(commands []cli.Command) []cli.Command {
+	for i := range commands {
+		cmd := &commands[i]
+		if len(cmd.Subcommands) != 0 {
+			cmd.Subcommands = withCompletion(cmd.Subcommands)
+			continue
+		}
+		if cmd.BashComplete != nil {
+			continue
+		}
+		cmd.BashComplete = argCompleters[usageArg(cmd.Usage)]
+	}
+	return commands
+}
+
+// usageArg returns the first argument in usage of command, e.g. "<volume>"
+// of "delete a volume: delete <volume> [options]"
+func usageArg(usage string) string {
+	idx := strings.LastIndex(usage, ": ")
+	if idx < 0 {
+		return ""
+	}
+	previous := ""
+	for _, token := range strings.Fields(usage[idx+2:]) {
+		if strings.HasPrefix(token, "<") && !strings.HasPrefix(strings.TrimLeft(previous, "["), "-") {
+			return strings.TrimRight(token, ".,")
+		}
+		previous = token
+	}
+	return ""
+}
+
+// Completions are best effort, nothing is printed if daemon cannot tell
+func getCompletionResponse(path string, data, v interface{}) bool {
+	rc, err := sendRequest("GET", path, data)
+	if err != nil {
+		return false
+	}
+	defer rc.Close()
+	return json.NewDecoder(rc).Decode(v) == nil
+}
+
+func printCompletions(names []string) {
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+func listCompletionVolumes() map[string]api.VolumeResponse {
+	volumes := make(map[string]api.VolumeResponse)
+	if !getCompletionResponse("/volumes/list", nil, &volumes) {
+		return nil
+	}
+	return volumes
+}
+
+func completeVolumes(c *cli.Context) {
+	names := []string{}
+	for name := range listCompletionVolumes() {
+		names = append(names, name)
+	}
+	printCompletions(names)
+}
+
+func completeSnapshots(c *cli.Context) {
+	names := []string{}
+	for _, volume := range listCompletionVolumes() {
+		for name := range volume.Snapshots {
+			names = append(names, name)
+		}
+	}
+	printCompletions(names)
+}
+
+/*
+listCompletionBackups returns backups of volumes recorded in their timelines,
+i.e. created, imported or replicated and not removed since.
+*/
+func listCompletionBackups() []string {
+	backups := make(map[string]bool)
+	for name := range listCompletionVolumes() {
+		timeline := &api.VolumeTimelineResponse{}
+		if !getCompletionResponse("/volumes/timeline", &api.VolumeTimelineRequest{VolumeName: name}, timeline) {
+			continue
+		}
+		for _, event := range timeline.Events {
+			if event.Object != logging.LOG_OBJECT_BACKUP_URL || event.Error != "" || event.Name == "" {
+				continue
+			}
+			switch event.Event {
+			case logging.LOG_EVENT_BACKUP, logging.LOG_EVENT_IMPORT, logging.LOG_EVENT_REPLICATE:
+				backups[event.Name] = true
+			case logging.LOG_EVENT_REMOVE:
+				delete(backups, event.Name)
+			}
+		}
+	}
+	result := []string{}
+	for url := range backups {
+		result = append(result, url)
+	}
+	return result
+}
+
+// listCompletionDestinations returns destinations known to daemon, i.e. the
+// ones of schedules, the ones probed, and the ones of backups
+func listCompletionDestinations(backups []string) []string {
+	dests := make(map[string]bool)
+	info := struct {
+		BackupDestinations map[string]interface{}
+	}{}
+	if getCompletionResponse("/info", nil, &info) {
+		for dest := range info.BackupDestinations {
+			dests[dest] = true
+		}
+	}
+	schedules := make(map[string]api.ScheduleResponse)
+	if getCompletionResponse("/schedules/list", nil, &schedules) {
+		for _, schedule := range schedules {
+			if schedule.URL != "" {
+				dests[schedule.URL] = true
+			}
+		}
+	}
+	for _, url := range backups {
+		dests[strings.SplitN(url, "?", 2)[0]] = true
+	}
+	result := []string{}
+	for dest := range dests {
+		result = append(result, dest)
+	}
+	return result
+}
+
+func completeDestinations(c *cli.Context) {
+	printCompletions(listCompletionDestinations(listCompletionBackups()))
+}
+
+func completeBackups(c *cli.Context) {
+	printCompletions(listCompletionBackups())
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/conformance"
+	"github.com/rancher/convoy/util"
+)
+
+var (
+	conformanceCmd = cli.Command{
+		Name:  "conformance",
+		Usage: "run driver conformance tests without daemon: conformance --driver <driver> [options]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "driver",
+				Usage: "driver to be tested",
+			},
+			cli.StringSliceFlag{
+				Name:  "driver-opts",
+				Value: &cli.StringSlice{},
+				Usage: "options for driver",
+			},
+			cli.StringFlag{
+				Name:  "size",
+				Usage: "size of test volume, in bytes, or end in either G or M or K",
+			},
+			cli.StringFlag{
+				Name:  "dest",
+				Usage: "destination of test backup, backup and restore would be skipped if not specified",
+			},
+			cli.StringFlag{
+				Name:  "data-size",
+				Value: "4M",
+				Usage: "size of random data written to test volume",
+			},
+			cli.IntFlag{
+				Name:  "seed",
+				Usage: "seed of random data, random if not specified",
+			},
+		},
+		Action: cmdConformance,
+	}
+)
+
+func cmdConformance(c *cli.Context) {
+	if err := doConformance(c); err != nil {
+		panic(err)
+	}
+}
+
+func doConformance(c *cli.Context) error {
+	var err error
+
+	driverName, err := util.GetFlag(c, "driver", true, err)
+	dataSize, err := util.GetFlag(c, "data-size", true, err)
+	if err != nil {
+		return err
+	}
+	size, err := getSize(c, err)
+	if err != nil {
+		return err
+	}
+	config := conformance.Config{
+		VolumeSize: size,
+		BackupDest: c.String("dest"),
+		Seed:       int64(c.Int("seed")),
+	}
+	if config.DataSize, err = util.ParseSize(dataSize); err != nil {
+		return err
+	}
+
+	report, err := conformance.VerifyDriver(driverName, util.SliceToMap(c.StringSlice("driver-opts")), config)
+	if err != nil {
+		return err
+	}
+	out, err := api.ResponseOutput(report)
+	if err != nil {
+		return err
+	}
+	if err := printOutput(out); err != nil {
+		return err
+	}
+	if !report.Passed {
+		return fmt.Errorf("Driver %v failed conformance tests, seed %v", driverName, report.Seed)
+	}
+	return nil
+}
@@ -1,12 +1,18 @@
 package client
 
 import (
+	"bufio"
 	"fmt"
 	"io/ioutil"
+	"net/url"
+	"strconv"
 
 	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
 	"github.com/rancher/convoy/client/flags"
 	"github.com/rancher/convoy/daemon"
+	"github.com/rancher/convoy/logging"
+	"github.com/rancher/convoy/util"
 )
 
 var (
@@ -17,11 +23,92 @@ var (
 		Action: cmdStartDaemon,
 	}
 
+	recoverCmd = cli.Command{
+		Name:   "recover",
+		Usage:  "rebuild driver config from state exported to objectstore, daemon must be stopped: recover --driver <driver> --from <dest>",
+		Flags:  flags.RecoverFlags,
+		Action: cmdRecover,
+	}
+
 	infoCmd = cli.Command{
 		Name:   "info",
 		Usage:  "information about convoy",
 		Action: cmdInfo,
 	}
+
+	statsCmd = cli.Command{
+		Name:   "stats",
+		Usage:  "latency percentiles of operations in the sliding window, and their SLOs",
+		Action: cmdStats,
+	}
+
+	logLevelCmd = cli.Command{
+		Name:   "log-level",
+		Usage:  "show log format and levels of daemon, or change levels if specified: log-level [<level>,<pkg>=<level>,...]",
+		Action: cmdLogLevel,
+	}
+
+	maintenanceCmd = cli.Command{
+		Name:  "maintenance",
+		Usage: "show whether daemon is in maintenance, or enter or leave it: maintenance [on|off] [--reason <reason>] [--drain]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "reason",
+				Usage: "why daemon enters maintenance, told to requests refused",
+			},
+			cli.BoolFlag{
+				Name:  "drain",
+				Usage: "release volumes not mounted from the host on entering maintenance, e.g. detach EBS volumes, so other hosts can use them",
+			},
+		},
+		Action: cmdMaintenance,
+	}
+
+	eventsCmd = cli.Command{
+		Name:  "events",
+		Usage: "stream events as JSON, one per line, until interrupted, e.g. failed snapshots and alerts of drivers: events [--volume <volume>]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "volume",
+				Usage: "only events of the volume",
+			},
+		},
+		Action: cmdEvents,
+	}
+
+	quotaCmd = cli.Command{
+		Name:   "quota",
+		Usage:  "show quotas of volumes by driver, set by --quota of daemon, and their usage",
+		Action: cmdQuota,
+	}
+
+	auditCmd = cli.Command{
+		Name:  "audit",
+		Usage: "list requests recorded in audit log of daemon, i.e. the ones changing volumes, backups or settings: audit [--since <time>] [--until <time>] [--volume <volume>] [--caller <caller>] [--limit <n>]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "since",
+				Usage: "only requests at or after the time, a date like 2006-01-02, a time in RFC 3339, or a duration before now like 12h or 7d",
+			},
+			cli.StringFlag{
+				Name:  "until",
+				Usage: "only requests before the time, in the same forms as --since",
+			},
+			cli.StringFlag{
+				Name:  "volume",
+				Usage: "only requests on the volume",
+			},
+			cli.StringFlag{
+				Name:  "caller",
+				Usage: "only requests of the caller, e.g. principal=<name> or uid=<uid>,gid=<gid>,pid=<pid>",
+			},
+			cli.IntFlag{
+				Name:  "limit",
+				Usage: "only the latest number of requests, all if it's 0",
+			},
+		},
+		Action: cmdAudit,
+	}
 )
 
 func cmdInfo(c *cli.Context) {
@@ -41,8 +128,88 @@ func doInfo(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	fmt.Println(string(b))
-	return nil
+	return printOutput(b)
+}
+
+func cmdStats(c *cli.Context) {
+	if err := doStats(c); err != nil {
+		panic(err)
+	}
+}
+
+func doStats(c *cli.Context) error {
+	return sendRequestAndPrint("GET", "/stats", nil)
+}
+
+func cmdLogLevel(c *cli.Context) {
+	if err := doLogLevel(c); err != nil {
+		panic(err)
+	}
+}
+
+func doLogLevel(c *cli.Context) error {
+	levels := c.Args().First()
+	if levels == "" {
+		return sendRequestAndPrint("GET", "/logging", nil)
+	}
+	if _, err := logging.ParseLevels(levels); err != nil {
+		return err
+	}
+	request := &api.LoggingSetRequest{
+		Levels: levels,
+	}
+	return sendRequestAndPrint("POST", "/logging/set", request)
+}
+
+func cmdMaintenance(c *cli.Context) {
+	if err := doMaintenance(c); err != nil {
+		panic(err)
+	}
+}
+
+func doMaintenance(c *cli.Context) error {
+	request := &api.MaintenanceSetRequest{}
+	switch mode := c.Args().First(); mode {
+	case "":
+		return sendRequestAndPrint("GET", "/maintenance", nil)
+	case "on":
+		request.Enabled = true
+		request.Reason = c.String("reason")
+		request.Drain = c.Bool("drain")
+	case "off":
+	default:
+		return fmt.Errorf("Invalid maintenance mode %v, should be on or off", mode)
+	}
+	return sendRequestAndPrint("POST", "/maintenance/set", request)
+}
+
+func cmdEvents(c *cli.Context) {
+	if err := doEvents(c); err != nil {
+		panic(err)
+	}
+}
+
+func doEvents(c *cli.Context) error {
+	v := url.Values{}
+	if volumeName := c.String("volume"); volumeName != "" {
+		if err := util.CheckName(volumeName); err != nil {
+			return err
+		}
+		v.Set("volume", volumeName)
+	}
+	rc, err := sendRequest("GET", "/events?"+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		if err := printOutput(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
 }
 
 func cmdStartDaemon(c *cli.Context) {
@@ -54,3 +221,42 @@ func cmdStartDaemon(c *cli.Context) {
 func startDaemon(c *cli.Context) error {
 	return daemon.Start(client.addr, c)
 }
+
+func cmdRecover(c *cli.Context) {
+	if err := doRecover(c); err != nil {
+		panic(err)
+	}
+}
+
+func doRecover(c *cli.Context) error {
+	return daemon.RecoverDriver(c)
+}
+
+func cmdQuota(c *cli.Context) {
+	if err := doQuota(c); err != nil {
+		panic(err)
+	}
+}
+
+func doQuota(c *cli.Context) error {
+	return sendRequestAndPrint("GET", "/quotas", nil)
+}
+
+func cmdAudit(c *cli.Context) {
+	if err := doAudit(c); err != nil {
+		panic(err)
+	}
+}
+
+func doAudit(c *cli.Context) error {
+	v := url.Values{}
+	for _, name := range []string{"since", "until", "volume", "caller"} {
+		if value := c.String(name); value != "" {
+			v.Set(name, value)
+		}
+	}
+	if limit := c.Int("limit"); limit != 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+	return sendRequestAndPrint("GET", "/audit?"+v.Encode(), nil)
+}
@@ -14,11 +14,69 @@ var (
 			Name:  "log",
 			Usage: "specific output log file, otherwise output to stdout by default",
 		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Usage: "format of logs, text or json. Default to json if --log is specified, text otherwise",
+		},
+		cli.StringFlag{
+			Name:  "log-level",
+			Value: "debug",
+			Usage: "log level, optionally followed by levels of components overriding it, e.g. info,ebs=debug,daemon=warning. Can be changed at runtime by \"convoy log-level\"",
+		},
 		cli.StringFlag{
 			Name:  "root",
 			Value: "/var/lib/rancher/convoy",
 			Usage: "specific root directory of convoy, if configure file exists, daemon specific options would be ignored",
 		},
+		cli.StringFlag{
+			Name:  "metadata-store",
+			Value: "file",
+			Usage: "Store of metadata of daemon, drivers and volumes: file for JSON files in root, or etcd to keep them in etcd, so they can be recovered on another host",
+		},
+		cli.StringSliceFlag{
+			Name:  "metadata-store-endpoints",
+			Value: &cli.StringSlice{},
+			Usage: "Endpoints of etcd for --metadata-store etcd, e.g. https://10.0.0.1:2379",
+		},
+		cli.StringFlag{
+			Name:  "metadata-store-prefix",
+			Usage: "Prefix of keys of metadata in etcd, which must be different for each daemon. Default to /convoy/<hostname>. Start daemon on another host with the prefix and root of a failed host to recover its volumes",
+		},
+		cli.StringFlag{
+			Name:  "metadata-store-username",
+			Usage: "Username of etcd, if etcd authentication is enabled",
+		},
+		cli.StringFlag{
+			Name:  "metadata-store-password",
+			Usage: "Password of --metadata-store-username as file:<path> or env:<name>",
+		},
+		cli.StringFlag{
+			Name:  "metadata-store-ca-cert",
+			Usage: "PEM encoded CA certificates to verify https endpoints of etcd, instead of the system's",
+		},
+		cli.StringFlag{
+			Name:  "metadata-store-cert",
+			Usage: "PEM encoded client certificate presented to etcd, if it requires client certificates",
+		},
+		cli.StringFlag{
+			Name:  "metadata-store-key",
+			Usage: "PEM encoded private key of --metadata-store-cert",
+		},
+		cli.StringSliceFlag{
+			Name:  "coordination-drivers",
+			Value: &cli.StringSlice{},
+			Usage: "Drivers whose volumes are on storage shared with other daemons, e.g. glusterfs, so a volume can only be mounted or changed by one daemon at a time, by leases in etcd of --metadata-store",
+		},
+		cli.StringFlag{
+			Name:  "coordination-prefix",
+			Value: "/convoy/leases",
+			Usage: "Prefix of keys of leases in etcd, which must be the same for daemons sharing storage",
+		},
+		cli.StringFlag{
+			Name:  "coordination-ttl",
+			Value: "60s",
+			Usage: "Time after which volumes held by a daemon gone, e.g. with its host down, are released for other daemons. It should cover restarts of daemon",
+		},
 		cli.StringSliceFlag{
 			Name:  "drivers",
 			Value: &cli.StringSlice{},
@@ -45,9 +103,340 @@ var (
 			Name:  "cmd-timeout",
 			Usage: "Set timeout value for executing each command. One minute (1m) by default and at least one minute.",
 		},
+		cli.IntFlag{
+			Name:  "health-check-interval",
+			Value: 30,
+			Usage: "Interval in seconds to probe health of drivers' backends. Operations would fail fast while a driver is degraded. 0 to disable",
+		},
+		cli.StringFlag{
+			Name:  "scheduler",
+			Value: "internal",
+			Usage: "Run schedules by daemon (internal), or only through schedule run from external scheduler (external), e.g. ones exported by schedule export",
+		},
+		cli.StringFlag{
+			Name:  "schedule-catchup-stagger",
+			Value: "1m",
+			Usage: "Delay between starts of catch-up runs of schedules missed while daemon was down, so they won't run at once. 0 to start them together",
+		},
+		cli.StringFlag{
+			Name:  "schedule-jitter",
+			Value: "0",
+			Usage: "Maximum random delay of each scheduled run, so schedules due at the same time, e.g. on the hour, won't start at once. 0 to disable",
+		},
+		cli.IntFlag{
+			Name:  "schedule-concurrency",
+			Value: 1,
+			Usage: "Number of schedules run by daemon at the same time, the rest wait for free slots",
+		},
+		cli.IntFlag{
+			Name:  "max-concurrent-ops",
+			Value: 8,
+			Usage: "Number of snapshots, backups and restores run by daemon at the same time, the rest wait for free slots. Operations on different volumes don't block each other otherwise",
+		},
+		cli.IntFlag{
+			Name:  "job-concurrency",
+			Value: 4,
+			Usage: "Number of jobs, i.e. operations requested with --async, run at the same time, the rest stay pending and can be cancelled",
+		},
+		cli.StringFlag{
+			Name:  "config-file",
+			Usage: "YAML file of settings overriding their options, reloaded on SIGHUP without restarting daemon: logLevel, scheduleJitter, quotas, rateLimits and driverOpts changing defaults of new volumes",
+		},
+		cli.StringSliceFlag{
+			Name:  "quota",
+			Value: &cli.StringSlice{},
+			Usage: "Quota of volumes created by a driver as <driver>.<limit>=<value>, e.g. ebs.total-size=10T. Limits are volumes for the number of volumes, total-size for their total size, and volume-size for size of each volume",
+		},
+		cli.StringSliceFlag{
+			Name:  "rate-limit",
+			Value: &cli.StringSlice{},
+			Usage: "Rate limit of API requests as [<client>=]<rate>[:<burst>] in requests per second, e.g. 10:20. Without client it applies to every client, otherwise overrides it for the client: principal=<name>, uid=<uid> of socket peer, docker-plugin, csi, or IP address. Rate 0 means unlimited",
+		},
+		cli.StringFlag{
+			Name:  "audit-log",
+			Usage: "File recording every request changing volumes, backups or daemon settings, with its caller, parameters and outcome. Default to audit.log in root",
+		},
+		cli.StringFlag{
+			Name:  "tracing-endpoint",
+			Usage: "OTLP/HTTP endpoint spans of API requests, driver operations and backup transfers are exported to, e.g. http://localhost:4318/v1/traces. Tracing is disabled by default",
+		},
+		cli.StringSliceFlag{
+			Name:  "tracing-header",
+			Value: &cli.StringSlice{},
+			Usage: "Header of export to tracing endpoint as <name>=<value>, e.g. for API keys. Value can be file:<path> or env:<name> for secrets",
+		},
+		cli.StringFlag{
+			Name:  "tracing-ca-cert",
+			Usage: "PEM encoded CA certificates to verify https tracing endpoint, instead of the system's",
+		},
+		cli.Float64Flag{
+			Name:  "tracing-sample-ratio",
+			Value: 1,
+			Usage: "Ratio of traces started by daemon which are exported, between 0 and 1. Requests with traceparent header follow the decisions of their callers",
+		},
+		cli.StringFlag{
+			Name:  "tracing-service-name",
+			Value: "convoy",
+			Usage: "Service name of spans exported",
+		},
+		cli.StringFlag{
+			Name:  "selinux-label",
+			Usage: "Default SELinux label of mounts by drivers supporting it, so volumes can be used by containers on enforcing hosts: z for shared by containers, or explicit context. Can be overridden by mount",
+		},
+		cli.StringFlag{
+			Name:  "latency-window",
+			Value: "1h",
+			Usage: "Sliding window of operation latencies shown by stats and metrics",
+		},
+		cli.StringSliceFlag{
+			Name:  "latency-slo",
+			Value: &cli.StringSlice{},
+			Usage: "SLO of operation latency as <operation>.p<percentile>=<duration>, e.g. mount.p99=10s. Warning event would be generated when breached. Operations are create, attach, mount, snapshot and backup, percentiles are p50, p95 and p99",
+		},
+		cli.StringSliceFlag{
+			Name:  "backup-dest-group",
+			Value: &cli.StringSlice{},
+			Usage: "Group of backup destinations as <name>=<url>[,<url>...], volumes backed up to group://<name> would be sharded across them by consistent hashing of volume names",
+		},
+		cli.StringSliceFlag{
+			Name:  "backup-encryption",
+			Value: &cli.StringSlice{},
+			Usage: "Client-side encryption of everything written to a backup destination as <url>=key:<secret> or <url>=passphrase:<secret>, where secret is file:<path> or env:<name>, and key is base64 encoded 32 bytes",
+		},
+		cli.StringSliceFlag{
+			Name:  "backup-compression",
+			Value: &cli.StringSlice{},
+			Usage: "Compression of blocks uploaded by incremental backups as <compression> for all destinations, or <url>=<compression> for a destination. Compression is none, gzip, gzip-<1-9>, lz4, zstd or zstd-<1-22>, gzip by default",
+		},
+		cli.StringSliceFlag{
+			Name:  "backup-block-pool",
+			Value: &cli.StringSlice{},
+			Usage: "Destination whose incremental backups store blocks in a pool shared by all volumes in it, so identical blocks of different volumes are stored once",
+		},
+		cli.StringSliceFlag{
+			Name:  "backup-retention",
+			Value: &cli.StringSlice{},
+			Usage: "Retention of backups pruned by backup prune and after scheduled backups as <policy> for all destinations, or <url>=<policy> for a destination. Policy is e.g. last=7,daily=14,weekly=8,monthly=12. Backups are kept forever by default",
+		},
+		cli.StringSliceFlag{
+			Name:  "backup-mirror",
+			Value: &cli.StringSlice{},
+			Usage: "Mirror backups created in a destination to another one as <url>=<mirror-url>, e.g. to a bucket in another region for disaster recovery",
+		},
+		cli.StringFlag{
+			Name:  "s3-sse",
+			Usage: "Server-side encryption of objects written to S3 destinations: sse-s3, sse-kms or sse-c. Empty to use the default encryption of the bucket",
+		},
+		cli.StringFlag{
+			Name:  "s3-sse-kms-key-id",
+			Usage: "KMS key ID or ARN used by sse-kms, the default key of S3 in KMS if empty",
+		},
+		cli.StringFlag{
+			Name:  "s3-sse-customer-key",
+			Usage: "Base64 encoded 256-bit key used by sse-c as file:<path> or env:<name>, required to restore the backups as well",
+		},
+		cli.StringFlag{
+			Name:  "s3-acl",
+			Usage: "Canned ACL of objects written to S3 destinations, e.g. bucket-owner-full-control",
+		},
+		cli.StringFlag{
+			Name:  "s3-object-lock",
+			Usage: "S3 Object Lock mode of objects written to S3 destinations: governance or compliance, along with --s3-object-lock-period. Empty to use the default retention of the bucket",
+		},
+		cli.StringFlag{
+			Name:  "s3-object-lock-period",
+			Usage: "Period objects written to S3 destinations are locked for after they're written, e.g. 30d. Backups are kept by backup delete and prune until then",
+		},
+		cli.StringSliceFlag{
+			Name:  "s3-storage-class",
+			Value: &cli.StringSlice{},
+			Usage: "S3 storage class of blocks and files of backups uploaded to a S3 destination as <url>=<class>, e.g. STANDARD_IA or GLACIER_IR, configs are kept in STANDARD. Can be specified multiple times",
+		},
+		cli.StringFlag{
+			Name:  "s3-part-size",
+			Value: "8M",
+			Usage: "Objects larger than it would be uploaded to S3 destinations in parts of the size, each retried on its own. At least 5M",
+		},
+		cli.IntFlag{
+			Name:  "s3-upload-concurrency",
+			Value: 4,
+			Usage: "Parts of an object uploaded to S3 destinations at the same time",
+		},
+		cli.StringFlag{
+			Name:  "s3-endpoint",
+			Usage: "Endpoint of S3 compatible service used by S3 destinations instead of AWS, e.g. https://minio.example.com:9000",
+		},
+		cli.StringFlag{
+			Name:  "s3-ca-cert",
+			Usage: "PEM bundle of CA certificates trusted for S3 endpoints in addition to the system's",
+		},
+		cli.StringFlag{
+			Name:  "s3-client-cert",
+			Usage: "PEM client certificate presented to S3 endpoints requiring mutual TLS, along with --s3-client-key",
+		},
+		cli.StringFlag{
+			Name:  "s3-client-key",
+			Usage: "PEM private key of --s3-client-cert",
+		},
+		cli.BoolFlag{
+			Name:  "s3-insecure-skip-verify",
+			Usage: "INSECURE: accept any certificate of S3 endpoints. Only meant for testing",
+		},
+		cli.StringFlag{
+			Name:  "inventory-url",
+			Usage: "HTTP(S) endpoint of inventory service, daemon would POST its volumes, driver capacity and health to it periodically. Empty to disable",
+		},
+		cli.StringFlag{
+			Name:  "inventory-interval",
+			Value: "5m",
+			Usage: "Interval of reports to inventory service",
+		},
+		cli.StringFlag{
+			Name:  "inventory-token",
+			Usage: "Bearer token for inventory service as file:<path> or env:<name>, read before every report",
+		},
+		cli.StringFlag{
+			Name:  "encryption-key-dir",
+			Usage: "Directory of encryption keys of volumes, the key of --encryption-key <id> is the content of file <id> in it",
+		},
+		cli.StringFlag{
+			Name:  "encryption-key-hook",
+			Usage: "Executable printing encryption keys of volumes, called with key ID and volume name for keys not in --encryption-key-dir, e.g. to fetch them from external KMS",
+		},
+		cli.StringFlag{
+			Name:  "hooks-dir",
+			Value: "/etc/convoy/hooks.d",
+			Usage: "Directory of site hooks, executables run on volume lifecycle events with the event as JSON on stdin",
+		},
+		cli.StringFlag{
+			Name:  "hooks-timeout",
+			Value: "30s",
+			Usage: "Time each site hook can run before it's killed and treated as failed",
+		},
+		cli.StringFlag{
+			Name:  "hooks-on-failure",
+			Value: "continue",
+			Usage: "If a site hook fails before create, delete, mount or umount of a volume, abort the operation, or continue with it",
+		},
+		cli.StringSliceFlag{
+			Name:  "webhook",
+			Value: &cli.StringSlice{},
+			Usage: "URL to POST every event to as JSON, e.g. failed snapshots and thin pool alerts, for monitoring. Can be specified multiple times",
+		},
+		cli.StringFlag{
+			Name:  "webhook-timeout",
+			Value: "10s",
+			Usage: "Time each delivery to a webhook can take before it's retried",
+		},
+		cli.StringFlag{
+			Name:  "backup-block-size",
+			Value: "2M",
+			Usage: "Block size of incremental backups of volumes without their own, power of 2 between 64K and 64M. Smaller blocks upload less for random writes, larger blocks mean fewer objects",
+		},
+		cli.IntFlag{
+			Name:  "backup-verify-percent",
+			Usage: "Percentage of blocks uploaded by incremental backups to be read back and verified against their checksums, so corruption is caught at backup time. 0 to disable",
+		},
+		cli.IntFlag{
+			Name:  "backup-concurrency",
+			Value: 4,
+			Usage: "Blocks uploaded by an incremental backup, or downloaded by a restore, at the same time, between 1 and 64",
+		},
+		cli.IntFlag{
+			Name:  "backup-dest-probe-interval",
+			Value: 60,
+			Usage: "Interval in seconds to probe backup destinations of schedules and destination groups by writing a canary object. 0 to disable",
+		},
+		cli.IntFlag{
+			Name:  "backup-dest-probe-failures",
+			Value: 3,
+			Usage: "Consecutive failed probes before a backup destination is taken as unavailable, and backups to it fail fast until a probe succeeds",
+		},
+		cli.StringFlag{
+			Name:  "canary-restore-interval",
+			Usage: "Interval of canary restores, e.g. 7d. Each time a sample of backups made by schedules within the interval is restored into temporary volumes and verified. Empty to disable",
+		},
+		cli.IntFlag{
+			Name:  "canary-restore-samples",
+			Value: 1,
+			Usage: "Number of backups sampled by each canary restore",
+		},
+		cli.StringFlag{
+			Name:  "scrub-interval",
+			Usage: "Interval of scrubs, e.g. 7d. Each time blocks of backups in destinations of schedules and destination groups are read and verified against their checksums. Empty to disable",
+		},
+		cli.IntFlag{
+			Name:  "scrub-percent",
+			Value: 100,
+			Usage: "Percentage of blocks in each destination verified by each scrub, sampled at random",
+		},
+		cli.StringFlag{
+			Name:  "plugin-socket",
+			Usage: "Unix domain socket Docker connects to when running as Docker managed plugin, e.g. /run/docker/plugins/convoy.sock, besides the daemon socket",
+		},
+		cli.StringFlag{
+			Name:  "propagated-mount",
+			Usage: "propagatedMount directory of Docker managed plugin, which root directory must be within. Docker mounts of volumes mounted outside it are refused, since Docker cannot see them",
+		},
+		cli.StringFlag{
+			Name:  "csi-socket",
+			Usage: "Unix domain socket to serve CSI, e.g. /var/lib/kubelet/plugins/convoy.rancher.io/csi.sock, so Kubernetes can consume volumes of Convoy. Empty to disable",
+		},
+		cli.StringFlag{
+			Name:  "csi-node-id",
+			Usage: "Node ID reported to CSI, hostname by default. Volumes are only accessible from the node they're created on",
+		},
+		cli.StringFlag{
+			Name:  "listen",
+			Usage: "TCP address to serve the API over TLS besides the unix domain socket, e.g. :9410, for remote management. Requires --tls-cert and --tls-key",
+		},
+		cli.StringFlag{
+			Name:  "grpc-listen",
+			Usage: "TCP address to serve the gRPC API over TLS, e.g. :9411. Requires --tls-cert and --tls-key",
+		},
+		cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "PEM encoded certificate of the daemon served by --listen and --grpc-listen",
+		},
+		cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "PEM encoded private key of --tls-cert",
+		},
+		cli.StringFlag{
+			Name:  "tls-client-ca",
+			Usage: "PEM encoded CA certificates clients of --listen and --grpc-listen must present certificates signed by, i.e. mutual TLS. Strongly recommended, since any client reaching the address can manage volumes otherwise",
+		},
+		cli.StringFlag{
+			Name:  "auth-config",
+			Usage: "YAML or JSON file of principals allowed to use the APIs of --listen and --grpc-listen, by token or client certificate, with role readonly or admin, optionally limited to volumes with prefixes",
+		},
+		cli.StringFlag{
+			Name:  "metrics-listen",
+			Usage: "TCP address to serve metrics in Prometheus text format, e.g. :9412, besides the daemon socket. Empty to disable",
+		},
 		cli.BoolFlag{
 			Name:  "ignore-config-file",
 			Usage: "Avoid loading the existing config file when starting daemon, and use the command line options instead (not including driver options)",
 		},
 	}
+
+	RecoverFlags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "root",
+			Value: "/var/lib/rancher/convoy",
+			Usage: "specific root directory of convoy to be recovered",
+		},
+		cli.StringFlag{
+			Name:  "driver",
+			Usage: "driver to be recovered",
+		},
+		cli.StringFlag{
+			Name:  "from",
+			Usage: "objectstore where driver exported its state, would be url like s3://bucket@region/path/, gcs://bucket/path/ or vfs:///path/",
+		},
+		cli.StringFlag{
+			Name:  "host",
+			Usage: "hostname of the host to be recovered, current hostname by default",
+		},
+	}
 )
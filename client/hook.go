@@ -0,0 +1,118 @@
+package client
+
+import (
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+var (
+	hookSetCmd = cli.Command{
+		Name:  "set",
+		Usage: "run executables before and after snapshots of a volume are taken: set <volume>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "pre-snapshot",
+				Usage: "absolute path of executable to run before snapshot, e.g. to flush and lock the database",
+			},
+			cli.StringFlag{
+				Name:  "post-snapshot",
+				Usage: "absolute path of executable to run after snapshot, even if it failed, e.g. to unlock the database",
+			},
+			cli.StringFlag{
+				Name:  "timeout",
+				Value: "30s",
+				Usage: "time each hook can run before it's killed and treated as failed",
+			},
+			cli.StringFlag{
+				Name:  "on-failure",
+				Value: "abort",
+				Usage: "if pre-snapshot hook fails, abort the snapshot, or continue taking it without application consistency",
+			},
+		},
+		Action: cmdHookSet,
+	}
+
+	hookListCmd = cli.Command{
+		Name:   "list",
+		Usage:  "list snapshot hooks of volumes",
+		Action: cmdHookList,
+	}
+
+	hookDeleteCmd = cli.Command{
+		Name:   "delete",
+		Usage:  "delete snapshot hooks of a volume: delete <volume>",
+		Action: cmdHookDelete,
+	}
+
+	hookCmd = cli.Command{
+		Name:  "hook",
+		Usage: "snapshot hook related operations",
+		Subcommands: []cli.Command{
+			hookSetCmd,
+			hookListCmd,
+			hookDeleteCmd,
+		},
+	}
+)
+
+func cmdHookSet(c *cli.Context) {
+	if err := doHookSet(c); err != nil {
+		panic(err)
+	}
+}
+
+func doHookSet(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "", true)
+	preSnapshot, err := util.GetFlag(c, "pre-snapshot", false, err)
+	postSnapshot, err := util.GetFlag(c, "post-snapshot", false, err)
+	timeout, err := util.GetFlag(c, "timeout", false, err)
+	onFailure, err := util.GetFlag(c, "on-failure", false, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.HookSetRequest{
+		VolumeName:   volumeName,
+		PreSnapshot:  preSnapshot,
+		PostSnapshot: postSnapshot,
+		Timeout:      timeout,
+		OnFailure:    onFailure,
+	}
+	url := "/hooks/set"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdHookList(c *cli.Context) {
+	if err := doHookList(c); err != nil {
+		panic(err)
+	}
+}
+
+func doHookList(c *cli.Context) error {
+	url := "/hooks/list"
+	return sendRequestAndPrint("GET", url, nil)
+}
+
+func cmdHookDelete(c *cli.Context) {
+	if err := doHookDelete(c); err != nil {
+		panic(err)
+	}
+}
+
+func doHookDelete(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.HookDeleteRequest{
+		VolumeName: volumeName,
+	}
+	url := "/hooks"
+	return sendRequestAndPrint("DELETE", url, request)
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+)
+
+var (
+	jobListCmd = cli.Command{
+		Name:   "list",
+		Usage:  "list jobs of operations requested with --async, including the ones finished in last 7 days",
+		Action: cmdJobList,
+	}
+
+	jobInspectCmd = cli.Command{
+		Name:   "inspect",
+		Usage:  "inspect state and result of a job: inspect <job>",
+		Action: cmdJobInspect,
+	}
+
+	jobCancelCmd = cli.Command{
+		Name:   "cancel",
+		Usage:  "cancel a pending job: cancel <job>",
+		Action: cmdJobCancel,
+	}
+
+	jobCmd = cli.Command{
+		Name:  "job",
+		Usage: "job related operations",
+		Subcommands: []cli.Command{
+			jobListCmd,
+			jobInspectCmd,
+			jobCancelCmd,
+		},
+	}
+)
+
+func cmdJobList(c *cli.Context) {
+	if err := doJobList(c); err != nil {
+		panic(err)
+	}
+}
+
+func doJobList(c *cli.Context) error {
+	url := "/jobs/list"
+	return sendRequestAndPrint("GET", url, nil)
+}
+
+func cmdJobInspect(c *cli.Context) {
+	if err := doJobInspect(c); err != nil {
+		panic(err)
+	}
+}
+
+func doJobInspect(c *cli.Context) error {
+	var err error
+
+	id, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.JobInspectRequest{
+		ID: id,
+	}
+	url := "/jobs/"
+	return sendRequestAndPrint("GET", url, request)
+}
+
+func cmdJobCancel(c *cli.Context) {
+	if err := doJobCancel(c); err != nil {
+		panic(err)
+	}
+}
+
+func doJobCancel(c *cli.Context) error {
+	var err error
+
+	id, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.JobCancelRequest{
+		ID: id,
+	}
+	url := "/jobs/cancel"
+	return sendRequestAndPrint("POST", url, request)
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+)
+
+var (
+	leaseListCmd = cli.Command{
+		Name:   "list",
+		Usage:  "list daemons holding volumes coordinated by --coordination-drivers of daemon",
+		Action: cmdLeaseList,
+	}
+
+	leaseBreakCmd = cli.Command{
+		Name:  "break",
+		Usage: "release a volume held by another daemon known to be down, without waiting for its lease to expire: break <volume>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "holder",
+				Usage: "break the lease only if the volume is held by the daemon",
+			},
+		},
+		Action: cmdLeaseBreak,
+	}
+
+	leaseCmd = cli.Command{
+		Name:  "lease",
+		Usage: "leases of volumes coordinated with other daemons",
+		Subcommands: []cli.Command{
+			leaseListCmd,
+			leaseBreakCmd,
+		},
+	}
+)
+
+func cmdLeaseList(c *cli.Context) {
+	if err := doLeaseList(c); err != nil {
+		panic(err)
+	}
+}
+
+func doLeaseList(c *cli.Context) error {
+	return sendRequestAndPrint("GET", "/leases", nil)
+}
+
+func cmdLeaseBreak(c *cli.Context) {
+	if err := doLeaseBreak(c); err != nil {
+		panic(err)
+	}
+}
+
+func doLeaseBreak(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.LeaseBreakRequest{
+		VolumeName: volumeName,
+		Holder:     c.String("holder"),
+	}
+	return sendRequestAndPrint("POST", "/leases/break", request)
+}
@@ -1,8 +1,16 @@
 package client
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
 	"github.com/codegangsta/cli"
 	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/logging"
 	"github.com/rancher/convoy/util"
 )
 
@@ -13,7 +21,16 @@ var (
 		Flags: []cli.Flag{
 			cli.StringFlag{
 				Name:  "dest",
-				Usage: "destination of backup if driver supports, would be url like s3://bucket@region/path/ or vfs:///path/",
+				Usage: "destination of backup if driver supports, would be url like s3://bucket@region/path/, gcs://bucket/path/ or vfs:///path/",
+			},
+			cli.StringSliceFlag{
+				Name:  "label",
+				Value: &cli.StringSlice{},
+				Usage: "label of backup as <key>=<value>, e.g. release=v1.2, can be specified multiple times",
+			},
+			cli.BoolFlag{
+				Name:  "async",
+				Usage: "return a job at once instead of waiting for the backup to be created, see \"convoy job\"",
 			},
 		},
 		Action: cmdBackupCreate,
@@ -33,6 +50,20 @@ var (
 				Name:  "volume-name",
 				Usage: "name of volume",
 			},
+			cli.StringSliceFlag{
+				Name:  "filter",
+				Value: &cli.StringSlice{},
+				Usage: "only list backups with label, as label=<key> or label=<key>=<value>, can be specified multiple times to match all",
+			},
+			cli.StringFlag{
+				Name:  "since",
+				Usage: "only list backups created since, as a date like 2006-01-02, a time like 2006-01-02T15:04:05Z07:00, or a duration ago like 7d",
+			},
+			cli.StringFlag{
+				Name:  "until",
+				Usage: "only list backups created before, in the same forms as --since. A date includes the whole day",
+			},
+			watchFlag,
 		},
 		Action: cmdBackupList,
 	}
@@ -43,6 +74,129 @@ var (
 		Action: cmdBackupInspect,
 	}
 
+	backupIndexRefreshCmd = cli.Command{
+		Name:   "refresh",
+		Usage:  "load backups added or removed since last refresh into local index: refresh <dest>",
+		Action: cmdBackupIndexRefresh,
+	}
+
+	backupIndexRebuildCmd = cli.Command{
+		Name:   "rebuild",
+		Usage:  "discard local index and build it again from objectstore: rebuild <dest>",
+		Action: cmdBackupIndexRebuild,
+	}
+
+	backupIndexCmd = cli.Command{
+		Name:  "index",
+		Usage: "local index of backups in objectstore, used by list and inspect",
+		Subcommands: []cli.Command{
+			backupIndexRefreshCmd,
+			backupIndexRebuildCmd,
+		},
+	}
+
+	backupPruneCmd = cli.Command{
+		Name:  "prune",
+		Usage: "delete backups expired by retention and blocks no longer referenced: prune <dest>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "volume-name",
+				Usage: "name of volume, all volumes in dest with retention if not specified",
+			},
+			cli.StringFlag{
+				Name:  "retention",
+				Usage: "retention overriding the configured ones, e.g. last=7,daily=14,weekly=8,monthly=12",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only list backups would be pruned",
+			},
+		},
+		Action: cmdBackupPrune,
+	}
+
+	backupReplicateCmd = cli.Command{
+		Name:  "replicate",
+		Usage: "copy a backup to another destination without restoring it: replicate <backup>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "dest",
+				Usage: "destination the backup would be copied to, e.g. s3://bucket@region/path/",
+			},
+		},
+		Action: cmdBackupReplicate,
+	}
+
+	backupExportCmd = cli.Command{
+		Name:  "export",
+		Usage: "export a backup as a tar archive, for transfer to destinations without network access: export <backup>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "file the archive would be written to, stdout if not specified",
+			},
+		},
+		Action: cmdBackupExport,
+	}
+
+	backupImportCmd = cli.Command{
+		Name:  "import",
+		Usage: "import a backup exported as a tar archive into objectstore: import <dest>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "input, i",
+				Usage: "file the archive would be read from, stdin if not specified",
+			},
+		},
+		Action: cmdBackupImport,
+	}
+
+	backupMigrateCmd = cli.Command{
+		Name:  "migrate",
+		Usage: "upgrade configs of volumes and backups in objectstore to the current format: migrate <dest>",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only count configs would be migrated",
+			},
+		},
+		Action: cmdBackupMigrate,
+	}
+
+	backupRotateKeyCmd = cli.Command{
+		Name:  "rotate-key",
+		Usage: "encrypt backups to an encrypted destination with a new key from now on, keeping earlier keys wrapped with it: rotate-key <dest>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "key",
+				Usage: "new key as key:<secret> or passphrase:<secret>, where secret is file:<path> or env:<name> read by daemon",
+			},
+		},
+		Action: cmdBackupRotateKey,
+	}
+
+	backupMountCmd = cli.Command{
+		Name:  "mount",
+		Usage: "restore a backup into a temporary volume mounted read-only, to copy files out of it: mount <backup>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "driver",
+				Usage: "driver of the temporary volume, the one of the backed up volume or the default driver if not specified",
+			},
+			cli.StringFlag{
+				Name:  "mountpoint",
+				Usage: "mountpoint of the temporary volume. If not specified, it would be mounted to default directory",
+			},
+		},
+		Action: cmdBackupMount,
+	}
+
+	backupUmountCmd = cli.Command{
+		Name:   "umount",
+		Usage:  "umount a backup mounted and delete its temporary volume: umount <volume>",
+		Action: cmdBackupUmount,
+	}
+
 	backupCmd = cli.Command{
 		Name:  "backup",
 		Usage: "backup related operations",
@@ -51,6 +205,15 @@ var (
 			backupDeleteCmd,
 			backupListCmd,
 			backupInspectCmd,
+			backupIndexCmd,
+			backupPruneCmd,
+			backupReplicateCmd,
+			backupExportCmd,
+			backupImportCmd,
+			backupMigrateCmd,
+			backupRotateKeyCmd,
+			backupMountCmd,
+			backupUmountCmd,
 		},
 	}
 )
@@ -69,12 +232,29 @@ func doBackupList(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	for _, filter := range c.StringSlice("filter") {
+		if _, err := util.ParseLabelFilter(filter); err != nil {
+			return err
+		}
+	}
 
 	request := &api.BackupListRequest{
 		URL:        destURL,
 		VolumeName: volumeName,
+		Filters:    c.StringSlice("filter"),
+		Since:      c.String("since"),
+		Until:      c.String("until"),
 	}
 	url := "/backups/list"
+	if c.Bool("watch") {
+		// Backups created, deleted, imported or replicated are all events
+		// of backup URLs
+		return watchList(volumeName, func(event *api.Event) bool {
+			return event.Object == logging.LOG_OBJECT_BACKUP_URL
+		}, func() error {
+			return sendRequestAndPrint("GET", url, request)
+		})
+	}
 	return sendRequestAndPrint("GET", url, request)
 }
 
@@ -99,6 +279,268 @@ func doBackupInspect(c *cli.Context) error {
 	return sendRequestAndPrint("GET", url, request)
 }
 
+func cmdBackupIndexRefresh(c *cli.Context) {
+	if err := doBackupIndexRefresh(c, false); err != nil {
+		panic(err)
+	}
+}
+
+func cmdBackupIndexRebuild(c *cli.Context) {
+	if err := doBackupIndexRefresh(c, true); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupIndexRefresh(c *cli.Context, rebuild bool) error {
+	var err error
+
+	destURL, err := util.GetFlag(c, "", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupIndexRequest{
+		URL:     destURL,
+		Rebuild: rebuild,
+	}
+	url := "/backups/index"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupPrune(c *cli.Context) {
+	if err := doBackupPrune(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupPrune(c *cli.Context) error {
+	var err error
+
+	destURL, err := util.GetFlag(c, "", true, err)
+	volumeName, err := util.GetName(c, "volume-name", false, err)
+	retention, err := util.GetFlag(c, "retention", false, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupPruneRequest{
+		URL:        destURL,
+		VolumeName: volumeName,
+		Retention:  retention,
+		DryRun:     c.Bool("dry-run"),
+	}
+	url := "/backups/prune"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupMigrate(c *cli.Context) {
+	if err := doBackupMigrate(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupMigrate(c *cli.Context) error {
+	var err error
+
+	destURL, err := util.GetFlag(c, "", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupMigrateRequest{
+		URL:    destURL,
+		DryRun: c.Bool("dry-run"),
+	}
+	url := "/backups/migrate"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupRotateKey(c *cli.Context) {
+	if err := doBackupRotateKey(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupRotateKey(c *cli.Context) error {
+	var err error
+
+	destURL, err := util.GetFlag(c, "", true, err)
+	key, err := util.GetFlag(c, "key", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupRotateKeyRequest{
+		URL: destURL,
+		Key: key,
+	}
+	url := "/backups/rotate-key"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupMount(c *cli.Context) {
+	if err := doBackupMount(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupMount(c *cli.Context) error {
+	var err error
+
+	backupURL, err := util.GetFlag(c, "", true, err)
+	driverName, err := util.GetFlag(c, "driver", false, err)
+	mountPoint, err := util.GetFlag(c, "mountpoint", false, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupMountRequest{
+		URL:        backupURL,
+		DriverName: driverName,
+		MountPoint: mountPoint,
+		Verbose:    verboseOutput(c),
+	}
+	url := "/backups/mount"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupUmount(c *cli.Context) {
+	if err := doBackupUmount(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupUmount(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupUmountRequest{
+		VolumeName: volumeName,
+	}
+	url := "/backups/umount"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupReplicate(c *cli.Context) {
+	if err := doBackupReplicate(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupReplicate(c *cli.Context) error {
+	var err error
+
+	backupURL, err := util.GetFlag(c, "", true, err)
+	destURL, err := util.GetFlag(c, "dest", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupReplicateRequest{
+		URL:     backupURL,
+		DestURL: destURL,
+		Verbose: verboseOutput(c),
+	}
+	url := "/backups/replicate"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupExport(c *cli.Context) {
+	if err := doBackupExport(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupExport(c *cli.Context) error {
+	var err error
+
+	backupURL, err := util.GetFlag(c, "", true, err)
+	output, err := util.GetFlag(c, "output", false, err)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		if st, err := os.Stdout.Stat(); err == nil && st.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("Refuse to write archive to terminal, redirect stdout or specify --output")
+		}
+	}
+
+	request := &api.BackupExportRequest{
+		URL: backupURL,
+	}
+	rc, err := sendRequest("GET", "/backups/export", request)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if output == "" {
+		_, err = io.Copy(os.Stdout, rc)
+		return err
+	}
+	// Write to a temporary file first, so an interrupted export won't be
+	// left as the archive
+	f, err := ioutil.TempFile(filepath.Dir(output), "."+filepath.Base(output)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, rc)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), output)
+}
+
+func cmdBackupImport(c *cli.Context) {
+	if err := doBackupImport(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupImport(c *cli.Context) error {
+	var err error
+
+	destURL, err := util.GetFlag(c, "", true, err)
+	input, err := util.GetFlag(c, "input", false, err)
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if input != "" {
+		if in, err = os.Open(input); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	// Archive is the body, so parameters are passed in query
+	params := url.Values{}
+	params.Set("dest", destURL)
+	if verboseOutput(c) {
+		params.Set("verbose", "true")
+	}
+	rc, _, _, err := client.clientRequest("POST", "/backups/import?"+params.Encode(), in, nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	return printOutput(b)
+}
+
 func cmdBackupCreate(c *cli.Context) {
 	if err := doBackupCreate(c); err != nil {
 		panic(err)
@@ -117,11 +559,17 @@ func doBackupCreate(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	labels, err := util.ParseLabels(c.StringSlice("label"))
+	if err != nil {
+		return err
+	}
 
 	request := &api.BackupCreateRequest{
 		URL:          destURL,
 		SnapshotName: snapshotName,
-		Verbose:      c.GlobalBool(verboseFlag),
+		Labels:       labels,
+		Async:        c.Bool("async"),
+		Verbose:      verboseOutput(c),
 	}
 
 	url := "/backups/create"
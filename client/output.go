@@ -0,0 +1,220 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	OUTPUT_FORMAT_JSON = "json"
+	OUTPUT_FORMAT_YAML = "yaml"
+
+	formatFlag = "format"
+	quietFlag  = "quiet"
+)
+
+var (
+	formatCliFlag = cli.StringFlag{
+		Name:  formatFlag,
+		Usage: "output format: json, yaml, or a Go template applied to the response, e.g. '{{.Name}}'",
+	}
+	quietCliFlag = cli.BoolFlag{
+		Name:  quietFlag + ", q",
+		Usage: "only output names of volumes, snapshots and other objects, or URLs of backups, one per line",
+	}
+
+	// Keys identifying objects in responses, by precedence
+	outputIDKeys = []string{"ID", "Name", "URL", "BackupURL"}
+
+	output outputOptions
+)
+
+type outputOptions struct {
+	format   string
+	quiet    bool
+	template *template.Template
+}
+
+/*
+withOutputFlags adds --format and --quiet to commands, and their
+subcommands, so they can be specified after the command as well as before it,
+e.g. "convoy list -q". Commands having flags of the same names, e.g.
+"schedule export --format", only take the global ones.
+*/
+func withOutputFlags(commands []cli.Command) []cli.Command {
+	for i := range commands {
+		cmd := &commands[i]
+		if len(cmd.Subcommands) != 0 {
+			cmd.Subcommands = withOutputFlags(cmd.Subcommands)
+			continue
+		}
+		if cmd.Action == nil || cmd.Name == "daemon" || cmd.Name == "completion" {
+			continue
+		}
+		localFormat, localQuiet := !hasFlag(cmd.Flags, formatFlag), !hasFlag(cmd.Flags, quietFlag)
+		if localFormat {
+			cmd.Flags = append(cmd.Flags, formatCliFlag)
+		}
+		if localQuiet {
+			cmd.Flags = append(cmd.Flags, quietCliFlag)
+		}
+		action := cmd.Action
+		cmd.Action = func(c *cli.Context) {
+			if err := initOutput(c, localFormat, localQuiet); err != nil {
+				panic(err)
+			}
+			action(c)
+		}
+	}
+	return commands
+}
+
+func hasFlag(flags []cli.Flag, name string) bool {
+	for _, flag := range flags {
+		for _, flagName := range strings.Split(flag.GetName(), ",") {
+			if strings.TrimSpace(flagName) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func initOutput(c *cli.Context, localFormat, localQuiet bool) error {
+	format := c.GlobalString(formatFlag)
+	if localFormat && c.String(formatFlag) != "" {
+		format = c.String(formatFlag)
+	}
+	quiet := c.GlobalBool(quietFlag) || (localQuiet && c.Bool(quietFlag))
+	if quiet && format != "" {
+		return fmt.Errorf("--quiet and --format cannot be used together")
+	}
+	output = outputOptions{
+		format: format,
+		quiet:  quiet,
+	}
+	switch format {
+	case "", OUTPUT_FORMAT_JSON, OUTPUT_FORMAT_YAML:
+	default:
+		tmpl, err := template.New(formatFlag).Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+			"join": strings.Join,
+		}).Parse(format)
+		if err != nil {
+			return fmt.Errorf("Invalid --format template: %v", err)
+		}
+		output.template = tmpl
+	}
+	return nil
+}
+
+// verboseOutput tells whether daemon should answer with the objects, e.g.
+// of volume created rather than its name, which is needed by --format
+func verboseOutput(c *cli.Context) bool {
+	return c.GlobalBool(verboseFlag) || output.format != ""
+}
+
+/*
+printOutput prints response of daemon as specified by --format or --quiet,
+or as it is without them. Responses which aren't JSON, e.g. names of objects
+created without --verbose, are printed as they are.
+*/
+func printOutput(b []byte) error {
+	if output.format == "" && !output.quiet {
+		fmt.Println(string(b))
+		return nil
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		fmt.Println(strings.TrimSpace(string(b)))
+		return nil
+	}
+
+	if output.quiet {
+		for _, id := range outputIDs(value) {
+			fmt.Println(id)
+		}
+		return nil
+	}
+	switch output.format {
+	case OUTPUT_FORMAT_JSON:
+		out, err := json.MarshalIndent(value, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	case OUTPUT_FORMAT_YAML:
+		out, err := util.EncodeYAML(value)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(out))
+	default:
+		out := &bytes.Buffer{}
+		if err := output.template.Execute(out, value); err != nil {
+			return err
+		}
+		// Templates ranging over lists usually end lines by themselves
+		if !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+			out.WriteString("\n")
+		}
+		fmt.Print(out.String())
+	}
+	return nil
+}
+
+/*
+outputIDs returns what identifies the objects of response value: the ID, name
+or URL of a single object, of each object in a list, or the keys of a
+collection, e.g. names of volumes listed.
+*/
+func outputIDs(value interface{}) []string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if id, ok := outputID(v); ok {
+			return []string{id}
+		}
+		ids := []string{}
+		for key := range v {
+			ids = append(ids, key)
+		}
+		sort.Strings(ids)
+		return ids
+	case []interface{}:
+		ids := []string{}
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				if id, ok := outputID(m); ok {
+					ids = append(ids, id)
+				}
+				continue
+			}
+			ids = append(ids, fmt.Sprint(item))
+		}
+		return ids
+	case nil:
+		return []string{}
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+func outputID(object map[string]interface{}) (string, bool) {
+	for _, key := range outputIDKeys {
+		if id, ok := object[key].(string); ok && id != "" {
+			return id, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+var (
+	restoreCmd = cli.Command{
+		Name:  "restore",
+		Usage: "create volumes from backups as listed in a manifest: restore -f <manifest>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "file, f",
+				Usage: "restore manifest in YAML or JSON format",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only validate the manifest and show volumes would be restored on this host",
+			},
+		},
+		Action: cmdRestore,
+	}
+)
+
+// restoreManifest maps source backups to new volumes, e.g. for cloning an
+// environment. Volumes with Host specified would only be restored on the host
+// with the same hostname, so the same manifest can be applied on every host.
+type restoreManifest struct {
+	Volumes []restoreManifestVolume
+}
+
+type restoreManifestVolume struct {
+	Name   string
+	Backup string
+	Size   string
+	Driver string
+	Host   string
+	Type   string
+	IOPS   string
+
+	EncryptionKey   string
+	KmsKeyID        string
+	BackupBlockSize string
+	FsFreeze        string
+	Labels          map[string]string
+}
+
+func loadRestoreManifest(file string) (*restoreManifest, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &restoreManifest{}
+	if err := util.DecodeYAML(data, manifest); err != nil {
+		return nil, fmt.Errorf("Invalid restore manifest %v: %v", file, err)
+	}
+	return manifest, nil
+}
+
+func (v *restoreManifestVolume) toCreateRequest() (*api.VolumeCreateRequest, error) {
+	size, err := util.ParseSize(v.Size)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid size %v of volume %v: %v", v.Size, v.Name, err)
+	}
+	var iops int64
+	if v.IOPS != "" {
+		if iops, err = strconv.ParseInt(v.IOPS, 10, 64); err != nil {
+			return nil, fmt.Errorf("Invalid IOPS %v of volume %v: %v", v.IOPS, v.Name, err)
+		}
+	}
+	return &api.VolumeCreateRequest{
+		Name:            v.Name,
+		DriverName:      v.Driver,
+		Size:            size,
+		BackupURL:       v.Backup,
+		Type:            v.Type,
+		IOPS:            iops,
+		EncryptionKey:   v.EncryptionKey,
+		KmsKeyID:        v.KmsKeyID,
+		BackupBlockSize: v.BackupBlockSize,
+		FsFreeze:        v.FsFreeze,
+		Labels:          v.Labels,
+	}, nil
+}
+
+func cmdRestore(c *cli.Context) {
+	if err := doRestore(c); err != nil {
+		panic(err)
+	}
+}
+
+func doRestore(c *cli.Context) error {
+	var err error
+
+	file, err := util.GetFlag(c, "file", true, err)
+	if err != nil {
+		return err
+	}
+	manifest, err := loadRestoreManifest(file)
+	if err != nil {
+		return err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	request := &api.VolumeRestoreRequest{
+		Volumes: []api.VolumeCreateRequest{},
+		DryRun:  c.Bool("dry-run"),
+	}
+	for _, volume := range manifest.Volumes {
+		if volume.Host != "" && volume.Host != hostname {
+			log.Debugf("Skip volume %v for host %v", volume.Name, volume.Host)
+			continue
+		}
+		req, err := volume.toCreateRequest()
+		if err != nil {
+			return err
+		}
+		request.Volumes = append(request.Volumes, *req)
+	}
+	if len(request.Volumes) == 0 {
+		return fmt.Errorf("No volume in manifest %v is for host %v", file, hostname)
+	}
+
+	url := "/volumes/restore"
+	return sendRequestAndPrint("POST", url, request)
+}
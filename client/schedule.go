@@ -0,0 +1,245 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+var (
+	scheduleSetCmd = cli.Command{
+		Name:  "set",
+		Usage: "snapshot a volume periodically, and back up the snapshot if dest is specified: set <volume>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "interval",
+				Usage: "interval between snapshots, e.g. 30m, 12h or 1d. At least one minute",
+			},
+			cli.StringFlag{
+				Name:  "cron",
+				Usage: "cron expression of snapshots in local time of daemon instead of interval, e.g. \"30 2 * * *\" or @daily",
+			},
+			cli.StringFlag{
+				Name:  "dest",
+				Usage: "destination of backups if driver supports, would be url like s3://bucket@region/path/, gcs://bucket/path/ or vfs:///path/",
+			},
+			cli.StringFlag{
+				Name:  "retention",
+				Usage: "retention of backups in objectstore pruned after each scheduled backup, e.g. last=7,daily=14,weekly=8,monthly=12. Default to retention of dest",
+			},
+		},
+		Action: cmdScheduleSet,
+	}
+
+	scheduleListCmd = cli.Command{
+		Name:   "list",
+		Usage:  "list schedules of volumes",
+		Action: cmdScheduleList,
+	}
+
+	scheduleDeleteCmd = cli.Command{
+		Name:   "delete",
+		Usage:  "delete schedule of a volume: delete <volume>",
+		Action: cmdScheduleDelete,
+	}
+
+	scheduleRunCmd = cli.Command{
+		Name:   "run",
+		Usage:  "run schedule of a volume now, e.g. from external scheduler: run <volume>",
+		Action: cmdScheduleRun,
+	}
+
+	scheduleExportCmd = cli.Command{
+		Name:  "export",
+		Usage: "export schedules as systemd timers or Kubernetes CronJobs which call schedule run: export --format <format> [volume...]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "format",
+				Usage: "format of exported schedules, systemd or cronjob",
+			},
+			cli.StringFlag{
+				Name:  "binary",
+				Usage: "convoy binary called by exported schedules, default to this binary for systemd, or \"convoy\" for cronjob",
+			},
+			cli.StringFlag{
+				Name:  "image",
+				Usage: "image containing convoy binary, required for cronjob",
+			},
+			cli.StringFlag{
+				Name:  "namespace",
+				Usage: "namespace of CronJobs",
+			},
+			cli.StringFlag{
+				Name:  "node",
+				Usage: "node CronJobs run on, default to hostname of daemon",
+			},
+			cli.StringFlag{
+				Name:  "dir",
+				Usage: "directory exported files would be written to, rather than printed",
+			},
+		},
+		Action: cmdScheduleExport,
+	}
+
+	scheduleCmd = cli.Command{
+		Name:  "schedule",
+		Usage: "schedule related operations",
+		Subcommands: []cli.Command{
+			scheduleSetCmd,
+			scheduleListCmd,
+			scheduleDeleteCmd,
+			scheduleRunCmd,
+			scheduleExportCmd,
+		},
+	}
+)
+
+func cmdScheduleSet(c *cli.Context) {
+	if err := doScheduleSet(c); err != nil {
+		panic(err)
+	}
+}
+
+func doScheduleSet(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "", true)
+	interval, err := util.GetFlag(c, "interval", false, err)
+	cron, err := util.GetFlag(c, "cron", false, err)
+	destURL, err := util.GetFlag(c, "dest", false, err)
+	retention, err := util.GetFlag(c, "retention", false, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.ScheduleSetRequest{
+		VolumeName: volumeName,
+		Interval:   interval,
+		Cron:       cron,
+		URL:        destURL,
+		Retention:  retention,
+	}
+	url := "/schedules/set"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdScheduleList(c *cli.Context) {
+	if err := doScheduleList(c); err != nil {
+		panic(err)
+	}
+}
+
+func doScheduleList(c *cli.Context) error {
+	url := "/schedules/list"
+	return sendRequestAndPrint("GET", url, nil)
+}
+
+func cmdScheduleDelete(c *cli.Context) {
+	if err := doScheduleDelete(c); err != nil {
+		panic(err)
+	}
+}
+
+func doScheduleDelete(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.ScheduleDeleteRequest{
+		VolumeName: volumeName,
+	}
+	url := "/schedules"
+	return sendRequestAndPrint("DELETE", url, request)
+}
+
+func cmdScheduleRun(c *cli.Context) {
+	if err := doScheduleRun(c); err != nil {
+		panic(err)
+	}
+}
+
+func doScheduleRun(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.ScheduleRunRequest{
+		VolumeName: volumeName,
+	}
+	url := "/schedules/run"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdScheduleExport(c *cli.Context) {
+	if err := doScheduleExport(c); err != nil {
+		panic(err)
+	}
+}
+
+func doScheduleExport(c *cli.Context) error {
+	var err error
+
+	format, err := util.GetFlag(c, "format", true, err)
+	binary, err := util.GetFlag(c, "binary", false, err)
+	if err != nil {
+		return err
+	}
+	if binary == "" {
+		binary = "convoy"
+		if format == "systemd" {
+			if binary, err = os.Executable(); err != nil {
+				return err
+			}
+		}
+	}
+	socket, err := filepath.Abs(c.GlobalString("socket"))
+	if err != nil {
+		return err
+	}
+
+	request := &api.ScheduleExportRequest{
+		Format:      format,
+		VolumeNames: c.Args(),
+		Binary:      binary,
+		Socket:      socket,
+		Image:       c.String("image"),
+		Namespace:   c.String("namespace"),
+		Node:        c.String("node"),
+	}
+	url := "/schedules/export"
+	rc, err := sendRequest("POST", url, request)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	resp := &api.ScheduleExportResponse{}
+	if err := json.NewDecoder(rc).Decode(resp); err != nil {
+		return err
+	}
+	dir := c.String("dir")
+	for _, file := range resp.Files {
+		if dir == "" {
+			fmt.Printf("# %v\n%v\n", file.Name, file.Content)
+			continue
+		}
+		path := filepath.Join(dir, file.Name)
+		if err := ioutil.WriteFile(path, []byte(file.Content), 0644); err != nil {
+			return err
+		}
+		fmt.Println(path)
+	}
+	return nil
+}
@@ -1,6 +1,10 @@
 package client
 
 import (
+	"fmt"
+	"net/url"
+	"os"
+
 	"github.com/codegangsta/cli"
 	"github.com/rancher/convoy/api"
 	"github.com/rancher/convoy/util"
@@ -9,12 +13,43 @@ import (
 var (
 	snapshotCreateCmd = cli.Command{
 		Name:  "create",
-		Usage: "create a snapshot for certain volume: snapshot create <volume>",
+		Usage: "create a snapshot for certain volume: snapshot create <volume>, or snapshot create --all [--filter <filter>] for many",
 		Flags: []cli.Flag{
 			cli.StringFlag{
 				Name:  "name",
 				Usage: "name of snapshot",
 			},
+			cli.StringFlag{
+				Name:  "kms-key-id",
+				Usage: "KMS key ID the snapshot would be encrypted with if driver supports",
+			},
+			cli.BoolFlag{
+				Name:  "fsfreeze",
+				Usage: "freeze filesystem of mounted volume while taking the snapshot if driver supports, even if driver doesn't by default",
+			},
+			cli.StringSliceFlag{
+				Name:  "label",
+				Value: &cli.StringSlice{},
+				Usage: "label of snapshot as <key>=<value>, e.g. reason=pre-upgrade, can be specified multiple times",
+			},
+			cli.BoolFlag{
+				Name:  "async",
+				Usage: "return a job at once instead of waiting for the snapshot to be taken, see \"convoy job\"",
+			},
+			cli.BoolFlag{
+				Name:  "all",
+				Usage: "snapshot every volume instead of the named one, or the ones selected by --filter and --older-than",
+			},
+			cli.StringSliceFlag{
+				Name:  "filter",
+				Value: &cli.StringSlice{},
+				Usage: "with --all, only snapshot volumes matching filter, as filter of \"convoy list\", can be specified multiple times to match all",
+			},
+			cli.StringFlag{
+				Name:  "older-than",
+				Usage: "with --all, only snapshot volumes created before the duration ago, e.g. 12h, 7d or 2w",
+			},
+			confirmFlag,
 		},
 		Action: cmdSnapshotCreate,
 	}
@@ -31,6 +66,19 @@ var (
 		Action: cmdSnapshotInspect,
 	}
 
+	snapshotListCmd = cli.Command{
+		Name:  "list",
+		Usage: "list snapshots of all volumes, or of the volume: snapshot list [--volume <volume>]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "volume",
+				Usage: "only list snapshots of the volume",
+			},
+			watchFlag,
+		},
+		Action: cmdSnapshotList,
+	}
+
 	snapshotCmd = cli.Command{
 		Name:  "snapshot",
 		Usage: "snapshot related operations",
@@ -38,6 +86,7 @@ var (
 			snapshotCreateCmd,
 			snapshotDeleteCmd,
 			snapshotInspectCmd,
+			snapshotListCmd,
 		},
 	}
 )
@@ -49,6 +98,13 @@ func cmdSnapshotCreate(c *cli.Context) {
 }
 
 func doSnapshotCreate(c *cli.Context) error {
+	if c.Bool("all") {
+		return doSnapshotCreateAll(c)
+	}
+	if isBulkSelect(c) {
+		return fmt.Errorf("--filter and --older-than select volumes for --all only")
+	}
+
 	var err error
 
 	volumeName, err := getName(c, "", true)
@@ -56,11 +112,19 @@ func doSnapshotCreate(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	labels, err := util.ParseLabels(c.StringSlice("label"))
+	if err != nil {
+		return err
+	}
 
 	request := &api.SnapshotCreateRequest{
 		Name:       snapshotName,
 		VolumeName: volumeName,
-		Verbose:    c.GlobalBool(verboseFlag),
+		KmsKeyID:   c.String("kms-key-id"),
+		FsFreeze:   c.Bool("fsfreeze"),
+		Labels:     labels,
+		Async:      c.Bool("async"),
+		Verbose:    verboseOutput(c),
 	}
 
 	url := "/snapshots/create"
@@ -68,6 +132,49 @@ func doSnapshotCreate(c *cli.Context) error {
 	return sendRequestAndPrint("POST", url, request)
 }
 
+// doSnapshotCreateAll snapshots every volume selected, with snapshot names
+// generated by daemon. Failures are reported per volume, without stopping
+// snapshots of the rest.
+func doSnapshotCreateAll(c *cli.Context) error {
+	if len(c.Args()) != 0 {
+		return fmt.Errorf("Cannot snapshot the named volume with --all")
+	}
+	if c.String("name") != "" {
+		return fmt.Errorf("Cannot name snapshots of --all, since snapshot names are unique")
+	}
+	labels, err := util.ParseLabels(c.StringSlice("label"))
+	if err != nil {
+		return err
+	}
+	names, err := selectVolumes(c)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(os.Stderr, "No volume matched")
+		return nil
+	}
+	if err := confirmBulk(c, "snapshot", names); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		request := &api.SnapshotCreateRequest{
+			VolumeName: name,
+			KmsKeyID:   c.String("kms-key-id"),
+			FsFreeze:   c.Bool("fsfreeze"),
+			Labels:     labels,
+			Async:      c.Bool("async"),
+			Verbose:    verboseOutput(c),
+		}
+		if reqErr := sendRequestAndPrint("POST", "/snapshots/create", request); reqErr != nil {
+			err = reqErr
+			fmt.Println("Error creating snapshot of " + name + ": " + reqErr.Error())
+		}
+	}
+	return err
+}
+
 func cmdSnapshotDelete(c *cli.Context) {
 	if err := doSnapshotDelete(c); err != nil {
 		panic(err)
@@ -108,3 +215,60 @@ func doSnapshotInspect(c *cli.Context) error {
 	url := "/snapshots/"
 	return sendRequestAndPrint("GET", url, request)
 }
+
+func cmdSnapshotList(c *cli.Context) {
+	if err := doSnapshotList(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotList(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "volume", false)
+	if err != nil {
+		return err
+	}
+	if c.Bool("watch") {
+		return watchList(volumeName, func(event *api.Event) bool {
+			return event.VolumeName != ""
+		}, func() error {
+			return listSnapshots(volumeName)
+		})
+	}
+	return listSnapshots(volumeName)
+}
+
+// listSnapshots prints snapshots of volumes by name, with names of their
+// volumes, as snapshots of volumes listed or inspected
+func listSnapshots(volumeName string) error {
+	volumes := make(map[string]api.VolumeResponse)
+	if volumeName != "" {
+		volume := api.VolumeResponse{}
+		if err := getResponse("GET", "/volumes/", &api.VolumeInspectRequest{
+			VolumeName: volumeName,
+		}, &volume); err != nil {
+			return err
+		}
+		volumes[volumeName] = volume
+	} else {
+		v := url.Values{}
+		v.Set("fields", "Name,Snapshots")
+		if err := getResponse("GET", "/volumes/list?"+v.Encode(), nil, &volumes); err != nil {
+			return err
+		}
+	}
+
+	snapshots := make(map[string]api.SnapshotResponse)
+	for name, volume := range volumes {
+		for snapshotName, snapshot := range volume.Snapshots {
+			snapshot.VolumeName = name
+			snapshots[snapshotName] = snapshot
+		}
+	}
+	b, err := api.ResponseOutput(snapshots)
+	if err != nil {
+		return err
+	}
+	return printOutput(b)
+}
@@ -2,7 +2,10 @@ package client
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"strconv"
 
 	"github.com/codegangsta/cli"
 	"github.com/rancher/convoy/api"
@@ -42,18 +45,94 @@ var (
 				Name:  "vm",
 				Usage: "Prepare volume for Rancher VM if driver supports",
 			},
+			cli.StringFlag{
+				Name:  "fs",
+				Usage: "filesystem to format the volume with if driver supports, ext4, xfs or btrfs. Driver's default would be used if not specified",
+			},
+			cli.StringFlag{
+				Name:  "mkfs-opts",
+				Usage: "extra options passed to mkfs when formatting the volume if driver supports, e.g. \"-E lazy_itable_init=0 -I 512\"",
+			},
+			cli.StringFlag{
+				Name:  "mount-opts",
+				Usage: "comma separated options used every time the volume is mounted if driver supports, e.g. noatime,discard",
+			},
+			cli.StringFlag{
+				Name:  "encryption-key",
+				Usage: "encrypt the volume with LUKS if driver supports, using the key of the ID from daemon's --encryption-key-dir or --encryption-key-hook",
+			},
+			cli.StringFlag{
+				Name:  "kms-key-id",
+				Usage: "KMS key ID the volume would be encrypted with if driver supports, including volume restored from backup",
+			},
+			cli.StringFlag{
+				Name:  "backup-block-size",
+				Usage: "block size of incremental backups of the volume if driver supports, e.g. 256k, overriding --backup-block-size of daemon",
+			},
+			cli.StringFlag{
+				Name:  "fsfreeze",
+				Usage: "true or false, whether to freeze filesystem of mounted volume while each of its snapshots is started if driver supports, overriding driver's default",
+			},
+			cli.StringSliceFlag{
+				Name:  "label",
+				Value: &cli.StringSlice{},
+				Usage: "label of volume as <key>=<value>, e.g. team=payments, can be specified multiple times",
+			},
+			cli.BoolFlag{
+				Name:  "async",
+				Usage: "return a job at once instead of waiting for the volume to be created, e.g. restored from backup, see \"convoy job\"",
+			},
 		},
 		Action: cmdVolumeCreate,
 	}
 
+	volumeCloneCmd = cli.Command{
+		Name:  "clone",
+		Usage: "create a new volume as an independent copy of a volume, by the same driver: clone <volume> <volume_name> [options]",
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "label",
+				Value: &cli.StringSlice{},
+				Usage: "label of the new volume as <key>=<value>, labels of the volume cloned are not copied, can be specified multiple times",
+			},
+			cli.BoolFlag{
+				Name:  "async",
+				Usage: "return a job at once instead of waiting for the volume to be cloned, see \"convoy job\"",
+			},
+		},
+		Action: cmdVolumeClone,
+	}
+
+	volumeExpandCmd = cli.Command{
+		Name:  "expand",
+		Usage: "expand a volume and grow its filesystem if driver supports: expand <volume> --size <size>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "size",
+				Usage: "new size of volume, larger than the current one, in bytes, or end in either G or M or K",
+			},
+		},
+		Action: cmdVolumeExpand,
+	}
+
 	volumeDeleteCmd = cli.Command{
 		Name:  "delete",
-		Usage: "delete a volume: delete <volume> [options]",
+		Usage: "delete volumes: delete <volume>... or delete --filter <filter> [options]",
 		Flags: []cli.Flag{
 			cli.BoolFlag{
 				Name:  "reference, r",
 				Usage: "only delete the reference of volume if driver supports",
 			},
+			cli.StringSliceFlag{
+				Name:  "filter",
+				Value: &cli.StringSlice{},
+				Usage: "delete volumes matching filter instead of named ones, as filter of \"convoy list\", can be specified multiple times to match all",
+			},
+			cli.StringFlag{
+				Name:  "older-than",
+				Usage: "delete volumes created before the duration ago instead of named ones, e.g. 12h, 7d or 2w",
+			},
+			confirmFlag,
 		},
 		Action: cmdVolumeDelete,
 	}
@@ -66,6 +145,18 @@ var (
 				Name:  "mountpoint",
 				Usage: "mountpoint of volume. If not specified, it would be automatic mounted to default directory",
 			},
+			cli.BoolFlag{
+				Name:  "read-only",
+				Usage: "mount volume read-only if driver supports",
+			},
+			cli.StringFlag{
+				Name:  "subpath",
+				Usage: "directory within the volume to return instead of its mountpoint, would be created if absent",
+			},
+			cli.StringFlag{
+				Name:  "selinux-label",
+				Usage: "label filesystem with SELinux context if driver supports: z for shared by containers, none, or explicit context like system_u:object_r:svirt_sandbox_file_t:s0:c1,c2",
+			},
 		},
 		Action: cmdVolumeMount,
 	}
@@ -76,16 +167,72 @@ var (
 		Action: cmdVolumeUmount,
 	}
 
+	volumeListFlags = []cli.Flag{
+		cli.BoolFlag{
+			Name:  "driver",
+			Usage: "Ask for driver specific info of volumes and snapshots",
+		},
+		cli.StringFlag{
+			Name:  "idle-for",
+			Usage: "only list volumes haven't been mounted or doing I/O for the duration, e.g. 12h, 30d or 2w",
+		},
+		cli.StringFlag{
+			Name:  "older-than",
+			Usage: "only list volumes created before the duration ago, e.g. 12h, 7d or 2w",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter",
+			Value: &cli.StringSlice{},
+			Usage: "only list volumes matching filter, as name=<glob>, driver=<driver>, state=<creating|detached|attaching|mounted|backing-up|error|deleting>, label=<key> or label=<key>=<value>, can be specified multiple times to match all",
+		},
+		cli.IntFlag{
+			Name:  "limit",
+			Usage: "list at most the number of volumes sorted by name, the rest can be listed with --after",
+		},
+		cli.StringFlag{
+			Name:  "after",
+			Usage: "only list volumes whose names sort after it, e.g. the last one of previous page",
+		},
+		cli.BoolFlag{
+			Name:  "brief",
+			Usage: "only list name, driver, state, mount point and labels of volumes, without asking driver, which is much faster",
+		},
+		cli.StringFlag{
+			Name:  "fields",
+			Usage: "comma separated fields of volumes to list, e.g. Name,State,Labels",
+		},
+		watchFlag,
+	}
+
 	volumeListCmd = cli.Command{
-		Name:  "list",
-		Usage: "list all managed volumes",
+		Name:   "list",
+		Usage:  "list all managed volumes",
+		Flags:  volumeListFlags,
+		Action: cmdVolumeList,
+	}
+
+	volumeLsCmd = cli.Command{
+		Name:   "ls",
+		Usage:  "list all managed volumes",
+		Flags:  volumeListFlags,
+		Action: cmdVolumeList,
+	}
+
+	volumeDfCmd = cli.Command{
+		Name:  "df",
+		Usage: "show size and space actually used of volumes, and whether they're mounted: df [<volume>...] [options]",
 		Flags: []cli.Flag{
-			cli.BoolFlag{
-				Name:  "driver",
-				Usage: "Ask for driver specific info of volumes and snapshots",
+			cli.StringSliceFlag{
+				Name:  "filter",
+				Value: &cli.StringSlice{},
+				Usage: "only show volumes matching filter, as filter of \"convoy list\", can be specified multiple times to match all",
+			},
+			cli.StringFlag{
+				Name:  "older-than",
+				Usage: "only show volumes created before the duration ago, e.g. 12h, 7d or 2w",
 			},
 		},
-		Action: cmdVolumeList,
+		Action: cmdVolumeDf,
 	}
 
 	volumeInspectCmd = cli.Command{
@@ -93,6 +240,27 @@ var (
 		Usage:  "inspect a certain volume: inspect <volume>",
 		Action: cmdVolumeInspect,
 	}
+
+	volumeTimelineCmd = cli.Command{
+		Name:  "timeline",
+		Usage: "show snapshots, backups and events of a volume in chronological order: timeline <volume>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "dest",
+				Usage: "also list backups of the volume at destination, would be url like s3://bucket@region/path/, gcs://bucket/path/ or vfs:///path/",
+			},
+		},
+		Action: cmdVolumeTimeline,
+	}
+
+	volumeCmd = cli.Command{
+		Name:  "volume",
+		Usage: "volume related operations",
+		Subcommands: []cli.Command{
+			volumeLsCmd,
+			volumeTimelineCmd,
+		},
+	}
 )
 
 func cmdVolumeCreate(c *cli.Context) {
@@ -124,17 +292,46 @@ func doVolumeCreate(c *cli.Context) error {
 	volumeType := c.String("type")
 	iops := c.Int("iops")
 	prepareForVM := c.Bool("vm")
+	fsType := c.String("fs")
+	if fsType != "" {
+		if err := util.CheckFilesystem(fsType); err != nil {
+			return err
+		}
+	}
+	mountOpts := c.String("mount-opts")
+	if err := util.CheckMountOptions(mountOpts); err != nil {
+		return err
+	}
+	encryptionKey := c.String("encryption-key")
+	if encryptionKey != "" {
+		if err := util.CheckEncryptionKey(encryptionKey); err != nil {
+			return err
+		}
+	}
+	labels, err := util.ParseLabels(c.StringSlice("label"))
+	if err != nil {
+		return err
+	}
 
 	request := &api.VolumeCreateRequest{
-		Name:           name,
-		DriverName:     driverName,
-		Size:           size,
-		BackupURL:      backupURL,
-		DriverVolumeID: driverVolumeID,
-		Type:           volumeType,
-		IOPS:           int64(iops),
-		PrepareForVM:   prepareForVM,
-		Verbose:        c.GlobalBool(verboseFlag),
+		Name:            name,
+		DriverName:      driverName,
+		Size:            size,
+		BackupURL:       backupURL,
+		DriverVolumeID:  driverVolumeID,
+		Type:            volumeType,
+		IOPS:            int64(iops),
+		PrepareForVM:    prepareForVM,
+		Filesystem:      fsType,
+		MkfsOptions:     c.String("mkfs-opts"),
+		MountOptions:    mountOpts,
+		EncryptionKey:   encryptionKey,
+		KmsKeyID:        c.String("kms-key-id"),
+		BackupBlockSize: c.String("backup-block-size"),
+		FsFreeze:        c.String("fsfreeze"),
+		Labels:          labels,
+		Async:           c.Bool("async"),
+		Verbose:         verboseOutput(c),
 	}
 
 	url := "/volumes/create"
@@ -142,6 +339,73 @@ func doVolumeCreate(c *cli.Context) error {
 	return sendRequestAndPrint("POST", url, request)
 }
 
+func cmdVolumeClone(c *cli.Context) {
+	if err := doVolumeClone(c); err != nil {
+		panic(err)
+	}
+}
+
+func doVolumeClone(c *cli.Context) error {
+	if len(c.Args()) != 2 {
+		return fmt.Errorf("Volume to clone and name of the new volume are required")
+	}
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+	name := c.Args().Get(1)
+	if err := util.CheckName(name); err != nil {
+		return err
+	}
+	labels, err := util.ParseLabels(c.StringSlice("label"))
+	if err != nil {
+		return err
+	}
+
+	request := &api.VolumeCloneRequest{
+		VolumeName: volumeName,
+		Name:       name,
+		Labels:     labels,
+		Async:      c.Bool("async"),
+		Verbose:    verboseOutput(c),
+	}
+
+	url := "/volumes/clone"
+
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdVolumeExpand(c *cli.Context) {
+	if err := doVolumeExpand(c); err != nil {
+		panic(err)
+	}
+}
+
+func doVolumeExpand(c *cli.Context) error {
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+	size, err := util.GetFlag(c, "size", true, err)
+	if err != nil {
+		return err
+	}
+	sizeInBytes, err := util.ParseSize(size)
+	if err != nil {
+		return err
+	}
+
+	request := &api.VolumeExpandRequest{
+		VolumeName: volumeName,
+		Size:       sizeInBytes,
+		Verbose:    verboseOutput(c),
+	}
+
+	url := "/volumes/expand"
+
+	return sendRequestAndPrint("POST", url, request)
+}
+
 func cmdVolumeDelete(c *cli.Context) {
 	if err := doVolumeDelete(c); err != nil {
 		panic(err)
@@ -155,6 +419,21 @@ func doVolumeDelete(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if isBulkSelect(c) {
+		if len(names) != 0 {
+			return fmt.Errorf("Cannot delete named volumes with --filter or --older-than")
+		}
+		if names, err = selectVolumes(c); err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			fmt.Fprintln(os.Stderr, "No volume matched")
+			return nil
+		}
+		if err := confirmBulk(c, "delete", names); err != nil {
+			return err
+		}
+	}
 
 	for _, name := range names {
 		request := &api.VolumeDeleteRequest{
@@ -181,13 +460,85 @@ func cmdVolumeList(c *cli.Context) {
 }
 
 func doVolumeList(c *cli.Context) error {
+	if c.Bool("watch") {
+		return watchList("", func(event *api.Event) bool {
+			return event.VolumeName != ""
+		}, func() error {
+			return listVolumes(c)
+		})
+	}
+	return listVolumes(c)
+}
+
+func listVolumes(c *cli.Context) error {
 	v := url.Values{}
 	if c.Bool("driver") {
 		v.Set("driver", "1")
 	}
+	if idleFor := c.String("idle-for"); idleFor != "" {
+		if _, err := util.ParseDuration(idleFor); err != nil {
+			return err
+		}
+		v.Set("idle_for", idleFor)
+	}
+	if err := addVolumeSelectQuery(c, v); err != nil {
+		return err
+	}
+	if limit := c.Int("limit"); limit != 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+	if after := c.String("after"); after != "" {
+		v.Set("after", after)
+	}
+	if c.Bool("brief") {
+		v.Set("brief", "1")
+	}
+	if fields := c.String("fields"); fields != "" {
+		v.Set("fields", fields)
+	}
 
-	url := "/volumes/list?" + v.Encode()
-	return sendRequestAndPrint("GET", url, nil)
+	rc, header, _, err := client.clientRequest("GET", "/volumes/list?"+v.Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	if err := printOutput(b); err != nil {
+		return err
+	}
+	if next := header.Get(api.NEXT_PAGE_HEADER); next != "" {
+		fmt.Fprintf(os.Stderr, "More volumes left, list them with --after %v\n", next)
+	}
+	return nil
+}
+
+func cmdVolumeDf(c *cli.Context) {
+	if err := doVolumeDf(c); err != nil {
+		panic(err)
+	}
+}
+
+func doVolumeDf(c *cli.Context) error {
+	names, err := getNames(c)
+	if err != nil {
+		return err
+	}
+	v := url.Values{}
+	if isBulkSelect(c) {
+		if len(names) != 0 {
+			return fmt.Errorf("Cannot show named volumes with --filter or --older-than")
+		}
+		if err := addVolumeSelectQuery(c, v); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		v.Add("volume", name)
+	}
+	return sendRequestAndPrint("GET", "/volumes/usage?"+v.Encode(), nil)
 }
 
 func cmdVolumeInspect(c *cli.Context) {
@@ -211,6 +562,28 @@ func doVolumeInspect(c *cli.Context) error {
 	return sendRequestAndPrint("GET", url, request)
 }
 
+func cmdVolumeTimeline(c *cli.Context) {
+	if err := doVolumeTimeline(c); err != nil {
+		panic(err)
+	}
+}
+
+func doVolumeTimeline(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.VolumeTimelineRequest{
+		VolumeName: volumeName,
+		URL:        c.String("dest"),
+	}
+	url := "/volumes/timeline"
+	return sendRequestAndPrint("GET", url, request)
+}
+
 func cmdVolumeMount(c *cli.Context) {
 	if err := doVolumeMount(c); err != nil {
 		panic(err)
@@ -227,9 +600,12 @@ func doVolumeMount(c *cli.Context) error {
 	}
 
 	request := &api.VolumeMountRequest{
-		VolumeName: volumeName,
-		MountPoint: mountPoint,
-		Verbose:    c.GlobalBool(verboseFlag),
+		VolumeName:   volumeName,
+		MountPoint:   mountPoint,
+		ReadOnly:     c.Bool("read-only"),
+		SubPath:      c.String("subpath"),
+		SELinuxLabel: c.String("selinux-label"),
+		Verbose:      verboseOutput(c),
 	}
 
 	url := "/volumes/mount"
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	// Events arriving within the interval after one are coalesced into one
+	// refresh, e.g. the ones of a volume being created and mounted
+	WATCH_COALESCE_INTERVAL = 200 * time.Millisecond
+
+	// Clears the terminal and moves cursor to the top
+	CLEAR_SCREEN = "\033[H\033[2J"
+)
+
+var watchFlag = cli.BoolFlag{
+	Name:  "watch, w",
+	Usage: "keep listing again as daemon publishes changes, until interrupted",
+}
+
+/*
+watchList calls list, then calls it again whenever daemon publishes an event
+changed returns true for, of the volume only if volumeName is set. It streams
+/events the same as "convoy events", subscribed before the first list so no
+change is missed. Output is redrawn in place on terminals, and appended
+otherwise, e.g. when piped to a file. It runs until interrupted, or daemon
+closes the stream, e.g. when it's stopped.
+*/
+func watchList(volumeName string, changed func(*api.Event) bool, list func() error) error {
+	v := url.Values{}
+	if volumeName != "" {
+		if err := util.CheckName(volumeName); err != nil {
+			return err
+		}
+		v.Set("volume", volumeName)
+	}
+	rc, err := sendRequest("GET", "/events?"+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	changes := make(chan *api.Event, 1)
+	streamErr := make(chan error, 1)
+	go func() {
+		decoder := json.NewDecoder(rc)
+		for {
+			event := &api.Event{}
+			if err := decoder.Decode(event); err != nil {
+				streamErr <- err
+				return
+			}
+			if !changed(event) {
+				continue
+			}
+			select {
+			case changes <- event:
+			default:
+				// A refresh is pending already
+			}
+		}
+	}()
+
+	terminal := isTerminal(os.Stdout)
+	var last *api.Event
+	for {
+		if terminal {
+			fmt.Print(CLEAR_SCREEN)
+		}
+		if err := list(); err != nil {
+			return err
+		}
+		if last != nil {
+			fmt.Fprintf(os.Stderr, "Refreshed on %v %v %v of %v at %v\n",
+				last.Object, last.Name, last.Event, last.VolumeName, last.Time)
+		}
+
+		select {
+		case last = <-changes:
+			time.Sleep(WATCH_COALESCE_INTERVAL)
+			select {
+			case last = <-changes:
+			default:
+			}
+		case err := <-streamErr:
+			if err == io.EOF {
+				return fmt.Errorf("Daemon closed the event stream")
+			}
+			return err
+		}
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rancher/convoy/api"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestWatchList(c *C) {
+	done := make(chan struct{})
+	query := make(chan string, 1)
+	defer serveFakeDaemon(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query <- r.URL.RawQuery
+		encoder := json.NewEncoder(w)
+		for _, event := range []api.Event{
+			{VolumeName: "vol1", VolumeEvent: api.VolumeEvent{Object: "snapshot", Event: "create", Name: "snap1"}},
+			{VolumeName: "vol1", VolumeEvent: api.VolumeEvent{Object: "volume", Event: "mount", Name: "vol1"}},
+			{VolumeName: "vol1", VolumeEvent: api.VolumeEvent{Object: "snapshot", Event: "delete", Name: "snap1"}},
+		} {
+			encoder.Encode(event)
+		}
+		w.(http.Flusher).Flush()
+		// Daemon is stopped after the list is refreshed
+		<-done
+	}))()
+
+	lists := 0
+	err := watchList("vol1", func(event *api.Event) bool {
+		return event.Object == "snapshot"
+	}, func() error {
+		lists++
+		if lists == 2 {
+			close(done)
+		}
+		return nil
+	})
+	c.Assert(err, ErrorMatches, "Daemon closed the event stream")
+	c.Assert(<-query, Equals, "volume=vol1")
+	// Listed at start, then once for both snapshot events coalesced
+	c.Assert(lists, Equals, 2)
+
+	err = watchList("vol/1", func(*api.Event) bool { return true }, func() error { return nil })
+	c.Assert(err, NotNil)
+}
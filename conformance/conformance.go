@@ -0,0 +1,496 @@
+/*
+Package conformance exercises a ConvoyDriver through the full volume
+lifecycle, so in-tree and out-of-tree drivers can prove they behave the way
+the Convoy daemon expects.
+
+Each step is run in order and recorded in the returned report. Steps which
+depend on functionality the driver doesn't report in Capabilities() are
+marked as skipped rather than failed.
+*/
+package conformance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+const (
+	STEP_CREATE   = "create"
+	STEP_MOUNT    = "mount"
+	STEP_WRITE    = "write"
+	STEP_EXPAND   = "expand"
+	STEP_SNAPSHOT = "snapshot"
+	STEP_BACKUP   = "backup"
+	STEP_RESTORE  = "restore"
+	STEP_VERIFY   = "verify"
+	STEP_UMOUNT   = "umount"
+	STEP_CLEANUP  = "cleanup"
+
+	DEFAULT_DATA_SIZE  = 4 * 1024 * 1024
+	DEFAULT_FILE_COUNT = 4
+	// Volumes are expanded by 1GiB, the smallest step of cloud volumes
+	EXPAND_SIZE = 1024 * 1024 * 1024
+
+	dataDirName = "convoy-conformance"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "conformance"})
+)
+
+// Config specifies how the conformance run would exercise the driver
+type Config struct {
+	// Size of volume to create, in bytes. 0 means driver default
+	VolumeSize int64
+	// Destination for backup and restore, steps would be skipped if empty
+	BackupDest string
+	// Total bytes of random data written to the volume
+	DataSize int64
+	// Number of files the random data would be spread across
+	FileCount int
+	// Seed for random data generation, so failure can be reproduced
+	Seed int64
+	// Extra driver specific options passed to CreateVolume()
+	VolumeOptions map[string]string
+}
+
+type StepResult struct {
+	Step     string
+	Passed   bool
+	Skipped  bool
+	Error    string `json:",omitempty"`
+	Duration string
+}
+
+type Report struct {
+	Driver string
+	Seed   int64
+	Passed bool
+	Steps  []StepResult
+}
+
+type runner struct {
+	driver ConvoyDriver
+	config Config
+	report *Report
+
+	volumeName   string
+	restoredName string
+	snapshotName string
+	backupURL    string
+	checksums    map[string]string
+}
+
+func (r *runner) step(name string, f func() error) bool {
+	start := time.Now()
+	err := f()
+	result := StepResult{
+		Step:     name,
+		Passed:   err == nil,
+		Duration: time.Since(start).String(),
+	}
+	if err != nil {
+		if _, ok := err.(skipError); ok {
+			result.Passed = true
+			result.Skipped = true
+		} else {
+			r.report.Passed = false
+		}
+		result.Error = err.Error()
+	}
+	log.Debugf("Conformance step %v of driver %v: %+v", name, r.driver.Name(), result)
+	r.report.Steps = append(r.report.Steps, result)
+	return result.Passed
+}
+
+type skipError struct {
+	error
+}
+
+func skip(format string, a ...interface{}) error {
+	return skipError{fmt.Errorf(format, a...)}
+}
+
+/*
+Verify would execute the conformance steps against driver. The returned error is
+only for failures preventing the run itself, failed steps would be recorded
+in the report instead.
+*/
+func Verify(driver ConvoyDriver, config Config) (*Report, error) {
+	if config.DataSize == 0 {
+		config.DataSize = DEFAULT_DATA_SIZE
+	}
+	if config.FileCount <= 0 {
+		config.FileCount = DEFAULT_FILE_COUNT
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+	suffix := util.NewUUID()[:8]
+	r := &runner{
+		driver: driver,
+		config: config,
+		report: &Report{
+			Driver: driver.Name(),
+			Seed:   config.Seed,
+			Passed: true,
+		},
+		volumeName:   "conformance-" + suffix,
+		restoredName: "conformance-restored-" + suffix,
+		snapshotName: "conformance-snapshot-" + suffix,
+	}
+
+	volOps, err := driver.VolumeOps()
+	if err != nil {
+		return nil, err
+	}
+
+	if !r.step(STEP_CREATE, func() error {
+		return r.createVolume(volOps, r.volumeName, "")
+	}) {
+		return r.report, nil
+	}
+	defer r.step(STEP_CLEANUP, func() error { return r.cleanup(volOps) })
+
+	var mountPoint string
+	if !r.step(STEP_MOUNT, func() error {
+		mountPoint, err = r.mountVolume(volOps, r.volumeName)
+		return err
+	}) {
+		return r.report, nil
+	}
+	if !r.step(STEP_WRITE, func() error {
+		r.checksums, err = r.writeData(mountPoint)
+		return err
+	}) {
+		return r.report, nil
+	}
+	r.step(STEP_EXPAND, func() error { return r.expandVolume(volOps, mountPoint) })
+	if !r.step(STEP_SNAPSHOT, r.createSnapshot) {
+		return r.report, nil
+	}
+	if !r.step(STEP_BACKUP, r.createBackup) {
+		return r.report, nil
+	}
+	if !r.step(STEP_RESTORE, func() error {
+		if r.backupURL == "" {
+			return skip("No backup created")
+		}
+		return r.createVolume(volOps, r.restoredName, r.backupURL)
+	}) {
+		return r.report, nil
+	}
+	r.step(STEP_VERIFY, func() error {
+		if r.backupURL == "" {
+			return skip("No backup created")
+		}
+		restoredPoint, err := r.mountVolume(volOps, r.restoredName)
+		if err != nil {
+			return err
+		}
+		defer volOps.UmountVolume(Request{Name: r.restoredName, Options: map[string]string{}})
+		return r.verifyData(restoredPoint, r.checksums)
+	})
+	r.step(STEP_UMOUNT, func() error {
+		if err := volOps.UmountVolume(Request{Name: r.volumeName, Options: map[string]string{}}); err != nil {
+			return err
+		}
+		mp, err := volOps.MountPoint(Request{Name: r.volumeName, Options: map[string]string{}})
+		if err != nil {
+			return err
+		}
+		if mp != "" {
+			return fmt.Errorf("Volume %v still reports mount point %v after umount", r.volumeName, mp)
+		}
+		return nil
+	})
+	return r.report, nil
+}
+
+func (r *runner) createVolume(volOps VolumeOperations, name, backupURL string) error {
+	opts := map[string]string{}
+	for k, v := range r.config.VolumeOptions {
+		opts[k] = v
+	}
+	opts[OPT_SIZE] = strconv.FormatInt(r.config.VolumeSize, 10)
+	opts[OPT_BACKUP_URL] = backupURL
+	opts[OPT_VOLUME_NAME] = name
+	if _, exists := opts[OPT_PREPARE_FOR_VM]; !exists {
+		opts[OPT_PREPARE_FOR_VM] = "false"
+	}
+	if _, exists := opts[OPT_VOLUME_IOPS]; !exists {
+		opts[OPT_VOLUME_IOPS] = "0"
+	}
+	if err := volOps.CreateVolume(Request{Name: name, Options: opts}); err != nil {
+		return err
+	}
+	info, err := volOps.GetVolumeInfo(name)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf("Volume %v created but GetVolumeInfo() returned nothing", name)
+	}
+	volumes, err := volOps.ListVolume(map[string]string{})
+	if err != nil {
+		return err
+	}
+	if _, exists := volumes[name]; !exists {
+		return fmt.Errorf("Volume %v created but not in ListVolume()", name)
+	}
+	return nil
+}
+
+func (r *runner) mountVolume(volOps VolumeOperations, name string) (string, error) {
+	mountPoint, err := volOps.MountVolume(Request{Name: name, Options: map[string]string{}})
+	if err != nil {
+		return "", err
+	}
+	if mountPoint == "" {
+		return "", fmt.Errorf("MountVolume() of %v returned empty mount point", name)
+	}
+	mp, err := volOps.MountPoint(Request{Name: name, Options: map[string]string{}})
+	if err != nil {
+		return "", err
+	}
+	if mp != mountPoint {
+		return "", fmt.Errorf("MountPoint() of %v returned %v, expected %v", name, mp, mountPoint)
+	}
+	return mountPoint, nil
+}
+
+func (r *runner) writeData(mountPoint string) (map[string]string, error) {
+	dir := filepath.Join(mountPoint, dataDirName)
+	if err := util.MkdirIfNotExists(dir); err != nil {
+		return nil, err
+	}
+	random := rand.New(rand.NewSource(r.config.Seed))
+	fileSize := r.config.DataSize / int64(r.config.FileCount)
+	checksums := make(map[string]string)
+	for i := 0; i < r.config.FileCount; i++ {
+		data := make([]byte, fileSize)
+		random.Read(data)
+		name := fmt.Sprintf("data-%d", i)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return nil, err
+		}
+		checksums[name] = util.GetChecksum(data)
+	}
+	if err := util.Sync(); err != nil {
+		return nil, err
+	}
+	return checksums, r.verifyData(mountPoint, checksums)
+}
+
+func (r *runner) verifyData(mountPoint string, checksums map[string]string) error {
+	dir := filepath.Join(mountPoint, dataDirName)
+	for name, expected := range checksums {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if checksum := util.GetChecksum(data); checksum != expected {
+			return fmt.Errorf("Data mismatch for %v: checksum %v, expected %v", name, checksum, expected)
+		}
+	}
+	return nil
+}
+
+/*
+expandVolume expands the mounted volume by EXPAND_SIZE, and checks that the
+new size is reported, shrinking it back is refused and data written is intact.
+*/
+func (r *runner) expandVolume(volOps VolumeOperations, mountPoint string) error {
+	if !r.driver.Capabilities().Resize {
+		return skip("Driver doesn't support resize")
+	}
+	resizer, ok := r.driver.(Resizer)
+	if !ok {
+		return fmt.Errorf("Driver reports resize capability but doesn't implement ResizeVolume()")
+	}
+	size, err := r.getVolumeSize(volOps)
+	if err != nil {
+		return err
+	}
+	newSize := size + EXPAND_SIZE
+	if err := resizer.ResizeVolume(Request{
+		Name:    r.volumeName,
+		Options: map[string]string{OPT_SIZE: strconv.FormatInt(newSize, 10)},
+	}); err != nil {
+		return err
+	}
+	reported, err := r.getVolumeSize(volOps)
+	if err != nil {
+		return err
+	}
+	if reported < newSize {
+		return fmt.Errorf("Volume %v reports size %v after expanded to %v", r.volumeName, reported, newSize)
+	}
+	if err := resizer.ResizeVolume(Request{
+		Name:    r.volumeName,
+		Options: map[string]string{OPT_SIZE: strconv.FormatInt(size, 10)},
+	}); err == nil {
+		return fmt.Errorf("Volume %v shrunk from %v to %v, expected to be refused", r.volumeName, reported, size)
+	}
+	return r.verifyData(mountPoint, r.checksums)
+}
+
+func (r *runner) getVolumeSize(volOps VolumeOperations) (int64, error) {
+	info, err := volOps.GetVolumeInfo(r.volumeName)
+	if err != nil {
+		return 0, err
+	}
+	size, err := strconv.ParseInt(info[OPT_SIZE], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("GetVolumeInfo() of %v returned invalid size %q: %v", r.volumeName, info[OPT_SIZE], err)
+	}
+	return size, nil
+}
+
+func (r *runner) createSnapshot() error {
+	if !r.driver.Capabilities().Snapshot {
+		return skip("Driver doesn't support snapshot")
+	}
+	snapOps, err := r.driver.SnapshotOps()
+	if err != nil {
+		return fmt.Errorf("Driver reports snapshot capability but SnapshotOps() failed: %v", err)
+	}
+	req := Request{
+		Name: r.snapshotName,
+		Options: map[string]string{
+			OPT_VOLUME_NAME: r.volumeName,
+		},
+	}
+	if err := snapOps.CreateSnapshot(req); err != nil {
+		return err
+	}
+	if _, err := snapOps.GetSnapshotInfo(req); err != nil {
+		return err
+	}
+	snapshots, err := snapOps.ListSnapshot(map[string]string{OPT_VOLUME_NAME: r.volumeName})
+	if err != nil {
+		return err
+	}
+	if _, exists := snapshots[r.snapshotName]; !exists {
+		return fmt.Errorf("Snapshot %v created but not in ListSnapshot()", r.snapshotName)
+	}
+	return nil
+}
+
+func (r *runner) createBackup() error {
+	if r.config.BackupDest == "" {
+		return skip("No backup destination specified")
+	}
+	caps := r.driver.Capabilities()
+	if !caps.Snapshot {
+		return skip("Driver doesn't support snapshot")
+	}
+	if !caps.Backup {
+		return skip("Driver doesn't support backup")
+	}
+	backupOps, err := r.driver.BackupOps()
+	if err != nil {
+		return fmt.Errorf("Driver reports backup capability but BackupOps() failed: %v", err)
+	}
+	volOps, err := r.driver.VolumeOps()
+	if err != nil {
+		return err
+	}
+	snapOps, err := r.driver.SnapshotOps()
+	if err != nil {
+		return err
+	}
+	volumeInfo, err := volOps.GetVolumeInfo(r.volumeName)
+	if err != nil {
+		return err
+	}
+	snapshotInfo, err := snapOps.GetSnapshotInfo(Request{
+		Name:    r.snapshotName,
+		Options: map[string]string{OPT_VOLUME_NAME: r.volumeName},
+	})
+	if err != nil {
+		return err
+	}
+	opts := map[string]string{
+		OPT_VOLUME_NAME:           r.volumeName,
+		OPT_VOLUME_CREATED_TIME:   volumeInfo[OPT_VOLUME_CREATED_TIME],
+		OPT_SNAPSHOT_CREATED_TIME: snapshotInfo[OPT_SNAPSHOT_CREATED_TIME],
+	}
+	r.backupURL, err = backupOps.CreateBackup(r.snapshotName, r.volumeName, r.config.BackupDest, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := backupOps.GetBackupInfo(r.backupURL); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *runner) cleanup(volOps VolumeOperations) error {
+	var errs []error
+	if r.backupURL != "" {
+		if backupOps, err := r.driver.BackupOps(); err == nil {
+			if err := backupOps.DeleteBackup(r.backupURL); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if snapOps, err := r.driver.SnapshotOps(); err == nil {
+		req := Request{
+			Name:    r.snapshotName,
+			Options: map[string]string{OPT_VOLUME_NAME: r.volumeName},
+		}
+		if _, err := snapOps.GetSnapshotInfo(req); err == nil {
+			if err := snapOps.DeleteSnapshot(req); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for _, name := range []string{r.restoredName, r.volumeName} {
+		if _, err := volOps.GetVolumeInfo(name); err != nil {
+			continue
+		}
+		volOps.UmountVolume(Request{Name: name, Options: map[string]string{}})
+		if err := volOps.DeleteVolume(Request{
+			Name:    name,
+			Options: map[string]string{OPT_REFERENCE_ONLY: "false"},
+		}); err != nil {
+			errs = append(errs, err)
+		}
+		if _, err := volOps.GetVolumeInfo(name); err == nil {
+			errs = append(errs, fmt.Errorf("Volume %v still exists after delete", name))
+		} else if !util.IsNotExistsError(err) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("Cleanup failed: %v", errs)
+	}
+	return nil
+}
+
+// VerifyDriver would initialize driver name at a temporary root and run the
+// conformance steps against it
+func VerifyDriver(name string, driverOpts map[string]string, config Config) (*Report, error) {
+	root, err := ioutil.TempDir("", "convoy-conformance-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(root)
+
+	driver, err := GetDriver(name, root, driverOpts)
+	if err != nil {
+		return nil, err
+	}
+	return Verify(driver, config)
+}
@@ -0,0 +1,167 @@
+package conformance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	_ "github.com/rancher/convoy/vfs"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+const (
+	testRoot = "/tmp/conformance"
+)
+
+func (s *TestSuite) SetUpTest(c *C) {
+	c.Assert(os.MkdirAll(testRoot, 0755), IsNil)
+}
+
+func (s *TestSuite) TearDownTest(c *C) {
+	c.Assert(os.RemoveAll(testRoot), IsNil)
+}
+
+func (s *TestSuite) TestVFSConformance(c *C) {
+	opts := map[string]string{
+		"vfs.path": filepath.Join(testRoot, "volumes"),
+	}
+	c.Assert(os.MkdirAll(filepath.Join(testRoot, "backup"), 0755), IsNil)
+	config := Config{
+		BackupDest: "vfs://" + filepath.Join(testRoot, "backup"),
+		DataSize:   1024 * 1024,
+		Seed:       1,
+	}
+	report, err := VerifyDriver("vfs", opts, config)
+	c.Assert(err, IsNil)
+	c.Assert(report.Passed, Equals, true, Commentf("%+v", report))
+
+	steps := []string{}
+	for _, step := range report.Steps {
+		// vfs only resizes volumes with project quota
+		c.Assert(step.Skipped, Equals, step.Step == STEP_EXPAND, Commentf("%+v", step))
+		steps = append(steps, step.Step)
+	}
+	c.Assert(steps, DeepEquals, []string{
+		STEP_CREATE, STEP_MOUNT, STEP_WRITE, STEP_EXPAND, STEP_SNAPSHOT,
+		STEP_BACKUP, STEP_RESTORE, STEP_VERIFY, STEP_UMOUNT, STEP_CLEANUP,
+	})
+}
+
+// resizableDriver makes vfs volumes resizable by keeping their sizes itself
+type resizableDriver struct {
+	ConvoyDriver
+	sizes map[string]int64
+	// shrink lets volumes be shrunk, which conformance should catch
+	shrink bool
+}
+
+type resizableVolumeOps struct {
+	VolumeOperations
+	driver *resizableDriver
+}
+
+func (d *resizableDriver) Capabilities() Capabilities {
+	caps := d.ConvoyDriver.Capabilities()
+	caps.Resize = true
+	return caps
+}
+
+func (d *resizableDriver) VolumeOps() (VolumeOperations, error) {
+	ops, err := d.ConvoyDriver.VolumeOps()
+	if err != nil {
+		return nil, err
+	}
+	return &resizableVolumeOps{ops, d}, nil
+}
+
+func (d *resizableDriver) ResizeVolume(req Request) error {
+	size, err := strconv.ParseInt(req.Options[OPT_SIZE], 10, 64)
+	if err != nil {
+		return err
+	}
+	if size <= d.sizes[req.Name] && !d.shrink {
+		return fmt.Errorf("Volume %v can only be expanded", req.Name)
+	}
+	d.sizes[req.Name] = size
+	return nil
+}
+
+func (ops *resizableVolumeOps) GetVolumeInfo(name string) (map[string]string, error) {
+	info, err := ops.VolumeOperations.GetVolumeInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	if size, exists := ops.driver.sizes[name]; exists {
+		info[OPT_SIZE] = strconv.FormatInt(size, 10)
+	}
+	return info, nil
+}
+
+func (s *TestSuite) TestExpand(c *C) {
+	root, err := ioutil.TempDir(testRoot, "vfs-")
+	c.Assert(err, IsNil)
+	vfs, err := GetDriver("vfs", root, map[string]string{
+		"vfs.path": filepath.Join(root, "volumes"),
+	})
+	c.Assert(err, IsNil)
+	driver := &resizableDriver{
+		ConvoyDriver: vfs,
+		sizes:        make(map[string]int64),
+	}
+
+	stepOf := func(report *Report, name string) StepResult {
+		for _, step := range report.Steps {
+			if step.Step == name {
+				return step
+			}
+		}
+		c.Fatalf("No step %v in %+v", name, report)
+		return StepResult{}
+	}
+
+	report, err := Verify(driver, Config{DataSize: 1024, Seed: 1})
+	c.Assert(err, IsNil)
+	c.Assert(report.Passed, Equals, true, Commentf("%+v", report))
+	c.Assert(stepOf(report, STEP_EXPAND).Skipped, Equals, false)
+	c.Assert(driver.sizes, HasLen, 1)
+	for _, size := range driver.sizes {
+		c.Assert(size, Equals, int64(EXPAND_SIZE))
+	}
+
+	driver.shrink = true
+	report, err = Verify(driver, Config{DataSize: 1024, Seed: 1})
+	c.Assert(err, IsNil)
+	c.Assert(report.Passed, Equals, false)
+	c.Assert(stepOf(report, STEP_EXPAND).Error, Matches, ".*expected to be refused")
+	// Later steps still run
+	c.Assert(stepOf(report, STEP_SNAPSHOT).Passed, Equals, true)
+}
+
+func (s *TestSuite) TestSkipBackupWithoutDest(c *C) {
+	opts := map[string]string{
+		"vfs.path": filepath.Join(testRoot, "volumes"),
+	}
+	report, err := VerifyDriver("vfs", opts, Config{DataSize: 1024})
+	c.Assert(err, IsNil)
+	c.Assert(report.Passed, Equals, true, Commentf("%+v", report))
+
+	skipped := map[string]bool{}
+	for _, step := range report.Steps {
+		skipped[step.Step] = step.Skipped
+	}
+	c.Assert(skipped[STEP_BACKUP], Equals, true)
+	c.Assert(skipped[STEP_RESTORE], Equals, true)
+	c.Assert(skipped[STEP_VERIFY], Equals, true)
+	c.Assert(skipped[STEP_SNAPSHOT], Equals, false)
+}
@@ -1,6 +1,7 @@
 package convoydriver
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 
@@ -29,15 +30,172 @@ driver.
 type ConvoyDriver interface {
 	Name() string
 	Info() (map[string]string, error)
+	Capabilities() Capabilities
 
 	VolumeOps() (VolumeOperations, error)
 	SnapshotOps() (SnapshotOperations, error)
 	BackupOps() (BackupOperations, error)
 }
 
+/*
+Capabilities describes the optional functionality supported by a Convoy
+Driver, so unsupported operations can be rejected before reaching the driver.
+*/
+type Capabilities struct {
+	Snapshot bool
+	Backup   bool
+	// Resize means volume can be expanded by Resizer.ResizeVolume()
+	Resize bool
+	// Clone means volume can be created as an independent copy of another
+	// volume of the driver, by VolumeOperations.CreateVolume() with
+	// opts[OPT_CLONE_VOLUME]
+	Clone bool
+	// CrossHostAttach means volume can be detached and used on another host
+	CrossHostAttach bool
+	// CustomMountPoint means volume can be mounted at user specified path
+	CustomMountPoint bool
+	// ReadOnlyMount means volume can be mounted read-only
+	ReadOnlyMount bool
+	// Encryption means block device of volume can be encrypted with LUKS
+	Encryption bool
+	// SELinuxLabel means filesystem of volume can be labeled with SELinux
+	// context when mounted
+	SELinuxLabel bool
+	// KmsEncryption means volume and its snapshots can be encrypted by the
+	// backend with a KMS key, specified by opts[OPT_KMS_KEY_ID]
+	KmsEncryption bool
+}
+
+/*
+RecoverFunc would rebuild the driver's configuration under "root" from the
+state the driver exported to objectstore at destURL, after the local
+configuration is lost. "host" identifies which host's state to recover.
+*/
+type RecoverFunc func(root, destURL, host string) error
+
+/*
+HealthChecker is an optional interface for Convoy Driver to probe the health
+of its backend, e.g. the cloud API or storage pool it depends on. Daemon
+would call CheckHealth() periodically, and fail new operations of the driver
+fast while it returns error.
+*/
+type HealthChecker interface {
+	CheckHealth() error
+}
+
+/*
+FilesystemGrower is an optional interface for Convoy Driver whose volumes'
+devices can be expanded outside of Convoy, e.g. by EBS ModifyVolume. Driver
+should grow the filesystem when mounting a volume with expanded device, and
+daemon would call GrowFilesystems() periodically to grow the filesystems of
+mounted volumes online. It returns the new sizes of volumes grown.
+*/
+type FilesystemGrower interface {
+	GrowFilesystems() (map[string]int64, error)
+}
+
+/*
+Resizer is an optional interface for Convoy Driver supporting
+Capabilities.Resize, to expand the volume to opts[OPT_SIZE]. Filesystem of
+the volume should be grown right away if it's mounted, otherwise when it's
+mounted next time. Volumes cannot be shrunk.
+*/
+type Resizer interface {
+	ResizeVolume(req Request) error
+}
+
+/*
+UsageReporter is an optional interface for Convoy Driver to report the bytes a
+volume actually takes in its backend, e.g. blocks of thin device allocated in
+the pool, which can be far less or more than the usage of its filesystem.
+Daemon reports usage of filesystems of mounted volumes regardless.
+*/
+type UsageReporter interface {
+	VolumeUsage(name string) (int64, error)
+}
+
+/*
+MetricsReporter is an optional interface for Convoy Driver to report gauges
+of its backend in metrics of daemon, e.g. usage of the storage pool. Names of
+metrics would be prefixed by "convoy_<driver name>_".
+*/
+type MetricsReporter interface {
+	Metrics() ([]Metric, error)
+}
+
+type Metric struct {
+	Name   string
+	Help   string
+	Labels map[string]string
+	Value  float64
+}
+
+/*
+AlertReporter is an optional interface for Convoy Driver to report conditions
+of its backend that need attention before operations start failing, e.g. the
+storage pool near full. Daemon would call Alerts() with the health probes, and
+publish events when an alert is raised or cleared.
+*/
+type AlertReporter interface {
+	Alerts() ([]Alert, error)
+}
+
+type Alert struct {
+	// Name identifies the condition, e.g. data_space
+	Name   string
+	Detail string
+}
+
+/*
+Reconciler is an optional interface for Convoy Driver to reconcile its backend
+and the mounts of its volumes with its metadata when daemon starts, e.g. after
+an unclean shutdown or reboot: activating or attaching devices of volumes,
+mounting volumes which should be mounted again, and cleaning up mounts, mount
+points and devices left behind by volumes not mounted. Volumes are reconciled
+one by one, and failure of one volume shouldn't stop the others. It returns
+what has been done, error only if nothing could be reconciled.
+*/
+type Reconciler interface {
+	Reconcile() ([]Reconciliation, error)
+}
+
+// Reconciliation is a fix of the volume, or of the backend if Volume is
+// empty, e.g. "remounted at /mnt/db". Err is set if the fix failed.
+type Reconciliation struct {
+	Volume string
+	Action string
+	Err    error
+}
+
 type Request struct {
 	Name    string
 	Options map[string]string
+	// Context carries the span of the operation, so drivers can trace
+	// their steps as its children by util.StartSpan(). It can be nil
+	Context context.Context
+}
+
+/*
+Drainer is an optional interface for Convoy Driver whose volumes are attached
+to the host, e.g. cloud block devices, to release the volumes not mounted from
+the host at once, so they can be used by other hosts before the host goes
+away. Mounted volumes are left to be unmounted first and reported as failures.
+Volumes drained should be attached again when they're mounted. It returns what
+has been done as Reconcile() does.
+*/
+type Drainer interface {
+	Drain() ([]Reconciliation, error)
+}
+
+/*
+DefaultsReloader is an optional interface for Convoy Driver whose defaults of
+new volumes, e.g. size and type, can be changed while daemon is running.
+opts are the driver options changing, in the same form as --driver-opts.
+Driver should reject all of them if any is invalid or cannot be changed, and
+save the new defaults as its config.
+*/
+type DefaultsReloader interface {
+	ReloadDefaults(opts map[string]string) error
 }
 
 /*
@@ -93,18 +251,33 @@ const (
 	OPT_SNAPSHOT_NAME         = "SnapshotName"
 	OPT_SNAPSHOT_CREATED_TIME = "SnapshotCreatedAt"
 	OPT_BACKUP_URL            = "BackupURL"
+	OPT_CLONE_VOLUME          = "CloneVolume"
 	OPT_REFERENCE_ONLY        = "ReferenceOnly"
 	OPT_PREPARE_FOR_VM        = "PrepareForVM"
 	OPT_FILESYSTEM            = "Filesystem"
+	OPT_MKFS_OPTIONS          = "MkfsOptions"
+	OPT_MOUNT_OPTIONS         = "MountOptions"
+	OPT_KMS_KEY_ID            = "KmsKeyID"
+	OPT_READ_ONLY             = "ReadOnly"
+	OPT_ENCRYPTION_KEY        = "EncryptionKey"
+	OPT_FSFREEZE              = "FsFreeze"
+	OPT_SELINUX_CONTEXT       = "SELinuxContext"
+	OPT_BACKUP_BLOCK_SIZE     = "BackupBlockSize"
+	OPT_BACKUP_LABELS         = "BackupLabels"
+	// Labels of volume or snapshot being created, encoded by
+	// util.EncodeLabels, which drivers can tag their resources with
+	OPT_LABELS = "Labels"
 )
 
 var (
 	initializers map[string]InitFunc
+	recoverers   map[string]RecoverFunc
 	log          = logrus.WithFields(logrus.Fields{"pkg": "convoydriver"})
 )
 
 func init() {
 	initializers = make(map[string]InitFunc)
+	recoverers = make(map[string]RecoverFunc)
 }
 
 /*
@@ -128,3 +301,27 @@ func GetDriver(name, root string, config map[string]string) (ConvoyDriver, error
 	drvRoot := filepath.Join(root, name)
 	return initializers[name](drvRoot, config)
 }
+
+/*
+RegisterRecover would add specified RecoverFunc for a Convoy Driver which
+supports recovering from exported state.
+*/
+func RegisterRecover(name string, recoverFunc RecoverFunc) error {
+	if _, exists := recoverers[name]; exists {
+		return fmt.Errorf("Recover function of driver %s has already been registered", name)
+	}
+	recoverers[name] = recoverFunc
+	return nil
+}
+
+/*
+Recover would rebuild the configuration of Convoy Driver at root from state
+exported to destURL.
+*/
+func Recover(name, root, destURL, host string) error {
+	if _, exists := recoverers[name]; !exists {
+		return fmt.Errorf("Driver %v doesn't support recover", name)
+	}
+	drvRoot := filepath.Join(root, name)
+	return recoverers[name](drvRoot, destURL, host)
+}
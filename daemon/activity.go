@@ -0,0 +1,206 @@
+package daemon
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+const (
+	ACTIVITY_DIR = "activity"
+
+	ACTIVITY_SAMPLE_INTERVAL = 5 * time.Minute
+
+	DISKSTATS_FILE = "/proc/diskstats"
+)
+
+/*
+volumeActivity records when the volume was last in use. LastMounted is the
+last time volume was mounted, or seen mounted if I/O of the volume cannot be
+sampled. LastIO is the last time I/O counters of volume's device changed.
+*/
+type volumeActivity struct {
+	Name        string
+	LastMounted string
+	LastIO      string
+	// I/O counters of the device at last sample
+	ReadIOs  uint64
+	WriteIOs uint64
+
+	root string
+}
+
+func (a *volumeActivity) ConfigFile() (string, error) {
+	if a.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if a.root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty activity root")
+	}
+	return filepath.Join(a.root, ACTIVITY_DIR, VOLUME_CFG_PREFIX+a.Name+CFG_POSTFIX), nil
+}
+
+func (s *daemon) loadVolumeActivity(volumeName string) (*volumeActivity, error) {
+	activity := &volumeActivity{
+		Name: volumeName,
+		root: s.Root,
+	}
+	if err := util.ObjectLoad(activity); err != nil && !util.IsNotExistsError(err) {
+		return nil, err
+	}
+	return activity, nil
+}
+
+func (s *daemon) updateVolumeActivity(volumeName string, update func(*volumeActivity)) error {
+	s.activityMutex.Lock()
+	defer s.activityMutex.Unlock()
+
+	if err := util.MkdirIfNotExists(filepath.Join(s.Root, ACTIVITY_DIR)); err != nil {
+		return err
+	}
+	activity, err := s.loadVolumeActivity(volumeName)
+	if err != nil {
+		return err
+	}
+	update(activity)
+	return util.ObjectSave(activity)
+}
+
+func (s *daemon) recordMount(volumeName string) {
+	if err := s.updateVolumeActivity(volumeName, func(a *volumeActivity) {
+		a.LastMounted = util.Now()
+	}); err != nil {
+		log.Warnf("Failed to record mount of volume %v: %v", volumeName, err)
+	}
+}
+
+func (s *daemon) deleteVolumeActivity(volumeName string) {
+	s.activityMutex.Lock()
+	defer s.activityMutex.Unlock()
+
+	activity := &volumeActivity{
+		Name: volumeName,
+		root: s.Root,
+	}
+	if exists, err := util.ObjectExists(activity); err != nil || !exists {
+		return
+	}
+	if err := util.ObjectDelete(activity); err != nil {
+		log.Warnf("Failed to delete activity of volume %v: %v", volumeName, err)
+	}
+}
+
+type diskStats struct {
+	ReadIOs  uint64
+	WriteIOs uint64
+}
+
+/*
+readDiskStats parses /proc/diskstats, in format of:
+
+<major> <minor> <name> <reads completed> <reads merged> <sectors read>
+<time reading> <writes completed> ...
+*/
+func readDiskStats(file string) (map[string]diskStats, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := make(map[string]diskStats)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		reads, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		writes, err := strconv.ParseUint(fields[7], 10, 64)
+		if err != nil {
+			continue
+		}
+		stats[fields[2]] = diskStats{
+			ReadIOs:  reads,
+			WriteIOs: writes,
+		}
+	}
+	return stats, scanner.Err()
+}
+
+// getKernelDeviceName would resolve e.g. /dev/mapper/vol1 to dm-3, as named
+// in /proc/diskstats
+func getKernelDeviceName(dev string) string {
+	if resolved, err := filepath.EvalSymlinks(dev); err == nil {
+		dev = resolved
+	}
+	return filepath.Base(dev)
+}
+
+func (s *daemon) sampleVolumeActivity() {
+	stats, err := readDiskStats(DISKSTATS_FILE)
+	if err != nil {
+		log.Debugf("Cannot read disk stats, I/O of volumes won't be sampled: %v", err)
+	}
+	for name, info := range s.getVolumeList() {
+		if info[OPT_MOUNT_POINT] == "" {
+			continue
+		}
+		stat, sampled := diskStats{}, false
+		if info["Device"] != "" && stats != nil {
+			stat, sampled = stats[getKernelDeviceName(info["Device"])]
+		}
+		if err := s.updateVolumeActivity(name, func(a *volumeActivity) {
+			if !sampled {
+				a.LastMounted = util.Now()
+				return
+			}
+			if a.LastIO == "" || stat.ReadIOs != a.ReadIOs || stat.WriteIOs != a.WriteIOs {
+				a.LastIO = util.Now()
+			}
+			a.ReadIOs = stat.ReadIOs
+			a.WriteIOs = stat.WriteIOs
+		}); err != nil {
+			log.Warnf("Failed to sample activity of volume %v: %v", name, err)
+		}
+	}
+}
+
+func (s *daemon) startActivitySampler() {
+	go func() {
+		for {
+			s.sampleVolumeActivity()
+			time.Sleep(ACTIVITY_SAMPLE_INTERVAL)
+		}
+	}()
+}
+
+func (s *daemon) getVolumeActivity(volumeName string) (*volumeActivity, error) {
+	s.activityMutex.Lock()
+	defer s.activityMutex.Unlock()
+	return s.loadVolumeActivity(volumeName)
+}
+
+// getLastActive returns the last time volume was known in use, or created if
+// never used
+func getLastActive(volume *api.VolumeResponse) time.Time {
+	lastActive := parseTime(volume.CreatedTime)
+	for _, t := range []string{volume.LastMounted, volume.LastIO} {
+		if parsed := parseTime(t); parsed.After(lastActive) {
+			lastActive = parsed
+		}
+	}
+	return lastActive
+}
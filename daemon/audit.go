@@ -0,0 +1,430 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	AUDIT_LOG_FILE = "audit.log"
+
+	AUDIT_CALLER_DOCKER = "docker-plugin"
+	AUDIT_CALLER_CSI    = "csi"
+
+	// Error responses are kept up to the size in records
+	AUDIT_MAX_ERROR_SIZE = 1024
+	AUDIT_REDACTED       = "<redacted>"
+)
+
+var (
+	// Docker plugin calls changing volumes, the rest only query
+	auditPluginRoutes = map[string]bool{
+		"/VolumeDriver.Create":  true,
+		"/VolumeDriver.Remove":  true,
+		"/VolumeDriver.Mount":   true,
+		"/VolumeDriver.Unmount": true,
+	}
+)
+
+/*
+auditLog appends a record of every request changing anything, i.e. all but
+GET, to the file after the request is done, with caller, parameters and
+outcome of it. The file is opened for each record with O_APPEND and never
+rewritten by daemon, so it can be rotated or shipped by external tools.
+*/
+type auditLog struct {
+	file  string
+	mutex sync.Mutex
+}
+
+// initAudit starts audit log at file, AUDIT_LOG_FILE in root if it's empty
+func (s *daemon) initAudit(file string) error {
+	if file == "" {
+		file = filepath.Join(s.Root, AUDIT_LOG_FILE)
+	}
+	if err := util.MkdirIfNotExists(filepath.Dir(file)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("Cannot open audit log %v: %v", file, err)
+	}
+	f.Close()
+	s.audit = &auditLog{
+		file: file,
+	}
+	log.Debugf("Recording audit log at %v", file)
+	return nil
+}
+
+func (a *auditLog) record(record *api.AuditRecord) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(record); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	f, err := os.OpenFile(a.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+type auditCallerKey struct{}
+
+// withAuditCaller returns request carrying identity of its caller, e.g.
+// principal authenticated or user of the socket peer
+func withAuditCaller(r *http.Request, caller string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), auditCallerKey{}, caller))
+}
+
+// auditCaller returns identity of caller of the request, or its remote
+// address if it's unknown
+func auditCaller(r *http.Request) string {
+	if caller, ok := r.Context().Value(auditCallerKey{}).(string); ok && caller != "" {
+		return caller
+	}
+	if r.RemoteAddr != "" && r.RemoteAddr != "@" {
+		return r.RemoteAddr
+	}
+	return "unknown"
+}
+
+/*
+unixConnContext identifies the peer of connections to unix domain sockets by
+its credentials, so requests to the daemon socket, which are not
+authenticated, still tell the user and process calling.
+*/
+func unixConnContext(ctx context.Context, c net.Conn) context.Context {
+	conn, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return ctx
+	}
+	var cred *syscall.Ucred
+	if err := raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil || cred == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, auditCallerKey{}, fmt.Sprintf("uid=%v,gid=%v,pid=%v", cred.Uid, cred.Gid, cred.Pid))
+}
+
+// serveUnix serves h at unix domain socket listener l, with credentials of
+// peers for audit log
+func serveUnix(l net.Listener, h http.Handler) error {
+	server := &http.Server{
+		Handler:     h,
+		ConnContext: unixConnContext,
+	}
+	return server.Serve(l)
+}
+
+/*
+auditParameters returns parameters of request, i.e. its query and JSON body,
+with values of secrets like encryption keys and credentials redacted, along
+with the secrets, which may be echoed by errors. Body is kept for the
+handler. Archives imported are not read.
+*/
+func auditParameters(route string, r *http.Request) (map[string]interface{}, []string) {
+	params := make(map[string]interface{})
+	for k, v := range r.URL.Query() {
+		if len(v) == 1 {
+			params[k] = v[0]
+		} else {
+			params[k] = v
+		}
+	}
+	if route != "/backups/import" && r.Body != nil {
+		data, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(data))
+		if err == nil && len(data) != 0 {
+			body := make(map[string]interface{})
+			if err := json.Unmarshal(data, &body); err != nil {
+				params["Body"] = fmt.Sprintf("<%v bytes not in JSON>", len(data))
+			}
+			for k, v := range body {
+				params[k] = v
+			}
+		}
+	}
+	secrets := []string{}
+	redactParameters(params, &secrets)
+	return params, secrets
+}
+
+func isSecretParameter(key string) bool {
+	key = strings.ToLower(key)
+	// Names of keys are not secrets, e.g. KmsKeyID
+	if strings.HasSuffix(key, "keyid") || strings.HasSuffix(key, "keyname") {
+		return false
+	}
+	for _, secret := range []string{"key", "secret", "password", "token", "credential"} {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactParameters redacts secrets in v, which are added to secrets
+func redactParameters(v interface{}, secrets *[]string) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			if isSecretParameter(k) {
+				secret, ok := item.(string)
+				if ok && secret == "" {
+					continue
+				}
+				if ok {
+					*secrets = append(*secrets, secret)
+				}
+				value[k] = AUDIT_REDACTED
+				continue
+			}
+			value[k] = redactParameters(item, secrets)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactParameters(item, secrets)
+		}
+	case string:
+		// Credentials may be passed in URLs as well
+		if strings.Contains(value, "://") && strings.Contains(value, "@") {
+			if u, err := url.Parse(value); err == nil && u.User != nil {
+				if password, exists := u.User.Password(); exists && password != "" {
+					*secrets = append(*secrets, password)
+					u.User = url.UserPassword(u.User.Username(), AUDIT_REDACTED)
+					return u.String()
+				}
+			}
+		}
+	}
+	return v
+}
+
+// auditResponseWriter keeps status code and the beginning of response, which
+// has the error if any, for audit log
+type auditResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       []byte
+}
+
+func (w *auditResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if len(w.body) < AUDIT_MAX_ERROR_SIZE {
+		n := AUDIT_MAX_ERROR_SIZE - len(w.body)
+		if n > len(data) {
+			n = len(data)
+		}
+		w.body = append(w.body, data[:n]...)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+/*
+auditHandler records requests to h in the audit log. Failing to record
+doesn't fail the request, since it has already been done, but is logged as
+error.
+*/
+func (s *daemon) auditHandler(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.audit == nil {
+			h(w, r)
+			return
+		}
+		params, secrets := auditParameters(route, r)
+		record := &api.AuditRecord{
+			Time:       util.Now(),
+			Caller:     auditCaller(r),
+			Method:     r.Method,
+			Route:      route,
+			Parameters: params,
+		}
+		// Unix domain sockets have no remote address
+		if r.RemoteAddr != "@" {
+			record.RemoteAddr = r.RemoteAddr
+		}
+		// Volumes must be found before the request, e.g. snapshots
+		// deleted cannot tell their volumes afterwards
+		if volumes, err := s.requestVolumes(route, r); err == nil {
+			record.Volumes = volumes
+		}
+
+		start := time.Now()
+		rw := &auditResponseWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		h(rw, r)
+
+		record.Duration = time.Since(start).String()
+		record.StatusCode = rw.statusCode
+		if rw.statusCode >= http.StatusBadRequest {
+			record.Error = strings.TrimSpace(string(rw.body))
+		} else if auditPluginRoutes[route] {
+			// Docker plugin calls fail with Err of response
+			resp := &pluginResponse{}
+			if err := json.Unmarshal(rw.body, resp); err == nil {
+				record.Error = resp.Err
+			}
+		}
+		if record.Error != "" {
+			for _, secret := range secrets {
+				record.Error = strings.Replace(record.Error, secret, AUDIT_REDACTED, -1)
+			}
+		}
+		if err := s.audit.record(record); err != nil {
+			log.Errorf("Failed to record %v %v by %v in audit log: %v", r.Method, route, record.Caller, err)
+		}
+	}
+}
+
+// auditPluginHandler records Docker plugin calls changing volumes in the
+// audit log, with caller of AUDIT_CALLER_DOCKER
+func (s *daemon) auditPluginHandler(route string, h http.HandlerFunc) http.HandlerFunc {
+	if !auditPluginRoutes[route] {
+		return h
+	}
+	audited := s.auditHandler(route, h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller := AUDIT_CALLER_DOCKER
+		if peer, ok := r.Context().Value(auditCallerKey{}).(string); ok {
+			caller += "," + peer
+		}
+		audited(w, withAuditCaller(r, caller))
+	}
+}
+
+// auditQuery is the query of /audit, see docs/api.md
+type auditQuery struct {
+	since  time.Time
+	until  time.Time
+	volume string
+	caller string
+	limit  int
+}
+
+func parseAuditQuery(r *http.Request) (*auditQuery, error) {
+	query := r.URL.Query()
+	q := &auditQuery{
+		volume: query.Get("volume"),
+		caller: query.Get("caller"),
+	}
+	now := time.Now()
+	var err error
+	if since := query.Get("since"); since != "" {
+		if q.since, err = parseTimeBound(since, now, false); err != nil {
+			return nil, err
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if q.until, err = parseTimeBound(until, now, true); err != nil {
+			return nil, err
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if q.limit, err = strconv.Atoi(limit); err != nil || q.limit < 0 {
+			return nil, fmt.Errorf("Invalid limit %v, should be a non-negative integer", limit)
+		}
+	}
+	return q, nil
+}
+
+func (q *auditQuery) match(record *api.AuditRecord) bool {
+	t := parseTime(record.Time)
+	if !q.since.IsZero() && t.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && !t.Before(q.until) {
+		return false
+	}
+	if q.caller != "" && record.Caller != q.caller {
+		return false
+	}
+	if q.volume != "" && !containsString(record.Volumes, q.volume) {
+		return false
+	}
+	return true
+}
+
+/*
+doAudit returns records of the audit log matching the query, in order they
+were recorded. Only the latest ones are returned with limit.
+*/
+func (s *daemon) doAudit(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	if s.audit == nil {
+		return APIError{
+			statusCode: http.StatusNotFound,
+			error:      "Audit log is disabled",
+		}
+	}
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(s.audit.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records := []*api.AuditRecord{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		record := &api.AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			log.Warnf("Skipping invalid record in audit log %v: %v", s.audit.file, err)
+			continue
+		}
+		if !q.match(record) {
+			continue
+		}
+		records = append(records, record)
+		if q.limit != 0 && len(records) > q.limit {
+			records = records[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return writeResponseOutput(w, records)
+}
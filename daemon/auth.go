@@ -0,0 +1,416 @@
+package daemon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	AUTH_ROLE_READONLY = "readonly"
+	AUTH_ROLE_ADMIN    = "admin"
+
+	AUTH_TOKEN_PREFIX = "Bearer "
+
+	// Clients failing to authenticate AUTH_FAILURE_BURST times are only
+	// allowed to try again at AUTH_FAILURE_RATE per second, so tokens
+	// cannot be guessed by brute force
+	AUTH_FAILURE_RATE  = 0.2
+	AUTH_FAILURE_BURST = 10
+)
+
+var (
+	authVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+	// Requests changing more than the volumes they name, e.g. every backup
+	// of the destination, or making daemon run executables as root, cannot
+	// be limited to volumes, so they are denied for principals with volume
+	// prefixes even if volumes they name match
+	authUnscopedRoutes = map[string]bool{
+		"/backups/index":      true,
+		"/backups/migrate":    true,
+		"/backups/rotate-key": true,
+		"/hooks/set":          true,
+	}
+)
+
+/*
+authPrincipal is a client of the APIs listening at TCP addresses, identified by
+a bearer token, whose SHA-256 is kept rather than the token itself, or by
+common name of its client certificate verified by --tls-client-ca.
+*/
+type authPrincipal struct {
+	Name           string
+	TokenSHA256    string
+	CommonName     string
+	Role           string
+	VolumePrefixes []string
+}
+
+type authConfig struct {
+	Principals []*authPrincipal
+
+	// Guards Principals, which are replaced on reload
+	mutex sync.RWMutex
+
+	// Failed authentications of clients, by address without port
+	failures *util.RateLimiter
+}
+
+// authThrottledError rejects clients failed to authenticate too many times,
+// before their credentials are checked
+type authThrottledError struct {
+	client string
+	wait   time.Duration
+}
+
+func (e *authThrottledError) Error() string {
+	return fmt.Sprintf("Too many failed authentications from %v, retry after %v", e.client, e.wait)
+}
+
+func loadAuthConfig(file string) (*authConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := &authConfig{
+		failures: util.NewRateLimiter(&util.RateLimit{
+			Rate:  AUTH_FAILURE_RATE,
+			Burst: AUTH_FAILURE_BURST,
+		}, nil),
+	}
+	if err := util.DecodeYAML(data, config); err != nil {
+		return nil, fmt.Errorf("Invalid auth config %v: %v", file, err)
+	}
+	if len(config.Principals) == 0 {
+		return nil, fmt.Errorf("No principal found in auth config %v", file)
+	}
+	names := make(map[string]bool)
+	for _, p := range config.Principals {
+		if p.Name == "" {
+			return nil, fmt.Errorf("Principal without name in auth config %v", file)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("Duplicate principal %v in auth config %v", p.Name, file)
+		}
+		names[p.Name] = true
+		if p.TokenSHA256 == "" && p.CommonName == "" {
+			return nil, fmt.Errorf("Principal %v needs tokenSHA256 or commonName", p.Name)
+		}
+		if p.TokenSHA256 != "" {
+			if b, err := hex.DecodeString(p.TokenSHA256); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("Invalid tokenSHA256 of principal %v, should be hex encoded SHA-256 of the token", p.Name)
+			}
+			p.TokenSHA256 = strings.ToLower(p.TokenSHA256)
+		}
+		if p.Role != AUTH_ROLE_READONLY && p.Role != AUTH_ROLE_ADMIN {
+			return nil, fmt.Errorf("Invalid role %q of principal %v, should be %v or %v",
+				p.Role, p.Name, AUTH_ROLE_READONLY, AUTH_ROLE_ADMIN)
+		}
+		for _, prefix := range p.VolumePrefixes {
+			if prefix == "" {
+				return nil, fmt.Errorf("Empty volume prefix of principal %v", p.Name)
+			}
+		}
+	}
+	return config, nil
+}
+
+// initAuth loads principals allowed to use the APIs listening with --listen
+// and --grpc-listen. Empty file leaves them open to every client passing TLS.
+func (s *daemon) initAuth(file string, listening bool) error {
+	if file == "" {
+		return nil
+	}
+	if !listening {
+		return fmt.Errorf("--auth-config is only used with --listen or --grpc-listen")
+	}
+	config, err := loadAuthConfig(file)
+	if err != nil {
+		return err
+	}
+	s.auth = config
+	return nil
+}
+
+// update replaces principals by the ones of config reloaded
+func (c *authConfig) update(config *authConfig) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.Principals = config.Principals
+}
+
+// authenticate returns principal of the request, by its bearer token or
+// else its verified client certificate. Clients failed too many times are
+// rejected with authThrottledError.
+func (c *authConfig) authenticate(r *http.Request) (*authPrincipal, error) {
+	client := authClient(r)
+	if wait := c.failures.Wait(client); wait > 0 {
+		return nil, &authThrottledError{
+			client: client,
+			wait:   wait,
+		}
+	}
+	p, err := c.authenticatePrincipal(r)
+	if err != nil {
+		c.failures.Allow(client)
+		return nil, err
+	}
+	return p, nil
+}
+
+func (c *authConfig) authenticatePrincipal(r *http.Request) (*authPrincipal, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if header := r.Header.Get("Authorization"); header != "" {
+		if !strings.HasPrefix(header, AUTH_TOKEN_PREFIX) {
+			return nil, fmt.Errorf("Unsupported authorization scheme")
+		}
+		sum := sha256.Sum256([]byte(strings.TrimPrefix(header, AUTH_TOKEN_PREFIX)))
+		hash := []byte(hex.EncodeToString(sum[:]))
+		for _, p := range c.Principals {
+			if p.TokenSHA256 != "" && subtle.ConstantTimeCompare(hash, []byte(p.TokenSHA256)) == 1 {
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("Invalid token")
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+		cn := r.TLS.PeerCertificates[0].Subject.CommonName
+		for _, p := range c.Principals {
+			if p.CommonName != "" && p.CommonName == cn {
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("No principal of client certificate %v", cn)
+	}
+	return nil, fmt.Errorf("Token or client certificate required")
+}
+
+// authClient returns address of the client without port, so failures of
+// its connections count together
+func authClient(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// authRequest has fields of API requests naming the volumes they operate on
+type authRequest struct {
+	ID             string
+	Name           string
+	VolumeName     string
+	VolumeNames    []string
+	SnapshotName   string
+	URL            string
+	BackupURL      string
+	DriverVolumeID string
+	Volumes        []api.VolumeCreateRequest
+	// Options of Docker plugin requests
+	Opts map[string]string
+}
+
+// backupURLVolume returns volume of the backup or destination URL, empty if
+// it has none
+func backupURLVolume(backupURL string) string {
+	u, err := url.Parse(util.UnescapeURL(backupURL))
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("volume")
+}
+
+/*
+sourceVolumes returns volumes a new volume would be created from, i.e. the
+volume backed up by backupURL. Volumes imported by driverVolumeID are refused,
+since their names cannot be told.
+*/
+func sourceVolumes(backupURL, driverVolumeID string) ([]string, error) {
+	if driverVolumeID != "" {
+		return nil, fmt.Errorf("Cannot import volume %v of driver, its name cannot be checked against volume prefixes", driverVolumeID)
+	}
+	if backupURL == "" {
+		return nil, nil
+	}
+	volumeName := backupURLVolume(backupURL)
+	if volumeName == "" {
+		return nil, fmt.Errorf("Cannot find volume of backup %v", backupURL)
+	}
+	return []string{volumeName}, nil
+}
+
+/*
+requestVolumes returns volumes the request would change, read from its body,
+which is kept for the handler. Empty means the request isn't limited to
+volumes, e.g. a global hook, or they cannot be told, e.g. imported archives.
+*/
+func (s *daemon) requestVolumes(route string, r *http.Request) ([]string, error) {
+	if route == "/backups/import" || r.Body == nil {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	req := &authRequest{}
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, req); err != nil {
+			return nil, nil
+		}
+	}
+	volumes := []string{}
+	switch route {
+	case "/volumes/create", "/VolumeDriver.Create":
+		backupURL, driverVolumeID := req.BackupURL, req.DriverVolumeID
+		if route == "/VolumeDriver.Create" {
+			backupURL, driverVolumeID = req.Opts["backup"], req.Opts["id"]
+		}
+		sources, err := sourceVolumes(backupURL, driverVolumeID)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, req.Name)
+		volumes = append(volumes, sources...)
+	case "/VolumeDriver.Remove", "/VolumeDriver.Mount", "/VolumeDriver.Unmount":
+		volumes = append(volumes, req.Name)
+	case "/volumes/clone":
+		volumes = append(volumes, req.VolumeName, req.Name)
+	case "/volumes/restore":
+		for _, v := range req.Volumes {
+			sources, err := sourceVolumes(v.BackupURL, v.DriverVolumeID)
+			if err != nil {
+				return nil, err
+			}
+			volumes = append(volumes, v.Name)
+			volumes = append(volumes, sources...)
+		}
+	case "/jobs/cancel":
+		if volumeName := s.jobVolume(req.ID); volumeName != "" {
+			volumes = append(volumes, volumeName)
+		}
+	default:
+		if req.VolumeName != "" {
+			volumes = append(volumes, req.VolumeName)
+		}
+		volumes = append(volumes, req.VolumeNames...)
+		if req.SnapshotName != "" {
+			volumeName := s.SnapshotVolumeIndex.Get(req.SnapshotName)
+			if volumeName == "" {
+				return nil, fmt.Errorf("Cannot find volume of snapshot %v", req.SnapshotName)
+			}
+			volumes = append(volumes, volumeName)
+		}
+		if req.URL != "" {
+			// Destinations without volume, e.g. of schedules, are not
+			// limited to a volume by themselves
+			if volumeName := backupURLVolume(req.URL); volumeName != "" {
+				volumes = append(volumes, volumeName)
+			}
+		}
+	}
+	return volumes, nil
+}
+
+/*
+authorize checks the principal can make the request. Read-only principals
+can only get, admins can change as well. Changes by principals with volume
+prefixes are limited to requests naming volumes, all of which must start
+with one of the prefixes, and not in authUnscopedRoutes. Reads are not
+limited by prefixes.
+*/
+func (s *daemon) authorize(p *authPrincipal, r *http.Request) error {
+	if r.Method == "GET" {
+		return nil
+	}
+	if p.Role != AUTH_ROLE_ADMIN {
+		return fmt.Errorf("Principal %v is %v", p.Name, p.Role)
+	}
+	if len(p.VolumePrefixes) == 0 {
+		return nil
+	}
+	route := authVersionPrefix.ReplaceAllString(r.URL.Path, "")
+	if authUnscopedRoutes[route] {
+		return fmt.Errorf("Principal %v is limited to volumes with prefixes %v, but %v %v isn't limited to volumes",
+			p.Name, p.VolumePrefixes, r.Method, route)
+	}
+	volumes, err := s.requestVolumes(route, r)
+	if err != nil {
+		return err
+	}
+	if len(volumes) == 0 {
+		return fmt.Errorf("Principal %v is limited to volumes with prefixes %v, but %v %v isn't limited to volumes",
+			p.Name, p.VolumePrefixes, r.Method, route)
+	}
+	for _, volume := range volumes {
+		matched := false
+		for _, prefix := range p.VolumePrefixes {
+			if strings.HasPrefix(volume, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("Principal %v is limited to volumes with prefixes %v, not %v",
+				p.Name, p.VolumePrefixes, volume)
+		}
+	}
+	return nil
+}
+
+// authHandler authenticates and authorizes requests to h, if principals
+// are configured
+func (s *daemon) authHandler(h http.Handler) http.Handler {
+	if s.auth == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := s.auth.authenticate(r)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_FAILURE,
+				LOG_FIELD_EVENT:  LOG_EVENT_AUTH,
+			}).Warnf("Unauthenticated %v %v from %v: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			if e, ok := err.(*authThrottledError); ok {
+				w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(e.wait.Seconds())), 10))
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err := s.authorize(p, r); err != nil {
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_FAILURE,
+				LOG_FIELD_EVENT:  LOG_EVENT_AUTH,
+			}).Warnf("Denied %v %v from %v: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, withAuditCaller(r, "principal="+p.Name))
+	})
+}
@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/rancher/convoy/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func newTestAuthConfig(principals ...*authPrincipal) *authConfig {
+	return &authConfig{
+		Principals: principals,
+		failures: util.NewRateLimiter(&util.RateLimit{
+			Rate:  AUTH_FAILURE_RATE,
+			Burst: AUTH_FAILURE_BURST,
+		}, nil),
+	}
+}
+
+func tokenSHA256(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAuthRequest(method, route, body, token, remoteAddr string) *http.Request {
+	r := httptest.NewRequest(method, route, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", AUTH_TOKEN_PREFIX+token)
+	}
+	r.RemoteAddr = remoteAddr
+	return r
+}
+
+func (s *TestSuite) TestAuthorize(c *C) {
+	d := &daemon{
+		SnapshotVolumeIndex: util.NewIndex(),
+	}
+	c.Assert(d.SnapshotVolumeIndex.Add("ci-db-snap", "ci-db"), IsNil)
+	c.Assert(d.SnapshotVolumeIndex.Add("prod-snap", "prod"), IsNil)
+
+	reader := &authPrincipal{Name: "reader", Role: AUTH_ROLE_READONLY}
+	admin := &authPrincipal{Name: "admin", Role: AUTH_ROLE_ADMIN}
+	ci := &authPrincipal{Name: "ci", Role: AUTH_ROLE_ADMIN, VolumePrefixes: []string{"ci-"}}
+
+	testCases := []struct {
+		method  string
+		route   string
+		body    string
+		allowed bool
+	}{
+		{"GET", "/v1/volumes/list", "", true},
+		{"POST", "/v1/volumes/create", `{"Name":"ci-db"}`, true},
+		{"POST", "/v1/volumes/create", `{"Name":"prod"}`, false},
+		{"POST", "/v1/volumes/create", `{"Name":"ci-db","BackupURL":"vfs:///backups?backup=b1&volume=ci-old"}`, true},
+		{"POST", "/v1/volumes/create", `{"Name":"ci-db","BackupURL":"vfs:///backups?backup=b1&volume=prod"}`, false},
+		{"POST", "/v1/volumes/create", `{"Name":"ci-db","DriverVolumeID":"vol-0123456789abcdef0"}`, false},
+		{"POST", "/VolumeDriver.Create", `{"Name":"ci-db","Opts":{"backup":"vfs:///backups?backup=b1&volume=prod"}}`, false},
+		{"POST", "/VolumeDriver.Create", `{"Name":"ci-db","Opts":{"id":"vol-0123456789abcdef0"}}`, false},
+		{"POST", "/VolumeDriver.Mount", `{"Name":"ci-db"}`, true},
+		{"POST", "/VolumeDriver.Remove", `{"Name":"prod"}`, false},
+		{"POST", "/v1/volumes/mount", `{"VolumeName":"ci-db"}`, true},
+		{"POST", "/v1/volumes/mount", `{"VolumeName":"prod"}`, false},
+		{"DELETE", "/v1/volumes/", `{"VolumeName":"prod"}`, false},
+		{"POST", "/v1/volumes/clone", `{"VolumeName":"ci-db","Name":"ci-db-copy"}`, true},
+		{"POST", "/v1/volumes/clone", `{"VolumeName":"ci-db","Name":"prod"}`, false},
+		{"POST", "/v1/volumes/clone", `{"VolumeName":"prod","Name":"ci-db-copy"}`, false},
+		{"POST", "/v1/volumes/clone", `{"VolumeName":"ci-db"}`, false},
+		{"POST", "/v1/volumes/restore", `{"Volumes":[{"Name":"ci-a"},{"Name":"ci-b"}]}`, true},
+		{"POST", "/v1/volumes/restore", `{"Volumes":[{"Name":"ci-a"},{"Name":"prod"}]}`, false},
+		{"POST", "/v1/volumes/restore", `{"Volumes":[{"Name":"ci-a","BackupURL":"vfs:///backups?backup=b1&volume=ci-a"}]}`, true},
+		{"POST", "/v1/volumes/restore", `{"Volumes":[{"Name":"ci-a","BackupURL":"vfs:///backups?backup=b1&volume=prod"}]}`, false},
+		{"POST", "/v1/snapshots/create", `{"VolumeName":"ci-db"}`, true},
+		{"DELETE", "/v1/snapshots/", `{"SnapshotName":"ci-db-snap"}`, true},
+		{"DELETE", "/v1/snapshots/", `{"SnapshotName":"prod-snap"}`, false},
+		{"DELETE", "/v1/snapshots/", `{"SnapshotName":"nonexistent"}`, false},
+		{"DELETE", "/v1/backups", `{"URL":"vfs:///backups?backup=b1&volume=ci-db"}`, true},
+		{"DELETE", "/v1/backups", `{"URL":"vfs:///backups?backup=b1&volume=prod"}`, false},
+		{"POST", "/v1/backups/prune", `{"URL":"vfs:///backups","VolumeName":"ci-db"}`, true},
+		{"POST", "/v1/backups/prune", `{"URL":"vfs:///backups"}`, false},
+		{"POST", "/v1/backups/rotate-key", `{"URL":"vfs:///backups?volume=ci-db","Key":"key:env:KEY"}`, false},
+		{"POST", "/v1/backups/rotate-key", `{"URL":"vfs:///backups","Key":"key:env:KEY"}`, false},
+		{"POST", "/v1/backups/migrate", `{"URL":"vfs:///backups?volume=ci-db"}`, false},
+		{"POST", "/v1/backups/index", `{"URL":"vfs:///backups?volume=ci-db"}`, false},
+		{"POST", "/v1/backups/import", `{"VolumeName":"ci-db"}`, false},
+		{"POST", "/v1/hooks/set", `{"VolumeName":"ci-db","PreSnapshot":"/bin/sh"}`, false},
+		{"DELETE", "/v1/hooks", `{"VolumeName":"ci-db"}`, true},
+		{"POST", "/v1/schedules/set", `{"VolumeName":"ci-db","Interval":"1h"}`, true},
+		{"POST", "/v1/schedules/export", `{"VolumeNames":["ci-a","prod"]}`, false},
+		{"POST", "/v1/schedules/export", `{}`, false},
+		{"POST", "/v1/logging/set", `{"Level":"debug"}`, false},
+		{"POST", "/v1/maintenance/set", `{"Enabled":true}`, false},
+	}
+	for _, t := range testCases {
+		comment := Commentf("%v %v %v", t.method, t.route, t.body)
+
+		err := d.authorize(ci, newAuthRequest(t.method, t.route, t.body, "", ""))
+		if t.allowed {
+			c.Assert(err, IsNil, comment)
+		} else {
+			c.Assert(err, NotNil, comment)
+		}
+
+		// Admins without prefixes can do anything, read-only principals
+		// can only get
+		c.Assert(d.authorize(admin, newAuthRequest(t.method, t.route, t.body, "", "")), IsNil, comment)
+		err = d.authorize(reader, newAuthRequest(t.method, t.route, t.body, "", ""))
+		if t.method == "GET" {
+			c.Assert(err, IsNil, comment)
+		} else {
+			c.Assert(err, NotNil, comment)
+		}
+	}
+
+	// Body is kept for the handler
+	body := `{"VolumeName":"ci-db"}`
+	r := newAuthRequest("POST", "/v1/volumes/mount", body, "", "")
+	c.Assert(d.authorize(ci, r), IsNil)
+	data, err := ioutil.ReadAll(r.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, body)
+}
+
+func (s *TestSuite) TestAuthFailuresThrottled(c *C) {
+	d := &daemon{
+		auth: newTestAuthConfig(&authPrincipal{
+			Name:        "admin",
+			Role:        AUTH_ROLE_ADMIN,
+			TokenSHA256: tokenSHA256("secret"),
+		}),
+	}
+	h := d.authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(token, remoteAddr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newAuthRequest("GET", "/v1/info", "", token, remoteAddr))
+		return w
+	}
+
+	c.Assert(serve("secret", "10.0.0.1:1000").Code, Equals, http.StatusOK)
+	for i := 0; i < AUTH_FAILURE_BURST; i++ {
+		c.Assert(serve("guess", "10.0.0.1:1000").Code, Equals, http.StatusUnauthorized)
+	}
+	// Failures of connections from the same address count together, and
+	// even the right token is rejected until the client is allowed to try
+	// again
+	w := serve("secret", "10.0.0.1:2000")
+	c.Assert(w.Code, Equals, http.StatusTooManyRequests)
+	c.Assert(w.Header().Get("Retry-After"), Not(Equals), "")
+	c.Assert(serve("", "10.0.0.1:2000").Code, Equals, http.StatusTooManyRequests)
+
+	// Other clients are not affected
+	c.Assert(serve("secret", "10.0.0.2:1000").Code, Equals, http.StatusOK)
+	c.Assert(serve("guess", "10.0.0.2:1000").Code, Equals, http.StatusUnauthorized)
+}
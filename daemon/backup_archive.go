@@ -0,0 +1,75 @@
+package daemon
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+// writeCounter counts bytes written, to tell whether response has started
+type writeCounter struct {
+	w       io.Writer
+	written int64
+}
+
+func (c *writeCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+/*
+doBackupExport streams archive of the backup as response. Once streaming has
+started, errors abort the response instead, so clients won't take a truncated
+archive as complete.
+*/
+func (s *daemon) doBackupExport(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupExportRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+	if _, err := objectstore.GetObjectStoreDriver(request.URL); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	counter := &writeCounter{w: w}
+	if err := objectstore.ExportBackup(request.URL, counter); err != nil {
+		if counter.written == 0 {
+			return err
+		}
+		log.Errorf("Failed to export backup %v after %v bytes: %v", request.URL, counter.written, err)
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
+
+// doBackupImport imports archive of backup in request body into destination
+// in query "dest"
+func (s *daemon) doBackupImport(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	query := r.URL.Query()
+	destURL := util.UnescapeURL(query.Get("dest"))
+	backupURL, err := objectstore.ImportBackup(destURL, r.Body)
+	if err != nil {
+		return err
+	}
+	if objVolume, err := objectstore.LoadVolume(backupURL); err == nil && s.getVolume(objVolume.Name) != nil {
+		s.recordEvent(objVolume.Name, LOG_OBJECT_BACKUP_URL, LOG_EVENT_IMPORT, backupURL, "")
+	}
+
+	backup := &api.BackupURLResponse{
+		URL: backupURL,
+	}
+	if query.Get("verbose") == "true" {
+		return sendResponse(w, backup)
+	}
+	escapedURL := strings.Replace(backupURL, "&", "\\u0026", 1)
+	return writeStringResponse(w, escapedURL)
+}
@@ -0,0 +1,28 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rancher/convoy/objectstore"
+)
+
+/*
+parseBackupCompression parses spec in the form of "<compression>" for the
+default compression of blocks, or "<url>=<compression>" for the destination,
+where compression is e.g. none, gzip-9, lz4 or zstd.
+*/
+func parseBackupCompression(spec string) error {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) == 1 {
+		if err := objectstore.SetDefaultCompression(strings.TrimSpace(spec)); err != nil {
+			return fmt.Errorf("Invalid default backup compression: %v", err)
+		}
+		return nil
+	}
+	destURL := strings.TrimSpace(parts[0])
+	if err := objectstore.SetCompression(destURL, strings.TrimSpace(parts[1])); err != nil {
+		return fmt.Errorf("Failed to set backup compression of %v: %v", destURL, err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package daemon
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	BACKUP_ENCRYPTION_KEY        = "key"
+	BACKUP_ENCRYPTION_PASSPHRASE = "passphrase"
+)
+
+/*
+parseBackupEncryption parses spec in the form of "<url>=key:<secret>" or
+"<url>=passphrase:<secret>", where secret is file:<path> or env:<name>, and
+enables encryption of the destination. Key is base64 encoded 32 bytes.
+*/
+func parseBackupEncryption(spec string) error {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("Invalid backup encryption %q, should be <url>=%v:<secret> or <url>=%v:<secret>",
+			spec, BACKUP_ENCRYPTION_KEY, BACKUP_ENCRYPTION_PASSPHRASE)
+	}
+	destURL := strings.TrimSpace(parts[0])
+	encryption, err := parseEncryptionKey(destURL, parts[1])
+	if err != nil {
+		return err
+	}
+	if err := objectstore.SetEncryption(destURL, encryption); err != nil {
+		return fmt.Errorf("Failed to enable backup encryption of %v: %v", destURL, err)
+	}
+	return nil
+}
+
+// parseEncryptionKey parses key of destURL in the form of "key:<secret>" or
+// "passphrase:<secret>"
+func parseEncryptionKey(destURL, spec string) (objectstore.Encryption, error) {
+	encryption := objectstore.Encryption{}
+	kind := strings.SplitN(spec, ":", 2)
+	if len(kind) != 2 {
+		return encryption, fmt.Errorf("Invalid backup encryption of %v, should be %v:<secret> or %v:<secret>",
+			destURL, BACKUP_ENCRYPTION_KEY, BACKUP_ENCRYPTION_PASSPHRASE)
+	}
+
+	switch kind[0] {
+	case BACKUP_ENCRYPTION_KEY:
+		encoded, err := getSecret(kind[1], "backup encryption key")
+		if err != nil {
+			return encryption, err
+		}
+		if encryption.Key, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return encryption, fmt.Errorf("Invalid backup encryption key from %v, should be base64 encoded: %v", kind[1], err)
+		}
+	case BACKUP_ENCRYPTION_PASSPHRASE:
+		passphrase, err := getSecret(kind[1], "backup encryption passphrase")
+		if err != nil {
+			return encryption, err
+		}
+		encryption.Passphrase = passphrase
+	default:
+		return encryption, fmt.Errorf("Invalid backup encryption of %v, should be %v:<secret> or %v:<secret>",
+			destURL, BACKUP_ENCRYPTION_KEY, BACKUP_ENCRYPTION_PASSPHRASE)
+	}
+	return encryption, nil
+}
+
+func (s *daemon) doBackupRotateKey(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupRotateKeyRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	encryption, err := parseEncryptionKey(request.URL, request.Key)
+	if err != nil {
+		return err
+	}
+	s.destLocks.Lock(request.URL)
+	defer s.destLocks.Unlock(request.URL)
+
+	keyVersion, err := objectstore.RotateEncryptionKey(request.URL, encryption)
+	if err != nil {
+		return err
+	}
+	return sendResponse(w, map[string]string{
+		"DestURL":              request.URL,
+		"EncryptionKeyVersion": strconv.Itoa(keyVersion),
+	})
+}
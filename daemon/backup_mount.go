@@ -0,0 +1,132 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	BACKUP_MOUNT_VOLUME_PREFIX = "backup-mount"
+	// Label of volumes backups are mounted from, with the URL of the backup
+	LABEL_BACKUP_MOUNT = "convoy.backup-mount"
+)
+
+// getBackupMountDriver returns the driver backup would be restored by, the
+// driver it's backed up from if it's here, or the default driver
+func (s *daemon) getBackupMountDriver(backupURL, driverName string) string {
+	if driverName != "" {
+		return driverName
+	}
+	u, err := url.Parse(backupURL)
+	if err == nil && stringListContains(objectstore.ListDrivers(), u.Scheme) {
+		info, err := objectstore.GetBackupInfo(backupURL)
+		if err == nil {
+			if _, err := s.getDriver(info["DriverName"]); err == nil {
+				return info["DriverName"]
+			}
+		}
+	}
+	return s.DefaultDriver
+}
+
+/*
+processBackupMount restores the backup into a temporary volume and mounts it
+read-only, so files can be copied out of the backup without restoring it into
+a volume to keep. The volume is labeled with the backup, and deleted if it
+cannot be mounted.
+*/
+func (s *daemon) processBackupMount(ctx context.Context, request *api.BackupMountRequest) (*Volume, string, error) {
+	driverName := s.getBackupMountDriver(request.URL, request.DriverName)
+	if err := s.checkCapability(driverName, CAPABILITY_READ_ONLY_MOUNT); err != nil {
+		return nil, "", err
+	}
+	volume, err := s.processVolumeCreate(ctx, &api.VolumeCreateRequest{
+		Name:       util.GenerateName(BACKUP_MOUNT_VOLUME_PREFIX),
+		DriverName: driverName,
+		BackupURL:  request.URL,
+		Labels: map[string]string{
+			LABEL_BACKUP_MOUNT: request.URL,
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	mountPoint, err := s.processVolumeMount(ctx, volume, &api.VolumeMountRequest{
+		VolumeName: volume.Name,
+		MountPoint: request.MountPoint,
+		ReadOnly:   true,
+	})
+	if err != nil {
+		if err := s.processVolumeDelete(ctx, &api.VolumeDeleteRequest{
+			VolumeName: volume.Name,
+		}); err != nil {
+			log.Warnf("Failed to delete volume %v of backup %v: %v", volume.Name, request.URL, err)
+		}
+		return nil, "", err
+	}
+	return volume, mountPoint, nil
+}
+
+// processBackupUmount umounts and deletes the volume the backup is mounted
+// from. Volumes not created by backup mount are refused.
+func (s *daemon) processBackupUmount(ctx context.Context, volume *Volume) error {
+	labels, err := s.getVolumeLabels(volume.Name)
+	if err != nil {
+		return err
+	}
+	if _, exists := labels[LABEL_BACKUP_MOUNT]; !exists {
+		return fmt.Errorf("Volume %v is not a mounted backup", volume.Name)
+	}
+	if err := s.processVolumeUmount(ctx, volume); err != nil {
+		return err
+	}
+	return s.processVolumeDelete(ctx, &api.VolumeDeleteRequest{
+		VolumeName: volume.Name,
+	})
+}
+
+func (s *daemon) doBackupMount(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupMountRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	volume, mountPoint, err := s.processBackupMount(r.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	if request.Verbose {
+		return writeResponseOutput(w, api.VolumeResponse{
+			Name:       volume.Name,
+			Driver:     volume.DriverName,
+			MountPoint: mountPoint,
+		})
+	}
+	return writeStringResponse(w, mountPoint)
+}
+
+func (s *daemon) doBackupUmount(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupUmountRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+
+	volumeName := request.VolumeName
+	if err := util.CheckName(volumeName); err != nil {
+		return err
+	}
+	volume := s.getVolume(volumeName)
+	if volume == nil {
+		return fmt.Errorf("volume %v doesn't exist", volumeName)
+	}
+
+	return s.processBackupUmount(r.Context(), volume)
+}
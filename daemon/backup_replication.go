@@ -0,0 +1,129 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	// Backups waiting to be mirrored, more would be dropped
+	BACKUP_MIRROR_QUEUE_SIZE = 1000
+)
+
+type backupMirror struct {
+	volumeName string
+	backupURL  string
+}
+
+// parseBackupMirror parses spec in the form of "<url>=<mirror-url>", so
+// backups created in url would be replicated to mirror-url
+func parseBackupMirror(spec string) error {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("Invalid backup mirror %v, should be <url>=<mirror-url>", spec)
+	}
+	destURL := strings.TrimSpace(parts[0])
+	if err := objectstore.SetMirror(destURL, strings.TrimSpace(parts[1])); err != nil {
+		return fmt.Errorf("Failed to set backup mirror of %v: %v", destURL, err)
+	}
+	return nil
+}
+
+// processBackupReplicate replicates the backup to destURL, and records it in
+// timeline of the volume if it's here
+func (s *daemon) processBackupReplicate(backupURL, destURL string) (string, error) {
+	objVolume, err := objectstore.LoadVolume(backupURL)
+	if err != nil {
+		return "", err
+	}
+	if err := s.checkDestinationHealth(destURL, objVolume.Name); err != nil {
+		return "", err
+	}
+	replicaURL, err := objectstore.ReplicateBackup(backupURL, destURL)
+	if err != nil {
+		return "", err
+	}
+	if s.getVolume(objVolume.Name) != nil {
+		s.recordEvent(objVolume.Name, LOG_OBJECT_BACKUP_URL, LOG_EVENT_REPLICATE, replicaURL, "from "+backupURL)
+	}
+	return replicaURL, nil
+}
+
+// startBackupMirrors starts mirroring backups queued one at a time, in the
+// order they're created, so retention in mirrors sees them in order as well
+func (s *daemon) startBackupMirrors() {
+	s.mirrorQueue = make(chan *backupMirror, BACKUP_MIRROR_QUEUE_SIZE)
+	go func() {
+		for m := range s.mirrorQueue {
+			s.mirrorBackup(m.volumeName, m.backupURL)
+		}
+	}()
+}
+
+// queueBackupMirror queues the backup just created for mirroring if its
+// destination has mirrors
+func (s *daemon) queueBackupMirror(volumeName, backupURL string) {
+	if s.mirrorQueue == nil || len(objectstore.GetMirrors(backupURL)) == 0 {
+		return
+	}
+	select {
+	case s.mirrorQueue <- &backupMirror{
+		volumeName: volumeName,
+		backupURL:  backupURL,
+	}:
+	default:
+		log.Warnf("Too many backups waiting to be mirrored, drop backup %v of volume %v", backupURL, volumeName)
+	}
+}
+
+/*
+mirrorBackup replicates the backup to mirrors of its destination, then prunes
+backups of the volume in mirrors if retention applies there. Failures are
+only logged, the backup has been made anyway and can be replicated again
+later.
+*/
+func (s *daemon) mirrorBackup(volumeName, backupURL string) {
+	for _, mirrorURL := range objectstore.GetMirrors(backupURL) {
+		if _, err := s.processBackupReplicate(backupURL, mirrorURL); err != nil {
+			log.Warnf("Failed to mirror backup %v to %v: %v", backupURL, mirrorURL, err)
+			continue
+		}
+		policy, err := objectstore.GetRetention(mirrorURL, volumeName)
+		if err == nil && policy != nil {
+			_, err = s.pruneVolumeBackups(mirrorURL, volumeName, policy, false)
+		}
+		if err != nil {
+			log.Warnf("Failed to prune backups of volume %v in mirror %v: %v", volumeName, mirrorURL, err)
+		}
+	}
+}
+
+func (s *daemon) doBackupReplicate(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupReplicateRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+	request.DestURL = util.UnescapeURL(request.DestURL)
+
+	replicaURL, err := s.processBackupReplicate(request.URL, request.DestURL)
+	if err != nil {
+		return err
+	}
+
+	backup := &api.BackupURLResponse{
+		URL: replicaURL,
+	}
+	if request.Verbose {
+		return sendResponse(w, backup)
+	}
+	escapedURL := strings.Replace(replicaURL, "&", "\\u0026", 1)
+	return writeStringResponse(w, escapedURL)
+}
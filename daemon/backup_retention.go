@@ -0,0 +1,135 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+/*
+parseBackupRetention parses spec in the form of "<policy>" for the default
+retention of backups, or "<url>=<policy>" for the destination, where policy
+is e.g. "last=7,daily=14,weekly=8,monthly=12". Policies contain "=" as well,
+so only a part containing "://" is taken as url.
+*/
+func parseBackupRetention(spec string) error {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) == 1 || !strings.Contains(parts[0], "://") {
+		if err := objectstore.SetDefaultRetention(strings.TrimSpace(spec)); err != nil {
+			return fmt.Errorf("Invalid default backup retention: %v", err)
+		}
+		return nil
+	}
+	destURL := strings.TrimSpace(parts[0])
+	if err := objectstore.SetRetention(destURL, strings.TrimSpace(parts[1])); err != nil {
+		return fmt.Errorf("Failed to set backup retention of %v: %v", destURL, err)
+	}
+	return nil
+}
+
+/*
+getBackupRetention returns retention of backups of the volume in destURL,
+nil if they're kept forever. Retention of the schedule of the volume applies
+to backups in its destination, otherwise the one of destination applies.
+*/
+func (s *daemon) getBackupRetention(destURL, volumeName string) (*objectstore.RetentionPolicy, error) {
+	s.scheduleMutex.Lock()
+	schedule, err := s.loadVolumeSchedule(volumeName)
+	s.scheduleMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if schedule != nil && schedule.Retention != "" && schedule.DestURL == destURL {
+		return objectstore.ParseRetentionPolicy(schedule.Retention)
+	}
+	return objectstore.GetRetention(destURL, volumeName)
+}
+
+// pruneVolumeBackups prunes backups of the volume in destURL by policy, and
+// records removed backups in timeline of the volume if it's still here
+func (s *daemon) pruneVolumeBackups(destURL, volumeName string, policy *objectstore.RetentionPolicy, dryRun bool) (*api.BackupPruneResponse, error) {
+	s.destLocks.RLock(destURL)
+	defer s.destLocks.RUnlock(destURL)
+
+	result, err := objectstore.PruneBackups(destURL, volumeName, policy, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to prune backups of volume %v: %v", volumeName, err)
+	}
+	if !dryRun && s.getVolume(volumeName) != nil {
+		for _, backupURL := range result.Pruned {
+			s.recordEvent(volumeName, LOG_OBJECT_BACKUP_URL, LOG_EVENT_REMOVE, backupURL, "pruned by retention "+policy.String())
+		}
+	}
+	return &api.BackupPruneResponse{
+		VolumeName: volumeName,
+		Retention:  policy.String(),
+		Kept:       result.Kept,
+		Pruned:     result.Pruned,
+		Locked:     result.Locked,
+		DryRun:     dryRun,
+	}, nil
+}
+
+/*
+doBackupPrune deletes backups in the destination expired by retention, of
+the volume or all volumes in it, then collects blocks no longer referenced.
+Retention in the request overrides the configured ones. Volumes without
+retention are skipped, unless asked for explicitly.
+*/
+func (s *daemon) doBackupPrune(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupPruneRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+	if _, err := objectstore.GetObjectStoreDriver(request.URL); err != nil {
+		return err
+	}
+	var policy *objectstore.RetentionPolicy
+	if request.Retention != "" {
+		var err error
+		if policy, err = objectstore.ParseRetentionPolicy(request.Retention); err != nil {
+			return err
+		}
+	}
+
+	volumeNames := []string{request.VolumeName}
+	if request.VolumeName == "" {
+		var err error
+		if volumeNames, err = objectstore.ListVolumeNames(request.URL); err != nil {
+			return err
+		}
+	} else if err := util.CheckName(request.VolumeName); err != nil {
+		return err
+	}
+
+	resp := []*api.BackupPruneResponse{}
+	for _, volumeName := range volumeNames {
+		p := policy
+		if p == nil {
+			var err error
+			if p, err = s.getBackupRetention(request.URL, volumeName); err != nil {
+				return err
+			}
+		}
+		if p == nil {
+			if request.VolumeName != "" {
+				return fmt.Errorf("No retention of backups of volume %v in %v", volumeName, request.URL)
+			}
+			log.Debugf("Skip prune of volume %v without retention", volumeName)
+			continue
+		}
+		result, err := s.pruneVolumeBackups(request.URL, volumeName, p, request.DryRun)
+		if err != nil {
+			return err
+		}
+		resp = append(resp, result)
+	}
+	return writeResponseOutput(w, resp)
+}
@@ -0,0 +1,290 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	CANARY_CFG           = "canary.json"
+	CANARY_VOLUME_PREFIX = "canary-restore"
+)
+
+// canaryState is kept in root directory, so restarts of daemon won't
+// postpone canary restores
+type canaryState struct {
+	LastRun string
+
+	root string
+}
+
+func (c *canaryState) ConfigFile() (string, error) {
+	if c.root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty canary root")
+	}
+	return filepath.Join(c.root, CANARY_CFG), nil
+}
+
+type canaryRestorer struct {
+	interval time.Duration
+	samples  int
+	rand     *rand.Rand
+
+	mutex    sync.Mutex
+	passed   int64
+	failed   int64
+	lastRun  time.Time
+	lastPass time.Time
+}
+
+// canaryCandidate is a backup of a scheduled volume, which would be restored
+// by the driver of the volume
+type canaryCandidate struct {
+	volume    *Volume
+	backupURL string
+}
+
+/*
+startCanaryRestores would restore a random sample of backups made by
+schedules within the last interval every interval, into temporary volumes,
+and verify them against the checksums recorded in the backups, so there's
+continuous evidence backups are actually restorable. Empty interval disables
+canary restores.
+*/
+func (s *daemon) startCanaryRestores(interval string, samples int) error {
+	if interval == "" {
+		return nil
+	}
+	canaryInterval, err := util.ParseDuration(interval)
+	if err != nil {
+		return fmt.Errorf("Invalid canary restore interval: %v", err)
+	}
+	if canaryInterval < SCHEDULE_CHECK_INTERVAL {
+		return fmt.Errorf("Canary restore interval cannot be less than %v", SCHEDULE_CHECK_INTERVAL)
+	}
+	if samples < 1 {
+		return fmt.Errorf("Invalid canary restore samples %v, must be positive", samples)
+	}
+
+	state := &canaryState{
+		root: s.Root,
+	}
+	exists, err := util.ObjectExists(state)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := util.ObjectLoad(state); err != nil {
+			return err
+		}
+	}
+	s.canary = &canaryRestorer{
+		interval: canaryInterval,
+		samples:  samples,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	go func() {
+		for {
+			time.Sleep(SCHEDULE_CHECK_INTERVAL)
+			if s.inMaintenance() {
+				continue
+			}
+			if last := parseTime(state.LastRun); !last.IsZero() && time.Since(last) < canaryInterval {
+				continue
+			}
+			s.runCanaryRestores()
+			state.LastRun = util.Now()
+			if err := util.ObjectSave(state); err != nil {
+				log.Warnf("Failed to save state of canary restores: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// listCanaryCandidates returns backups of scheduled volumes created within
+// the last interval. Destinations found unavailable are skipped.
+func (s *daemon) listCanaryCandidates(since time.Time) ([]canaryCandidate, error) {
+	schedules, err := s.listVolumeSchedules()
+	if err != nil {
+		return nil, err
+	}
+	candidates := []canaryCandidate{}
+	for _, schedule := range schedules {
+		if schedule.DestURL == "" {
+			continue
+		}
+		volume := s.getVolume(schedule.Name)
+		if volume == nil {
+			continue
+		}
+		if err := objectstore.CheckDestinationHealth(schedule.DestURL, volume.Name); err != nil {
+			log.Warnf("Skip canary restore of volume %v: %v", volume.Name, err)
+			continue
+		}
+		backupOps, err := s.getBackupOpsForVolume(volume)
+		if err != nil {
+			return nil, err
+		}
+		backups, err := backupOps.ListBackup(schedule.DestURL, map[string]string{
+			OPT_VOLUME_NAME: volume.Name,
+		})
+		if err != nil {
+			log.Warnf("Failed to list backups of volume %v for canary restore: %v", volume.Name, err)
+			continue
+		}
+		for backupURL, backup := range backups {
+			if parseTime(backup["CreatedTime"]).Before(since) {
+				continue
+			}
+			candidates = append(candidates, canaryCandidate{
+				volume:    volume,
+				backupURL: backupURL,
+			})
+		}
+	}
+	return candidates, nil
+}
+
+func (s *daemon) runCanaryRestores() {
+	c := s.canary
+	candidates, err := s.listCanaryCandidates(time.Now().Add(-c.interval))
+	if err != nil {
+		log.Warnf("Failed to list backups for canary restore: %v", err)
+		return
+	}
+	if len(candidates) == 0 {
+		log.Debugf("No backup created in the last %v for canary restore", c.interval)
+		return
+	}
+
+	c.mutex.Lock()
+	perm := c.rand.Perm(len(candidates))
+	c.mutex.Unlock()
+	for i := 0; i < c.samples && i < len(perm); i++ {
+		candidate := candidates[perm[i]]
+		ctx, span := util.StartSpan(context.Background(), "canary restore")
+		span.SetAttribute(TRACE_ATTR_VOLUME, candidate.volume.Name)
+		verified, err := s.canaryRestore(ctx, candidate)
+		span.End(err)
+		s.recordCanaryResult(candidate, verified, err)
+	}
+}
+
+/*
+canaryRestore restores the backup into a temporary volume of the same driver,
+and verifies the data restored if the backup has block checksums, returns
+the number of blocks verified. The temporary volume is always deleted.
+*/
+func (s *daemon) canaryRestore(ctx context.Context, candidate canaryCandidate) (int, error) {
+	volume, err := s.processVolumeCreate(ctx, &api.VolumeCreateRequest{
+		Name:       util.GenerateName(CANARY_VOLUME_PREFIX),
+		DriverName: candidate.volume.DriverName,
+		BackupURL:  candidate.backupURL,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := s.processVolumeDelete(ctx, &api.VolumeDeleteRequest{
+			VolumeName: volume.Name,
+		}); err != nil {
+			log.Warnf("Failed to delete canary restore volume %v: %v", volume.Name, err)
+		}
+	}()
+
+	// Backups out of objectstore, e.g. EBS snapshots, have no checksums
+	u, err := url.Parse(candidate.backupURL)
+	if err != nil {
+		return 0, err
+	}
+	if !stringListContains(objectstore.ListDrivers(), u.Scheme) {
+		return 0, nil
+	}
+	info, err := s.getVolumeDriverInfo(volume)
+	if err != nil {
+		return 0, err
+	}
+	path := info["Device"]
+	if path == "" {
+		path = info["File"]
+	}
+	if path == "" {
+		return 0, nil
+	}
+	return objectstore.VerifyRestoredBackup(candidate.backupURL, path)
+}
+
+func (s *daemon) recordCanaryResult(candidate canaryCandidate, verified int, err error) {
+	c := s.canary
+	now := time.Now()
+	c.mutex.Lock()
+	c.lastRun = now
+	if err == nil {
+		c.passed++
+		c.lastPass = now
+	} else {
+		c.failed++
+	}
+	c.mutex.Unlock()
+
+	fields := logrus.Fields{
+		LOG_FIELD_EVENT:      LOG_EVENT_VERIFY,
+		LOG_FIELD_OBJECT:     LOG_OBJECT_BACKUP_URL,
+		LOG_FIELD_VOLUME:     candidate.volume.Name,
+		LOG_FIELD_BACKUP_URL: candidate.backupURL,
+	}
+	if err != nil {
+		fields[LOG_FIELD_REASON] = LOG_REASON_FAILURE
+		log.WithFields(fields).Errorf("Canary restore of backup %v failed: %v", candidate.backupURL, err)
+		s.recordEvent(candidate.volume.Name, LOG_OBJECT_BACKUP_URL, LOG_EVENT_VERIFY, candidate.backupURL,
+			"canary restore failed: "+err.Error())
+		return
+	}
+	detail := "canary restore passed, no checksums to verify"
+	if verified != 0 {
+		detail = fmt.Sprintf("canary restore passed, %v blocks verified", verified)
+	}
+	fields[LOG_FIELD_REASON] = LOG_REASON_COMPLETE
+	log.WithFields(fields).Infof("Canary restore of backup %v passed, %v blocks verified", candidate.backupURL, verified)
+	s.recordEvent(candidate.volume.Name, LOG_OBJECT_BACKUP_URL, LOG_EVENT_VERIFY, candidate.backupURL, detail)
+}
+
+// writeCanaryMetrics adds results of canary restores to metrics, if enabled
+func (s *daemon) writeCanaryMetrics(b *bytes.Buffer) {
+	c := s.canary
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	b.WriteString("# HELP convoy_canary_restore_total Canary restores of sampled backups by result.\n")
+	b.WriteString("# TYPE convoy_canary_restore_total counter\n")
+	fmt.Fprintf(b, "convoy_canary_restore_total{result=\"pass\"} %v\n", c.passed)
+	fmt.Fprintf(b, "convoy_canary_restore_total{result=\"fail\"} %v\n", c.failed)
+	if !c.lastRun.IsZero() {
+		b.WriteString("# HELP convoy_canary_restore_last_run_timestamp_seconds Time of the last canary restore.\n")
+		b.WriteString("# TYPE convoy_canary_restore_last_run_timestamp_seconds gauge\n")
+		fmt.Fprintf(b, "convoy_canary_restore_last_run_timestamp_seconds %v\n", c.lastRun.Unix())
+	}
+	if !c.lastPass.IsZero() {
+		b.WriteString("# HELP convoy_canary_restore_last_success_timestamp_seconds Time of the last passed canary restore.\n")
+		b.WriteString("# TYPE convoy_canary_restore_last_success_timestamp_seconds gauge\n")
+		fmt.Fprintf(b, "convoy_canary_restore_last_success_timestamp_seconds %v\n", c.lastPass.Unix())
+	}
+}
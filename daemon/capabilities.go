@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+const (
+	CAPABILITY_SNAPSHOT           = "Snapshot"
+	CAPABILITY_BACKUP             = "Backup"
+	CAPABILITY_RESIZE             = "Resize"
+	CAPABILITY_CLONE              = "Clone"
+	CAPABILITY_CROSS_HOST_ATTACH  = "CrossHostAttach"
+	CAPABILITY_CUSTOM_MOUNT_POINT = "CustomMountPoint"
+	CAPABILITY_READ_ONLY_MOUNT    = "ReadOnlyMount"
+	CAPABILITY_ENCRYPTION         = "Encryption"
+	CAPABILITY_SELINUX_LABEL      = "SELinuxLabel"
+	CAPABILITY_KMS_ENCRYPTION     = "KmsEncryption"
+)
+
+func capabilitySupported(caps Capabilities, capability string) bool {
+	switch capability {
+	case CAPABILITY_SNAPSHOT:
+		return caps.Snapshot
+	case CAPABILITY_BACKUP:
+		return caps.Backup
+	case CAPABILITY_RESIZE:
+		return caps.Resize
+	case CAPABILITY_CLONE:
+		return caps.Clone
+	case CAPABILITY_CROSS_HOST_ATTACH:
+		return caps.CrossHostAttach
+	case CAPABILITY_CUSTOM_MOUNT_POINT:
+		return caps.CustomMountPoint
+	case CAPABILITY_READ_ONLY_MOUNT:
+		return caps.ReadOnlyMount
+	case CAPABILITY_ENCRYPTION:
+		return caps.Encryption
+	case CAPABILITY_SELINUX_LABEL:
+		return caps.SELinuxLabel
+	case CAPABILITY_KMS_ENCRYPTION:
+		return caps.KmsEncryption
+	}
+	return false
+}
+
+// capabilityList returns the supported capabilities in a fixed order, for
+// output of info
+func capabilityList(caps Capabilities) string {
+	supported := []string{}
+	for _, capability := range []string{
+		CAPABILITY_SNAPSHOT,
+		CAPABILITY_BACKUP,
+		CAPABILITY_RESIZE,
+		CAPABILITY_CLONE,
+		CAPABILITY_CROSS_HOST_ATTACH,
+		CAPABILITY_CUSTOM_MOUNT_POINT,
+		CAPABILITY_READ_ONLY_MOUNT,
+		CAPABILITY_ENCRYPTION,
+		CAPABILITY_SELINUX_LABEL,
+		CAPABILITY_KMS_ENCRYPTION,
+	} {
+		if capabilitySupported(caps, capability) {
+			supported = append(supported, capability)
+		}
+	}
+	return strings.Join(supported, ",")
+}
+
+// checkCapability would reject the operation before reaching the driver if
+// driver doesn't support it, as a bad request of the client
+func (s *daemon) checkCapability(driverName, capability string) error {
+	driver, err := s.getDriver(driverName)
+	if err != nil {
+		return err
+	}
+	if !capabilitySupported(driver.Capabilities(), capability) {
+		return APIError{
+			statusCode: http.StatusBadRequest,
+			error:      fmt.Sprintf("Driver %v doesn't support %v", driverName, capability),
+		}
+	}
+	return nil
+}
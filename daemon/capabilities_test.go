@@ -0,0 +1,34 @@
+package daemon
+
+import (
+	"net/http"
+
+	"github.com/rancher/convoy/api"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestCheckCapability(c *C) {
+	d := newTestDaemon(c)
+
+	c.Assert(d.checkCapability("vfs", CAPABILITY_SNAPSHOT), IsNil)
+	// vfs only resizes volumes with project quota
+	err := d.checkCapability("vfs", CAPABILITY_RESIZE)
+	c.Assert(err, ErrorMatches, "Driver vfs doesn't support Resize")
+	c.Assert(checkForStatusCode(err), Equals, http.StatusBadRequest)
+	c.Assert(d.checkCapability("nonexistent", CAPABILITY_SNAPSHOT), NotNil)
+
+	code, body := d.call(c, "POST", "/volumes/create", &api.VolumeCreateRequest{Name: "vol1"}, nil)
+	c.Assert(code, Equals, http.StatusOK, Commentf(body))
+	code, body = d.call(c, "POST", "/volumes/expand", &api.VolumeExpandRequest{VolumeName: "vol1", Size: 1 << 30}, nil)
+	c.Assert(code, Equals, http.StatusBadRequest)
+	c.Assert(body, Matches, "Driver vfs doesn't support Resize\n")
+
+	// KMS keys are only known to backends supporting them
+	code, body = d.call(c, "POST", "/volumes/create", &api.VolumeCreateRequest{Name: "vol2", KmsKeyID: "alias/backup"}, nil)
+	c.Assert(code, Equals, http.StatusBadRequest)
+	c.Assert(body, Matches, "Driver vfs doesn't support KmsEncryption\n")
+	code, body = d.call(c, "POST", "/snapshots/create", &api.SnapshotCreateRequest{VolumeName: "vol1", KmsKeyID: "alias/backup"}, nil)
+	c.Assert(code, Equals, http.StatusBadRequest)
+	c.Assert(body, Matches, "Driver vfs doesn't support KmsEncryption\n")
+}
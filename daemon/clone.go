@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+/*
+processVolumeClone creates a volume as an independent copy of another volume,
+by the driver of the source, e.g. a thin snapshot of devicemapper, an EBS
+volume created from a temporary EBS snapshot, or a copy of vfs directory.
+Labels of source are not copied, since copies are usually for other uses,
+e.g. tests on production data.
+*/
+func (s *daemon) processVolumeClone(ctx context.Context, request *api.VolumeCloneRequest) (*Volume, error) {
+	if err := util.CheckName(request.VolumeName); err != nil {
+		return nil, err
+	}
+	source := s.getVolume(request.VolumeName)
+	if source == nil {
+		return nil, fmt.Errorf("Volume %v to clone doesn't exist", request.VolumeName)
+	}
+	// Delete of source would wait for the clone
+	if err := s.beginVolumeOperation(source.Name); err != nil {
+		return nil, err
+	}
+	defer s.endVolumeOperation(source.Name)
+
+	createRequest := &api.VolumeCreateRequest{
+		Name:       request.Name,
+		DriverName: source.DriverName,
+		Labels:     request.Labels,
+	}
+	// Clone is as large as source, which is checked against quota
+	if info, err := s.getVolumeDriverInfo(source); err == nil {
+		if size, err := strconv.ParseInt(info[OPT_SIZE], 10, 64); err == nil {
+			createRequest.Size = size
+		}
+	}
+	return s.createVolume(ctx, createRequest, source)
+}
+
+func (s *daemon) doVolumeClone(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.VolumeCloneRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if err := util.CheckName(request.Name); err != nil {
+		return err
+	}
+
+	if request.Async {
+		if request.Name == "" {
+			name, err := s.generateName()
+			if err != nil {
+				return err
+			}
+			request.Name = name
+		}
+		j, err := s.startJob(r.Context(), JOB_TYPE_VOLUME_CLONE, request.Name, func(ctx context.Context) (string, error) {
+			volume, err := s.processVolumeClone(ctx, request)
+			if err != nil {
+				return "", err
+			}
+			return volume.Name, nil
+		})
+		if err != nil {
+			return err
+		}
+		return writeJobResponse(w, j)
+	}
+
+	volume, err := s.processVolumeClone(r.Context(), request)
+	if err != nil {
+		return err
+	}
+	if request.Verbose {
+		driverInfo, err := s.getVolumeDriverInfo(volume)
+		if err != nil {
+			return err
+		}
+		return writeResponseOutput(w, api.VolumeResponse{
+			Name:        volume.Name,
+			Driver:      volume.DriverName,
+			CreatedTime: driverInfo[OPT_VOLUME_CREATED_TIME],
+			Labels:      request.Labels,
+			DriverInfo:  driverInfo,
+			Snapshots:   map[string]api.SnapshotResponse{},
+		})
+	}
+	return writeStringResponse(w, volume.Name)
+}
@@ -0,0 +1,58 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/convoy/api"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestVolumeClone(c *C) {
+	d := newTestDaemon(c)
+	code, body := d.call(c, "POST", "/volumes/create", &api.VolumeCreateRequest{Name: "vol1"}, nil)
+	c.Assert(code, Equals, http.StatusOK, Commentf(body))
+	source := &api.VolumeResponse{}
+	code, body = d.call(c, "GET", "/volumes/", &api.VolumeInspectRequest{VolumeName: "vol1"}, source)
+	c.Assert(code, Equals, http.StatusOK, Commentf(body))
+	err := ioutil.WriteFile(filepath.Join(source.DriverInfo["Path"], "data"), []byte("data"), 0644)
+	c.Assert(err, IsNil)
+
+	clone := &api.VolumeResponse{}
+	code, body = d.call(c, "POST", "/volumes/clone", &api.VolumeCloneRequest{
+		VolumeName: "vol1",
+		Name:       "vol1-copy",
+		Labels:     map[string]string{"owner": "ci"},
+		Verbose:    true,
+	}, clone)
+	c.Assert(code, Equals, http.StatusOK, Commentf(body))
+	c.Assert(clone.Name, Equals, "vol1-copy")
+	c.Assert(clone.Labels, DeepEquals, map[string]string{"owner": "ci"})
+	data, err := ioutil.ReadFile(filepath.Join(clone.DriverInfo["Path"], "data"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+
+	// Clone is independent of the source
+	c.Assert(ioutil.WriteFile(filepath.Join(clone.DriverInfo["Path"], "data"), []byte("new"), 0644), IsNil)
+	data, err = ioutil.ReadFile(filepath.Join(source.DriverInfo["Path"], "data"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+
+	// Name is generated if not specified
+	code, body = d.call(c, "POST", "/volumes/clone", &api.VolumeCloneRequest{VolumeName: "vol1"}, nil)
+	c.Assert(code, Equals, http.StatusOK, Commentf(body))
+	c.Assert(strings.HasPrefix(strings.TrimSpace(body), "volume-"), Equals, true, Commentf(body))
+
+	code, body = d.call(c, "POST", "/volumes/clone", &api.VolumeCloneRequest{VolumeName: "vol1", Name: "vol1-copy"}, nil)
+	c.Assert(code, Not(Equals), http.StatusOK)
+	c.Assert(body, Matches, "(?s).*already exists.*")
+	code, body = d.call(c, "POST", "/volumes/clone", &api.VolumeCloneRequest{VolumeName: "vol1", Name: "invalid/name"}, nil)
+	c.Assert(code, Not(Equals), http.StatusOK)
+	c.Assert(body, Matches, "(?s)Invalid name.*")
+	code, body = d.call(c, "POST", "/volumes/clone", &api.VolumeCloneRequest{VolumeName: "nonexistent", Name: "vol2"}, nil)
+	c.Assert(code, Not(Equals), http.StatusOK)
+	c.Assert(body, Matches, "(?s).*to clone doesn't exist.*")
+}
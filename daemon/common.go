@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
 )
 
 func decodeRequest(r *http.Request, v interface{}) error {
@@ -55,6 +56,26 @@ func (s *daemon) doInfo(version string, w http.ResponseWriter, r *http.Request,
 	if err != nil {
 		return err
 	}
+	if _, err := w.Write([]byte(fmt.Sprint(",\n\"ObjectStoreDrivers\": "))); err != nil {
+		return err
+	}
+	data, err = api.ResponseOutput(objectstore.ListDrivers())
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(fmt.Sprint(",\n\"BackupDestinations\": "))); err != nil {
+		return err
+	}
+	data, err = api.ResponseOutput(objectstore.ListDestinationHealth())
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
 	for _, driver := range s.ConvoyDrivers {
 		if _, err := w.Write([]byte(fmt.Sprintf(",\n\"%v\": ", driver.Name()))); err != nil {
 			return err
@@ -64,6 +85,8 @@ func (s *daemon) doInfo(version string, w http.ResponseWriter, r *http.Request,
 		if err != nil {
 			return err
 		}
+		info["Capabilities"] = capabilityList(driver.Capabilities())
+		s.addDriverHealthInfo(driver.Name(), info)
 		data, err = api.ResponseOutput(info)
 		if err != nil {
 			return err
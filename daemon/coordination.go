@@ -0,0 +1,354 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	COORDINATION_PREFIX = "/convoy/leases"
+
+	// Leases are renewed every third of their TTL, so they survive two
+	// failed renewals
+	COORDINATION_RENEW_RATIO = 3
+)
+
+/*
+coordinator keeps daemons sharing backends, e.g. GlusterFS, NFS of vfs or
+EBS, from using the same volume at once. Each volume of the coordinated
+drivers has a key in etcd naming the daemon holding it, attached to the lease
+of that daemon. Volumes are held while they're mounted, and during
+operations changing them, i.e. create, delete, and create and delete of
+snapshots. Daemons renew their leases as long as they're alive, so volumes of
+a daemon gone are released once its lease expires, and can be mounted by
+another daemon, i.e. failed over. Volumes are identified by driver and name,
+which must be the same for a volume on every daemon.
+*/
+type coordinator struct {
+	client  *util.EtcdClient
+	prefix  string
+	holder  string
+	ttl     time.Duration
+	drivers []string
+
+	mutex   sync.Mutex
+	leaseID int64
+	// Volumes held until they're unmounted
+	mounted map[string]string
+}
+
+// initCoordination coordinates volumes of drivers with other daemons by
+// leases in etcd of client
+func (s *daemon) initCoordination(client *util.EtcdClient, drivers []string, prefix, ttl string) error {
+	if len(drivers) == 0 {
+		return nil
+	}
+	if client == nil {
+		return fmt.Errorf("Coordination of volumes requires --metadata-store %v", util.METADATA_STORE_ETCD)
+	}
+	for _, driver := range drivers {
+		if !stringListContains(s.DriverList, driver) {
+			return fmt.Errorf("Invalid coordinated driver %v, should be one of %v", driver, s.DriverList)
+		}
+	}
+	duration, err := util.ParseDuration(ttl)
+	if err != nil || duration < COORDINATION_RENEW_RATIO*time.Second {
+		return fmt.Errorf("Invalid coordination TTL %v, must be at least %vs", ttl, COORDINATION_RENEW_RATIO)
+	}
+	if prefix == "" {
+		prefix = COORDINATION_PREFIX
+	}
+	c := &coordinator{
+		client:  client,
+		prefix:  strings.TrimSuffix(prefix, "/"),
+		holder:  s.hostname,
+		ttl:     duration,
+		drivers: drivers,
+		mounted: make(map[string]string),
+	}
+	if c.leaseID, err = client.GrantLease(duration); err != nil {
+		return fmt.Errorf("Failed to get lease for coordination: %v", err)
+	}
+	s.coordinator = c
+	log.Infof("Coordinating volumes of drivers %v as %v with lease %x", drivers, c.holder, c.leaseID)
+
+	// Volumes mounted before daemon restarted are still held
+	for name, info := range s.getVolumeList() {
+		if !stringListContains(drivers, info["Driver"]) {
+			continue
+		}
+		volume := &Volume{
+			Name:       name,
+			DriverName: info["Driver"],
+		}
+		mountPoint, err := s.getVolumeMountPoint(volume)
+		if err != nil || mountPoint == "" {
+			continue
+		}
+		if err := c.acquire(volume); err != nil {
+			log.Errorf("Volume %v is mounted here but cannot be held: %v", name, err)
+			continue
+		}
+		c.mounted[name] = volume.DriverName
+	}
+	go c.renew()
+	return nil
+}
+
+func (c *coordinator) key(volume *Volume) string {
+	return c.prefix + "/" + volume.DriverName + "/" + volume.Name
+}
+
+func (c *coordinator) coordinates(volume *Volume) bool {
+	return c != nil && stringListContains(c.drivers, volume.DriverName)
+}
+
+func (c *coordinator) lease() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.leaseID
+}
+
+func volumeHeldError(volume *Volume, holder string, ttl time.Duration) error {
+	return APIError{
+		statusCode: http.StatusConflict,
+		error: fmt.Sprintf("Volume %v is held by %v, which must unmount it, or be down for %v, before it can be used here",
+			volume.Name, holder, ttl),
+	}
+}
+
+// acquire takes the key of volume, which may be held by daemon already, e.g.
+// with the lease before it restarted
+func (c *coordinator) acquire(volume *Volume) error {
+	key := c.key(volume)
+	lease := c.lease()
+	created, holder, err := c.client.CreateOrGet(key, []byte(c.holder), lease)
+	if err != nil {
+		return err
+	}
+	if created {
+		return nil
+	}
+	if holder == nil {
+		// Released meanwhile
+		if created, holder, err = c.client.CreateOrGet(key, []byte(c.holder), lease); err != nil || created {
+			return err
+		}
+	}
+	if string(holder) != c.holder {
+		return volumeHeldError(volume, string(holder), c.ttl)
+	}
+	ok, err := c.client.PutIfValue(key, holder, []byte(c.holder), lease)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Volume %v changed hands while being acquired, try again", volume.Name)
+	}
+	return nil
+}
+
+func (c *coordinator) release(volume *Volume) {
+	if _, err := c.client.DeleteIfValue(c.key(volume), []byte(c.holder)); err != nil {
+		log.Errorf("Failed to release volume %v: %v", volume.Name, err)
+	}
+}
+
+func (c *coordinator) isMounted(name string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, exists := c.mounted[name]
+	return exists
+}
+
+/*
+holdVolume holds volume for an operation changing it, if it's coordinated,
+returning function to release it once the operation is done. Volumes mounted
+are held already. Caller should hold lock of volume.
+*/
+func (s *daemon) holdVolume(volume *Volume) (func(), error) {
+	c := s.coordinator
+	if !c.coordinates(volume) || c.isMounted(volume.Name) {
+		return func() {}, nil
+	}
+	if err := c.acquire(volume); err != nil {
+		return nil, err
+	}
+	return func() {
+		if !c.isMounted(volume.Name) {
+			c.release(volume)
+		}
+	}, nil
+}
+
+// holdMountedVolume keeps volume held until releaseMountedVolume() is
+// called once it's unmounted or deleted
+func (s *daemon) holdMountedVolume(volume *Volume) {
+	c := s.coordinator
+	if !c.coordinates(volume) {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.mounted[volume.Name] = volume.DriverName
+}
+
+func (s *daemon) releaseMountedVolume(volume *Volume) {
+	c := s.coordinator
+	if !c.coordinates(volume) {
+		return
+	}
+	c.mutex.Lock()
+	delete(c.mounted, volume.Name)
+	c.mutex.Unlock()
+
+	c.release(volume)
+}
+
+/*
+renew keeps lease of daemon alive. If it has expired, e.g. etcd was out of
+reach for longer than TTL, volumes mounted are acquired again with a new
+lease, unless other daemons have taken them meanwhile, which is logged as
+error, since they're mounted by both.
+*/
+func (c *coordinator) renew() {
+	for {
+		time.Sleep(c.ttl / COORDINATION_RENEW_RATIO)
+		alive, err := c.client.KeepAliveLease(c.lease())
+		if err != nil {
+			log.Warnf("Failed to renew lease for coordination: %v", err)
+			continue
+		}
+		if alive {
+			continue
+		}
+		leaseID, err := c.client.GrantLease(c.ttl)
+		if err != nil {
+			log.Errorf("Lease for coordination expired, failed to get a new one: %v", err)
+			continue
+		}
+		c.mutex.Lock()
+		c.leaseID = leaseID
+		volumes := []*Volume{}
+		for name, driver := range c.mounted {
+			volumes = append(volumes, &Volume{
+				Name:       name,
+				DriverName: driver,
+			})
+		}
+		c.mutex.Unlock()
+
+		log.Warnf("Lease for coordination expired, acquiring %v volumes mounted with lease %x", len(volumes), leaseID)
+		for _, volume := range volumes {
+			if err := c.acquire(volume); err != nil {
+				log.Errorf("Volume %v is mounted here but lost: %v", volume.Name, err)
+			}
+		}
+	}
+}
+
+// doLeaseList returns holders of coordinated volumes of daemon
+func (s *daemon) doLeaseList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	c := s.coordinator
+	if c == nil {
+		return APIError{
+			statusCode: http.StatusNotFound,
+			error:      "Coordination is disabled",
+		}
+	}
+	names := []string{}
+	volumes := s.getVolumeList()
+	for name, info := range volumes {
+		if stringListContains(c.drivers, info["Driver"]) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	resp := []api.LeaseResponse{}
+	for _, name := range names {
+		volume := &Volume{
+			Name:       name,
+			DriverName: volumes[name]["Driver"],
+		}
+		holder, exists, err := c.client.Get(c.key(volume))
+		if err != nil {
+			return err
+		}
+		lease := api.LeaseResponse{
+			VolumeName: name,
+			Driver:     volume.DriverName,
+			Mounted:    c.isMounted(name),
+		}
+		if exists {
+			lease.Holder = string(holder)
+			lease.HeldHere = lease.Holder == c.holder
+		}
+		resp = append(resp, lease)
+	}
+	return writeResponseOutput(w, resp)
+}
+
+/*
+doLeaseBreak releases volume held by another daemon, which is known to be
+down, without waiting for its lease to expire. The daemon must be fenced,
+e.g. its host powered off, since it would keep using the volume otherwise.
+*/
+func (s *daemon) doLeaseBreak(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	c := s.coordinator
+	if c == nil {
+		return APIError{
+			statusCode: http.StatusNotFound,
+			error:      "Coordination is disabled",
+		}
+	}
+	request := &api.LeaseBreakRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if err := util.CheckName(request.VolumeName); err != nil {
+		return err
+	}
+
+	s.lockVolume(r.Context(), request.VolumeName)
+	defer s.volumeLocks.Unlock(request.VolumeName)
+
+	volume := s.getVolume(request.VolumeName)
+	if volume == nil {
+		return notFoundAPIError
+	}
+	if !c.coordinates(volume) {
+		return fmt.Errorf("Volume %v of driver %v is not coordinated", volume.Name, volume.DriverName)
+	}
+	holder, exists, err := c.client.Get(c.key(volume))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	if string(holder) == c.holder {
+		return fmt.Errorf("Volume %v is held by this daemon, unmount it instead", volume.Name)
+	}
+	if request.Holder != "" && request.Holder != string(holder) {
+		return fmt.Errorf("Volume %v is held by %v rather than %v", volume.Name, string(holder), request.Holder)
+	}
+	if _, err := c.client.DeleteIfValue(c.key(volume), holder); err != nil {
+		return err
+	}
+	log.Warnf("Broke lease of volume %v held by %v", volume.Name, string(holder))
+	s.recordEvent(volume.Name, LOG_OBJECT_VOLUME, LOG_EVENT_LEASE, volume.Name, "held by "+string(holder))
+	return nil
+}
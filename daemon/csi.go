@@ -0,0 +1,588 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/rancher/convoy/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+const (
+	CSI_DRIVER_NAME = "convoy.rancher.io"
+	// Volumes are only known to the daemon of the node they're created on
+	CSI_TOPOLOGY_KEY = CSI_DRIVER_NAME + "/node"
+
+	// Parameters of StorageClass and VolumeSnapshotClass
+	CSI_PARAM_DRIVER            = "driver"
+	CSI_PARAM_TYPE              = "type"
+	CSI_PARAM_IOPS              = "iops"
+	CSI_PARAM_FILESYSTEM        = "filesystem"
+	CSI_PARAM_MKFS_OPTIONS      = "mkfsOptions"
+	CSI_PARAM_MOUNT_OPTIONS     = "mountOptions"
+	CSI_PARAM_KMS_KEY_ID        = "kmsKeyId"
+	CSI_PARAM_BACKUP_BLOCK_SIZE = "backupBlockSize"
+	CSI_PARAM_FS_FREEZE         = "fsFreeze"
+	CSI_PARAM_BACKUP_DEST       = "backupDest"
+
+	// Secret of CreateVolume
+	CSI_SECRET_ENCRYPTION_KEY = "encryptionKey"
+)
+
+/*
+csiServer serves Identity, Controller and Node services of CSI, so Container
+Orchestrators like Kubernetes can consume volumes of Convoy. Like the gRPC API,
+requests are served by the handlers of HTTP API. Every node runs its own
+daemon, as controller and node at once, and volumes are accessible from the
+node they're created on.
+*/
+type csiServer struct {
+	csi.UnimplementedIdentityServer
+	csi.UnimplementedControllerServer
+	csi.UnimplementedNodeServer
+
+	s       *daemon
+	api     *grpcServer
+	nodeID  string
+	version string
+}
+
+// startCSIServer listens at unix domain socket for CSI. Empty socket
+// disables it.
+func (s *daemon) startCSIServer(sockFile, nodeID, version string) (*grpc.Server, net.Listener, error) {
+	if sockFile == "" {
+		return nil, nil, nil
+	}
+	if nodeID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, nil, err
+		}
+		nodeID = hostname
+	}
+	l, err := listenUnix(sockFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot listen at CSI socket %v: %v", sockFile, err)
+	}
+	server := grpc.NewServer()
+	c := &csiServer{
+		s: s,
+		// Local socket, the same as the daemon socket
+		api: &grpcServer{
+			s:       s,
+			handler: s.Router,
+			caller:  AUDIT_CALLER_CSI,
+		},
+		nodeID:  nodeID,
+		version: version,
+	}
+	csi.RegisterIdentityServer(server, c)
+	csi.RegisterControllerServer(server, c)
+	csi.RegisterNodeServer(server, c)
+	log.Infof("Serving CSI as %v of node %v at %v", CSI_DRIVER_NAME, nodeID, sockFile)
+	return server, l, nil
+}
+
+func (c *csiServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{
+		Name:          CSI_DRIVER_NAME,
+		VendorVersion: c.version,
+	}, nil
+}
+
+func (c *csiServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	resp := &csi.GetPluginCapabilitiesResponse{}
+	for _, t := range []csi.PluginCapability_Service_Type{
+		csi.PluginCapability_Service_CONTROLLER_SERVICE,
+		csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+	} {
+		resp.Capabilities = append(resp.Capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{Type: t},
+			},
+		})
+	}
+	// Drivers grow filesystems of volumes mounted
+	resp.Capabilities = append(resp.Capabilities, &csi.PluginCapability{
+		Type: &csi.PluginCapability_VolumeExpansion_{
+			VolumeExpansion: &csi.PluginCapability_VolumeExpansion{Type: csi.PluginCapability_VolumeExpansion_ONLINE},
+		},
+	})
+	return resp, nil
+}
+
+// Probe reports not ready while any driver fails its health check
+func (c *csiServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	for name := range c.s.ConvoyDrivers {
+		if err := c.s.checkDriverHealth(name); err != nil {
+			log.Warnf("CSI probe: %v", err)
+			return &csi.ProbeResponse{Ready: &wrappers.BoolValue{Value: false}}, nil
+		}
+	}
+	return &csi.ProbeResponse{Ready: &wrappers.BoolValue{Value: true}}, nil
+}
+
+func (c *csiServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	resp := &csi.ControllerGetCapabilitiesResponse{}
+	for _, t := range []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+	} {
+		resp.Capabilities = append(resp.Capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{Type: t},
+			},
+		})
+	}
+	return resp, nil
+}
+
+func (c *csiServer) topology() []*csi.Topology {
+	return []*csi.Topology{
+		{Segments: map[string]string{CSI_TOPOLOGY_KEY: c.nodeID}},
+	}
+}
+
+/*
+checkCapabilities returns error if volumes cannot be used with the
+capabilities. Volumes are mounted filesystems, accessed by the node they're
+created on only, even if they're on shared filesystems, e.g. vfs, since other
+daemons don't know them.
+*/
+func checkCapabilities(caps []*csi.VolumeCapability) error {
+	for _, cap := range caps {
+		if cap.GetBlock() != nil {
+			return fmt.Errorf("Block access type is not supported")
+		}
+		if cap.GetMount() == nil {
+			return fmt.Errorf("Access type is required")
+		}
+		if cap.GetAccessMode() == nil {
+			return fmt.Errorf("Access mode is required")
+		}
+		switch cap.GetAccessMode().GetMode() {
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+		default:
+			return fmt.Errorf("Access mode %v is not supported", cap.GetAccessMode().GetMode())
+		}
+	}
+	return nil
+}
+
+// csiCapacity returns size of the volume in its driver info, or else the
+// size requested
+func csiCapacity(volume *api.VolumeResponse, requested int64) int64 {
+	if size, err := strconv.ParseInt(volume.DriverInfo[OPT_SIZE], 10, 64); err == nil && size != 0 {
+		return size
+	}
+	return requested
+}
+
+func (c *csiServer) csiVolume(volume *api.VolumeResponse, requested int64, source *csi.VolumeContentSource) *csi.Volume {
+	return &csi.Volume{
+		VolumeId:           volume.Name,
+		CapacityBytes:      csiCapacity(volume, requested),
+		ContentSource:      source,
+		AccessibleTopology: c.topology(),
+	}
+}
+
+/*
+CreateVolume creates volume named by the CO, with options of Convoy from
+parameters of the StorageClass, e.g. driver and filesystem. Volumes can be
+created from CSI snapshots which were backed up, i.e. restored from the
+backup, or cloned from other volumes. Existing volume of the name is returned as is, since the CO retries
+with the same name.
+*/
+func (c *csiServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume name is required")
+	}
+	if len(req.VolumeCapabilities) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume capabilities are required")
+	}
+	params := req.Parameters
+	driver := params[CSI_PARAM_DRIVER]
+	if driver == "" {
+		driver = c.s.DefaultDriver
+	}
+	if err := checkCapabilities(req.VolumeCapabilities); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	size := req.GetCapacityRange().GetRequiredBytes()
+	if size == 0 {
+		size = req.GetCapacityRange().GetLimitBytes()
+	}
+
+	if volume := c.s.getVolume(req.Name); volume != nil {
+		resp := &api.VolumeResponse{}
+		if err := c.api.call(ctx, "GET", "/volumes/", nil, &api.VolumeInspectRequest{
+			VolumeName: req.Name,
+		}, resp); err != nil {
+			return nil, err
+		}
+		if resp.Driver != driver {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume %v exists with driver %v", req.Name, resp.Driver)
+		}
+		return &csi.CreateVolumeResponse{
+			Volume: c.csiVolume(resp, size, req.VolumeContentSource),
+		}, nil
+	}
+
+	request := &api.VolumeCreateRequest{
+		Name:            req.Name,
+		DriverName:      driver,
+		Size:            size,
+		Type:            params[CSI_PARAM_TYPE],
+		Filesystem:      params[CSI_PARAM_FILESYSTEM],
+		MkfsOptions:     params[CSI_PARAM_MKFS_OPTIONS],
+		MountOptions:    params[CSI_PARAM_MOUNT_OPTIONS],
+		EncryptionKey:   req.Secrets[CSI_SECRET_ENCRYPTION_KEY],
+		KmsKeyID:        params[CSI_PARAM_KMS_KEY_ID],
+		BackupBlockSize: params[CSI_PARAM_BACKUP_BLOCK_SIZE],
+		FsFreeze:        params[CSI_PARAM_FS_FREEZE],
+		Verbose:         true,
+	}
+	if params[CSI_PARAM_IOPS] != "" {
+		iops, err := strconv.ParseInt(params[CSI_PARAM_IOPS], 10, 64)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid %v %v", CSI_PARAM_IOPS, params[CSI_PARAM_IOPS])
+		}
+		request.IOPS = iops
+	}
+	if source := req.VolumeContentSource; source != nil {
+		if sourceVolume := source.GetVolume(); sourceVolume != nil {
+			return c.cloneVolume(ctx, req, driver, sourceVolume.GetVolumeId(), size)
+		}
+		snapshotID := source.GetSnapshot().GetSnapshotId()
+		if !strings.Contains(snapshotID, "://") {
+			return nil, status.Errorf(codes.InvalidArgument, "Snapshot %v is not backed up, set %v in parameters of the VolumeSnapshotClass to create volumes from snapshots",
+				snapshotID, CSI_PARAM_BACKUP_DEST)
+		}
+		request.BackupURL = snapshotID
+	}
+
+	resp := &api.VolumeResponse{}
+	if err := c.api.call(ctx, "POST", "/volumes/create", nil, request, resp); err != nil {
+		return nil, err
+	}
+	return &csi.CreateVolumeResponse{
+		Volume: c.csiVolume(resp, size, req.VolumeContentSource),
+	}, nil
+}
+
+// cloneVolume creates volume named by the CO as a clone of the source volume,
+// which must be of the driver of the StorageClass. Other parameters are of
+// the source.
+func (c *csiServer) cloneVolume(ctx context.Context, req *csi.CreateVolumeRequest, driver, sourceID string, size int64) (*csi.CreateVolumeResponse, error) {
+	source := c.s.getVolume(sourceID)
+	if source == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %v to clone not found", sourceID)
+	}
+	if source.DriverName != driver {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %v to clone is of driver %v rather than %v", sourceID, source.DriverName, driver)
+	}
+	resp := &api.VolumeResponse{}
+	if err := c.api.call(ctx, "POST", "/volumes/clone", nil, &api.VolumeCloneRequest{
+		VolumeName: sourceID,
+		Name:       req.Name,
+		Verbose:    true,
+	}, resp); err != nil {
+		return nil, err
+	}
+	return &csi.CreateVolumeResponse{
+		Volume: c.csiVolume(resp, size, req.VolumeContentSource),
+	}, nil
+}
+
+// DeleteVolume succeeds if the volume is gone already
+func (c *csiServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	if c.s.getVolume(req.VolumeId) == nil {
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if err := c.api.call(ctx, "DELETE", "/volumes/", nil, &api.VolumeDeleteRequest{
+		VolumeName: req.VolumeId,
+	}, nil); err != nil {
+		return nil, err
+	}
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+/*
+ControllerExpandVolume expands volume to the required size. Filesystem is
+grown by the driver, so no expansion is needed on the node. Volume already
+as large is left as is, since the CO retries.
+*/
+func (c *csiServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	size := req.GetCapacityRange().GetRequiredBytes()
+	if size == 0 {
+		size = req.GetCapacityRange().GetLimitBytes()
+	}
+	if size == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Capacity is required")
+	}
+	if c.s.getVolume(req.VolumeId) == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %v not found", req.VolumeId)
+	}
+	resp := &api.VolumeResponse{}
+	if err := c.api.call(ctx, "GET", "/volumes/", nil, &api.VolumeInspectRequest{
+		VolumeName: req.VolumeId,
+	}, resp); err != nil {
+		return nil, err
+	}
+	if current := csiCapacity(resp, 0); current < size {
+		if err := c.api.call(ctx, "POST", "/volumes/expand", nil, &api.VolumeExpandRequest{
+			VolumeName: req.VolumeId,
+			Size:       size,
+			Verbose:    true,
+		}, resp); err != nil {
+			return nil, err
+		}
+	}
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes: csiCapacity(resp, size),
+	}, nil
+}
+
+func (c *csiServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	if len(req.VolumeCapabilities) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume capabilities are required")
+	}
+	if c.s.getVolume(req.VolumeId) == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %v not found", req.VolumeId)
+	}
+	if err := checkCapabilities(req.VolumeCapabilities); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.VolumeContext,
+			VolumeCapabilities: req.VolumeCapabilities,
+			Parameters:         req.Parameters,
+		},
+	}, nil
+}
+
+func csiTimestamp(t string) *timestamp.Timestamp {
+	created, err := time.Parse(time.RubyDate, t)
+	if err != nil {
+		created = time.Now()
+	}
+	return &timestamp.Timestamp{
+		Seconds: created.Unix(),
+		Nanos:   int32(created.Nanosecond()),
+	}
+}
+
+/*
+CreateSnapshot creates snapshot of the volume named by the CO. With backupDest
+in parameters of the VolumeSnapshotClass, the snapshot is backed up there as
+well, and its ID is the backup URL, so volumes can be created from it on any
+node. Otherwise its ID is the name of the snapshot, which stays on the node.
+*/
+func (c *csiServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot name is required")
+	}
+	if req.SourceVolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Source volume ID is required")
+	}
+	if c.s.getVolume(req.SourceVolumeId) == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %v not found", req.SourceVolumeId)
+	}
+
+	snapshot := &api.SnapshotResponse{}
+	if volumeName := c.s.SnapshotVolumeIndex.Get(req.Name); volumeName != "" {
+		if volumeName != req.SourceVolumeId {
+			return nil, status.Errorf(codes.AlreadyExists, "Snapshot %v exists of volume %v", req.Name, volumeName)
+		}
+		if err := c.api.call(ctx, "GET", "/snapshots/", nil, &api.SnapshotInspectRequest{
+			SnapshotName: req.Name,
+		}, snapshot); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := c.api.call(ctx, "POST", "/snapshots/create", nil, &api.SnapshotCreateRequest{
+			Name:       req.Name,
+			VolumeName: req.SourceVolumeId,
+			Verbose:    true,
+		}, snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	snapshotID := req.Name
+	if dest := req.Parameters[CSI_PARAM_BACKUP_DEST]; dest != "" {
+		backupURL, err := c.backupSnapshot(ctx, dest, req.SourceVolumeId, req.Name)
+		if err != nil {
+			return nil, err
+		}
+		snapshotID = backupURL
+	}
+	return &csi.CreateSnapshotResponse{
+		Snapshot: &csi.Snapshot{
+			SnapshotId:     snapshotID,
+			SourceVolumeId: req.SourceVolumeId,
+			CreationTime:   csiTimestamp(snapshot.CreatedTime),
+			ReadyToUse:     true,
+		},
+	}, nil
+}
+
+// backupSnapshot returns URL of backup of the snapshot in dest, created
+// unless it was by previous call
+func (c *csiServer) backupSnapshot(ctx context.Context, dest, volumeName, snapshotName string) (string, error) {
+	backups := make(map[string]map[string]string)
+	if err := c.api.call(ctx, "GET", "/backups/list", nil, &api.BackupListRequest{
+		URL:          dest,
+		VolumeName:   volumeName,
+		SnapshotName: snapshotName,
+	}, &backups); err != nil {
+		return "", err
+	}
+	for backupURL := range backups {
+		return backupURL, nil
+	}
+	resp := &api.BackupURLResponse{}
+	if err := c.api.call(ctx, "POST", "/backups/create", nil, &api.BackupCreateRequest{
+		URL:          dest,
+		SnapshotName: snapshotName,
+		Verbose:      true,
+	}, resp); err != nil {
+		return "", err
+	}
+	return resp.URL, nil
+}
+
+/*
+DeleteSnapshot deletes the snapshot, or the backup and the snapshot it was
+created from if the ID is a backup URL. It succeeds if they're gone already.
+*/
+func (c *csiServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if req.SnapshotId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot ID is required")
+	}
+	snapshotName := req.SnapshotId
+	if strings.Contains(req.SnapshotId, "://") {
+		info := make(map[string]string)
+		if err := c.api.call(ctx, "GET", "/backups/inspect", nil, &api.BackupListRequest{
+			URL: req.SnapshotId,
+		}, &info); err != nil {
+			// Gone already, or the destination is unreachable, which
+			// the CO would retry
+			if status.Code(err) == codes.NotFound {
+				return &csi.DeleteSnapshotResponse{}, nil
+			}
+			return nil, err
+		}
+		if err := c.api.call(ctx, "DELETE", "/backups", nil, &api.BackupDeleteRequest{
+			URL: req.SnapshotId,
+		}, nil); err != nil {
+			return nil, err
+		}
+		snapshotName = info["SnapshotName"]
+	}
+	if snapshotName == "" || c.s.SnapshotVolumeIndex.Get(snapshotName) == "" {
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+	if err := c.api.call(ctx, "DELETE", "/snapshots/", nil, &api.SnapshotDeleteRequest{
+		SnapshotName: snapshotName,
+	}, nil); err != nil {
+		return nil, err
+	}
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+func (c *csiServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{}, nil
+}
+
+func (c *csiServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{
+		NodeId:             c.nodeID,
+		AccessibleTopology: c.topology()[0],
+	}, nil
+}
+
+/*
+NodePublishVolume mounts the volume at its mount point, the default one unless
+it's mounted already, and bind mounts it at the target path, read-only if the
+CO asks so. Mount options are set by mountOptions in parameters of the
+StorageClass rather than mount flags, since the mount is shared by all
+targets.
+*/
+func (c *csiServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	if req.TargetPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "Target path is required")
+	}
+	if req.VolumeCapability == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability is required")
+	}
+	if c.s.getVolume(req.VolumeId) == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %v not found", req.VolumeId)
+	}
+	if err := checkCapabilities([]*csi.VolumeCapability{req.VolumeCapability}); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(req.VolumeCapability.GetMount().GetMountFlags()) != 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Mount flags are not supported, set %v in parameters of the StorageClass instead",
+			CSI_PARAM_MOUNT_OPTIONS)
+	}
+
+	if err := c.api.call(ctx, "POST", "/volumes/publish", nil, &api.VolumePublishRequest{
+		VolumeName: req.VolumeId,
+		TargetPath: req.TargetPath,
+		ReadOnly:   req.Readonly,
+	}, nil); err != nil {
+		return nil, err
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// NodeUnpublishVolume umounts the target path, and the volume once it's not
+// published anywhere else
+func (c *csiServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	if req.TargetPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "Target path is required")
+	}
+
+	if err := c.api.call(ctx, "POST", "/volumes/unpublish", nil, &api.VolumeUnpublishRequest{
+		VolumeName: req.VolumeId,
+		TargetPath: req.TargetPath,
+	}, nil); err != nil {
+		return nil, err
+	}
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
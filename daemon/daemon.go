@@ -1,19 +1,22 @@
 package daemon
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 	"github.com/gorilla/mux"
 	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
 	"github.com/rancher/convoy/util"
 
 	. "github.com/rancher/convoy/convoydriver"
@@ -27,6 +30,114 @@ type daemon struct {
 	NameUUIDIndex       *util.Index
 	SnapshotVolumeIndex *util.Index
 	daemonConfig
+
+	historyMutex  sync.Mutex
+	activityMutex sync.Mutex
+	scheduleMutex sync.Mutex
+	hookMutex     sync.Mutex
+	labelMutex    sync.Mutex
+
+	healthMutex  sync.RWMutex
+	driverHealth map[string]*driverHealth
+	healthProbes map[string]*util.Probe
+
+	dockerCreateMutex sync.Mutex
+	dockerCreateCalls map[string]*dockerCreateCall
+
+	// Snapshots and backups in progress, and volumes being deleted
+	volumeOpsMutex  sync.Mutex
+	volumeOps       map[string]int
+	volumesDeleting map[string]bool
+
+	// States of volumes by name, see volume_state.go
+	volumeStateMutex sync.Mutex
+	volumeStates     map[string]*volumeState
+
+	// Per volume and per backup destination locks, and slots of long
+	// running operations, see lock.go
+	volumeLocks *util.LockMap
+	destLocks   *util.LockMap
+	opSlots     chan struct{}
+
+	// Jobs in progress and their slots, see job.go
+	jobMutex sync.Mutex
+	jobs     map[string]*job
+	jobSlots chan struct{}
+
+	// Default SELinux label of mounts
+	SELinuxLabel string
+
+	latency      *util.LatencyTracker
+	latencyMutex sync.Mutex
+	latencySLOs  []*latencySLO
+
+	metrics *operationMetrics
+
+	// Records of requests changing anything, see audit.go
+	audit *auditLog
+
+	// Serializes reloads of config, see reload.go
+	reloadMutex sync.Mutex
+
+	// Limits of volumes by driver, see quota.go
+	quotaMutex sync.Mutex
+	quotas     map[string]*driverQuota
+	// Incremented whenever a reservation is released
+	quotaReleases int
+
+	// Limits of requests by client, see ratelimit.go
+	rateLimiter         *util.RateLimiter
+	rateLimitMutex      sync.Mutex
+	rateLimitedRequests map[string]int64
+
+	// nil unless exporting traces, see tracing.go
+	tracer *util.Tracer
+
+	// nil if API listening with --listen is open to every client passing
+	// TLS
+	auth *authConfig
+
+	// Empty unless running as Docker managed plugin, see --propagated-mount
+	propagatedMount string
+
+	// Serializes publications of volumes, see /volumes/publish
+	publishMutex sync.Mutex
+
+	// Watchers of events by /events and gRPC API
+	eventWatchMutex sync.Mutex
+	eventWatchers   map[*eventWatcher]bool
+	webhooks        []*webhook
+	// Host of events
+	hostname string
+
+	// nil if site hooks are disabled
+	siteHooks *siteHooks
+
+	// nil if canary restores are disabled
+	canary *canaryRestorer
+
+	// nil if scrubs are disabled
+	scrubber *scrubber
+
+	// See /maintenance
+	maintenanceMutex sync.RWMutex
+	maintenance      *maintenanceState
+
+	// Schedules running, at most cap(scheduleSlots) of them started by
+	// daemon at once, and schedules waiting for their catch-up runs. Jitter
+	// is guarded by scheduleRunMutex as well, since it can be reloaded
+	scheduleRunMutex  sync.Mutex
+	schedulesRunning  map[string]bool
+	schedulesCatchUps map[string]bool
+	scheduleSlots     chan struct{}
+	scheduleJitter    time.Duration
+
+	// nil if backups aren't mirrored
+	mirrorQueue chan *backupMirror
+
+	// nil unless volumes are coordinated with other daemons, see
+	// coordination.go
+	coordinator *coordinator
 }
 
 const (
@@ -35,12 +146,18 @@ const (
 
 	CONFIGFILE = "convoy.cfg"
 	LOCKFILE   = "lock"
+
+	BACKUP_INDEX_DIR = "backup-index"
 )
 
 var (
 	lockFile *os.File
 	logFile  *os.File
 
+	// Format and levels of logs, see log_level.go
+	logFormat    string
+	logFormatter *LevelFormatter
+
 	log = logrus.WithFields(logrus.Fields{"pkg": "daemon"})
 )
 
@@ -61,35 +178,90 @@ func (c *daemonConfig) ConfigFile() (string, error) {
 	return filepath.Join(c.Root, CONFIGFILE), nil
 }
 
-func createRouter(s *daemon) *mux.Router {
-	router := mux.NewRouter()
-	m := map[string]map[string]requestHandler{
+// apiRoutes returns handlers of the API by method and route, which
+// /api-spec is generated from as well
+func (s *daemon) apiRoutes() map[string]map[string]requestHandler {
+	return map[string]map[string]requestHandler{
 		"GET": {
-			"/info":            s.doInfo,
-			"/volumes/list":    s.doVolumeList,
-			"/volumes/":        s.doVolumeInspect,
-			"/snapshots/":      s.doSnapshotInspect,
-			"/backups/list":    s.doBackupList,
-			"/backups/inspect": s.doBackupInspect,
+			"/info":             s.doInfo,
+			"/volumes/list":     s.doVolumeList,
+			"/volumes/":         s.doVolumeInspect,
+			"/volumes/timeline": s.doVolumeTimeline,
+			"/volumes/usage":    s.doVolumeUsage,
+			"/snapshots/":       s.doSnapshotInspect,
+			"/backups/list":     s.doBackupList,
+			"/backups/inspect":  s.doBackupInspect,
+			"/backups/export":   s.doBackupExport,
+			"/schedules/list":   s.doScheduleList,
+			"/hooks/list":       s.doHookList,
+			"/stats":            s.doStats,
+			"/events":           s.doEvents,
+			"/metrics":          s.doMetrics,
+			"/jobs/list":        s.doJobList,
+			"/jobs/":            s.doJobInspect,
+			"/logging":          s.doLogging,
+			"/healthz":          s.doHealthz,
+			"/readyz":           s.doReadyz,
+			"/audit":            s.doAudit,
+			"/quotas":           s.doQuotaList,
+			"/leases":           s.doLeaseList,
+			"/api-spec":         s.doAPISpec,
+			"/maintenance":      s.doMaintenance,
 		},
 		"POST": {
-			"/volumes/create":   s.doVolumeCreate,
-			"/volumes/mount":    s.doVolumeMount,
-			"/volumes/umount":   s.doVolumeUmount,
-			"/snapshots/create": s.doSnapshotCreate,
-			"/backups/create":   s.doBackupCreate,
+			"/volumes/create":     s.doVolumeCreate,
+			"/volumes/clone":      s.doVolumeClone,
+			"/volumes/expand":     s.doVolumeExpand,
+			"/volumes/restore":    s.doVolumeRestore,
+			"/volumes/mount":      s.doVolumeMount,
+			"/volumes/umount":     s.doVolumeUmount,
+			"/volumes/publish":    s.doVolumePublish,
+			"/volumes/unpublish":  s.doVolumeUnpublish,
+			"/snapshots/create":   s.doSnapshotCreate,
+			"/backups/create":     s.doBackupCreate,
+			"/backups/index":      s.doBackupIndexRefresh,
+			"/backups/prune":      s.doBackupPrune,
+			"/backups/rotate-key": s.doBackupRotateKey,
+			"/backups/replicate":  s.doBackupReplicate,
+			"/backups/import":     s.doBackupImport,
+			"/backups/migrate":    s.doBackupMigrate,
+			"/backups/mount":      s.doBackupMount,
+			"/backups/umount":     s.doBackupUmount,
+			"/schedules/set":      s.doScheduleSet,
+			"/schedules/run":      s.doScheduleRun,
+			"/schedules/export":   s.doScheduleExport,
+			"/hooks/set":          s.doHookSet,
+			"/jobs/cancel":        s.doJobCancel,
+			"/logging/set":        s.doLoggingSet,
+			"/leases/break":       s.doLeaseBreak,
+			"/maintenance/set":    s.doMaintenanceSet,
 		},
 		"DELETE": {
 			"/volumes/":   s.doVolumeDelete,
 			"/snapshots/": s.doSnapshotDelete,
 			"/backups":    s.doBackupDelete,
+			"/schedules":  s.doScheduleDelete,
+			"/hooks":      s.doHookDelete,
 		},
 	}
-	for method, routes := range m {
+}
+
+func createRouter(s *daemon) *mux.Router {
+	router := mux.NewRouter()
+	for method, routes := range s.apiRoutes() {
 		for route, f := range routes {
 			log.Debugf("Registering %s, %s", method, route)
-			handler := makeHandlerFunc(method, route, api.API_VERSION, f)
-			router.Path("/v{version:[0-9.]+}" + route).Methods(method).HandlerFunc(handler)
+			handler := makeHandlerFunc(method, route, f)
+			if method != "GET" {
+				handler = s.auditHandler(route, handler)
+			}
+			// Rejected requests are not audited, since they change
+			// nothing
+			handler = s.rateLimitHandler(route, handler)
+			handler = s.traceHandler(method, route, handler)
+			router.Path("/v" + api.API_MAJOR_VERSION + route).Methods(method).HandlerFunc(handler)
+			// Paths without version are kept for clients before API
+			// version 1.1, see docs/api.md
 			router.Path(route).Methods(method).HandlerFunc(handler)
 		}
 	}
@@ -110,14 +282,20 @@ func createRouter(s *daemon) *mux.Router {
 	for method, routes := range pluginMap {
 		for route, f := range routes {
 			log.Debugf("Registering plugin handler %s, %s", method, route)
-			router.Path(route).Methods(method).HandlerFunc(f)
+			handler := s.rateLimitPluginHandler(route, s.auditPluginHandler(route, f))
+			router.Path(route).Methods(method).HandlerFunc(s.traceHandler(method, route, handler))
 		}
 	}
 	return router
 }
 
 func (s *daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(api.API_VERSION_HEADER, api.API_VERSION)
 	info := fmt.Sprintf("Handler not found: %v %v", r.Method, r.RequestURI)
+	if version := unsupportedAPIVersion(r); version != "" {
+		info = fmt.Sprintf("API version %v of %v %v is not supported by daemon, whose API version is %v",
+			version, r.Method, r.RequestURI, api.API_VERSION)
+	}
 	log.Errorf(info)
 	w.WriteHeader(http.StatusNotFound)
 	w.Write([]byte(info))
@@ -125,19 +303,20 @@ func (s *daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 type requestHandler func(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error
 
-func makeHandlerFunc(method string, route string, version string, f requestHandler) http.HandlerFunc {
+// makeHandlerFunc returns handler calling f with the API version negotiated
+// with the client, which responses should be compatible with
+func makeHandlerFunc(method string, route string, f requestHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Don't record volume list API call since it may used for polling
 		if route != "/volumes/list" {
 			log.Debugf("Calling: %v, %v, request: %v, %v", method, route, r.Method, r.RequestURI)
 		}
 
-		if strings.Contains(r.Header.Get("User-Agent"), "Convoy-Client/") {
-			userAgent := strings.Split(r.Header.Get("User-Agent"), "/")
-			if len(userAgent) == 2 && userAgent[1] != version {
-				http.Error(w, fmt.Errorf("client version %v doesn't match with server %v", userAgent[1], version).Error(), http.StatusNotFound)
-				return
-			}
+		w.Header().Set(api.API_VERSION_HEADER, api.API_VERSION)
+		version, err := negotiateAPIVersion(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 		if err := f(version, w, r, mux.Vars(r)); err != nil {
 			statusCode := checkForStatusCode(err)
@@ -188,20 +367,7 @@ func daemonEnvironmentSetup(c *cli.Context) error {
 		return fmt.Errorf("Failed to lock the file at %v: %v", lockPath, err.Error())
 	}
 
-	logrus.SetLevel(logrus.DebugLevel)
-	logName := c.String("log")
-	if logName != "" {
-		logFile, err := os.OpenFile(logName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			return err
-		}
-		logrus.SetFormatter(&logrus.JSONFormatter{})
-		logrus.SetOutput(logFile)
-	} else {
-		logrus.SetOutput(os.Stdout)
-	}
-
-	return nil
+	return initLogging(c)
 }
 
 func environmentCleanup() {
@@ -260,9 +426,18 @@ func Start(sockFile string, c *cli.Context) error {
 	}
 	defer environmentCleanup()
 
+	if err := initSocketActivation(); err != nil {
+		return err
+	}
 	root := c.String("root")
+	etcdClient, err := initMetadataStore(c, root)
+	if err != nil {
+		return err
+	}
 	s := &daemon{
 		ConvoyDrivers: make(map[string]ConvoyDriver),
+		metrics:       newOperationMetrics(),
+		eventWatchers: make(map[*eventWatcher]bool),
 	}
 	config := &daemonConfig{
 		Root: root,
@@ -309,41 +484,211 @@ func Start(sockFile string, c *cli.Context) error {
 	if err := util.InitMountNamespace(s.MountNamespaceFD); err != nil {
 		return err
 	}
+	if err := s.initPropagatedMount(c.String("propagated-mount")); err != nil {
+		return err
+	}
 
 	util.InitTimeout(config.CmdTimeout)
 
+	if _, err := util.SELinuxMountContext(c.String("selinux-label")); err != nil {
+		return err
+	}
+	s.SELinuxLabel = c.String("selinux-label")
+	if err := s.initLatencyTracking(c.String("latency-window"), c.StringSlice("latency-slo")); err != nil {
+		return err
+	}
+
 	// driverOpts would be ignored by Convoy Drivers if config already exists
 	driverOpts := util.SliceToMap(c.StringSlice("driver-opts"))
+	if err := util.InitEncryptionKeySources(c.String("encryption-key-dir"), c.String("encryption-key-hook")); err != nil {
+		return err
+	}
+	if err := s.initSiteHooks(c.String("hooks-dir"), c.String("hooks-timeout"), c.String("hooks-on-failure")); err != nil {
+		return err
+	}
+	if err := s.initEvents(c.StringSlice("webhook"), c.String("webhook-timeout")); err != nil {
+		return err
+	}
+	if err := s.initLocks(c.Int("max-concurrent-ops")); err != nil {
+		return err
+	}
+	if err := s.initJobs(c.Int("job-concurrency")); err != nil {
+		return err
+	}
+	if err := s.initAudit(c.String("audit-log")); err != nil {
+		return err
+	}
+	if err := s.initQuotas(c.StringSlice("quota")); err != nil {
+		return err
+	}
+	if err := s.initRateLimits(c.StringSlice("rate-limit")); err != nil {
+		return err
+	}
+	if err := s.initTracing(c); err != nil {
+		return err
+	}
+	if s.tracer != nil {
+		// Spans of requests being served when stopping are lost
+		defer s.tracer.Stop()
+	}
 	if err := s.initDrivers(driverOpts); err != nil {
 		return err
 	}
 	if err := s.finializeInitialization(); err != nil {
 		return err
 	}
-	if err := util.ObjectSave(config); err != nil {
+	s.reconcileDrivers()
+	if err := s.initCoordination(etcdClient, c.StringSlice("coordination-drivers"),
+		c.String("coordination-prefix"), c.String("coordination-ttl")); err != nil {
 		return err
 	}
-
-	s.Router = createRouter(s)
-
-	if err := util.MkdirIfNotExists(filepath.Dir(sockFile)); err != nil {
+	s.completePendingDeletes()
+	s.startHealthProbes(c.Int("health-check-interval"))
+	s.startAlertProbes(c.Int("health-check-interval"))
+	s.startActivitySampler()
+	s.startFilesystemGrower()
+	if err := objectstore.SetIndexDir(filepath.Join(s.Root, BACKUP_INDEX_DIR)); err != nil {
+		return err
+	}
+	catchUpStagger, err := util.ParseDuration(c.String("schedule-catchup-stagger"))
+	if err != nil {
+		return fmt.Errorf("Invalid schedule catch-up stagger: %v", err)
+	}
+	scheduleJitter, err := util.ParseDuration(c.String("schedule-jitter"))
+	if err != nil {
+		return fmt.Errorf("Invalid schedule jitter: %v", err)
+	}
+	if err := objectstore.SetVerifyPercent(c.Int("backup-verify-percent")); err != nil {
+		return err
+	}
+	if err := objectstore.SetTransferConcurrency(c.Int("backup-concurrency")); err != nil {
+		return err
+	}
+	if err := objectstore.SetProbeFailureThreshold(c.Int("backup-dest-probe-failures")); err != nil {
+		return err
+	}
+	backupBlockSize, err := util.ParseSize(c.String("backup-block-size"))
+	if err != nil {
+		return fmt.Errorf("Invalid backup block size: %v", err)
+	}
+	if err := objectstore.SetDefaultBlockSize(backupBlockSize); err != nil {
+		return err
+	}
+	if err := initS3Encryption(c); err != nil {
+		return err
+	}
+	if err := initS3Upload(c); err != nil {
+		return err
+	}
+	if err := initS3ObjectLock(c); err != nil {
+		return err
+	}
+	if err := initS3StorageClass(c); err != nil {
+		return err
+	}
+	if err := initS3Endpoint(c); err != nil {
 		return err
 	}
-	// This should be safe because lock file prevent starting daemon twice
-	if _, err := os.Stat(sockFile); err == nil {
-		log.Warnf("Remove previous sockfile at %v", sockFile)
-		if err := os.Remove(sockFile); err != nil {
+	for _, spec := range c.StringSlice("backup-encryption") {
+		if err := parseBackupEncryption(spec); err != nil {
 			return err
 		}
 	}
+	for _, spec := range c.StringSlice("backup-compression") {
+		if err := parseBackupCompression(spec); err != nil {
+			return err
+		}
+	}
+	for _, destURL := range c.StringSlice("backup-block-pool") {
+		if err := objectstore.SetBlockPool(destURL); err != nil {
+			return fmt.Errorf("Failed to enable block pool of %v: %v", destURL, err)
+		}
+	}
+	for _, spec := range c.StringSlice("backup-dest-group") {
+		if err := objectstore.ParseDestinationGroup(spec); err != nil {
+			return err
+		}
+	}
+	for _, spec := range c.StringSlice("backup-retention") {
+		if err := parseBackupRetention(spec); err != nil {
+			return err
+		}
+	}
+	if mirrors := c.StringSlice("backup-mirror"); len(mirrors) != 0 {
+		for _, spec := range mirrors {
+			if err := parseBackupMirror(spec); err != nil {
+				return err
+			}
+		}
+		s.startBackupMirrors()
+	}
+	if err := s.initMaintenance(); err != nil {
+		return err
+	}
+	if err := s.startScheduler(c.String("scheduler"), catchUpStagger, scheduleJitter, c.Int("schedule-concurrency")); err != nil {
+		return err
+	}
+	// Settings of config file override their options
+	if c.String("config-file") != "" {
+		if err := s.reloadConfig(c); err != nil {
+			return err
+		}
+	}
+	s.startDestinationProbes(c.Int("backup-dest-probe-interval"))
+	if err := s.startCanaryRestores(c.String("canary-restore-interval"), c.Int("canary-restore-samples")); err != nil {
+		return err
+	}
+	if err := s.startScrubs(c.String("scrub-interval"), c.Int("scrub-percent")); err != nil {
+		return err
+	}
+	if err := s.startInventoryReporter(c.String("inventory-url"), c.String("inventory-interval"),
+		c.String("inventory-token")); err != nil {
+		return err
+	}
+	if err := util.ObjectSave(config); err != nil {
+		return err
+	}
 
-	l, err := net.Listen("unix", sockFile)
+	s.Router = createRouter(s)
+	if err := s.startMetricsListener(c.String("metrics-listen")); err != nil {
+		return err
+	}
+
+	l, err := listenUnix(sockFile)
 	if err != nil {
 		fmt.Println("listen err", err)
 		return err
 	}
 	defer l.Close()
 
+	pluginListener, err := listenPluginSocket(c.String("plugin-socket"))
+	if err != nil {
+		return err
+	}
+
+	listening := c.String("listen") != "" || c.String("grpc-listen") != ""
+	if err := s.initAuth(c.String("auth-config"), listening); err != nil {
+		return err
+	}
+	tlsConfig, err := s.loadListenerTLS(c.String("tls-cert"), c.String("tls-key"), c.String("tls-client-ca"), listening)
+	if err != nil {
+		return err
+	}
+	tcpListener, err := listenTLS(c.String("listen"), tlsConfig)
+	if err != nil {
+		return err
+	}
+	grpcServer, grpcListener, err := s.startGRPCServer(c.String("grpc-listen"), tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	csiServer, csiListener, err := s.startCSIServer(c.String("csi-socket"), c.String("csi-node-id"), c.App.Version)
+	if err != nil {
+		return err
+	}
+	closeUnusedActivatedSockets()
+
 	sigs := make(chan os.Signal, 1)
 	done := make(chan bool, 1)
 	signal.Notify(sigs, os.Interrupt, os.Kill, syscall.SIGTERM)
@@ -352,19 +697,104 @@ func Start(sockFile string, c *cli.Context) error {
 		fmt.Printf("Caught signal %s: shutting down.\n", sig)
 		done <- true
 	}()
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	go s.reloadOnSignal(c, hups)
 
 	go func() {
-		err = http.Serve(l, s.Router)
+		err = serveUnix(l, s.Router)
 		if err != nil {
 			log.Error("http server error", err.Error())
 		}
 		done <- true
 	}()
 
+	if pluginListener != nil {
+		defer pluginListener.Close()
+		go func() {
+			if err := serveUnix(pluginListener, s.Router); err != nil {
+				log.Error("plugin http server error ", err.Error())
+			}
+			done <- true
+		}()
+	}
+
+	if tcpListener != nil {
+		defer tcpListener.Close()
+		go func() {
+			if err := http.Serve(tcpListener, s.authHandler(s.Router)); err != nil {
+				log.Error("https server error ", err.Error())
+			}
+			done <- true
+		}()
+	}
+
+	if grpcServer != nil {
+		defer grpcServer.Stop()
+		go func() {
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				log.Error("grpc server error ", err.Error())
+			}
+			done <- true
+		}()
+	}
+
+	if csiServer != nil {
+		defer csiServer.Stop()
+		go func() {
+			if err := csiServer.Serve(csiListener); err != nil {
+				log.Error("csi server error ", err.Error())
+			}
+			done <- true
+		}()
+	}
+
+	s.notifySystemd()
 	<-done
+	util.SdNotify(util.SD_NOTIFY_STOPPING)
 	return nil
 }
 
+/*
+loadListenerTLS returns TLS config of the APIs listening at TCP addresses,
+--listen and --grpc-listen. TLS is required there, since anyone reaching the
+addresses could manage volumes; with clientCAFile, clients must present
+certificates signed by it as well.
+*/
+func (s *daemon) loadListenerTLS(certFile, keyFile, clientCAFile string, listening bool) (*tls.Config, error) {
+	if !listening {
+		if certFile != "" || keyFile != "" || clientCAFile != "" {
+			return nil, fmt.Errorf("TLS options are only used with --listen or --grpc-listen")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("--listen and --grpc-listen require --tls-cert and --tls-key")
+	}
+	config, err := util.NewServerTLSConfig(certFile, keyFile, clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	if clientCAFile == "" && s.auth == nil {
+		log.Warnf("Clients over TCP are not authenticated, specify --tls-client-ca to require client certificates, or --auth-config")
+	}
+	return config, nil
+}
+
+// listenTLS listens at TCP address for the API, besides the unix domain
+// socket, so the daemon can be managed remotely. Empty address disables it.
+func listenTLS(address string, config *tls.Config) (net.Listener, error) {
+	if address == "" {
+		return nil, nil
+	}
+	l, err := tls.Listen("tcp", address, config)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot listen at %v: %v", address, err)
+	}
+	log.Infof("Serving API over TLS at %v", l.Addr())
+	return l, nil
+}
+
 func (s *daemon) getDriver(driverName string) (ConvoyDriver, error) {
 	driver, exists := s.ConvoyDrivers[driverName]
 	if !exists {
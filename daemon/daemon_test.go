@@ -0,0 +1,63 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+// newTestDaemon returns daemon with vfs driver, rooted in temporary
+// directories of the test
+func newTestDaemon(c *C) *daemon {
+	s := &daemon{
+		ConvoyDrivers: make(map[string]ConvoyDriver),
+		metrics:       newOperationMetrics(),
+		eventWatchers: make(map[*eventWatcher]bool),
+	}
+	s.daemonConfig = daemonConfig{
+		Root:          c.MkDir(),
+		DriverList:    []string{"vfs"},
+		DefaultDriver: "vfs",
+	}
+	c.Assert(s.initLatencyTracking("", nil), IsNil)
+	c.Assert(s.initSiteHooks("", DEFAULT_HOOK_TIMEOUT, HOOK_ON_FAILURE_CONTINUE), IsNil)
+	c.Assert(s.initEvents(nil, "10s"), IsNil)
+	c.Assert(s.initLocks(8), IsNil)
+	c.Assert(s.initJobs(2), IsNil)
+	c.Assert(s.initAudit(""), IsNil)
+	c.Assert(s.initQuotas(nil), IsNil)
+	c.Assert(s.initRateLimits(nil), IsNil)
+	c.Assert(s.initDrivers(map[string]string{"vfs.path": c.MkDir()}), IsNil)
+	c.Assert(s.finializeInitialization(), IsNil)
+	c.Assert(s.initMaintenance(), IsNil)
+	s.Router = createRouter(s)
+	return s
+}
+
+// call makes request to API of s, and decodes the response into v unless
+// it's nil. It returns the status code and body of the response.
+func (s *daemon) call(c *C, method, route string, request, v interface{}) (int, string) {
+	body := &bytes.Buffer{}
+	if request != nil {
+		c.Assert(json.NewEncoder(body).Encode(request), IsNil)
+	}
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, httptest.NewRequest(method, "/v1"+route, body))
+	if v != nil && w.Code == http.StatusOK {
+		c.Assert(json.Unmarshal(w.Body.Bytes(), v), IsNil)
+	}
+	return w.Code, w.Body.String()
+}
@@ -1,9 +1,11 @@
 package daemon
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"strconv"
 
 	"github.com/rancher/convoy/api"
@@ -11,6 +13,10 @@ import (
 	"github.com/rancher/convoy/util"
 )
 
+const (
+	DOCKER_MOUNT_DIR = "docker-mounts"
+)
+
 type pluginInfo struct {
 	Implements []string
 }
@@ -23,8 +29,9 @@ type pluginResponse struct {
 }
 
 type DockerVolume struct {
-	Name       string `json:",omitempty"`
-	Mountpoint string `json:",omitempty"`
+	Name       string                 `json:",omitempty"`
+	Mountpoint string                 `json:",omitempty"`
+	Status     map[string]interface{} `json:",omitempty"`
 }
 
 type pluginRequest struct {
@@ -32,6 +39,98 @@ type pluginRequest struct {
 	Opts map[string]string
 }
 
+// dockerCreateCall is a create of volume in progress for docker, shared by
+// concurrent requests for the same volume name
+type dockerCreateCall struct {
+	done   chan struct{}
+	volume *Volume
+	err    error
+}
+
+/*
+dockerMountOptions are options of Docker volume create applied to every mount
+of the volume by Docker, e.g. "-o read-only=true", since Docker passes no
+options to VolumeDriver.Mount.
+*/
+type dockerMountOptions struct {
+	Name         string
+	ReadOnly     bool
+	SubPath      string
+	SELinuxLabel string
+
+	root string
+}
+
+func (o *dockerMountOptions) ConfigFile() (string, error) {
+	if o.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if o.root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty docker mount root")
+	}
+	return filepath.Join(o.root, DOCKER_MOUNT_DIR, VOLUME_CFG_PREFIX+o.Name+CFG_POSTFIX), nil
+}
+
+// parseDockerMountOptions returns mount options of Docker volume create, nil
+// if there is none
+func (s *daemon) parseDockerMountOptions(name string, opts map[string]string) (*dockerMountOptions, error) {
+	if opts["read-only"] == "" && opts["subpath"] == "" && opts["selinux-label"] == "" {
+		return nil, nil
+	}
+	o := &dockerMountOptions{
+		Name:         name,
+		SubPath:      opts["subpath"],
+		SELinuxLabel: opts["selinux-label"],
+		root:         s.Root,
+	}
+	if opts["read-only"] != "" {
+		readOnly, err := strconv.ParseBool(opts["read-only"])
+		if err != nil {
+			return nil, err
+		}
+		o.ReadOnly = readOnly
+	}
+	if o.SubPath != "" {
+		if err := util.CheckSubPath(o.SubPath); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := util.SELinuxMountContext(o.SELinuxLabel); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// getDockerMountRequest returns the request of mounting volume for Docker by
+// options it was created with
+func (s *daemon) getDockerMountRequest(volumeName string) (*api.VolumeMountRequest, error) {
+	o := &dockerMountOptions{
+		Name: volumeName,
+		root: s.Root,
+	}
+	if err := util.ObjectLoad(o); err != nil && !util.IsNotExistsError(err) {
+		return nil, err
+	}
+	return &api.VolumeMountRequest{
+		ReadOnly:     o.ReadOnly,
+		SubPath:      o.SubPath,
+		SELinuxLabel: o.SELinuxLabel,
+	}, nil
+}
+
+func (s *daemon) deleteDockerMountOptions(volumeName string) {
+	o := &dockerMountOptions{
+		Name: volumeName,
+		root: s.Root,
+	}
+	if exists, err := util.ObjectExists(o); err != nil || !exists {
+		return
+	}
+	if err := util.ObjectDelete(o); err != nil {
+		log.Warnf("Failed to delete docker mount options of volume %v: %v", volumeName, err)
+	}
+}
+
 func (s *daemon) dockerActivate(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("Handle plugin activate: %v %v", r.Method, r.RequestURI)
 	info := pluginInfo{
@@ -49,7 +148,52 @@ func convertToPluginRequest(r *http.Request) (*pluginRequest, error) {
 	return request, nil
 }
 
-func (s *daemon) createDockerVolume(request *pluginRequest) (*Volume, error) {
+/*
+createDockerVolume creates volume for docker. Docker may call Create(or Mount
+with CreateOnDockerMount) for the same volume concurrently, e.g. when scaling
+up a compose service, so the first request would create the volume, and the
+others would wait for it and get the same result, instead of racing to create
+duplicate backend volumes. Options of the first request win.
+*/
+func (s *daemon) createDockerVolume(ctx context.Context, request *pluginRequest) (*Volume, error) {
+	name := request.Name
+
+	s.dockerCreateMutex.Lock()
+	if call, exists := s.dockerCreateCalls[name]; exists {
+		s.dockerCreateMutex.Unlock()
+		log.Debugf("Waiting for volume %v being created for docker", name)
+		<-call.done
+		return call.volume, call.err
+	}
+	if s.volumePendingDelete(name) {
+		s.dockerCreateMutex.Unlock()
+		return nil, fmt.Errorf("Volume %v is being deleted, retry once its snapshot or backup finishes", name)
+	}
+	// The volume may be created by a call just finished
+	if volume := s.getVolume(name); volume != nil {
+		s.dockerCreateMutex.Unlock()
+		log.Debugf("Found existing volume for docker %v", name)
+		return volume, nil
+	}
+	call := &dockerCreateCall{
+		done: make(chan struct{}),
+	}
+	if s.dockerCreateCalls == nil {
+		s.dockerCreateCalls = make(map[string]*dockerCreateCall)
+	}
+	s.dockerCreateCalls[name] = call
+	s.dockerCreateMutex.Unlock()
+
+	call.volume, call.err = s.doCreateDockerVolume(ctx, request)
+
+	s.dockerCreateMutex.Lock()
+	delete(s.dockerCreateCalls, name)
+	s.dockerCreateMutex.Unlock()
+	close(call.done)
+	return call.volume, call.err
+}
+
+func (s *daemon) doCreateDockerVolume(ctx context.Context, request *pluginRequest) (*Volume, error) {
 	name := request.Name
 	log.Debugf("Create a new volume %v for docker", name)
 
@@ -75,17 +219,43 @@ func (s *daemon) createDockerVolume(request *pluginRequest) (*Volume, error) {
 			return nil, err
 		}
 	}
+	mountOpts, err := s.parseDockerMountOptions(name, request.Opts)
+	if err != nil {
+		return nil, err
+	}
 	createReq := &api.VolumeCreateRequest{
-		Name:           name,
-		DriverName:     request.Opts["driver"],
-		Size:           size,
-		BackupURL:      request.Opts["backup"],
-		DriverVolumeID: request.Opts["id"],
-		Type:           request.Opts["type"],
-		PrepareForVM:   prepareForVM,
-		IOPS:           int64(iops),
-	}
-	return s.processVolumeCreate(createReq)
+		Name:            name,
+		DriverName:      request.Opts["driver"],
+		Size:            size,
+		BackupURL:       request.Opts["backup"],
+		DriverVolumeID:  request.Opts["id"],
+		Type:            request.Opts["type"],
+		PrepareForVM:    prepareForVM,
+		IOPS:            int64(iops),
+		Filesystem:      request.Opts["fs"],
+		MkfsOptions:     request.Opts["mkfs-opts"],
+		MountOptions:    request.Opts["mount-opts"],
+		EncryptionKey:   request.Opts["encryption-key"],
+		KmsKeyID:        request.Opts["kms-key-id"],
+		BackupBlockSize: request.Opts["backup-block-size"],
+		FsFreeze:        request.Opts["fsfreeze"],
+		Labels:          getDockerLabels(request.Opts),
+	}
+	// Saved before the volume is created, so Docker never mounts it without
+	// them
+	if mountOpts != nil {
+		if err := util.MkdirIfNotExists(filepath.Join(s.Root, DOCKER_MOUNT_DIR)); err != nil {
+			return nil, err
+		}
+		if err := util.ObjectSave(mountOpts); err != nil {
+			return nil, err
+		}
+	}
+	volume, err := s.processVolumeCreate(ctx, createReq)
+	if err != nil && mountOpts != nil && s.getVolume(name) == nil {
+		s.deleteDockerMountOptions(name)
+	}
+	return volume, err
 }
 
 func (s *daemon) getDockerVolume(r *http.Request) (*Volume, *pluginRequest, error) {
@@ -93,6 +263,10 @@ func (s *daemon) getDockerVolume(r *http.Request) (*Volume, *pluginRequest, erro
 	if err != nil {
 		return nil, nil, err
 	}
+	// Volume pending delete has been removed as far as docker knows
+	if s.volumePendingDelete(request.Name) {
+		return nil, request, nil
+	}
 	volume := s.getVolume(request.Name)
 	return volume, request, nil
 }
@@ -122,7 +296,7 @@ func (s *daemon) dockerCreateVolume(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	volume, err = s.createDockerVolume(request)
+	volume, err = s.createDockerVolume(r.Context(), request)
 	if err != nil {
 		dockerResponse(w, "", err)
 		return
@@ -145,29 +319,40 @@ func (s *daemon) dockerRemoveVolume(w http.ResponseWriter, r *http.Request) {
 		}
 		log.Debugf("Ignoring remove volume %v for docker", name)
 	} else {
-		volume, _, err := s.getDockerVolume(r)
+		req, err := convertToPluginRequest(r)
 		if err != nil {
 			dockerResponse(w, "", err)
 			return
 		}
-
-		if volume == nil {
-			log.Infof("Couldn't find volume. Nothing to remove.")
+		if s.volumePendingDelete(req.Name) {
+			log.Debugf("Volume %v is already pending delete", req.Name)
 			dockerResponse(w, "", nil)
 			return
 		}
 
 		request := &api.VolumeDeleteRequest{
-			VolumeName: volume.Name,
+			VolumeName: req.Name,
 			// By default we don't want to remove the volume because probably we're using NFS
 			ReferenceOnly: true,
 		}
-		if err := s.processVolumeDelete(request); err != nil {
+		// Docker doesn't retry, so delete is deferred rather than failed
+		// if snapshot or backup of the volume is in progress
+		deferred, err := s.processVolumeDeleteOrDefer(r.Context(), request, true)
+		if err == notFoundAPIError {
+			log.Infof("Couldn't find volume. Nothing to remove.")
+			dockerResponse(w, "", nil)
+			return
+		}
+		if err != nil {
 			dockerResponse(w, "", err)
 			return
 		}
 
-		log.Debugf("Removed volume %v for docker", volume.Name)
+		if deferred {
+			log.Debugf("Deferred removing volume %v for docker", req.Name)
+		} else {
+			log.Debugf("Removed volume %v for docker", req.Name)
+		}
 	}
 
 	dockerResponse(w, "", nil)
@@ -184,7 +369,7 @@ func (s *daemon) dockerMountVolume(w http.ResponseWriter, r *http.Request) {
 
 	if volume == nil {
 		if s.CreateOnDockerMount {
-			volume, err = s.createDockerVolume(request)
+			volume, err = s.createDockerVolume(r.Context(), request)
 			if err != nil {
 				dockerResponse(w, "", err)
 				return
@@ -198,11 +383,20 @@ func (s *daemon) dockerMountVolume(w http.ResponseWriter, r *http.Request) {
 
 	log.Debugf("Mount volume: %v for docker", volume.Name)
 
-	mountPoint, err := s.processVolumeMount(volume, &api.VolumeMountRequest{})
+	mountRequest, err := s.getDockerMountRequest(volume.Name)
+	if err != nil {
+		dockerResponse(w, "", err)
+		return
+	}
+	mountPoint, err := s.processVolumeMount(r.Context(), volume, mountRequest)
 	if err != nil {
 		dockerResponse(w, "", err)
 		return
 	}
+	if err := s.checkPropagatedMount(volume.Name, mountPoint); err != nil {
+		dockerResponse(w, "", err)
+		return
+	}
 
 	dockerResponse(w, mountPoint, nil)
 }
@@ -224,7 +418,7 @@ func (s *daemon) dockerUnmountVolume(w http.ResponseWriter, r *http.Request) {
 
 	log.Debugf("Unmount volume: %v for docker", volume.Name)
 
-	if err := s.processVolumeUmount(volume); err != nil {
+	if err := s.processVolumeUmount(r.Context(), volume); err != nil {
 		dockerResponse(w, "", err)
 		return
 	}
@@ -276,12 +470,23 @@ func (s *daemon) dockerGetVolume(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	labels, err := s.getVolumeLabels(volume.Name)
+	if err != nil {
+		dockerResponse(w, "", err)
+		return
+	}
+
 	response := pluginResponse{
 		Volume: &DockerVolume{
 			Name:       volume.Name,
 			Mountpoint: mountPoint,
 		},
 	}
+	if len(labels) != 0 {
+		response.Volume.Status = map[string]interface{}{
+			DOCKER_STATUS_LABELS: labels,
+		}
+	}
 
 	log.Debugf("Found volume %v for docker", volume.Name)
 
@@ -300,7 +505,7 @@ func (s *daemon) dockerListVolume(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		vol := s.getVolume(volName)
-		if vol == nil {
+		if vol == nil || s.volumePendingDelete(volName) {
 			continue
 		}
 		mountPoint, err := s.getVolumeMountPoint(vol)
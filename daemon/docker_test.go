@@ -0,0 +1,75 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+
+	"github.com/rancher/convoy/util"
+
+	. "gopkg.in/check.v1"
+)
+
+// callPlugin makes request to the Docker volume plugin API of s
+func (s *daemon) callPlugin(c *C, route string, request *pluginRequest) *pluginResponse {
+	body := &bytes.Buffer{}
+	c.Assert(json.NewEncoder(body).Encode(request), IsNil)
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, httptest.NewRequest("POST", route, body))
+	c.Assert(w.Code, Equals, 200, Commentf(w.Body.String()))
+	resp := &pluginResponse{}
+	c.Assert(json.Unmarshal(w.Body.Bytes(), resp), IsNil)
+	return resp
+}
+
+func (s *TestSuite) TestDockerMountOptions(c *C) {
+	d := newTestDaemon(c)
+
+	resp := d.callPlugin(c, "/VolumeDriver.Create", &pluginRequest{
+		Name: "vol1",
+		Opts: map[string]string{"read-only": "maybe"},
+	})
+	c.Assert(resp.Err, Matches, ".*invalid syntax.*")
+	resp = d.callPlugin(c, "/VolumeDriver.Create", &pluginRequest{
+		Name: "vol1",
+		Opts: map[string]string{"subpath": "../data"},
+	})
+	c.Assert(resp.Err, Not(Equals), "")
+	c.Assert(d.getVolume("vol1"), IsNil)
+
+	// Options of create apply to mounts, which have no options of their own
+	resp = d.callPlugin(c, "/VolumeDriver.Create", &pluginRequest{
+		Name: "vol1",
+		Opts: map[string]string{"subpath": "app1/data"},
+	})
+	c.Assert(resp.Err, Equals, "")
+	resp = d.callPlugin(c, "/VolumeDriver.Mount", &pluginRequest{Name: "vol1"})
+	c.Assert(resp.Err, Equals, "")
+	c.Assert(filepath.Base(filepath.Dir(resp.Mountpoint))+"/"+filepath.Base(resp.Mountpoint), Equals, "app1/data")
+
+	// Volumes created otherwise are mounted as they are
+	resp = d.callPlugin(c, "/VolumeDriver.Create", &pluginRequest{Name: "vol2"})
+	c.Assert(resp.Err, Equals, "")
+	resp = d.callPlugin(c, "/VolumeDriver.Mount", &pluginRequest{Name: "vol2"})
+	c.Assert(resp.Err, Equals, "")
+	c.Assert(filepath.Base(resp.Mountpoint), Not(Equals), "data")
+
+	// Read-only mount is rejected by driver not supporting it
+	resp = d.callPlugin(c, "/VolumeDriver.Create", &pluginRequest{
+		Name: "vol3",
+		Opts: map[string]string{"read-only": "true"},
+	})
+	c.Assert(resp.Err, Equals, "")
+	resp = d.callPlugin(c, "/VolumeDriver.Mount", &pluginRequest{Name: "vol3"})
+	c.Assert(resp.Err, Matches, ".*doesn't support.*")
+
+	// Options are gone along with the volume
+	resp = d.callPlugin(c, "/VolumeDriver.Unmount", &pluginRequest{Name: "vol1"})
+	c.Assert(resp.Err, Equals, "")
+	resp = d.callPlugin(c, "/VolumeDriver.Remove", &pluginRequest{Name: "vol1"})
+	c.Assert(resp.Err, Equals, "")
+	exists, err := util.ObjectExists(&dockerMountOptions{Name: "vol1", root: d.Root})
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+}
@@ -0,0 +1,302 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	// Events not received by a watcher in time are dropped
+	EVENT_WATCHER_BUFFER = 64
+
+	// Events waiting for delivery to each webhook, more would be dropped
+	WEBHOOK_QUEUE_SIZE = 1000
+	// Deliveries are retried with backoff from WEBHOOK_RETRY_INTERVAL
+	WEBHOOK_RETRIES        = 3
+	WEBHOOK_RETRY_INTERVAL = time.Second
+)
+
+/*
+eventWatcher receives events published from now on, of the volume only if
+volumeName is set, for /events and WatchEvents of gRPC API.
+*/
+type eventWatcher struct {
+	volumeName string
+	events     chan api.Event
+}
+
+/*
+webhook POSTs every event published as JSON to the URL, one at a time in the
+order they happened, so monitoring systems learn about failures without
+polling. Deliveries failing after retries are dropped.
+*/
+type webhook struct {
+	url    string
+	client *http.Client
+	queue  chan api.Event
+}
+
+func (s *daemon) initEvents(webhookURLs []string, timeout string) error {
+	host, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	s.hostname = host
+
+	webhookTimeout, err := util.ParseDuration(timeout)
+	if err != nil {
+		return fmt.Errorf("Invalid webhook timeout: %v", err)
+	}
+	if webhookTimeout <= 0 {
+		return fmt.Errorf("Invalid webhook timeout %v, must be positive", timeout)
+	}
+	for _, webhookURL := range webhookURLs {
+		u, err := url.Parse(webhookURL)
+		if err != nil {
+			return fmt.Errorf("Invalid webhook %v: %v", webhookURL, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid webhook %v, should be a http or https URL", webhookURL)
+		}
+		h := &webhook{
+			url:    webhookURL,
+			client: &http.Client{Timeout: webhookTimeout},
+			queue:  make(chan api.Event, WEBHOOK_QUEUE_SIZE),
+		}
+		s.webhooks = append(s.webhooks, h)
+		go func() {
+			for event := range h.queue {
+				h.deliver(event)
+			}
+		}()
+	}
+	return nil
+}
+
+func (h *webhook) deliver(event api.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("Failed to encode %v %v event for webhook %v: %v", event.Object, event.Event, h.url, err)
+		return
+	}
+	interval := WEBHOOK_RETRY_INTERVAL
+	for i := 0; ; i++ {
+		if err = h.post(data); err == nil {
+			return
+		}
+		if i == WEBHOOK_RETRIES {
+			break
+		}
+		time.Sleep(interval)
+		interval *= 2
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_FAILURE,
+		LOG_FIELD_EVENT:  event.Event,
+		LOG_FIELD_OBJECT: event.Object,
+		LOG_FIELD_VOLUME: event.VolumeName,
+	}).Warnf("Dropped event for webhook %v after %v retries: %v", h.url, WEBHOOK_RETRIES, err)
+}
+
+func (h *webhook) post(data []byte) error {
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected response %v", resp.Status)
+	}
+	return nil
+}
+
+// publishEvent sends the event to watchers and webhooks. Events of volumes
+// are recorded in their timelines by recordEvent and recordFailure as well.
+func (s *daemon) publishEvent(volumeName string, volumeEvent api.VolumeEvent) {
+	event := api.Event{
+		VolumeName:  volumeName,
+		Host:        s.hostname,
+		VolumeEvent: volumeEvent,
+	}
+	for _, h := range s.webhooks {
+		select {
+		case h.queue <- event:
+		default:
+			log.Warnf("Too many events waiting for webhook %v, drop %v %v event of volume %v",
+				h.url, event.Object, event.Event, volumeName)
+		}
+	}
+
+	s.eventWatchMutex.Lock()
+	defer s.eventWatchMutex.Unlock()
+	for watcher := range s.eventWatchers {
+		if watcher.volumeName != "" && watcher.volumeName != volumeName {
+			continue
+		}
+		select {
+		case watcher.events <- event:
+		default:
+			log.Warnf("Dropped %v %v event of volume %v for slow watcher", event.Object, event.Event, volumeName)
+		}
+	}
+}
+
+func (s *daemon) watchEvents(volumeName string) *eventWatcher {
+	watcher := &eventWatcher{
+		volumeName: volumeName,
+		events:     make(chan api.Event, EVENT_WATCHER_BUFFER),
+	}
+	s.eventWatchMutex.Lock()
+	s.eventWatchers[watcher] = true
+	s.eventWatchMutex.Unlock()
+	return watcher
+}
+
+func (s *daemon) unwatchEvents(watcher *eventWatcher) {
+	s.eventWatchMutex.Lock()
+	delete(s.eventWatchers, watcher)
+	s.eventWatchMutex.Unlock()
+}
+
+/*
+recordFailure publishes failure of the operation of the object with err, e.g.
+failed snapshot, and records it in timeline of the volume unless the volume
+doesn't exist, e.g. failed to be created.
+*/
+func (s *daemon) recordFailure(volumeName, object, event, name, detail string, err error) {
+	volumeEvent := api.VolumeEvent{
+		Time:   util.Now(),
+		Object: object,
+		Event:  event,
+		Name:   name,
+		Detail: detail,
+		Error:  err.Error(),
+	}
+	if s.getVolume(volumeName) == nil {
+		s.publishEvent(volumeName, volumeEvent)
+		return
+	}
+	s.appendVolumeEvent(volumeName, volumeEvent)
+}
+
+// recordDriverEvent publishes event of the driver, e.g. health and alerts of
+// its backend, which aren't recorded in timelines
+func (s *daemon) recordDriverEvent(driverName, event, detail string) {
+	s.publishEvent("", api.VolumeEvent{
+		Time:   util.Now(),
+		Object: LOG_OBJECT_DRIVER,
+		Event:  event,
+		Name:   driverName,
+		Detail: detail,
+	})
+}
+
+/*
+startAlertProbes would check alerts of drivers implementing AlertReporter
+every interval seconds, publishing an alert event when an alert is raised,
+and another one when it's cleared.
+*/
+func (s *daemon) startAlertProbes(interval int) {
+	if interval <= 0 {
+		return
+	}
+	for name, driver := range s.ConvoyDrivers {
+		reporter, ok := driver.(AlertReporter)
+		if !ok {
+			continue
+		}
+		go func(name string, reporter AlertReporter) {
+			raised := map[string]bool{}
+			for {
+				s.probeAlerts(name, reporter, raised)
+				time.Sleep(time.Duration(interval) * time.Second)
+			}
+		}(name, reporter)
+	}
+}
+
+func (s *daemon) probeAlerts(name string, reporter AlertReporter, raised map[string]bool) {
+	alerts, err := reporter.Alerts()
+	if err != nil {
+		// Failures of backend are reported by health probes
+		log.Debugf("Failed to check alerts of driver %v: %v", name, err)
+		return
+	}
+	current := map[string]bool{}
+	for _, alert := range alerts {
+		current[alert.Name] = true
+		if raised[alert.Name] {
+			continue
+		}
+		raised[alert.Name] = true
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_EVENT:  LOG_EVENT_ALERT,
+			LOG_FIELD_OBJECT: LOG_OBJECT_DRIVER,
+			LOG_FIELD_DRIVER: name,
+		}).Warnf("Driver %v raised alert %v: %v", name, alert.Name, alert.Detail)
+		s.recordDriverEvent(name, LOG_EVENT_ALERT, alert.Name+" raised: "+alert.Detail)
+	}
+	for alertName := range raised {
+		if current[alertName] {
+			continue
+		}
+		delete(raised, alertName)
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_EVENT:  LOG_EVENT_ALERT,
+			LOG_FIELD_OBJECT: LOG_OBJECT_DRIVER,
+			LOG_FIELD_DRIVER: name,
+		}).Infof("Driver %v cleared alert %v", name, alertName)
+		s.recordDriverEvent(name, LOG_EVENT_ALERT, alertName+" cleared")
+	}
+}
+
+/*
+doEvents streams events published from now on as JSON, one per line, until
+the client disconnects. Events of the volume only if volume is specified,
+otherwise events of drivers as well.
+*/
+func (s *daemon) doEvents(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	volumeName, err := util.GetFlag(r, "volume", false, nil)
+	if err != nil {
+		return err
+	}
+	if err := util.CheckName(volumeName); err != nil {
+		return err
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("Streaming events is not supported by the connection")
+	}
+
+	watcher := s.watchEvents(volumeName)
+	defer s.unwatchEvents(watcher)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event := <-watcher.events:
+			if err := encoder.Encode(event); err != nil {
+				// Client is gone, response has been started anyway
+				return nil
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
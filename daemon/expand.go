@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+/*
+processVolumeExpand expands the volume to the size requested by its driver,
+e.g. extending thin device of devicemapper, modifying EBS volume or raising
+project quota of vfs. Filesystem of the volume is grown by the driver as
+well, right away if the volume is mounted. Volumes cannot be shrunk.
+*/
+func (s *daemon) processVolumeExpand(ctx context.Context, volume *Volume, request *api.VolumeExpandRequest) error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+	if request.Size <= 0 {
+		return fmt.Errorf("Invalid size %v to expand volume %v to", request.Size, volume.Name)
+	}
+	s.lockVolume(ctx, volume.Name)
+	defer s.volumeLocks.Unlock(volume.Name)
+
+	if err := s.checkDriverHealth(volume.DriverName); err != nil {
+		return err
+	}
+	if err := s.checkCapability(volume.DriverName, CAPABILITY_RESIZE); err != nil {
+		return err
+	}
+	driver, err := s.getDriver(volume.DriverName)
+	if err != nil {
+		return err
+	}
+	resizer, ok := driver.(Resizer)
+	if !ok {
+		return fmt.Errorf("BUG: Driver %v supports %v without implementing it", volume.DriverName, CAPABILITY_RESIZE)
+	}
+	if err := s.reserveQuotaExpansion(volume.Name, driver, request.Size); err != nil {
+		return err
+	}
+	defer s.releaseQuota(volume.Name, volume.DriverName)
+	release, err := s.holdVolume(volume)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	size := strconv.FormatInt(request.Size, 10)
+	req := Request{
+		Name: volume.Name,
+		Options: map[string]string{
+			OPT_SIZE: size,
+		},
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_PREPARE,
+		LOG_FIELD_EVENT:  LOG_EVENT_EXTEND,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: volume.Name,
+		LOG_FIELD_OPTS:   req.Options,
+	}).Debug()
+	start := time.Now()
+	var span *util.Span
+	req.Context, span = startDriverSpan(ctx, "ResizeVolume", volume.DriverName, volume.Name)
+	err = resizer.ResizeVolume(req)
+	span.End(err)
+	s.observeOperation(METRICS_OP_EXPAND, start, err)
+	if err != nil {
+		s.recordFailure(volume.Name, LOG_OBJECT_VOLUME, LOG_EVENT_EXTEND, volume.Name, "", err)
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:  LOG_EVENT_EXTEND,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: volume.Name,
+		LOG_FIELD_SIZE:   request.Size,
+	}).Infof("Expanded volume %v", volume.Name)
+	s.recordEvent(volume.Name, LOG_OBJECT_VOLUME, LOG_EVENT_EXTEND, volume.Name, "expanded to "+size+" bytes")
+	return nil
+}
+
+func (s *daemon) doVolumeExpand(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.VolumeExpandRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if err := util.CheckName(request.VolumeName); err != nil {
+		return err
+	}
+	volume := s.getVolume(request.VolumeName)
+	if volume == nil {
+		return fmt.Errorf("volume %v doesn't exist", request.VolumeName)
+	}
+
+	if err := s.processVolumeExpand(r.Context(), volume, request); err != nil {
+		return err
+	}
+
+	if request.Verbose {
+		resp, err := s.listVolumeInfo(volume)
+		if err != nil {
+			return err
+		}
+		return writeResponseOutput(w, resp)
+	}
+	return writeStringResponse(w, volume.Name)
+}
@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rancher/convoy/api"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "gopkg.in/check.v1"
+)
+
+// resizableDriver makes vfs volumes resizable by keeping their sizes itself,
+// since vfs only resizes volumes with project quota
+type resizableDriver struct {
+	ConvoyDriver
+	sizes map[string]int64
+}
+
+func (d *resizableDriver) Capabilities() Capabilities {
+	caps := d.ConvoyDriver.Capabilities()
+	caps.Resize = true
+	return caps
+}
+
+func (d *resizableDriver) ResizeVolume(req Request) error {
+	size, err := strconv.ParseInt(req.Options[OPT_SIZE], 10, 64)
+	if err != nil {
+		return err
+	}
+	if size <= d.sizes[req.Name] {
+		return fmt.Errorf("Volume %v can only be expanded", req.Name)
+	}
+	d.sizes[req.Name] = size
+	return nil
+}
+
+func (s *TestSuite) TestVolumeExpand(c *C) {
+	d := newTestDaemon(c)
+	driver := &resizableDriver{
+		ConvoyDriver: d.ConvoyDrivers["vfs"],
+		sizes:        make(map[string]int64),
+	}
+	d.ConvoyDrivers["vfs"] = driver
+
+	code, body := d.call(c, "POST", "/volumes/create", &api.VolumeCreateRequest{Name: "vol1"}, nil)
+	c.Assert(code, Equals, http.StatusOK, Commentf(body))
+
+	code, body = d.call(c, "POST", "/volumes/expand", &api.VolumeExpandRequest{VolumeName: "vol1", Size: 1 << 30}, nil)
+	c.Assert(code, Equals, http.StatusOK, Commentf(body))
+	c.Assert(driver.sizes["vol1"], Equals, int64(1<<30))
+
+	// Shrinking is refused by driver
+	code, body = d.call(c, "POST", "/volumes/expand", &api.VolumeExpandRequest{VolumeName: "vol1", Size: 1 << 20}, nil)
+	c.Assert(code, Equals, http.StatusBadRequest)
+	c.Assert(body, Matches, "Volume vol1 can only be expanded\n")
+	c.Assert(driver.sizes["vol1"], Equals, int64(1<<30))
+
+	code, body = d.call(c, "POST", "/volumes/expand", &api.VolumeExpandRequest{VolumeName: "vol1"}, nil)
+	c.Assert(code, Equals, http.StatusBadRequest)
+	c.Assert(body, Matches, "Invalid size 0 .*\n")
+	code, _ = d.call(c, "POST", "/volumes/expand", &api.VolumeExpandRequest{VolumeName: "vol2", Size: 1 << 30}, nil)
+	c.Assert(code, Not(Equals), http.StatusOK)
+
+	// Expanding provisions space, so it's refused in maintenance
+	c.Assert(d.setMaintenance(true, "test"), IsNil)
+	code, body = d.call(c, "POST", "/volumes/expand", &api.VolumeExpandRequest{VolumeName: "vol1", Size: 2 << 30}, nil)
+	c.Assert(code, Equals, http.StatusServiceUnavailable)
+	c.Assert(body, Matches, "Daemon is in maintenance .*: test, operation refused\n")
+	c.Assert(driver.sizes["vol1"], Equals, int64(1<<30))
+}
@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	FILESYSTEM_GROW_INTERVAL = 5 * time.Minute
+)
+
+func (s *daemon) growFilesystems() {
+	for name, driver := range s.ConvoyDrivers {
+		grower, ok := driver.(FilesystemGrower)
+		if !ok {
+			continue
+		}
+		if err := s.checkDriverHealth(name); err != nil {
+			continue
+		}
+		grown, err := grower.GrowFilesystems()
+		if err != nil {
+			log.Warnf("Failed to grow filesystems of driver %v: %v", name, err)
+			continue
+		}
+		for volumeName, size := range grown {
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+				LOG_FIELD_EVENT:  LOG_EVENT_EXTEND,
+				LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+				LOG_FIELD_VOLUME: volumeName,
+				LOG_FIELD_SIZE:   size,
+			}).Infof("Grew filesystem of volume %v to the size of expanded device", volumeName)
+			s.recordEvent(volumeName, LOG_OBJECT_VOLUME, LOG_EVENT_EXTEND, volumeName,
+				"filesystem grown to "+strconv.FormatInt(size, 10)+" bytes")
+		}
+	}
+}
+
+// startFilesystemGrower would grow filesystems of mounted volumes online,
+// after their devices are expanded
+func (s *daemon) startFilesystemGrower() {
+	go func() {
+		for {
+			time.Sleep(FILESYSTEM_GROW_INTERVAL)
+			s.growFilesystems()
+		}
+	}()
+}
@@ -0,0 +1,457 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	convoyv1 "github.com/rancher/convoy/rpc/v1"
+	"github.com/rancher/convoy/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	GRPC_PROGRESS_INTERVAL = 5 * time.Second
+)
+
+/*
+grpcServer serves the gRPC API by the handlers of HTTP API, so requests are
+validated, recorded and authorized the same way, whichever API they come
+from.
+*/
+type grpcServer struct {
+	convoyv1.UnimplementedConvoyServer
+
+	s       *daemon
+	handler http.Handler
+	// Caller of requests in audit log, principals authenticated by
+	// handler if it's empty
+	caller string
+}
+
+// startGRPCServer listens at TCP address for the gRPC API over TLS. Empty
+// address disables it.
+func (s *daemon) startGRPCServer(address string, config *tls.Config) (*grpc.Server, net.Listener, error) {
+	if address == "" {
+		return nil, nil, nil
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot listen at %v: %v", address, err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(config)))
+	convoyv1.RegisterConvoyServer(server, &grpcServer{
+		s:       s,
+		handler: s.authHandler(s.Router),
+	})
+	log.Infof("Serving gRPC API over TLS at %v", l.Addr())
+	return server, l, nil
+}
+
+// grpcResponse keeps response of HTTP API handler
+type grpcResponse struct {
+	header http.Header
+	body   bytes.Buffer
+	code   int
+}
+
+func (r *grpcResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *grpcResponse) Write(data []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	return r.body.Write(data)
+}
+
+func (r *grpcResponse) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func grpcError(code int, message string) error {
+	c := codes.Unknown
+	switch code {
+	case http.StatusUnauthorized:
+		c = codes.Unauthenticated
+	case http.StatusForbidden:
+		c = codes.PermissionDenied
+	case http.StatusNotFound:
+		c = codes.NotFound
+	case http.StatusTooManyRequests:
+		c = codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		c = codes.Unavailable
+	case http.StatusInternalServerError:
+		c = codes.Internal
+	}
+	return status.Error(c, strings.TrimSpace(message))
+}
+
+// newRequest returns HTTP API request for the gRPC call, with credentials of
+// its client
+func (g *grpcServer) newRequest(ctx context.Context, method, route string, query url.Values, request interface{}) (*http.Request, error) {
+	var body []byte
+	if request != nil {
+		var err error
+		if body, err = json.Marshal(request); err != nil {
+			return nil, err
+		}
+	}
+	path := "/v" + api.API_MAJOR_VERSION + route
+	if len(query) != 0 {
+		path += "?" + query.Encode()
+	}
+	r, err := http.NewRequest(method, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r = r.WithContext(ctx)
+	if g.caller != "" {
+		r = withAuditCaller(r, g.caller)
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) != 0 {
+			r.Header.Set("Authorization", values[0])
+		}
+		if values := md.Get(util.TRACE_PARENT_HEADER); len(values) != 0 {
+			r.Header.Set(util.TRACE_PARENT_HEADER, values[0])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state := info.State
+			r.TLS = &state
+		}
+	}
+	return r, nil
+}
+
+// call makes the request to route of HTTP API, and decodes the response into
+// resp if not nil
+func (g *grpcServer) call(ctx context.Context, method, route string, query url.Values, request, resp interface{}) error {
+	r, err := g.newRequest(ctx, method, route, query, request)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	w := &grpcResponse{
+		header: make(http.Header),
+	}
+	g.handler.ServeHTTP(w, r)
+	if w.code >= http.StatusBadRequest {
+		return grpcError(w.code, w.body.String())
+	}
+	if resp == nil {
+		return nil
+	}
+	if err := json.Unmarshal(w.body.Bytes(), resp); err != nil {
+		return status.Errorf(codes.Internal, "Invalid response of %v %v: %v", method, route, err)
+	}
+	return nil
+}
+
+func toGRPCSnapshot(snapshot *api.SnapshotResponse) *convoyv1.Snapshot {
+	return &convoyv1.Snapshot{
+		Name:            snapshot.Name,
+		VolumeName:      snapshot.VolumeName,
+		VolumeCreatedAt: snapshot.VolumeCreatedAt,
+		CreatedTime:     snapshot.CreatedTime,
+		DriverInfo:      snapshot.DriverInfo,
+	}
+}
+
+func toGRPCVolume(volume *api.VolumeResponse) *convoyv1.Volume {
+	resp := &convoyv1.Volume{
+		Name:        volume.Name,
+		Driver:      volume.Driver,
+		MountPoint:  volume.MountPoint,
+		CreatedTime: volume.CreatedTime,
+		LastMounted: volume.LastMounted,
+		LastIo:      volume.LastIO,
+		Labels:      volume.Labels,
+		DriverInfo:  volume.DriverInfo,
+		Snapshots:   make(map[string]*convoyv1.Snapshot),
+	}
+	for name, snapshot := range volume.Snapshots {
+		snapshot := snapshot
+		resp.Snapshots[name] = toGRPCSnapshot(&snapshot)
+	}
+	return resp
+}
+
+func (g *grpcServer) CreateVolume(ctx context.Context, req *convoyv1.CreateVolumeRequest) (*convoyv1.Volume, error) {
+	resp := &api.VolumeResponse{}
+	if err := g.call(ctx, "POST", "/volumes/create", nil, &api.VolumeCreateRequest{
+		Name:            req.Name,
+		DriverName:      req.DriverName,
+		Size:            req.Size,
+		BackupURL:       req.BackupUrl,
+		DriverVolumeID:  req.DriverVolumeId,
+		Type:            req.Type,
+		IOPS:            req.Iops,
+		PrepareForVM:    req.PrepareForVm,
+		Filesystem:      req.Filesystem,
+		MkfsOptions:     req.MkfsOptions,
+		MountOptions:    req.MountOptions,
+		EncryptionKey:   req.EncryptionKey,
+		KmsKeyID:        req.KmsKeyId,
+		BackupBlockSize: req.BackupBlockSize,
+		FsFreeze:        req.FsFreeze,
+		Labels:          req.Labels,
+		Verbose:         true,
+	}, resp); err != nil {
+		return nil, err
+	}
+	return toGRPCVolume(resp), nil
+}
+
+func (g *grpcServer) DeleteVolume(ctx context.Context, req *convoyv1.DeleteVolumeRequest) (*convoyv1.DeleteVolumeResponse, error) {
+	if err := g.call(ctx, "DELETE", "/volumes/", nil, &api.VolumeDeleteRequest{
+		VolumeName:    req.VolumeName,
+		ReferenceOnly: req.ReferenceOnly,
+	}, nil); err != nil {
+		return nil, err
+	}
+	return &convoyv1.DeleteVolumeResponse{}, nil
+}
+
+func (g *grpcServer) MountVolume(ctx context.Context, req *convoyv1.MountVolumeRequest) (*convoyv1.MountVolumeResponse, error) {
+	resp := &api.VolumeResponse{}
+	if err := g.call(ctx, "POST", "/volumes/mount", nil, &api.VolumeMountRequest{
+		VolumeName:   req.VolumeName,
+		MountPoint:   req.MountPoint,
+		ReadOnly:     req.ReadOnly,
+		SubPath:      req.SubPath,
+		SELinuxLabel: req.SelinuxLabel,
+		Verbose:      true,
+	}, resp); err != nil {
+		return nil, err
+	}
+	return &convoyv1.MountVolumeResponse{
+		MountPoint: resp.MountPoint,
+	}, nil
+}
+
+func (g *grpcServer) UmountVolume(ctx context.Context, req *convoyv1.UmountVolumeRequest) (*convoyv1.UmountVolumeResponse, error) {
+	if err := g.call(ctx, "POST", "/volumes/umount", nil, &api.VolumeUmountRequest{
+		VolumeName: req.VolumeName,
+	}, nil); err != nil {
+		return nil, err
+	}
+	return &convoyv1.UmountVolumeResponse{}, nil
+}
+
+func (g *grpcServer) ListVolumes(ctx context.Context, req *convoyv1.ListVolumesRequest) (*convoyv1.ListVolumesResponse, error) {
+	query := url.Values{}
+	for _, filter := range req.Filters {
+		query.Add("filter", filter)
+	}
+	if req.IdleFor != "" {
+		query.Set("idle_for", req.IdleFor)
+	}
+	volumes := make(map[string]api.VolumeResponse)
+	if err := g.call(ctx, "GET", "/volumes/list", query, nil, &volumes); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resp := &convoyv1.ListVolumesResponse{}
+	for _, name := range names {
+		volume := volumes[name]
+		resp.Volumes = append(resp.Volumes, toGRPCVolume(&volume))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) InspectVolume(ctx context.Context, req *convoyv1.InspectVolumeRequest) (*convoyv1.Volume, error) {
+	resp := &api.VolumeResponse{}
+	if err := g.call(ctx, "GET", "/volumes/", nil, &api.VolumeInspectRequest{
+		VolumeName: req.VolumeName,
+	}, resp); err != nil {
+		return nil, err
+	}
+	return toGRPCVolume(resp), nil
+}
+
+func (g *grpcServer) CreateSnapshot(ctx context.Context, req *convoyv1.CreateSnapshotRequest) (*convoyv1.Snapshot, error) {
+	resp := &api.SnapshotResponse{}
+	if err := g.call(ctx, "POST", "/snapshots/create", nil, &api.SnapshotCreateRequest{
+		Name:       req.Name,
+		VolumeName: req.VolumeName,
+		KmsKeyID:   req.KmsKeyId,
+		FsFreeze:   req.FsFreeze,
+		Verbose:    true,
+	}, resp); err != nil {
+		return nil, err
+	}
+	return toGRPCSnapshot(resp), nil
+}
+
+func (g *grpcServer) DeleteSnapshot(ctx context.Context, req *convoyv1.DeleteSnapshotRequest) (*convoyv1.DeleteSnapshotResponse, error) {
+	if err := g.call(ctx, "DELETE", "/snapshots/", nil, &api.SnapshotDeleteRequest{
+		SnapshotName: req.SnapshotName,
+	}, nil); err != nil {
+		return nil, err
+	}
+	return &convoyv1.DeleteSnapshotResponse{}, nil
+}
+
+func (g *grpcServer) InspectSnapshot(ctx context.Context, req *convoyv1.InspectSnapshotRequest) (*convoyv1.Snapshot, error) {
+	resp := &api.SnapshotResponse{}
+	if err := g.call(ctx, "GET", "/snapshots/", nil, &api.SnapshotInspectRequest{
+		SnapshotName: req.SnapshotName,
+	}, resp); err != nil {
+		return nil, err
+	}
+	return toGRPCSnapshot(resp), nil
+}
+
+/*
+CreateBackup reports the backup running every GRPC_PROGRESS_INTERVAL until it
+completes. The backup would go on if the client goes away, like the one
+requested by HTTP API.
+*/
+func (g *grpcServer) CreateBackup(req *convoyv1.CreateBackupRequest, stream convoyv1.Convoy_CreateBackupServer) error {
+	start := time.Now()
+	resp := &api.BackupURLResponse{}
+	done := make(chan error, 1)
+	go func() {
+		done <- g.call(stream.Context(), "POST", "/backups/create", nil, &api.BackupCreateRequest{
+			URL:          req.DestUrl,
+			SnapshotName: req.SnapshotName,
+			Labels:       req.Labels,
+			Verbose:      true,
+		}, resp)
+	}()
+
+	ticker := time.NewTicker(GRPC_PROGRESS_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+			return stream.Send(&convoyv1.BackupProgress{
+				State:          convoyv1.BackupProgress_COMPLETED,
+				ElapsedSeconds: time.Since(start).Seconds(),
+				BackupUrl:      resp.URL,
+			})
+		case <-ticker.C:
+			if err := stream.Send(&convoyv1.BackupProgress{
+				State:          convoyv1.BackupProgress_RUNNING,
+				ElapsedSeconds: time.Since(start).Seconds(),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (g *grpcServer) DeleteBackup(ctx context.Context, req *convoyv1.DeleteBackupRequest) (*convoyv1.DeleteBackupResponse, error) {
+	if err := g.call(ctx, "DELETE", "/backups", nil, &api.BackupDeleteRequest{
+		URL: req.BackupUrl,
+	}, nil); err != nil {
+		return nil, err
+	}
+	return &convoyv1.DeleteBackupResponse{}, nil
+}
+
+func (g *grpcServer) ListBackups(ctx context.Context, req *convoyv1.ListBackupsRequest) (*convoyv1.ListBackupsResponse, error) {
+	backups := make(map[string]map[string]string)
+	if err := g.call(ctx, "GET", "/backups/list", nil, &api.BackupListRequest{
+		URL:          req.DestUrl,
+		VolumeName:   req.VolumeName,
+		SnapshotName: req.SnapshotName,
+		Filters:      req.Filters,
+		Since:        req.Since,
+		Until:        req.Until,
+	}, &backups); err != nil {
+		return nil, err
+	}
+	urls := []string{}
+	for backupURL := range backups {
+		urls = append(urls, backupURL)
+	}
+	sort.Strings(urls)
+	resp := &convoyv1.ListBackupsResponse{}
+	for _, backupURL := range urls {
+		resp.Backups = append(resp.Backups, &convoyv1.Backup{
+			Url:  backupURL,
+			Info: backups[backupURL],
+		})
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) InspectBackup(ctx context.Context, req *convoyv1.InspectBackupRequest) (*convoyv1.Backup, error) {
+	info := make(map[string]string)
+	if err := g.call(ctx, "GET", "/backups/inspect", nil, &api.BackupListRequest{
+		URL: req.BackupUrl,
+	}, &info); err != nil {
+		return nil, err
+	}
+	return &convoyv1.Backup{
+		Url:  req.BackupUrl,
+		Info: info,
+	}, nil
+}
+
+// WatchEvents is a read, open to every principal
+func (g *grpcServer) WatchEvents(req *convoyv1.WatchEventsRequest, stream convoyv1.Convoy_WatchEventsServer) error {
+	if g.s.auth != nil {
+		r, err := g.newRequest(stream.Context(), "GET", "/events", nil, nil)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if _, err := g.s.auth.authenticate(r); err != nil {
+			if _, ok := err.(*authThrottledError); ok {
+				return status.Error(codes.ResourceExhausted, err.Error())
+			}
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+	}
+
+	watcher := g.s.watchEvents(req.VolumeName)
+	defer g.s.unwatchEvents(watcher)
+
+	for {
+		select {
+		case event := <-watcher.events:
+			if err := stream.Send(&convoyv1.Event{
+				VolumeName: event.VolumeName,
+				Time:       event.Time,
+				Object:     event.Object,
+				Event:      event.Event,
+				Name:       event.Name,
+				Detail:     event.Detail,
+				Error:      event.Error,
+			}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+type driverHealth struct {
+	Healthy   bool
+	Error     string
+	CheckedAt string
+	// Since is the time driver entered current state
+	Since string
+}
+
+/*
+startHealthProbes would probe drivers implementing HealthChecker every
+interval seconds. A probe not returning within the interval would be treated
+as failure, so a hung backend wouldn't block the probes of the others, and
+would be waited for by the next probe rather than probed again.
+*/
+func (s *daemon) startHealthProbes(interval int) {
+	s.driverHealth = make(map[string]*driverHealth)
+	if interval <= 0 {
+		return
+	}
+	timeout := time.Duration(interval) * time.Second
+	for name, driver := range s.ConvoyDrivers {
+		checker, ok := driver.(HealthChecker)
+		if !ok {
+			continue
+		}
+		s.driverHealth[name] = &driverHealth{
+			Healthy: true,
+			Since:   util.Now(),
+		}
+		probe := s.getHealthProbe(name, checker)
+		go func(name string) {
+			for {
+				s.updateDriverHealth(name, probe.Run(timeout))
+				time.Sleep(timeout)
+			}
+		}(name)
+	}
+}
+
+// getHealthProbe returns probe of the driver, shared by periodic probes and
+// /readyz, so a hung driver is probed at most once at a time
+func (s *daemon) getHealthProbe(name string, checker HealthChecker) *util.Probe {
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+
+	if s.healthProbes == nil {
+		s.healthProbes = make(map[string]*util.Probe)
+	}
+	probe, exists := s.healthProbes[name]
+	if !exists {
+		probe = util.NewProbe(checker.CheckHealth)
+		s.healthProbes[name] = probe
+	}
+	return probe
+}
+
+func (s *daemon) updateDriverHealth(name string, err error) {
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+
+	health := s.driverHealth[name]
+	now := util.Now()
+	health.CheckedAt = now
+	if err == nil {
+		if !health.Healthy {
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_RECOVERY,
+				LOG_FIELD_EVENT:  LOG_EVENT_HEALTH,
+				LOG_FIELD_OBJECT: LOG_OBJECT_DRIVER,
+				LOG_FIELD_DRIVER: name,
+			}).Infof("Driver %v recovered, was degraded since %v", name, health.Since)
+			s.recordDriverEvent(name, LOG_EVENT_HEALTH, "recovered, was degraded since "+health.Since)
+			health.Since = now
+		}
+		health.Healthy = true
+		health.Error = ""
+		return
+	}
+	if health.Healthy {
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON: LOG_REASON_DEGRADED,
+			LOG_FIELD_EVENT:  LOG_EVENT_HEALTH,
+			LOG_FIELD_OBJECT: LOG_OBJECT_DRIVER,
+			LOG_FIELD_DRIVER: name,
+		}).Errorf("Driver %v is degraded: %v", name, err)
+		s.recordDriverEvent(name, LOG_EVENT_HEALTH, "degraded: "+err.Error())
+		health.Since = now
+	}
+	health.Healthy = false
+	health.Error = err.Error()
+}
+
+// checkDriverHealth would fail fast if the driver was found degraded by the
+// last probe
+func (s *daemon) checkDriverHealth(name string) error {
+	s.healthMutex.RLock()
+	defer s.healthMutex.RUnlock()
+
+	health, exists := s.driverHealth[name]
+	if !exists || health.Healthy {
+		return nil
+	}
+	return APIError{
+		statusCode: http.StatusServiceUnavailable,
+		error: fmt.Sprintf("Driver %v is degraded since %v: %v, operation refused",
+			name, health.Since, health.Error),
+	}
+}
+
+func (s *daemon) addDriverHealthInfo(name string, info map[string]string) {
+	s.healthMutex.RLock()
+	defer s.healthMutex.RUnlock()
+
+	health, exists := s.driverHealth[name]
+	if !exists {
+		return
+	}
+	info["Healthy"] = strconv.FormatBool(health.Healthy)
+	info["HealthSince"] = health.Since
+	info["HealthCheckedAt"] = health.CheckedAt
+	if !health.Healthy {
+		info["HealthError"] = health.Error
+	}
+}
+
+/*
+startDestinationProbes would probe backup destinations of schedules, and
+members of destination groups, every interval seconds. Scheduled backups to a
+destination found unavailable would fail fast until it recovers.
+*/
+func (s *daemon) startDestinationProbes(interval int) {
+	if interval <= 0 {
+		return
+	}
+	timeout := time.Duration(interval) * time.Second
+	go func() {
+		for {
+			destURLs := []string{}
+			schedules, err := s.listVolumeSchedules()
+			if err != nil {
+				log.Warnf("Failed to list schedules for probing destinations: %v", err)
+			}
+			for _, schedule := range schedules {
+				if schedule.DestURL != "" {
+					destURLs = append(destURLs, schedule.DestURL)
+				}
+			}
+			objectstore.ProbeDestinations(destURLs, timeout)
+			time.Sleep(timeout)
+		}
+	}()
+}
+
+// checkDestinationHealth would fail fast if the destination of the volume was
+// found unavailable by probes
+func (s *daemon) checkDestinationHealth(destURL, volumeName string) error {
+	if err := objectstore.CheckDestinationHealth(destURL, volumeName); err != nil {
+		return APIError{
+			statusCode: http.StatusServiceUnavailable,
+			error:      fmt.Sprintf("%v, backup refused", err),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+const (
+	HEALTH_STATUS_OK     = "ok"
+	HEALTH_STATUS_FAILED = "failed"
+
+	HEALTH_COMPONENT_METADATA      = "metadata"
+	HEALTH_COMPONENT_DRIVER_PREFIX = "driver."
+
+	// Drivers without periodic probes are probed by /readyz, which cannot
+	// wait longer than orchestrators would
+	READYZ_PROBE_TIMEOUT = 10 * time.Second
+)
+
+// checkMetadataStore verifies root directory, where locks and logs are kept,
+// is writable, and the store of config and metadata of volumes is available
+func (s *daemon) checkMetadataStore() error {
+	if err := util.CheckMetadataStore(); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(s.Root, ".healthz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write([]byte(util.Now())); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func componentHealth(err error) api.ComponentHealth {
+	if err != nil {
+		return api.ComponentHealth{
+			Healthy:   false,
+			Error:     err.Error(),
+			CheckedAt: util.Now(),
+		}
+	}
+	return api.ComponentHealth{
+		Healthy:   true,
+		CheckedAt: util.Now(),
+	}
+}
+
+/*
+driverComponentHealth returns result of the last periodic probe of driver, by
+--health-check-interval. Drivers without periodic probes are probed now.
+*/
+func (s *daemon) driverComponentHealth(name string, driver ConvoyDriver) api.ComponentHealth {
+	s.healthMutex.RLock()
+	health, exists := s.driverHealth[name]
+	if exists {
+		resp := api.ComponentHealth{
+			Healthy:   health.Healthy,
+			Error:     health.Error,
+			CheckedAt: health.CheckedAt,
+		}
+		s.healthMutex.RUnlock()
+		return resp
+	}
+	s.healthMutex.RUnlock()
+
+	checker, ok := driver.(HealthChecker)
+	if !ok {
+		// Nothing to check beyond driver being loaded
+		return componentHealth(nil)
+	}
+	return componentHealth(s.getHealthProbe(name, checker).Run(READYZ_PROBE_TIMEOUT))
+}
+
+func writeHealthResponse(w http.ResponseWriter, components map[string]api.ComponentHealth) error {
+	resp := api.HealthResponse{
+		Status:     HEALTH_STATUS_OK,
+		Components: components,
+	}
+	for _, c := range components {
+		if !c.Healthy {
+			resp.Status = HEALTH_STATUS_FAILED
+		}
+	}
+	output, err := api.ResponseOutput(resp)
+	if err != nil {
+		return err
+	}
+	if resp.Status != HEALTH_STATUS_OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, err = w.Write(output)
+	return err
+}
+
+/*
+doHealthz answers whether daemon is alive, i.e. serving requests with its
+metadata store writable. Backends are not checked, since restarting daemon
+wouldn't fix them, see doReadyz.
+*/
+func (s *daemon) doHealthz(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	return writeHealthResponse(w, map[string]api.ComponentHealth{
+		HEALTH_COMPONENT_METADATA: componentHealth(s.checkMetadataStore()),
+	})
+}
+
+// doReadyz answers whether daemon is ready to serve volumes, i.e. alive, not
+// in maintenance and all its drivers are healthy
+func (s *daemon) doReadyz(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	components := map[string]api.ComponentHealth{
+		HEALTH_COMPONENT_METADATA:    componentHealth(s.checkMetadataStore()),
+		HEALTH_COMPONENT_MAINTENANCE: s.maintenanceComponentHealth(),
+	}
+	for name, driver := range s.ConvoyDrivers {
+		components[HEALTH_COMPONENT_DRIVER_PREFIX+name] = s.driverComponentHealth(name, driver)
+	}
+	return writeHealthResponse(w, components)
+}
+
+/*
+notifySystemd tells systemd daemon is ready, if it's started as service of
+Type=notify, and pets the watchdog of WatchdogSec= at half of its interval
+as long as daemon is alive, by the same check of /healthz.
+*/
+func (s *daemon) notifySystemd() {
+	sent, err := util.SdNotify(util.SD_NOTIFY_READY)
+	if err != nil {
+		log.Warnf("Failed to notify systemd of readiness: %v", err)
+		return
+	}
+	if !sent {
+		return
+	}
+	interval, err := util.SdWatchdogInterval()
+	if err != nil {
+		log.Warnf("Failed to get systemd watchdog interval: %v", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	log.Debugf("Notifying systemd watchdog every %v", interval/2)
+	go func() {
+		for {
+			time.Sleep(interval / 2)
+			if err := s.checkMetadataStore(); err != nil {
+				log.Errorf("Metadata store isn't writable, not notifying systemd watchdog: %v", err)
+				continue
+			}
+			if _, err := util.SdNotify(util.SD_NOTIFY_WATCHDOG); err != nil {
+				log.Warnf("Failed to notify systemd watchdog: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,226 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	HISTORY_DIR = "history"
+
+	// Oldest events would be dropped once exceeded
+	MAX_VOLUME_EVENTS = 1000
+)
+
+type volumeHistory struct {
+	Name   string
+	Events []api.VolumeEvent
+
+	root string
+}
+
+func (h *volumeHistory) ConfigFile() (string, error) {
+	if h.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if h.root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty history root")
+	}
+	return filepath.Join(h.root, HISTORY_DIR, VOLUME_CFG_PREFIX+h.Name+CFG_POSTFIX), nil
+}
+
+func (s *daemon) loadVolumeHistory(volumeName string) (*volumeHistory, error) {
+	history := &volumeHistory{
+		Name: volumeName,
+		root: s.Root,
+	}
+	if err := util.ObjectLoad(history); err != nil && !util.IsNotExistsError(err) {
+		return nil, err
+	}
+	return history, nil
+}
+
+// recordEvent appends an event to volume's history. Failure to record would
+// only be logged, since the operation itself has already completed.
+func (s *daemon) recordEvent(volumeName, object, event, name, detail string) {
+	s.queueSiteHooks(volumeName, "", object, event, name, detail)
+
+	volumeEvent := api.VolumeEvent{
+		Time:   util.Now(),
+		Object: object,
+		Event:  event,
+		Name:   name,
+		Detail: detail,
+	}
+	s.appendVolumeEvent(volumeName, volumeEvent)
+}
+
+func (s *daemon) appendVolumeEvent(volumeName string, volumeEvent api.VolumeEvent) {
+	object, event := volumeEvent.Object, volumeEvent.Event
+	s.publishEvent(volumeName, volumeEvent)
+
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+
+	if err := util.MkdirIfNotExists(filepath.Join(s.Root, HISTORY_DIR)); err != nil {
+		log.Warnf("Failed to record %v %v event for volume %v: %v", object, event, volumeName, err)
+		return
+	}
+	history, err := s.loadVolumeHistory(volumeName)
+	if err != nil {
+		log.Warnf("Failed to record %v %v event for volume %v: %v", object, event, volumeName, err)
+		return
+	}
+	history.Events = append(history.Events, volumeEvent)
+	if len(history.Events) > MAX_VOLUME_EVENTS {
+		history.Events = history.Events[len(history.Events)-MAX_VOLUME_EVENTS:]
+	}
+	if err := util.ObjectSave(history); err != nil {
+		log.Warnf("Failed to record %v %v event for volume %v: %v", object, event, volumeName, err)
+	}
+}
+
+func (s *daemon) deleteVolumeHistory(volumeName string) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+
+	history := &volumeHistory{
+		Name: volumeName,
+		root: s.Root,
+	}
+	if exists, err := util.ObjectExists(history); err != nil || !exists {
+		return
+	}
+	if err := util.ObjectDelete(history); err != nil {
+		log.Warnf("Failed to delete history of volume %v: %v", volumeName, err)
+	}
+}
+
+func parseTime(t string) time.Time {
+	parsed, err := time.Parse(time.RubyDate, t)
+	if err != nil {
+		return time.Time{}
+	}
+	return parsed
+}
+
+type eventsByTime []api.VolumeEvent
+
+func (e eventsByTime) Len() int      { return len(e) }
+func (e eventsByTime) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e eventsByTime) Less(i, j int) bool {
+	return parseTime(e[i].Time).Before(parseTime(e[j].Time))
+}
+
+func formatAge(now, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds ago", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh%dm ago", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dd%dh ago", int(d.Hours())/24, int(d.Hours())%24)
+}
+
+/*
+getVolumeTimeline merges the recorded events of the volume with its existing
+snapshots, and backups at destURL if specified, in chronological order.
+*/
+func (s *daemon) getVolumeTimeline(volume *Volume, destURL string) (*api.VolumeTimelineResponse, error) {
+	s.historyMutex.Lock()
+	history, err := s.loadVolumeHistory(volume.Name)
+	s.historyMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []api.VolumeEvent{}
+	seen := map[string]bool{}
+	for _, event := range history.Events {
+		entries = append(entries, event)
+		seen[event.Object+"/"+event.Event+"/"+event.Name] = true
+	}
+
+	// Snapshots and backups may predate the history
+	if snapshots, err := s.listSnapshotDriverInfos(volume); err == nil {
+		for name, snapshot := range snapshots {
+			if seen[LOG_OBJECT_SNAPSHOT+"/"+LOG_EVENT_CREATE+"/"+name] {
+				continue
+			}
+			entries = append(entries, api.VolumeEvent{
+				Time:   snapshot[OPT_SNAPSHOT_CREATED_TIME],
+				Object: LOG_OBJECT_SNAPSHOT,
+				Event:  LOG_EVENT_CREATE,
+				Name:   name,
+			})
+		}
+	}
+	if destURL != "" {
+		backupOps, err := s.getBackupOpsForVolume(volume)
+		if err != nil {
+			return nil, err
+		}
+		backups, err := backupOps.ListBackup(destURL, map[string]string{
+			OPT_VOLUME_NAME: volume.Name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for url, backup := range backups {
+			if seen[LOG_OBJECT_BACKUP_URL+"/"+LOG_EVENT_BACKUP+"/"+url] {
+				continue
+			}
+			entries = append(entries, api.VolumeEvent{
+				Time:   backup["CreatedTime"],
+				Object: LOG_OBJECT_BACKUP_URL,
+				Event:  LOG_EVENT_BACKUP,
+				Name:   url,
+				Detail: "snapshot " + backup["SnapshotName"],
+			})
+		}
+	}
+
+	sort.Stable(eventsByTime(entries))
+	now := time.Now()
+	for i := range entries {
+		entries[i].Age = formatAge(now, parseTime(entries[i].Time))
+	}
+	return &api.VolumeTimelineResponse{
+		VolumeName: volume.Name,
+		Events:     entries,
+	}, nil
+}
+
+func (s *daemon) doVolumeTimeline(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.VolumeTimelineRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if err := util.CheckName(request.VolumeName); err != nil {
+		return err
+	}
+	volume := s.getVolume(request.VolumeName)
+	if volume == nil {
+		return notFoundAPIError
+	}
+	timeline, err := s.getVolumeTimeline(volume, util.UnescapeURL(request.URL))
+	if err != nil {
+		return err
+	}
+	return writeResponseOutput(w, timeline)
+}
@@ -0,0 +1,321 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	HOOK_DIR = "hooks"
+
+	DEFAULT_HOOK_TIMEOUT = "30s"
+
+	// Snapshot would fail if pre-snapshot hook fails
+	HOOK_ON_FAILURE_ABORT = "abort"
+	// Snapshot would be taken anyway, only crash-consistent
+	HOOK_ON_FAILURE_CONTINUE = "continue"
+
+	HOOK_PRE_SNAPSHOT  = "pre-snapshot"
+	HOOK_POST_SNAPSHOT = "post-snapshot"
+)
+
+/*
+volumeHook runs executables before and after snapshot of the volume is taken,
+e.g. to flush and lock tables of a database on it, so the snapshot is
+application-consistent. OnFailure decides whether the snapshot goes on if
+PreSnapshot fails.
+*/
+type volumeHook struct {
+	Name         string
+	PreSnapshot  string
+	PostSnapshot string
+	Timeout      string
+	OnFailure    string
+	CreatedTime  string
+
+	root string
+}
+
+func (h *volumeHook) ConfigFile() (string, error) {
+	if h.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if h.root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty hook root")
+	}
+	return filepath.Join(h.root, HOOK_DIR, VOLUME_CFG_PREFIX+h.Name+CFG_POSTFIX), nil
+}
+
+// checkHookExecutable makes sure hook is an executable only root can change,
+// since daemon runs it as root
+func checkHookExecutable(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("Hook %v must be an absolute path", path)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st.IsDir() || st.Mode()&0111 == 0 {
+		return fmt.Errorf("Hook %v is not executable", path)
+	}
+	if sys, ok := st.Sys().(*syscall.Stat_t); ok && sys.Uid != 0 {
+		return fmt.Errorf("Hook %v must be owned by root", path)
+	}
+	if st.Mode()&0022 != 0 {
+		return fmt.Errorf("Hook %v must not be writable by group or others", path)
+	}
+	return nil
+}
+
+// loadVolumeHook returns nil if the volume has no hook, caller should hold
+// hookMutex
+func (s *daemon) loadVolumeHook(volumeName string) (*volumeHook, error) {
+	hook := &volumeHook{
+		Name: volumeName,
+		root: s.Root,
+	}
+	exists, err := util.ObjectExists(hook)
+	if err != nil || !exists {
+		return nil, err
+	}
+	if err := util.ObjectLoad(hook); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+func (s *daemon) getVolumeHook(volumeName string) (*volumeHook, error) {
+	s.hookMutex.Lock()
+	defer s.hookMutex.Unlock()
+
+	return s.loadVolumeHook(volumeName)
+}
+
+func (s *daemon) deleteVolumeHook(volumeName string) {
+	s.hookMutex.Lock()
+	defer s.hookMutex.Unlock()
+
+	hook := &volumeHook{
+		Name: volumeName,
+		root: s.Root,
+	}
+	if exists, err := util.ObjectExists(hook); err != nil || !exists {
+		return
+	}
+	if err := util.ObjectDelete(hook); err != nil {
+		log.Warnf("Failed to delete hook of volume %v: %v", volumeName, err)
+	}
+}
+
+/*
+runSnapshotHook runs the hook of phase with the snapshot's information in
+environment:
+
+	CONVOY_HOOK             pre-snapshot or post-snapshot
+	CONVOY_VOLUME_NAME      name of the volume
+	CONVOY_SNAPSHOT_NAME    name of the snapshot
+	CONVOY_MOUNTPOINT       mount point of the volume, empty if not mounted
+	CONVOY_SNAPSHOT_RESULT  success or failure, post-snapshot hook only
+
+Failure would be recorded as an event of the snapshot.
+*/
+func (s *daemon) runSnapshotHook(hook *volumeHook, phase, snapshotName, mountPoint string, snapshotErr error) error {
+	path := hook.PreSnapshot
+	if phase == HOOK_POST_SNAPSHOT {
+		path = hook.PostSnapshot
+	}
+	if path == "" {
+		return nil
+	}
+	timeout, err := util.ParseDuration(hook.Timeout)
+	if err != nil {
+		return err
+	}
+	env := []string{
+		"CONVOY_HOOK=" + phase,
+		"CONVOY_VOLUME_NAME=" + hook.Name,
+		"CONVOY_SNAPSHOT_NAME=" + snapshotName,
+		"CONVOY_MOUNTPOINT=" + mountPoint,
+	}
+	if phase == HOOK_POST_SNAPSHOT {
+		result := "success"
+		if snapshotErr != nil {
+			result = "failure"
+		}
+		env = append(env, "CONVOY_SNAPSHOT_RESULT="+result)
+	}
+
+	log.Debugf("Running %v hook %v of volume %v for snapshot %v", phase, path, hook.Name, snapshotName)
+	output, err := util.ExecuteWithEnv(path, []string{}, env, timeout)
+	if err != nil {
+		log.Warnf("The %v hook of volume %v failed: %v", phase, hook.Name, err)
+		s.recordEvent(hook.Name, LOG_OBJECT_SNAPSHOT, LOG_EVENT_HOOK, snapshotName, phase+" hook failed: "+err.Error())
+		return err
+	}
+	log.Debugf("The %v hook of volume %v completed, output: %v", phase, hook.Name, output)
+	return nil
+}
+
+/*
+snapshotWithHooks calls create between the volume's hooks if it has any. The
+post-snapshot hook always runs once pre-snapshot hook has been called, even
+if either failed, so anything locked can be released.
+*/
+func (s *daemon) snapshotWithHooks(volume *Volume, snapshotName string, create func() error) error {
+	hook, err := s.getVolumeHook(volume.Name)
+	if err != nil {
+		return err
+	}
+	if hook == nil {
+		return create()
+	}
+	mountPoint, err := s.getVolumeMountPoint(volume)
+	if err != nil {
+		return err
+	}
+
+	if err := s.runSnapshotHook(hook, HOOK_PRE_SNAPSHOT, snapshotName, mountPoint, nil); err != nil {
+		if hook.OnFailure != HOOK_ON_FAILURE_CONTINUE {
+			s.runSnapshotHook(hook, HOOK_POST_SNAPSHOT, snapshotName, mountPoint, err)
+			return fmt.Errorf("Snapshot of volume %v aborted, %v hook failed: %v", volume.Name, HOOK_PRE_SNAPSHOT, err)
+		}
+		log.Warnf("Continue snapshot of volume %v despite %v hook failure", volume.Name, HOOK_PRE_SNAPSHOT)
+	}
+	createErr := create()
+	s.runSnapshotHook(hook, HOOK_POST_SNAPSHOT, snapshotName, mountPoint, createErr)
+	return createErr
+}
+
+func hookResponse(hook *volumeHook) api.HookResponse {
+	return api.HookResponse{
+		VolumeName:   hook.Name,
+		PreSnapshot:  hook.PreSnapshot,
+		PostSnapshot: hook.PostSnapshot,
+		Timeout:      hook.Timeout,
+		OnFailure:    hook.OnFailure,
+		CreatedTime:  hook.CreatedTime,
+	}
+}
+
+func (s *daemon) doHookSet(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.HookSetRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	volumeName := request.VolumeName
+	if err := util.CheckName(volumeName); err != nil {
+		return err
+	}
+	volume := s.getVolume(volumeName)
+	if volume == nil {
+		return fmt.Errorf("volume %v doesn't exist", volumeName)
+	}
+	if request.PreSnapshot == "" && request.PostSnapshot == "" {
+		return fmt.Errorf("At least one of %v and %v hooks should be specified", HOOK_PRE_SNAPSHOT, HOOK_POST_SNAPSHOT)
+	}
+	for _, path := range []string{request.PreSnapshot, request.PostSnapshot} {
+		if path == "" {
+			continue
+		}
+		if err := checkHookExecutable(path); err != nil {
+			return err
+		}
+	}
+	if request.Timeout == "" {
+		request.Timeout = DEFAULT_HOOK_TIMEOUT
+	}
+	timeout, err := util.ParseDuration(request.Timeout)
+	if err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("Timeout of hook must be positive")
+	}
+	if request.OnFailure == "" {
+		request.OnFailure = HOOK_ON_FAILURE_ABORT
+	}
+	if request.OnFailure != HOOK_ON_FAILURE_ABORT && request.OnFailure != HOOK_ON_FAILURE_CONTINUE {
+		return fmt.Errorf("Invalid failure policy %v, should be %v or %v",
+			request.OnFailure, HOOK_ON_FAILURE_ABORT, HOOK_ON_FAILURE_CONTINUE)
+	}
+	if err := s.checkCapability(volume.DriverName, CAPABILITY_SNAPSHOT); err != nil {
+		return err
+	}
+
+	s.hookMutex.Lock()
+	defer s.hookMutex.Unlock()
+
+	if err := util.MkdirIfNotExists(filepath.Join(s.Root, HOOK_DIR)); err != nil {
+		return err
+	}
+	hook, err := s.loadVolumeHook(volumeName)
+	if err != nil {
+		return err
+	}
+	if hook == nil {
+		hook = &volumeHook{
+			Name:        volumeName,
+			CreatedTime: util.Now(),
+			root:        s.Root,
+		}
+	}
+	hook.PreSnapshot = request.PreSnapshot
+	hook.PostSnapshot = request.PostSnapshot
+	hook.Timeout = request.Timeout
+	hook.OnFailure = request.OnFailure
+	if err := util.ObjectSave(hook); err != nil {
+		return err
+	}
+	s.recordEvent(volumeName, LOG_OBJECT_VOLUME, LOG_EVENT_HOOK, volumeName, "snapshot hooks set")
+	return writeResponseOutput(w, hookResponse(hook))
+}
+
+func (s *daemon) doHookList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	s.hookMutex.Lock()
+	defer s.hookMutex.Unlock()
+
+	resp := make(map[string]api.HookResponse)
+	volumeNames, err := util.ListConfigIDs(filepath.Join(s.Root, HOOK_DIR), VOLUME_CFG_PREFIX, CFG_POSTFIX)
+	if err != nil {
+		return err
+	}
+	for _, volumeName := range volumeNames {
+		hook, err := s.loadVolumeHook(volumeName)
+		if err != nil {
+			return err
+		}
+		if hook != nil {
+			resp[hook.Name] = hookResponse(hook)
+		}
+	}
+	return writeResponseOutput(w, resp)
+}
+
+func (s *daemon) doHookDelete(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.HookDeleteRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if err := util.CheckName(request.VolumeName); err != nil {
+		return err
+	}
+	hook, err := s.getVolumeHook(request.VolumeName)
+	if err != nil {
+		return err
+	}
+	if hook == nil {
+		return fmt.Errorf("Hook of volume %v doesn't exist", request.VolumeName)
+	}
+	s.deleteVolumeHook(request.VolumeName)
+	s.recordEvent(request.VolumeName, LOG_OBJECT_VOLUME, LOG_EVENT_HOOK, request.VolumeName, "snapshot hooks deleted")
+	return nil
+}
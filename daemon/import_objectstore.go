@@ -1,6 +1,8 @@
 package daemon
 
 import (
+	// Involve GCS objecstore drivers for registeration
+	_ "github.com/rancher/convoy/gcs"
 	// Involve S3 objecstore drivers for registeration
 	_ "github.com/rancher/convoy/s3"
 	// Involve VFS convoy driver/objectstore driver for registeration
@@ -0,0 +1,176 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	INVENTORY_REQUEST_TIMEOUT = 30 * time.Second
+)
+
+// inventoryReport is what daemon POSTs to the inventory service as JSON
+type inventoryReport struct {
+	Host       string
+	ReportedAt string
+	// Drivers are keyed by driver name, with the same information as
+	// "convoy info", including capacity and health where available
+	Drivers map[string]map[string]string
+	Volumes []inventoryVolume
+}
+
+type inventoryVolume struct {
+	Name       string
+	Driver     string
+	Size       string
+	MountPoint string
+	Labels     map[string]string `json:",omitempty"`
+}
+
+type inventoryReporter struct {
+	url      string
+	token    string
+	interval time.Duration
+	client   *http.Client
+}
+
+/*
+startInventoryReporter would report volumes, driver capacity and health of
+the host to inventoryURL on start and every interval afterwards, so central
+asset systems can track storage without querying every host. Failed reports
+are logged and retried at the next interval.
+*/
+func (s *daemon) startInventoryReporter(inventoryURL, interval, token string) error {
+	if inventoryURL == "" {
+		return nil
+	}
+	u, err := url.Parse(inventoryURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid inventory URL %q, should be http:// or https://", inventoryURL)
+	}
+	reportInterval, err := util.ParseDuration(interval)
+	if err != nil {
+		return fmt.Errorf("Invalid inventory interval: %v", err)
+	}
+	if reportInterval <= 0 {
+		return fmt.Errorf("Invalid inventory interval %v, must be positive", interval)
+	}
+	if token != "" {
+		if err := checkSecret(token, "inventory token"); err != nil {
+			return err
+		}
+	}
+
+	reporter := &inventoryReporter{
+		url:      inventoryURL,
+		token:    token,
+		interval: reportInterval,
+		client:   &http.Client{Timeout: INVENTORY_REQUEST_TIMEOUT},
+	}
+	go func() {
+		for {
+			if err := s.reportInventory(reporter); err != nil {
+				log.WithFields(logrus.Fields{
+					LOG_FIELD_REASON: LOG_REASON_FAILURE,
+					LOG_FIELD_EVENT:  LOG_EVENT_INVENTORY,
+				}).Warnf("Failed to report inventory to %v: %v", reporter.url, err)
+			}
+			time.Sleep(reporter.interval)
+		}
+	}()
+	return nil
+}
+
+func (s *daemon) getInventoryReport() (*inventoryReport, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	report := &inventoryReport{
+		Host:       host,
+		ReportedAt: util.Now(),
+		Drivers:    make(map[string]map[string]string),
+		Volumes:    []inventoryVolume{},
+	}
+	for name, driver := range s.ConvoyDrivers {
+		info, err := driver.Info()
+		if err != nil {
+			info = map[string]string{"Error": err.Error()}
+		}
+		info["Capabilities"] = capabilityList(driver.Capabilities())
+		s.addDriverHealthInfo(name, info)
+		report.Drivers[name] = info
+	}
+	for name := range s.getVolumeList() {
+		volume := s.getVolume(name)
+		if volume == nil {
+			// Deleted since listed
+			continue
+		}
+		r, err := s.listVolumeInfo(volume)
+		if err != nil {
+			log.Warnf("Failed to get volume %v for inventory: %v", name, err)
+			continue
+		}
+		report.Volumes = append(report.Volumes, inventoryVolume{
+			Name:       r.Name,
+			Driver:     r.Driver,
+			Size:       r.DriverInfo[OPT_SIZE],
+			MountPoint: r.MountPoint,
+			Labels:     r.Labels,
+		})
+	}
+	return report, nil
+}
+
+func (s *daemon) reportInventory(reporter *inventoryReporter) error {
+	report, err := s.getInventoryReport()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", reporter.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if reporter.token != "" {
+		// Read every time, so the token can be rotated without restarting
+		// daemon
+		token, err := getSecret(reporter.token, "inventory token")
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := reporter.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("inventory service responded %v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:  LOG_EVENT_INVENTORY,
+	}).Debugf("Reported %v volumes to inventory service %v", len(report.Volumes), reporter.url)
+	return nil
+}
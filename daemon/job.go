@@ -0,0 +1,379 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	JOB_DIR        = "jobs"
+	JOB_CFG_PREFIX = "job_"
+
+	JOB_TYPE_VOLUME_CREATE   = "volume-create"
+	JOB_TYPE_VOLUME_CLONE    = "volume-clone"
+	JOB_TYPE_SNAPSHOT_CREATE = "snapshot-create"
+	JOB_TYPE_BACKUP_CREATE   = "backup-create"
+
+	// Waiting for one of cap(jobSlots) slots, can be cancelled
+	JOB_STATE_PENDING   = "pending"
+	JOB_STATE_RUNNING   = "running"
+	JOB_STATE_SUCCEEDED = "succeeded"
+	JOB_STATE_FAILED    = "failed"
+	JOB_STATE_CANCELLED = "cancelled"
+
+	// Finished jobs are kept for the period before they're removed
+	JOB_RETENTION = 7 * 24 * time.Hour
+)
+
+/*
+job runs a long operation, i.e. restore, snapshot or backup, in background on
+behalf of a request with Async, so the client doesn't have to hold the
+request for minutes. Jobs are saved in JOB_DIR of root as their state
+changes, so they can be queried after daemon restarts. Requests are not
+saved, since they may carry secrets like encryption keys, so jobs
+interrupted by restart are failed rather than run again.
+*/
+type job struct {
+	ID           string
+	Type         string
+	State        string
+	VolumeName   string
+	Result       string
+	Error        string
+	CreatedTime  string
+	StartedTime  string
+	FinishedTime string
+
+	root string
+	// Returns result of the job, e.g. the backup URL
+	run    func(ctx context.Context) (string, error)
+	cancel chan struct{}
+	// Continues the trace of the request starting the job
+	ctx context.Context
+}
+
+func (j *job) ConfigFile() (string, error) {
+	if j.ID == "" {
+		return "", fmt.Errorf("BUG: Invalid empty job ID")
+	}
+	if j.root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty job root")
+	}
+	return filepath.Join(j.root, JOB_DIR, JOB_CFG_PREFIX+j.ID+CFG_POSTFIX), nil
+}
+
+func (j *job) finished() bool {
+	return j.State == JOB_STATE_SUCCEEDED || j.State == JOB_STATE_FAILED || j.State == JOB_STATE_CANCELLED
+}
+
+func jobResponse(j *job) api.JobResponse {
+	return api.JobResponse{
+		ID:           j.ID,
+		Type:         j.Type,
+		State:        j.State,
+		VolumeName:   j.VolumeName,
+		Result:       j.Result,
+		Error:        j.Error,
+		CreatedTime:  j.CreatedTime,
+		StartedTime:  j.StartedTime,
+		FinishedTime: j.FinishedTime,
+	}
+}
+
+/*
+initJobs loads jobs saved before daemon started. Jobs which hadn't finished
+were interrupted, and are failed. Finished jobs older than JOB_RETENTION are
+removed.
+*/
+func (s *daemon) initJobs(concurrency int) error {
+	if concurrency < 1 {
+		return fmt.Errorf("Invalid job concurrency %v, must be positive", concurrency)
+	}
+	s.jobSlots = make(chan struct{}, concurrency)
+	s.jobs = make(map[string]*job)
+	if err := util.MkdirIfNotExists(filepath.Join(s.Root, JOB_DIR)); err != nil {
+		return err
+	}
+
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+
+	jobs, err := s.loadJobs()
+	if err != nil {
+		return err
+	}
+	for _, j := range jobs {
+		if j.finished() {
+			continue
+		}
+		log.Warnf("Job %v of %v was %v when daemon stopped, failing it", j.ID, j.Type, j.State)
+		j.State = JOB_STATE_FAILED
+		j.Error = "Interrupted by daemon restart"
+		j.FinishedTime = util.Now()
+		if err := util.ObjectSave(j); err != nil {
+			return err
+		}
+	}
+	s.removeExpiredJobs(jobs)
+	return nil
+}
+
+// loadJobs loads all the jobs saved, caller should hold jobMutex
+func (s *daemon) loadJobs() ([]*job, error) {
+	ids, err := util.ListConfigIDs(filepath.Join(s.Root, JOB_DIR), JOB_CFG_PREFIX, CFG_POSTFIX)
+	if err != nil {
+		return nil, err
+	}
+	jobs := []*job{}
+	for _, id := range ids {
+		j, err := s.loadJob(id)
+		if err != nil {
+			return nil, err
+		}
+		if j != nil {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs, nil
+}
+
+// loadJob returns the job in progress, or the one saved, nil if it doesn't
+// exist. Caller should hold jobMutex
+func (s *daemon) loadJob(id string) (*job, error) {
+	if j, exists := s.jobs[id]; exists {
+		return j, nil
+	}
+	j := &job{
+		ID:   id,
+		root: s.Root,
+	}
+	exists, err := util.ObjectExists(j)
+	if err != nil || !exists {
+		return nil, err
+	}
+	if err := util.ObjectLoad(j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// removeExpiredJobs removes jobs finished longer than JOB_RETENTION ago,
+// caller should hold jobMutex
+func (s *daemon) removeExpiredJobs(jobs []*job) {
+	expiry := time.Now().Add(-JOB_RETENTION)
+	for _, j := range jobs {
+		if !j.finished() || parseTime(j.FinishedTime).After(expiry) {
+			continue
+		}
+		if err := util.ObjectDelete(j); err != nil {
+			log.Warnf("Failed to remove expired job %v: %v", j.ID, err)
+		}
+	}
+}
+
+// startJob saves the job as pending and runs it in background, once one of
+// jobSlots is free. The job is traced as part of the request of ctx
+func (s *daemon) startJob(ctx context.Context, jobType, volumeName string, run func(ctx context.Context) (string, error)) (*job, error) {
+	// Jobs only provision, refused before they're accepted
+	if err := s.checkMaintenance(); err != nil {
+		return nil, err
+	}
+	j := &job{
+		ID:          util.GenerateName("job"),
+		Type:        jobType,
+		State:       JOB_STATE_PENDING,
+		VolumeName:  volumeName,
+		CreatedTime: util.Now(),
+		root:        s.Root,
+		run:         run,
+		cancel:      make(chan struct{}),
+		ctx:         util.DetachTrace(ctx),
+	}
+
+	s.jobMutex.Lock()
+	if err := util.ObjectSave(j); err != nil {
+		s.jobMutex.Unlock()
+		return nil, err
+	}
+	s.jobs[j.ID] = j
+	resp := *j
+	s.jobMutex.Unlock()
+
+	log.Debugf("Started job %v of %v for volume %v", j.ID, jobType, volumeName)
+	go s.runJob(j)
+	return &resp, nil
+}
+
+func (s *daemon) runJob(j *job) {
+	ctx, span := util.StartSpan(j.ctx, "job "+j.Type)
+	span.SetAttribute("convoy.job", j.ID)
+	span.SetAttribute(TRACE_ATTR_VOLUME, j.VolumeName)
+	var err error
+	defer func() { span.End(err) }()
+
+	_, waitSpan := util.StartSpan(ctx, "wait job slot")
+	select {
+	case s.jobSlots <- struct{}{}:
+		waitSpan.End(nil)
+		defer func() { <-s.jobSlots }()
+	case <-j.cancel:
+		waitSpan.End(nil)
+		s.finishJob(j, JOB_STATE_CANCELLED, "", nil)
+		return
+	}
+
+	s.jobMutex.Lock()
+	// Cancelled while taking the slot
+	if j.State != JOB_STATE_PENDING {
+		s.jobMutex.Unlock()
+		s.finishJob(j, JOB_STATE_CANCELLED, "", nil)
+		return
+	}
+	j.State = JOB_STATE_RUNNING
+	j.StartedTime = util.Now()
+	if err := util.ObjectSave(j); err != nil {
+		log.Warnf("Failed to save job %v: %v", j.ID, err)
+	}
+	s.jobMutex.Unlock()
+
+	result, err := j.run(ctx)
+	if err != nil {
+		s.finishJob(j, JOB_STATE_FAILED, "", err)
+		return
+	}
+	s.finishJob(j, JOB_STATE_SUCCEEDED, result, nil)
+}
+
+func (s *daemon) finishJob(j *job, state, result string, err error) {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+
+	j.State = state
+	j.Result = result
+	if err != nil {
+		j.Error = err.Error()
+		log.Warnf("Job %v of %v for volume %v failed: %v", j.ID, j.Type, j.VolumeName, err)
+	} else {
+		log.Debugf("Job %v of %v for volume %v %v", j.ID, j.Type, j.VolumeName, state)
+	}
+	j.FinishedTime = util.Now()
+	if err := util.ObjectSave(j); err != nil {
+		log.Warnf("Failed to save job %v: %v", j.ID, err)
+	}
+	delete(s.jobs, j.ID)
+
+	jobs, err := s.loadJobs()
+	if err != nil {
+		log.Warnf("Failed to list jobs: %v", err)
+		return
+	}
+	s.removeExpiredJobs(jobs)
+}
+
+// writeJobResponse answers request with Async by the job started for it
+func writeJobResponse(w http.ResponseWriter, j *job) error {
+	output, err := api.ResponseOutput(jobResponse(j))
+	if err != nil {
+		return err
+	}
+	log.Debugln("Response: ", string(output))
+	w.WriteHeader(http.StatusAccepted)
+	_, err = w.Write(output)
+	return err
+}
+
+func (s *daemon) doJobList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+
+	jobs, err := s.loadJobs()
+	if err != nil {
+		return err
+	}
+	resp := make(map[string]api.JobResponse)
+	for _, j := range jobs {
+		resp[j.ID] = jobResponse(j)
+	}
+	return writeResponseOutput(w, resp)
+}
+
+func (s *daemon) getJob(id string) (api.JobResponse, error) {
+	if err := util.CheckName(id); err != nil {
+		return api.JobResponse{}, err
+	}
+
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+
+	j, err := s.loadJob(id)
+	if err != nil {
+		return api.JobResponse{}, err
+	}
+	if j == nil {
+		return api.JobResponse{}, APIError{
+			statusCode: http.StatusNotFound,
+			error:      fmt.Sprintf("Job %v not found", id),
+		}
+	}
+	return jobResponse(j), nil
+}
+
+func (s *daemon) doJobInspect(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.JobInspectRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	resp, err := s.getJob(request.ID)
+	if err != nil {
+		return err
+	}
+	return writeResponseOutput(w, resp)
+}
+
+/*
+doJobCancel cancels the job if it's still pending. Running jobs cannot be
+cancelled, since drivers cannot stop their operations halfway safely.
+*/
+func (s *daemon) doJobCancel(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.JobCancelRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	resp, err := s.getJob(request.ID)
+	if err != nil {
+		return err
+	}
+
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+
+	j, exists := s.jobs[request.ID]
+	if !exists || j.State != JOB_STATE_PENDING {
+		if exists {
+			resp = jobResponse(j)
+		}
+		return APIError{
+			statusCode: http.StatusConflict,
+			error:      fmt.Sprintf("Job %v is %v, only pending jobs can be cancelled", resp.ID, resp.State),
+		}
+	}
+	j.State = JOB_STATE_CANCELLED
+	close(j.cancel)
+	log.Debugf("Cancelled job %v of %v for volume %v", j.ID, j.Type, j.VolumeName)
+	return writeResponseOutput(w, jobResponse(j))
+}
+
+// jobVolume returns volume of the job, for authorization of its cancel
+func (s *daemon) jobVolume(id string) string {
+	resp, err := s.getJob(id)
+	if err != nil {
+		return ""
+	}
+	return resp.VolumeName
+}
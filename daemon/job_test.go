@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "gopkg.in/check.v1"
+)
+
+// waitJob waits for the job to reach one of states, and returns it
+func (s *daemon) waitJob(c *C, id string, states ...string) api.JobResponse {
+	for i := 0; i < 100; i++ {
+		resp, err := s.getJob(id)
+		c.Assert(err, IsNil)
+		for _, state := range states {
+			if resp.State == state {
+				return resp
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp, _ := s.getJob(id)
+	c.Fatalf("Job %v is still %v, expected %v", id, resp.State, states)
+	return resp
+}
+
+func (s *TestSuite) TestJobStates(c *C) {
+	d := newTestDaemon(c)
+
+	// Both slots are taken by jobs blocked until they're released
+	release := make(chan error)
+	blocked := func(ctx context.Context) (string, error) {
+		if err := <-release; err != nil {
+			return "", err
+		}
+		return "done", nil
+	}
+	job1, err := d.startJob(context.Background(), JOB_TYPE_SNAPSHOT_CREATE, "vol1", blocked)
+	c.Assert(err, IsNil)
+	c.Assert(job1.State, Equals, JOB_STATE_PENDING)
+	job2, err := d.startJob(context.Background(), JOB_TYPE_BACKUP_CREATE, "vol2", blocked)
+	c.Assert(err, IsNil)
+	resp := d.waitJob(c, job1.ID, JOB_STATE_RUNNING)
+	c.Assert(resp.StartedTime, Not(Equals), "")
+	d.waitJob(c, job2.ID, JOB_STATE_RUNNING)
+
+	ran := false
+	job3, err := d.startJob(context.Background(), JOB_TYPE_VOLUME_CREATE, "vol3", func(ctx context.Context) (string, error) {
+		ran = true
+		return "", nil
+	})
+	c.Assert(err, IsNil)
+	resp = d.waitJob(c, job3.ID, JOB_STATE_PENDING)
+
+	// Only pending jobs can be cancelled
+	code, body := d.call(c, "POST", "/jobs/cancel", &api.JobCancelRequest{ID: job1.ID}, nil)
+	c.Assert(code, Equals, http.StatusConflict)
+	c.Assert(body, Matches, "Job .* is running, only pending jobs can be cancelled\n")
+	code, body = d.call(c, "POST", "/jobs/cancel", &api.JobCancelRequest{ID: job3.ID}, &resp)
+	c.Assert(code, Equals, http.StatusOK, Commentf(body))
+	c.Assert(resp.State, Equals, JOB_STATE_CANCELLED)
+	// Finished once it stops waiting for the slot
+	for i := 0; i < 100 && resp.FinishedTime == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+		resp, err = d.getJob(job3.ID)
+		c.Assert(err, IsNil)
+	}
+	c.Assert(resp.State, Equals, JOB_STATE_CANCELLED)
+	c.Assert(resp.FinishedTime, Not(Equals), "")
+	code, _ = d.call(c, "POST", "/jobs/cancel", &api.JobCancelRequest{ID: job3.ID}, nil)
+	c.Assert(code, Equals, http.StatusConflict)
+	code, _ = d.call(c, "POST", "/jobs/cancel", &api.JobCancelRequest{ID: "job-nonexistent"}, nil)
+	c.Assert(code, Equals, http.StatusNotFound)
+
+	release <- nil
+	release <- fmt.Errorf("backup failed")
+	// Either of job1 and job2 may take either result
+	resp1 := d.waitJob(c, job1.ID, JOB_STATE_SUCCEEDED, JOB_STATE_FAILED)
+	resp2 := d.waitJob(c, job2.ID, JOB_STATE_SUCCEEDED, JOB_STATE_FAILED)
+	if resp1.State == JOB_STATE_FAILED {
+		resp1, resp2 = resp2, resp1
+	}
+	c.Assert(resp1.State, Equals, JOB_STATE_SUCCEEDED)
+	c.Assert(resp1.Result, Equals, "done")
+	c.Assert(resp2.State, Equals, JOB_STATE_FAILED)
+	c.Assert(resp2.Error, Equals, "backup failed")
+	c.Assert(ran, Equals, false)
+
+	jobs := map[string]api.JobResponse{}
+	code, body = d.call(c, "GET", "/jobs/list", nil, &jobs)
+	c.Assert(code, Equals, http.StatusOK, Commentf(body))
+	c.Assert(jobs, HasLen, 3)
+
+	// Jobs are refused in maintenance
+	c.Assert(d.setMaintenance(true, ""), IsNil)
+	_, err = d.startJob(context.Background(), JOB_TYPE_VOLUME_CREATE, "vol4", blocked)
+	c.Assert(checkForStatusCode(err), Equals, http.StatusServiceUnavailable)
+}
+
+func (s *TestSuite) TestJobsAfterRestart(c *C) {
+	d := newTestDaemon(c)
+
+	running := &job{
+		ID:          "job-running",
+		Type:        JOB_TYPE_BACKUP_CREATE,
+		State:       JOB_STATE_RUNNING,
+		VolumeName:  "vol1",
+		CreatedTime: util.Now(),
+		StartedTime: util.Now(),
+		root:        d.Root,
+	}
+	expired := &job{
+		ID:           "job-expired",
+		Type:         JOB_TYPE_SNAPSHOT_CREATE,
+		State:        JOB_STATE_SUCCEEDED,
+		VolumeName:   "vol1",
+		CreatedTime:  time.Now().Add(-JOB_RETENTION - time.Hour).Format(time.RubyDate),
+		FinishedTime: time.Now().Add(-JOB_RETENTION - time.Hour).Format(time.RubyDate),
+		root:         d.Root,
+	}
+	c.Assert(util.ObjectSave(running), IsNil)
+	c.Assert(util.ObjectSave(expired), IsNil)
+
+	// Interrupted jobs are failed rather than run again
+	c.Assert(d.initJobs(2), IsNil)
+	resp, err := d.getJob(running.ID)
+	c.Assert(err, IsNil)
+	c.Assert(resp.State, Equals, JOB_STATE_FAILED)
+	c.Assert(resp.Error, Equals, "Interrupted by daemon restart")
+	_, err = d.getJob(expired.ID)
+	c.Assert(checkForStatusCode(err), Equals, http.StatusNotFound)
+}
@@ -0,0 +1,188 @@
+package daemon
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	LABEL_DIR = "labels"
+
+	// Options of Docker volume create with the prefix would be labels of
+	// the volume, e.g. "-o label.team=payments"
+	DOCKER_LABEL_OPT_PREFIX = "label."
+	// Labels are reported in Status of volume to Docker under the key
+	DOCKER_STATUS_LABELS = "Labels"
+)
+
+// volumeLabels are key value pairs set on volume creation, e.g. to record
+// the owner of the volume, and the ones set on creation of its snapshots
+type volumeLabels struct {
+	Name      string
+	Labels    map[string]string
+	Snapshots map[string]map[string]string `json:",omitempty"`
+
+	root string
+}
+
+func (l *volumeLabels) ConfigFile() (string, error) {
+	if l.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if l.root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty label root")
+	}
+	return filepath.Join(l.root, LABEL_DIR, VOLUME_CFG_PREFIX+l.Name+CFG_POSTFIX), nil
+}
+
+// loadVolumeLabels returns empty labels if the volume has none, caller
+// should hold labelMutex
+func (s *daemon) loadVolumeLabels(volumeName string) (*volumeLabels, error) {
+	labels := &volumeLabels{
+		Name: volumeName,
+		root: s.Root,
+	}
+	if err := util.ObjectLoad(labels); err != nil && !util.IsNotExistsError(err) {
+		return nil, err
+	}
+	if labels.Labels == nil {
+		labels.Labels = make(map[string]string)
+	}
+	return labels, nil
+}
+
+// getVolumeLabels returns empty labels if the volume has none
+func (s *daemon) getVolumeLabels(volumeName string) (map[string]string, error) {
+	s.labelMutex.Lock()
+	defer s.labelMutex.Unlock()
+
+	labels, err := s.loadVolumeLabels(volumeName)
+	if err != nil {
+		return nil, err
+	}
+	return labels.Labels, nil
+}
+
+func (s *daemon) saveVolumeLabels(volumeName string, labels map[string]string) error {
+	s.labelMutex.Lock()
+	defer s.labelMutex.Unlock()
+
+	if err := util.MkdirIfNotExists(filepath.Join(s.Root, LABEL_DIR)); err != nil {
+		return err
+	}
+	l, err := s.loadVolumeLabels(volumeName)
+	if err != nil {
+		return err
+	}
+	l.Labels = labels
+	return util.ObjectSave(l)
+}
+
+func (s *daemon) deleteVolumeLabels(volumeName string) {
+	s.labelMutex.Lock()
+	defer s.labelMutex.Unlock()
+
+	labels := &volumeLabels{
+		Name: volumeName,
+		root: s.Root,
+	}
+	if exists, err := util.ObjectExists(labels); err != nil || !exists {
+		return
+	}
+	if err := util.ObjectDelete(labels); err != nil {
+		log.Warnf("Failed to delete labels of volume %v: %v", volumeName, err)
+	}
+}
+
+// getSnapshotLabels returns nil if the snapshot has no labels
+func (s *daemon) getSnapshotLabels(volumeName, snapshotName string) (map[string]string, error) {
+	s.labelMutex.Lock()
+	defer s.labelMutex.Unlock()
+
+	labels, err := s.loadVolumeLabels(volumeName)
+	if err != nil {
+		return nil, err
+	}
+	return labels.Snapshots[snapshotName], nil
+}
+
+func (s *daemon) saveSnapshotLabels(volumeName, snapshotName string, labels map[string]string) error {
+	s.labelMutex.Lock()
+	defer s.labelMutex.Unlock()
+
+	if err := util.MkdirIfNotExists(filepath.Join(s.Root, LABEL_DIR)); err != nil {
+		return err
+	}
+	l, err := s.loadVolumeLabels(volumeName)
+	if err != nil {
+		return err
+	}
+	if l.Snapshots == nil {
+		l.Snapshots = make(map[string]map[string]string)
+	}
+	l.Snapshots[snapshotName] = labels
+	return util.ObjectSave(l)
+}
+
+func (s *daemon) deleteSnapshotLabels(volumeName, snapshotName string) {
+	s.labelMutex.Lock()
+	defer s.labelMutex.Unlock()
+
+	l, err := s.loadVolumeLabels(volumeName)
+	if err != nil {
+		log.Warnf("Failed to load labels of volume %v: %v", volumeName, err)
+		return
+	}
+	if _, exists := l.Snapshots[snapshotName]; !exists {
+		return
+	}
+	delete(l.Snapshots, snapshotName)
+	if err := util.ObjectSave(l); err != nil {
+		log.Warnf("Failed to delete labels of snapshot %v: %v", snapshotName, err)
+	}
+}
+
+func checkLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if err := util.CheckLabel(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getDockerLabels returns labels in options of Docker volume create
+func getDockerLabels(opts map[string]string) map[string]string {
+	labels := make(map[string]string)
+	for k, v := range opts {
+		if strings.HasPrefix(k, DOCKER_LABEL_OPT_PREFIX) {
+			labels[strings.TrimPrefix(k, DOCKER_LABEL_OPT_PREFIX)] = v
+		}
+	}
+	return labels
+}
+
+func parseLabelFilters(filters []string) ([]*util.LabelFilter, error) {
+	result := []*util.LabelFilter{}
+	for _, filter := range filters {
+		f, err := util.ParseLabelFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// matchLabelFilters returns true if labels match all the filters
+func matchLabelFilters(filters []*util.LabelFilter, labels map[string]string) bool {
+	for _, f := range filters {
+		if !f.Match(labels) {
+			return false
+		}
+	}
+	return true
+}
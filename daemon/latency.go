@@ -0,0 +1,259 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DEFAULT_LATENCY_WINDOW = "1h"
+
+	METRICS_CONTENT_TYPE = "text/plain; version=0.0.4"
+)
+
+/*
+latencySLO is threshold of a latency percentile of an operation, specified as
+"<operation>.p<percentile>=<duration>", e.g. "mount.p99=10s".
+*/
+type latencySLO struct {
+	Operation  string
+	Percentile int
+	Threshold  time.Duration
+	Breached   bool
+}
+
+func parseLatencySLO(spec string) (*latencySLO, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	keys := strings.SplitN(parts[0], ".", 2)
+	if len(parts) != 2 || len(keys) != 2 || !strings.HasPrefix(keys[1], "p") {
+		return nil, fmt.Errorf("Invalid latency SLO %q, should be <operation>.p<percentile>=<duration>, e.g. mount.p99=10s", spec)
+	}
+	slo := &latencySLO{
+		Operation: keys[0],
+	}
+	if !stringListContains(util.LatencyOperations, slo.Operation) {
+		return nil, fmt.Errorf("Invalid operation %v in latency SLO %q, should be one of %v",
+			slo.Operation, spec, util.LatencyOperations)
+	}
+	percentile, err := strconv.Atoi(strings.TrimPrefix(keys[1], "p"))
+	if err != nil || !intListContains(util.LatencyPercentiles, percentile) {
+		return nil, fmt.Errorf("Invalid percentile %v in latency SLO %q, should be one of %v",
+			keys[1], spec, util.LatencyPercentiles)
+	}
+	slo.Percentile = percentile
+	if slo.Threshold, err = util.ParseDuration(parts[1]); err != nil {
+		return nil, fmt.Errorf("Invalid threshold in latency SLO %q: %v", spec, err)
+	}
+	if slo.Threshold <= 0 {
+		return nil, fmt.Errorf("Threshold in latency SLO %q must be positive", spec)
+	}
+	return slo, nil
+}
+
+func stringListContains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func intListContains(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// initLatencyTracking starts tracking latencies in window, which would be
+// reported by drivers as well
+func (s *daemon) initLatencyTracking(window string, slos []string) error {
+	if window == "" {
+		window = DEFAULT_LATENCY_WINDOW
+	}
+	duration, err := util.ParseDuration(window)
+	if err != nil {
+		return fmt.Errorf("Invalid latency window: %v", err)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("Latency window must be positive")
+	}
+	s.latencySLOs = []*latencySLO{}
+	for _, spec := range slos {
+		slo, err := parseLatencySLO(spec)
+		if err != nil {
+			return err
+		}
+		s.latencySLOs = append(s.latencySLOs, slo)
+	}
+	s.latency = util.NewLatencyTracker(duration)
+	util.SetLatencyObserver(s.observeLatency)
+	return nil
+}
+
+// observeLatency records latency of successful operation on the volume, and
+// checks the SLOs of the operation
+func (s *daemon) observeLatency(op, volumeName string, latency time.Duration) {
+	if s.latency == nil {
+		return
+	}
+	stats := s.latency.Observe(op, latency)
+
+	s.latencyMutex.Lock()
+	defer s.latencyMutex.Unlock()
+
+	for _, slo := range s.latencySLOs {
+		if slo.Operation != op {
+			continue
+		}
+		value := stats.Percentiles[slo.Percentile]
+		if value > slo.Threshold && !slo.Breached {
+			slo.Breached = true
+			detail := fmt.Sprintf("p%v latency %v exceeded SLO %v over %v samples",
+				slo.Percentile, formatLatency(value), slo.Threshold, stats.Count)
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_DEGRADED,
+				LOG_FIELD_EVENT:  LOG_EVENT_SLO,
+				LOG_FIELD_VOLUME: volumeName,
+			}).Warnf("Operation %v: %v", op, detail)
+			s.recordSLOEvent(volumeName, op, detail)
+		} else if value <= slo.Threshold && slo.Breached {
+			slo.Breached = false
+			detail := fmt.Sprintf("p%v latency %v back within SLO %v", slo.Percentile, formatLatency(value), slo.Threshold)
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_RECOVERY,
+				LOG_FIELD_EVENT:  LOG_EVENT_SLO,
+				LOG_FIELD_VOLUME: volumeName,
+			}).Infof("Operation %v: %v", op, detail)
+			s.recordSLOEvent(volumeName, op, detail)
+		}
+	}
+}
+
+// recordSLOEvent records the event to the volume whose operation changed
+// the SLO state
+func (s *daemon) recordSLOEvent(volumeName, op, detail string) {
+	if volumeName == "" {
+		return
+	}
+	s.recordEvent(volumeName, LOG_OBJECT_VOLUME, LOG_EVENT_SLO, op, detail)
+}
+
+func formatLatency(latency time.Duration) string {
+	return latency.Round(time.Millisecond).String()
+}
+
+func (s *daemon) latencyStats() map[string]util.LatencyStats {
+	if s.latency == nil {
+		return map[string]util.LatencyStats{}
+	}
+	return s.latency.Stats()
+}
+
+func (s *daemon) doStats(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	resp := api.StatsResponse{
+		Latencies: make(map[string]api.LatencyResponse),
+	}
+	if s.latency != nil {
+		resp.Window = s.latency.Window().String()
+	}
+	for op, stats := range s.latencyStats() {
+		latency := api.LatencyResponse{
+			Count: stats.Count,
+		}
+		if stats.Count != 0 {
+			latency.P50 = formatLatency(stats.Percentiles[50])
+			latency.P95 = formatLatency(stats.Percentiles[95])
+			latency.P99 = formatLatency(stats.Percentiles[99])
+		}
+		resp.Latencies[op] = latency
+	}
+
+	s.latencyMutex.Lock()
+	for _, slo := range s.latencySLOs {
+		latency := resp.Latencies[slo.Operation]
+		if latency.SLOs == nil {
+			latency.SLOs = make(map[string]string)
+		}
+		percentile := "p" + strconv.Itoa(slo.Percentile)
+		latency.SLOs[percentile] = slo.Threshold.String()
+		if slo.Breached {
+			latency.Breached = append(latency.Breached, percentile)
+		}
+		resp.Latencies[slo.Operation] = latency
+	}
+	s.latencyMutex.Unlock()
+
+	return writeResponseOutput(w, resp)
+}
+
+/*
+doMetrics exposes the latencies in Prometheus text format, as summaries of
+the sliding window. Percentiles of operation without samples are omitted.
+*/
+func (s *daemon) doMetrics(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	all := s.latencyStats()
+	ops := []string{}
+	for op := range all {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	var b bytes.Buffer
+	b.WriteString("# HELP convoy_operation_latency_seconds Latency of successful operations in the sliding window.\n")
+	b.WriteString("# TYPE convoy_operation_latency_seconds summary\n")
+	for _, op := range ops {
+		stats := all[op]
+		for _, p := range util.LatencyPercentiles {
+			if stats.Count == 0 {
+				break
+			}
+			fmt.Fprintf(&b, "convoy_operation_latency_seconds{operation=%q,quantile=\"%v\"} %v\n",
+				op, float64(p)/100, stats.Percentiles[p].Seconds())
+		}
+		fmt.Fprintf(&b, "convoy_operation_latency_seconds_sum{operation=%q} %v\n", op, stats.Sum.Seconds())
+		fmt.Fprintf(&b, "convoy_operation_latency_seconds_count{operation=%q} %v\n", op, stats.Count)
+	}
+
+	s.latencyMutex.Lock()
+	if len(s.latencySLOs) != 0 {
+		b.WriteString("# HELP convoy_operation_latency_slo_breached Whether the latency percentile exceeds its SLO.\n")
+		b.WriteString("# TYPE convoy_operation_latency_slo_breached gauge\n")
+	}
+	for _, slo := range s.latencySLOs {
+		breached := 0
+		if slo.Breached {
+			breached = 1
+		}
+		fmt.Fprintf(&b, "convoy_operation_latency_slo_breached{operation=%q,quantile=\"%v\"} %v\n",
+			slo.Operation, float64(slo.Percentile)/100, breached)
+	}
+	s.latencyMutex.Unlock()
+
+	s.writeOperationMetrics(&b)
+	s.writeDriverMetrics(&b)
+	s.writeAWSMetrics(&b)
+	s.writeCanaryMetrics(&b)
+	s.writeScrubMetrics(&b)
+	s.writeRateLimitMetrics(&b)
+	s.writeTracingMetrics(&b)
+	s.writeMaintenanceMetrics(&b)
+
+	w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	_, err := w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rancher/convoy/util"
+)
+
+/*
+Operations on a volume, e.g. create, mount, snapshot and delete, are
+serialized by volumeLocks, while the ones on different volumes run
+concurrently. Backup destinations are locked by destLocks: backups are
+created, restored and removed under read locks, so they won't block each
+other, while operations rewriting the destination as a whole, e.g. rotate-key
+and migrate, hold the write lock.
+
+Long running operations, i.e. snapshots, backups and restores, also take one
+of cap(opSlots) slots, so a burst of them won't overwhelm the backends. Locks
+are always taken in order of volume, destination and slot.
+*/
+
+func (s *daemon) initLocks(concurrency int) error {
+	if concurrency < 1 {
+		return fmt.Errorf("Invalid max concurrent operations %v, must be positive", concurrency)
+	}
+	s.volumeLocks = util.NewLockMap()
+	s.destLocks = util.NewLockMap()
+	s.opSlots = make(chan struct{}, concurrency)
+	return nil
+}
+
+// lockVolume locks the volume, tracing the wait for operations of others on
+// it. It should be unlocked by volumeLocks.Unlock()
+func (s *daemon) lockVolume(ctx context.Context, volumeName string) {
+	_, span := util.StartSpan(ctx, "wait volume lock")
+	span.SetAttribute(TRACE_ATTR_VOLUME, volumeName)
+	s.volumeLocks.Lock(volumeName)
+	span.End(nil)
+}
+
+// lockVolumes locks the volumes in order of their names, so operations
+// locking the same volumes, e.g. clones, won't wait for each other in turn.
+// Volume specified more than once is locked once. It returns the function
+// unlocking them
+func (s *daemon) lockVolumes(ctx context.Context, volumeNames ...string) func() {
+	names := []string{}
+	locked := make(map[string]bool)
+	for _, name := range volumeNames {
+		if !locked[name] {
+			locked[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s.lockVolume(ctx, name)
+	}
+	return func() {
+		for i := len(names) - 1; i >= 0; i-- {
+			s.volumeLocks.Unlock(names[i])
+		}
+	}
+}
+
+// acquireOpSlot waits for a free slot of long running operation, which should
+// be released by releaseOpSlot()
+func (s *daemon) acquireOpSlot(ctx context.Context) {
+	select {
+	case s.opSlots <- struct{}{}:
+		return
+	default:
+	}
+	_, span := util.StartSpan(ctx, "wait operation slot")
+	log.Debugf("All %v operation slots are busy, waiting", cap(s.opSlots))
+	s.opSlots <- struct{}{}
+	span.End(nil)
+}
+
+func (s *daemon) releaseOpSlot() {
+	<-s.opSlots
+}
+
+// destinationOf returns the destination of backup URL, which is the key of
+// destLocks
+func destinationOf(backupURL string) string {
+	return strings.SplitN(backupURL, "?", 2)[0]
+}
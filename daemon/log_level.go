@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+/*
+initLogging sets up output, format and levels of logs. Logs are JSON by
+default if written to --log file, and text otherwise. Levels of components
+can be changed at runtime through /logging/set.
+*/
+func initLogging(c *cli.Context) error {
+	levels, err := ParseLevels(c.String("log-level"))
+	if err != nil {
+		return err
+	}
+	logName := c.String("log")
+	format := c.String("log-format")
+	if format == "" {
+		format = LOG_FORMAT_TEXT
+		if logName != "" {
+			format = LOG_FORMAT_JSON
+		}
+	}
+	if logFormatter, err = NewLevelFormatter(format, levels); err != nil {
+		return err
+	}
+	logFormat = format
+
+	if logName != "" {
+		if logFile, err = os.OpenFile(logName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666); err != nil {
+			return err
+		}
+		logrus.SetOutput(logFile)
+	} else {
+		logrus.SetOutput(os.Stdout)
+	}
+	logrus.SetFormatter(logFormatter)
+	return nil
+}
+
+func loggingResponse() api.LoggingResponse {
+	return api.LoggingResponse{
+		Format: logFormat,
+		Levels: logFormatter.Levels().String(),
+	}
+}
+
+func (s *daemon) doLogging(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	return writeResponseOutput(w, loggingResponse())
+}
+
+func (s *daemon) doLoggingSet(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.LoggingSetRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	levels, err := ParseLevels(request.Levels)
+	if err != nil {
+		return err
+	}
+	log.Infof("Changing log levels from %v to %v", logFormatter.Levels(), levels)
+	logFormatter.SetLevels(levels)
+	return writeResponseOutput(w, loggingResponse())
+}
@@ -0,0 +1,251 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	MAINTENANCE_CFG = "maintenance.json"
+
+	HEALTH_COMPONENT_MAINTENANCE = "maintenance"
+)
+
+/*
+maintenanceState is kept in root directory, so daemon restarted within the
+maintenance window stays in maintenance. While Enabled, schedules, canary
+restores and scrubs are paused, and operations provisioning volumes, i.e.
+creating, restoring or mounting volumes and creating snapshots or backups,
+are refused with 503. Unmounting, deleting and inspecting are still allowed,
+so hosts can be drained.
+*/
+type maintenanceState struct {
+	Enabled bool
+	Reason  string
+	Since   string
+
+	root string
+}
+
+func (m *maintenanceState) ConfigFile() (string, error) {
+	if m.root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty maintenance root")
+	}
+	return filepath.Join(m.root, MAINTENANCE_CFG), nil
+}
+
+func (s *daemon) initMaintenance() error {
+	state := &maintenanceState{
+		root: s.Root,
+	}
+	exists, err := util.ObjectExists(state)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := util.ObjectLoad(state); err != nil {
+			return err
+		}
+	}
+	if state.Enabled {
+		log.Warnf("Daemon is in maintenance since %v, provisioning is refused until it ends", state.Since)
+	}
+	s.maintenance = state
+	return nil
+}
+
+func (s *daemon) inMaintenance() bool {
+	s.maintenanceMutex.RLock()
+	defer s.maintenanceMutex.RUnlock()
+
+	return s.maintenance != nil && s.maintenance.Enabled
+}
+
+// checkMaintenance refuses operation provisioning volumes while daemon is in
+// maintenance
+func (s *daemon) checkMaintenance() error {
+	s.maintenanceMutex.RLock()
+	defer s.maintenanceMutex.RUnlock()
+
+	if s.maintenance == nil || !s.maintenance.Enabled {
+		return nil
+	}
+	msg := fmt.Sprintf("Daemon is in maintenance since %v", s.maintenance.Since)
+	if s.maintenance.Reason != "" {
+		msg += ": " + s.maintenance.Reason
+	}
+	return APIError{
+		statusCode: http.StatusServiceUnavailable,
+		error:      msg + ", operation refused",
+	}
+}
+
+func (s *daemon) maintenanceResponse() api.MaintenanceResponse {
+	s.maintenanceMutex.RLock()
+	defer s.maintenanceMutex.RUnlock()
+
+	if s.maintenance == nil {
+		return api.MaintenanceResponse{}
+	}
+	return api.MaintenanceResponse{
+		Enabled: s.maintenance.Enabled,
+		Reason:  s.maintenance.Reason,
+		Since:   s.maintenance.Since,
+	}
+}
+
+// maintenanceComponentHealth fails /readyz in maintenance, so orchestrators
+// stop placing volumes on the host
+func (s *daemon) maintenanceComponentHealth() api.ComponentHealth {
+	if err := s.checkMaintenance(); err != nil {
+		return componentHealth(err)
+	}
+	return componentHealth(nil)
+}
+
+/*
+setMaintenance enters or leaves maintenance. Entering again only updates the
+reason, keeping the time maintenance started. Operations in progress are left
+to complete.
+*/
+func (s *daemon) setMaintenance(enabled bool, reason string) error {
+	s.maintenanceMutex.Lock()
+	defer s.maintenanceMutex.Unlock()
+
+	state := &maintenanceState{
+		Enabled: enabled,
+		root:    s.Root,
+	}
+	current := s.maintenance
+	if enabled {
+		state.Reason = reason
+		state.Since = util.Now()
+		if current != nil && current.Enabled {
+			state.Since = current.Since
+		}
+	}
+	if err := util.ObjectSave(state); err != nil {
+		return err
+	}
+	s.maintenance = state
+
+	if current != nil && current.Enabled == enabled {
+		return nil
+	}
+	detail := "ended"
+	if enabled {
+		detail = "started"
+		if reason != "" {
+			detail += ": " + reason
+		}
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_EVENT:  LOG_EVENT_MAINTENANCE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_DAEMON,
+	}).Infof("Maintenance %v", detail)
+	s.publishEvent("", api.VolumeEvent{
+		Time:   util.Now(),
+		Object: LOG_OBJECT_DAEMON,
+		Event:  LOG_EVENT_MAINTENANCE,
+		Name:   s.hostname,
+		Detail: detail,
+	})
+	return nil
+}
+
+func (s *daemon) doMaintenance(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	return writeResponseOutput(w, s.maintenanceResponse())
+}
+
+func (s *daemon) doMaintenanceSet(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.MaintenanceSetRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if request.Drain && !request.Enabled {
+		return APIError{
+			statusCode: http.StatusBadRequest,
+			error:      "Drain is only allowed on entering maintenance",
+		}
+	}
+	if err := s.setMaintenance(request.Enabled, request.Reason); err != nil {
+		return err
+	}
+	if request.Drain {
+		if err := s.drainDrivers(); err != nil {
+			return err
+		}
+	}
+	return writeResponseOutput(w, s.maintenanceResponse())
+}
+
+/*
+drainDrivers releases volumes not mounted from the host by drivers implementing
+Drainer, e.g. detaching EBS volumes, so they can be used by other hosts before
+the host goes away. Daemon is in maintenance meanwhile, so no volume is
+provisioned again. It fails if any volume cannot be drained, after all the
+drivers have been drained.
+*/
+func (s *daemon) drainDrivers() error {
+	failed := 0
+	for _, driverName := range s.DriverList {
+		drainer, ok := s.ConvoyDrivers[driverName].(Drainer)
+		if !ok {
+			continue
+		}
+		results, err := drainer.Drain()
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_FAILURE,
+				LOG_FIELD_EVENT:  LOG_EVENT_DRAIN,
+				LOG_FIELD_DRIVER: driverName,
+			}).Errorf("Failed to drain driver: %v", err)
+			s.recordDriverEvent(driverName, LOG_EVENT_DRAIN, "failed: "+err.Error())
+			failed++
+		}
+		for _, result := range results {
+			if result.Err != nil {
+				failed++
+				log.WithFields(logrus.Fields{
+					LOG_FIELD_REASON: LOG_REASON_FAILURE,
+					LOG_FIELD_EVENT:  LOG_EVENT_DRAIN,
+					LOG_FIELD_DRIVER: driverName,
+					LOG_FIELD_VOLUME: result.Volume,
+				}).Errorf("Failed to drain: %v", result.Err)
+				s.recordFailure(result.Volume, LOG_OBJECT_VOLUME, LOG_EVENT_DRAIN, result.Volume, result.Action, result.Err)
+				continue
+			}
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+				LOG_FIELD_EVENT:  LOG_EVENT_DRAIN,
+				LOG_FIELD_DRIVER: driverName,
+				LOG_FIELD_VOLUME: result.Volume,
+			}).Info(result.Action)
+			s.recordEvent(result.Volume, LOG_OBJECT_VOLUME, LOG_EVENT_DRAIN, result.Volume, result.Action)
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("Failed to drain %v volume(s) or driver(s), see events of them", failed)
+	}
+	return nil
+}
+
+// writeMaintenanceMetrics adds whether daemon is in maintenance to metrics
+func (s *daemon) writeMaintenanceMetrics(b *bytes.Buffer) {
+	enabled := 0
+	if s.inMaintenance() {
+		enabled = 1
+	}
+	b.WriteString("# HELP convoy_maintenance Whether daemon is in maintenance, refusing to provision volumes.\n")
+	b.WriteString("# TYPE convoy_maintenance gauge\n")
+	fmt.Fprintf(b, "convoy_maintenance %v\n", enabled)
+}
@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	METADATA_STORE_PREFIX = "/convoy/"
+)
+
+/*
+initMetadataStore sets up the store of metadata of daemon, drivers and
+volumes by --metadata-store, before any of them is loaded, returning client
+of etcd if it's kept there. Backup index stays in root, since it's a cache of
+backups rebuilt from objectstores.
+*/
+func initMetadataStore(c *cli.Context, root string) (*util.EtcdClient, error) {
+	kind := c.String("metadata-store")
+	switch kind {
+	case "", util.METADATA_STORE_FILE:
+		return nil, nil
+	case util.METADATA_STORE_ETCD:
+	default:
+		return nil, fmt.Errorf("Invalid metadata store %v, should be %v or %v", kind, util.METADATA_STORE_FILE, util.METADATA_STORE_ETCD)
+	}
+
+	prefix := c.String("metadata-store-prefix")
+	if prefix == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		prefix = METADATA_STORE_PREFIX + hostname
+	}
+	password := ""
+	if spec := c.String("metadata-store-password"); spec != "" {
+		var err error
+		if password, err = getSecret(spec, "etcd password"); err != nil {
+			return nil, err
+		}
+	}
+	tlsConfig, err := util.NewClientTLSConfig(c.String("metadata-store-ca-cert"),
+		c.String("metadata-store-cert"), c.String("metadata-store-key"))
+	if err != nil {
+		return nil, err
+	}
+	client, err := util.NewEtcdClient(c.StringSlice("metadata-store-endpoints"), tlsConfig,
+		c.String("metadata-store-username"), password)
+	if err != nil {
+		return nil, err
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	store := util.NewEtcdMetadataStore(client, prefix, []string{filepath.Join(absRoot, BACKUP_INDEX_DIR)})
+	if err := store.Check(); err != nil {
+		return nil, fmt.Errorf("Cannot use etcd as metadata store: %v", err)
+	}
+	util.SetMetadataStore(store)
+	log.Infof("Keeping metadata in etcd at %v with prefix %v", c.StringSlice("metadata-store-endpoints"), prefix)
+	return client, nil
+}
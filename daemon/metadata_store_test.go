@@ -0,0 +1,146 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "gopkg.in/check.v1"
+)
+
+// memMetadataStore keeps configs in memory rather than files, the same as
+// etcd does as far as daemon can tell
+type memMetadataStore struct {
+	mutex   sync.Mutex
+	configs map[string][]byte
+}
+
+func (m *memMetadataStore) Load(fileName string, v interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	data, exists := m.configs[fileName]
+	if !exists {
+		return fmt.Errorf("Config %v doesn't exist", fileName)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (m *memMetadataStore) Save(fileName string, v interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m.configs[fileName] = data
+	return nil
+}
+
+func (m *memMetadataStore) Exists(fileName string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, exists := m.configs[fileName]
+	return exists, nil
+}
+
+func (m *memMetadataStore) Remove(fileName string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.configs, fileName)
+	return nil
+}
+
+func (m *memMetadataStore) List(dir, prefix, suffix string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ids := []string{}
+	for fileName := range m.configs {
+		name := filepath.Base(fileName)
+		if filepath.Dir(fileName) != dir || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (m *memMetadataStore) Check() error {
+	return nil
+}
+
+func (s *TestSuite) TestConfigsListedFromMetadataStore(c *C) {
+	d := newTestDaemon(c)
+	store := &memMetadataStore{configs: make(map[string][]byte)}
+	util.SetMetadataStore(store)
+	defer util.SetMetadataStore(&util.FileMetadataStore{})
+
+	c.Assert(util.ObjectSave(&volumeSchedule{
+		Name:        "vol1",
+		Interval:    "1h",
+		CreatedTime: util.Now(),
+		root:        d.Root,
+	}), IsNil)
+	c.Assert(util.ObjectSave(&volumeHook{
+		Name:        "vol1",
+		PreSnapshot: "/bin/true",
+		CreatedTime: util.Now(),
+		root:        d.Root,
+	}), IsNil)
+	c.Assert(util.ObjectSave(&job{
+		ID:          "job1",
+		Type:        JOB_TYPE_SNAPSHOT_CREATE,
+		State:       JOB_STATE_SUCCEEDED,
+		VolumeName:  "vol1",
+		CreatedTime: util.Now(),
+		root:        d.Root,
+	}), IsNil)
+	pending := &pendingDelete{
+		Name:          "vol2",
+		RequestedTime: util.Now(),
+		root:          d.Root,
+	}
+	c.Assert(util.ObjectSave(pending), IsNil)
+
+	// Nothing of them is in files under root
+	for _, dir := range []string{SCHEDULE_DIR, HOOK_DIR, JOB_DIR, PENDING_DELETE_DIR} {
+		files, err := filepath.Glob(filepath.Join(d.Root, dir, "*"))
+		c.Assert(err, IsNil)
+		c.Assert(files, HasLen, 0)
+	}
+
+	schedules, err := d.listVolumeSchedules()
+	c.Assert(err, IsNil)
+	c.Assert(schedules, HasLen, 1)
+	c.Assert(schedules[0].Name, Equals, "vol1")
+
+	hooks := map[string]api.HookResponse{}
+	code, body := d.call(c, "GET", "/hooks/list", nil, &hooks)
+	c.Assert(code, Equals, 200, Commentf(body))
+	c.Assert(hooks, HasLen, 1)
+	c.Assert(hooks["vol1"].PreSnapshot, Equals, "/bin/true")
+
+	d.jobMutex.Lock()
+	jobs, err := d.loadJobs()
+	d.jobMutex.Unlock()
+	c.Assert(err, IsNil)
+	c.Assert(jobs, HasLen, 1)
+	c.Assert(jobs[0].ID, Equals, "job1")
+
+	// Volume is gone already, so only the record is removed
+	d.completePendingDeletes()
+	exists, err := util.ObjectExists(pending)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+}
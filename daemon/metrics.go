@@ -0,0 +1,266 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+const (
+	METRICS_OP_CREATE   = "create"
+	METRICS_OP_RESTORE  = "restore"
+	METRICS_OP_CLONE    = "clone"
+	METRICS_OP_EXPAND   = "expand"
+	METRICS_OP_MOUNT    = "mount"
+	METRICS_OP_UMOUNT   = "umount"
+	METRICS_OP_SNAPSHOT = "snapshot"
+	METRICS_OP_BACKUP   = "backup"
+)
+
+var (
+	metricsOperations = []string{
+		METRICS_OP_CREATE,
+		METRICS_OP_RESTORE,
+		METRICS_OP_CLONE,
+		METRICS_OP_EXPAND,
+		METRICS_OP_MOUNT,
+		METRICS_OP_UMOUNT,
+		METRICS_OP_SNAPSHOT,
+		METRICS_OP_BACKUP,
+	}
+
+	// Upper bounds of buckets of operation durations in seconds, from
+	// mounts to restores of large volumes
+	metricsDurationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+)
+
+type durationHistogram struct {
+	buckets []int64
+	sum     float64
+	count   int64
+}
+
+/*
+operationMetrics counts operations of drivers since daemon started, unlike
+latency tracker which only keeps successful ones in the sliding window, so
+they can be exposed as Prometheus counters and histograms.
+*/
+type operationMetrics struct {
+	mutex     sync.Mutex
+	durations map[string]*durationHistogram
+	errors    map[string]int64
+
+	backupChangedBytes int64
+	backupStoredBytes  int64
+}
+
+func newOperationMetrics() *operationMetrics {
+	m := &operationMetrics{
+		durations: make(map[string]*durationHistogram),
+		errors:    make(map[string]int64),
+	}
+	for _, op := range metricsOperations {
+		m.durations[op] = &durationHistogram{
+			buckets: make([]int64, len(metricsDurationBuckets)),
+		}
+	}
+	return m
+}
+
+// observeOperation records duration of operation since start, or its
+// failure
+func (s *daemon) observeOperation(op string, start time.Time, err error) {
+	m := s.metrics
+	if m == nil {
+		return
+	}
+	seconds := time.Since(start).Seconds()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err != nil {
+		m.errors[op]++
+		return
+	}
+	h := m.durations[op]
+	for i, bound := range metricsDurationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// observeBackupSize records bytes of blocks changed and uploaded by the
+// backup, if the driver reports them
+func (s *daemon) observeBackupSize(backupOps BackupOperations, backupURL string) {
+	m := s.metrics
+	if m == nil {
+		return
+	}
+	info, err := backupOps.GetBackupInfo(backupURL)
+	if err != nil {
+		log.Debugf("Cannot get size of backup %v for metrics: %v", backupURL, err)
+		return
+	}
+	changed, _ := strconv.ParseInt(info["ChangedSize"], 10, 64)
+	stored, _ := strconv.ParseInt(info["StoredSize"], 10, 64)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.backupChangedBytes += changed
+	m.backupStoredBytes += stored
+}
+
+// writeOperationMetrics adds durations and errors of operations, and bytes
+// of backups, to metrics
+func (s *daemon) writeOperationMetrics(b *bytes.Buffer) {
+	m := s.metrics
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	b.WriteString("# HELP convoy_operation_duration_seconds Duration of successful operations since daemon started.\n")
+	b.WriteString("# TYPE convoy_operation_duration_seconds histogram\n")
+	for _, op := range metricsOperations {
+		h := m.durations[op]
+		for i, bound := range metricsDurationBuckets {
+			fmt.Fprintf(b, "convoy_operation_duration_seconds_bucket{operation=%q,le=\"%v\"} %v\n",
+				op, bound, h.buckets[i])
+		}
+		fmt.Fprintf(b, "convoy_operation_duration_seconds_bucket{operation=%q,le=\"+Inf\"} %v\n", op, h.count)
+		fmt.Fprintf(b, "convoy_operation_duration_seconds_sum{operation=%q} %v\n", op, h.sum)
+		fmt.Fprintf(b, "convoy_operation_duration_seconds_count{operation=%q} %v\n", op, h.count)
+	}
+
+	b.WriteString("# HELP convoy_operation_errors_total Failed operations since daemon started.\n")
+	b.WriteString("# TYPE convoy_operation_errors_total counter\n")
+	for _, op := range metricsOperations {
+		fmt.Fprintf(b, "convoy_operation_errors_total{operation=%q} %v\n", op, m.errors[op])
+	}
+
+	b.WriteString("# HELP convoy_backup_bytes_total Bytes of blocks of backups created since daemon started, changed since the last backup, or uploaded after deduplication.\n")
+	b.WriteString("# TYPE convoy_backup_bytes_total counter\n")
+	fmt.Fprintf(b, "convoy_backup_bytes_total{type=\"changed\"} %v\n", m.backupChangedBytes)
+	fmt.Fprintf(b, "convoy_backup_bytes_total{type=\"stored\"} %v\n", m.backupStoredBytes)
+}
+
+func formatMetricLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := []string{}
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i, k := range keys {
+		if i != 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "%v=%q", k, labels[k])
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// writeDriverMetrics adds gauges reported by drivers, e.g. usage of thin
+// pool, to metrics
+func (s *daemon) writeDriverMetrics(b *bytes.Buffer) {
+	names := []string{}
+	for name := range s.ConvoyDrivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		reporter, ok := s.ConvoyDrivers[name].(MetricsReporter)
+		if !ok {
+			continue
+		}
+		metrics, err := reporter.Metrics()
+		if err != nil {
+			log.Warnf("Cannot get metrics of driver %v: %v", name, err)
+			continue
+		}
+		for _, metric := range metrics {
+			fullName := "convoy_" + name + "_" + metric.Name
+			fmt.Fprintf(b, "# HELP %v %v\n", fullName, metric.Help)
+			fmt.Fprintf(b, "# TYPE %v gauge\n", fullName)
+			fmt.Fprintf(b, "%v%v %v\n", fullName, formatMetricLabels(metric.Labels), metric.Value)
+		}
+	}
+}
+
+// writeAWSMetrics adds AWS API calls made by drivers and objectstore, if
+// any, to metrics
+func (s *daemon) writeAWSMetrics(b *bytes.Buffer) {
+	counts := util.APICallCounts()
+	if len(counts) == 0 {
+		return
+	}
+	b.WriteString("# HELP convoy_aws_api_calls_total Requests sent to AWS APIs, including retries.\n")
+	b.WriteString("# TYPE convoy_aws_api_calls_total counter\n")
+	for _, count := range counts {
+		fmt.Fprintf(b, "convoy_aws_api_calls_total{service=%q,operation=%q} %v\n",
+			count.Service, count.Operation, count.Calls)
+	}
+	b.WriteString("# HELP convoy_aws_api_throttles_total Requests to AWS APIs throttled.\n")
+	b.WriteString("# TYPE convoy_aws_api_throttles_total counter\n")
+	for _, count := range counts {
+		fmt.Fprintf(b, "convoy_aws_api_throttles_total{service=%q,operation=%q} %v\n",
+			count.Service, count.Operation, count.Throttled)
+	}
+}
+
+/*
+startMetricsListener serves metrics over TCP at address, e.g. ":9412", so
+Prometheus can scrape them without access to the daemon socket. Nothing else
+of the API is exposed there. Empty address disables it.
+*/
+func (s *daemon) startMetricsListener(address string) error {
+	if address == "" {
+		return nil
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("Cannot listen for metrics at %v: %v", address, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.doMetrics("", w, r, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	// Probes of orchestrators, which usually cannot authenticate
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.doHealthz("", w, r, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.doReadyz("", w, r, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Error("metrics server error ", err.Error())
+		}
+	}()
+	log.Infof("Serving metrics at %v", l.Addr())
+	return nil
+}
@@ -1,10 +1,12 @@
 package daemon
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/convoy/api"
@@ -21,6 +23,10 @@ func (s *daemon) doBackupList(version string, w http.ResponseWriter, r *http.Req
 		return err
 	}
 	request.URL = util.UnescapeURL(request.URL)
+	filter, err := parseBackupListFilter(request, time.Now())
+	if err != nil {
+		return err
+	}
 
 	opts := map[string]string{
 		OPT_VOLUME_NAME: request.VolumeName,
@@ -37,7 +43,9 @@ func (s *daemon) doBackupList(version string, w http.ResponseWriter, r *http.Req
 			return err
 		}
 		for k, v := range infos {
-			result[k] = v
+			if filter.match(v) {
+				result[k] = v
+			}
 		}
 	}
 
@@ -49,6 +57,97 @@ func (s *daemon) doBackupList(version string, w http.ResponseWriter, r *http.Req
 	return err
 }
 
+// backupListFilter selects backups listed by their snapshot, labels and
+// creation time
+type backupListFilter struct {
+	snapshotName string
+	labels       []*util.LabelFilter
+	since        time.Time
+	until        time.Time
+}
+
+func parseBackupListFilter(request *api.BackupListRequest, now time.Time) (*backupListFilter, error) {
+	f := &backupListFilter{
+		snapshotName: request.SnapshotName,
+	}
+	var err error
+	if f.labels, err = parseLabelFilters(request.Filters); err != nil {
+		return nil, err
+	}
+	if request.Since != "" {
+		if f.since, err = parseTimeBound(request.Since, now, false); err != nil {
+			return nil, err
+		}
+	}
+	if request.Until != "" {
+		if f.until, err = parseTimeBound(request.Until, now, true); err != nil {
+			return nil, err
+		}
+	}
+	if !f.since.IsZero() && !f.until.IsZero() && !f.since.Before(f.until) {
+		return nil, fmt.Errorf("Invalid time range, %v is not before %v", request.Since, request.Until)
+	}
+	return f, nil
+}
+
+/*
+parseTimeBound parses value as a date like 2026-03-04 in local time, a time
+in RFC 3339, or a duration before now like 12h or 7d. Dates cover the whole
+day, so the end of the day is returned if it's the end of a range.
+*/
+func parseTimeBound(value string, now time.Time, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := util.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid time %q, should be a date like 2006-01-02, a time like 2006-01-02T15:04:05Z07:00, or a duration like 7d", value)
+}
+
+// match returns true if the backup of the snapshot, if any, created in the
+// range has labels matching all the filters
+func (f *backupListFilter) match(info map[string]string) bool {
+	if f.snapshotName != "" && info["SnapshotName"] != f.snapshotName {
+		return false
+	}
+	labels := make(map[string]string)
+	for k, v := range info {
+		if strings.HasPrefix(k, objectstore.BACKUP_INFO_LABEL_PREFIX) {
+			labels[strings.TrimPrefix(k, objectstore.BACKUP_INFO_LABEL_PREFIX)] = v
+		}
+	}
+	if !matchLabelFilters(f.labels, labels) {
+		return false
+	}
+	if f.since.IsZero() && f.until.IsZero() {
+		return true
+	}
+	created := parseTime(info["CreatedTime"])
+	if created.IsZero() {
+		return false
+	}
+	return !created.Before(f.since) && (f.until.IsZero() || created.Before(f.until))
+}
+
+// backupNotFoundError returns err as not found if the backup is missing in
+// objectstore, e.g. deleted
+func backupNotFoundError(err error) error {
+	if objectstore.IsNotFound(err) {
+		return APIError{
+			statusCode: http.StatusNotFound,
+			error:      err.Error(),
+		}
+	}
+	return err
+}
+
 func (s *daemon) doBackupInspect(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
 	request := &api.BackupListRequest{}
 	if err := decodeRequest(r, request); err != nil {
@@ -57,12 +156,12 @@ func (s *daemon) doBackupInspect(version string, w http.ResponseWriter, r *http.
 	request.URL = util.UnescapeURL(request.URL)
 	backupOps, err := s.getBackupOpsForBackup(request.URL)
 	if err != nil {
-		return err
+		return backupNotFoundError(err)
 	}
 
 	info, err := backupOps.GetBackupInfo(request.URL)
 	if err != nil {
-		return err
+		return backupNotFoundError(err)
 	}
 
 	data, err := api.ResponseOutput(info)
@@ -73,6 +172,37 @@ func (s *daemon) doBackupInspect(version string, w http.ResponseWriter, r *http.
 	return err
 }
 
+func (s *daemon) doBackupIndexRefresh(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupIndexRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	info, err := objectstore.RefreshIndex(request.URL, request.Rebuild)
+	if err != nil {
+		return err
+	}
+	return sendResponse(w, info)
+}
+
+func (s *daemon) doBackupMigrate(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupMigrateRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	s.destLocks.Lock(request.URL)
+	defer s.destLocks.Unlock(request.URL)
+
+	info, err := objectstore.MigrateObjectStore(request.URL, request.DryRun)
+	if err != nil {
+		return err
+	}
+	return sendResponse(w, info)
+}
+
 func (s *daemon) doBackupCreate(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
 	request := &api.BackupCreateRequest{}
 	if err := decodeRequest(r, request); err != nil {
@@ -80,36 +210,90 @@ func (s *daemon) doBackupCreate(version string, w http.ResponseWriter, r *http.R
 	}
 	request.URL = util.UnescapeURL(request.URL)
 
-	snapshotName := request.SnapshotName
+	if err := checkLabels(request.Labels); err != nil {
+		return err
+	}
+
+	if request.Async {
+		volumeName := s.SnapshotVolumeIndex.Get(request.SnapshotName)
+		if volumeName == "" {
+			return fmt.Errorf("Cannot find volume of snapshot %v", request.SnapshotName)
+		}
+		j, err := s.startJob(r.Context(), JOB_TYPE_BACKUP_CREATE, volumeName, func(ctx context.Context) (string, error) {
+			return s.processBackupCreate(ctx, request.SnapshotName, request.URL, request.Labels)
+		})
+		if err != nil {
+			return err
+		}
+		return writeJobResponse(w, j)
+	}
+
+	backupURL, err := s.processBackupCreate(r.Context(), request.SnapshotName, request.URL, request.Labels)
+	if err != nil {
+		return err
+	}
+
+	backup := &api.BackupURLResponse{
+		URL: backupURL,
+	}
+	if request.Verbose {
+		return sendResponse(w, backup)
+	}
+	escapedURL := strings.Replace(backupURL, "&", "\\u0026", 1)
+	return writeStringResponse(w, escapedURL)
+}
+
+func (s *daemon) processBackupCreate(ctx context.Context, snapshotName, destURL string, labels map[string]string) (string, error) {
+	if err := s.checkMaintenance(); err != nil {
+		return "", err
+	}
 	volumeName := s.SnapshotVolumeIndex.Get(snapshotName)
 	if volumeName == "" {
-		return fmt.Errorf("Cannot find volume of snapshot %v", snapshotName)
+		return "", fmt.Errorf("Cannot find volume of snapshot %v", snapshotName)
 	}
 
+	if err := s.beginVolumeOperation(volumeName); err != nil {
+		return "", err
+	}
+	defer s.endVolumeOperation(volumeName)
+
 	if !s.snapshotExists(volumeName, snapshotName) {
-		return fmt.Errorf("snapshot %v of volume %v doesn't exist", snapshotName, volumeName)
+		return "", fmt.Errorf("snapshot %v of volume %v doesn't exist", snapshotName, volumeName)
 	}
 
 	volume := s.getVolume(volumeName)
+	if err := s.checkDriverHealth(volume.DriverName); err != nil {
+		return "", err
+	}
+	if err := s.checkCapability(volume.DriverName, CAPABILITY_BACKUP); err != nil {
+		return "", err
+	}
+	if err := s.checkDestinationHealth(destURL, volumeName); err != nil {
+		return "", err
+	}
 	backupOps, err := s.getBackupOpsForVolume(volume)
 	if err != nil {
-		return err
+		return "", err
 	}
 
+	s.destLocks.RLock(destURL)
+	defer s.destLocks.RUnlock(destURL)
+
 	volumeInfo, err := s.getVolumeDriverInfo(volume)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	snapshot, err := s.getSnapshotDriverInfo(snapshotName, volume)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	opts := map[string]string{
 		OPT_VOLUME_NAME:           volumeName,
 		OPT_VOLUME_CREATED_TIME:   volumeInfo[OPT_VOLUME_CREATED_TIME],
 		OPT_SNAPSHOT_CREATED_TIME: snapshot[OPT_SNAPSHOT_CREATED_TIME],
+		OPT_BACKUP_LABELS:         util.EncodeLabels(labels),
 	}
 
 	log.WithFields(logrus.Fields{
@@ -119,12 +303,24 @@ func (s *daemon) doBackupCreate(version string, w http.ResponseWriter, r *http.R
 		LOG_FIELD_SNAPSHOT: snapshotName,
 		LOG_FIELD_VOLUME:   volumeName,
 		LOG_FIELD_DRIVER:   backupOps.Name(),
-		LOG_FIELD_DEST_URL: request.URL,
+		LOG_FIELD_DEST_URL: destURL,
 	}).Debug()
-	backupURL, err := backupOps.CreateBackup(snapshotName, volumeName, request.URL, opts)
+	s.acquireOpSlot(ctx)
+	s.beginVolumeBackup(volumeName)
+	start := time.Now()
+	_, span := startDriverSpan(ctx, "CreateBackup", backupOps.Name(), volumeName)
+	span.SetAttribute(TRACE_ATTR_DEST_URL, destURL)
+	backupURL, err := backupOps.CreateBackup(snapshotName, volumeName, destURL, opts)
+	span.End(err)
+	s.endVolumeBackup(volumeName)
+	s.releaseOpSlot()
+	s.observeOperation(METRICS_OP_BACKUP, start, err)
 	if err != nil {
-		return err
+		s.recordFailure(volumeName, LOG_OBJECT_SNAPSHOT, LOG_EVENT_BACKUP, snapshotName, "to "+destURL, err)
+		return "", err
 	}
+	s.observeLatency(util.LATENCY_BACKUP, volumeName, time.Since(start))
+	s.observeBackupSize(backupOps, backupURL)
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
 		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
@@ -132,17 +328,11 @@ func (s *daemon) doBackupCreate(version string, w http.ResponseWriter, r *http.R
 		LOG_FIELD_SNAPSHOT: snapshotName,
 		LOG_FIELD_VOLUME:   volumeName,
 		LOG_FIELD_DRIVER:   backupOps.Name(),
-		LOG_FIELD_DEST_URL: request.URL,
+		LOG_FIELD_DEST_URL: destURL,
 	}).Debug()
-
-	backup := &api.BackupURLResponse{
-		URL: backupURL,
-	}
-	if request.Verbose {
-		return sendResponse(w, backup)
-	}
-	escapedURL := strings.Replace(backupURL, "&", "\\u0026", 1)
-	return writeStringResponse(w, escapedURL)
+	s.recordEvent(volumeName, LOG_OBJECT_BACKUP_URL, LOG_EVENT_BACKUP, backupURL, "snapshot "+snapshotName)
+	s.queueBackupMirror(volumeName, backupURL)
+	return backupURL, nil
 }
 
 func (s *daemon) doBackupDelete(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
@@ -156,6 +346,19 @@ func (s *daemon) doBackupDelete(version string, w http.ResponseWriter, r *http.R
 	if err != nil {
 		return err
 	}
+	if err := s.checkDriverHealth(backupOps.Name()); err != nil {
+		return err
+	}
+	volumeName := ""
+	if objVolume, err := objectstore.LoadVolume(request.URL); err == nil {
+		volumeName = objVolume.Name
+	}
+
+	destURL := destinationOf(request.URL)
+	s.destLocks.RLock(destURL)
+	defer s.destLocks.RUnlock(destURL)
+	s.acquireOpSlot(r.Context())
+	defer s.releaseOpSlot()
 
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:   LOG_REASON_PREPARE,
@@ -164,7 +367,11 @@ func (s *daemon) doBackupDelete(version string, w http.ResponseWriter, r *http.R
 		LOG_FIELD_DEST_URL: request.URL,
 		LOG_FIELD_DRIVER:   backupOps.Name(),
 	}).Debug()
-	if err := backupOps.DeleteBackup(request.URL); err != nil {
+	_, span := startDriverSpan(r.Context(), "DeleteBackup", backupOps.Name(), volumeName)
+	span.SetAttribute(TRACE_ATTR_DEST_URL, destURL)
+	err = backupOps.DeleteBackup(request.URL)
+	span.End(err)
+	if err != nil {
 		return err
 	}
 	log.WithFields(logrus.Fields{
@@ -174,6 +381,9 @@ func (s *daemon) doBackupDelete(version string, w http.ResponseWriter, r *http.R
 		LOG_FIELD_DEST_URL: request.URL,
 		LOG_FIELD_DRIVER:   backupOps.Name(),
 	}).Debug()
+	if volumeName != "" && s.getVolume(volumeName) != nil {
+		s.recordEvent(volumeName, LOG_OBJECT_BACKUP_URL, LOG_EVENT_REMOVE, request.URL, "")
+	}
 	return nil
 }
 
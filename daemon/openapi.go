@@ -0,0 +1,403 @@
+package daemon
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+/*
+apiRouteDoc tells what apiRoutes() can't: what a route is for, and the types
+of its request and response, which are values of the types. Responses of
+routes with text are plain text, e.g. name of the volume created, unless
+Verbose of request is set. Routes with async answer 202 with the job if Async
+is set.
+*/
+type apiRouteDoc struct {
+	summary      string
+	query        []util.OpenAPIParameter
+	request      interface{}
+	requestType  string
+	response     interface{}
+	responseType string
+	text         string
+	async        bool
+}
+
+var (
+	volumeResponse   = api.VolumeResponse{}
+	snapshotResponse = api.SnapshotResponse{}
+	backupResponse   = api.BackupURLResponse{}
+	backupInfo       = map[string]string{}
+
+	// Keyed by method and route of apiRoutes()
+	apiRouteDocs = map[string]apiRouteDoc{
+		"GET /info": {
+			summary:  "Show config of daemon, objectstore drivers, backup destinations and drivers",
+			response: map[string]interface{}{},
+		},
+		"GET /volumes/list": {
+			summary: "List volumes by name, paged by Convoy-Next-Page header of the next after",
+			query: []util.OpenAPIParameter{
+				{Name: "filter", Type: "string", Repeated: true, Description: "name=<glob>, driver=<driver> or state=<state>"},
+				{Name: "limit", Type: "integer", Description: "Volumes in a page, 0 for all"},
+				{Name: "after", Type: "string", Description: "Name of the last volume of the previous page"},
+				{Name: "brief", Type: "string", Description: "1 for fields not asking drivers only"},
+				{Name: "fields", Type: "string", Description: "Comma separated fields of volumes"},
+				{Name: "idle_for", Type: "string", Description: "Volumes not mounted or written for the duration, e.g. 30d"},
+				{Name: "older_than", Type: "string", Description: "Volumes created before the duration ago, e.g. 7d"},
+				{Name: "driver", Type: "string", Description: "1 for driver of every volume only"},
+			},
+			response: map[string]api.VolumeResponse{},
+		},
+		"GET /volumes/": {
+			summary:  "Inspect volume",
+			request:  api.VolumeInspectRequest{},
+			response: volumeResponse,
+		},
+		"GET /volumes/usage": {
+			summary: "Show size and space used of volumes, by names or selected the same as list",
+			query: []util.OpenAPIParameter{
+				{Name: "volume", Type: "string", Repeated: true, Description: "Name of volume, all volumes listed by the other parameters if not specified"},
+				{Name: "filter", Type: "string", Repeated: true, Description: "name=<glob>, driver=<driver> or state=<state>"},
+				{Name: "limit", Type: "integer", Description: "Volumes in a page, 0 for all"},
+				{Name: "after", Type: "string", Description: "Name of the last volume of the previous page"},
+				{Name: "idle_for", Type: "string", Description: "Volumes not mounted or written for the duration, e.g. 30d"},
+				{Name: "older_than", Type: "string", Description: "Volumes created before the duration ago, e.g. 7d"},
+			},
+			response: map[string]api.VolumeUsageResponse{},
+		},
+		"GET /volumes/timeline": {
+			summary:  "Show events of volume, or of volume backed up to URL",
+			request:  api.VolumeTimelineRequest{},
+			response: api.VolumeTimelineResponse{},
+		},
+		"GET /snapshots/": {
+			summary:  "Inspect snapshot",
+			request:  api.SnapshotInspectRequest{},
+			response: snapshotResponse,
+		},
+		"GET /backups/list": {
+			summary:  "List backups in destination by URL",
+			request:  api.BackupListRequest{},
+			response: map[string]map[string]string{},
+		},
+		"GET /backups/inspect": {
+			summary:  "Inspect backup",
+			request:  api.BackupListRequest{},
+			response: backupInfo,
+		},
+		"GET /backups/export": {
+			summary:      "Export backup with its blocks as tar archive",
+			request:      api.BackupExportRequest{},
+			response:     []byte{},
+			responseType: "application/x-tar",
+		},
+		"GET /schedules/list": {
+			summary:  "List schedules by volume",
+			response: map[string]api.ScheduleResponse{},
+		},
+		"GET /hooks/list": {
+			summary:  "List hooks by volume",
+			response: map[string]api.HookResponse{},
+		},
+		"GET /stats": {
+			summary:  "Show latencies of operations in the sliding window",
+			response: api.StatsResponse{},
+		},
+		"GET /events": {
+			summary: "Stream events, one JSON object each, until the client goes away",
+			query: []util.OpenAPIParameter{
+				{Name: "volume", Type: "string", Description: "Volume to stream events of, all by default"},
+			},
+			response: api.Event{},
+		},
+		"GET /metrics": {
+			summary:      "Show metrics in Prometheus text format",
+			response:     "",
+			responseType: METRICS_CONTENT_TYPE,
+		},
+		"GET /jobs/list": {
+			summary:  "List jobs by ID",
+			response: map[string]api.JobResponse{},
+		},
+		"GET /jobs/": {
+			summary:  "Inspect job",
+			request:  api.JobInspectRequest{},
+			response: api.JobResponse{},
+		},
+		"GET /logging": {
+			summary:  "Show log levels",
+			response: api.LoggingResponse{},
+		},
+		"GET /healthz": {
+			summary:  "Check daemon is alive, 503 if not",
+			response: api.HealthResponse{},
+		},
+		"GET /readyz": {
+			summary:  "Check daemon and drivers are ready, 503 if not",
+			response: api.HealthResponse{},
+		},
+		"GET /audit": {
+			summary: "List records of audit log",
+			query: []util.OpenAPIParameter{
+				{Name: "since", Type: "string", Description: "Time or duration ago, e.g. 24h"},
+				{Name: "until", Type: "string", Description: "Time or duration ago"},
+				{Name: "volume", Type: "string"},
+				{Name: "caller", Type: "string"},
+				{Name: "limit", Type: "integer", Description: "The latest records only"},
+			},
+			response: []api.AuditRecord{},
+		},
+		"GET /quotas": {
+			summary:  "Show quotas by driver and their usage",
+			response: map[string]api.QuotaResponse{},
+		},
+		"GET /leases": {
+			summary:  "List holders of coordinated volumes",
+			response: []api.LeaseResponse{},
+		},
+		"GET /maintenance": {
+			summary:  "Show whether daemon is in maintenance",
+			response: api.MaintenanceResponse{},
+		},
+		"GET /api-spec": {
+			summary:  "Show this document",
+			response: map[string]interface{}{},
+		},
+		"POST /volumes/create": {
+			summary:  "Create volume",
+			request:  api.VolumeCreateRequest{},
+			response: volumeResponse,
+			text:     "Name of volume",
+			async:    true,
+		},
+		"POST /volumes/clone": {
+			summary:  "Create volume as independent copy of another volume",
+			request:  api.VolumeCloneRequest{},
+			response: volumeResponse,
+			text:     "Name of volume",
+			async:    true,
+		},
+		"POST /volumes/expand": {
+			summary:  "Expand volume and grow its filesystem",
+			request:  api.VolumeExpandRequest{},
+			response: volumeResponse,
+			text:     "Name of volume",
+		},
+		"POST /volumes/restore": {
+			summary:  "Create volumes from backups",
+			request:  api.VolumeRestoreRequest{},
+			response: api.VolumeRestoreResponse{},
+		},
+		"POST /volumes/mount": {
+			summary:  "Mount volume",
+			request:  api.VolumeMountRequest{},
+			response: volumeResponse,
+			text:     "Mount point",
+		},
+		"POST /volumes/umount": {
+			summary: "Unmount volume",
+			request: api.VolumeUmountRequest{},
+		},
+		"POST /volumes/publish": {
+			summary:  "Bind mount volume at target path",
+			request:  api.VolumePublishRequest{},
+			response: volumeResponse,
+		},
+		"POST /volumes/unpublish": {
+			summary: "Remove bind mount of volume at target path",
+			request: api.VolumeUnpublishRequest{},
+		},
+		"POST /snapshots/create": {
+			summary:  "Create snapshot of volume",
+			request:  api.SnapshotCreateRequest{},
+			response: snapshotResponse,
+			text:     "Name of snapshot",
+			async:    true,
+		},
+		"POST /backups/create": {
+			summary:  "Back up snapshot to destination",
+			request:  api.BackupCreateRequest{},
+			response: backupResponse,
+			text:     "URL of backup",
+			async:    true,
+		},
+		"POST /backups/index": {
+			summary:  "Refresh index of backups in destination",
+			request:  api.BackupIndexRequest{},
+			response: backupInfo,
+		},
+		"POST /backups/prune": {
+			summary:  "Remove backups beyond retention",
+			request:  api.BackupPruneRequest{},
+			response: []api.BackupPruneResponse{},
+		},
+		"POST /backups/rotate-key": {
+			summary:  "Re-encrypt keys of backups in destination with a new key",
+			request:  api.BackupRotateKeyRequest{},
+			response: backupInfo,
+		},
+		"POST /backups/replicate": {
+			summary:  "Copy backup to another destination",
+			request:  api.BackupReplicateRequest{},
+			response: backupResponse,
+			text:     "URL of backup copied",
+		},
+		"POST /backups/import": {
+			summary: "Import backup exported by /backups/export into destination",
+			query: []util.OpenAPIParameter{
+				{Name: "dest", Type: "string", Description: "URL of destination"},
+				{Name: "verbose", Type: "boolean"},
+			},
+			request:     []byte{},
+			requestType: "application/x-tar",
+			response:    backupResponse,
+			text:        "URL of backup",
+		},
+		"POST /backups/migrate": {
+			summary:  "Upgrade configs in destination to the current format",
+			request:  api.BackupMigrateRequest{},
+			response: backupInfo,
+		},
+		"POST /backups/mount": {
+			summary:  "Mount backup read only without restoring it",
+			request:  api.BackupMountRequest{},
+			response: volumeResponse,
+			text:     "Mount point",
+		},
+		"POST /backups/umount": {
+			summary: "Unmount backup mounted",
+			request: api.BackupUmountRequest{},
+		},
+		"POST /schedules/set": {
+			summary:  "Set schedule of volume",
+			request:  api.ScheduleSetRequest{},
+			response: api.ScheduleResponse{},
+		},
+		"POST /schedules/run": {
+			summary:  "Run schedule of volume now",
+			request:  api.ScheduleRunRequest{},
+			response: api.ScheduleResponse{},
+		},
+		"POST /schedules/export": {
+			summary:  "Export schedules for external schedulers",
+			request:  api.ScheduleExportRequest{},
+			response: api.ScheduleExportResponse{},
+		},
+		"POST /hooks/set": {
+			summary:  "Set hooks of volume",
+			request:  api.HookSetRequest{},
+			response: api.HookResponse{},
+		},
+		"POST /jobs/cancel": {
+			summary:  "Cancel pending job",
+			request:  api.JobCancelRequest{},
+			response: api.JobResponse{},
+		},
+		"POST /logging/set": {
+			summary:  "Change log levels",
+			request:  api.LoggingSetRequest{},
+			response: api.LoggingResponse{},
+		},
+		"POST /maintenance/set": {
+			summary:  "Enter or leave maintenance, refusing to provision volumes and pausing schedules in it",
+			request:  api.MaintenanceSetRequest{},
+			response: api.MaintenanceResponse{},
+		},
+		"POST /leases/break": {
+			summary: "Release volume held by another daemon",
+			request: api.LeaseBreakRequest{},
+		},
+		"DELETE /volumes/": {
+			summary: "Delete volume",
+			request: api.VolumeDeleteRequest{},
+		},
+		"DELETE /snapshots/": {
+			summary: "Delete snapshot",
+			request: api.SnapshotDeleteRequest{},
+		},
+		"DELETE /backups": {
+			summary: "Delete backup",
+			request: api.BackupDeleteRequest{},
+		},
+		"DELETE /schedules": {
+			summary: "Delete schedule of volume",
+			request: api.ScheduleDeleteRequest{},
+		},
+		"DELETE /hooks": {
+			summary: "Delete hooks of volume",
+			request: api.HookDeleteRequest{},
+		},
+	}
+)
+
+// apiOperationID names operation by its handler, e.g. VolumeList of
+// doVolumeList
+func apiOperationID(f requestHandler) string {
+	name := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.TrimPrefix(name, "do")
+}
+
+/*
+apiSpec generates OpenAPI document of routes of apiRoutes(), described by
+apiRouteDocs, with schemas of their requests and responses generated from
+types of package api. Routes without doc are still listed, with bodies of any
+type. Paths of Docker plugin are left out, since they follow the Docker
+plugin API.
+*/
+func (s *daemon) apiSpec() map[string]interface{} {
+	ops := []util.OpenAPIOperation{}
+	for method, routes := range s.apiRoutes() {
+		for route, f := range routes {
+			doc, exists := apiRouteDocs[method+" "+route]
+			if !exists {
+				log.Debugf("No doc of %v %v for API spec", method, route)
+			}
+			op := util.OpenAPIOperation{
+				ID:                 apiOperationID(f),
+				Method:             method,
+				Path:               route,
+				Summary:            doc.summary,
+				Parameters:         doc.query,
+				Request:            doc.request,
+				RequestContentType: doc.requestType,
+				Responses: []util.OpenAPIResponse{
+					{
+						Status:      http.StatusOK,
+						ContentType: doc.responseType,
+						Body:        doc.response,
+					},
+				},
+			}
+			if doc.text != "" {
+				op.Responses = append(op.Responses, util.OpenAPIResponse{
+					Status:      http.StatusOK,
+					ContentType: util.OPENAPI_TEXT,
+					Body:        "",
+				})
+				op.Description = doc.text + " as plain text, unless Verbose is set."
+			}
+			if doc.async {
+				op.Responses = append(op.Responses, util.OpenAPIResponse{
+					Status:      http.StatusAccepted,
+					Description: "Job running the request with Async",
+					Body:        api.JobResponse{},
+				})
+			}
+			ops = append(ops, op)
+		}
+	}
+	return util.NewOpenAPISpec("Convoy API", api.API_VERSION, "/v"+api.API_MAJOR_VERSION, ops)
+}
+
+func (s *daemon) doAPISpec(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	w.Header().Set("Content-Type", util.OPENAPI_JSON)
+	return writeResponseOutput(w, s.apiSpec())
+}
@@ -0,0 +1,182 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	PENDING_DELETE_DIR = "pending-deletes"
+)
+
+/*
+pendingDelete is a delete of the volume requested by Docker while snapshot or
+backup of the volume was in progress. Docker expects Remove to succeed, so the
+delete is recorded and completed once the last operation finishes, or when
+daemon starts if it was interrupted.
+*/
+type pendingDelete struct {
+	Name          string
+	ReferenceOnly bool
+	RequestedTime string
+
+	root string
+}
+
+func (p *pendingDelete) ConfigFile() (string, error) {
+	if p.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if p.root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty pending delete root")
+	}
+	return filepath.Join(p.root, PENDING_DELETE_DIR, VOLUME_CFG_PREFIX+p.Name+CFG_POSTFIX), nil
+}
+
+// isVolumePendingDelete is true if delete of the volume has been deferred,
+// caller should hold volumeOpsMutex
+func (s *daemon) isVolumePendingDelete(volumeName string) bool {
+	exists, err := util.ObjectExists(&pendingDelete{
+		Name: volumeName,
+		root: s.Root,
+	})
+	return err == nil && exists
+}
+
+func (s *daemon) volumePendingDelete(volumeName string) bool {
+	s.volumeOpsMutex.Lock()
+	defer s.volumeOpsMutex.Unlock()
+
+	return s.isVolumePendingDelete(volumeName)
+}
+
+// beginVolumeOperation registers snapshot or backup of the volume in
+// progress, which would hold off delete of the volume until
+// endVolumeOperation() is called
+func (s *daemon) beginVolumeOperation(volumeName string) error {
+	s.volumeOpsMutex.Lock()
+	defer s.volumeOpsMutex.Unlock()
+
+	if s.volumesDeleting[volumeName] || s.isVolumePendingDelete(volumeName) {
+		return fmt.Errorf("Volume %v is being deleted", volumeName)
+	}
+	if s.volumeOps == nil {
+		s.volumeOps = make(map[string]int)
+	}
+	s.volumeOps[volumeName]++
+	return nil
+}
+
+func (s *daemon) endVolumeOperation(volumeName string) {
+	s.volumeOpsMutex.Lock()
+	defer s.volumeOpsMutex.Unlock()
+
+	s.volumeOps[volumeName]--
+	if s.volumeOps[volumeName] > 0 {
+		return
+	}
+	delete(s.volumeOps, volumeName)
+	if s.isVolumePendingDelete(volumeName) {
+		go s.completePendingDelete(volumeName)
+	}
+}
+
+/*
+reserveVolumeDelete stops new snapshots and backups of the volume from
+starting, so it can be deleted. If any is in progress, the delete would be
+rejected, or recorded as pending if deferIfBusy is true. The volume should be
+released by releaseVolumeDelete() unless the delete is deferred.
+*/
+func (s *daemon) reserveVolumeDelete(request *api.VolumeDeleteRequest, deferIfBusy bool) (bool, error) {
+	name := request.VolumeName
+
+	s.volumeOpsMutex.Lock()
+	defer s.volumeOpsMutex.Unlock()
+
+	if s.volumesDeleting[name] {
+		return false, fmt.Errorf("Volume %v is being deleted", name)
+	}
+	if s.volumeOps[name] == 0 {
+		if s.volumesDeleting == nil {
+			s.volumesDeleting = make(map[string]bool)
+		}
+		s.volumesDeleting[name] = true
+		return false, nil
+	}
+	if !deferIfBusy {
+		return false, fmt.Errorf("Cannot delete volume %v, it has snapshot or backup in progress", name)
+	}
+	if err := util.MkdirIfNotExists(filepath.Join(s.Root, PENDING_DELETE_DIR)); err != nil {
+		return false, err
+	}
+	if err := util.ObjectSave(&pendingDelete{
+		Name:          name,
+		ReferenceOnly: request.ReferenceOnly,
+		RequestedTime: util.Now(),
+		root:          s.Root,
+	}); err != nil {
+		return false, err
+	}
+	log.Infof("Volume %v has snapshot or backup in progress, would delete it once finished", name)
+	s.recordEvent(name, LOG_OBJECT_VOLUME, LOG_EVENT_DELETE, name, "deferred until snapshot or backup finishes")
+	return true, nil
+}
+
+func (s *daemon) releaseVolumeDelete(volumeName string) {
+	s.volumeOpsMutex.Lock()
+	defer s.volumeOpsMutex.Unlock()
+
+	delete(s.volumesDeleting, volumeName)
+}
+
+func (s *daemon) completePendingDelete(volumeName string) {
+	pending := &pendingDelete{
+		Name: volumeName,
+		root: s.Root,
+	}
+	if err := util.ObjectLoad(pending); err != nil {
+		log.Warnf("Failed to load pending delete of volume %v: %v", volumeName, err)
+		return
+	}
+	request := &api.VolumeDeleteRequest{
+		VolumeName:    volumeName,
+		ReferenceOnly: pending.ReferenceOnly,
+	}
+	deferred, err := s.reserveVolumeDelete(request, false)
+	if err != nil || deferred {
+		log.Warnf("Cannot complete pending delete of volume %v: %v", volumeName, err)
+		return
+	}
+	defer s.releaseVolumeDelete(volumeName)
+
+	// Record is kept if delete fails, so it would be retried when daemon
+	// starts next time
+	if s.getVolume(volumeName) != nil {
+		if err := s.deleteVolume(context.Background(), request); err != nil {
+			log.Errorf("Failed to complete pending delete of volume %v: %v", volumeName, err)
+			return
+		}
+		log.Infof("Completed pending delete of volume %v", volumeName)
+	}
+	if err := util.ObjectDelete(pending); err != nil {
+		log.Warnf("Failed to remove pending delete of volume %v: %v", volumeName, err)
+	}
+}
+
+// completePendingDeletes finishes deletes deferred before daemon stopped
+func (s *daemon) completePendingDeletes() {
+	volumeNames, err := util.ListConfigIDs(filepath.Join(s.Root, PENDING_DELETE_DIR), VOLUME_CFG_PREFIX, CFG_POSTFIX)
+	if err != nil {
+		log.Warnf("Failed to list pending deletes: %v", err)
+		return
+	}
+	for _, volumeName := range volumeNames {
+		s.completePendingDelete(volumeName)
+	}
+}
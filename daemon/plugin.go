@@ -0,0 +1,136 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/convoy/util"
+)
+
+var (
+	// Sockets passed by systemd socket activation by their paths, served
+	// instead of listening again
+	activatedSockets = map[string]net.Listener{}
+)
+
+/*
+initSocketActivation takes sockets passed by systemd socket activation, so the
+daemon, the Docker plugin and CSI sockets exist before the daemon starts, e.g.
+the daemon is started by the first request of Docker, and while it restarts.
+Requests wait in the sockets until the daemon serves them.
+*/
+func initSocketActivation() error {
+	listeners, err := util.SdListeners()
+	if err != nil {
+		return err
+	}
+	for addr, l := range listeners {
+		if _, ok := l.(*net.UnixListener); !ok {
+			log.Warnf("Ignore socket %v passed by systemd, only unix domain sockets can be activated", addr)
+			l.Close()
+			continue
+		}
+		path, err := filepath.Abs(addr)
+		if err != nil {
+			return err
+		}
+		activatedSockets[path] = l
+		log.Debugf("Got socket %v from systemd", path)
+	}
+	return nil
+}
+
+// closeUnusedActivatedSockets closes sockets passed by systemd which are
+// none of the sockets of the daemon
+func closeUnusedActivatedSockets() {
+	for path, l := range activatedSockets {
+		log.Warnf("Ignore socket %v passed by systemd, which isn't --socket, --plugin-socket or --csi-socket", path)
+		l.Close()
+		delete(activatedSockets, path)
+	}
+}
+
+// listenUnix listens at unix domain socket sockFile, replacing the one left
+// by previous daemon, unless it's passed by systemd
+func listenUnix(sockFile string) (net.Listener, error) {
+	if path, err := filepath.Abs(sockFile); err == nil {
+		if l, exists := activatedSockets[path]; exists {
+			delete(activatedSockets, path)
+			log.Infof("Serving socket %v activated by systemd", sockFile)
+			return l, nil
+		}
+	}
+	if err := util.MkdirIfNotExists(filepath.Dir(sockFile)); err != nil {
+		return nil, err
+	}
+	// This should be safe because lock file prevent starting daemon twice
+	if _, err := os.Stat(sockFile); err == nil {
+		log.Warnf("Remove previous sockfile at %v", sockFile)
+		if err := os.Remove(sockFile); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", sockFile)
+}
+
+/*
+listenPluginSocket listens at the socket Docker connects to when Convoy runs
+as a Docker managed plugin, e.g. /run/docker/plugins/convoy.sock, besides
+the daemon socket, which is kept for the Convoy client on the host. Empty
+sockFile disables it.
+*/
+func listenPluginSocket(sockFile string) (net.Listener, error) {
+	if sockFile == "" {
+		return nil, nil
+	}
+	l, err := listenUnix(sockFile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot listen at plugin socket %v: %v", sockFile, err)
+	}
+	log.Infof("Serving Docker plugin API at %v", sockFile)
+	return l, nil
+}
+
+/*
+initPropagatedMount sets the propagatedMount directory of Docker managed
+plugin, the only directory whose mounts are seen by Docker and containers.
+Default mount points are under the root directory, so it must be within.
+*/
+func (s *daemon) initPropagatedMount(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("Propagated mount %v must be an absolute path", dir)
+	}
+	root, err := filepath.Abs(s.Root)
+	if err != nil {
+		return err
+	}
+	if !pathWithin(root, dir) {
+		return fmt.Errorf("Root directory %v must be within propagated mount %v, or mounts of volumes won't be seen by Docker", root, dir)
+	}
+	s.propagatedMount = filepath.Clean(dir)
+	return nil
+}
+
+// checkPropagatedMount makes sure Docker can see mountPoint of volume
+func (s *daemon) checkPropagatedMount(volumeName, mountPoint string) error {
+	if s.propagatedMount == "" || pathWithin(mountPoint, s.propagatedMount) {
+		return nil
+	}
+	return fmt.Errorf("Volume %v is mounted at %v, outside propagated mount %v of the plugin, so Docker cannot see it. Umount it and let Docker mount it instead",
+		volumeName, mountPoint, s.propagatedMount)
+}
+
+// pathWithin returns true if path is dir or under it
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, "../"))
+}
@@ -0,0 +1,197 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	PUBLICATIONS_CFG_PREFIX = "publications_"
+)
+
+/*
+volumePublications are target paths a volume is published at, bind mounts of
+its mount point for container orchestrators, e.g. by CSI or FlexVolume, so
+the volume is only umounted once the last of them is unpublished. They're
+kept in the root directory, so they survive restarts of the daemon.
+*/
+type volumePublications struct {
+	Root       string
+	VolumeName string
+	Targets    []string
+}
+
+func (p *volumePublications) ConfigFile() (string, error) {
+	if p.Root == "" || p.VolumeName == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume publications path")
+	}
+	return filepath.Join(p.Root, PUBLICATIONS_CFG_PREFIX+p.VolumeName+CFG_POSTFIX), nil
+}
+
+func (s *daemon) loadPublications(volumeName string) (*volumePublications, error) {
+	p := &volumePublications{
+		Root:       s.Root,
+		VolumeName: volumeName,
+	}
+	exists, err := util.ObjectExists(p)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// findPublications returns publications of the volume published at target,
+// nil if none is
+func (s *daemon) findPublications(target string) (*volumePublications, error) {
+	names, err := util.ListConfigIDs(s.Root, PUBLICATIONS_CFG_PREFIX, CFG_POSTFIX)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		p, err := s.loadPublications(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range p.Targets {
+			if t == target {
+				return p, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func checkTargetPath(target string) error {
+	if target == "" {
+		return fmt.Errorf("Target path is required")
+	}
+	if !filepath.IsAbs(target) {
+		return fmt.Errorf("Target path %v must be an absolute path", target)
+	}
+	return nil
+}
+
+/*
+doVolumePublish mounts the volume at its mount point, the default one unless
+it's mounted already, and bind mounts it at the target path, read-only if
+asked, so a volume can be used by multiple containers on the host, e.g. pods
+of Kubernetes. Publishing at the same target again does nothing.
+*/
+func (s *daemon) doVolumePublish(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.VolumePublishRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if err := util.CheckName(request.VolumeName); err != nil {
+		return err
+	}
+	if err := checkTargetPath(request.TargetPath); err != nil {
+		return err
+	}
+	target := filepath.Clean(request.TargetPath)
+	volume := s.getVolume(request.VolumeName)
+	if volume == nil {
+		return notFoundAPIError
+	}
+
+	s.publishMutex.Lock()
+	defer s.publishMutex.Unlock()
+
+	mountPoint, err := s.processVolumeMount(r.Context(), volume, &api.VolumeMountRequest{
+		VolumeName: volume.Name,
+	})
+	if err != nil {
+		return err
+	}
+	if err := util.BindMount(mountPoint, target, request.ReadOnly); err != nil {
+		return fmt.Errorf("Cannot publish volume %v at %v: %v", volume.Name, target, err)
+	}
+
+	p, err := s.loadPublications(volume.Name)
+	if err != nil {
+		return err
+	}
+	published := false
+	for _, t := range p.Targets {
+		if t == target {
+			published = true
+		}
+	}
+	if !published {
+		p.Targets = append(p.Targets, target)
+		if err := util.ObjectSave(p); err != nil {
+			return err
+		}
+	}
+	log.Debugf("Published volume %v at %v", volume.Name, target)
+	return writeResponseOutput(w, api.VolumeResponse{
+		Name:       volume.Name,
+		MountPoint: mountPoint,
+	})
+}
+
+/*
+doVolumeUnpublish umounts the target path, and the volume once it's not
+published anywhere else. The volume is found by the target if not specified.
+Unpublishing a target not published does nothing.
+*/
+func (s *daemon) doVolumeUnpublish(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.VolumeUnpublishRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if err := util.CheckName(request.VolumeName); err != nil {
+		return err
+	}
+	if err := checkTargetPath(request.TargetPath); err != nil {
+		return err
+	}
+	target := filepath.Clean(request.TargetPath)
+
+	s.publishMutex.Lock()
+	defer s.publishMutex.Unlock()
+
+	if err := util.UmountPath(target); err != nil {
+		return fmt.Errorf("Cannot unpublish %v: %v", target, err)
+	}
+	var p *volumePublications
+	var err error
+	if request.VolumeName != "" {
+		p, err = s.loadPublications(request.VolumeName)
+	} else {
+		p, err = s.findPublications(target)
+	}
+	if err != nil || p == nil {
+		return err
+	}
+	targets := []string{}
+	for _, t := range p.Targets {
+		if t != target {
+			targets = append(targets, t)
+		}
+	}
+	p.Targets = targets
+	if len(p.Targets) != 0 {
+		return util.ObjectSave(p)
+	}
+
+	if volume := s.getVolume(p.VolumeName); volume != nil {
+		if err := s.processVolumeUmount(r.Context(), volume); err != nil {
+			return err
+		}
+	}
+	if err := util.ObjectDelete(p); err != nil {
+		return err
+	}
+	log.Debugf("Unpublished volume %v at %v", p.VolumeName, target)
+	return nil
+}
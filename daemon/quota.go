@@ -0,0 +1,370 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+const (
+	QUOTA_VOLUMES     = "volumes"
+	QUOTA_TOTAL_SIZE  = "total-size"
+	QUOTA_VOLUME_SIZE = "volume-size"
+
+	// Info of drivers and backups telling sizes of volumes created without
+	// size
+	DRIVER_INFO_DEFAULT_SIZE = "DefaultVolumeSize"
+	BACKUP_INFO_VOLUME_SIZE  = "VolumeSize"
+)
+
+var (
+	quotaLimits = []string{QUOTA_VOLUMES, QUOTA_TOTAL_SIZE, QUOTA_VOLUME_SIZE}
+)
+
+/*
+driverQuota limits volumes of a driver, so a misbehaving client cannot
+provision without bound, e.g. running up EBS spending or exhausting the thin
+pool. Zero means unlimited. Volumes being created are reserved, so
+concurrent creates cannot overrun the quota together.
+*/
+type driverQuota struct {
+	MaxVolumes    int
+	MaxTotalSize  int64
+	MaxVolumeSize int64
+
+	// Sizes of volumes being created or expanded
+	reserved map[string]int64
+}
+
+// parseQuotas parses specs in the form of <driver>.<limit>=<value>, e.g.
+// ebs.total-size=10T, of the drivers
+func parseQuotas(specs []string, drivers []string) (map[string]*driverQuota, error) {
+	quotas := make(map[string]*driverQuota)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		keys := strings.SplitN(parts[0], ".", 2)
+		if len(parts) != 2 || len(keys) != 2 {
+			return nil, fmt.Errorf("Invalid quota %q, should be <driver>.<limit>=<value>, e.g. ebs.total-size=10T", spec)
+		}
+		driver, limit := keys[0], keys[1]
+		if !stringListContains(drivers, driver) {
+			return nil, fmt.Errorf("Invalid driver %v in quota %q, should be one of %v", driver, spec, drivers)
+		}
+		q, exists := quotas[driver]
+		if !exists {
+			q = &driverQuota{
+				reserved: make(map[string]int64),
+			}
+			quotas[driver] = q
+		}
+		switch limit {
+		case QUOTA_VOLUMES:
+			value, err := strconv.Atoi(parts[1])
+			if err != nil || value <= 0 {
+				return nil, fmt.Errorf("Invalid number of volumes in quota %q, must be a positive integer", spec)
+			}
+			q.MaxVolumes = value
+		case QUOTA_TOTAL_SIZE, QUOTA_VOLUME_SIZE:
+			value, err := util.ParseSize(parts[1])
+			if err != nil || value <= 0 {
+				return nil, fmt.Errorf("Invalid size in quota %q, must be positive, e.g. 100G", spec)
+			}
+			if limit == QUOTA_TOTAL_SIZE {
+				q.MaxTotalSize = value
+			} else {
+				q.MaxVolumeSize = value
+			}
+		default:
+			return nil, fmt.Errorf("Invalid limit %v in quota %q, should be one of %v", limit, spec, quotaLimits)
+		}
+	}
+	return quotas, nil
+}
+
+func (s *daemon) initQuotas(specs []string) error {
+	quotas, err := parseQuotas(specs, s.DriverList)
+	if err != nil {
+		return err
+	}
+	s.setQuotas(quotas)
+	return nil
+}
+
+// setQuotas replaces quotas, keeping reservations of volumes being created
+func (s *daemon) setQuotas(quotas map[string]*driverQuota) {
+	s.quotaMutex.Lock()
+	defer s.quotaMutex.Unlock()
+
+	for driver, q := range quotas {
+		if old, exists := s.quotas[driver]; exists {
+			q.reserved = old.reserved
+		}
+		log.Debugf("Quota of driver %v: %v volumes, %v bytes in total, %v bytes per volume",
+			driver, q.MaxVolumes, q.MaxTotalSize, q.MaxVolumeSize)
+	}
+	s.quotas = quotas
+}
+
+func (s *daemon) getQuota(driverName string) (driverQuota, bool) {
+	s.quotaMutex.Lock()
+	defer s.quotaMutex.Unlock()
+
+	q, exists := s.quotas[driverName]
+	if !exists {
+		return driverQuota{}, false
+	}
+	return *q, true
+}
+
+/*
+requestedSize returns size of the volume to be created. Volumes created
+without size get the default size of driver, or the size of the volume
+backed up if they're restored.
+*/
+func (s *daemon) requestedSize(driver ConvoyDriver, request *api.VolumeCreateRequest) (int64, error) {
+	if request.Size != 0 {
+		return request.Size, nil
+	}
+	var (
+		info map[string]string
+		err  error
+	)
+	key := DRIVER_INFO_DEFAULT_SIZE
+	if request.BackupURL != "" {
+		backupOps, err := driver.BackupOps()
+		if err != nil {
+			return 0, err
+		}
+		if info, err = backupOps.GetBackupInfo(util.UnescapeURL(request.BackupURL)); err != nil {
+			return 0, err
+		}
+		key = BACKUP_INFO_VOLUME_SIZE
+		if info[key] == "" {
+			key = OPT_SIZE
+		}
+	} else if info, err = driver.Info(); err != nil {
+		return 0, err
+	}
+	size, err := strconv.ParseInt(info[key], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot tell size of volume %v, specify it to be checked against quota of driver %v",
+			request.Name, driver.Name())
+	}
+	return size, nil
+}
+
+/*
+driverVolumeSizes returns volumes of the driver, along with their sizes if
+sizes is true. Sizes may be found by calling backends, e.g. one call per
+volume for EBS, so caller shouldn't hold quotaMutex.
+*/
+func (s *daemon) driverVolumeSizes(driverName string, sizes bool) (map[string]int64, error) {
+	volumes := make(map[string]int64)
+	for name, info := range s.getVolumeList() {
+		if info["Driver"] != driverName {
+			continue
+		}
+		volumes[name] = 0
+		if !sizes {
+			continue
+		}
+		driverInfo, err := s.getVolumeDriverInfo(&Volume{
+			Name:       name,
+			DriverName: driverName,
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Volumes without size don't take space of their own, e.g. vfs
+		volumes[name], _ = strconv.ParseInt(driverInfo[OPT_SIZE], 10, 64)
+	}
+	return volumes, nil
+}
+
+/*
+lockQuotaUsage returns volumes of the driver by driverVolumeSizes(), with
+quotaMutex held unless it fails. They're listed again if any reservation is
+released meanwhile, since the volume just created could be missed by both
+the list and the reservations otherwise.
+*/
+func (s *daemon) lockQuotaUsage(driverName string, sizes bool) (map[string]int64, error) {
+	for {
+		s.quotaMutex.Lock()
+		releases := s.quotaReleases
+		s.quotaMutex.Unlock()
+
+		volumes, err := s.driverVolumeSizes(driverName, sizes)
+		if err != nil {
+			return nil, err
+		}
+		s.quotaMutex.Lock()
+		if s.quotaReleases == releases {
+			return volumes, nil
+		}
+		s.quotaMutex.Unlock()
+	}
+}
+
+/*
+quotaUsage returns number of volumes, along with their total size. Volumes
+being created are counted by their reservations. Caller should hold
+quotaMutex.
+*/
+func quotaUsage(volumes map[string]int64, q *driverQuota) (int, int64) {
+	count := 0
+	var total int64
+	for name, size := range volumes {
+		if _, reserved := q.reserved[name]; reserved {
+			continue
+		}
+		count++
+		total += size
+	}
+	for _, size := range q.reserved {
+		count++
+		total += size
+	}
+	return count, total
+}
+
+func quotaExceededError(format string, args ...interface{}) error {
+	return APIError{
+		statusCode: http.StatusForbidden,
+		error:      fmt.Sprintf(format, args...),
+	}
+}
+
+/*
+reserveQuota checks the volume can be created by quota of the driver, and
+reserves its size until releaseQuota() is called once it's created or
+failed.
+*/
+func (s *daemon) reserveQuota(volumeName string, driver ConvoyDriver, request *api.VolumeCreateRequest) error {
+	limits, exists := s.getQuota(driver.Name())
+	if !exists {
+		return nil
+	}
+	// Size may be found by calling backends, so it's done without holding
+	// quotaMutex
+	var size int64
+	if limits.MaxVolumeSize != 0 || limits.MaxTotalSize != 0 {
+		var err error
+		if size, err = s.requestedSize(driver, request); err != nil {
+			return err
+		}
+	}
+
+	volumes, err := s.lockQuotaUsage(driver.Name(), limits.MaxTotalSize != 0)
+	if err != nil {
+		return err
+	}
+	defer s.quotaMutex.Unlock()
+
+	// Quota may have been reloaded meanwhile
+	q, exists := s.quotas[driver.Name()]
+	if !exists {
+		return nil
+	}
+	if q.MaxVolumeSize != 0 && size > q.MaxVolumeSize {
+		return quotaExceededError("Volume %v of %v bytes exceeds quota of driver %v, %v bytes per volume",
+			volumeName, size, driver.Name(), q.MaxVolumeSize)
+	}
+	count, total := quotaUsage(volumes, q)
+	if q.MaxVolumes != 0 && count+1 > q.MaxVolumes {
+		return quotaExceededError("Volume %v exceeds quota of driver %v, %v volumes, with %v volumes already",
+			volumeName, driver.Name(), q.MaxVolumes, count)
+	}
+	if q.MaxTotalSize != 0 && total+size > q.MaxTotalSize {
+		return quotaExceededError("Volume %v of %v bytes exceeds quota of driver %v, %v bytes in total, with %v bytes already",
+			volumeName, size, driver.Name(), q.MaxTotalSize, total)
+	}
+	q.reserved[volumeName] = size
+	return nil
+}
+
+/*
+reserveQuotaExpansion checks the volume can be expanded to size by quota of
+the driver, and reserves the new size until releaseQuota() is called. The
+volume is counted by its new size rather than its current one meanwhile.
+*/
+func (s *daemon) reserveQuotaExpansion(volumeName string, driver ConvoyDriver, size int64) error {
+	limits, exists := s.getQuota(driver.Name())
+	if !exists {
+		return nil
+	}
+	volumes, err := s.lockQuotaUsage(driver.Name(), limits.MaxTotalSize != 0)
+	if err != nil {
+		return err
+	}
+	defer s.quotaMutex.Unlock()
+
+	// Quota may have been reloaded meanwhile
+	q, exists := s.quotas[driver.Name()]
+	if !exists {
+		return nil
+	}
+	if q.MaxVolumeSize != 0 && size > q.MaxVolumeSize {
+		return quotaExceededError("Volume %v expanded to %v bytes exceeds quota of driver %v, %v bytes per volume",
+			volumeName, size, driver.Name(), q.MaxVolumeSize)
+	}
+	q.reserved[volumeName] = size
+	if q.MaxTotalSize == 0 {
+		return nil
+	}
+	if _, total := quotaUsage(volumes, q); total > q.MaxTotalSize {
+		delete(q.reserved, volumeName)
+		return quotaExceededError("Volume %v expanded to %v bytes exceeds quota of driver %v, %v bytes in total, with %v bytes then",
+			volumeName, size, driver.Name(), q.MaxTotalSize, total)
+	}
+	return nil
+}
+
+func (s *daemon) releaseQuota(volumeName, driverName string) {
+	s.quotaMutex.Lock()
+	defer s.quotaMutex.Unlock()
+
+	if q, exists := s.quotas[driverName]; exists {
+		delete(q.reserved, volumeName)
+	}
+	s.quotaReleases++
+}
+
+func (s *daemon) doQuotaList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	s.quotaMutex.Lock()
+	driverNames := []string{}
+	for driverName := range s.quotas {
+		driverNames = append(driverNames, driverName)
+	}
+	s.quotaMutex.Unlock()
+
+	resp := make(map[string]api.QuotaResponse)
+	for _, driverName := range driverNames {
+		volumes, err := s.lockQuotaUsage(driverName, true)
+		if err != nil {
+			return err
+		}
+		q, exists := s.quotas[driverName]
+		if !exists {
+			// Quota may have been reloaded meanwhile
+			s.quotaMutex.Unlock()
+			continue
+		}
+		count, total := quotaUsage(volumes, q)
+		resp[driverName] = api.QuotaResponse{
+			Driver:        driverName,
+			MaxVolumes:    q.MaxVolumes,
+			MaxTotalSize:  q.MaxTotalSize,
+			MaxVolumeSize: q.MaxVolumeSize,
+			Volumes:       count,
+			TotalSize:     total,
+		}
+		s.quotaMutex.Unlock()
+	}
+	return writeResponseOutput(w, resp)
+}
@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"net/http"
+
+	"github.com/rancher/convoy/api"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestParseQuotas(c *C) {
+	drivers := []string{"ebs", "vfs"}
+
+	quotas, err := parseQuotas([]string{"ebs.volumes=10", "ebs.total-size=1T", "ebs.volume-size=100G", "vfs.volumes=2"}, drivers)
+	c.Assert(err, IsNil)
+	c.Assert(quotas, HasLen, 2)
+	// Specs of one driver are merged
+	c.Assert(quotas["ebs"].MaxVolumes, Equals, 10)
+	c.Assert(quotas["ebs"].MaxTotalSize, Equals, int64(1<<40))
+	c.Assert(quotas["ebs"].MaxVolumeSize, Equals, int64(100<<30))
+	c.Assert(quotas["ebs"].reserved, NotNil)
+	c.Assert(*quotas["vfs"], DeepEquals, driverQuota{MaxVolumes: 2, reserved: map[string]int64{}})
+
+	quotas, err = parseQuotas(nil, drivers)
+	c.Assert(err, IsNil)
+	c.Assert(quotas, HasLen, 0)
+
+	testCases := []struct {
+		spec string
+		err  string
+	}{
+		{"ebs", "Invalid quota \"ebs\", should be .*"},
+		{"ebs=10", "Invalid quota \"ebs=10\", should be .*"},
+		{"devicemapper.volumes=10", "Invalid driver devicemapper in quota .*"},
+		{"ebs.snapshots=10", "Invalid limit snapshots in quota .*"},
+		{"ebs.volumes=many", "Invalid number of volumes in quota .*"},
+		{"ebs.volumes=0", "Invalid number of volumes in quota .*"},
+		{"ebs.volumes=-1", "Invalid number of volumes in quota .*"},
+		{"ebs.total-size=lots", "Invalid size in quota .*"},
+		{"ebs.total-size=0", "Invalid size in quota .*"},
+		{"ebs.volume-size=-1G", "Invalid size in quota .*"},
+	}
+	for _, t := range testCases {
+		_, err := parseQuotas([]string{"vfs.volumes=2", t.spec}, drivers)
+		c.Assert(err, ErrorMatches, t.err, Commentf("%q", t.spec))
+	}
+}
+
+func (s *TestSuite) TestReserveQuota(c *C) {
+	d := newTestDaemon(c)
+	c.Assert(d.initQuotas([]string{"vfs.volumes=2"}), IsNil)
+
+	code, body := d.call(c, "POST", "/volumes/create", &api.VolumeCreateRequest{Name: "vol1"}, nil)
+	c.Assert(code, Equals, http.StatusOK, Commentf(body))
+	// Volume being created counts along with the one created
+	c.Assert(d.reserveQuota("vol2", d.ConvoyDrivers["vfs"], &api.VolumeCreateRequest{Name: "vol2"}), IsNil)
+	code, body = d.call(c, "POST", "/volumes/create", &api.VolumeCreateRequest{Name: "vol3"}, nil)
+	c.Assert(code, Equals, http.StatusForbidden)
+	c.Assert(body, Matches, "Volume vol3 exceeds quota of driver vfs, 2 volumes, with 2 volumes already\n")
+
+	d.releaseQuota("vol2", "vfs")
+	quotas := map[string]api.QuotaResponse{}
+	code, body = d.call(c, "GET", "/quotas", nil, &quotas)
+	c.Assert(code, Equals, http.StatusOK, Commentf(body))
+	c.Assert(quotas["vfs"].Volumes, Equals, 1)
+	c.Assert(quotas["vfs"].MaxVolumes, Equals, 2)
+}
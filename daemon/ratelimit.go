@@ -0,0 +1,135 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+var (
+	// Probes must not be throttled by other requests of their clients,
+	// otherwise a busy daemon would be restarted for being unhealthy
+	rateLimitExemptRoutes = map[string]bool{
+		"/healthz": true,
+		"/readyz":  true,
+	}
+)
+
+// initRateLimits limits requests of clients by specs of --rate-limit, see
+// util.ParseRateLimits()
+func (s *daemon) initRateLimits(specs []string) error {
+	defaultLimit, limits, err := util.ParseRateLimits(specs)
+	if err != nil {
+		return err
+	}
+	s.rateLimitedRequests = make(map[string]int64)
+	s.rateLimiter = util.NewRateLimiter(nil, nil)
+	s.setRateLimits(defaultLimit, limits)
+	return nil
+}
+
+func (s *daemon) setRateLimits(defaultLimit *util.RateLimit, limits map[string]util.RateLimit) {
+	s.rateLimiter.SetLimits(defaultLimit, limits)
+	if defaultLimit != nil {
+		log.Debugf("Rate limit of clients: %v", defaultLimit)
+	}
+	for client, limit := range limits {
+		log.Debugf("Rate limit of client %v: %v", client, limit)
+	}
+}
+
+/*
+rateLimitClient returns the client whose limit applies to the request:
+principal=<name> for authenticated requests, uid=<uid> for peers of unix
+domain sockets regardless of their processes, csi for CSI, and address
+without port for the rest, e.g. gRPC over TCP.
+*/
+func rateLimitClient(r *http.Request) string {
+	caller := auditCaller(r)
+	if strings.HasPrefix(caller, "uid=") {
+		return strings.SplitN(caller, ",", 2)[0]
+	}
+	if host, _, err := net.SplitHostPort(caller); err == nil {
+		return host
+	}
+	return caller
+}
+
+// rateLimit tells whether request of client can be served, otherwise writes
+// Retry-After and counts it as rejected
+func (s *daemon) rateLimit(w http.ResponseWriter, client, route string) (bool, error) {
+	if s.rateLimiter == nil {
+		return true, nil
+	}
+	ok, wait := s.rateLimiter.Allow(client)
+	if ok {
+		return true, nil
+	}
+	s.rateLimitMutex.Lock()
+	s.rateLimitedRequests[client]++
+	s.rateLimitMutex.Unlock()
+
+	retryAfter := int64(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	limit, _ := s.rateLimiter.Limit(client)
+	log.Debugf("Rejected %v of client %v exceeding rate limit %v", route, client, limit)
+	return false, fmt.Errorf("Rate limit of client %v exceeded (%v), retry after %v",
+		client, limit, time.Duration(retryAfter)*time.Second)
+}
+
+// rateLimitHandler rejects requests of clients exceeding their rate limits
+// with 429 Too Many Requests
+func (s *daemon) rateLimitHandler(route string, h http.HandlerFunc) http.HandlerFunc {
+	if rateLimitExemptRoutes[route] {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, err := s.rateLimit(w, rateLimitClient(r), route); !ok {
+			w.Header().Set(api.API_VERSION_HEADER, api.API_VERSION)
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// rateLimitPluginHandler limits Docker as client AUDIT_CALLER_DOCKER,
+// answering in the form of plugin protocol
+func (s *daemon) rateLimitPluginHandler(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, err := s.rateLimit(w, AUDIT_CALLER_DOCKER, route); !ok {
+			dockerResponse(w, "", err)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// writeRateLimitMetrics adds requests rejected by rate limits to metrics
+func (s *daemon) writeRateLimitMetrics(b *bytes.Buffer) {
+	s.rateLimitMutex.Lock()
+	defer s.rateLimitMutex.Unlock()
+
+	if len(s.rateLimitedRequests) == 0 {
+		return
+	}
+	clients := []string{}
+	for client := range s.rateLimitedRequests {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+	b.WriteString("# HELP convoy_api_rate_limited_total Requests rejected for exceeding rate limits by client.\n")
+	b.WriteString("# TYPE convoy_api_rate_limited_total counter\n")
+	for _, client := range clients {
+		fmt.Fprintf(b, "convoy_api_rate_limited_total{client=%q} %v\n", client, s.rateLimitedRequests[client])
+	}
+}
@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+/*
+reconcileDrivers reconciles drivers implementing Reconciler with their
+metadata when daemon starts, so volumes mounted before an unclean shutdown or
+reboot are mounted again and mounts left behind are cleaned up, before
+requests are served. Fixes are recorded in timelines of the volumes, and
+volumes failed to be reconciled are put in error, so they would be noticed
+instead of failing the daemon start.
+*/
+func (s *daemon) reconcileDrivers() {
+	for _, driverName := range s.DriverList {
+		reconciler, ok := s.ConvoyDrivers[driverName].(Reconciler)
+		if !ok {
+			continue
+		}
+		results, err := reconciler.Reconcile()
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_FAILURE,
+				LOG_FIELD_EVENT:  LOG_EVENT_RECONCILE,
+				LOG_FIELD_DRIVER: driverName,
+			}).Errorf("Failed to reconcile driver: %v", err)
+			s.recordDriverEvent(driverName, LOG_EVENT_RECONCILE, "failed: "+err.Error())
+		}
+		fixed, failed := 0, 0
+		for _, result := range results {
+			if result.Err != nil {
+				failed++
+				s.reconcileFailure(driverName, result)
+				continue
+			}
+			fixed++
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+				LOG_FIELD_EVENT:  LOG_EVENT_RECONCILE,
+				LOG_FIELD_DRIVER: driverName,
+				LOG_FIELD_VOLUME: result.Volume,
+			}).Info(result.Action)
+			if result.Volume == "" {
+				s.recordDriverEvent(driverName, LOG_EVENT_RECONCILE, result.Action)
+				continue
+			}
+			s.recordEvent(result.Volume, LOG_OBJECT_VOLUME, LOG_EVENT_RECONCILE, result.Volume, result.Action)
+		}
+		if fixed != 0 || failed != 0 {
+			log.Infof("Reconciled driver %v at startup, %v fixed, %v failed", driverName, fixed, failed)
+		}
+	}
+}
+
+func (s *daemon) reconcileFailure(driverName string, result Reconciliation) {
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_FAILURE,
+		LOG_FIELD_EVENT:  LOG_EVENT_RECONCILE,
+		LOG_FIELD_DRIVER: driverName,
+		LOG_FIELD_VOLUME: result.Volume,
+	}).Errorf("Failed to reconcile: %v", result.Err)
+	if result.Volume == "" {
+		s.recordDriverEvent(driverName, LOG_EVENT_RECONCILE, "failed: "+result.Err.Error())
+		return
+	}
+	s.recordFailure(result.Volume, LOG_OBJECT_VOLUME, LOG_EVENT_RECONCILE, result.Volume, result.Action, result.Err)
+	s.setVolumeState(result.Volume, VOLUME_STATE_ERROR, result.Err)
+}
@@ -0,0 +1,36 @@
+package daemon
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+// RecoverDriver would rebuild the driver config in daemon root directory from the
+// state exported by driver. Daemon must not be running at the time.
+func RecoverDriver(c *cli.Context) error {
+	var err error
+
+	root, err := util.GetFlag(c, "root", true, err)
+	driverName, err := util.GetFlag(c, "driver", true, err)
+	destURL, err := util.GetFlag(c, "from", true, err)
+	if err != nil {
+		return err
+	}
+
+	if err := util.MkdirIfNotExists(root); err != nil {
+		return err
+	}
+	lockPath := filepath.Join(root, LOCKFILE)
+	lock, err := util.LockFile(lockPath)
+	if err != nil {
+		return fmt.Errorf("Failed to lock the file at %v, is daemon running? %v", lockPath, err)
+	}
+	defer util.UnlockFile(lock)
+
+	return Recover(driverName, root, util.UnescapeURL(destURL), c.String("host"))
+}
@@ -0,0 +1,174 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+/*
+reloadableConfig has the settings of --config-file, which override their
+options of daemon, and can be changed without restarting daemon by SIGHUP.
+Settings left out fall back to the options. DriverOpts only change defaults
+of new volumes, e.g. ebs.defaultvolumetype, by drivers supporting it.
+*/
+type reloadableConfig struct {
+	LogLevel       string
+	ScheduleJitter string
+	Quotas         []string
+	RateLimits     []string
+	DriverOpts     map[string]string
+}
+
+func loadReloadableConfig(file string) (*reloadableConfig, error) {
+	config := &reloadableConfig{}
+	if file == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := util.DecodeYAML(data, config); err != nil {
+		return nil, fmt.Errorf("Invalid config file %v: %v", file, err)
+	}
+	return config, nil
+}
+
+// driverDefaults groups driver options by their drivers, which must be able
+// to reload them
+func (s *daemon) driverDefaults(opts map[string]string) (map[DefaultsReloader]map[string]string, error) {
+	defaults := make(map[DefaultsReloader]map[string]string)
+	for k, v := range opts {
+		driverName := strings.SplitN(k, ".", 2)[0]
+		driver, err := s.getDriver(driverName)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid driver option %v: %v", k, err)
+		}
+		reloader, ok := driver.(DefaultsReloader)
+		if !ok {
+			return nil, fmt.Errorf("Invalid driver option %v: driver %v cannot change options without restart", k, driverName)
+		}
+		if defaults[reloader] == nil {
+			defaults[reloader] = make(map[string]string)
+		}
+		defaults[reloader][k] = v
+	}
+	return defaults, nil
+}
+
+/*
+reloadConfig applies --config-file, and rereads the files and secrets other
+options refer to: principals of --auth-config, S3 customer key and TLS
+certificates of S3 endpoint. Everything is read and validated before any is
+applied, so an invalid config leaves the daemon as it was, except drivers
+rejecting their options. Log levels changed by /logging/set are replaced.
+*/
+func (s *daemon) reloadConfig(c *cli.Context) error {
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	file := c.String("config-file")
+	config, err := loadReloadableConfig(file)
+	if err != nil {
+		return err
+	}
+
+	levelSpec := c.String("log-level")
+	if config.LogLevel != "" {
+		levelSpec = config.LogLevel
+	}
+	levels, err := ParseLevels(levelSpec)
+	if err != nil {
+		return err
+	}
+	jitterSpec := c.String("schedule-jitter")
+	if config.ScheduleJitter != "" {
+		jitterSpec = config.ScheduleJitter
+	}
+	jitter, err := util.ParseDuration(jitterSpec)
+	if err != nil || jitter < 0 {
+		return fmt.Errorf("Invalid schedule jitter %v", jitterSpec)
+	}
+	quotaSpecs := c.StringSlice("quota")
+	if config.Quotas != nil {
+		quotaSpecs = config.Quotas
+	}
+	quotas, err := parseQuotas(quotaSpecs, s.DriverList)
+	if err != nil {
+		return err
+	}
+	rateLimitSpecs := c.StringSlice("rate-limit")
+	if config.RateLimits != nil {
+		rateLimitSpecs = config.RateLimits
+	}
+	defaultRateLimit, rateLimits, err := util.ParseRateLimits(rateLimitSpecs)
+	if err != nil {
+		return err
+	}
+	defaults, err := s.driverDefaults(config.DriverOpts)
+	if err != nil {
+		return err
+	}
+	var auth *authConfig
+	if s.auth != nil {
+		if auth, err = loadAuthConfig(c.String("auth-config")); err != nil {
+			return err
+		}
+	}
+	// Each of them is applied only if it's read and valid
+	if err := initS3Encryption(c); err != nil {
+		return err
+	}
+	if err := initS3Endpoint(c); err != nil {
+		return err
+	}
+
+	if levels.String() != logFormatter.Levels().String() {
+		log.Infof("Changing log levels from %v to %v", logFormatter.Levels(), levels)
+		logFormatter.SetLevels(levels)
+	}
+	s.setScheduleJitter(jitter)
+	s.setQuotas(quotas)
+	s.setRateLimits(defaultRateLimit, rateLimits)
+	if auth != nil {
+		s.auth.update(auth)
+	}
+	failed := []string{}
+	for reloader, opts := range defaults {
+		if err := reloader.ReloadDefaults(opts); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) != 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("Failed to reload driver options: %v", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+/*
+reloadOnSignal reloads config on every signal received, e.g. SIGHUP sent by
+"systemctl reload" with ExecReload=/bin/kill -HUP $MAINPID. Failures are
+logged and leave the daemon running with the config it had.
+*/
+func (s *daemon) reloadOnSignal(c *cli.Context, sigs chan os.Signal) {
+	for sig := range sigs {
+		log.Infof("Caught signal %s: reloading config", sig)
+		util.SdNotify(util.SD_NOTIFY_RELOADING)
+		if err := s.reloadConfig(c); err != nil {
+			log.Errorf("Failed to reload config: %v", err)
+		} else {
+			log.Infof("Reloaded config")
+		}
+		util.SdNotify(util.SD_NOTIFY_READY)
+	}
+}
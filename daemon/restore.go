@@ -0,0 +1,117 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+/*
+doVolumeRestore creates volumes from backups as listed in the request, e.g.
+from a restore manifest. All the volumes would be validated before any of them
+is created, so a bad manifest won't leave half of an environment behind.
+Creation stops at the first failure, volumes created before would be kept and
+reported in the error.
+*/
+func (s *daemon) doVolumeRestore(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.VolumeRestoreRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if len(request.Volumes) == 0 {
+		return fmt.Errorf("No volume specified to restore")
+	}
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+
+	resp := api.VolumeRestoreResponse{
+		DryRun:  request.DryRun,
+		Volumes: []api.VolumeRestoreResult{},
+	}
+	names := make(map[string]bool)
+	for i := range request.Volumes {
+		volume := &request.Volumes[i]
+		if err := s.validateVolumeRestore(volume, names); err != nil {
+			return err
+		}
+		names[volume.Name] = true
+		resp.Volumes = append(resp.Volumes, api.VolumeRestoreResult{
+			Name:      volume.Name,
+			Driver:    volume.DriverName,
+			BackupURL: volume.BackupURL,
+			Size:      volume.Size,
+		})
+	}
+	if request.DryRun {
+		return writeResponseOutput(w, resp)
+	}
+
+	restored := []string{}
+	for i := range request.Volumes {
+		volume, err := s.processVolumeCreate(r.Context(), &request.Volumes[i])
+		if err != nil {
+			if len(restored) == 0 {
+				return fmt.Errorf("Failed to restore volume %v: %v", request.Volumes[i].Name, err)
+			}
+			return fmt.Errorf("Failed to restore volume %v: %v. Volumes already restored: %v",
+				request.Volumes[i].Name, err, strings.Join(restored, ", "))
+		}
+		restored = append(restored, volume.Name)
+		driverInfo, err := s.getVolumeDriverInfo(volume)
+		if err != nil {
+			return err
+		}
+		resp.Volumes[i].CreatedTime = driverInfo[OPT_VOLUME_CREATED_TIME]
+	}
+	return writeResponseOutput(w, resp)
+}
+
+func (s *daemon) validateVolumeRestore(request *api.VolumeCreateRequest, names map[string]bool) error {
+	if request.Name == "" {
+		return fmt.Errorf("Volume name is required for restoring backup %v", request.BackupURL)
+	}
+	if err := util.CheckName(request.Name); err != nil {
+		return err
+	}
+	if names[request.Name] {
+		return fmt.Errorf("Volume %v is specified more than once", request.Name)
+	}
+	if request.BackupURL == "" {
+		return fmt.Errorf("Backup is required for restoring volume %v", request.Name)
+	}
+	exists, err := s.volumeExists(request.Name)
+	if err != nil {
+		return fmt.Errorf("Error occurred while checking if volume %v exists: %v", request.Name, err)
+	}
+	if exists {
+		return fmt.Errorf("Volume %v already exists", request.Name)
+	}
+
+	if request.DriverName == "" {
+		request.DriverName = s.DefaultDriver
+	}
+	if _, err := s.getDriver(request.DriverName); err != nil {
+		return err
+	}
+	if err := s.checkDriverHealth(request.DriverName); err != nil {
+		return err
+	}
+	if request.EncryptionKey != "" {
+		if err := util.CheckEncryptionKey(request.EncryptionKey); err != nil {
+			return err
+		}
+		if err := util.CheckEncryptionKeySource(); err != nil {
+			return err
+		}
+		if err := s.checkCapability(request.DriverName, CAPABILITY_ENCRYPTION); err != nil {
+			return err
+		}
+	}
+	return s.checkCapability(request.DriverName, CAPABILITY_BACKUP)
+}
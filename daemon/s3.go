@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/s3"
+	"github.com/rancher/convoy/util"
+)
+
+// initS3Encryption applies encryption options of daemon to all the S3
+// destinations
+func initS3Encryption(c *cli.Context) error {
+	encryption := s3.Encryption{
+		Mode:     c.String("s3-sse"),
+		KMSKeyID: c.String("s3-sse-kms-key-id"),
+		ACL:      c.String("s3-acl"),
+	}
+	if spec := c.String("s3-sse-customer-key"); spec != "" {
+		encoded, err := getSecret(spec, "S3 customer key")
+		if err != nil {
+			return err
+		}
+		if encryption.CustomerKey, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return fmt.Errorf("Invalid S3 customer key from %v, should be base64 encoded: %v", spec, err)
+		}
+	}
+	return s3.SetEncryption(encryption)
+}
+
+// initS3ObjectLock applies Object Lock options of daemon to all the S3
+// destinations
+func initS3ObjectLock(c *cli.Context) error {
+	lock := s3.ObjectLock{
+		Mode: c.String("s3-object-lock"),
+	}
+	if period := c.String("s3-object-lock-period"); period != "" {
+		var err error
+		if lock.Period, err = util.ParseDuration(period); err != nil {
+			return fmt.Errorf("Invalid S3 object lock period: %v", err)
+		}
+	}
+	return s3.SetObjectLock(lock)
+}
+
+// initS3StorageClass applies storage classes of data in S3 destinations, each
+// specified as "<url>=<class>"
+func initS3StorageClass(c *cli.Context) error {
+	for _, spec := range c.StringSlice("s3-storage-class") {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid S3 storage class %v, should be <url>=<class>", spec)
+		}
+		destURL := strings.TrimSpace(parts[0])
+		if err := s3.SetStorageClass(destURL, strings.TrimSpace(parts[1])); err != nil {
+			return fmt.Errorf("Failed to set S3 storage class of %v: %v", destURL, err)
+		}
+	}
+	return nil
+}
+
+// initS3Endpoint applies the S3 compatible endpoint and TLS options of daemon
+// to all the S3 destinations
+func initS3Endpoint(c *cli.Context) error {
+	return s3.SetEndpoint(s3.Endpoint{
+		URL:                c.String("s3-endpoint"),
+		CACert:             c.String("s3-ca-cert"),
+		ClientCert:         c.String("s3-client-cert"),
+		ClientKey:          c.String("s3-client-key"),
+		InsecureSkipVerify: c.Bool("s3-insecure-skip-verify"),
+	})
+}
+
+// initS3Upload applies upload options of daemon to all the S3 destinations
+func initS3Upload(c *cli.Context) error {
+	partSize, err := util.ParseSize(c.String("s3-part-size"))
+	if err != nil {
+		return fmt.Errorf("Invalid S3 part size: %v", err)
+	}
+	return s3.SetUploadOptions(s3.UploadOptions{
+		PartSize:    partSize,
+		Concurrency: c.Int("s3-upload-concurrency"),
+	})
+}
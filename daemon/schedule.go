@@ -0,0 +1,611 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	SCHEDULE_DIR = "schedules"
+
+	// Due schedules are checked at this interval, which is also the minimal
+	// interval of a schedule
+	SCHEDULE_CHECK_INTERVAL = time.Minute
+
+	// Missed runs of cron schedules are counted up to it
+	MAX_MISSED_CRON_RUNS = 10000
+
+	// Schedules are run by daemon itself, or only by "schedule run" from
+	// an external scheduler, e.g. systemd timers or Kubernetes CronJobs
+	// exported by "schedule export"
+	SCHEDULER_INTERNAL = "internal"
+	SCHEDULER_EXTERNAL = "external"
+)
+
+/*
+volumeSchedule takes a snapshot of the volume every Interval, or at times
+matching Cron, and backs it up to DestURL if specified. Next run is due at
+Interval after LastRun, or the first time matching Cron after it, counting
+from CreatedTime if it has never run.
+*/
+type volumeSchedule struct {
+	Name          string
+	Interval      string
+	Cron          string
+	DestURL       string
+	CreatedTime   string
+	LastRun       string
+	LastSnapshot  string
+	LastBackupURL string
+	LastError     string
+	Retention     string
+
+	root string
+}
+
+func (sc *volumeSchedule) ConfigFile() (string, error) {
+	if sc.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if sc.root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty schedule root")
+	}
+	return filepath.Join(sc.root, SCHEDULE_DIR, VOLUME_CFG_PREFIX+sc.Name+CFG_POSTFIX), nil
+}
+
+func (sc *volumeSchedule) lastRun() time.Time {
+	last := parseTime(sc.LastRun)
+	if last.IsZero() {
+		last = parseTime(sc.CreatedTime)
+	}
+	return last
+}
+
+// nextRun returns when the schedule is due next, without jitter. Cron
+// expressions are evaluated in local time of the daemon.
+func (sc *volumeSchedule) nextRun() time.Time {
+	if sc.Cron != "" {
+		cron, err := util.ParseCron(sc.Cron)
+		if err != nil {
+			return time.Time{}
+		}
+		return cron.Next(sc.lastRun().Local())
+	}
+	interval, err := util.ParseDuration(sc.Interval)
+	if err != nil {
+		return time.Time{}
+	}
+	return sc.lastRun().Add(interval)
+}
+
+// dueTime returns when the schedule would run next, delayed by a jitter
+// derived from volume name and next run, so it's stable across checks
+func (sc *volumeSchedule) dueTime(jitter time.Duration) time.Time {
+	next := sc.nextRun()
+	if jitter <= 0 || next.IsZero() {
+		return next
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v@%v", sc.Name, next.Unix())
+	return next.Add(time.Duration(h.Sum64() % uint64(jitter)))
+}
+
+// missedRuns returns how many runs have passed since next run was due
+func (sc *volumeSchedule) missedRuns(now time.Time) int64 {
+	next := sc.nextRun()
+	if next.IsZero() || !now.After(next) {
+		return 0
+	}
+	if sc.Cron != "" {
+		cron, err := util.ParseCron(sc.Cron)
+		if err != nil {
+			return 0
+		}
+		count := int64(0)
+		for t := next; !t.IsZero() && !t.After(now) && count < MAX_MISSED_CRON_RUNS; t = cron.Next(t) {
+			count++
+		}
+		return count
+	}
+	interval, err := util.ParseDuration(sc.Interval)
+	if err != nil || interval <= 0 {
+		return 0
+	}
+	return 1 + int64(now.Sub(next)/interval)
+}
+
+func (s *daemon) loadVolumeSchedule(volumeName string) (*volumeSchedule, error) {
+	schedule := &volumeSchedule{
+		Name: volumeName,
+		root: s.Root,
+	}
+	exists, err := util.ObjectExists(schedule)
+	if err != nil || !exists {
+		return nil, err
+	}
+	if err := util.ObjectLoad(schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *daemon) listVolumeSchedules() ([]*volumeSchedule, error) {
+	s.scheduleMutex.Lock()
+	defer s.scheduleMutex.Unlock()
+
+	volumeNames, err := util.ListConfigIDs(filepath.Join(s.Root, SCHEDULE_DIR), VOLUME_CFG_PREFIX, CFG_POSTFIX)
+	if err != nil {
+		return nil, err
+	}
+	schedules := []*volumeSchedule{}
+	for _, volumeName := range volumeNames {
+		schedule, err := s.loadVolumeSchedule(volumeName)
+		if err != nil {
+			return nil, err
+		}
+		if schedule != nil {
+			schedules = append(schedules, schedule)
+		}
+	}
+	return schedules, nil
+}
+
+func (s *daemon) updateVolumeSchedule(volumeName string, update func(*volumeSchedule)) error {
+	s.scheduleMutex.Lock()
+	defer s.scheduleMutex.Unlock()
+
+	schedule, err := s.loadVolumeSchedule(volumeName)
+	if err != nil {
+		return err
+	}
+	if schedule == nil {
+		return fmt.Errorf("Schedule of volume %v doesn't exist", volumeName)
+	}
+	update(schedule)
+	return util.ObjectSave(schedule)
+}
+
+func (s *daemon) deleteVolumeSchedule(volumeName string) {
+	s.scheduleMutex.Lock()
+	defer s.scheduleMutex.Unlock()
+
+	schedule := &volumeSchedule{
+		Name: volumeName,
+		root: s.Root,
+	}
+	if exists, err := util.ObjectExists(schedule); err != nil || !exists {
+		return
+	}
+	if err := util.ObjectDelete(schedule); err != nil {
+		log.Warnf("Failed to delete schedule of volume %v: %v", volumeName, err)
+	}
+}
+
+// beginScheduleRun marks the schedule running, so it won't be run again
+// before the run completes
+func (s *daemon) beginScheduleRun(volumeName string) error {
+	s.scheduleRunMutex.Lock()
+	defer s.scheduleRunMutex.Unlock()
+
+	if s.schedulesRunning == nil {
+		s.schedulesRunning = make(map[string]bool)
+	}
+	if s.schedulesRunning[volumeName] {
+		return fmt.Errorf("Schedule of volume %v is already running", volumeName)
+	}
+	s.schedulesRunning[volumeName] = true
+	return nil
+}
+
+func (s *daemon) endScheduleRun(volumeName string) {
+	s.scheduleRunMutex.Lock()
+	defer s.scheduleRunMutex.Unlock()
+
+	delete(s.schedulesRunning, volumeName)
+}
+
+func (s *daemon) isScheduleRunning(volumeName string) bool {
+	s.scheduleRunMutex.Lock()
+	defer s.scheduleRunMutex.Unlock()
+
+	return s.schedulesRunning[volumeName]
+}
+
+// isScheduleCatchingUp tells whether the catch-up run of the schedule hasn't
+// started yet, so regular checks won't run it as well
+func (s *daemon) isScheduleCatchingUp(volumeName string) bool {
+	s.scheduleRunMutex.Lock()
+	defer s.scheduleRunMutex.Unlock()
+
+	return s.schedulesCatchUps[volumeName]
+}
+
+func (s *daemon) setScheduleCatchingUp(volumeName string, catchingUp bool) {
+	s.scheduleRunMutex.Lock()
+	defer s.scheduleRunMutex.Unlock()
+
+	if s.schedulesCatchUps == nil {
+		s.schedulesCatchUps = make(map[string]bool)
+	}
+	if catchingUp {
+		s.schedulesCatchUps[volumeName] = true
+	} else {
+		delete(s.schedulesCatchUps, volumeName)
+	}
+}
+
+// runSchedule creates a snapshot of the volume, then backs it up if the
+// schedule has a destination. Result is recorded in the schedule. The run is
+// traced as part of the trace of ctx if any
+func (s *daemon) runSchedule(ctx context.Context, schedule *volumeSchedule) error {
+	if err := s.beginScheduleRun(schedule.Name); err != nil {
+		log.Debugf("Skip schedule of volume %v: %v", schedule.Name, err)
+		return err
+	}
+	defer s.endScheduleRun(schedule.Name)
+
+	// Volume won't be deleted between the snapshot and its backup
+	if err := s.beginVolumeOperation(schedule.Name); err != nil {
+		log.Debugf("Skip schedule of volume %v: %v", schedule.Name, err)
+		return err
+	}
+	defer s.endVolumeOperation(schedule.Name)
+
+	ctx, span := util.StartSpan(ctx, "schedule")
+	span.SetAttribute(TRACE_ATTR_VOLUME, schedule.Name)
+	backupURL := ""
+	snapshotName, runErr := s.processSnapshotCreate(ctx, &api.SnapshotCreateRequest{
+		VolumeName: schedule.Name,
+	})
+	if runErr == nil && schedule.DestURL != "" {
+		backupURL, runErr = s.processBackupCreate(ctx, snapshotName, schedule.DestURL, nil)
+	}
+	span.End(runErr)
+	if runErr != nil {
+		log.Warnf("Failed to run schedule of volume %v: %v", schedule.Name, runErr)
+	} else if backupURL != "" {
+		s.pruneScheduledBackups(schedule)
+	}
+	if err := s.updateVolumeSchedule(schedule.Name, func(sc *volumeSchedule) {
+		sc.LastRun = util.Now()
+		sc.LastError = ""
+		if runErr != nil {
+			sc.LastError = runErr.Error()
+			return
+		}
+		sc.LastSnapshot = snapshotName
+		sc.LastBackupURL = backupURL
+	}); err != nil {
+		log.Warnf("Failed to update schedule of volume %v: %v", schedule.Name, err)
+	}
+	return runErr
+}
+
+// pruneScheduledBackups prunes backups in destination of the schedule if
+// retention applies. Failures are only logged, backup has been made anyway.
+func (s *daemon) pruneScheduledBackups(schedule *volumeSchedule) {
+	if _, err := objectstore.GetObjectStoreDriver(schedule.DestURL); err != nil {
+		// Backups out of objectstore, e.g. EBS snapshots
+		return
+	}
+	policy, err := s.getBackupRetention(schedule.DestURL, schedule.Name)
+	if err == nil && policy != nil {
+		_, err = s.pruneVolumeBackups(schedule.DestURL, schedule.Name, policy, false)
+	}
+	if err != nil {
+		log.Warnf("Failed to prune backups of volume %v after scheduled backup: %v", schedule.Name, err)
+	}
+}
+
+type schedulesByNextRun []*volumeSchedule
+
+func (sc schedulesByNextRun) Len() int      { return len(sc) }
+func (sc schedulesByNextRun) Swap(i, j int) { sc[i], sc[j] = sc[j], sc[i] }
+func (sc schedulesByNextRun) Less(i, j int) bool {
+	return sc[i].nextRun().Before(sc[j].nextRun())
+}
+
+/*
+runDueSchedules starts runs of schedules due, most overdue first, without
+waiting for them to complete. At most scheduleSlots of them run at once, the
+rest are left for later checks. Schedules still running or waiting for
+their catch-up runs are skipped.
+*/
+func (s *daemon) runDueSchedules() {
+	// Schedules due in maintenance run once it ends
+	if s.inMaintenance() {
+		return
+	}
+	schedules, err := s.listVolumeSchedules()
+	if err != nil {
+		log.Warnf("Failed to list schedules: %v", err)
+		return
+	}
+	sort.Sort(schedulesByNextRun(schedules))
+	now := time.Now()
+	for _, schedule := range schedules {
+		due := schedule.dueTime(s.getScheduleJitter())
+		if due.IsZero() || due.After(now) || s.isScheduleRunning(schedule.Name) || s.isScheduleCatchingUp(schedule.Name) {
+			continue
+		}
+		select {
+		case s.scheduleSlots <- struct{}{}:
+		default:
+			log.Debugf("All %v schedule slots are busy, schedule of volume %v would wait", cap(s.scheduleSlots), schedule.Name)
+			return
+		}
+		go func(schedule *volumeSchedule) {
+			defer func() { <-s.scheduleSlots }()
+			s.runSchedule(context.Background(), schedule)
+		}(schedule)
+	}
+}
+
+/*
+catchUpSchedules runs once at startup for schedules whose window has passed
+while daemon was down. Each affected volume gets one catch-up run regardless
+of how many runs were missed, most overdue first, and stagger apart so a host
+coming back online won't snapshot and upload every volume at once. Runs are
+started by timers, so regular checks of other schedules don't wait for them.
+*/
+func (s *daemon) catchUpSchedules(stagger time.Duration) {
+	// Left for the regular checks after maintenance
+	if s.inMaintenance() {
+		return
+	}
+	schedules, err := s.listVolumeSchedules()
+	if err != nil {
+		log.Warnf("Failed to list schedules: %v", err)
+		return
+	}
+	sort.Sort(schedulesByNextRun(schedules))
+	now := time.Now()
+	missed := []*volumeSchedule{}
+	for _, schedule := range schedules {
+		// Runs due within a check interval are left for the regular check
+		if schedule.nextRun().Add(SCHEDULE_CHECK_INTERVAL).After(now) {
+			continue
+		}
+		count := schedule.missedRuns(now)
+		detail := fmt.Sprintf("%v run(s) missed since %v", count, schedule.nextRun().Format(time.RubyDate))
+		log.Warnf("Schedule of volume %v: %v, catching up", schedule.Name, detail)
+		s.recordEvent(schedule.Name, LOG_OBJECT_SCHEDULE, LOG_EVENT_MISSED, "", detail)
+		missed = append(missed, schedule)
+	}
+	for i, schedule := range missed {
+		schedule := schedule
+		s.setScheduleCatchingUp(schedule.Name, true)
+		time.AfterFunc(time.Duration(i)*stagger, func() {
+			s.setScheduleCatchingUp(schedule.Name, false)
+			s.runSchedule(context.Background(), schedule)
+		})
+	}
+}
+
+func (s *daemon) getScheduleJitter() time.Duration {
+	s.scheduleRunMutex.Lock()
+	defer s.scheduleRunMutex.Unlock()
+
+	return s.scheduleJitter
+}
+
+func (s *daemon) setScheduleJitter(jitter time.Duration) {
+	s.scheduleRunMutex.Lock()
+	defer s.scheduleRunMutex.Unlock()
+
+	s.scheduleJitter = jitter
+}
+
+func (s *daemon) startScheduler(mode string, catchUpStagger, jitter time.Duration, concurrency int) error {
+	if concurrency < 1 {
+		return fmt.Errorf("Invalid schedule concurrency %v, must be positive", concurrency)
+	}
+	if jitter < 0 {
+		return fmt.Errorf("Invalid schedule jitter %v, cannot be negative", jitter)
+	}
+	s.setScheduleJitter(jitter)
+	s.scheduleSlots = make(chan struct{}, concurrency)
+	switch mode {
+	case SCHEDULER_INTERNAL:
+	case SCHEDULER_EXTERNAL:
+		log.Infof("Schedules would only be run by external scheduler through schedule run")
+		return nil
+	default:
+		return fmt.Errorf("Invalid scheduler %v, should be %v or %v", mode, SCHEDULER_INTERNAL, SCHEDULER_EXTERNAL)
+	}
+	go func() {
+		s.catchUpSchedules(catchUpStagger)
+		for {
+			time.Sleep(SCHEDULE_CHECK_INTERVAL)
+			s.runDueSchedules()
+		}
+	}()
+	return nil
+}
+
+func (s *daemon) scheduleResponse(schedule *volumeSchedule) api.ScheduleResponse {
+	resp := api.ScheduleResponse{
+		VolumeName:    schedule.Name,
+		Interval:      schedule.Interval,
+		Cron:          schedule.Cron,
+		Running:       s.isScheduleRunning(schedule.Name),
+		URL:           schedule.DestURL,
+		CreatedTime:   schedule.CreatedTime,
+		LastRun:       schedule.LastRun,
+		LastSnapshot:  schedule.LastSnapshot,
+		LastBackupURL: schedule.LastBackupURL,
+		LastError:     schedule.LastError,
+		Retention:     schedule.Retention,
+	}
+	if next := schedule.dueTime(s.getScheduleJitter()); !next.IsZero() {
+		resp.NextRun = next.Format(time.RubyDate)
+	}
+	return resp
+}
+
+func (s *daemon) doScheduleSet(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.ScheduleSetRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+	volumeName := request.VolumeName
+	if err := util.CheckName(volumeName); err != nil {
+		return err
+	}
+	volume := s.getVolume(volumeName)
+	if volume == nil {
+		return fmt.Errorf("volume %v doesn't exist", volumeName)
+	}
+	if (request.Interval == "") == (request.Cron == "") {
+		return fmt.Errorf("Either interval or cron of schedule should be specified")
+	}
+	detail := "every " + request.Interval
+	if request.Cron != "" {
+		cron, err := util.ParseCron(request.Cron)
+		if err != nil {
+			return err
+		}
+		if cron.Next(time.Now()).IsZero() {
+			return fmt.Errorf("Cron expression %q never matches", request.Cron)
+		}
+		detail = "cron " + request.Cron
+	} else {
+		interval, err := util.ParseDuration(request.Interval)
+		if err != nil {
+			return err
+		}
+		if interval < SCHEDULE_CHECK_INTERVAL {
+			return fmt.Errorf("Interval of schedule cannot be less than %v", SCHEDULE_CHECK_INTERVAL)
+		}
+	}
+	if err := s.checkCapability(volume.DriverName, CAPABILITY_SNAPSHOT); err != nil {
+		return err
+	}
+	if request.URL != "" {
+		if err := s.checkCapability(volume.DriverName, CAPABILITY_BACKUP); err != nil {
+			return err
+		}
+	}
+	if request.Retention != "" {
+		if _, err := objectstore.GetObjectStoreDriver(request.URL); err != nil {
+			return fmt.Errorf("Retention of schedule needs destination in objectstore: %v", err)
+		}
+		if _, err := objectstore.ParseRetentionPolicy(request.Retention); err != nil {
+			return err
+		}
+	}
+
+	s.scheduleMutex.Lock()
+	defer s.scheduleMutex.Unlock()
+
+	if err := util.MkdirIfNotExists(filepath.Join(s.Root, SCHEDULE_DIR)); err != nil {
+		return err
+	}
+	schedule, err := s.loadVolumeSchedule(volumeName)
+	if err != nil {
+		return err
+	}
+	if schedule == nil {
+		schedule = &volumeSchedule{
+			Name:        volumeName,
+			CreatedTime: util.Now(),
+			root:        s.Root,
+		}
+	}
+	schedule.Interval = request.Interval
+	schedule.Cron = request.Cron
+	schedule.DestURL = request.URL
+	schedule.Retention = request.Retention
+	if err := util.ObjectSave(schedule); err != nil {
+		return err
+	}
+	s.recordEvent(volumeName, LOG_OBJECT_SCHEDULE, LOG_EVENT_CREATE, "", detail)
+	return writeResponseOutput(w, s.scheduleResponse(schedule))
+}
+
+func (s *daemon) doScheduleList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	schedules, err := s.listVolumeSchedules()
+	if err != nil {
+		return err
+	}
+	resp := make(map[string]api.ScheduleResponse)
+	for _, schedule := range schedules {
+		resp[schedule.Name] = s.scheduleResponse(schedule)
+	}
+	return writeResponseOutput(w, resp)
+}
+
+/*
+doScheduleRun runs the schedule of the volume right away, regardless of when
+it's due, and fails if the snapshot or backup fails, so external schedulers
+can tell. Result is recorded in the schedule the same as scheduled runs.
+*/
+func (s *daemon) doScheduleRun(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.ScheduleRunRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if err := util.CheckName(request.VolumeName); err != nil {
+		return err
+	}
+	s.scheduleMutex.Lock()
+	schedule, err := s.loadVolumeSchedule(request.VolumeName)
+	s.scheduleMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if schedule == nil {
+		return fmt.Errorf("Schedule of volume %v doesn't exist", request.VolumeName)
+	}
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+	if err := s.runSchedule(r.Context(), schedule); err != nil {
+		return fmt.Errorf("Failed to run schedule of volume %v: %v", request.VolumeName, err)
+	}
+
+	s.scheduleMutex.Lock()
+	schedule, err = s.loadVolumeSchedule(request.VolumeName)
+	s.scheduleMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if schedule == nil {
+		return fmt.Errorf("Schedule of volume %v has been deleted", request.VolumeName)
+	}
+	return writeResponseOutput(w, s.scheduleResponse(schedule))
+}
+
+func (s *daemon) doScheduleDelete(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.ScheduleDeleteRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if err := util.CheckName(request.VolumeName); err != nil {
+		return err
+	}
+	s.scheduleMutex.Lock()
+	schedule, err := s.loadVolumeSchedule(request.VolumeName)
+	s.scheduleMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if schedule == nil {
+		return fmt.Errorf("Schedule of volume %v doesn't exist", request.VolumeName)
+	}
+	s.deleteVolumeSchedule(request.VolumeName)
+	s.recordEvent(request.VolumeName, LOG_OBJECT_SCHEDULE, LOG_EVENT_DELETE, "", "")
+	return nil
+}
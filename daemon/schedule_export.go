@@ -0,0 +1,246 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	SCHEDULE_EXPORT_SYSTEMD = "systemd"
+	SCHEDULE_EXPORT_CRONJOB = "cronjob"
+
+	SCHEDULE_UNIT_PREFIX = "convoy-schedule-"
+
+	// CronJob names are limited so names of Jobs created from them fit
+	CRONJOB_NAME_MAX_LENGTH = 52
+)
+
+var (
+	invalidDNSLabelChars = regexp.MustCompile("[^a-z0-9-]+")
+)
+
+func scheduleHash(volumeName string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(volumeName))
+	return h.Sum32()
+}
+
+/*
+cronSchedule converts interval of the schedule to a cron expression. Cron has
+no notion of arbitrary intervals, so only those dividing an hour or a day
+evenly, a day and a week are accepted. Minute of the runs is spread by volume
+name, so volumes on the same schedule won't all start at once.
+*/
+func cronSchedule(volumeName, interval string) (string, error) {
+	d, err := util.ParseDuration(interval)
+	if err != nil {
+		return "", err
+	}
+	offset := scheduleHash(volumeName)
+	minutes := int64(d / time.Minute)
+	hours := int64(d / time.Hour)
+	switch {
+	case d%time.Minute != 0:
+	case d < time.Hour && 60%minutes == 0:
+		return fmt.Sprintf("%d-59/%d * * * *", int64(offset)%minutes, minutes), nil
+	case d%time.Hour == 0 && hours < 24 && 24%hours == 0:
+		return fmt.Sprintf("%d */%d * * *", offset%60, hours), nil
+	case d == 24*time.Hour:
+		return fmt.Sprintf("%d 0 * * *", offset%60), nil
+	case d == 7*24*time.Hour:
+		return fmt.Sprintf("%d 0 * * 0", offset%60), nil
+	}
+	return "", fmt.Errorf("Interval %v of schedule of volume %v cannot be expressed in cron, it should divide an hour or a day, or be 1d or 7d",
+		interval, volumeName)
+}
+
+// cronJobName returns a DNS-1123 name for the CronJob of the volume. Names
+// changed to fit get a hash of the volume name, so they won't collide
+func cronJobName(volumeName string) string {
+	name := SCHEDULE_UNIT_PREFIX + volumeName
+	sanitized := strings.Trim(invalidDNSLabelChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if sanitized == name && len(name) <= CRONJOB_NAME_MAX_LENGTH {
+		return name
+	}
+	suffix := fmt.Sprintf("-%08x", scheduleHash(volumeName))
+	if len(sanitized) > CRONJOB_NAME_MAX_LENGTH-len(suffix) {
+		sanitized = strings.TrimRight(sanitized[:CRONJOB_NAME_MAX_LENGTH-len(suffix)], "-")
+	}
+	return sanitized + suffix
+}
+
+func exportSystemdUnits(schedule *volumeSchedule, request *api.ScheduleExportRequest) ([]api.ScheduleExportFile, error) {
+	if schedule.Cron != "" {
+		return nil, fmt.Errorf("Cron schedule of volume %v cannot be exported as systemd timer, use cronjob or an interval", schedule.Name)
+	}
+	interval, err := util.ParseDuration(schedule.Interval)
+	if err != nil {
+		return nil, err
+	}
+	unit := SCHEDULE_UNIT_PREFIX + schedule.Name
+
+	service := &bytes.Buffer{}
+	fmt.Fprintf(service, "[Unit]\n")
+	fmt.Fprintf(service, "Description=Convoy schedule of volume %v\n", schedule.Name)
+	fmt.Fprintf(service, "\n[Service]\n")
+	fmt.Fprintf(service, "Type=oneshot\n")
+	fmt.Fprintf(service, "ExecStart=%v -s %v schedule run %v\n", request.Binary, request.Socket, schedule.Name)
+
+	timer := &bytes.Buffer{}
+	fmt.Fprintf(timer, "[Unit]\n")
+	fmt.Fprintf(timer, "Description=Convoy schedule of volume %v, every %v\n", schedule.Name, schedule.Interval)
+	fmt.Fprintf(timer, "\n[Timer]\n")
+	fmt.Fprintf(timer, "OnActiveSec=%d\n", int64(interval/time.Second))
+	fmt.Fprintf(timer, "OnUnitActiveSec=%d\n", int64(interval/time.Second))
+	fmt.Fprintf(timer, "\n[Install]\n")
+	fmt.Fprintf(timer, "WantedBy=timers.target\n")
+
+	return []api.ScheduleExportFile{
+		{Name: unit + ".service", Content: service.String()},
+		{Name: unit + ".timer", Content: timer.String()},
+	}, nil
+}
+
+func exportCronJob(schedule *volumeSchedule, request *api.ScheduleExportRequest) ([]api.ScheduleExportFile, error) {
+	cron := schedule.Cron
+	if cron == "" {
+		var err error
+		if cron, err = cronSchedule(schedule.Name, schedule.Interval); err != nil {
+			return nil, err
+		}
+	}
+	name := cronJobName(schedule.Name)
+	q := strconv.Quote
+	command := []string{}
+	for _, arg := range []string{request.Binary, "-s", request.Socket, "schedule", "run", schedule.Name} {
+		command = append(command, q(arg))
+	}
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "---\n")
+	fmt.Fprintf(b, "apiVersion: batch/v1\n")
+	fmt.Fprintf(b, "kind: CronJob\n")
+	fmt.Fprintf(b, "metadata:\n")
+	fmt.Fprintf(b, "  name: %v\n", name)
+	if request.Namespace != "" {
+		fmt.Fprintf(b, "  namespace: %v\n", q(request.Namespace))
+	}
+	fmt.Fprintf(b, "  annotations:\n")
+	fmt.Fprintf(b, "    convoy.rancher.io/volume: %v\n", q(schedule.Name))
+	if schedule.Interval != "" {
+		fmt.Fprintf(b, "    convoy.rancher.io/interval: %v\n", q(schedule.Interval))
+	}
+	fmt.Fprintf(b, "spec:\n")
+	fmt.Fprintf(b, "  schedule: %v\n", q(cron))
+	fmt.Fprintf(b, "  concurrencyPolicy: Forbid\n")
+	fmt.Fprintf(b, "  jobTemplate:\n")
+	fmt.Fprintf(b, "    spec:\n")
+	fmt.Fprintf(b, "      backoffLimit: 0\n")
+	fmt.Fprintf(b, "      template:\n")
+	fmt.Fprintf(b, "        spec:\n")
+	fmt.Fprintf(b, "          nodeName: %v\n", q(request.Node))
+	fmt.Fprintf(b, "          restartPolicy: Never\n")
+	fmt.Fprintf(b, "          containers:\n")
+	fmt.Fprintf(b, "          - name: convoy\n")
+	fmt.Fprintf(b, "            image: %v\n", q(request.Image))
+	fmt.Fprintf(b, "            command: [%v]\n", strings.Join(command, ", "))
+	fmt.Fprintf(b, "            volumeMounts:\n")
+	fmt.Fprintf(b, "            - name: convoy-socket\n")
+	fmt.Fprintf(b, "              mountPath: %v\n", q(filepath.Dir(request.Socket)))
+	fmt.Fprintf(b, "          volumes:\n")
+	fmt.Fprintf(b, "          - name: convoy-socket\n")
+	fmt.Fprintf(b, "            hostPath:\n")
+	fmt.Fprintf(b, "              path: %v\n", q(filepath.Dir(request.Socket)))
+
+	return []api.ScheduleExportFile{
+		{Name: name + ".yaml", Content: b.String()},
+	}, nil
+}
+
+/*
+doScheduleExport generates systemd timers or Kubernetes CronJobs which run
+schedules through "schedule run", so sites with a central scheduler can keep
+schedules of convoy as the source of truth. Daemon should be started with
+"--scheduler external" afterwards, otherwise schedules would run twice.
+*/
+func (s *daemon) doScheduleExport(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.ScheduleExportRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(request.Socket) {
+		return fmt.Errorf("Socket %v should be an absolute path", request.Socket)
+	}
+	if request.Binary == "" {
+		return fmt.Errorf("Binary of convoy should be specified")
+	}
+	var export func(*volumeSchedule, *api.ScheduleExportRequest) ([]api.ScheduleExportFile, error)
+	switch request.Format {
+	case SCHEDULE_EXPORT_SYSTEMD:
+		if !filepath.IsAbs(request.Binary) {
+			return fmt.Errorf("Binary %v should be an absolute path for systemd", request.Binary)
+		}
+		export = exportSystemdUnits
+	case SCHEDULE_EXPORT_CRONJOB:
+		if request.Image == "" {
+			return fmt.Errorf("Image containing convoy should be specified for cronjob")
+		}
+		if request.Node == "" {
+			node, err := os.Hostname()
+			if err != nil {
+				return err
+			}
+			request.Node = node
+		}
+		export = exportCronJob
+	default:
+		return fmt.Errorf("Invalid export format %v, should be %v or %v",
+			request.Format, SCHEDULE_EXPORT_SYSTEMD, SCHEDULE_EXPORT_CRONJOB)
+	}
+
+	schedules := []*volumeSchedule{}
+	if len(request.VolumeNames) == 0 {
+		var err error
+		if schedules, err = s.listVolumeSchedules(); err != nil {
+			return err
+		}
+	}
+	for _, volumeName := range request.VolumeNames {
+		if err := util.CheckName(volumeName); err != nil {
+			return err
+		}
+		s.scheduleMutex.Lock()
+		schedule, err := s.loadVolumeSchedule(volumeName)
+		s.scheduleMutex.Unlock()
+		if err != nil {
+			return err
+		}
+		if schedule == nil {
+			return fmt.Errorf("Schedule of volume %v doesn't exist", volumeName)
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	resp := api.ScheduleExportResponse{
+		Files: []api.ScheduleExportFile{},
+	}
+	for _, schedule := range schedules {
+		files, err := export(schedule, request)
+		if err != nil {
+			return err
+		}
+		resp.Files = append(resp.Files, files...)
+	}
+	return writeResponseOutput(w, resp)
+}
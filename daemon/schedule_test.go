@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rancher/convoy/api"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) getSchedule(c *C, d *daemon, volumeName string) *volumeSchedule {
+	schedules, err := d.listVolumeSchedules()
+	c.Assert(err, IsNil)
+	for _, schedule := range schedules {
+		if schedule.Name == volumeName {
+			return schedule
+		}
+	}
+	c.Fatalf("Cannot find schedule of volume %v", volumeName)
+	return nil
+}
+
+func (s *TestSuite) TestCatchUpSchedulesStaggered(c *C) {
+	d := newTestDaemon(c)
+	d.scheduleSlots = make(chan struct{}, 2)
+	for i, name := range []string{"vol1", "vol2"} {
+		code, body := d.call(c, "POST", "/volumes/create", &api.VolumeCreateRequest{Name: name}, nil)
+		c.Assert(code, Equals, http.StatusOK, Commentf(body))
+		code, body = d.call(c, "POST", "/schedules/set", &api.ScheduleSetRequest{VolumeName: name, Interval: "1h"}, nil)
+		c.Assert(code, Equals, http.StatusOK, Commentf(body))
+		// vol1 is the most overdue
+		lastRun := time.Now().Add(-time.Duration(4-i) * time.Hour).Format(time.RubyDate)
+		c.Assert(d.updateVolumeSchedule(name, func(sc *volumeSchedule) {
+			sc.LastRun = lastRun
+		}), IsNil)
+	}
+	missedRun := s.getSchedule(c, d, "vol2").LastRun
+
+	// Catch-up runs don't hold up the scheduler while waiting for their turns
+	start := time.Now()
+	d.catchUpSchedules(time.Hour)
+	c.Assert(time.Since(start) < time.Minute, Equals, true)
+	c.Assert(d.isScheduleCatchingUp("vol2"), Equals, true)
+
+	for i := 0; i < 100 && s.getSchedule(c, d, "vol1").LastSnapshot == ""; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	vol1 := s.getSchedule(c, d, "vol1")
+	c.Assert(vol1.LastSnapshot, Not(Equals), "")
+	c.Assert(vol1.LastError, Equals, "")
+
+	// Regular checks leave vol2 to its catch-up run
+	d.runDueSchedules()
+	c.Assert(d.isScheduleRunning("vol2"), Equals, false)
+	c.Assert(s.getSchedule(c, d, "vol2").LastRun, Equals, missedRun)
+}
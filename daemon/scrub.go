@@ -0,0 +1,217 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	SCRUB_CFG = "scrub.json"
+)
+
+// scrubState is kept in root directory, so restarts of daemon won't postpone
+// scrubs
+type scrubState struct {
+	LastRun string
+
+	root string
+}
+
+func (c *scrubState) ConfigFile() (string, error) {
+	if c.root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty scrub root")
+	}
+	return filepath.Join(c.root, SCRUB_CFG), nil
+}
+
+type scrubber struct {
+	percent int
+
+	mutex    sync.Mutex
+	checked  int64
+	skipped  int64
+	missing  int64
+	corrupt  int64
+	failed   int64
+	lastRun  time.Time
+	lastPass time.Time
+}
+
+/*
+startScrubs would verify percent of the blocks of backups in destinations of
+schedules and destination groups every interval, against the checksums
+recorded in backups, so blocks lost or corrupted in objectstore are found
+before a restore needs them. Empty interval disables scrubs.
+*/
+func (s *daemon) startScrubs(interval string, percent int) error {
+	if interval == "" {
+		return nil
+	}
+	scrubInterval, err := util.ParseDuration(interval)
+	if err != nil {
+		return fmt.Errorf("Invalid scrub interval: %v", err)
+	}
+	if scrubInterval < SCHEDULE_CHECK_INTERVAL {
+		return fmt.Errorf("Scrub interval cannot be less than %v", SCHEDULE_CHECK_INTERVAL)
+	}
+	if percent <= 0 || percent > 100 {
+		return fmt.Errorf("Invalid scrub percentage %v, should be between 1 and 100", percent)
+	}
+
+	state := &scrubState{
+		root: s.Root,
+	}
+	exists, err := util.ObjectExists(state)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := util.ObjectLoad(state); err != nil {
+			return err
+		}
+	}
+	s.scrubber = &scrubber{
+		percent: percent,
+	}
+	go func() {
+		for {
+			time.Sleep(SCHEDULE_CHECK_INTERVAL)
+			if s.inMaintenance() {
+				continue
+			}
+			if last := parseTime(state.LastRun); !last.IsZero() && time.Since(last) < scrubInterval {
+				continue
+			}
+			s.runScrubs()
+			state.LastRun = util.Now()
+			if err := util.ObjectSave(state); err != nil {
+				log.Warnf("Failed to save state of scrubs: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// runScrubs scrubs destinations one at a time. Destinations found unavailable
+// by probes are skipped.
+func (s *daemon) runScrubs() {
+	schedules, err := s.listVolumeSchedules()
+	if err != nil {
+		log.Warnf("Failed to list schedules for scrubbing destinations: %v", err)
+		return
+	}
+	destURLs := []string{}
+	for _, schedule := range schedules {
+		if schedule.DestURL != "" {
+			destURLs = append(destURLs, schedule.DestURL)
+		}
+	}
+	for _, destURL := range objectstore.ListDestinations(destURLs) {
+		if err := objectstore.CheckDestinationHealth(destURL, ""); err != nil {
+			log.Warnf("Skip scrubbing destination %v: %v", destURL, err)
+			continue
+		}
+		result, err := objectstore.ScrubDestination(destURL, s.scrubber.percent)
+		s.recordScrubResult(destURL, result, err)
+	}
+}
+
+// recordScrubResult logs the result, and records damages found in timelines
+// of the volumes here whose backups reference the damaged objects
+func (s *daemon) recordScrubResult(destURL string, result *objectstore.ScrubResult, err error) {
+	c := s.scrubber
+	now := time.Now()
+	c.mutex.Lock()
+	c.lastRun = now
+	if err != nil {
+		c.failed++
+	} else {
+		c.checked += int64(result.Checked)
+		c.skipped += int64(result.Skipped)
+		for _, damage := range result.Damages {
+			if damage.Missing {
+				c.missing++
+			} else {
+				c.corrupt++
+			}
+		}
+		if len(result.Damages) == 0 {
+			c.lastPass = now
+		}
+	}
+	c.mutex.Unlock()
+
+	fields := logrus.Fields{
+		LOG_FIELD_EVENT:    LOG_EVENT_VERIFY,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_DEST_URL,
+		LOG_FIELD_DEST_URL: destURL,
+	}
+	if err != nil {
+		fields[LOG_FIELD_REASON] = LOG_REASON_FAILURE
+		log.WithFields(fields).Errorf("Failed to scrub destination %v: %v", destURL, err)
+		return
+	}
+	if len(result.Damages) != 0 {
+		fields[LOG_FIELD_REASON] = LOG_REASON_FAILURE
+		log.WithFields(fields).Errorf("Scrub of destination %v found %v of %v objects damaged",
+			destURL, len(result.Damages), result.Checked)
+	} else {
+		fields[LOG_FIELD_REASON] = LOG_REASON_COMPLETE
+		log.WithFields(fields).Infof("Scrub of destination %v passed, %v objects verified, %v archived skipped",
+			destURL, result.Checked, result.Skipped)
+	}
+
+	for _, damage := range result.Damages {
+		detail := fmt.Sprintf("scrub found %v corrupted, referenced by %v backups: %v",
+			damage.Path, len(damage.BackupURLs), damage.Reason)
+		if damage.Missing {
+			detail = fmt.Sprintf("scrub found %v missing, referenced by %v backups",
+				damage.Path, len(damage.BackupURLs))
+		}
+		for _, volumeName := range damage.VolumeNames {
+			if s.getVolume(volumeName) == nil {
+				continue
+			}
+			s.recordEvent(volumeName, LOG_OBJECT_DEST_URL, LOG_EVENT_VERIFY, destURL, detail)
+		}
+	}
+}
+
+// writeScrubMetrics adds results of scrubs to metrics, if enabled
+func (s *daemon) writeScrubMetrics(b *bytes.Buffer) {
+	c := s.scrubber
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	b.WriteString("# HELP convoy_scrub_objects_total Objects of backups scrubbed by result.\n")
+	b.WriteString("# TYPE convoy_scrub_objects_total counter\n")
+	fmt.Fprintf(b, "convoy_scrub_objects_total{result=\"ok\"} %v\n", c.checked-c.missing-c.corrupt)
+	fmt.Fprintf(b, "convoy_scrub_objects_total{result=\"missing\"} %v\n", c.missing)
+	fmt.Fprintf(b, "convoy_scrub_objects_total{result=\"corrupt\"} %v\n", c.corrupt)
+	fmt.Fprintf(b, "convoy_scrub_objects_total{result=\"archived\"} %v\n", c.skipped)
+	b.WriteString("# HELP convoy_scrub_failures_total Scrubs of destinations failed before completing.\n")
+	b.WriteString("# TYPE convoy_scrub_failures_total counter\n")
+	fmt.Fprintf(b, "convoy_scrub_failures_total %v\n", c.failed)
+	if !c.lastRun.IsZero() {
+		b.WriteString("# HELP convoy_scrub_last_run_timestamp_seconds Time of the last scrub of a destination.\n")
+		b.WriteString("# TYPE convoy_scrub_last_run_timestamp_seconds gauge\n")
+		fmt.Fprintf(b, "convoy_scrub_last_run_timestamp_seconds %v\n", c.lastRun.Unix())
+	}
+	if !c.lastPass.IsZero() {
+		b.WriteString("# HELP convoy_scrub_last_success_timestamp_seconds Time of the last scrub finding no damage.\n")
+		b.WriteString("# TYPE convoy_scrub_last_success_timestamp_seconds gauge\n")
+		fmt.Fprintf(b, "convoy_scrub_last_success_timestamp_seconds %v\n", c.lastPass.Unix())
+	}
+}
@@ -0,0 +1,46 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	SECRET_FILE = "file"
+	SECRET_ENV  = "env"
+)
+
+// checkSecret validates specification of a secret passed to daemon, as
+// file:<path> or env:<name>, so the secret won't show up in process list
+func checkSecret(spec, what string) error {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[1] == "" || (parts[0] != SECRET_FILE && parts[0] != SECRET_ENV) {
+		return fmt.Errorf("Invalid %v %q, should be %v:<path> or %v:<name>", what, spec, SECRET_FILE, SECRET_ENV)
+	}
+	return nil
+}
+
+// getSecret reads the secret with surrounding whitespaces trimmed
+func getSecret(spec, what string) (string, error) {
+	if err := checkSecret(spec, what); err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(spec, ":", 2)
+	secret := ""
+	if parts[0] == SECRET_FILE {
+		data, err := ioutil.ReadFile(parts[1])
+		if err != nil {
+			return "", err
+		}
+		secret = string(data)
+	} else {
+		secret = os.Getenv(parts[1])
+	}
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return "", fmt.Errorf("Empty %v from %v", what, spec)
+	}
+	return secret, nil
+}
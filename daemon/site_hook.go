@@ -0,0 +1,244 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	SITE_HOOK_PHASE_PRE  = "pre"
+	SITE_HOOK_PHASE_POST = "post"
+
+	// Events waiting for post hooks, more would be dropped
+	SITE_HOOK_QUEUE_SIZE = 1000
+)
+
+/*
+siteHookEvent is what site hooks receive as JSON on stdin. Object and Event
+are the same as events in volume timeline, e.g. volume and mount, or snapshot
+and create. Options are only set for pre hooks, with the options of the
+request, e.g. MountPoint and ReadOnly of mount.
+*/
+type siteHookEvent struct {
+	Phase   string
+	Object  string
+	Event   string
+	Volume  string
+	Driver  string            `json:",omitempty"`
+	Name    string            `json:",omitempty"`
+	Detail  string            `json:",omitempty"`
+	Options map[string]string `json:",omitempty"`
+	Host    string
+	Time    string
+}
+
+/*
+siteHooks runs every executable in dir on volume lifecycle events, so sites
+can integrate CMDB updates, fencing or notifications without patching convoy.
+Unlike volume hooks, they apply to every volume, and are set up by the
+administrator of the host rather than through API.
+
+Pre hooks run before create, delete, mount and umount of a volume, and can
+abort the operation if onFailure is abort. Post hooks run after every event
+recorded in volume timeline, and in addition deletion of volumes, one event
+at a time in the order they happened, without holding up the operations.
+*/
+type siteHooks struct {
+	dir       string
+	timeout   time.Duration
+	onFailure string
+	host      string
+	queue     chan *siteHookEvent
+}
+
+func (s *daemon) initSiteHooks(dir, timeout, onFailure string) error {
+	if dir == "" {
+		return nil
+	}
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("Hooks directory %v must be an absolute path", dir)
+	}
+	hookTimeout, err := util.ParseDuration(timeout)
+	if err != nil {
+		return fmt.Errorf("Invalid hooks timeout: %v", err)
+	}
+	if hookTimeout <= 0 {
+		return fmt.Errorf("Invalid hooks timeout %v, must be positive", timeout)
+	}
+	if onFailure != HOOK_ON_FAILURE_ABORT && onFailure != HOOK_ON_FAILURE_CONTINUE {
+		return fmt.Errorf("Invalid failure policy of hooks %v, should be %v or %v",
+			onFailure, HOOK_ON_FAILURE_ABORT, HOOK_ON_FAILURE_CONTINUE)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	s.siteHooks = &siteHooks{
+		dir:       dir,
+		timeout:   hookTimeout,
+		onFailure: onFailure,
+		host:      host,
+		queue:     make(chan *siteHookEvent, SITE_HOOK_QUEUE_SIZE),
+	}
+	// Hooks are listed for every event, so they can be added or removed
+	// without restarting daemon
+	hooks, err := s.siteHooks.list()
+	if err != nil {
+		return err
+	}
+	log.Infof("Found %v site hooks in %v", len(hooks), dir)
+
+	go func() {
+		for event := range s.siteHooks.queue {
+			s.siteHooks.run(event)
+		}
+	}()
+	return nil
+}
+
+// list returns executables in the directory in lexical order, the directory
+// may not exist
+func (h *siteHooks) list() ([]string, error) {
+	files, err := ioutil.ReadDir(h.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	hooks := []string{}
+	for _, f := range files {
+		name := f.Name()
+		// Skip hidden files and backups of editors
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		path := filepath.Join(h.dir, name)
+		if err := checkHookExecutable(path); err != nil {
+			log.Debugf("Skip site hook %v: %v", path, err)
+			continue
+		}
+		hooks = append(hooks, path)
+	}
+	return hooks, nil
+}
+
+/*
+run runs every hook with event as JSON on stdin, and the basics of it in
+environment:
+
+	CONVOY_HOOK_PHASE   pre or post
+	CONVOY_HOOK_OBJECT  object of the event, e.g. volume or snapshot
+	CONVOY_HOOK_EVENT   event, e.g. create or mount
+	CONVOY_VOLUME_NAME  name of the volume
+
+Failure of a hook won't stop the following hooks, unless it's a pre hook and
+onFailure is abort.
+*/
+func (h *siteHooks) run(event *siteHookEvent) error {
+	hooks, err := h.list()
+	if err != nil {
+		log.Warnf("Failed to list site hooks in %v: %v", h.dir, err)
+		return nil
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	event.Host = h.host
+	event.Time = util.Now()
+	input, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	env := []string{
+		"CONVOY_HOOK_PHASE=" + event.Phase,
+		"CONVOY_HOOK_OBJECT=" + event.Object,
+		"CONVOY_HOOK_EVENT=" + event.Event,
+		"CONVOY_VOLUME_NAME=" + event.Volume,
+	}
+	for _, hook := range hooks {
+		log.Debugf("Running site hook %v for %v %v %v of volume %v", hook, event.Phase, event.Object, event.Event, event.Volume)
+		output, err := util.ExecuteWithInput(hook, []string{}, env, input, h.timeout)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_FAILURE,
+				LOG_FIELD_EVENT:  LOG_EVENT_HOOK,
+				LOG_FIELD_VOLUME: event.Volume,
+			}).Warnf("Site hook %v failed for %v %v %v: %v", hook, event.Phase, event.Object, event.Event, err)
+			if event.Phase == SITE_HOOK_PHASE_PRE && h.onFailure == HOOK_ON_FAILURE_ABORT {
+				return fmt.Errorf("site hook %v failed: %v", hook, err)
+			}
+			continue
+		}
+		log.Debugf("Site hook %v completed, output: %v", hook, output)
+	}
+	return nil
+}
+
+/*
+runPreSiteHooks runs site hooks before event of the volume. The operation
+should be aborted if it returns error. Failure would be recorded as an event
+of the volume, unless the volume is yet to be created.
+*/
+func (s *daemon) runPreSiteHooks(ctx context.Context, event, volumeName, driverName string, opts map[string]string) error {
+	if s.siteHooks == nil {
+		return nil
+	}
+	_, span := util.StartSpan(ctx, "site hooks pre-"+event)
+	span.SetAttribute(TRACE_ATTR_VOLUME, volumeName)
+	err := s.siteHooks.run(&siteHookEvent{
+		Phase:   SITE_HOOK_PHASE_PRE,
+		Object:  LOG_OBJECT_VOLUME,
+		Event:   event,
+		Volume:  volumeName,
+		Driver:  driverName,
+		Name:    volumeName,
+		Options: opts,
+	})
+	span.End(err)
+	if err == nil {
+		return nil
+	}
+	if event != LOG_EVENT_CREATE {
+		s.recordEvent(volumeName, LOG_OBJECT_VOLUME, LOG_EVENT_HOOK, volumeName, "pre-"+event+" site hook failed: "+err.Error())
+	}
+	return fmt.Errorf("%v of volume %v aborted, %v", strings.Title(event), volumeName, err)
+}
+
+// queueSiteHooks queues the event for post hooks. driverName would be looked
+// up if it's empty
+func (s *daemon) queueSiteHooks(volumeName, driverName, object, event, name, detail string) {
+	if s.siteHooks == nil {
+		return
+	}
+	if driverName == "" {
+		if volume := s.getVolume(volumeName); volume != nil {
+			driverName = volume.DriverName
+		}
+	}
+	select {
+	case s.siteHooks.queue <- &siteHookEvent{
+		Phase:  SITE_HOOK_PHASE_POST,
+		Object: object,
+		Event:  event,
+		Volume: volumeName,
+		Driver: driverName,
+		Name:   name,
+		Detail: detail,
+	}:
+	default:
+		log.Warnf("Too many events waiting for site hooks, drop %v %v event of volume %v", object, event, volumeName)
+	}
+}
@@ -1,8 +1,11 @@
 package daemon
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/convoy/api"
@@ -26,23 +29,73 @@ func (s *daemon) doSnapshotCreate(version string, w http.ResponseWriter, r *http
 	if err := decodeRequest(r, request); err != nil {
 		return err
 	}
+	if request.Async {
+		if err := util.CheckName(request.VolumeName); err != nil {
+			return err
+		}
+		if s.getVolume(request.VolumeName) == nil {
+			return fmt.Errorf("volume %v doesn't exist", request.VolumeName)
+		}
+		j, err := s.startJob(r.Context(), JOB_TYPE_SNAPSHOT_CREATE, request.VolumeName, func(ctx context.Context) (string, error) {
+			return s.processSnapshotCreate(ctx, request)
+		})
+		if err != nil {
+			return err
+		}
+		return writeJobResponse(w, j)
+	}
+	snapshotName, err := s.processSnapshotCreate(r.Context(), request)
+	if err != nil {
+		return err
+	}
+	if request.Verbose {
+		volume := s.getVolume(request.VolumeName)
+		driverInfo, err := s.getSnapshotDriverInfo(snapshotName, volume)
+		if err != nil {
+			return err
+		}
+		return writeResponseOutput(w, api.SnapshotResponse{
+			Name:        snapshotName,
+			VolumeName:  volume.Name,
+			CreatedTime: driverInfo[OPT_SNAPSHOT_CREATED_TIME],
+			Labels:      request.Labels,
+			DriverInfo:  driverInfo,
+		})
+	}
+	return writeStringResponse(w, snapshotName)
+}
+
+func (s *daemon) processSnapshotCreate(ctx context.Context, request *api.SnapshotCreateRequest) (string, error) {
+	if err := s.checkMaintenance(); err != nil {
+		return "", err
+	}
 	volumeName := request.VolumeName
 	if err := util.CheckName(volumeName); err != nil {
-		return err
+		return "", err
 	}
 	volume := s.getVolume(volumeName)
 	if volume == nil {
-		return fmt.Errorf("volume %v doesn't exist", volumeName)
+		return "", fmt.Errorf("volume %v doesn't exist", volumeName)
+	}
+	if err := checkLabels(request.Labels); err != nil {
+		return "", err
 	}
+	if err := s.beginVolumeOperation(volumeName); err != nil {
+		return "", err
+	}
+	defer s.endVolumeOperation(volumeName)
+
+	s.lockVolume(ctx, volumeName)
+	defer s.volumeLocks.Unlock(volumeName)
 
 	snapshotName := request.Name
 	if snapshotName != "" {
 		if err := util.CheckName(snapshotName); err != nil {
-			return err
+			return "", err
 		}
 		existName := s.NameUUIDIndex.Get(snapshotName)
 		if existName != "" {
-			return fmt.Errorf("Snapshot name %v already exists", snapshotName)
+			return "", fmt.Errorf("Snapshot name %v already exists", snapshotName)
 		}
 	} else {
 		snapshotName = util.GenerateName("snapshot")
@@ -51,15 +104,34 @@ func (s *daemon) doSnapshotCreate(version string, w http.ResponseWriter, r *http
 		}
 	}
 
+	if err := s.checkDriverHealth(volume.DriverName); err != nil {
+		return "", err
+	}
+	if err := s.checkCapability(volume.DriverName, CAPABILITY_SNAPSHOT); err != nil {
+		return "", err
+	}
+	if request.KmsKeyID != "" {
+		if err := s.checkCapability(volume.DriverName, CAPABILITY_KMS_ENCRYPTION); err != nil {
+			return "", err
+		}
+	}
 	snapOps, err := s.getSnapshotOpsForVolume(volume)
 	if err != nil {
-		return err
+		return "", err
 	}
+	release, err := s.holdVolume(volume)
+	if err != nil {
+		return "", err
+	}
+	defer release()
 
 	req := Request{
 		Name: snapshotName,
 		Options: map[string]string{
 			OPT_VOLUME_NAME: volumeName,
+			OPT_KMS_KEY_ID:  request.KmsKeyID,
+			OPT_FSFREEZE:    strconv.FormatBool(request.FsFreeze),
+			OPT_LABELS:      util.EncodeLabels(request.Labels),
 		},
 	}
 
@@ -70,8 +142,24 @@ func (s *daemon) doSnapshotCreate(version string, w http.ResponseWriter, r *http
 		LOG_FIELD_SNAPSHOT: snapshotName,
 		LOG_FIELD_VOLUME:   volumeName,
 	}).Debug()
-	if err := snapOps.CreateSnapshot(req); err != nil {
-		return err
+	if err := s.snapshotWithHooks(volume, snapshotName, func() error {
+		s.acquireOpSlot(ctx)
+		defer s.releaseOpSlot()
+
+		start := time.Now()
+		var span *util.Span
+		req.Context, span = startDriverSpan(ctx, "CreateSnapshot", volume.DriverName, volumeName)
+		err := snapOps.CreateSnapshot(req)
+		span.End(err)
+		s.observeOperation(METRICS_OP_SNAPSHOT, start, err)
+		if err != nil {
+			return err
+		}
+		s.observeLatency(util.LATENCY_SNAPSHOT, volumeName, time.Since(start))
+		return nil
+	}); err != nil {
+		s.recordFailure(volumeName, LOG_OBJECT_SNAPSHOT, LOG_EVENT_CREATE, snapshotName, "", err)
+		return "", err
 	}
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
@@ -81,26 +169,21 @@ func (s *daemon) doSnapshotCreate(version string, w http.ResponseWriter, r *http
 		LOG_FIELD_VOLUME:   volumeName,
 	}).Debug()
 
+	s.recordEvent(volumeName, LOG_OBJECT_SNAPSHOT, LOG_EVENT_CREATE, snapshotName, "")
+
 	//TODO: error handling
 	if err := s.SnapshotVolumeIndex.Add(snapshotName, volume.Name); err != nil {
-		return err
+		return "", err
 	}
 	if err := s.NameUUIDIndex.Add(snapshotName, "exists"); err != nil {
-		return err
+		return "", err
 	}
-	driverInfo, err := s.getSnapshotDriverInfo(snapshotName, volume)
-	if err != nil {
-		return err
-	}
-	if request.Verbose {
-		return writeResponseOutput(w, api.SnapshotResponse{
-			Name:        snapshotName,
-			VolumeName:  volume.Name,
-			CreatedTime: driverInfo[OPT_SNAPSHOT_CREATED_TIME],
-			DriverInfo:  driverInfo,
-		})
+	if len(request.Labels) != 0 {
+		if err := s.saveSnapshotLabels(volumeName, snapshotName, request.Labels); err != nil {
+			log.Warnf("Failed to save labels of snapshot %v: %v", snapshotName, err)
+		}
 	}
-	return writeStringResponse(w, snapshotName)
+	return snapshotName, nil
 }
 
 func (s *daemon) getSnapshotDriverInfo(snapshotName string, volume *Volume) (map[string]string, error) {
@@ -151,15 +234,26 @@ func (s *daemon) doSnapshotDelete(version string, w http.ResponseWriter, r *http
 		return fmt.Errorf("cannot find volume for snapshot %v", snapshotName)
 	}
 
+	s.lockVolume(r.Context(), volumeName)
+	defer s.volumeLocks.Unlock(volumeName)
+
 	volume := s.getVolume(volumeName)
 	if !s.snapshotExists(volumeName, snapshotName) {
 		return fmt.Errorf("snapshot %v of volume %v doesn't exist", snapshotName, volumeName)
 	}
 
+	if err := s.checkDriverHealth(volume.DriverName); err != nil {
+		return err
+	}
 	snapOps, err := s.getSnapshotOpsForVolume(volume)
 	if err != nil {
 		return err
 	}
+	release, err := s.holdVolume(volume)
+	if err != nil {
+		return err
+	}
+	defer release()
 
 	req := Request{
 		Name: snapshotName,
@@ -174,7 +268,11 @@ func (s *daemon) doSnapshotDelete(version string, w http.ResponseWriter, r *http
 		LOG_FIELD_SNAPSHOT: snapshotName,
 		LOG_FIELD_VOLUME:   volumeName,
 	}).Debug()
-	if err := snapOps.DeleteSnapshot(req); err != nil {
+	var span *util.Span
+	req.Context, span = startDriverSpan(r.Context(), "DeleteSnapshot", volume.DriverName, volumeName)
+	err = snapOps.DeleteSnapshot(req)
+	span.End(err)
+	if err != nil {
 		return err
 	}
 	log.WithFields(logrus.Fields{
@@ -185,6 +283,8 @@ func (s *daemon) doSnapshotDelete(version string, w http.ResponseWriter, r *http
 		LOG_FIELD_VOLUME:   volumeName,
 	}).Debug()
 
+	s.recordEvent(volumeName, LOG_OBJECT_SNAPSHOT, LOG_EVENT_DELETE, snapshotName, "")
+
 	//TODO: error handling
 	if err := s.SnapshotVolumeIndex.Delete(snapshotName); err != nil {
 		return err
@@ -192,6 +292,7 @@ func (s *daemon) doSnapshotDelete(version string, w http.ResponseWriter, r *http
 	if err := s.NameUUIDIndex.Delete(snapshotName); err != nil {
 		return err
 	}
+	s.deleteSnapshotLabels(volumeName, snapshotName)
 	return nil
 }
 
@@ -229,11 +330,17 @@ func (s *daemon) doSnapshotInspect(version string, w http.ResponseWriter, r *htt
 		return err
 	}
 
+	labels, err := s.getSnapshotLabels(volumeName, snapshotName)
+	if err != nil {
+		return err
+	}
+
 	resp := api.SnapshotResponse{
 		Name:            snapshotName,
 		VolumeName:      volumeName,
 		VolumeCreatedAt: volumeDriverInfo[OPT_VOLUME_CREATED_TIME],
 		CreatedTime:     snapshot[OPT_SNAPSHOT_CREATED_TIME],
+		Labels:          labels,
 		DriverInfo:      driverInfo,
 	}
 	data, err := api.ResponseOutput(resp)
@@ -0,0 +1,140 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	TRACE_ATTR_VOLUME = "convoy.volume"
+	TRACE_ATTR_DRIVER = "convoy.driver"
+	// Destination of backups, without their names
+	TRACE_ATTR_DEST_URL = "convoy.dest_url"
+)
+
+var (
+	// Polled or streamed, their spans would only bury the rest
+	traceExemptRoutes = map[string]bool{
+		"/events":  true,
+		"/metrics": true,
+		"/healthz": true,
+		"/readyz":  true,
+	}
+)
+
+/*
+initTracing exports spans of requests, driver operations and backup
+transfers to the OTLP collector of --tracing-endpoint. Values of
+--tracing-header can be file:<path> or env:<name> for secrets, e.g. API keys
+of hosted collectors.
+*/
+func (s *daemon) initTracing(c *cli.Context) error {
+	endpoint := c.String("tracing-endpoint")
+	if endpoint == "" {
+		return nil
+	}
+	headers := make(map[string]string)
+	for _, spec := range c.StringSlice("tracing-header") {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("Invalid tracing header %q, should be <name>=<value>", spec)
+		}
+		value := parts[1]
+		if strings.HasPrefix(value, SECRET_FILE+":") || strings.HasPrefix(value, SECRET_ENV+":") {
+			var err error
+			if value, err = getSecret(value, "tracing header "+parts[0]); err != nil {
+				return err
+			}
+		}
+		headers[parts[0]] = value
+	}
+	tlsConfig, err := util.NewClientTLSConfig(c.String("tracing-ca-cert"), "", "")
+	if err != nil {
+		return err
+	}
+	attributes := map[string]string{
+		"service.name":    c.String("tracing-service-name"),
+		"service.version": c.App.Version,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		attributes["host.name"] = hostname
+	}
+	tracer, err := util.NewTracer(util.TracingConfig{
+		Endpoint:    endpoint,
+		Headers:     headers,
+		TLSConfig:   tlsConfig,
+		Attributes:  attributes,
+		SampleRatio: c.Float64("tracing-sample-ratio"),
+	})
+	if err != nil {
+		return err
+	}
+	s.tracer = tracer
+	util.SetTracer(tracer)
+	log.Infof("Exporting traces to %v, sampling %v of them", endpoint, c.Float64("tracing-sample-ratio"))
+	return nil
+}
+
+/*
+traceHandler records request to route as a span, continuing the trace of
+caller if it has traceparent header. Spans of the operations done for the
+request are its children.
+*/
+func (s *daemon) traceHandler(method, route string, h http.HandlerFunc) http.HandlerFunc {
+	if traceExemptRoutes[route] {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := util.StartServerSpan(r.Context(), method+" "+route, r.Header.Get(util.TRACE_PARENT_HEADER))
+		if span == nil {
+			h(w, r.WithContext(ctx))
+			return
+		}
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("convoy.caller", auditCaller(r))
+		rw := &auditResponseWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		h(rw, r.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", rw.statusCode)
+		var err error
+		if rw.statusCode >= http.StatusBadRequest {
+			err = fmt.Errorf("%v", strings.TrimSpace(string(rw.body)))
+		}
+		span.End(err)
+	}
+}
+
+// startDriverSpan starts span of operation op of driver on volume, whose
+// context should be passed to the driver by Request.Context
+func startDriverSpan(ctx context.Context, op, driverName, volumeName string) (context.Context, *util.Span) {
+	ctx, span := util.StartSpan(ctx, "driver."+op)
+	span.SetAttribute(TRACE_ATTR_DRIVER, driverName)
+	if volumeName != "" {
+		span.SetAttribute(TRACE_ATTR_VOLUME, volumeName)
+	}
+	return ctx, span
+}
+
+// writeTracingMetrics adds spans exported, dropped and failed to metrics
+func (s *daemon) writeTracingMetrics(b *bytes.Buffer) {
+	if s.tracer == nil {
+		return
+	}
+	stats := s.tracer.Stats()
+	b.WriteString("# HELP convoy_trace_spans_total Spans ended by result of export.\n")
+	b.WriteString("# TYPE convoy_trace_spans_total counter\n")
+	fmt.Fprintf(b, "convoy_trace_spans_total{result=\"exported\"} %v\n", stats.Exported)
+	fmt.Fprintf(b, "convoy_trace_spans_total{result=\"dropped\"} %v\n", stats.Dropped)
+	fmt.Fprintf(b, "convoy_trace_spans_total{result=\"failed\"} %v\n", stats.Failed)
+}
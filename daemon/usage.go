@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+/*
+getVolumeUsage reports size of the volume, the space it takes in the backend
+by UsageReporter of its driver, and usage of its filesystem if it's mounted.
+Failures are reported as Error of the volume, so a volume the backend cannot
+tell doesn't fail the usage of others.
+*/
+func (s *daemon) getVolumeUsage(name string) api.VolumeUsageResponse {
+	volume := s.getVolume(name)
+	if volume == nil {
+		usage := api.VolumeUsageResponse{
+			Name:  name,
+			State: VOLUME_STATE_CREATING,
+			Used:  -1,
+		}
+		if driverName, exists := s.creatingVolumes()[name]; exists {
+			usage.Driver = driverName
+		} else {
+			usage.Error = fmt.Sprintf("volume %v doesn't exist", name)
+		}
+		return usage
+	}
+	usage := api.VolumeUsageResponse{
+		Name:   volume.Name,
+		Driver: volume.DriverName,
+		Used:   -1,
+	}
+	r, err := s.listVolumeBrief(volume)
+	if err != nil {
+		usage.Error = err.Error()
+		return usage
+	}
+	usage.State = r.State
+	usage.MountPoint = r.MountPoint
+
+	driverInfo, err := s.getVolumeDriverInfo(volume)
+	if err != nil {
+		usage.Error = err.Error()
+		return usage
+	}
+	// Volumes without size of their own report 0, e.g. vfs
+	usage.Size, _ = strconv.ParseInt(driverInfo[OPT_SIZE], 10, 64)
+
+	// Mount points of volumes without filesystem of their own, e.g. vfs,
+	// are on filesystem of the host, which tells nothing about the volume
+	if r.MountPoint != "" {
+		if mounted, err := util.IsMountPoint(r.MountPoint); err == nil && mounted {
+			if size, used, err := util.FilesystemUsage(r.MountPoint); err == nil {
+				usage.FilesystemSize = size
+				usage.FilesystemUsed = used
+				usage.Used = used
+			}
+		}
+	}
+
+	driver, err := s.getDriver(volume.DriverName)
+	if err != nil {
+		usage.Error = err.Error()
+		return usage
+	}
+	if reporter, ok := driver.(UsageReporter); ok {
+		used, err := reporter.VolumeUsage(volume.Name)
+		if err != nil {
+			usage.Error = err.Error()
+		} else {
+			usage.Used = used
+		}
+	}
+	return usage
+}
+
+func (s *daemon) doVolumeUsage(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	names := r.URL.Query()["volume"]
+	for _, name := range names {
+		if err := util.CheckName(name); err != nil {
+			return err
+		}
+		if s.getVolume(name) == nil {
+			if _, exists := s.creatingVolumes()[name]; !exists {
+				return fmt.Errorf("volume %v doesn't exist", name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		// Volumes are selected the same as list, without asking drivers
+		// unless filtered by their ages
+		opts, err := parseVolumeListOptions(version, r)
+		if err != nil {
+			return err
+		}
+		opts.fields = []string{"Name"}
+		resp, next, err := s.listVolume(opts)
+		if err != nil {
+			return err
+		}
+		for name := range resp.(map[string]map[string]interface{}) {
+			names = append(names, name)
+		}
+		if next != "" {
+			w.Header().Set(api.NEXT_PAGE_HEADER, next)
+		}
+	}
+
+	usages := make(map[string]api.VolumeUsageResponse)
+	for _, name := range names {
+		usages[name] = s.getVolumeUsage(name)
+	}
+	return writeResponseOutput(w, usages)
+}
@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/rancher/convoy/api"
+)
+
+const (
+	API_CLIENT_USER_AGENT = "Convoy-Client/"
+)
+
+var (
+	apiVersionPath = regexp.MustCompile(`^/v([0-9.]+)/`)
+)
+
+/*
+negotiateAPIVersion returns the API version the response to r should be
+compatible with, i.e. the version the client speaks, from API_VERSION_HEADER
+or else the user agent of Convoy clients before 1.1. Requests not telling
+their version, e.g. curl, get API_VERSION of the daemon. Clients of another
+major version, or a newer minor version whose requests the daemon may not
+fully understand, are refused rather than served with changes they don't
+expect.
+*/
+func negotiateAPIVersion(r *http.Request) (string, error) {
+	version := r.Header.Get(api.API_VERSION_HEADER)
+	if version == "" {
+		userAgent := r.Header.Get("User-Agent")
+		if !strings.HasPrefix(userAgent, API_CLIENT_USER_AGENT) {
+			return api.API_VERSION, nil
+		}
+		version = strings.TrimPrefix(userAgent, API_CLIENT_USER_AGENT)
+	}
+	major, minor, err := api.ParseVersion(version)
+	if err != nil {
+		return "", err
+	}
+	serverMajor, serverMinor, err := api.ParseVersion(api.API_VERSION)
+	if err != nil {
+		return "", err
+	}
+	if major != serverMajor {
+		return "", fmt.Errorf("Client API version %v is incompatible with daemon API version %v", version, api.API_VERSION)
+	}
+	if minor > serverMinor {
+		return "", fmt.Errorf("Client API version %v is newer than daemon API version %v, the daemon needs to be upgraded", version, api.API_VERSION)
+	}
+	return fmt.Sprintf("%v.%v", major, minor), nil
+}
+
+// apiVersionBefore tells whether API version negotiated is older than than
+func apiVersionBefore(version, than string) bool {
+	major, minor, err := api.ParseVersion(version)
+	if err != nil {
+		return false
+	}
+	thanMajor, thanMinor, err := api.ParseVersion(than)
+	if err != nil {
+		return false
+	}
+	return major < thanMajor || (major == thanMajor && minor < thanMinor)
+}
+
+// unsupportedAPIVersion returns the version in path of r, if it's not the
+// major version served by the daemon
+func unsupportedAPIVersion(r *http.Request) string {
+	m := apiVersionPath.FindStringSubmatch(r.URL.Path)
+	if m == nil || m[1] == api.API_MAJOR_VERSION {
+		return ""
+	}
+	return m[1]
+}
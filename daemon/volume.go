@@ -1,9 +1,11 @@
 package daemon
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/convoy/api"
@@ -71,17 +73,32 @@ func (s *daemon) generateName() (string, error) {
 	}
 }
 
-func (s *daemon) processVolumeCreate(request *api.VolumeCreateRequest) (*Volume, error) {
+func (s *daemon) processVolumeCreate(ctx context.Context, request *api.VolumeCreateRequest) (*Volume, error) {
+	return s.createVolume(ctx, request, nil)
+}
+
+// createVolume creates the volume of request, as a clone of source volume if
+// source isn't nil
+func (s *daemon) createVolume(ctx context.Context, request *api.VolumeCreateRequest, source *Volume) (volume *Volume, err error) {
 	volumeName := request.Name
 	driverName := request.DriverName
 
-	var err error
+	if err := s.checkMaintenance(); err != nil {
+		return nil, err
+	}
 	if volumeName == "" {
 		volumeName, err = s.generateName()
 		if err != nil {
 			return nil, err
 		}
-	} else {
+	}
+	lockNames := []string{volumeName}
+	if source != nil {
+		lockNames = append(lockNames, source.Name)
+	}
+	unlock := s.lockVolumes(ctx, lockNames...)
+	defer unlock()
+	if request.Name != "" {
 		exists, err := s.volumeExists(volumeName)
 		if err != nil {
 			return nil, fmt.Errorf("Error occurred while checking if volume %v exists: %v", volumeName, err)
@@ -91,9 +108,50 @@ func (s *daemon) processVolumeCreate(request *api.VolumeCreateRequest) (*Volume,
 		}
 	}
 
+	// Source may be gone while waiting for its lock
+	if source != nil && s.getVolume(source.Name) == nil {
+		return nil, fmt.Errorf("Volume %v to clone doesn't exist", source.Name)
+	}
+
 	if driverName == "" {
 		driverName = s.DefaultDriver
 	}
+	s.startCreatingVolume(volumeName, driverName)
+	defer func() {
+		s.finishCreatingVolume(volumeName, err)
+	}()
+	if err := s.checkDriverHealth(driverName); err != nil {
+		return nil, err
+	}
+	if request.BackupURL != "" {
+		if err := s.checkCapability(driverName, CAPABILITY_BACKUP); err != nil {
+			return nil, err
+		}
+	}
+	if source != nil {
+		if err := s.checkCapability(driverName, CAPABILITY_CLONE); err != nil {
+			return nil, err
+		}
+	}
+	if request.EncryptionKey != "" {
+		if err := util.CheckEncryptionKey(request.EncryptionKey); err != nil {
+			return nil, err
+		}
+		if err := util.CheckEncryptionKeySource(); err != nil {
+			return nil, err
+		}
+		if err := s.checkCapability(driverName, CAPABILITY_ENCRYPTION); err != nil {
+			return nil, err
+		}
+	}
+	if request.KmsKeyID != "" {
+		if err := s.checkCapability(driverName, CAPABILITY_KMS_ENCRYPTION); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkLabels(request.Labels); err != nil {
+		return nil, err
+	}
 	driver, err := s.getDriver(driverName)
 	if err != nil {
 		return nil, err
@@ -102,17 +160,47 @@ func (s *daemon) processVolumeCreate(request *api.VolumeCreateRequest) (*Volume,
 	if err != nil {
 		return nil, err
 	}
+	if err := s.reserveQuota(volumeName, driver, request); err != nil {
+		return nil, err
+	}
+	defer s.releaseQuota(volumeName, driverName)
+	release, err := s.holdVolume(&Volume{
+		Name:       volumeName,
+		DriverName: driverName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	sourceName := ""
+	if source != nil {
+		releaseSource, err := s.holdVolume(source)
+		if err != nil {
+			return nil, err
+		}
+		defer releaseSource()
+		sourceName = source.Name
+	}
 
 	req := Request{
 		Name: volumeName,
 		Options: map[string]string{
-			OPT_SIZE:             strconv.FormatInt(request.Size, 10),
-			OPT_BACKUP_URL:       util.UnescapeURL(request.BackupURL),
-			OPT_VOLUME_NAME:      volumeName,
-			OPT_VOLUME_DRIVER_ID: request.DriverVolumeID,
-			OPT_VOLUME_TYPE:      request.Type,
-			OPT_VOLUME_IOPS:      strconv.FormatInt(request.IOPS, 10),
-			OPT_PREPARE_FOR_VM:   strconv.FormatBool(request.PrepareForVM),
+			OPT_SIZE:              strconv.FormatInt(request.Size, 10),
+			OPT_BACKUP_URL:        util.UnescapeURL(request.BackupURL),
+			OPT_CLONE_VOLUME:      sourceName,
+			OPT_VOLUME_NAME:       volumeName,
+			OPT_VOLUME_DRIVER_ID:  request.DriverVolumeID,
+			OPT_VOLUME_TYPE:       request.Type,
+			OPT_VOLUME_IOPS:       strconv.FormatInt(request.IOPS, 10),
+			OPT_PREPARE_FOR_VM:    strconv.FormatBool(request.PrepareForVM),
+			OPT_FILESYSTEM:        request.Filesystem,
+			OPT_MKFS_OPTIONS:      request.MkfsOptions,
+			OPT_MOUNT_OPTIONS:     request.MountOptions,
+			OPT_ENCRYPTION_KEY:    request.EncryptionKey,
+			OPT_KMS_KEY_ID:        request.KmsKeyID,
+			OPT_BACKUP_BLOCK_SIZE: request.BackupBlockSize,
+			OPT_FSFREEZE:          request.FsFreeze,
+			OPT_LABELS:            util.EncodeLabels(request.Labels),
 		},
 	}
 	log.WithFields(logrus.Fields{
@@ -122,9 +210,55 @@ func (s *daemon) processVolumeCreate(request *api.VolumeCreateRequest) (*Volume,
 		LOG_FIELD_VOLUME: volumeName,
 		LOG_FIELD_OPTS:   req.Options,
 	}).Debug()
-	if err := volOps.CreateVolume(req); err != nil {
+	hookOpts := map[string]string{
+		OPT_SIZE: req.Options[OPT_SIZE],
+	}
+	if request.BackupURL != "" {
+		hookOpts[OPT_BACKUP_URL] = req.Options[OPT_BACKUP_URL]
+	}
+	if source != nil {
+		hookOpts[OPT_CLONE_VOLUME] = sourceName
+	}
+	if err := s.runPreSiteHooks(ctx, LOG_EVENT_CREATE, volumeName, driverName, hookOpts); err != nil {
+		return nil, err
+	}
+	op := METRICS_OP_CREATE
+	if request.BackupURL != "" {
+		op = METRICS_OP_RESTORE
+	} else if source != nil {
+		op = METRICS_OP_CLONE
+	}
+	if request.BackupURL != "" {
+		destURL := destinationOf(req.Options[OPT_BACKUP_URL])
+		s.destLocks.RLock(destURL)
+		defer s.destLocks.RUnlock(destURL)
+	}
+	if request.BackupURL != "" || source != nil {
+		s.acquireOpSlot(ctx)
+		defer s.releaseOpSlot()
+	}
+	start := time.Now()
+	var span *util.Span
+	req.Context, span = startDriverSpan(ctx, "CreateVolume", driverName, volumeName)
+	err = volOps.CreateVolume(req)
+	span.End(err)
+	s.observeOperation(op, start, err)
+	if err != nil {
+		if request.BackupURL != "" {
+			s.recordFailure(volumeName, LOG_OBJECT_VOLUME, LOG_EVENT_RESTORE, volumeName,
+				util.UnescapeURL(request.BackupURL), err)
+		} else if source != nil {
+			s.recordFailure(volumeName, LOG_OBJECT_VOLUME, LOG_EVENT_CLONE, volumeName, sourceName, err)
+		} else {
+			s.recordFailure(volumeName, LOG_OBJECT_VOLUME, LOG_EVENT_CREATE, volumeName, "", err)
+		}
 		return nil, err
 	}
+	// Restore would be dominated by the download, and clone by the copy, not
+	// tracked as create
+	if request.BackupURL == "" && source == nil {
+		s.observeLatency(util.LATENCY_CREATE, volumeName, time.Since(start))
+	}
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
 		LOG_FIELD_EVENT:  LOG_EVENT_CREATE,
@@ -132,7 +266,7 @@ func (s *daemon) processVolumeCreate(request *api.VolumeCreateRequest) (*Volume,
 		LOG_FIELD_VOLUME: volumeName,
 	}).Debug("Created volume")
 
-	volume := &Volume{
+	volume = &Volume{
 		Name:       volumeName,
 		DriverName: driverName,
 	}
@@ -140,6 +274,18 @@ func (s *daemon) processVolumeCreate(request *api.VolumeCreateRequest) (*Volume,
 	if err := s.NameUUIDIndex.Add(volumeName, "exists"); err != nil {
 		return nil, err
 	}
+	if len(request.Labels) != 0 {
+		if err := s.saveVolumeLabels(volumeName, request.Labels); err != nil {
+			log.Warnf("Failed to save labels of volume %v: %v", volumeName, err)
+		}
+	}
+	if request.BackupURL != "" {
+		s.recordEvent(volumeName, LOG_OBJECT_VOLUME, LOG_EVENT_RESTORE, volumeName, util.UnescapeURL(request.BackupURL))
+	} else if source != nil {
+		s.recordEvent(volumeName, LOG_OBJECT_VOLUME, LOG_EVENT_CLONE, volumeName, sourceName)
+	} else {
+		s.recordEvent(volumeName, LOG_OBJECT_VOLUME, LOG_EVENT_CREATE, volumeName, "")
+	}
 	return volume, nil
 }
 
@@ -149,7 +295,31 @@ func (s *daemon) doVolumeCreate(version string, w http.ResponseWriter, r *http.R
 		return err
 	}
 
-	volume, err := s.processVolumeCreate(request)
+	if request.Async {
+		if err := util.CheckName(request.Name); err != nil {
+			return err
+		}
+		if request.Name == "" {
+			name, err := s.generateName()
+			if err != nil {
+				return err
+			}
+			request.Name = name
+		}
+		j, err := s.startJob(r.Context(), JOB_TYPE_VOLUME_CREATE, request.Name, func(ctx context.Context) (string, error) {
+			volume, err := s.processVolumeCreate(ctx, request)
+			if err != nil {
+				return "", err
+			}
+			return volume.Name, nil
+		})
+		if err != nil {
+			return err
+		}
+		return writeJobResponse(w, j)
+	}
+
+	volume, err := s.processVolumeCreate(r.Context(), request)
 	if err != nil {
 		return err
 	}
@@ -163,6 +333,7 @@ func (s *daemon) doVolumeCreate(version string, w http.ResponseWriter, r *http.R
 			Name:        volume.Name,
 			Driver:      volume.DriverName,
 			CreatedTime: driverInfo[OPT_VOLUME_CREATED_TIME],
+			Labels:      request.Labels,
 			DriverInfo:  driverInfo,
 			Snapshots:   map[string]api.SnapshotResponse{},
 		})
@@ -180,17 +351,52 @@ func (s *daemon) doVolumeDelete(version string, w http.ResponseWriter, r *http.R
 		return err
 	}
 
-	return s.processVolumeDelete(request)
+	return s.processVolumeDelete(r.Context(), request)
+}
+
+func (s *daemon) processVolumeDelete(ctx context.Context, request *api.VolumeDeleteRequest) error {
+	_, err := s.processVolumeDeleteOrDefer(ctx, request, false)
+	return err
+}
+
+/*
+processVolumeDeleteOrDefer deletes the volume. If snapshot or backup of the
+volume is in progress, the delete would be deferred until they finish if
+deferIfBusy is true, otherwise it fails.
+*/
+func (s *daemon) processVolumeDeleteOrDefer(ctx context.Context, request *api.VolumeDeleteRequest, deferIfBusy bool) (bool, error) {
+	// Checked before reaching the driver, which may be busy with the
+	// operations
+	deferred, err := s.reserveVolumeDelete(request, deferIfBusy)
+	if err != nil || deferred {
+		return deferred, err
+	}
+	defer s.releaseVolumeDelete(request.VolumeName)
+
+	return false, s.deleteVolume(ctx, request)
 }
 
-func (s *daemon) processVolumeDelete(request *api.VolumeDeleteRequest) error {
+func (s *daemon) deleteVolume(ctx context.Context, request *api.VolumeDeleteRequest) error {
 	name := request.VolumeName
 
+	s.lockVolume(ctx, name)
+	defer s.volumeLocks.Unlock(name)
+
 	volume := s.getVolume(name)
 	if volume == nil {
 		return notFoundAPIError
 	}
 
+	if err := s.checkDriverHealth(volume.DriverName); err != nil {
+		return err
+	}
+
+	release, err := s.holdVolume(volume)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// In the case of snapshot is not supported, snapshots would be nil
 	snapshots, _ := s.listSnapshotDriverInfos(volume)
 
@@ -211,9 +417,19 @@ func (s *daemon) processVolumeDelete(request *api.VolumeDeleteRequest) error {
 		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
 		LOG_FIELD_VOLUME: name,
 	}).Debug()
-	if err := volOps.DeleteVolume(req); err != nil {
+	if err := s.runPreSiteHooks(ctx, LOG_EVENT_DELETE, name, volume.DriverName, req.Options); err != nil {
 		return err
 	}
+	var span *util.Span
+	req.Context, span = startDriverSpan(ctx, "DeleteVolume", volume.DriverName, name)
+	err = volOps.DeleteVolume(req)
+	span.End(err)
+	if err != nil {
+		s.setVolumeState(name, VOLUME_STATE_ERROR, err)
+		return err
+	}
+	s.deleteVolumeState(name)
+	s.releaseMountedVolume(volume)
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
 		LOG_FIELD_EVENT:  LOG_EVENT_DELETE,
@@ -223,6 +439,13 @@ func (s *daemon) processVolumeDelete(request *api.VolumeDeleteRequest) error {
 	if err := s.NameUUIDIndex.Delete(volume.Name); err != nil {
 		return err
 	}
+	s.deleteVolumeHistory(volume.Name)
+	s.deleteVolumeActivity(volume.Name)
+	s.deleteVolumeSchedule(volume.Name)
+	s.deleteVolumeHook(volume.Name)
+	s.deleteVolumeLabels(volume.Name)
+	s.deleteDockerMountOptions(volume.Name)
+	s.queueSiteHooks(volume.Name, volume.DriverName, LOG_OBJECT_VOLUME, LOG_EVENT_DELETE, volume.Name, "")
 	if snapshots != nil {
 		for snapshotName := range snapshots {
 			if err := s.NameUUIDIndex.Delete(snapshotName); err != nil {
@@ -233,7 +456,9 @@ func (s *daemon) processVolumeDelete(request *api.VolumeDeleteRequest) error {
 	return nil
 }
 
-func (s *daemon) listVolumeInfo(volume *Volume) (*api.VolumeResponse, error) {
+// listVolumeBrief returns info of volume known by daemon, without asking
+// driver for volume and snapshot info, which can be slow
+func (s *daemon) listVolumeBrief(volume *Volume) (*api.VolumeResponse, error) {
 	volOps, err := s.getVolumeOpsForVolume(volume)
 	if err != nil {
 		return nil, err
@@ -247,52 +472,69 @@ func (s *daemon) listVolumeInfo(volume *Volume) (*api.VolumeResponse, error) {
 	if err != nil {
 		return nil, err
 	}
-	driverInfo, err := s.getVolumeDriverInfo(volume)
+	activity, err := s.getVolumeActivity(volume.Name)
 	if err != nil {
 		return nil, err
 	}
-	resp := &api.VolumeResponse{
+	labels, err := s.getVolumeLabels(volume.Name)
+	if err != nil {
+		return nil, err
+	}
+	state, stateErr := s.getVolumeState(volume.Name, mountPoint)
+	return &api.VolumeResponse{
 		Name:        volume.Name,
 		Driver:      volume.DriverName,
+		State:       state,
+		Error:       stateErr,
 		MountPoint:  mountPoint,
-		CreatedTime: driverInfo[OPT_VOLUME_CREATED_TIME],
-		DriverInfo:  driverInfo,
-		Snapshots:   make(map[string]api.SnapshotResponse),
+		LastMounted: activity.LastMounted,
+		LastIO:      activity.LastIO,
+		Labels:      labels,
+	}, nil
+}
+
+func (s *daemon) listVolumeInfo(volume *Volume) (*api.VolumeResponse, error) {
+	resp, err := s.listVolumeBrief(volume)
+	if err != nil {
+		return nil, err
+	}
+	return resp, s.addVolumeDriverInfo(volume, resp)
+}
+
+// addVolumeDriverInfo fills in info of volume and its snapshots from driver
+func (s *daemon) addVolumeDriverInfo(volume *Volume, resp *api.VolumeResponse) error {
+	volOps, err := s.getVolumeOpsForVolume(volume)
+	if err != nil {
+		return err
+	}
+	driverInfo, err := s.getVolumeDriverInfo(volume)
+	if err != nil {
+		return err
 	}
+	resp.CreatedTime = driverInfo[OPT_VOLUME_CREATED_TIME]
+	resp.DriverInfo = driverInfo
+	resp.Snapshots = make(map[string]api.SnapshotResponse)
 	snapshots, err := s.listSnapshotDriverInfos(volume)
 	if err != nil {
 		//snapshot doesn't exists
-		return resp, nil
+		return nil
+	}
+	s.labelMutex.Lock()
+	labels, err := s.loadVolumeLabels(volume.Name)
+	s.labelMutex.Unlock()
+	if err != nil {
+		return err
 	}
 	for name, snapshot := range snapshots {
 		snapshot["Driver"] = volOps.Name()
 		resp.Snapshots[name] = api.SnapshotResponse{
 			Name:        name,
 			CreatedTime: snapshot[OPT_SNAPSHOT_CREATED_TIME],
+			Labels:      labels.Snapshots[name],
 			DriverInfo:  snapshot,
 		}
 	}
-	return resp, nil
-}
-
-func (s *daemon) listVolume() ([]byte, error) {
-	resp := make(map[string]api.VolumeResponse)
-
-	volumes := s.getVolumeList()
-
-	for name := range volumes {
-		volume := s.getVolume(name)
-		if volume == nil {
-			return nil, fmt.Errorf("Volume list changed for volume %v", name)
-		}
-		r, err := s.listVolumeInfo(volume)
-		if err != nil {
-			return nil, err
-		}
-		resp[name] = *r
-	}
-
-	return api.ResponseOutput(resp)
+	return nil
 }
 
 func (s *daemon) getVolumeDriverInfo(volume *Volume) (map[string]string, error) {
@@ -313,30 +555,43 @@ func (s *daemon) doVolumeList(version string, w http.ResponseWriter, r *http.Req
 	if err != nil {
 		return err
 	}
-
-	var data []byte
 	if driverSpecific == "1" {
 		result := s.getVolumeList()
-		data, err = api.ResponseOutput(&result)
-	} else {
-		data, err = s.listVolume()
+		return writeResponseOutput(w, &result)
 	}
+	opts, err := parseVolumeListOptions(version, r)
 	if err != nil {
 		return err
 	}
-	_, err = w.Write(data)
-	return err
+	resp, next, err := s.listVolume(opts)
+	if err != nil {
+		return err
+	}
+	if next != "" {
+		w.Header().Set(api.NEXT_PAGE_HEADER, next)
+	}
+	return writeResponseOutput(w, resp)
 }
 
-func (s *daemon) inspectVolume(name string) ([]byte, error) {
+// inspectVolume answers for volume in the states known by API version of
+// client
+func (s *daemon) inspectVolume(version, name string) ([]byte, error) {
+	legacy := apiVersionBefore(version, VOLUME_STATE_API_VERSION)
 	volume := s.getVolume(name)
 	if volume == nil {
+		if driverName, exists := s.creatingVolumes()[name]; exists && !legacy {
+			return api.ResponseOutput(*creatingVolumeResponse(name, driverName))
+		}
 		return nil, notFoundAPIError
 	}
 	resp, err := s.listVolumeInfo(volume)
 	if err != nil {
 		return nil, err
 	}
+	if legacy {
+		resp.State = legacyVolumeState(resp)
+		resp.Error = ""
+	}
 	return api.ResponseOutput(*resp)
 }
 
@@ -351,7 +606,7 @@ func (s *daemon) doVolumeInspect(version string, w http.ResponseWriter, r *http.
 		return err
 	}
 
-	data, err := s.inspectVolume(name)
+	data, err := s.inspectVolume(version, name)
 	if err != nil {
 		return err
 	}
@@ -376,7 +631,7 @@ func (s *daemon) doVolumeMount(version string, w http.ResponseWriter, r *http.Re
 		return fmt.Errorf("volume %v doesn't exist", volumeName)
 	}
 
-	mountPoint, err := s.processVolumeMount(volume, request)
+	mountPoint, err := s.processVolumeMount(r.Context(), volume, request)
 	if err != nil {
 		return err
 	}
@@ -390,16 +645,51 @@ func (s *daemon) doVolumeMount(version string, w http.ResponseWriter, r *http.Re
 	return writeStringResponse(w, mountPoint)
 }
 
-func (s *daemon) processVolumeMount(volume *Volume, request *api.VolumeMountRequest) (string, error) {
+func (s *daemon) processVolumeMount(ctx context.Context, volume *Volume, request *api.VolumeMountRequest) (string, error) {
+	if err := s.checkMaintenance(); err != nil {
+		return "", err
+	}
+	s.lockVolume(ctx, volume.Name)
+	defer s.volumeLocks.Unlock(volume.Name)
+
+	if err := s.checkDriverHealth(volume.DriverName); err != nil {
+		return "", err
+	}
+	if request.MountPoint != "" {
+		if err := s.checkCapability(volume.DriverName, CAPABILITY_CUSTOM_MOUNT_POINT); err != nil {
+			return "", err
+		}
+	}
+	if request.ReadOnly {
+		if err := s.checkCapability(volume.DriverName, CAPABILITY_READ_ONLY_MOUNT); err != nil {
+			return "", err
+		}
+	}
+	if request.SubPath != "" {
+		if err := util.CheckSubPath(request.SubPath); err != nil {
+			return "", err
+		}
+	}
+	seLinuxContext, err := s.getSELinuxMountContext(volume, request.SELinuxLabel)
+	if err != nil {
+		return "", err
+	}
 	volOps, err := s.getVolumeOpsForVolume(volume)
 	if err != nil {
 		return "", err
 	}
+	release, err := s.holdVolume(volume)
+	if err != nil {
+		return "", err
+	}
+	defer release()
 
 	req := Request{
 		Name: volume.Name,
 		Options: map[string]string{
-			OPT_MOUNT_POINT: request.MountPoint,
+			OPT_MOUNT_POINT:     request.MountPoint,
+			OPT_READ_ONLY:       strconv.FormatBool(request.ReadOnly),
+			OPT_SELINUX_CONTEXT: seLinuxContext,
 		},
 	}
 	log.WithFields(logrus.Fields{
@@ -409,10 +699,31 @@ func (s *daemon) processVolumeMount(volume *Volume, request *api.VolumeMountRequ
 		LOG_FIELD_VOLUME: volume.Name,
 		LOG_FIELD_OPTS:   req.Options,
 	}).Debug()
+	hookOpts := map[string]string{
+		OPT_MOUNT_POINT: request.MountPoint,
+		OPT_READ_ONLY:   req.Options[OPT_READ_ONLY],
+	}
+	if err := s.runPreSiteHooks(ctx, LOG_EVENT_MOUNT, volume.Name, volume.DriverName, hookOpts); err != nil {
+		return "", err
+	}
+	start := time.Now()
+	s.setVolumeState(volume.Name, VOLUME_STATE_ATTACHING, nil)
+	var span *util.Span
+	req.Context, span = startDriverSpan(ctx, "MountVolume", volume.DriverName, volume.Name)
 	mountPoint, err := volOps.MountVolume(req)
+	span.End(err)
+	s.observeOperation(METRICS_OP_MOUNT, start, err)
+	s.setVolumeState(volume.Name, VOLUME_STATE_MOUNTED, err)
 	if err != nil {
+		s.recordFailure(volume.Name, LOG_OBJECT_VOLUME, LOG_EVENT_MOUNT, volume.Name, "", err)
 		return "", err
 	}
+	s.observeLatency(util.LATENCY_MOUNT, volume.Name, time.Since(start))
+	if request.SubPath != "" {
+		if mountPoint, err = util.MountPointSubPath(mountPoint, request.SubPath); err != nil {
+			return "", err
+		}
+	}
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:     LOG_REASON_COMPLETE,
 		LOG_FIELD_EVENT:      LOG_EVENT_LIST,
@@ -420,9 +731,39 @@ func (s *daemon) processVolumeMount(volume *Volume, request *api.VolumeMountRequ
 		LOG_FIELD_VOLUME:     volume.Name,
 		LOG_FIELD_MOUNTPOINT: mountPoint,
 	}).Debug()
+	detail := mountPoint
+	if request.ReadOnly {
+		detail += " (read-only)"
+	}
+	s.recordEvent(volume.Name, LOG_OBJECT_VOLUME, LOG_EVENT_MOUNT, volume.Name, detail)
+	s.recordMount(volume.Name)
+	s.holdMountedVolume(volume)
 	return mountPoint, nil
 }
 
+// getSELinuxMountContext returns SELinux context of label to mount the volume
+// with. Default label of daemon only applies to drivers supporting it.
+func (s *daemon) getSELinuxMountContext(volume *Volume, label string) (string, error) {
+	if label == "" {
+		driver, err := s.getDriver(volume.DriverName)
+		if err != nil {
+			return "", err
+		}
+		if !capabilitySupported(driver.Capabilities(), CAPABILITY_SELINUX_LABEL) {
+			return "", nil
+		}
+		label = s.SELinuxLabel
+	}
+	context, err := util.SELinuxMountContext(label)
+	if err != nil || context == "" {
+		return "", err
+	}
+	if err := s.checkCapability(volume.DriverName, CAPABILITY_SELINUX_LABEL); err != nil {
+		return "", err
+	}
+	return context, nil
+}
+
 func (s *daemon) doVolumeUmount(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
 	request := &api.VolumeUmountRequest{}
 	if err := decodeRequest(r, request); err != nil {
@@ -438,10 +779,13 @@ func (s *daemon) doVolumeUmount(version string, w http.ResponseWriter, r *http.R
 		return fmt.Errorf("volume %v doesn't exist", volumeName)
 	}
 
-	return s.processVolumeUmount(volume)
+	return s.processVolumeUmount(r.Context(), volume)
 }
 
-func (s *daemon) processVolumeUmount(volume *Volume) error {
+func (s *daemon) processVolumeUmount(ctx context.Context, volume *Volume) error {
+	s.lockVolume(ctx, volume.Name)
+	defer s.volumeLocks.Unlock(volume.Name)
+
 	volOps, err := s.getVolumeOpsForVolume(volume)
 	if err != nil {
 		return err
@@ -457,7 +801,18 @@ func (s *daemon) processVolumeUmount(volume *Volume) error {
 		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
 		LOG_FIELD_VOLUME: volume.Name,
 	}).Debug()
-	if err := volOps.UmountVolume(req); err != nil {
+	if err := s.runPreSiteHooks(ctx, LOG_EVENT_UMOUNT, volume.Name, volume.DriverName, nil); err != nil {
+		return err
+	}
+	start := time.Now()
+	var span *util.Span
+	req.Context, span = startDriverSpan(ctx, "UmountVolume", volume.DriverName, volume.Name)
+	err = volOps.UmountVolume(req)
+	span.End(err)
+	s.observeOperation(METRICS_OP_UMOUNT, start, err)
+	s.setVolumeState(volume.Name, VOLUME_STATE_DETACHED, err)
+	if err != nil {
+		s.recordFailure(volume.Name, LOG_OBJECT_VOLUME, LOG_EVENT_UMOUNT, volume.Name, "", err)
 		return err
 	}
 	log.WithFields(logrus.Fields{
@@ -466,6 +821,8 @@ func (s *daemon) processVolumeUmount(volume *Volume) error {
 		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
 		LOG_FIELD_VOLUME: volume.Name,
 	}).Debug()
+	s.recordEvent(volume.Name, LOG_OBJECT_VOLUME, LOG_EVENT_UMOUNT, volume.Name, "")
+	s.releaseMountedVolume(volume)
 
 	return nil
 }
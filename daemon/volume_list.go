@@ -0,0 +1,241 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+var (
+	volumeListFilters = []string{util.NAME_FILTER, util.DRIVER_FILTER, util.STATE_FILTER}
+
+	// Fields listed with brief, which are known without asking driver
+	volumeBriefFields = []string{"Name", "Driver", "State", "Error", "MountPoint", "Labels"}
+	// Fields which need info from driver
+	volumeDriverFields = []string{"CreatedTime", "DriverInfo", "Snapshots"}
+)
+
+// volumeListOptions are the query of /volumes/list, see docs/api.md
+type volumeListOptions struct {
+	idleFor   time.Duration
+	olderThan time.Duration
+	filters   []*util.ListFilter
+	// Volumes sorted by name after the one are listed, up to limit if
+	// it's not zero
+	after string
+	limit int
+	// Fields of each volume in response, nil for all
+	fields []string
+	// States of clients before VOLUME_STATE_API_VERSION, see
+	// legacyVolumeState()
+	legacyStates bool
+}
+
+func parseVolumeListOptions(version string, r *http.Request) (*volumeListOptions, error) {
+	query := r.URL.Query()
+	opts := &volumeListOptions{
+		after:        query.Get("after"),
+		legacyStates: apiVersionBefore(version, VOLUME_STATE_API_VERSION),
+	}
+	states := volumeStates
+	if opts.legacyStates {
+		states = legacyVolumeStates
+	}
+
+	var err error
+	if idleFor := query.Get("idle_for"); idleFor != "" {
+		if opts.idleFor, err = util.ParseDuration(idleFor); err != nil {
+			return nil, err
+		}
+	}
+	if olderThan := query.Get("older_than"); olderThan != "" {
+		if opts.olderThan, err = util.ParseDuration(olderThan); err != nil {
+			return nil, err
+		}
+	}
+	for _, filter := range query["filter"] {
+		f, err := util.ParseListFilter(filter, volumeListFilters)
+		if err != nil {
+			return nil, err
+		}
+		if f.Field == util.STATE_FILTER && !containsString(states, f.Value) {
+			return nil, fmt.Errorf("Invalid volume state %v, should be one of %v", f.Value, states)
+		}
+		opts.filters = append(opts.filters, f)
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if opts.limit, err = strconv.Atoi(limit); err != nil || opts.limit < 0 {
+			return nil, fmt.Errorf("Invalid limit %v, should be a non-negative integer", limit)
+		}
+	}
+
+	if query.Get("brief") == "1" {
+		opts.fields = volumeBriefFields
+	}
+	if fields := query.Get("fields"); fields != "" {
+		opts.fields = strings.Split(fields, ",")
+		t := reflect.TypeOf(api.VolumeResponse{})
+		for _, field := range opts.fields {
+			if _, exists := t.FieldByName(field); !exists {
+				return nil, fmt.Errorf("Invalid volume field %v", field)
+			}
+		}
+	}
+	return opts, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// needCreatedTime returns true if volumes are filtered by their ages, which
+// are only known by drivers
+func (opts *volumeListOptions) needCreatedTime() bool {
+	return opts.idleFor != 0 || opts.olderThan != 0
+}
+
+func (opts *volumeListOptions) needDriverInfo() bool {
+	if opts.fields == nil {
+		return true
+	}
+	for _, field := range opts.fields {
+		if containsString(volumeDriverFields, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts *volumeListOptions) match(r *api.VolumeResponse) bool {
+	for _, f := range opts.filters {
+		matched := false
+		switch f.Field {
+		case util.NAME_FILTER:
+			matched = f.Match(r.Name)
+		case util.DRIVER_FILTER:
+			matched = f.Match(r.Driver)
+		case util.STATE_FILTER:
+			matched = f.Match(r.State)
+		case util.LABEL_FILTER:
+			matched = f.Label.Match(r.Labels)
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+listVolume lists volumes matching all the filters, haven't been mounted or
+doing I/O for idleFor and created before olderThan if they're not zero.
+Volumes are filtered before asking driver for their info, so filters and brief
+list stay fast with thousands of volumes. Name of the last volume listed is returned if more are left beyond
+limit. Volumes being created are listed with their names and drivers only,
+except for clients before VOLUME_STATE_API_VERSION.
+*/
+func (s *daemon) listVolume(opts *volumeListOptions) (interface{}, string, error) {
+	creating := s.creatingVolumes()
+	if opts.legacyStates {
+		creating = map[string]string{}
+	}
+	names := []string{}
+	for name := range s.getVolumeList() {
+		if _, exists := creating[name]; !exists && name > opts.after {
+			names = append(names, name)
+		}
+	}
+	for name := range creating {
+		if name > opts.after {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	volumes := []*api.VolumeResponse{}
+	now := time.Now()
+	idleSince := now.Add(-opts.idleFor)
+	createdBefore := now.Add(-opts.olderThan)
+	next := ""
+	for _, name := range names {
+		if driverName, exists := creating[name]; exists {
+			r := creatingVolumeResponse(name, driverName)
+			if opts.needCreatedTime() || !opts.match(r) {
+				continue
+			}
+			if opts.limit != 0 && len(volumes) == opts.limit {
+				next = volumes[len(volumes)-1].Name
+				break
+			}
+			volumes = append(volumes, r)
+			continue
+		}
+		volume := s.getVolume(name)
+		if volume == nil {
+			return nil, "", fmt.Errorf("Volume list changed for volume %v", name)
+		}
+		r, err := s.listVolumeBrief(volume)
+		if err != nil {
+			return nil, "", err
+		}
+		if opts.legacyStates {
+			r.State = legacyVolumeState(r)
+			r.Error = ""
+		}
+		if !opts.match(r) {
+			continue
+		}
+		// Creation time is needed for idleness and age
+		if opts.needCreatedTime() {
+			if err := s.addVolumeDriverInfo(volume, r); err != nil {
+				return nil, "", err
+			}
+			if opts.idleFor != 0 && getLastActive(r).After(idleSince) {
+				continue
+			}
+			if opts.olderThan != 0 && !parseTime(r.CreatedTime).Before(createdBefore) {
+				continue
+			}
+		}
+		if opts.limit != 0 && len(volumes) == opts.limit {
+			next = volumes[len(volumes)-1].Name
+			break
+		}
+		if !opts.needCreatedTime() && opts.needDriverInfo() {
+			if err := s.addVolumeDriverInfo(volume, r); err != nil {
+				return nil, "", err
+			}
+		}
+		volumes = append(volumes, r)
+	}
+
+	if opts.fields == nil {
+		resp := make(map[string]api.VolumeResponse)
+		for _, r := range volumes {
+			resp[r.Name] = *r
+		}
+		return resp, next, nil
+	}
+	resp := make(map[string]map[string]interface{})
+	for _, r := range volumes {
+		v := reflect.ValueOf(*r)
+		selected := make(map[string]interface{})
+		for _, field := range opts.fields {
+			selected[field] = v.FieldByName(field).Interface()
+		}
+		resp[r.Name] = selected
+	}
+	return resp, next, nil
+}
@@ -0,0 +1,60 @@
+package daemon
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher/convoy/api"
+
+	. "gopkg.in/check.v1"
+)
+
+// backdateVolume changes creation time of vfs volume as if it's created age
+// ago
+func backdateVolume(c *C, d *daemon, name string, age time.Duration) {
+	info, err := d.ConvoyDrivers["vfs"].Info()
+	c.Assert(err, IsNil)
+	file := filepath.Join(info["Path"], "config", "vfs_volume_"+name+".json")
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	config := map[string]interface{}{}
+	c.Assert(json.Unmarshal(data, &config), IsNil)
+	config["CreatedTime"] = time.Now().Add(-age).Format(time.RubyDate)
+	data, err = json.Marshal(config)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(file, data, 0600), IsNil)
+}
+
+func (s *TestSuite) TestVolumeListSelect(c *C) {
+	d := newTestDaemon(c)
+	for _, name := range []string{"ci-1", "ci-2", "prod"} {
+		code, body := d.call(c, "POST", "/volumes/create", &api.VolumeCreateRequest{Name: name}, nil)
+		c.Assert(code, Equals, http.StatusOK, Commentf(body))
+	}
+	backdateVolume(c, d, "ci-1", 8*24*time.Hour)
+	backdateVolume(c, d, "prod", 30*24*time.Hour)
+
+	list := func(query string) []string {
+		volumes := map[string]interface{}{}
+		code, body := d.call(c, "GET", "/volumes/list?fields=Name&"+query, nil, &volumes)
+		c.Assert(code, Equals, http.StatusOK, Commentf(body))
+		names := []string{}
+		for name := range volumes {
+			names = append(names, name)
+		}
+		return names
+	}
+	c.Assert(list("filter=name%3Dci-*"), HasLen, 2)
+	c.Assert(list("older_than=7d"), HasLen, 2)
+	// Both have to match
+	c.Assert(list("filter=name%3Dci-*&older_than=7d"), DeepEquals, []string{"ci-1"})
+	c.Assert(list("filter=name%3Dci-*&older_than=2w"), HasLen, 0)
+
+	code, _ := d.call(c, "GET", "/volumes/list?older_than=forever", nil, nil)
+	c.Assert(code, Not(Equals), http.StatusOK)
+	code, _ = d.call(c, "GET", "/volumes/list?filter=size%3D1", nil, nil)
+	c.Assert(code, Not(Equals), http.StatusOK)
+}
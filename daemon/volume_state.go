@@ -0,0 +1,210 @@
+package daemon
+
+import (
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	VOLUME_STATE_CREATING   = "creating"
+	VOLUME_STATE_DETACHED   = "detached"
+	VOLUME_STATE_ATTACHING  = "attaching"
+	VOLUME_STATE_MOUNTED    = "mounted"
+	VOLUME_STATE_BACKING_UP = "backing-up"
+	VOLUME_STATE_ERROR      = "error"
+	VOLUME_STATE_DELETING   = "deleting"
+
+	// State of volumes not mounted for clients before
+	// VOLUME_STATE_API_VERSION
+	VOLUME_STATE_UNMOUNTED = "unmounted"
+
+	// API version introducing states besides mounted, unmounted and
+	// deleting
+	VOLUME_STATE_API_VERSION = "1.13"
+)
+
+var (
+	volumeStates       = []string{VOLUME_STATE_CREATING, VOLUME_STATE_DETACHED, VOLUME_STATE_ATTACHING, VOLUME_STATE_MOUNTED, VOLUME_STATE_BACKING_UP, VOLUME_STATE_ERROR, VOLUME_STATE_DELETING}
+	legacyVolumeStates = []string{VOLUME_STATE_MOUNTED, VOLUME_STATE_UNMOUNTED, VOLUME_STATE_DELETING}
+)
+
+/*
+volumeState is the state of a volume maintained by daemon, changed as
+operations on the volume start and finish:
+
+	creating -> detached -> attaching -> mounted -> detached -> deleting
+
+Backups of a volume put it in backing-up until they finish, whether it's
+mounted or not. A failed create, mount, unmount or delete puts it in error,
+with the error, until one of them succeeds. Volumes creating or attaching
+are locked, so other operations on them wait. Volumes backing up can be
+mounted, unmounted and snapshotted, but delete is refused or deferred, see
+reserveVolumeDelete(). Otherwise volumes are mounted or detached by their
+mount points, which may change behind daemon, e.g. by umount on the host.
+*/
+type volumeState struct {
+	state  string
+	err    string
+	driver string
+	// Backups in progress
+	backups int
+}
+
+func (s *daemon) loadVolumeState(name string) *volumeState {
+	if s.volumeStates == nil {
+		s.volumeStates = make(map[string]*volumeState)
+	}
+	state, exists := s.volumeStates[name]
+	if !exists {
+		state = &volumeState{}
+		s.volumeStates[name] = state
+	}
+	return state
+}
+
+/*
+publishVolumeState publishes state event of the volume entering creating,
+attaching, backing-up or error, so watchers see operations in progress, e.g.
+"convoy list --watch". Operations done are published by their own events, so
+state events aren't recorded in timelines.
+*/
+func (s *daemon) publishVolumeState(name, state, errMsg string) {
+	s.publishEvent(name, api.VolumeEvent{
+		Time:   util.Now(),
+		Object: LOG_OBJECT_VOLUME,
+		Event:  LOG_EVENT_STATE,
+		Name:   name,
+		Detail: state,
+		Error:  errMsg,
+	})
+}
+
+// setVolumeState moves the volume to state, or error if err isn't nil
+func (s *daemon) setVolumeState(name, state string, err error) {
+	s.volumeStateMutex.Lock()
+	vs := s.loadVolumeState(name)
+	vs.state = state
+	vs.err = ""
+	if err != nil {
+		vs.state = VOLUME_STATE_ERROR
+		vs.err = err.Error()
+	}
+	state, errMsg := vs.state, vs.err
+	s.volumeStateMutex.Unlock()
+
+	if state == VOLUME_STATE_ATTACHING || state == VOLUME_STATE_ERROR {
+		s.publishVolumeState(name, state, errMsg)
+	}
+}
+
+// startCreatingVolume puts the volume to be created by driver in creating,
+// so it's listed before driver knows it
+func (s *daemon) startCreatingVolume(name, driverName string) {
+	s.volumeStateMutex.Lock()
+	vs := s.loadVolumeState(name)
+	vs.state = VOLUME_STATE_CREATING
+	vs.err = ""
+	vs.driver = driverName
+	s.volumeStateMutex.Unlock()
+
+	s.publishVolumeState(name, VOLUME_STATE_CREATING, "")
+}
+
+// finishCreatingVolume moves the volume created to detached. Volume failed
+// to be created is forgotten, unless driver still has it
+func (s *daemon) finishCreatingVolume(name string, err error) {
+	if err != nil && s.getVolume(name) == nil {
+		s.deleteVolumeState(name)
+		return
+	}
+	s.setVolumeState(name, VOLUME_STATE_DETACHED, err)
+}
+
+func (s *daemon) deleteVolumeState(name string) {
+	s.volumeStateMutex.Lock()
+	defer s.volumeStateMutex.Unlock()
+
+	delete(s.volumeStates, name)
+}
+
+func (s *daemon) beginVolumeBackup(name string) {
+	s.volumeStateMutex.Lock()
+	s.loadVolumeState(name).backups++
+	s.volumeStateMutex.Unlock()
+
+	s.publishVolumeState(name, VOLUME_STATE_BACKING_UP, "")
+}
+
+func (s *daemon) endVolumeBackup(name string) {
+	s.volumeStateMutex.Lock()
+	defer s.volumeStateMutex.Unlock()
+
+	s.loadVolumeState(name).backups--
+}
+
+// creatingVolumes returns drivers of volumes being created by name
+func (s *daemon) creatingVolumes() map[string]string {
+	s.volumeStateMutex.Lock()
+	defer s.volumeStateMutex.Unlock()
+
+	volumes := make(map[string]string)
+	for name, vs := range s.volumeStates {
+		if vs.state == VOLUME_STATE_CREATING {
+			volumes[name] = vs.driver
+		}
+	}
+	return volumes
+}
+
+// getVolumeState returns state of volume and the error of error state. The
+// mount point tells whether volume not in other states is mounted
+func (s *daemon) getVolumeState(name, mountPoint string) (string, string) {
+	s.volumeOpsMutex.Lock()
+	deleting := s.volumesDeleting[name] || s.isVolumePendingDelete(name)
+	s.volumeOpsMutex.Unlock()
+	if deleting {
+		return VOLUME_STATE_DELETING, ""
+	}
+
+	s.volumeStateMutex.Lock()
+	defer s.volumeStateMutex.Unlock()
+
+	if vs, exists := s.volumeStates[name]; exists {
+		if vs.backups > 0 {
+			return VOLUME_STATE_BACKING_UP, ""
+		}
+		switch vs.state {
+		case VOLUME_STATE_CREATING, VOLUME_STATE_ATTACHING, VOLUME_STATE_ERROR:
+			return vs.state, vs.err
+		}
+	}
+	if mountPoint != "" {
+		return VOLUME_STATE_MOUNTED, ""
+	}
+	return VOLUME_STATE_DETACHED, ""
+}
+
+// creatingVolumeResponse answers for volume being created, which driver
+// cannot tell about yet
+func creatingVolumeResponse(name, driverName string) *api.VolumeResponse {
+	return &api.VolumeResponse{
+		Name:   name,
+		Driver: driverName,
+		State:  VOLUME_STATE_CREATING,
+	}
+}
+
+// legacyVolumeState returns state of volume for clients before
+// VOLUME_STATE_API_VERSION, which are told mounted, unmounted or deleting by
+// mount point
+func legacyVolumeState(resp *api.VolumeResponse) string {
+	if resp.State == VOLUME_STATE_DELETING {
+		return VOLUME_STATE_DELETING
+	}
+	if resp.MountPoint != "" {
+		return VOLUME_STATE_MOUNTED
+	}
+	return VOLUME_STATE_UNMOUNTED
+}
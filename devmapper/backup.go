@@ -137,11 +137,16 @@ func (d *Driver) CreateBackup(snapshotID, volumeID, destURL string, opts map[str
 		Size:        volume.Size,
 		CreatedTime: opts[convoydriver.OPT_VOLUME_CREATED_TIME],
 	}
+	labels, err := util.DecodeLabels(opts[convoydriver.OPT_BACKUP_LABELS])
+	if err != nil {
+		return "", err
+	}
 	objSnapshot := &objectstore.Snapshot{
 		Name:        snapshotID,
 		CreatedTime: opts[convoydriver.OPT_SNAPSHOT_CREATED_TIME],
+		Labels:      labels,
 	}
-	return objectstore.CreateDeltaBlockBackup(objVolume, objSnapshot, destURL, d)
+	return objectstore.CreateDeltaBlockBackup(objVolume, objSnapshot, destURL, volume.BackupBlockSize, d)
 }
 
 func (d *Driver) DeleteBackup(backupURL string) error {
@@ -36,6 +36,9 @@ const (
 	DM_THINPOOL_BLOCK_SIZE = "dm.thinpoolblocksize"
 	DM_DEFAULT_VOLUME_SIZE = "dm.defaultvolumesize"
 	DM_DEFAULT_FS_TYPE     = "dm.fs"
+	DM_MKFS_OPTIONS        = "dm.mkfsoptions"
+	DM_MOUNT_OPTIONS       = "dm.mountoptions"
+	DM_FSFREEZE            = "dm.fsfreeze"
 
 	// as defined in device mapper thin provisioning
 	BLOCK_SIZE_MIN        = 128
@@ -76,8 +79,17 @@ type Volume struct {
 	CreatedTime string
 	Snapshots   map[string]Snapshot
 
-	configPath string
-	Filesystem string
+	configPath   string
+	Filesystem   string
+	MkfsOptions  string
+	MountOptions string
+	// EncryptionKey is ID of the key, see util.InitEncryptionKeySources()
+	EncryptionKey string
+	// BackupBlockSize overrides the default block size of backups, if not 0
+	BackupBlockSize int64
+	// FilesystemSize is the size of device filesystem was last grown to, if
+	// volume has been resized since, otherwise 0
+	FilesystemSize int64
 }
 
 type Snapshot struct {
@@ -102,7 +114,7 @@ func (v *Volume) GetDevice() (string, error) {
 }
 
 func (v *Volume) GetMountOpts() []string {
-	return []string{}
+	return util.GetFilesystemMountOpts(v.Filesystem, v.MountOptions)
 }
 
 func (v *Volume) GenerateDefaultMountPoint() string {
@@ -119,6 +131,17 @@ type Device struct {
 	DefaultVolumeSize int64
 	LastDevID         int
 	Filesystem        string
+	MkfsOptions       string
+	MountOptions      string
+	DataThreshold     int64
+	MetadataThreshold int64
+	AutoExtend        bool
+	AutoExtendSize    int64
+	MonitorInterval   int64
+	FsFreeze          bool
+
+	MetadataBackupDest     string
+	MetadataBackupInterval int64
 }
 
 func (dev *Device) ConfigFile() (string, error) {
@@ -142,6 +165,9 @@ func init() {
 	if err := Register(DRIVER_NAME, Init); err != nil {
 		panic(err)
 	}
+	if err := RegisterRecover(DRIVER_NAME, recoverDriver); err != nil {
+		panic(err)
+	}
 }
 
 func (device *Device) listVolumeNames() ([]string, error) {
@@ -196,20 +222,30 @@ func verifyConfig(config map[string]string) (*Device, error) {
 		config[DM_DEFAULT_FS_TYPE] = DEFAULT_FS_TYPE
 	}
 	fs_type := config[DM_DEFAULT_FS_TYPE]
-	if !fsSupported(fs_type) {
-		return nil, fmt.Errorf("Unsupported filesystem type specified")
+	if err := util.CheckFilesystem(fs_type); err != nil {
+		return nil, err
 	}
 	dv.Filesystem = fs_type
 
-	return &dv, nil
-}
+	if err := util.CheckMountOptions(config[DM_MOUNT_OPTIONS]); err != nil {
+		return nil, err
+	}
+	dv.MkfsOptions = config[DM_MKFS_OPTIONS]
+	dv.MountOptions = config[DM_MOUNT_OPTIONS]
+	if config[DM_FSFREEZE] != "" {
+		if dv.FsFreeze, err = strconv.ParseBool(config[DM_FSFREEZE]); err != nil {
+			return nil, fmt.Errorf("Illegal %v %v", DM_FSFREEZE, config[DM_FSFREEZE])
+		}
+	}
 
-func fsSupported(fs_type string) bool {
-	if fs_type == "ext4" || fs_type == "xfs" {
-		return true
+	if err := verifyMonitorConfig(&dv, config); err != nil {
+		return nil, err
+	}
+	if err := verifyMetadataBackupConfig(&dv, config); err != nil {
+		return nil, err
 	}
 
-	return false
+	return &dv, nil
 }
 
 func (d *Driver) activatePool() error {
@@ -273,7 +309,8 @@ func (logger *DMLogger) DMLog(level int, file string, line int, dmError int, mes
 	}
 }
 
-func (d *Driver) remountVolumes() error {
+// upgradeVolumes fills in filesystem of volumes created before it's recorded
+func (d *Driver) upgradeVolumes() error {
 	volumeIDs, err := d.listVolumeNames()
 	if err != nil {
 		return err
@@ -291,19 +328,77 @@ func (d *Driver) remountVolumes() error {
 				return err
 			}
 		}
+	}
+	return err
+}
 
-		if volume.MountPoint == "" {
+/*
+Reconcile activates devices of volumes missing from device-mapper, mounts
+volumes which were mounted before, cleans up mounts left behind by volumes not
+mounted, and deactivates snapshot devices left activated by backups
+interrupted.
+*/
+func (d *Driver) Reconcile() ([]Reconciliation, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := util.ListMounts()
+	if err != nil {
+		return nil, err
+	}
+	results := []Reconciliation{}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			results = append(results, Reconciliation{Volume: id, Err: err})
 			continue
 		}
-		req := Request{
-			Name:    id,
-			Options: map[string]string{},
+		actions, err := d.reconcileVolume(volume, mounts)
+		for _, action := range actions {
+			results = append(results, Reconciliation{Volume: id, Action: action})
 		}
-		if _, err := d.MountVolume(req); err != nil {
-			return err
+		if err != nil {
+			results = append(results, Reconciliation{Volume: id, Err: err})
+		}
+		if len(actions) != 0 {
+			if err := util.ObjectSave(volume); err != nil {
+				results = append(results, Reconciliation{Volume: id, Err: err})
+			}
 		}
 	}
-	return err
+	return results, nil
+}
+
+func (d *Driver) reconcileVolume(volume *Volume, mounts string) ([]string, error) {
+	actions := []string{}
+	for id, snapshot := range volume.Snapshots {
+		if !snapshot.Activated {
+			continue
+		}
+		if _, err := os.Stat(devPath(id)); err == nil {
+			if err := devicemapper.RemoveDevice(id); err != nil {
+				return actions, err
+			}
+		}
+		snapshot.Activated = false
+		volume.Snapshots[id] = snapshot
+		actions = append(actions, "deactivated device of snapshot "+id)
+	}
+	if _, err := os.Stat(devPath(volume.Name)); os.IsNotExist(err) {
+		if err := devicemapper.ActivateDevice(d.ThinpoolDevice, volume.Name, volume.DevID, uint64(volume.Size)); err != nil {
+			return actions, err
+		}
+		actions = append(actions, "activated device")
+	}
+	action, err := util.ReconcileVolumeMount(volume, mounts)
+	if action != "" {
+		actions = append(actions, action)
+	}
+	return actions, err
 }
 
 func Init(root string, config map[string]string) (ConvoyDriver, error) {
@@ -328,6 +423,15 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 		if err := util.ObjectLoad(dev); err != nil {
 			return nil, err
 		}
+		// For upgrade case
+		if dev.DataThreshold == 0 {
+			if err := verifyMonitorConfig(dev, map[string]string{}); err != nil {
+				return nil, err
+			}
+			if err := util.ObjectSave(dev); err != nil {
+				return nil, err
+			}
+		}
 		d := &Driver{
 			mutex:      &sync.RWMutex{},
 			devIDMutex: &sync.Mutex{},
@@ -336,9 +440,11 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 		if err := d.activatePool(); err != nil {
 			return nil, err
 		}
-		if err := d.remountVolumes(); err != nil {
+		if err := d.upgradeVolumes(); err != nil {
 			return nil, err
 		}
+		d.startPoolMonitor()
+		d.startMetadataBackup()
 		return d, nil
 	}
 
@@ -380,9 +486,24 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 		devIDMutex: &sync.Mutex{},
 		Device:     *dev,
 	}
+	d.startPoolMonitor()
+	d.startMetadataBackup()
 	return d, nil
 }
 
+func (d *Driver) Capabilities() Capabilities {
+	return Capabilities{
+		Snapshot:         true,
+		Backup:           true,
+		Resize:           true,
+		Clone:            true,
+		CustomMountPoint: true,
+		ReadOnlyMount:    true,
+		Encryption:       true,
+		SELinuxLabel:     true,
+	}
+}
+
 func (d *Driver) VolumeOps() (VolumeOperations, error) {
 	return d, nil
 }
@@ -436,6 +557,10 @@ func (d *Driver) CreateVolume(req Request) error {
 	id := req.Name
 	opts := req.Options
 
+	if sourceName := opts[OPT_CLONE_VOLUME]; sourceName != "" {
+		return d.cloneVolume(req, sourceName)
+	}
+
 	backupURL := opts[OPT_BACKUP_URL]
 	if backupURL != "" {
 		objVolume, err := objectstore.LoadVolume(backupURL)
@@ -463,6 +588,44 @@ func (d *Driver) CreateVolume(req Request) error {
 		return fmt.Errorf("Size must be multiple of block size")
 
 	}
+	fsType := ""
+	if backupURL == "" {
+		fsType = opts[OPT_FILESYSTEM]
+		if fsType == "" {
+			fsType = d.Filesystem
+		}
+		if err := util.CheckFilesystem(fsType); err != nil {
+			return err
+		}
+	} else if opts[OPT_FILESYSTEM] != "" {
+		return fmt.Errorf("Cannot specify filesystem for volume restored from backup")
+	}
+	mkfsOpts := ""
+	if backupURL == "" {
+		mkfsOpts = opts[OPT_MKFS_OPTIONS]
+		if mkfsOpts == "" {
+			mkfsOpts = d.MkfsOptions
+		}
+	} else if opts[OPT_MKFS_OPTIONS] != "" {
+		return fmt.Errorf("Cannot specify mkfs options for volume restored from backup")
+	}
+	mountOpts := opts[OPT_MOUNT_OPTIONS]
+	if mountOpts == "" {
+		mountOpts = d.MountOptions
+	}
+	if err := util.CheckMountOptions(mountOpts); err != nil {
+		return err
+	}
+	encryptionKey := opts[OPT_ENCRYPTION_KEY]
+	if encryptionKey != "" {
+		if err := util.CheckEncryptionKey(encryptionKey); err != nil {
+			return err
+		}
+	}
+	backupBlockSize, err := objectstore.ParseBlockSize(opts[OPT_BACKUP_BLOCK_SIZE])
+	if err != nil {
+		return err
+	}
 	volume := d.blankVolume(id)
 	exists, err := util.ObjectExists(volume)
 	if err != nil {
@@ -523,7 +686,11 @@ func (d *Driver) CreateVolume(req Request) error {
 	volume.Size = size
 	volume.CreatedTime = util.Now()
 	volume.Snapshots = make(map[string]Snapshot)
-	volume.Filesystem = d.Filesystem
+	volume.Filesystem = fsType
+	volume.MkfsOptions = mkfsOpts
+	volume.MountOptions = mountOpts
+	volume.EncryptionKey = encryptionKey
+	volume.BackupBlockSize = backupBlockSize
 	if err := util.ObjectSave(volume); err != nil {
 		return err
 	}
@@ -534,32 +701,125 @@ func (d *Driver) CreateVolume(req Request) error {
 	}
 	if backupURL == "" {
 		// format the device
-		if err := d.createFilesystem(dev); err != nil {
-			return err
-		}
-	} else {
-		if err := objectstore.RestoreDeltaBlockBackup(backupURL, dev); err != nil {
-			return err
-		}
+		_, span := util.StartSpan(req.Context, "mkfs")
+		span.SetAttribute("fs.type", volume.Filesystem)
+		err := util.FormatVolume(volume, volume.Filesystem, volume.MkfsOptions)
+		span.End(err)
+		return err
 	}
-
-	return nil
+	_, span := util.StartSpan(req.Context, "objectstore.RestoreDeltaBlockBackup")
+	err = objectstore.RestoreDeltaBlockBackup(backupURL, dev)
+	span.End(err)
+	if err != nil {
+		return err
+	}
+	// Filesystem of the backup is unknown until restored
+	if volume.Filesystem, err = util.GetVolumeFilesystemType(volume); err != nil {
+		log.Warnf("Cannot detect filesystem of volume %v restored from backup: %v", id, err)
+	}
+	return util.ObjectSave(volume)
 }
 
-func (d *Driver) createFilesystem(dev string) error {
-	var err error
-
-	log.Debugf("Formatting device %s with %s filesystem", dev, d.Filesystem)
+/*
+cloneVolume creates the volume as a thin snapshot of the source volume,
+activated as a volume of its own. It shares blocks with the source until
+either of them writes them, but doesn't depend on it otherwise, e.g. the
+source can be deleted. Caller should hold d.mutex.
+*/
+func (d *Driver) cloneVolume(req Request, sourceName string) error {
+	id := req.Name
+	opts := req.Options
 
-	_, err = util.Execute("mkfs", []string{"-t", d.Filesystem, dev})
+	if opts[OPT_BACKUP_URL] != "" {
+		return fmt.Errorf("Cannot clone volume and restore backup at the same time")
+	}
+	if opts[OPT_FILESYSTEM] != "" || opts[OPT_MKFS_OPTIONS] != "" {
+		return fmt.Errorf("Cannot specify filesystem or mkfs options for volume cloned")
+	}
+	if opts[OPT_ENCRYPTION_KEY] != "" {
+		return fmt.Errorf("Cannot specify encryption key for volume cloned, which has the one of volume %v", sourceName)
+	}
+	source := d.blankVolume(sourceName)
+	if err := util.ObjectLoad(source); err != nil {
+		return err
+	}
+	size, err := d.getSize(opts, source.Size)
+	if err != nil {
+		return err
+	}
+	if size != source.Size {
+		return fmt.Errorf("Volume size must match with size of volume %v", sourceName)
+	}
+	mountOpts := opts[OPT_MOUNT_OPTIONS]
+	if mountOpts == "" {
+		mountOpts = util.CloneMountOptions(source.Filesystem, source.MountOptions)
+	}
+	if err := util.CheckMountOptions(mountOpts); err != nil {
+		return err
+	}
+	backupBlockSize, err := objectstore.ParseBlockSize(opts[OPT_BACKUP_BLOCK_SIZE])
+	if err != nil {
+		return err
+	}
+	if backupBlockSize == 0 {
+		backupBlockSize = source.BackupBlockSize
+	}
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return generateError(logrus.Fields{
+			LOG_FIELD_VOLUME: id,
+		}, "Already has volume with specific uuid")
+	}
 
+	devID, err := d.allocateDevID()
 	if err != nil {
-		log.Errorf("Formatting device failed")
-	} else {
-		log.Debugf("Formatting device done")
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:          LOG_REASON_START,
+		LOG_FIELD_EVENT:           LOG_EVENT_CLONE,
+		LOG_FIELD_OBJECT:          LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME:          id,
+		DM_LOG_FIELD_VOLUME_DEVID: devID,
+	}).Debugf("Cloning volume %v", sourceName)
+	if source.MountPoint != "" && d.FsFreeze {
+		thaw, err := util.FreezeFilesystem(source.MountPoint)
+		if err != nil {
+			return err
+		}
+		defer thaw()
+	}
+	if err := devicemapper.CreateSnapDevice(d.ThinpoolDevice, devID, sourceName, source.DevID); err != nil {
+		return err
+	}
+	if err := devicemapper.ActivateDevice(d.ThinpoolDevice, id, devID, uint64(size)); err != nil {
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON:          LOG_REASON_ROLLBACK,
+			LOG_FIELD_EVENT:           LOG_EVENT_REMOVE,
+			LOG_FIELD_OBJECT:          LOG_OBJECT_VOLUME,
+			LOG_FIELD_VOLUME:          id,
+			DM_LOG_FIELD_VOLUME_DEVID: devID,
+		}).Debugf("Removing device for volume due to fail to activate")
+		if err := devicemapper.DeleteDevice(d.ThinpoolDevice, devID); err != nil {
+			log.Debugf("Failed to remove device %v: %v", devID, err)
+		}
+		return err
 	}
 
-	return err
+	volume.DevID = devID
+	volume.Size = size
+	volume.CreatedTime = util.Now()
+	volume.Snapshots = make(map[string]Snapshot)
+	volume.Filesystem = source.Filesystem
+	volume.MkfsOptions = source.MkfsOptions
+	volume.MountOptions = mountOpts
+	volume.EncryptionKey = source.EncryptionKey
+	volume.BackupBlockSize = backupBlockSize
+	return util.ObjectSave(volume)
 }
 
 func devPath(name string) string {
@@ -691,6 +951,16 @@ func (d *Driver) CreateSnapshot(req Request) error {
 		DM_LOG_FIELD_VOLUME_DEVID:   volume.DevID,
 		DM_LOG_FIELD_SNAPSHOT_DEVID: devID,
 	}).Debugf("Creating snapshot")
+	// Suspending the device only flushes the filesystem directly on it,
+	// not the one on top of decrypted device of encrypted volume
+	fsFreeze, _ := strconv.ParseBool(req.Options[OPT_FSFREEZE])
+	if volume.MountPoint != "" && (d.FsFreeze || fsFreeze) {
+		thaw, err := util.FreezeFilesystem(volume.MountPoint)
+		if err != nil {
+			return err
+		}
+		defer thaw()
+	}
 	err = devicemapper.CreateSnapDevice(d.ThinpoolDevice, devID, volumeID, volume.DevID)
 	if err != nil {
 		return err
@@ -764,6 +1034,16 @@ func (d *Driver) Info() (map[string]string, error) {
 		"ThinpoolBlockSize": strconv.FormatInt(blockSize, 10),
 		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
 		"Filesystem":        d.Filesystem,
+		"MkfsOptions":       d.MkfsOptions,
+		"MountOptions":      d.MountOptions,
+		"DataThreshold":     strconv.FormatInt(d.DataThreshold, 10),
+		"MetadataThreshold": strconv.FormatInt(d.MetadataThreshold, 10),
+		"AutoExtend":        strconv.FormatBool(d.AutoExtend),
+		"AutoExtendSize":    strconv.FormatInt(d.AutoExtendSize, 10),
+	}
+	if status, err := d.getPoolStatus(); err == nil {
+		info["DataUsage"] = strconv.FormatUint(status.dataPercent(), 10)
+		info["MetadataUsage"] = strconv.FormatUint(status.metadataPercent(), 10)
 	}
 
 	return info, nil
@@ -849,17 +1129,21 @@ func (d *Driver) MountVolume(req Request) (string, error) {
 
 	id := req.Name
 	opts := req.Options
+	readOnly, _ := strconv.ParseBool(opts[OPT_READ_ONLY])
 
 	volume := d.blankVolume(id)
 	if err := util.ObjectLoad(volume); err != nil {
 		return "", err
 	}
 
-	mountPoint, err := util.VolumeMount(volume, opts[OPT_MOUNT_POINT], false)
+	mountPoint, err := util.VolumeMount(volume, opts[OPT_MOUNT_POINT], false, readOnly, opts[OPT_SELINUX_CONTEXT])
 	if err != nil {
 		return "", err
 	}
 
+	if _, err := d.growFilesystem(volume); err != nil {
+		log.Warnf("Failed to grow filesystem of volume %v: %v", id, err)
+	}
 	if err := util.ObjectSave(volume); err != nil {
 		return "", err
 	}
@@ -867,6 +1151,121 @@ func (d *Driver) MountVolume(req Request) (string, error) {
 	return mountPoint, nil
 }
 
+// growFilesystem grows filesystem of the mounted volume if its device has been
+// expanded. Caller should save the volume.
+func (d *Driver) growFilesystem(volume *Volume) (bool, error) {
+	knownSize := volume.Size
+	if volume.FilesystemSize != 0 {
+		knownSize = volume.FilesystemSize
+	}
+	size, err := util.GrowExpandedVolume(volume, volume.Filesystem, knownSize)
+	if err != nil || size == knownSize {
+		return false, err
+	}
+	if size > volume.Size {
+		volume.Size = size
+	}
+	volume.FilesystemSize = 0
+	return true, nil
+}
+
+/*
+ResizeVolume extends thin device of the volume, whose space is allocated from
+thin pool only when written, and grows the filesystem if the volume is
+mounted read-write. Otherwise filesystem would be grown on next mount.
+*/
+func (d *Driver) ResizeVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	size, err := util.ParseSize(req.Options[OPT_SIZE])
+	if err != nil {
+		return err
+	}
+	if size <= volume.Size {
+		return fmt.Errorf("Volume %v of %v bytes cannot be resized to %v bytes, it can only be expanded", id, volume.Size, size)
+	}
+	if size%(d.ThinpoolBlockSize*SECTOR_SIZE) != 0 {
+		return fmt.Errorf("Size must be multiple of block size")
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:          LOG_REASON_START,
+		LOG_FIELD_EVENT:           LOG_EVENT_EXTEND,
+		LOG_FIELD_OBJECT:          LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME:          id,
+		LOG_FIELD_SIZE:            size,
+		DM_LOG_FIELD_VOLUME_DEVID: volume.DevID,
+	}).Debugf("Extending device of volume from %v bytes", volume.Size)
+	if err := d.reloadDevice(id, volume.DevID, size); err != nil {
+		return err
+	}
+	if volume.FilesystemSize == 0 {
+		volume.FilesystemSize = volume.Size
+	}
+	volume.Size = size
+
+	var growErr error
+	if volume.MountPoint != "" {
+		_, growErr = d.growFilesystem(volume)
+	}
+	if err := util.ObjectSave(volume); err != nil {
+		return err
+	}
+	if growErr != nil {
+		return fmt.Errorf("Extended device of volume %v, but failed to grow its filesystem: %v", id, growErr)
+	}
+	return nil
+}
+
+// reloadDevice replaces table of the active thin device with the one of the
+// new size, while the device is suspended
+func (d *Driver) reloadDevice(name string, devID int, size int64) error {
+	task, err := devicemapper.TaskCreateNamed(devicemapper.DeviceReload, name)
+	if task == nil {
+		return err
+	}
+	params := fmt.Sprintf("%s %d", d.ThinpoolDevice, devID)
+	if err := task.AddTarget(0, uint64(size/SECTOR_SIZE), "thin", params); err != nil {
+		return fmt.Errorf("Can't add target %s", err)
+	}
+	if err := devicemapper.SuspendDevice(name); err != nil {
+		return err
+	}
+	if err := task.Run(); err != nil {
+		devicemapper.ResumeDevice(name)
+		return fmt.Errorf("Error running DeviceReload %s", err)
+	}
+	return devicemapper.ResumeDevice(name)
+}
+
+// GrowFilesystems grows filesystems of mounted volumes whose devices have been
+// expanded, returns their new sizes
+func (d *Driver) GrowFilesystems() (map[string]int64, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	return util.GrowMountedFilesystems(volumeIDs, func(id string) (int64, error) {
+		volume := d.blankVolume(id)
+		grown, err := util.GrowMountedVolume(volume, func() (bool, error) {
+			return d.growFilesystem(volume)
+		})
+		if err != nil || !grown {
+			return 0, err
+		}
+		return volume.Size, nil
+	}), nil
+}
+
 func (d *Driver) UmountVolume(req Request) error {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
@@ -922,6 +1321,10 @@ func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
 		OPT_MOUNT_POINT:         volume.MountPoint,
 		OPT_SIZE:                strconv.FormatInt(volume.Size, 10),
 		OPT_FILESYSTEM:          volume.Filesystem,
+		OPT_MKFS_OPTIONS:        volume.MkfsOptions,
+		OPT_MOUNT_OPTIONS:       volume.MountOptions,
+		OPT_ENCRYPTION_KEY:      volume.EncryptionKey,
+		OPT_BACKUP_BLOCK_SIZE:   objectstore.FormatBlockSize(volume.BackupBlockSize),
 	}
 	return result, nil
 }
@@ -0,0 +1,179 @@
+// +build linux
+
+package devmapper
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DM_METADATA_BACKUP_DEST     = "dm.metadatabackupdest"
+	DM_METADATA_BACKUP_INTERVAL = "dm.metadatabackupinterval"
+
+	DEFAULT_METADATA_BACKUP_INTERVAL = 3600
+)
+
+// metadataBackup is the driver state exported to objectstore, enough to
+// rebuild the config directory of the driver
+type metadataBackup struct {
+	Device      Device
+	Volumes     []Volume
+	Host        string
+	CreatedTime string
+}
+
+func getMetadataBackupName(host string) string {
+	return host + "_" + DRIVER_NAME
+}
+
+func verifyMetadataBackupConfig(dv *Device, config map[string]string) error {
+	dv.MetadataBackupDest = config[DM_METADATA_BACKUP_DEST]
+	dv.MetadataBackupInterval = DEFAULT_METADATA_BACKUP_INTERVAL
+	if config[DM_METADATA_BACKUP_INTERVAL] != "" {
+		interval, err := strconv.ParseInt(config[DM_METADATA_BACKUP_INTERVAL], 10, 64)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("Illegal %v %v", DM_METADATA_BACKUP_INTERVAL, config[DM_METADATA_BACKUP_INTERVAL])
+		}
+		dv.MetadataBackupInterval = interval
+	}
+	if dv.MetadataBackupDest != "" {
+		if _, err := objectstore.GetObjectStoreDriver(dv.MetadataBackupDest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) exportMetadata() error {
+	host, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	backup, err := d.snapshotMetadata(host)
+	if err != nil {
+		return err
+	}
+	// Uploaded without the lock, so volume operations won't wait for it
+	name := getMetadataBackupName(host)
+	if err := objectstore.SaveRecoveryConfig(d.MetadataBackupDest, name, backup); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_CONFIG,
+		LOG_FIELD_DEST_URL: d.MetadataBackupDest,
+	}).Debugf("Exported metadata of %v volumes", len(backup.Volumes))
+	return nil
+}
+
+// snapshotMetadata returns a consistent copy of driver and volume configs
+func (d *Driver) snapshotMetadata(host string) (*metadataBackup, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	backup := &metadataBackup{
+		Device:      d.Device,
+		Volumes:     []Volume{},
+		Host:        host,
+		CreatedTime: util.Now(),
+	}
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		backup.Volumes = append(backup.Volumes, *volume)
+	}
+	return backup, nil
+}
+
+func (d *Driver) startMetadataBackup() {
+	if d.MetadataBackupDest == "" {
+		return
+	}
+	go func() {
+		for {
+			if err := d.exportMetadata(); err != nil {
+				log.WithFields(logrus.Fields{
+					LOG_FIELD_REASON:   LOG_REASON_FAILURE,
+					LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
+					LOG_FIELD_OBJECT:   LOG_OBJECT_CONFIG,
+					LOG_FIELD_DEST_URL: d.MetadataBackupDest,
+				}).Errorf("Failed to export devicemapper metadata: %v", err)
+			}
+			time.Sleep(time.Duration(d.MetadataBackupInterval) * time.Second)
+		}
+	}()
+}
+
+/*
+recoverDriver would rebuild the driver configuration at root from metadata
+exported by host. The thin pool itself is expected to be intact on the
+data and metadata devices, only the configuration mapping volumes to thin
+device IDs is restored. Volumes would be left unmounted.
+*/
+func recoverDriver(root, destURL, host string) error {
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Driver config already exists at %v, refuse to overwrite", root)
+	}
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+
+	backup := &metadataBackup{}
+	name := getMetadataBackupName(host)
+	if err := objectstore.LoadRecoveryConfig(destURL, name, backup); err != nil {
+		return fmt.Errorf("Cannot load metadata backup %v at %v: %v", name, destURL, err)
+	}
+
+	if err := util.MkdirIfNotExists(root); err != nil {
+		return err
+	}
+	backup.Device.Root = root
+	for _, volume := range backup.Volumes {
+		volume.configPath = root
+		volume.MountPoint = ""
+		for id, snapshot := range volume.Snapshots {
+			snapshot.Activated = false
+			volume.Snapshots[id] = snapshot
+		}
+		if err := util.ObjectSave(&volume); err != nil {
+			return err
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+			LOG_FIELD_EVENT:  LOG_EVENT_RESTORE,
+			LOG_FIELD_VOLUME: volume.Name,
+		}).Debug("Recovered volume config")
+	}
+	// Save device config last, so an interrupted recovery can be retried
+	if err := util.ObjectSave(&backup.Device); err != nil {
+		return err
+	}
+	log.Infof("Recovered %v volumes of devicemapper from metadata exported by %v at %v",
+		len(backup.Volumes), backup.Host, backup.CreatedTime)
+	return nil
+}
@@ -0,0 +1,431 @@
+// +build linux
+
+package devmapper
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/devicemapper"
+	"github.com/rancher/convoy/convoydriver"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DM_DATA_THRESHOLD     = "dm.datathreshold"
+	DM_METADATA_THRESHOLD = "dm.metadatathreshold"
+	DM_AUTO_EXTEND        = "dm.autoextend"
+	DM_AUTO_EXTEND_SIZE   = "dm.autoextendsize"
+	DM_MONITOR_INTERVAL   = "dm.monitorinterval"
+
+	DEFAULT_DATA_THRESHOLD     = 80
+	DEFAULT_METADATA_THRESHOLD = 80
+	DEFAULT_AUTO_EXTEND_SIZE   = "10G"
+	DEFAULT_MONITOR_INTERVAL   = 60
+
+	LOSETUP_BINARY = "losetup"
+
+	// Metadata of thin pool is in blocks of 4KiB, regardless of data block
+	// size
+	THIN_METADATA_BLOCK_SIZE = 4096
+)
+
+type poolStatus struct {
+	TransactionID  uint64
+	UsedMetadata   uint64
+	TotalMetadata  uint64
+	UsedData       uint64
+	TotalData      uint64
+	ReadOnly       bool
+	OutOfDataSpace bool
+}
+
+func (s *poolStatus) dataPercent() uint64 {
+	if s.TotalData == 0 {
+		return 0
+	}
+	return s.UsedData * 100 / s.TotalData
+}
+
+func (s *poolStatus) metadataPercent() uint64 {
+	if s.TotalMetadata == 0 {
+		return 0
+	}
+	return s.UsedMetadata * 100 / s.TotalMetadata
+}
+
+func parseUsage(field string) (uint64, uint64, error) {
+	parts := strings.Split(field, "/")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid usage %v", field)
+	}
+	used, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	total, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return used, total, nil
+}
+
+/*
+parsePoolStatus parses the status line of thin-pool target, in format of:
+
+<transaction id> <used metadata blocks>/<total metadata blocks>
+<used data blocks>/<total data blocks> <held metadata root> ro|rw|out_of_data_space
+...
+*/
+func parsePoolStatus(params string) (*poolStatus, error) {
+	fields := strings.Fields(params)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("Invalid thin pool status %v", params)
+	}
+	status := &poolStatus{}
+	var err error
+	if status.TransactionID, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return nil, fmt.Errorf("Invalid thin pool status %v: %v", params, err)
+	}
+	if status.UsedMetadata, status.TotalMetadata, err = parseUsage(fields[1]); err != nil {
+		return nil, fmt.Errorf("Invalid thin pool status %v: %v", params, err)
+	}
+	if status.UsedData, status.TotalData, err = parseUsage(fields[2]); err != nil {
+		return nil, fmt.Errorf("Invalid thin pool status %v: %v", params, err)
+	}
+	if len(fields) > 4 {
+		status.ReadOnly = fields[4] == "ro"
+		status.OutOfDataSpace = fields[4] == "out_of_data_space"
+	}
+	return status, nil
+}
+
+func (d *Driver) getPoolStatus() (*poolStatus, error) {
+	_, _, targetType, params, err := devicemapper.GetStatus(d.ThinpoolDevice)
+	if err != nil {
+		return nil, err
+	}
+	if targetType != "thin-pool" {
+		return nil, fmt.Errorf("Device %v is not a thin pool but %v", d.ThinpoolDevice, targetType)
+	}
+	return parsePoolStatus(params)
+}
+
+func parsePercent(config map[string]string, key string, defaultValue int64) (int64, error) {
+	value, exists := config[key]
+	if !exists {
+		return defaultValue, nil
+	}
+	percent, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return 0, fmt.Errorf("Illegal %v %v, must be a percentage between 1 and 100", key, value)
+	}
+	return percent, nil
+}
+
+func verifyMonitorConfig(dv *Device, config map[string]string) error {
+	var err error
+	if dv.DataThreshold, err = parsePercent(config, DM_DATA_THRESHOLD, DEFAULT_DATA_THRESHOLD); err != nil {
+		return err
+	}
+	if dv.MetadataThreshold, err = parsePercent(config, DM_METADATA_THRESHOLD, DEFAULT_METADATA_THRESHOLD); err != nil {
+		return err
+	}
+	if config[DM_AUTO_EXTEND] != "" {
+		if dv.AutoExtend, err = strconv.ParseBool(config[DM_AUTO_EXTEND]); err != nil {
+			return fmt.Errorf("Illegal %v %v", DM_AUTO_EXTEND, config[DM_AUTO_EXTEND])
+		}
+	}
+	if _, exists := config[DM_AUTO_EXTEND_SIZE]; !exists {
+		config[DM_AUTO_EXTEND_SIZE] = DEFAULT_AUTO_EXTEND_SIZE
+	}
+	if dv.AutoExtendSize, err = util.ParseSize(config[DM_AUTO_EXTEND_SIZE]); err != nil || dv.AutoExtendSize <= 0 {
+		return fmt.Errorf("Illegal %v %v", DM_AUTO_EXTEND_SIZE, config[DM_AUTO_EXTEND_SIZE])
+	}
+	dv.MonitorInterval = DEFAULT_MONITOR_INTERVAL
+	if config[DM_MONITOR_INTERVAL] != "" {
+		if dv.MonitorInterval, err = strconv.ParseInt(config[DM_MONITOR_INTERVAL], 10, 64); err != nil || dv.MonitorInterval < 0 {
+			return fmt.Errorf("Illegal %v %v", DM_MONITOR_INTERVAL, config[DM_MONITOR_INTERVAL])
+		}
+	}
+	return nil
+}
+
+// startPoolMonitor would check pool usage periodically. Setting
+// dm.monitorinterval to 0 disables the monitor.
+func (d *Driver) startPoolMonitor() {
+	if d.MonitorInterval == 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(time.Duration(d.MonitorInterval) * time.Second) {
+			if err := d.checkPool(); err != nil {
+				log.WithFields(logrus.Fields{
+					LOG_FIELD_REASON: LOG_REASON_FAILURE,
+					LOG_FIELD_EVENT:  LOG_EVENT_MONITOR,
+					LOG_FIELD_OBJECT: LOG_OBJECT_DRIVER,
+				}).Errorf("Failed to check thin pool %v: %v", d.ThinpoolDevice, err)
+			}
+		}
+	}()
+}
+
+func (d *Driver) checkPool() error {
+	status, err := d.getPoolStatus()
+	if err != nil {
+		return err
+	}
+	fields := logrus.Fields{
+		LOG_FIELD_EVENT:  LOG_EVENT_MONITOR,
+		LOG_FIELD_OBJECT: LOG_OBJECT_DRIVER,
+		"data_usage":     status.dataPercent(),
+		"metadata_usage": status.metadataPercent(),
+	}
+	if status.ReadOnly || status.OutOfDataSpace {
+		log.WithFields(fields).Errorf("Thin pool %v is out of space and no longer writable", d.ThinpoolDevice)
+	}
+	if int64(status.metadataPercent()) >= d.MetadataThreshold {
+		// Metadata device cannot be extended online by us, warn only
+		log.WithFields(fields).Warnf("Thin pool %v metadata usage %v%% reached threshold %v%%",
+			d.ThinpoolDevice, status.metadataPercent(), d.MetadataThreshold)
+	}
+	if int64(status.dataPercent()) < d.DataThreshold {
+		return nil
+	}
+	log.WithFields(fields).Warnf("Thin pool %v data usage %v%% reached threshold %v%%",
+		d.ThinpoolDevice, status.dataPercent(), d.DataThreshold)
+	if !d.AutoExtend {
+		return nil
+	}
+	return d.extendPool()
+}
+
+// Alerts reports usage of thin pool reaching thresholds, or pool out of space
+func (d *Driver) Alerts() ([]convoydriver.Alert, error) {
+	status, err := d.getPoolStatus()
+	if err != nil {
+		return nil, err
+	}
+	alerts := []convoydriver.Alert{}
+	if status.ReadOnly || status.OutOfDataSpace {
+		alerts = append(alerts, convoydriver.Alert{
+			Name:   "out_of_space",
+			Detail: fmt.Sprintf("Thin pool %v is out of space and no longer writable", d.ThinpoolDevice),
+		})
+	}
+	if int64(status.dataPercent()) >= d.DataThreshold {
+		alerts = append(alerts, convoydriver.Alert{
+			Name: "data_space",
+			Detail: fmt.Sprintf("Thin pool %v data usage %v%% reached threshold %v%%",
+				d.ThinpoolDevice, status.dataPercent(), d.DataThreshold),
+		})
+	}
+	if int64(status.metadataPercent()) >= d.MetadataThreshold {
+		alerts = append(alerts, convoydriver.Alert{
+			Name: "metadata_space",
+			Detail: fmt.Sprintf("Thin pool %v metadata usage %v%% reached threshold %v%%",
+				d.ThinpoolDevice, status.metadataPercent(), d.MetadataThreshold),
+		})
+	}
+	return alerts, nil
+}
+
+// Metrics reports space of thin pool, used and total
+func (d *Driver) Metrics() ([]convoydriver.Metric, error) {
+	status, err := d.getPoolStatus()
+	if err != nil {
+		return nil, err
+	}
+	dataBlockSize := float64(d.ThinpoolBlockSize * SECTOR_SIZE)
+	return []convoydriver.Metric{
+		{
+			Name:  "pool_data_used_bytes",
+			Help:  "Data space of thin pool used.",
+			Value: float64(status.UsedData) * dataBlockSize,
+		},
+		{
+			Name:  "pool_data_total_bytes",
+			Help:  "Data space of thin pool.",
+			Value: float64(status.TotalData) * dataBlockSize,
+		},
+		{
+			Name:  "pool_metadata_used_bytes",
+			Help:  "Metadata space of thin pool used.",
+			Value: float64(status.UsedMetadata * THIN_METADATA_BLOCK_SIZE),
+		},
+		{
+			Name:  "pool_metadata_total_bytes",
+			Help:  "Metadata space of thin pool.",
+			Value: float64(status.TotalMetadata * THIN_METADATA_BLOCK_SIZE),
+		},
+	}, nil
+}
+
+// CheckHealth reports the driver unhealthy if thin pool is unavailable or no
+// longer writable
+func (d *Driver) CheckHealth() error {
+	status, err := d.getPoolStatus()
+	if err != nil {
+		return err
+	}
+	if status.OutOfDataSpace {
+		return fmt.Errorf("Thin pool %v is out of data space", d.ThinpoolDevice)
+	}
+	if status.ReadOnly {
+		return fmt.Errorf("Thin pool %v is read only", d.ThinpoolDevice)
+	}
+	return nil
+}
+
+func getLoopBackingFile(dev string) (string, error) {
+	out, err := util.Execute(LOSETUP_BINARY, []string{"-n", "-O", "BACK-FILE", dev})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// growLoopBackingFile would extend the sparse file backing a loop data device
+// if the host filesystem has enough free space for it
+func (d *Driver) growLoopBackingFile(size int64) (bool, error) {
+	file, err := getLoopBackingFile(d.DataDevice)
+	if err != nil || file == "" {
+		// Not a loop device
+		return false, nil
+	}
+	st, err := os.Stat(file)
+	if err != nil {
+		return false, err
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(file, &fs); err != nil {
+		return false, err
+	}
+	if available := int64(fs.Bavail) * int64(fs.Bsize); available < size {
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_EVENT:    LOG_EVENT_EXTEND,
+			LOG_FIELD_FILEPATH: file,
+		}).Warnf("Not enough space to extend thin pool, %v bytes available, %v bytes needed", available, size)
+		return false, nil
+	}
+	if err := os.Truncate(file, st.Size()+size); err != nil {
+		return false, err
+	}
+	if _, err := util.Execute(LOSETUP_BINARY, []string{"-c", d.DataDevice}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+/*
+extendPool would reload the thin pool with the current size of data device.
+If the data device is a loop device, its backing file would be extended by
+dm.autoextendsize first. Otherwise the data device needs to be enlarged by
+other means, e.g. lvextend, before pool can make use of it.
+*/
+func (d *Driver) extendPool() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, err := d.growLoopBackingFile(d.AutoExtendSize); err != nil {
+		return err
+	}
+
+	dataDev, err := os.Open(d.DataDevice)
+	if err != nil {
+		return err
+	}
+	defer dataDev.Close()
+
+	metadataDev, err := os.Open(d.MetadataDevice)
+	if err != nil {
+		return err
+	}
+	defer metadataDev.Close()
+
+	size, err := devicemapper.GetBlockDeviceSize(dataDev)
+	if err != nil {
+		return err
+	}
+	if int64(size) <= d.ThinpoolSize {
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_EVENT:  LOG_EVENT_EXTEND,
+			LOG_FIELD_OBJECT: LOG_OBJECT_DRIVER,
+		}).Warnf("No backing space available to extend thin pool %v", d.ThinpoolDevice)
+		return nil
+	}
+
+	poolName := d.ThinpoolDevice
+	if err := devicemapper.SuspendDevice(poolName); err != nil {
+		return err
+	}
+	if err := devicemapper.ReloadPool(poolName, dataDev, metadataDev, uint32(d.ThinpoolBlockSize)); err != nil {
+		devicemapper.ResumeDevice(poolName)
+		return err
+	}
+	if err := devicemapper.ResumeDevice(poolName); err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:  LOG_EVENT_EXTEND,
+		LOG_FIELD_OBJECT: LOG_OBJECT_DRIVER,
+		LOG_FIELD_SIZE:   size,
+	}).Infof("Extended thin pool %v from %v to %v bytes", poolName, d.ThinpoolSize, size)
+	d.ThinpoolSize = int64(size)
+	return util.ObjectSave(&d.Device)
+}
+
+/*
+parseThinStatus parses the status line of thin target, in format of:
+
+<nr mapped sectors> <highest mapped sector>
+
+It returns the sectors of the thin device allocated in the pool.
+*/
+func parseThinStatus(params string) (int64, error) {
+	fields := strings.Fields(params)
+	if len(fields) < 1 {
+		return 0, fmt.Errorf("Invalid thin status %v", params)
+	}
+	if fields[0] == "Fail" {
+		return 0, fmt.Errorf("Thin device failed")
+	}
+	mapped, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid thin status %v: %v", params, err)
+	}
+	return mapped, nil
+}
+
+// VolumeUsage reports data space of thin pool allocated to the volume.
+// Snapshots share the blocks with the volume, so they're not counted.
+func (d *Driver) VolumeUsage(name string) (int64, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return 0, err
+	}
+	_, _, targetType, params, err := devicemapper.GetStatus(volume.Name)
+	if err != nil {
+		return 0, err
+	}
+	if targetType != "thin" {
+		return 0, fmt.Errorf("Device of volume %v is not a thin device but %v", volume.Name, targetType)
+	}
+	mapped, err := parseThinStatus(params)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot get usage of volume %v: %v", volume.Name, err)
+	}
+	return mapped * SECTOR_SIZE, nil
+}
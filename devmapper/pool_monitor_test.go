@@ -0,0 +1,145 @@
+// +build linux
+
+package devmapper
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Unlike the tests of devmapper_test.go, these don't need device mapper, so
+// they run without the devmapper build tag
+
+func TestParsePoolStatus(t *testing.T) {
+	testCases := []struct {
+		params string
+		status *poolStatus
+	}{
+		// Kernels before 3.5 have no mode
+		{"0 141/4161600 0/1638400 -", &poolStatus{
+			TransactionID: 0,
+			UsedMetadata:  141,
+			TotalMetadata: 4161600,
+			UsedData:      0,
+			TotalData:     1638400,
+		}},
+		{"3 1024/4161600 819200/1638400 - rw discard_passdown queue_if_no_space - 1024", &poolStatus{
+			TransactionID: 3,
+			UsedMetadata:  1024,
+			TotalMetadata: 4161600,
+			UsedData:      819200,
+			TotalData:     1638400,
+		}},
+		{"7 2048/4161600 1638400/1638400 - out_of_data_space discard_passdown error_if_no_space -", &poolStatus{
+			TransactionID:  7,
+			UsedMetadata:   2048,
+			TotalMetadata:  4161600,
+			UsedData:       1638400,
+			TotalData:      1638400,
+			OutOfDataSpace: true,
+		}},
+		{"9 4161600/4161600 1024/1638400 - ro no_discard_passdown queue_if_no_space needs_check", &poolStatus{
+			TransactionID: 9,
+			UsedMetadata:  4161600,
+			TotalMetadata: 4161600,
+			UsedData:      1024,
+			TotalData:     1638400,
+			ReadOnly:      true,
+		}},
+		{"Fail", nil},
+		{"", nil},
+		{"0 141/4161600 0/1638400", nil},
+		{"x 141/4161600 0/1638400 -", nil},
+		{"0 141 0/1638400 -", nil},
+		{"0 141/4161600 0/x -", nil},
+		{"0 141/4161600 -1/1638400 -", nil},
+	}
+	for _, tc := range testCases {
+		status, err := parsePoolStatus(tc.params)
+		if tc.status == nil {
+			if err == nil {
+				t.Errorf("parsePoolStatus(%q) = %+v, expected error", tc.params, status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePoolStatus(%q) failed: %v", tc.params, err)
+			continue
+		}
+		if !reflect.DeepEqual(status, tc.status) {
+			t.Errorf("parsePoolStatus(%q) = %+v, expected %+v", tc.params, status, tc.status)
+		}
+	}
+
+	status, _ := parsePoolStatus("3 1040/4161600 819200/1638400 -")
+	if status.dataPercent() != 50 || status.metadataPercent() != 0 {
+		t.Errorf("Usage of %+v is %v%% data and %v%% metadata, expected 50%% and 0%%",
+			status, status.dataPercent(), status.metadataPercent())
+	}
+}
+
+func TestVerifyMonitorConfig(t *testing.T) {
+	testCases := []struct {
+		config map[string]string
+		device *Device
+	}{
+		{map[string]string{}, &Device{
+			DataThreshold:     DEFAULT_DATA_THRESHOLD,
+			MetadataThreshold: DEFAULT_METADATA_THRESHOLD,
+			AutoExtendSize:    10 << 30,
+			MonitorInterval:   DEFAULT_MONITOR_INTERVAL,
+		}},
+		{map[string]string{
+			DM_DATA_THRESHOLD:     "1",
+			DM_METADATA_THRESHOLD: "100",
+			DM_AUTO_EXTEND:        "true",
+			DM_AUTO_EXTEND_SIZE:   "512M",
+			DM_MONITOR_INTERVAL:   "10",
+		}, &Device{
+			DataThreshold:     1,
+			MetadataThreshold: 100,
+			AutoExtend:        true,
+			AutoExtendSize:    512 << 20,
+			MonitorInterval:   10,
+		}},
+		// Monitor is disabled by interval 0
+		{map[string]string{DM_MONITOR_INTERVAL: "0"}, &Device{
+			DataThreshold:     DEFAULT_DATA_THRESHOLD,
+			MetadataThreshold: DEFAULT_METADATA_THRESHOLD,
+			AutoExtendSize:    10 << 30,
+		}},
+		{map[string]string{DM_DATA_THRESHOLD: "0"}, nil},
+		{map[string]string{DM_DATA_THRESHOLD: "101"}, nil},
+		{map[string]string{DM_DATA_THRESHOLD: "-5"}, nil},
+		{map[string]string{DM_DATA_THRESHOLD: "80%"}, nil},
+		{map[string]string{DM_METADATA_THRESHOLD: "0"}, nil},
+		{map[string]string{DM_METADATA_THRESHOLD: "200"}, nil},
+		{map[string]string{DM_AUTO_EXTEND: "sometimes"}, nil},
+		{map[string]string{DM_AUTO_EXTEND_SIZE: "0"}, nil},
+		{map[string]string{DM_AUTO_EXTEND_SIZE: "-1G"}, nil},
+		{map[string]string{DM_AUTO_EXTEND_SIZE: "ten"}, nil},
+		{map[string]string{DM_MONITOR_INTERVAL: "-1"}, nil},
+		{map[string]string{DM_MONITOR_INTERVAL: "1m"}, nil},
+	}
+	for _, tc := range testCases {
+		dv := &Device{}
+		err := verifyMonitorConfig(dv, tc.config)
+		if tc.device == nil {
+			if err == nil {
+				t.Errorf("verifyMonitorConfig(%v) = %+v, expected error", tc.config, dv)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("verifyMonitorConfig(%v) failed: %v", tc.config, err)
+			continue
+		}
+		if dv.DataThreshold != tc.device.DataThreshold ||
+			dv.MetadataThreshold != tc.device.MetadataThreshold ||
+			dv.AutoExtend != tc.device.AutoExtend ||
+			dv.AutoExtendSize != tc.device.AutoExtendSize ||
+			dv.MonitorInterval != tc.device.MonitorInterval {
+			t.Errorf("verifyMonitorConfig(%v) = %+v, expected %+v", tc.config, dv, tc.device)
+		}
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	. "github.com/rancher/convoy/convoydriver"
 	"github.com/rancher/convoy/util"
 )
@@ -21,6 +23,9 @@ const (
 
 	DO_DEFAULT_VOLUME_SIZE = "do.defaultvolumesize"
 	DEFAULT_VOLUME_SIZE    = "10G"
+	DO_DEFAULT_FS_TYPE     = "do.fs"
+	DO_MKFS_OPTIONS        = "do.mkfsoptions"
+	DO_MOUNT_OPTIONS       = "do.mountoptions"
 
 	DO_DEVICE_FOLDER = "/dev/disk/by-id"
 	DO_DEVICE_PREFIX = "scsi-0DO_Volume_"
@@ -31,6 +36,10 @@ const (
 	GB = 1073741824
 )
 
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "digitalocean"})
+)
+
 // Driver is a convoy driver for DigitalOcean volumes
 type Driver struct {
 	mutex  *sync.RWMutex
@@ -41,6 +50,10 @@ type Driver struct {
 type Device struct {
 	Root              string
 	DefaultVolumeSize int64
+	// Empty for config created before filesystem is configurable
+	DefaultFilesystem string
+	MkfsOptions       string
+	MountOptions      string
 }
 
 func (d *Device) ConfigFile() (string, error) {
@@ -51,12 +64,17 @@ func (d *Device) ConfigFile() (string, error) {
 }
 
 type Volume struct {
-	Name       string
-	ID         string
-	Device     string
-	MountPoint string
-	Size       int64
-	configPath string
+	Name         string
+	ID           string
+	Device       string
+	MountPoint   string
+	Size         int64
+	Filesystem   string
+	MkfsOptions  string
+	MountOptions string
+	// EncryptionKey is ID of the key, see util.InitEncryptionKeySources()
+	EncryptionKey string
+	configPath    string
 }
 
 func (v *Volume) ConfigFile() (string, error) {
@@ -75,7 +93,7 @@ func (v *Volume) GetDevice() (string, error) {
 }
 
 func (v *Volume) GetMountOpts() []string {
-	return []string{}
+	return util.GetFilesystemMountOpts(v.Filesystem, v.MountOptions)
 }
 
 func (v *Volume) GenerateDefaultMountPoint() string {
@@ -147,10 +165,22 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 		if err != nil {
 			return nil, err
 		}
+		if config[DO_DEFAULT_FS_TYPE] == "" {
+			config[DO_DEFAULT_FS_TYPE] = DO_VOLUME_FS
+		}
+		if err := util.CheckFilesystem(config[DO_DEFAULT_FS_TYPE]); err != nil {
+			return nil, err
+		}
+		if err := util.CheckMountOptions(config[DO_MOUNT_OPTIONS]); err != nil {
+			return nil, err
+		}
 
 		dev = &Device{
 			Root:              root,
 			DefaultVolumeSize: size,
+			DefaultFilesystem: config[DO_DEFAULT_FS_TYPE],
+			MkfsOptions:       config[DO_MKFS_OPTIONS],
+			MountOptions:      config[DO_MOUNT_OPTIONS],
 		}
 		if err := util.ObjectSave(dev); err != nil {
 			return nil, err
@@ -179,10 +209,22 @@ func (d *Driver) Name() string {
 func (d *Driver) Info() (map[string]string, error) {
 	ret := map[string]string{
 		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"MkfsOptions":       d.MkfsOptions,
+		"MountOptions":      d.MountOptions,
 	}
 	return ret, nil
 }
 
+func (d *Driver) Capabilities() Capabilities {
+	return Capabilities{
+		CrossHostAttach:  true,
+		CustomMountPoint: true,
+		ReadOnlyMount:    true,
+		Encryption:       true,
+		SELinuxLabel:     true,
+	}
+}
+
 func (d *Driver) VolumeOps() (VolumeOperations, error) {
 	return d, nil
 }
@@ -208,6 +250,39 @@ func (d *Driver) CreateVolume(req Request) error {
 	}
 	// DigitalOcean Volume ID
 	vID := opt[OPT_VOLUME_DRIVER_ID]
+	fsType := opt[OPT_FILESYSTEM]
+	if fsType != "" && vID != "" {
+		return fmt.Errorf("Cannot specify filesystem for existing volume")
+	}
+	if fsType == "" {
+		fsType = d.DefaultFilesystem
+	}
+	if fsType == "" {
+		fsType = DO_VOLUME_FS
+	}
+	if err := util.CheckFilesystem(fsType); err != nil {
+		return err
+	}
+	mkfsOpts := opt[OPT_MKFS_OPTIONS]
+	if mkfsOpts != "" && vID != "" {
+		return fmt.Errorf("Cannot specify mkfs options for existing volume")
+	}
+	if mkfsOpts == "" {
+		mkfsOpts = d.MkfsOptions
+	}
+	mountOpts := opt[OPT_MOUNT_OPTIONS]
+	if mountOpts == "" {
+		mountOpts = d.MountOptions
+	}
+	if err := util.CheckMountOptions(mountOpts); err != nil {
+		return err
+	}
+	encryptionKey := opt[OPT_ENCRYPTION_KEY]
+	if encryptionKey != "" {
+		if err := util.CheckEncryptionKey(encryptionKey); err != nil {
+			return err
+		}
+	}
 	if vID != "" {
 		doVol, err := d.client.GetVolume(vID)
 		if err != nil {
@@ -230,19 +305,25 @@ func (d *Driver) CreateVolume(req Request) error {
 		format = true
 	}
 
+	attachStart := time.Now()
 	if err := d.client.AttachVolume(vID); err != nil {
 		return err
 	}
+	util.ObserveLatency(util.LATENCY_ATTACH, id, attachStart)
 
 	vol.Name = id
 	vol.ID = vID
 	vol.Device = filepath.Join(DO_DEVICE_FOLDER, DO_DEVICE_PREFIX+id)
 	vol.Size = size
+	vol.MountOptions = mountOpts
+	vol.EncryptionKey = encryptionKey
 
 	if format {
-		if err := formatDevice(vol.Device, DO_VOLUME_FS); err != nil {
+		if err := util.FormatVolume(vol, fsType, mkfsOpts); err != nil {
 			return err
 		}
+		vol.Filesystem = fsType
+		vol.MkfsOptions = mkfsOpts
 	}
 	return util.ObjectSave(vol)
 }
@@ -274,19 +355,26 @@ func (d *Driver) DeleteVolume(req Request) error {
 }
 
 func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
 	id := req.Name
 	opts := req.Options
+	readOnly, _ := strconv.ParseBool(opts[OPT_READ_ONLY])
 
 	vol := d.blankVolume(id)
 	if err := util.ObjectLoad(vol); err != nil {
 		return "", err
 	}
 
-	mountPoint, err := util.VolumeMount(vol, opts[OPT_MOUNT_POINT], false)
+	mountPoint, err := util.VolumeMount(vol, opts[OPT_MOUNT_POINT], false, readOnly, opts[OPT_SELINUX_CONTEXT])
 	if err != nil {
 		return "", err
 	}
 
+	if _, err := d.growFilesystem(vol); err != nil {
+		log.Warnf("Failed to grow filesystem of volume %v: %v", id, err)
+	}
 	if err := util.ObjectSave(vol); err != nil {
 		return "", err
 	}
@@ -294,7 +382,43 @@ func (d *Driver) MountVolume(req Request) (string, error) {
 	return mountPoint, nil
 }
 
+// growFilesystem grows filesystem of the mounted volume if its device has been
+// expanded. Caller should save the volume.
+func (d *Driver) growFilesystem(volume *Volume) (bool, error) {
+	size, err := util.GrowExpandedVolume(volume, volume.Filesystem, volume.Size)
+	if err != nil || size == volume.Size {
+		return false, err
+	}
+	volume.Size = size
+	return true, nil
+}
+
+// GrowFilesystems grows filesystems of mounted volumes whose devices have been
+// expanded, returns their new sizes
+func (d *Driver) GrowFilesystems() (map[string]int64, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeIDs, err := util.ListConfigIDs(d.Root, CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_SUFFIX)
+	if err != nil {
+		return nil, err
+	}
+	return util.GrowMountedFilesystems(volumeIDs, func(id string) (int64, error) {
+		volume := d.blankVolume(id)
+		grown, err := util.GrowMountedVolume(volume, func() (bool, error) {
+			return d.growFilesystem(volume)
+		})
+		if err != nil || !grown {
+			return 0, err
+		}
+		return volume.Size, nil
+	}), nil
+}
+
 func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
 	id := req.Name
 
 	vol := d.blankVolume(id)
@@ -336,11 +460,15 @@ func (d *Driver) GetVolumeInfo(name string) (map[string]string, error) {
 
 	size := doVol.SizeGigaBytes * GB
 	info := map[string]string{
-		"Device":        vol.Device,
-		"MountPoint":    vol.MountPoint,
-		"ID":            vol.ID,
-		OPT_VOLUME_NAME: name,
-		"Size":          strconv.FormatInt(size, 10),
+		"Device":           vol.Device,
+		"MountPoint":       vol.MountPoint,
+		"ID":               vol.ID,
+		OPT_FILESYSTEM:     vol.Filesystem,
+		OPT_MKFS_OPTIONS:   vol.MkfsOptions,
+		OPT_MOUNT_OPTIONS:  vol.MountOptions,
+		OPT_ENCRYPTION_KEY: vol.EncryptionKey,
+		OPT_VOLUME_NAME:    name,
+		"Size":             strconv.FormatInt(size, 10),
 	}
 	return info, nil
 }
@@ -369,11 +497,6 @@ func (d *Driver) getSize(opts map[string]string, defaultVolumeSize int64) (int64
 	return util.ParseSize(size)
 }
 
-func formatDevice(device, fs string) error {
-	_, err := util.Execute("mkfs", []string{"-t", fs, device})
-	return err
-}
-
 // These methods are not implemented currently at DigitalOcean
 func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
 	return nil, errors.New("not implemented")
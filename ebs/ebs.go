@@ -2,7 +2,9 @@ package ebs
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/url"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -17,6 +19,7 @@ import (
 	. "github.com/rancher/convoy/logging"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
 const (
@@ -32,19 +35,38 @@ const (
 	EBS_DEFAULT_VOLUME_KEY  = "ebs.defaultkmskeyid"
 	EBS_DEFAULT_ENCRYPTED   = "ebs.defaultencrypted"
 	EBS_FSFREEZE = "ebs.fsfreeze"
+	EBS_DEFAULT_FS_TYPE     = "ebs.fs"
+	EBS_MKFS_OPTIONS        = "ebs.mkfsoptions"
+	EBS_MOUNT_OPTIONS       = "ebs.mountoptions"
 
 	DEFAULT_VOLUME_SIZE = "4G"
 	DEFAULT_VOLUME_TYPE = "gp2"
 	DEFAULT_FSFREEZE = "false"
+	DEFAULT_FS_TYPE     = "ext4"
 
 	MOUNTS_DIR    = "mounts"
 	MOUNT_BINARY  = "mount"
 	UMOUNT_BINARY = "umount"
+	NVME_BINARY   = "nvme"
+
+	// Resized device should show the new size within the timeout after
+	// rescan, unless modification of the volume hasn't reached optimizing
+	DEVICE_RESCAN_TIMEOUT  = 15 * time.Second
+	DEVICE_RESCAN_INTERVAL = time.Second
+)
+
+var (
+	nvmeNamespaceRegexp = regexp.MustCompile("^(nvme[0-9]+)n[0-9]+$")
 )
 
 type Driver struct {
-	mutex      *sync.RWMutex
-	ebsService *ebsService
+	// Operations on the same volume are serialized, while EBS calls of
+	// different volumes, e.g. waiting for snapshots to complete, don't
+	// block each other
+	volumeLocks *util.LockMap
+	ebsService  *ebsService
+	// Guards defaults of new volumes in Device, which can be reloaded
+	defaultsMutex sync.RWMutex
 	Device
 }
 
@@ -55,6 +77,11 @@ type Device struct {
 	DefaultKmsKeyID   string
 	DefaultEncrypted  bool
 	FsFreeze          string
+	LastBootID        string
+	// Empty for config created before filesystem is configurable
+	DefaultFilesystem string
+	MkfsOptions       string
+	MountOptions      string
 }
 
 func (dev *Device) ConfigFile() (string, error) {
@@ -71,11 +98,21 @@ type Snapshot struct {
 }
 
 type Volume struct {
-	Name       string
-	EBSID      string
-	Device     string
-	MountPoint string
-	Snapshots  map[string]Snapshot
+	Name         string
+	EBSID        string
+	Device       string
+	MountPoint   string
+	Filesystem   string
+	MkfsOptions  string
+	MountOptions string
+	// EncryptionKey is ID of the key, see util.InitEncryptionKeySources()
+	EncryptionKey string
+	Snapshots     map[string]Snapshot
+	// DeviceSize is the size of device filesystem was last grown to
+	DeviceSize int64
+	// FsFreeze overrides ebs.fsfreeze for snapshots of the volume if set,
+	// "true" or "false"
+	FsFreeze string
 
 	configPath string
 }
@@ -87,6 +124,19 @@ func (d *Driver) blankVolume(name string) *Volume {
 	}
 }
 
+// loadVolume loads config of the volume, which may be being saved by an
+// operation holding the lock of the volume
+func (d *Driver) loadVolume(id string) (*Volume, error) {
+	d.volumeLocks.RLock(id)
+	defer d.volumeLocks.RUnlock(id)
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	return volume, nil
+}
+
 func (v *Volume) ConfigFile() (string, error) {
 	if v.Name == "" {
 		return "", fmt.Errorf("BUG: Invalid empty volume name")
@@ -102,7 +152,7 @@ func (v *Volume) GetDevice() (string, error) {
 }
 
 func (v *Volume) GetMountOpts() []string {
-	return []string{}
+	return util.GetFilesystemMountOpts(v.Filesystem, v.MountOptions)
 }
 
 func (v *Volume) GenerateDefaultMountPoint() string {
@@ -133,28 +183,218 @@ func checkVolumeType(volumeType string) error {
 	return nil
 }
 
-func (d *Driver) remountVolumes() error {
+/*
+Reconcile reattaches volumes as reattachVolumes() does, then mounts volumes
+which were mounted before and cleans up mounts left behind by volumes not
+mounted.
+*/
+func (d *Driver) Reconcile() ([]Reconciliation, error) {
+	results, err := d.reattachVolumes()
+	if err != nil {
+		return results, err
+	}
 	volumeIDs, err := d.listVolumeNames()
 	if err != nil {
-		return err
+		return results, err
+	}
+	mounts, err := util.ListMounts()
+	if err != nil {
+		return results, err
 	}
 	for _, id := range volumeIDs {
-		volume := d.blankVolume(id)
+		if action, err := d.reconcileVolumeMount(id, mounts); action != "" || err != nil {
+			results = append(results, Reconciliation{Volume: id, Action: action, Err: err})
+		}
+	}
+	return results, nil
+}
+
+func (d *Driver) reconcileVolumeMount(id, mounts string) (string, error) {
+	d.volumeLocks.Lock(id)
+	defer d.volumeLocks.Unlock(id)
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	action, err := util.ReconcileVolumeMount(volume, mounts)
+	if err != nil || action == "" {
+		return action, err
+	}
+	return action, util.ObjectSave(volume)
+}
+
+/*
+reattachVolumes reconciles the attachments of convoy volumes after instance
+stop/start. Volumes detached from the instance would be attached again, and
+volumes still attached would have their device names refreshed, since those
+may change across restarts. Volumes attached to other instances are left
+untouched. The volumes are described with one call and attached together, so
+instances with many volumes won't take minutes to start. Volumes failed to be
+reattached are recorded unmounted, so they won't be mounted again.
+
+Volumes are only attached again if the instance has restarted since the last
+start of daemon, by its boot ID. Otherwise they were detached while the
+instance was running, e.g. by hand, and are recorded unmounted instead.
+*/
+func (d *Driver) reattachVolumes() ([]Reconciliation, error) {
+	// Taken as restarted if it's unknown
+	restarted := true
+	bootID, err := getBootID()
+	if err != nil {
+		log.Warnf("Cannot read boot ID, taking instance as restarted: %v", err)
+	} else if bootID != d.LastBootID {
+		if d.LastBootID != "" {
+			log.Infof("Instance %v has restarted since last start, reconciling volume attachments", d.ebsService.InstanceID)
+		}
+		d.LastBootID = bootID
+		if err := util.ObjectSave(&d.Device); err != nil {
+			return nil, err
+		}
+	} else {
+		restarted = false
+	}
+
+	names, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	results := []Reconciliation{}
+	if len(names) == 0 {
+		return results, nil
+	}
+	volumes := make(map[string]*Volume)
+	ebsIDs := []string{}
+	for _, name := range names {
+		volume := d.blankVolume(name)
 		if err := util.ObjectLoad(volume); err != nil {
-			return err
+			return nil, err
 		}
-		if volume.MountPoint == "" {
+		volumes[volume.EBSID] = volume
+		ebsIDs = append(ebsIDs, volume.EBSID)
+	}
+	ebsVolumes, err := d.ebsService.GetVolumes(ebsIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	detached := []string{}
+	for _, ebsID := range ebsIDs {
+		volume := volumes[ebsID]
+		ebsVolume, exists := ebsVolumes[ebsID]
+		if !exists {
+			err = fmt.Errorf("Cannot find volume %v", ebsID)
+		} else {
+			var attached bool
+			dev := volume.Device
+			if attached, err = d.refreshAttachedVolume(volume, ebsVolume); err == nil && !attached {
+				if restarted {
+					log.Infof("EBS volume %v of %v is no longer attached, attaching it again", ebsID, volume.Name)
+					detached = append(detached, ebsID)
+					continue
+				}
+				if volume.Device == "" {
+					// Detached by drain, attached again on mount
+					continue
+				}
+				err = fmt.Errorf("EBS volume %v was detached while instance was running, not attaching it again", ebsID)
+			}
+			if err == nil && volume.Device != dev {
+				results = append(results, Reconciliation{
+					Volume: volume.Name,
+					Action: "device changed to " + volume.Device,
+				})
+			}
+		}
+		if err != nil {
+			results = append(results, Reconciliation{Volume: volume.Name, Err: err})
+		}
+		if err := d.failReattach(volume, err); err != nil {
+			return results, err
+		}
+	}
+
+	start := time.Now()
+	devs, errs := d.ebsService.AttachVolumes(detached)
+	for ebsID, dev := range devs {
+		volume := volumes[ebsID]
+		util.ObserveLatency(util.LATENCY_ATTACH, volume.Name, start)
+		if err := d.updateVolumeDevice(volume, dev); err != nil {
+			return results, err
+		}
+		results = append(results, Reconciliation{
+			Volume: volume.Name,
+			Action: fmt.Sprintf("attached EBS volume %v again as %v", ebsID, dev),
+		})
+	}
+	for ebsID, attachErr := range errs {
+		results = append(results, Reconciliation{Volume: volumes[ebsID].Name, Err: attachErr})
+		if err := d.failReattach(volumes[ebsID], attachErr); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// failReattach logs the failure and records the volume unmounted. err is
+// passed through if it's nil
+func (d *Driver) failReattach(volume *Volume, err error) error {
+	if err == nil {
+		return nil
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_FAILURE,
+		LOG_FIELD_EVENT:  LOG_EVENT_ATTACH,
+		LOG_FIELD_VOLUME: volume.Name,
+	}).Errorf("Failed to reattach EBS volume %v: %v", volume.EBSID, err)
+	if volume.MountPoint == "" {
+		return nil
+	}
+	// Don't fail the daemon start on remount
+	volume.MountPoint = ""
+	return util.ObjectSave(volume)
+}
+
+// refreshAttachedVolume updates device of the volume if it's still attached
+// to the instance, and returns whether it's attached
+func (d *Driver) refreshAttachedVolume(volume *Volume, ebsVolume *ec2.Volume) (bool, error) {
+	for _, attachment := range ebsVolume.Attachments {
+		state := aws.StringValue(attachment.State)
+		if state == ec2.VolumeAttachmentStateDetached || state == ec2.VolumeAttachmentStateDetaching {
 			continue
 		}
-		req := Request{
-			Name:    id,
-			Options: map[string]string{},
+		if attachment.InstanceId == nil || *attachment.InstanceId != d.ebsService.InstanceID {
+			return false, fmt.Errorf("EBS volume %v is attached to another instance %v",
+				volume.EBSID, aws.StringValue(attachment.InstanceId))
 		}
-		if _, err := d.MountVolume(req); err != nil {
-			return err
+		dev, err := d.ebsService.GetLocalDevice(volume.EBSID, aws.StringValue(attachment.Device))
+		if err != nil {
+			return false, err
 		}
+		return true, d.updateVolumeDevice(volume, dev)
 	}
-	return err
+	return false, nil
+}
+
+func (d *Driver) updateVolumeDevice(volume *Volume, dev string) error {
+	if dev == volume.Device {
+		return nil
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:  LOG_EVENT_ATTACH,
+		LOG_FIELD_VOLUME: volume.Name,
+	}).Infof("Device of EBS volume %v changed from %v to %v", volume.EBSID, volume.Device, dev)
+	volume.Device = dev
+	return util.ObjectSave(volume)
+}
+
+func getBootID() (string, error) {
+	bootID, err := ioutil.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bootID)), nil
 }
 
 func Init(root string, config map[string]string) (ConvoyDriver, error) {
@@ -203,6 +443,16 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 			config[EBS_FSFREEZE] = DEFAULT_FSFREEZE
 		}
 		fsFreeze := config[EBS_FSFREEZE]
+		if config[EBS_DEFAULT_FS_TYPE] == "" {
+			config[EBS_DEFAULT_FS_TYPE] = DEFAULT_FS_TYPE
+		}
+		fsType := config[EBS_DEFAULT_FS_TYPE]
+		if err := util.CheckFilesystem(fsType); err != nil {
+			return nil, err
+		}
+		if err := util.CheckMountOptions(config[EBS_MOUNT_OPTIONS]); err != nil {
+			return nil, err
+		}
 
 		dev = &Device{
 			Root:              root,
@@ -211,20 +461,19 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 			DefaultKmsKeyID:   kmsKeyId,
 			DefaultEncrypted:  encrypted,
 			FsFreeze:          fsFreeze,
+			DefaultFilesystem: fsType,
+			MkfsOptions:       config[EBS_MKFS_OPTIONS],
+			MountOptions:      config[EBS_MOUNT_OPTIONS],
 		}
 		if err := util.ObjectSave(dev); err != nil {
 			return nil, err
 		}
 	}
 	d := &Driver{
-		mutex:      &sync.RWMutex{},
-		ebsService: ebsService,
-		Device:     *dev,
+		volumeLocks: util.NewLockMap(),
+		ebsService:  ebsService,
+		Device:      *dev,
 	}
-	if err := d.remountVolumes(); err != nil {
-		return nil, err
-	}
-
 	return d, nil
 }
 
@@ -233,17 +482,66 @@ func (d *Driver) Name() string {
 }
 
 func (d *Driver) Info() (map[string]string, error) {
+	dev := d.defaults()
 	infos := make(map[string]string)
-	infos["DefaultVolumeSize"] = strconv.FormatInt(d.DefaultVolumeSize, 10)
-	infos["DefaultVolumeType"] = d.DefaultVolumeType
-	infos["DefaultKmsKey"] = d.DefaultKmsKeyID
-	infos["DefaultEncrypted"] = fmt.Sprint(d.DefaultEncrypted)
+	infos["DefaultVolumeSize"] = strconv.FormatInt(dev.DefaultVolumeSize, 10)
+	infos["DefaultVolumeType"] = dev.DefaultVolumeType
+	infos["DefaultKmsKey"] = dev.DefaultKmsKeyID
+	infos["DefaultEncrypted"] = fmt.Sprint(dev.DefaultEncrypted)
+	infos["DefaultFilesystem"] = d.getDefaultFilesystem()
+	infos["MkfsOptions"] = dev.MkfsOptions
+	infos["MountOptions"] = dev.MountOptions
 	infos["InstanceID"] = d.ebsService.InstanceID
 	infos["Region"] = d.ebsService.Region
 	infos["AvailiablityZone"] = d.ebsService.AvailabilityZone
 	return infos, nil
 }
 
+func (d *Driver) Capabilities() Capabilities {
+	return Capabilities{
+		Snapshot:         true,
+		Backup:           true,
+		Resize:           true,
+		Clone:            true,
+		CrossHostAttach:  true,
+		CustomMountPoint: true,
+		ReadOnlyMount:    true,
+		Encryption:       true,
+		SELinuxLabel:     true,
+		KmsEncryption:    true,
+	}
+}
+
+func (d *Driver) CheckHealth() error {
+	return d.ebsService.CheckAvailabilityZone()
+}
+
+// Metrics reports EBS volumes of the driver attached to the instance, which
+// is limited by instance type
+func (d *Driver) Metrics() ([]Metric, error) {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	attached := 0
+	for _, id := range volumeIDs {
+		volume, err := d.loadVolume(id)
+		if err != nil {
+			return nil, err
+		}
+		if volume.Device != "" {
+			attached++
+		}
+	}
+	return []Metric{
+		{
+			Name:  "attached_volumes",
+			Help:  "EBS volumes attached to the instance.",
+			Value: float64(attached),
+		},
+	}, nil
+}
+
 func (d *Driver) VolumeOps() (VolumeOperations, error) {
 	return d, nil
 }
@@ -263,7 +561,7 @@ func (d *Driver) getTypeAndIOPS(opts map[string]string) (string, int64, error) {
 	)
 	volumeType := opts[OPT_VOLUME_TYPE]
 	if volumeType == "" {
-		volumeType = d.DefaultVolumeType
+		volumeType = d.defaults().DefaultVolumeType
 	}
 	if err := checkVolumeType(volumeType); err != nil {
 		return "", 0, err
@@ -283,19 +581,84 @@ func (d *Driver) getTypeAndIOPS(opts map[string]string) (string, int64, error) {
 	return volumeType, iops, nil
 }
 
+func (d *Driver) getDefaultFilesystem() string {
+	dev := d.defaults()
+	if dev.DefaultFilesystem == "" {
+		return DEFAULT_FS_TYPE
+	}
+	return dev.DefaultFilesystem
+}
+
+// defaults returns a copy of Device, whose defaults may be changed by
+// ReloadDefaults() at any time
+func (d *Driver) defaults() Device {
+	d.defaultsMutex.RLock()
+	defer d.defaultsMutex.RUnlock()
+
+	return d.Device
+}
+
+/*
+ReloadDefaults changes defaults of new volumes, i.e. size, type, KMS key,
+encryption, filesystem with its mkfs and mount options, and whether
+filesystems are frozen for snapshots.
+*/
+func (d *Driver) ReloadDefaults(opts map[string]string) error {
+	d.defaultsMutex.Lock()
+	defer d.defaultsMutex.Unlock()
+
+	dev := d.Device
+	for k, v := range opts {
+		var err error
+		switch k {
+		case EBS_DEFAULT_VOLUME_SIZE:
+			dev.DefaultVolumeSize, err = util.ParseSize(v)
+		case EBS_DEFAULT_VOLUME_TYPE:
+			dev.DefaultVolumeType = v
+			err = checkVolumeType(v)
+		case EBS_DEFAULT_VOLUME_KEY:
+			dev.DefaultKmsKeyID = v
+		case EBS_DEFAULT_ENCRYPTED:
+			dev.DefaultEncrypted, err = strconv.ParseBool(v)
+		case EBS_FSFREEZE:
+			dev.FsFreeze = v
+			_, err = strconv.ParseBool(v)
+		case EBS_DEFAULT_FS_TYPE:
+			dev.DefaultFilesystem = v
+			err = util.CheckFilesystem(v)
+		case EBS_MKFS_OPTIONS:
+			dev.MkfsOptions = v
+		case EBS_MOUNT_OPTIONS:
+			dev.MountOptions = v
+			err = util.CheckMountOptions(v)
+		default:
+			err = fmt.Errorf("cannot be changed without restart")
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid driver option %v: %v", k, err)
+		}
+	}
+	if err := util.ObjectSave(&dev); err != nil {
+		return err
+	}
+	d.Device = dev
+	return nil
+}
+
 func (d *Driver) CreateVolume(req Request) error {
 	var (
 		err        error
 		volumeSize int64
+		deviceSize int64
 		format     bool
 	)
 
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-
 	id := req.Name
 	opts := req.Options
 
+	d.volumeLocks.Lock(id)
+	defer d.volumeLocks.Unlock(id)
+
 	volume := d.blankVolume(id)
 	exists, err := util.ObjectExists(volume)
 	if err != nil {
@@ -311,10 +674,81 @@ func (d *Driver) CreateVolume(req Request) error {
 	if backupURL != "" && volumeID != "" {
 		return fmt.Errorf("Cannot specify both backup and EBS volume ID")
 	}
+	// Volume cloned is created from EBS snapshot of the source, like the one
+	// restored from backup
+	var source *Volume
+	if sourceName := opts[OPT_CLONE_VOLUME]; sourceName != "" {
+		if backupURL != "" || volumeID != "" {
+			return fmt.Errorf("Cannot clone volume along with backup or EBS volume ID")
+		}
+		if opts[OPT_ENCRYPTION_KEY] != "" {
+			return fmt.Errorf("Cannot specify encryption key for volume cloned, which has the one of volume %v", sourceName)
+		}
+		if source, err = d.loadVolume(sourceName); err != nil {
+			return err
+		}
+	}
+	fsType := opts[OPT_FILESYSTEM]
+	if fsType != "" && (backupURL != "" || volumeID != "" || source != nil) {
+		return fmt.Errorf("Cannot specify filesystem for volume restored from backup, cloned or existing EBS volume")
+	}
+	if fsType == "" {
+		fsType = d.getDefaultFilesystem()
+	}
+	if err := util.CheckFilesystem(fsType); err != nil {
+		return err
+	}
+	mkfsOpts := opts[OPT_MKFS_OPTIONS]
+	if mkfsOpts != "" && (backupURL != "" || volumeID != "" || source != nil) {
+		return fmt.Errorf("Cannot specify mkfs options for volume restored from backup, cloned or existing EBS volume")
+	}
+	if mkfsOpts == "" {
+		mkfsOpts = d.defaults().MkfsOptions
+	}
+	mountOpts := opts[OPT_MOUNT_OPTIONS]
+	if mountOpts == "" && source != nil {
+		mountOpts = util.CloneMountOptions(source.Filesystem, source.MountOptions)
+	}
+	if mountOpts == "" {
+		mountOpts = d.defaults().MountOptions
+	}
+	if err := util.CheckMountOptions(mountOpts); err != nil {
+		return err
+	}
+	encryptionKey := opts[OPT_ENCRYPTION_KEY]
+	if source != nil {
+		encryptionKey = source.EncryptionKey
+	}
+	if encryptionKey != "" {
+		if err := util.CheckEncryptionKey(encryptionKey); err != nil {
+			return err
+		}
+	}
+	kmsKeyID := opts[OPT_KMS_KEY_ID]
+	if kmsKeyID != "" && volumeID != "" {
+		return fmt.Errorf("Cannot specify KMS key for existing EBS volume")
+	}
+	fsFreeze := opts[OPT_FSFREEZE]
+	if fsFreeze == "" && source != nil {
+		fsFreeze = source.FsFreeze
+	}
+	if fsFreeze != "" {
+		freeze, err := strconv.ParseBool(fsFreeze)
+		if err != nil {
+			return fmt.Errorf("Invalid fsfreeze %v, should be true or false", fsFreeze)
+		}
+		fsFreeze = strconv.FormatBool(freeze)
+	}
 
-	newTags := map[string]string{
-		"Name": id,
+	// Labels of volume are tags of the EBS volume
+	newTags, err := util.DecodeLabels(opts[OPT_LABELS])
+	if err != nil {
+		return err
+	}
+	if newTags == nil {
+		newTags = make(map[string]string)
 	}
+	newTags["Name"] = id
 	if volumeID != "" {
 		ebsVolume, err := d.ebsService.GetVolume(volumeID)
 		if err != nil {
@@ -325,18 +759,37 @@ func (d *Driver) CreateVolume(req Request) error {
 		if err := d.ebsService.AddTags(volumeID, newTags); err != nil {
 			log.Debugf("Failed to update tags for volume %v, but continue", volumeID)
 		}
-	} else if backupURL != "" {
-		region, ebsSnapshotID, err := decodeURL(backupURL)
-		if err != nil {
-			return err
-		}
-		if region != d.ebsService.Region {
-			// We don't want to automatically copy snapshot here
-			// because it's way too time consuming.
-			return fmt.Errorf("Snapshot %v is at %v rather than current region %v. Copy snapshot is needed",
-				ebsSnapshotID, region, d.ebsService.Region)
+	} else if backupURL != "" || source != nil {
+		var ebsSnapshotID string
+		if source != nil {
+			if ebsSnapshotID, err = d.createCloneSnapshot(source, id); err != nil {
+				return err
+			}
+			// Volume created from EBS snapshot doesn't need it any
+			// more, even before its blocks are loaded
+			defer func() {
+				if err := d.ebsService.DeleteSnapshot(ebsSnapshotID); err != nil {
+					log.Warnf("Failed to remove snapshot %v for cloning volume %v, but continue: %v",
+						ebsSnapshotID, source.Name, err)
+				}
+			}()
+		} else {
+			var region string
+			if region, ebsSnapshotID, err = decodeURL(backupURL); err != nil {
+				return err
+			}
+			if region != d.ebsService.Region {
+				// We don't want to automatically copy snapshot here
+				// because it's way too time consuming.
+				return fmt.Errorf("Snapshot %v is at %v rather than current region %v. Copy snapshot is needed",
+					ebsSnapshotID, region, d.ebsService.Region)
+			}
 		}
-		if err := d.ebsService.WaitForSnapshotComplete(ebsSnapshotID); err != nil {
+		_, waitSpan := util.StartSpan(req.Context, "ebs.WaitForSnapshotComplete")
+		waitSpan.SetAttribute("ebs.snapshot_id", ebsSnapshotID)
+		err = d.ebsService.WaitForSnapshotComplete(ebsSnapshotID)
+		waitSpan.End(err)
+		if err != nil {
 			return err
 		}
 		log.Debugf("Snapshot %v is ready", ebsSnapshotID)
@@ -363,16 +816,28 @@ func (d *Driver) CreateVolume(req Request) error {
 			VolumeType: volumeType,
 			IOPS:       iops,
 			Tags:       newTags,
+			KmsKeyID:   kmsKeyID,
 		}
+		_, createSpan := util.StartSpan(req.Context, "ebs.CreateVolume")
 		volumeID, err = d.ebsService.CreateVolume(r)
+		createSpan.SetAttribute("ebs.volume_id", volumeID)
+		createSpan.End(err)
 		if err != nil {
 			return err
 		}
 		log.Debugf("Created volume %v from EBS snapshot %v", id, ebsSnapshotID)
+		// Filesystem is as large as the snapshot, and would be grown to
+		// the new size on mount, since xfs and btrfs can only be grown
+		// online
+		deviceSize = snapshotVolumeSize
+		if volumeSize > snapshotVolumeSize {
+			log.Infof("Volume %v is larger than snapshot %v, filesystem would be grown from %v to %v bytes when mounted",
+				id, ebsSnapshotID, snapshotVolumeSize, volumeSize)
+		}
 	} else {
 
 		// Create a new EBS volume
-		volumeSize, err = d.getSize(opts, d.DefaultVolumeSize)
+		volumeSize, err = d.getSize(opts, d.defaults().DefaultVolumeSize)
 		if err != nil {
 			return err
 		}
@@ -380,14 +845,20 @@ func (d *Driver) CreateVolume(req Request) error {
 		if err != nil {
 			return err
 		}
+		if kmsKeyID == "" {
+			kmsKeyID = d.defaults().DefaultKmsKeyID
+		}
 		r := &CreateEBSVolumeRequest{
 			Size:       volumeSize,
 			VolumeType: volumeType,
 			IOPS:       iops,
 			Tags:       newTags,
-			KmsKeyID:   d.DefaultKmsKeyID,
+			KmsKeyID:   kmsKeyID,
 		}
+		_, createSpan := util.StartSpan(req.Context, "ebs.CreateVolume")
 		volumeID, err = d.ebsService.CreateVolume(r)
+		createSpan.SetAttribute("ebs.volume_id", volumeID)
+		createSpan.End(err)
 		if err != nil {
 			return err
 		}
@@ -395,34 +866,93 @@ func (d *Driver) CreateVolume(req Request) error {
 		format = true
 	}
 
+	attachStart := time.Now()
+	_, attachSpan := util.StartSpan(req.Context, "ebs.AttachVolume")
+	attachSpan.SetAttribute("ebs.volume_id", volumeID)
 	dev, err := d.ebsService.AttachVolume(volumeID, volumeSize)
+	attachSpan.End(err)
 	if err != nil {
 		return err
 	}
+	util.ObserveLatency(util.LATENCY_ATTACH, id, attachStart)
 	log.Debugf("Attached EBS volume %v to %v", volumeID, dev)
 
 	volume.Name = id
 	volume.EBSID = volumeID
 	volume.Device = dev
 	volume.Snapshots = make(map[string]Snapshot)
+	volume.MountOptions = mountOpts
+	volume.EncryptionKey = encryptionKey
+	volume.DeviceSize = deviceSize
+	volume.FsFreeze = fsFreeze
 
 	// We don't format existing or snapshot restored volume
 	if format {
-		if _, err := util.Execute("mkfs", []string{"-t", "ext4", dev}); err != nil {
+		_, mkfsSpan := util.StartSpan(req.Context, "mkfs")
+		mkfsSpan.SetAttribute("fs.type", fsType)
+		err := util.FormatVolume(volume, fsType, mkfsOpts)
+		mkfsSpan.End(err)
+		if err != nil {
 			return err
 		}
+		volume.Filesystem = fsType
+		volume.MkfsOptions = mkfsOpts
+	} else if volume.Filesystem, err = util.GetVolumeFilesystemType(volume); err != nil {
+		log.Warnf("Cannot detect filesystem of volume %v: %v", id, err)
 	}
 
 	return util.ObjectSave(volume)
 }
 
-func (d *Driver) DeleteVolume(req Request) error {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
+/*
+createCloneSnapshot starts EBS snapshot of the source volume for cloning it
+to volume id, which is not recorded as snapshot of the source. Source volume
+is locked by volumeLocks while the snapshot is started, same as
+CreateSnapshot().
+*/
+func (d *Driver) createCloneSnapshot(source *Volume, id string) (string, error) {
+	d.volumeLocks.Lock(source.Name)
+	defer d.volumeLocks.Unlock(source.Name)
+
+	if err := util.ObjectLoad(source); err != nil {
+		return "", err
+	}
+	thaw := func() {}
+	if source.MountPoint != "" {
+		log.Debugf("syncing filesystems...")
+		if err := util.Sync(); err != nil {
+			return "", err
+		}
+		if d.shouldFreeze(source, false) {
+			var err error
+			if thaw, err = util.FreezeFilesystem(source.MountPoint); err != nil {
+				return "", err
+			}
+		}
+	}
+	ebsSnapshotID, err := d.ebsService.CreateSnapshot(&CreateSnapshotRequest{
+		VolumeID:    source.EBSID,
+		Description: fmt.Sprintf("Convoy snapshot for cloning volume %v to %v", source.Name, id),
+		Tags: map[string]string{
+			"ConvoyVolumeName": source.Name,
+			"ConvoyCloneName":  id,
+		},
+	})
+	thaw()
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("Creating snapshot %v of volume %v(%v) for cloning to %v", ebsSnapshotID, source.Name, source.EBSID, id)
+	return ebsSnapshotID, nil
+}
 
+func (d *Driver) DeleteVolume(req Request) error {
 	id := req.Name
 	opts := req.Options
 
+	d.volumeLocks.Lock(id)
+	defer d.volumeLocks.Unlock(id)
+
 	volume := d.blankVolume(id)
 	if err := util.ObjectLoad(volume); err != nil {
 		return err
@@ -452,17 +982,35 @@ func (d *Driver) DeleteVolume(req Request) error {
 func (d *Driver) MountVolume(req Request) (string, error) {
 	id := req.Name
 	opts := req.Options
+	readOnly, _ := strconv.ParseBool(opts[OPT_READ_ONLY])
+
+	d.volumeLocks.Lock(id)
+	defer d.volumeLocks.Unlock(id)
 
 	volume := d.blankVolume(id)
 	if err := util.ObjectLoad(volume); err != nil {
 		return "", err
 	}
 
-	mountPoint, err := util.VolumeMount(volume, opts[OPT_MOUNT_POINT], false)
+	if volume.Device == "" {
+		// Detached by drain
+		devs, errs := d.ebsService.AttachVolumes([]string{volume.EBSID})
+		if err := errs[volume.EBSID]; err != nil {
+			return "", err
+		}
+		if err := d.updateVolumeDevice(volume, devs[volume.EBSID]); err != nil {
+			return "", err
+		}
+	}
+
+	mountPoint, err := util.VolumeMount(volume, opts[OPT_MOUNT_POINT], false, readOnly, opts[OPT_SELINUX_CONTEXT])
 	if err != nil {
 		return "", err
 	}
 
+	if _, err := d.growFilesystem(volume, nil); err != nil {
+		log.Warnf("Failed to grow filesystem of volume %v: %v", id, err)
+	}
 	if err := util.ObjectSave(volume); err != nil {
 		return "", err
 	}
@@ -470,9 +1018,211 @@ func (d *Driver) MountVolume(req Request) (string, error) {
 	return mountPoint, nil
 }
 
+/*
+rescanDevice makes the kernel read size of the device again, which it doesn't
+do by itself for every kind of device after EBS volume is modified. NVMe
+namespaces are rescanned through their controller, SCSI devices through
+sysfs. Xen devices are updated by the hypervisor and have nothing to rescan.
+*/
+func rescanDevice(dev string) error {
+	dev, err := filepath.EvalSymlinks(dev)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(dev)
+	if m := nvmeNamespaceRegexp.FindStringSubmatch(name); m != nil {
+		controller := m[1]
+		rescan := filepath.Join("/sys/class/nvme", controller, "rescan_controller")
+		if _, err := os.Stat(rescan); err == nil {
+			return ioutil.WriteFile(rescan, []byte("1"), 0200)
+		}
+		// Kernels before 4.19 have no rescan_controller
+		_, err := util.Execute(NVME_BINARY, []string{"ns-rescan", "/dev/" + controller})
+		return err
+	}
+	rescan := filepath.Join("/sys/block", name, "device", "rescan")
+	if _, err := os.Stat(rescan); os.IsNotExist(err) {
+		log.Debugf("Device %v cannot be rescanned, skip", dev)
+		return nil
+	}
+	return ioutil.WriteFile(rescan, []byte("1"), 0200)
+}
+
+/*
+rescanResizedDevice rescans the device of volume if the EBS volume has been
+resized beyond the device, e.g. by ModifyVolume, since the new size usually
+isn't visible to the instance until then, and waits for the device to show
+it, so the filesystem can be grown afterwards. It fails if the device still
+shows the old size.
+*/
+func (d *Driver) rescanResizedDevice(volume *Volume, ebsVolume *ec2.Volume) error {
+	size := aws.Int64Value(ebsVolume.Size) * GB
+	if size <= volume.DeviceSize {
+		return nil
+	}
+	devSize, err := util.GetDeviceSize(volume.Device)
+	if err != nil {
+		return err
+	}
+	if devSize >= size {
+		return nil
+	}
+	log.Infof("EBS volume %v has been resized to %v bytes, rescanning device %v of %v bytes",
+		volume.EBSID, size, volume.Device, devSize)
+	if err := rescanDevice(volume.Device); err != nil {
+		return fmt.Errorf("Failed to rescan device %v of EBS volume %v: %v", volume.Device, volume.EBSID, err)
+	}
+	for start := time.Now(); time.Since(start) < DEVICE_RESCAN_TIMEOUT; time.Sleep(DEVICE_RESCAN_INTERVAL) {
+		if devSize, err = util.GetDeviceSize(volume.Device); err != nil {
+			return err
+		}
+		if devSize >= size {
+			return nil
+		}
+	}
+	return fmt.Errorf("Device %v still shows %v bytes after rescan, EBS volume %v of %v bytes may still be modifying",
+		volume.Device, devSize, volume.EBSID, size)
+}
+
+/*
+growFilesystem grows filesystem of the mounted volume if its device has been
+expanded, after rescanning the device if the EBS volume has been resized.
+ebsVolume would be described if nil. Caller should save the volume.
+*/
+func (d *Driver) growFilesystem(volume *Volume, ebsVolume *ec2.Volume) (bool, error) {
+	if ebsVolume == nil {
+		// GetVolume() would delay mount for retry interval
+		ebsVolumes, err := d.ebsService.GetVolumes([]string{volume.EBSID})
+		if err != nil {
+			return false, err
+		}
+		if ebsVolume = ebsVolumes[volume.EBSID]; ebsVolume == nil {
+			return false, fmt.Errorf("Cannot find EBS volume %v", volume.EBSID)
+		}
+	}
+	if err := d.rescanResizedDevice(volume, ebsVolume); err != nil {
+		return false, err
+	}
+	size, err := util.GrowExpandedVolume(volume, volume.Filesystem, volume.DeviceSize)
+	if err != nil || size == volume.DeviceSize {
+		return false, err
+	}
+	volume.DeviceSize = size
+	return true, nil
+}
+
+// GrowFilesystems grows filesystems of mounted volumes whose devices have been
+// expanded, returns their new sizes. EBS volumes are described with one call.
+func (d *Driver) GrowFilesystems() (map[string]int64, error) {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	mounted := []string{}
+	ebsIDs := make(map[string]string)
+	for _, id := range volumeIDs {
+		volume, err := d.loadVolume(id)
+		if err != nil {
+			return nil, err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		mounted = append(mounted, id)
+		ebsIDs[id] = volume.EBSID
+	}
+	if len(mounted) == 0 {
+		return nil, nil
+	}
+	ids := []string{}
+	for _, id := range mounted {
+		ids = append(ids, ebsIDs[id])
+	}
+	ebsVolumes, err := d.ebsService.GetVolumes(ids)
+	if err != nil {
+		return nil, err
+	}
+	return util.GrowMountedFilesystems(mounted, func(id string) (int64, error) {
+		ebsVolume, exists := ebsVolumes[ebsIDs[id]]
+		if !exists {
+			return 0, fmt.Errorf("Cannot find EBS volume %v", ebsIDs[id])
+		}
+		d.volumeLocks.Lock(id)
+		defer d.volumeLocks.Unlock(id)
+
+		volume := d.blankVolume(id)
+		grown, err := util.GrowMountedVolume(volume, func() (bool, error) {
+			return d.growFilesystem(volume, ebsVolume)
+		})
+		if err != nil || !grown {
+			return 0, err
+		}
+		return volume.DeviceSize, nil
+	}), nil
+}
+
+/*
+ResizeVolume modifies the EBS volume to the new size, and grows filesystem of
+the volume if it's mounted read-write and its device shows the new size in
+time. Otherwise filesystem would be grown once the modification is far
+enough, periodically by daemon while mounted, or on next mount.
+*/
+func (d *Driver) ResizeVolume(req Request) error {
+	id := req.Name
+
+	d.volumeLocks.Lock(id)
+	defer d.volumeLocks.Unlock(id)
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	size, err := util.ParseSize(req.Options[OPT_SIZE])
+	if err != nil {
+		return err
+	}
+	ebsVolume, err := d.ebsService.GetVolume(volume.EBSID)
+	if err != nil {
+		return err
+	}
+	currentSize := aws.Int64Value(ebsVolume.Size) * GB
+	if size <= currentSize {
+		return fmt.Errorf("Volume %v of %v bytes cannot be resized to %v bytes, it can only be expanded", id, currentSize, size)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_EXTEND,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+		LOG_FIELD_SIZE:   size,
+	}).Debugf("Modifying EBS volume %v from %v bytes", volume.EBSID, currentSize)
+	if err := d.ebsService.ModifyVolume(volume.EBSID, size); err != nil {
+		return err
+	}
+	if volume.MountPoint == "" {
+		return nil
+	}
+	// Size is rounded up to GB as the EBS volume is
+	ebsVolume.Size = aws.Int64((size + GB - 1) / GB)
+	grown, err := d.growFilesystem(volume, ebsVolume)
+	if err != nil {
+		log.Warnf("Failed to grow filesystem of volume %v after modifying EBS volume %v, would retry later: %v",
+			id, volume.EBSID, err)
+		return nil
+	}
+	if !grown {
+		return nil
+	}
+	return util.ObjectSave(volume)
+}
+
 func (d *Driver) UmountVolume(req Request) error {
 	id := req.Name
 
+	d.volumeLocks.Lock(id)
+	defer d.volumeLocks.Unlock(id)
+
 	volume := d.blankVolume(id)
 	if err := util.ObjectLoad(volume); err != nil {
 		return err
@@ -489,22 +1239,89 @@ func (d *Driver) UmountVolume(req Request) error {
 	return nil
 }
 
-func (d *Driver) MountPoint(req Request) (string, error) {
-	id := req.Name
+/*
+Drain detaches all the volumes not mounted from the instance at once, so they
+can be attached to other instances, e.g. before the instance is terminated.
+Mounted volumes are left attached and reported as failures, they should be
+unmounted first. Drained volumes are attached again when they're mounted, or
+when daemon starts next time.
+*/
+func (d *Driver) Drain() ([]Reconciliation, error) {
+	names, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	results := []Reconciliation{}
+	volumes := make(map[string]*Volume)
+	ebsIDs := []string{}
+	for _, name := range names {
+		// Hold the locks until detached, so the volumes cannot be mounted
+		// meanwhile
+		d.volumeLocks.Lock(name)
+		defer d.volumeLocks.Unlock(name)
 
-	volume := d.blankVolume(id)
-	if err := util.ObjectLoad(volume); err != nil {
+		volume := d.blankVolume(name)
+		if err := util.ObjectLoad(volume); err != nil {
+			return results, err
+		}
+		if volume.Device == "" {
+			continue
+		}
+		if volume.MountPoint != "" {
+			results = append(results, Reconciliation{
+				Volume: name,
+				Err:    fmt.Errorf("Volume is mounted at %v, unmount it before drain", volume.MountPoint),
+			})
+			continue
+		}
+		volumes[volume.EBSID] = volume
+		ebsIDs = append(ebsIDs, volume.EBSID)
+	}
+
+	errs := d.ebsService.DetachVolumes(ebsIDs)
+	for _, ebsID := range ebsIDs {
+		volume := volumes[ebsID]
+		if err, failed := errs[ebsID]; failed {
+			results = append(results, Reconciliation{Volume: volume.Name, Err: err})
+			continue
+		}
+		volume.Device = ""
+		if err := util.ObjectSave(volume); err != nil {
+			return results, err
+		}
+		results = append(results, Reconciliation{
+			Volume: volume.Name,
+			Action: fmt.Sprintf("detached EBS volume %v", ebsID),
+		})
+	}
+	return results, nil
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	volume, err := d.loadVolume(req.Name)
+	if err != nil {
 		return "", err
 	}
 	return volume.MountPoint, nil
 }
 
-func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
+// VolumeUsage reports the size of EBS volume, which is all allocated and
+// billed regardless of usage of its filesystem
+func (d *Driver) VolumeUsage(name string) (int64, error) {
+	volume, err := d.loadVolume(name)
+	if err != nil {
+		return 0, err
+	}
+	ebsVolume, err := d.ebsService.GetVolume(volume.EBSID)
+	if err != nil {
+		return 0, err
+	}
+	return *ebsVolume.Size * GB, nil
+}
 
-	volume := d.blankVolume(id)
-	if err := util.ObjectLoad(volume); err != nil {
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	volume, err := d.loadVolume(id)
+	if err != nil {
 		return nil, err
 	}
 
@@ -521,6 +1338,10 @@ func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
 		"Device":                volume.Device,
 		"MountPoint":            volume.MountPoint,
 		"EBSVolumeID":           volume.EBSID,
+		OPT_FILESYSTEM:          volume.Filesystem,
+		OPT_MKFS_OPTIONS:        volume.MkfsOptions,
+		OPT_MOUNT_OPTIONS:       volume.MountOptions,
+		OPT_ENCRYPTION_KEY:      volume.EncryptionKey,
 		"KmsKeyId":              aws.StringValue(ebsVolume.KmsKeyId),
 		"AvailiablityZone":      aws.StringValue(ebsVolume.AvailabilityZone),
 		OPT_VOLUME_NAME:         id,
@@ -529,6 +1350,7 @@ func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
 		"State":                 aws.StringValue(ebsVolume.State),
 		"Type":                  aws.StringValue(ebsVolume.VolumeType),
 		"IOPS":                  iops,
+		OPT_FSFREEZE:            strconv.FormatBool(d.shouldFreeze(volume, false)),
 	}
 
 	return info, nil
@@ -572,15 +1394,20 @@ func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*Snapshot, *
 }
 
 func (d *Driver) CreateSnapshot(req Request) error {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-
 	id := req.Name
 	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
 	if err != nil {
 		return err
 	}
 
+	d.volumeLocks.Lock(volumeID)
+	locked := true
+	defer func() {
+		if locked {
+			d.volumeLocks.Unlock(volumeID)
+		}
+	}()
+
 	volume := d.blankVolume(volumeID)
 	if err := util.ObjectLoad(volume); err != nil {
 		return err
@@ -593,44 +1420,48 @@ func (d *Driver) CreateSnapshot(req Request) error {
 		}, "Already has snapshot with uuid")
 	}
 
+	// Labels of snapshot are tags of the EBS snapshot
+	tags, err := util.DecodeLabels(req.Options[OPT_LABELS])
+	if err != nil {
+		return err
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags["ConvoyVolumeName"] = volumeID
+	tags["ConvoySnapshotName"] = id
+
+	thaw := func() {}
 	if volume.MountPoint != "" {
 		log.Debugf("syncing filesystems...")
 		if err := util.Sync(); err != nil {
 			return err
 		}
 
-		if d.FsFreeze == "true" {
-			log.Debugf("freezing %v", volume.MountPoint)
-			if err := util.Freeze( volume.MountPoint ); err != nil {
+		fsFreeze, _ := strconv.ParseBool(req.Options[OPT_FSFREEZE])
+		if d.shouldFreeze(volume, fsFreeze) {
+			if thaw, err = util.FreezeFilesystem(volume.MountPoint); err != nil {
 				return err
 			}
 		}
 	}
 
-	tags := map[string]string{
-		"ConvoyVolumeName":   volumeID,
-		"ConvoySnapshotName": id,
-	}
 	request := &CreateSnapshotRequest{
 		VolumeID:    volume.EBSID,
 		Description: fmt.Sprintf("Convoy snapshot"),
 		Tags:        tags,
 	}
+	// EBS snapshot is point-in-time once CreateSnapshot returns, so writes
+	// are only paused until then, rather than until the snapshot completes
+	freezeStart := time.Now()
 	ebsSnapshotID, err := d.ebsService.CreateSnapshot(request)
+	thaw()
 	if err != nil {
 		return err
 	}
 
-	if volume.MountPoint != "" {
-		if d.FsFreeze == "true" {
-			log.Debugf("unfreezing %v", volume.MountPoint)
-			if err := util.UnFreeze( volume.MountPoint ); err != nil {
-				return err
-			}
-		}
-	}
-	
-	log.Debugf("Creating snapshot %v(%v) of volume %v(%v)", id, ebsSnapshotID, volumeID, volume.EBSID)
+	log.Debugf("Creating snapshot %v(%v) of volume %v(%v), writes were paused for at most %v",
+		id, ebsSnapshotID, volumeID, volume.EBSID, time.Since(freezeStart))
 
 	snapshot = Snapshot{
 		Name:       id,
@@ -638,19 +1469,134 @@ func (d *Driver) CreateSnapshot(req Request) error {
 		EBSID:      ebsSnapshotID,
 	}
 	volume.Snapshots[id] = snapshot
+	if err := util.ObjectSave(volume); err != nil {
+		return err
+	}
+	kmsKeyID := req.Options[OPT_KMS_KEY_ID]
+	if kmsKeyID == "" {
+		return nil
+	}
+
+	// Snapshot and its encrypted copy take minutes to complete, so the
+	// volume isn't locked meanwhile, e.g. for listing volumes
+	d.volumeLocks.Unlock(volumeID)
+	locked = false
+	encryptedID, encryptErr := d.encryptSnapshot(ebsSnapshotID, kmsKeyID, request)
+	d.volumeLocks.Lock(volumeID)
+	locked = true
+
+	volume = d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if _, exists := volume.Snapshots[id]; !exists {
+		if encryptErr == nil && encryptedID != ebsSnapshotID {
+			if err := d.ebsService.DeleteSnapshot(encryptedID); err != nil {
+				log.Warnf("Failed to remove encrypted snapshot %v, but continue: %v", encryptedID, err)
+			}
+		}
+		return fmt.Errorf("Snapshot %v of volume %v was deleted while being encrypted", id, volumeID)
+	}
+	if encryptErr != nil {
+		delete(volume.Snapshots, id)
+		if err := util.ObjectSave(volume); err != nil {
+			return err
+		}
+		return encryptErr
+	}
+	snapshot.EBSID = encryptedID
+	volume.Snapshots[id] = snapshot
 	return util.ObjectSave(volume)
 }
 
-func (d *Driver) DeleteSnapshot(req Request) error {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
+// shouldFreeze returns whether filesystem of the volume should be frozen while
+// its snapshot is started. The volume's own setting overrides ebs.fsfreeze,
+// unless the snapshot asks for freezing.
+func (d *Driver) shouldFreeze(volume *Volume, fsFreeze bool) bool {
+	if fsFreeze {
+		return true
+	}
+	if volume.FsFreeze != "" {
+		return volume.FsFreeze == "true"
+	}
+	return d.defaults().FsFreeze == "true"
+}
 
+/*
+encryptSnapshot makes sure the snapshot is encrypted with the KMS key. If it's
+not, e.g. snapshot of a legacy unencrypted volume, the snapshot would be copied
+to an encrypted one once completed, then the unencrypted one would be removed.
+The ID of the encrypted snapshot would be returned. The unencrypted snapshot
+would be removed as well if it fails, along with the copy if there is one.
+It's called without lock of the volume, since it waits for both snapshots to
+complete.
+*/
+func (d *Driver) encryptSnapshot(ebsSnapshotID, kmsKeyID string, request *CreateSnapshotRequest) (encryptedID string, err error) {
+	defer func() {
+		if err == nil {
+			return
+		}
+		if encryptedID != "" {
+			if err := d.ebsService.DeleteSnapshot(encryptedID); err != nil {
+				log.Warnf("Failed to remove encrypted snapshot %v, but continue: %v", encryptedID, err)
+			}
+			encryptedID = ""
+		}
+		if err := d.ebsService.DeleteSnapshot(ebsSnapshotID); err != nil {
+			log.Warnf("Failed to remove unencrypted snapshot %v, but continue: %v", ebsSnapshotID, err)
+		}
+	}()
+
+	// EC2 reports ARN of the key, while it may be specified by ID or alias
+	keyARN, err := d.ebsService.GetKMSKeyARN(kmsKeyID)
+	if err != nil {
+		return "", err
+	}
+	if err := d.ebsService.WaitForSnapshotComplete(ebsSnapshotID); err != nil {
+		return "", err
+	}
+	ebsSnapshot, err := d.ebsService.GetSnapshot(ebsSnapshotID)
+	if err != nil {
+		return "", err
+	}
+	if aws.StringValue(ebsSnapshot.State) != ec2.SnapshotStateCompleted {
+		return "", fmt.Errorf("Snapshot %v is in state %v, cannot be encrypted",
+			ebsSnapshotID, aws.StringValue(ebsSnapshot.State))
+	}
+	if aws.BoolValue(ebsSnapshot.Encrypted) && aws.StringValue(ebsSnapshot.KmsKeyId) == keyARN {
+		log.Debugf("Snapshot %v is already encrypted with %v", ebsSnapshotID, kmsKeyID)
+		return ebsSnapshotID, nil
+	}
+
+	encryptedID, err = d.ebsService.EncryptSnapshot(&EncryptSnapshotRequest{
+		SnapshotID:  ebsSnapshotID,
+		KmsKeyID:    kmsKeyID,
+		Description: request.Description,
+		Tags:        request.Tags,
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("Copying snapshot %v to %v encrypted with %v", ebsSnapshotID, encryptedID, kmsKeyID)
+	if err := d.ebsService.WaitForSnapshotComplete(encryptedID); err != nil {
+		return encryptedID, err
+	}
+	if err := d.ebsService.DeleteSnapshot(ebsSnapshotID); err != nil {
+		log.Warnf("Failed to remove unencrypted snapshot %v, but continue: %v", ebsSnapshotID, err)
+	}
+	return encryptedID, nil
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
 	id := req.Name
 	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
 	if err != nil {
 		return err
 	}
 
+	d.volumeLocks.Lock(volumeID)
+	defer d.volumeLocks.Unlock(volumeID)
+
 	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
 	if err != nil {
 		return err
@@ -662,15 +1608,15 @@ func (d *Driver) DeleteSnapshot(req Request) error {
 }
 
 func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-
 	id := req.Name
 	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
 	if err != nil {
 		return nil, err
 	}
 
+	d.volumeLocks.RLock(volumeID)
+	defer d.volumeLocks.RUnlock(volumeID)
+
 	return d.getSnapshotInfo(id, volumeID)
 }
 
@@ -712,9 +1658,6 @@ func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error)
 }
 
 func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-
 	var (
 		volumeIDs []string
 		err       error
@@ -732,20 +1675,31 @@ func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]str
 		}
 	}
 	for _, volumeID := range volumeIDs {
-		volume := d.blankVolume(volumeID)
-		if err := util.ObjectLoad(volume); err != nil {
+		if err := d.listVolumeSnapshots(volumeID, snapshots); err != nil {
 			return nil, err
 		}
-		for snapshotID := range volume.Snapshots {
-			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
-			if err != nil {
-				return nil, err
-			}
-		}
 	}
 	return snapshots, nil
 }
 
+func (d *Driver) listVolumeSnapshots(volumeID string, snapshots map[string]map[string]string) error {
+	d.volumeLocks.RLock(volumeID)
+	defer d.volumeLocks.RUnlock(volumeID)
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	for snapshotID := range volume.Snapshots {
+		info, err := d.getSnapshotInfo(snapshotID, volumeID)
+		if err != nil {
+			return err
+		}
+		snapshots[snapshotID] = info
+	}
+	return nil
+}
+
 func (d *Driver) BackupOps() (BackupOperations, error) {
 	return d, nil
 }
@@ -790,6 +1744,16 @@ func (d *Driver) CreateBackup(snapshotID, volumeID, destURL string, opts map[str
 	if err := d.ebsService.WaitForSnapshotComplete(snapshot.EBSID); err != nil {
 		return "", err
 	}
+	// Labels of backup are tags of the EBS snapshot
+	labels, err := util.DecodeLabels(opts[OPT_BACKUP_LABELS])
+	if err != nil {
+		return "", err
+	}
+	if len(labels) != 0 {
+		if err := d.ebsService.AddTags(snapshot.EBSID, labels); err != nil {
+			return "", err
+		}
+	}
 	return encodeURL(d.ebsService.Region, snapshot.EBSID), nil
 }
 
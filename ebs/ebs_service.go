@@ -3,16 +3,21 @@ package ebs
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/rancher/convoy/util"
 )
 
 const (
@@ -27,10 +32,15 @@ var (
 type ebsService struct {
 	metadataClient *ec2metadata.EC2Metadata
 	ec2Client      *ec2.EC2
+	kmsClient      *kmsClient
 
 	InstanceID       string
 	Region           string
 	AvailabilityZone string
+
+	// Attachments pick free devices of the instance and find the new
+	// local devices, so they're serialized
+	attachMutex sync.Mutex
 }
 
 type CreateEBSVolumeRequest struct {
@@ -49,6 +59,39 @@ type CreateSnapshotRequest struct {
 	Tags        map[string]string
 }
 
+type EncryptSnapshotRequest struct {
+	SnapshotID  string
+	KmsKeyID    string
+	Description string
+	Tags        map[string]string
+}
+
+/*
+modifyVolumeInput, modifyVolumeOutput and volumeModification are shapes of
+EC2 ModifyVolume, which the vendored SDK predates, so the request is built
+from them directly.
+*/
+type modifyVolumeInput struct {
+	_ struct{} `type:"structure"`
+
+	VolumeId *string `type:"string" required:"true"`
+	Size     *int64  `type:"integer"`
+}
+
+type modifyVolumeOutput struct {
+	_ struct{} `type:"structure"`
+
+	VolumeModification *volumeModification `locationName:"volumeModification" type:"structure"`
+}
+
+type volumeModification struct {
+	_ struct{} `type:"structure"`
+
+	ModificationState *string `locationName:"modificationState" type:"string"`
+	StatusMessage     *string `locationName:"statusMessage" type:"string"`
+	TargetSize        *int64  `locationName:"targetSize" type:"integer"`
+}
+
 func sleepBeforeRetry() {
 	time.Sleep(RETRY_INTERVAL * time.Second)
 }
@@ -92,7 +135,8 @@ func NewEBSService() (*ebsService, error) {
 	}
 
 	config := aws.NewConfig().WithRegion(s.Region)
-	s.ec2Client = ec2.New(session.New(), config)
+	s.ec2Client = ec2.New(util.NewAWSSession(), config)
+	s.kmsClient = newKMSClient(util.NewAWSSession(), config)
 
 	return s, nil
 }
@@ -101,58 +145,78 @@ func (s *ebsService) isEC2Instance() bool {
 	return s.metadataClient.Available()
 }
 
-func (s *ebsService) waitForVolumeTransition(volumeID, start, end string) error {
-	volume, err := s.GetVolume(volumeID)
-	if err != nil {
-		return err
-	}
-
-	for *volume.State == start {
-		log.Debugf("Waiting for volume %v state transiting from %v to %v",
-			volumeID, start, end)
-		volume, err = s.GetVolume(volumeID)
+/*
+waitForVolumes polls the volumes together, with one DescribeVolumes call per
+round however many volumes are waited, until check of every volume returns
+done or an error. Errors are per volume, so one volume failing won't stop
+waiting for the others.
+*/
+func (s *ebsService) waitForVolumes(volumeIDs []string, check func(volume *ec2.Volume) (bool, error)) map[string]error {
+	errs := make(map[string]error)
+	pending := make([]string, len(volumeIDs))
+	copy(pending, volumeIDs)
+	for len(pending) != 0 {
+		sleepBeforeRetry()
+		volumes, err := s.GetVolumes(pending)
 		if err != nil {
-			return err
+			for _, id := range pending {
+				errs[id] = err
+			}
+			return errs
 		}
+		next := []string{}
+		for _, id := range pending {
+			volume, exists := volumes[id]
+			if !exists {
+				errs[id] = fmt.Errorf("Cannot find volume %v", id)
+				continue
+			}
+			done, err := check(volume)
+			if err != nil {
+				errs[id] = err
+			} else if !done {
+				next = append(next, id)
+			}
+		}
+		if len(next) != 0 {
+			log.Debugf("Waiting for %v volume(s) to finish state transition: %v", len(next), next)
+		}
+		pending = next
 	}
-	if *volume.State != end {
-		return fmt.Errorf("Cannot finish volume %v state transition, from %v to %v, though final state %v",
-			volumeID, start, end, *volume.State)
-	}
-	return nil
+	return errs
 }
 
-func (s *ebsService) waitForVolumeAttaching(volumeID string) error {
-	var attachment *ec2.VolumeAttachment
-	volume, err := s.GetVolume(volumeID)
-	if err != nil {
-		return err
+func checkVolumeAttached(volume *ec2.Volume) (bool, error) {
+	// Attachment may not show up right after the request
+	if len(volume.Attachments) == 0 {
+		return false, nil
 	}
-	for len(volume.Attachments) == 0 {
-		log.Debugf("Retry to get attachment of volume")
-		volume, err = s.GetVolume(volumeID)
-		if err != nil {
-			return err
-		}
+	state := aws.StringValue(volume.Attachments[0].State)
+	switch state {
+	case ec2.VolumeAttachmentStateAttaching:
+		return false, nil
+	case ec2.VolumeAttachmentStateAttached:
+		return true, nil
 	}
-	attachment = volume.Attachments[0]
+	return false, fmt.Errorf("Cannot attach volume %v, final state %v", aws.StringValue(volume.VolumeId), state)
+}
 
-	for *attachment.State == ec2.VolumeAttachmentStateAttaching {
-		log.Debugf("Waiting for volume %v attaching", volumeID)
-		volume, err := s.GetVolume(volumeID)
-		if err != nil {
-			return err
+func checkVolumeTransition(start, end string) func(volume *ec2.Volume) (bool, error) {
+	return func(volume *ec2.Volume) (bool, error) {
+		state := aws.StringValue(volume.State)
+		switch state {
+		case start:
+			return false, nil
+		case end:
+			return true, nil
 		}
-		if len(volume.Attachments) != 0 {
-			attachment = volume.Attachments[0]
-		} else {
-			return fmt.Errorf("Attaching failed for ", volumeID)
-		}
-	}
-	if *attachment.State != ec2.VolumeAttachmentStateAttached {
-		return fmt.Errorf("Cannot attach volume, final state %v", *attachment.State)
+		return false, fmt.Errorf("Cannot finish volume %v state transition, from %v to %v, though final state %v",
+			aws.StringValue(volume.VolumeId), start, end, state)
 	}
-	return nil
+}
+
+func (s *ebsService) waitForVolumeTransition(volumeID, start, end string) error {
+	return s.waitForVolumes([]string{volumeID}, checkVolumeTransition(start, end))[volumeID]
 }
 
 func (s *ebsService) CreateVolume(request *CreateEBSVolumeRequest) (string, error) {
@@ -179,7 +243,10 @@ func (s *ebsService) CreateVolume(request *CreateEBSVolumeRequest) (string, erro
 
 	if snapshotID != "" {
 		params.SnapshotId = aws.String(snapshotID)
-	} else if kmsKeyID != "" {
+	}
+	// Volume created from snapshot would be re-encrypted with the key, or
+	// inherit encryption of the snapshot if not specified
+	if kmsKeyID != "" {
 		params.KmsKeyId = aws.String(kmsKeyID)
 		params.Encrypted = aws.Bool(true)
 	}
@@ -249,6 +316,84 @@ func (s *ebsService) GetVolume(volumeID string) (*ec2.Volume, error) {
 	return volumes.Volumes[0], nil
 }
 
+/*
+GetVolumes describes the volumes with one call. Volumes are filtered rather
+than requested by ID, so a missing volume would be absent from the result
+instead of failing the whole call.
+*/
+func (s *ebsService) GetVolumes(volumeIDs []string) (map[string]*ec2.Volume, error) {
+	params := &ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("volume-id"),
+				Values: aws.StringSlice(volumeIDs),
+			},
+		},
+	}
+	result := make(map[string]*ec2.Volume)
+	err := s.ec2Client.DescribeVolumesPages(params, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		for _, volume := range page.Volumes {
+			result[aws.StringValue(volume.VolumeId)] = volume
+		}
+		return true
+	})
+	if err != nil {
+		return nil, parseAwsError(err)
+	}
+	return result, nil
+}
+
+/*
+ModifyVolume resizes the EBS volume, rounding size up to GB. It returns once
+the modification starts, it may take a while before the attached device shows
+the new size.
+*/
+func (s *ebsService) ModifyVolume(volumeID string, size int64) error {
+	ebsSize := size / GB
+	if size%GB > 0 {
+		ebsSize += 1
+	}
+	op := &request.Operation{
+		Name:       "ModifyVolume",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	params := &modifyVolumeInput{
+		VolumeId: aws.String(volumeID),
+		Size:     aws.Int64(ebsSize),
+	}
+	output := &modifyVolumeOutput{}
+	if err := s.ec2Client.NewRequest(op, params, output).Send(); err != nil {
+		return parseAwsError(err)
+	}
+	if m := output.VolumeModification; m != nil {
+		log.Debugf("Modifying EBS volume %v to %v GB, state %v %v", volumeID, aws.Int64Value(m.TargetSize),
+			aws.StringValue(m.ModificationState), aws.StringValue(m.StatusMessage))
+	}
+	return nil
+}
+
+// CheckAvailabilityZone verifies EC2 API is reachable and the availability
+// zone of the instance is available
+func (s *ebsService) CheckAvailabilityZone() error {
+	params := &ec2.DescribeAvailabilityZonesInput{
+		ZoneNames: []*string{
+			aws.String(s.AvailabilityZone),
+		},
+	}
+	zones, err := s.ec2Client.DescribeAvailabilityZones(params)
+	if err != nil {
+		return parseAwsError(err)
+	}
+	if len(zones.AvailabilityZones) != 1 {
+		return fmt.Errorf("Cannot find availability zone %v", s.AvailabilityZone)
+	}
+	if state := aws.StringValue(zones.AvailabilityZones[0].State); state != ec2.AvailabilityZoneStateAvailable {
+		return fmt.Errorf("Availability zone %v is %v", s.AvailabilityZone, state)
+	}
+	return nil
+}
+
 func getBlkDevList() (map[string]bool, error) {
 	devList := make(map[string]bool)
 	dirList, err := ioutil.ReadDir("/sys/block")
@@ -320,31 +465,40 @@ func (s *ebsService) getInstanceDevList() (map[string]bool, error) {
 }
 
 func (s *ebsService) FindFreeDeviceForAttach() (string, error) {
-	availableDevs := make(map[string]bool)
-	// Recommended available devices for EBS volume from AWS website
-	chars := "fghijklmnop"
-	for i := 0; i < len(chars); i++ {
-		availableDevs["/dev/sd"+string(chars[i])] = true
+	devs, err := s.findFreeDevicesForAttach(1)
+	if err != nil {
+		return "", err
 	}
+	return devs[0], nil
+}
+
+// findFreeDevicesForAttach returns count distinct devices, so volumes can be
+// attached at the same time without racing for the same device
+func (s *ebsService) findFreeDevicesForAttach(count int) ([]string, error) {
 	devMap, err := s.getInstanceDevList()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	for d := range devMap {
-		if _, ok := availableDevs[d]; !ok {
-			continue
+	devs := []string{}
+	// Recommended available devices for EBS volume from AWS website
+	chars := "fghijklmnop"
+	for i := 0; i < len(chars) && len(devs) < count; i++ {
+		dev := "/dev/sd" + string(chars[i])
+		if !devMap[dev] {
+			devs = append(devs, dev)
 		}
-		availableDevs[d] = false
 	}
-	for dev, available := range availableDevs {
-		if available {
-			return dev, nil
-		}
+	if len(devs) < count {
+		return nil, fmt.Errorf("Cannot find %v available devices for instance %v, only %v left",
+			count, s.InstanceID, len(devs))
 	}
-	return "", fmt.Errorf("Cannot find an available device for instance %v", s.InstanceID)
+	return devs, nil
 }
 
 func (s *ebsService) AttachVolume(volumeID string, size int64) (string, error) {
+	s.attachMutex.Lock()
+	defer s.attachMutex.Unlock()
+
 	dev, err := s.FindFreeDeviceForAttach()
 	if err != nil {
 		return "", err
@@ -366,7 +520,7 @@ func (s *ebsService) AttachVolume(volumeID string, size int64) (string, error) {
 		return "", parseAwsError(err)
 	}
 
-	if err = s.waitForVolumeAttaching(volumeID); err != nil {
+	if err := s.waitForVolumes([]string{volumeID}, checkVolumeAttached)[volumeID]; err != nil {
 		return "", err
 	}
 
@@ -377,17 +531,132 @@ func (s *ebsService) AttachVolume(volumeID string, size int64) (string, error) {
 	return result, nil
 }
 
-func (s *ebsService) DetachVolume(volumeID string) error {
-	params := &ec2.DetachVolumeInput{
-		VolumeId:   aws.String(volumeID),
-		InstanceId: aws.String(s.InstanceID),
+/*
+GetLocalDevice would find the block device of an EBS volume already attached to
+current instance. The device name seen by the kernel may differ from the one
+used for attachment, e.g. /dev/sdf would show up as /dev/xvdf on Xen, or as
+/dev/nvme1n1 on Nitro instances, and may change across instance stop/start.
+*/
+func (s *ebsService) GetLocalDevice(volumeID, attachDevice string) (string, error) {
+	// NVMe EBS devices are identified by the volume ID without the dash
+	nvmeLink := "/dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_" + strings.Replace(volumeID, "-", "", 1)
+	if dev, err := filepath.EvalSymlinks(nvmeLink); err == nil {
+		return dev, nil
+	}
+	candidates := []string{attachDevice}
+	if strings.HasPrefix(attachDevice, "/dev/sd") {
+		candidates = append(candidates, "/dev/xvd"+strings.TrimPrefix(attachDevice, "/dev/sd"))
+	}
+	for _, dev := range candidates {
+		if _, err := os.Stat(dev); err == nil {
+			return dev, nil
+		}
 	}
+	return "", fmt.Errorf("Cannot find local device of EBS volume %v attached as %v", volumeID, attachDevice)
+}
 
-	if _, err := s.ec2Client.DetachVolume(params); err != nil {
-		return parseAwsError(err)
+/*
+AttachVolumes attaches the volumes at once, e.g. on boot of instance with
+many volumes. All attach requests are sent before waiting, and the volumes are
+waited together, so the time taken is about the same as attaching one volume.
+Local devices are found by volume ID rather than by size as AttachVolume does,
+since volumes of the same size would show up at the same time. Returns the
+local devices of attached volumes, and errors of the others.
+*/
+func (s *ebsService) AttachVolumes(volumeIDs []string) (map[string]string, map[string]error) {
+	devs := make(map[string]string)
+	errs := make(map[string]error)
+	if len(volumeIDs) == 0 {
+		return devs, errs
+	}
+
+	s.attachMutex.Lock()
+	defer s.attachMutex.Unlock()
+
+	attachDevs, err := s.findFreeDevicesForAttach(len(volumeIDs))
+	if err != nil {
+		for _, id := range volumeIDs {
+			errs[id] = err
+		}
+		return devs, errs
+	}
+
+	requested := []string{}
+	for i, id := range volumeIDs {
+		log.Debugf("Attaching %v to %v's %v", id, s.InstanceID, attachDevs[i])
+		params := &ec2.AttachVolumeInput{
+			Device:     aws.String(attachDevs[i]),
+			InstanceId: aws.String(s.InstanceID),
+			VolumeId:   aws.String(id),
+		}
+		if _, err := s.ec2Client.AttachVolume(params); err != nil {
+			errs[id] = parseAwsError(err)
+			continue
+		}
+		devs[id] = attachDevs[i]
+		requested = append(requested, id)
 	}
 
-	return s.waitForVolumeTransition(volumeID, ec2.VolumeStateInUse, ec2.VolumeStateAvailable)
+	for id, err := range s.waitForVolumes(requested, checkVolumeAttached) {
+		errs[id] = err
+		delete(devs, id)
+	}
+	for id, attachDev := range devs {
+		dev, err := s.waitForLocalDevice(id, attachDev)
+		if err != nil {
+			errs[id] = err
+			delete(devs, id)
+			continue
+		}
+		devs[id] = dev
+	}
+	return devs, errs
+}
+
+// waitForLocalDevice allows the kernel some time to bring up the device after
+// the attachment has completed
+func (s *ebsService) waitForLocalDevice(volumeID, attachDevice string) (string, error) {
+	var (
+		dev string
+		err error
+	)
+	for i := 0; i < 3; i++ {
+		if dev, err = s.GetLocalDevice(volumeID, attachDevice); err == nil {
+			return dev, nil
+		}
+		sleepBeforeRetry()
+	}
+	return "", err
+}
+
+func (s *ebsService) DetachVolume(volumeID string) error {
+	return s.DetachVolumes([]string{volumeID})[volumeID]
+}
+
+/*
+DetachVolumes detaches the volumes at once, e.g. on drain of the instance. As
+AttachVolumes, all requests are sent before the volumes are waited together.
+Returns errors of volumes failed to detach.
+*/
+func (s *ebsService) DetachVolumes(volumeIDs []string) map[string]error {
+	errs := make(map[string]error)
+	requested := []string{}
+	for _, id := range volumeIDs {
+		params := &ec2.DetachVolumeInput{
+			VolumeId:   aws.String(id),
+			InstanceId: aws.String(s.InstanceID),
+		}
+		if _, err := s.ec2Client.DetachVolume(params); err != nil {
+			errs[id] = parseAwsError(err)
+			continue
+		}
+		requested = append(requested, id)
+	}
+	detached := checkVolumeTransition(ec2.VolumeStateInUse, ec2.VolumeStateAvailable)
+	for id, err := range s.waitForVolumes(requested, detached) {
+		errs[id] = err
+	}
+	return errs
 }
 
 func (s *ebsService) GetSnapshotWithRegion(snapshotID, region string) (*ec2.Snapshot, error) {
@@ -398,7 +667,7 @@ func (s *ebsService) GetSnapshotWithRegion(snapshotID, region string) (*ec2.Snap
 	}
 	ec2Client := s.ec2Client
 	if region != s.Region {
-		ec2Client = ec2.New(session.New(), aws.NewConfig().WithRegion(region))
+		ec2Client = ec2.New(util.NewAWSSession(), aws.NewConfig().WithRegion(region))
 	}
 	snapshots, err := ec2Client.DescribeSnapshots(params)
 	if err != nil {
@@ -453,7 +722,7 @@ func (s *ebsService) DeleteSnapshotWithRegion(snapshotID, region string) error {
 	}
 	ec2Client := s.ec2Client
 	if region != s.Region {
-		ec2Client = ec2.New(session.New(), aws.NewConfig().WithRegion(region))
+		ec2Client = ec2.New(util.NewAWSSession(), aws.NewConfig().WithRegion(region))
 	}
 	_, err := ec2Client.DeleteSnapshot(params)
 	return parseAwsError(err)
@@ -478,6 +747,42 @@ func (s *ebsService) CopySnapshot(snapshotID, srcRegion string) (string, error)
 	return *resp.SnapshotId, nil
 }
 
+// EncryptSnapshot copies a completed snapshot in current region to a new one
+// encrypted with the KMS key
+func (s *ebsService) EncryptSnapshot(request *EncryptSnapshotRequest) (string, error) {
+	if request.KmsKeyID == "" {
+		return "", fmt.Errorf("Invalid empty KMS key ID for encrypting snapshot %v", request.SnapshotID)
+	}
+	params := &ec2.CopySnapshotInput{
+		SourceRegion:     aws.String(s.Region),
+		SourceSnapshotId: aws.String(request.SnapshotID),
+		Description:      aws.String(request.Description),
+		Encrypted:        aws.Bool(true),
+		KmsKeyId:         aws.String(request.KmsKeyID),
+	}
+
+	resp, err := s.ec2Client.CopySnapshot(params)
+	if err != nil {
+		return "", parseAwsError(err)
+	}
+	if request.Tags != nil {
+		if err := s.AddTags(*resp.SnapshotId, request.Tags); err != nil {
+			log.Warnf("Unable to tag %v with %v, but continue", *resp.SnapshotId, request.Tags)
+		}
+	}
+	return *resp.SnapshotId, nil
+}
+
+// GetKMSKeyARN returns ARN of the KMS key specified by its ID, ARN, alias
+// name or alias ARN, to compare with the ARN of key reported by EC2
+func (s *ebsService) GetKMSKeyARN(keyID string) (string, error) {
+	arn, err := s.kmsClient.DescribeKey(keyID)
+	if err != nil {
+		return "", parseAwsError(err)
+	}
+	return arn, nil
+}
+
 func (s *ebsService) AddTags(resourceID string, tags map[string]string) error {
 	if tags == nil {
 		return nil
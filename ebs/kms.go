@@ -0,0 +1,109 @@
+package ebs
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/private/signer/v4"
+)
+
+const (
+	KMS_SERVICE_NAME  = "kms"
+	KMS_TARGET_PREFIX = "TrentService"
+	KMS_JSON_VERSION  = "1.1"
+)
+
+/*
+kmsClient calls KMS by its JSON protocol, the same way as the client of the
+SDK does, for the only operation needed to resolve IDs and aliases of keys,
+rather than vendoring the whole KMS client and JSON protocol of the SDK.
+*/
+type kmsClient struct {
+	*client.Client
+}
+
+type kmsDescribeKeyInput struct {
+	KeyId string
+}
+
+type kmsDescribeKeyOutput struct {
+	KeyMetadata struct {
+		Arn   string
+		KeyId string
+	}
+}
+
+type kmsErrorResponse struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func newKMSClient(p client.ConfigProvider, cfgs ...*aws.Config) *kmsClient {
+	c := p.ClientConfig(KMS_SERVICE_NAME, cfgs...)
+	svc := &kmsClient{
+		Client: client.New(*c.Config, metadata.ClientInfo{
+			ServiceName:   KMS_SERVICE_NAME,
+			SigningRegion: c.SigningRegion,
+			Endpoint:      c.Endpoint,
+			APIVersion:    "2014-11-01",
+			JSONVersion:   KMS_JSON_VERSION,
+			TargetPrefix:  KMS_TARGET_PREFIX,
+		}, c.Handlers),
+	}
+	svc.Handlers.Sign.PushBack(v4.Sign)
+	svc.Handlers.Build.PushBack(buildKMSRequest)
+	svc.Handlers.Unmarshal.PushBack(unmarshalKMSResponse)
+	svc.Handlers.UnmarshalError.PushBack(unmarshalKMSError)
+	return svc
+}
+
+func buildKMSRequest(r *request.Request) {
+	body, err := json.Marshal(r.Params)
+	if err != nil {
+		r.Error = awserr.New("SerializationError", "failed to encode KMS request", err)
+		return
+	}
+	r.SetBufferBody(body)
+	r.HTTPRequest.Header.Set("X-Amz-Target", r.ClientInfo.TargetPrefix+"."+r.Operation.Name)
+	r.HTTPRequest.Header.Set("Content-Type", "application/x-amz-json-"+r.ClientInfo.JSONVersion)
+}
+
+func unmarshalKMSResponse(r *request.Request) {
+	defer r.HTTPResponse.Body.Close()
+	if err := json.NewDecoder(r.HTTPResponse.Body).Decode(r.Data); err != nil && err != io.EOF {
+		r.Error = awserr.New("SerializationError", "failed to decode KMS response", err)
+	}
+}
+
+func unmarshalKMSError(r *request.Request) {
+	defer r.HTTPResponse.Body.Close()
+	resp := &kmsErrorResponse{}
+	if err := json.NewDecoder(r.HTTPResponse.Body).Decode(resp); err != nil && err != io.EOF {
+		r.Error = awserr.New("SerializationError", "failed to decode KMS error response", err)
+		return
+	}
+	// Type may be prefixed by namespace, e.g. "com.amazonaws.kms#NotFoundException"
+	code := resp.Type[strings.LastIndex(resp.Type, "#")+1:]
+	r.Error = awserr.NewRequestFailure(awserr.New(code, resp.Message, nil),
+		r.HTTPResponse.StatusCode, r.HTTPResponse.Header.Get("X-Amzn-RequestId"))
+}
+
+// DescribeKey returns ARN of the key by its ID, ARN, alias name or alias ARN
+func (c *kmsClient) DescribeKey(keyID string) (string, error) {
+	output := &kmsDescribeKeyOutput{}
+	req := c.NewRequest(&request.Operation{
+		Name:       "DescribeKey",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}, &kmsDescribeKeyInput{KeyId: keyID}, output)
+	if err := req.Send(); err != nil {
+		return "", err
+	}
+	return output.KeyMetadata.Arn, nil
+}
@@ -0,0 +1,225 @@
+/*
+convoy-flexvolume is a FlexVolume driver of Kubernetes, for clusters without
+CSI. Kubelet executes it to mount and unmount volumes of pods, which are
+published at the pod directories by the daemon of the node, through its unix
+domain socket. Volumes are only known to the daemon of the node they're
+created on, so there is nothing to attach or detach.
+
+It's installed as
+/usr/libexec/kubernetes/kubelet-plugins/volume/exec/rancher~convoy/convoy.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	DEFAULT_SOCKET = "/var/run/convoy/convoy.sock"
+	// Overrides DEFAULT_SOCKET, since kubelet passes no options to unmount
+	SOCKET_ENV = "CONVOY_SOCKET"
+
+	STATUS_SUCCESS       = "Success"
+	STATUS_FAILURE       = "Failure"
+	STATUS_NOT_SUPPORTED = "Not supported"
+
+	// Options of the flexVolume source of pods or persistent volumes
+	OPT_NAME       = "name"
+	OPT_DRIVER     = "driver"
+	OPT_SIZE       = "size"
+	OPT_TYPE       = "type"
+	OPT_IOPS       = "iops"
+	OPT_BACKUP     = "backup"
+	OPT_FILESYSTEM = "kubernetes.io/fsType"
+	// Set by kubelet
+	OPT_VOLUME_NAME = "kubernetes.io/pvOrVolumeName"
+	OPT_READ_WRITE  = "kubernetes.io/readwrite"
+)
+
+type driverStatus struct {
+	Status       string          `json:"status"`
+	Message      string          `json:"message,omitempty"`
+	Capabilities map[string]bool `json:"capabilities,omitempty"`
+}
+
+type convoyClient struct {
+	client *http.Client
+}
+
+func newClient() *convoyClient {
+	sockFile := os.Getenv(SOCKET_ENV)
+	if sockFile == "" {
+		sockFile = DEFAULT_SOCKET
+	}
+	return &convoyClient{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Dial: func(network, addr string) (net.Conn, error) {
+					return net.DialTimeout("unix", sockFile, 10*time.Second)
+				},
+			},
+		},
+	}
+}
+
+// call sends request to the daemon, decoding its response to resp unless nil.
+// It returns status code of the response as well.
+func (c *convoyClient) call(method, path string, request, resp interface{}) (int, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return -1, err
+	}
+	req, err := http.NewRequest(method, "http://convoy/v"+api.API_MAJOR_VERSION+path, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(api.API_VERSION_HEADER, api.API_VERSION)
+	r, err := c.client.Do(req)
+	if err != nil {
+		return -1, fmt.Errorf("Cannot connect to convoy daemon: %v", err)
+	}
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return r.StatusCode, err
+	}
+	if r.StatusCode < 200 || r.StatusCode >= 400 {
+		return r.StatusCode, fmt.Errorf("Error response from convoy daemon, %v", string(bytes.TrimSpace(data)))
+	}
+	if resp != nil {
+		return r.StatusCode, json.Unmarshal(data, resp)
+	}
+	return r.StatusCode, nil
+}
+
+// volumeName is name option of the flexVolume source, or name of the
+// persistent volume or pod volume
+func volumeName(opts map[string]string) (string, error) {
+	name := opts[OPT_NAME]
+	if name == "" {
+		name = opts[OPT_VOLUME_NAME]
+	}
+	if name == "" {
+		return "", fmt.Errorf("Option %v is required", OPT_NAME)
+	}
+	if !util.ValidateName(name) {
+		return "", fmt.Errorf("Invalid volume name %v", name)
+	}
+	return name, nil
+}
+
+// createVolume creates the volume unless it exists, with driver, size etc.
+// from options
+func (c *convoyClient) createVolume(name string, opts map[string]string) error {
+	code, err := c.call("GET", "/volumes/", &api.VolumeInspectRequest{
+		VolumeName: name,
+	}, nil)
+	if err == nil {
+		return nil
+	}
+	if code != http.StatusNotFound {
+		return err
+	}
+
+	size, err := util.ParseSize(opts[OPT_SIZE])
+	if err != nil {
+		return fmt.Errorf("Invalid option %v: %v", OPT_SIZE, err)
+	}
+	var iops int64
+	if opts[OPT_IOPS] != "" {
+		if iops, err = strconv.ParseInt(opts[OPT_IOPS], 10, 64); err != nil {
+			return fmt.Errorf("Invalid option %v: %v", OPT_IOPS, err)
+		}
+	}
+	_, err = c.call("POST", "/volumes/create", &api.VolumeCreateRequest{
+		Name:       name,
+		DriverName: opts[OPT_DRIVER],
+		Size:       size,
+		BackupURL:  opts[OPT_BACKUP],
+		Type:       opts[OPT_TYPE],
+		IOPS:       iops,
+		Filesystem: opts[OPT_FILESYSTEM],
+	}, nil)
+	return err
+}
+
+func (c *convoyClient) mount(target, jsonOptions string) error {
+	opts := map[string]string{}
+	if err := json.Unmarshal([]byte(jsonOptions), &opts); err != nil {
+		return fmt.Errorf("Invalid options %v: %v", jsonOptions, err)
+	}
+	name, err := volumeName(opts)
+	if err != nil {
+		return err
+	}
+	if err := c.createVolume(name, opts); err != nil {
+		return err
+	}
+	_, err = c.call("POST", "/volumes/publish", &api.VolumePublishRequest{
+		VolumeName: name,
+		TargetPath: target,
+		ReadOnly:   opts[OPT_READ_WRITE] == "ro",
+	}, nil)
+	return err
+}
+
+func (c *convoyClient) unmount(target string) error {
+	_, err := c.call("POST", "/volumes/unpublish", &api.VolumeUnpublishRequest{
+		TargetPath: target,
+	}, nil)
+	return err
+}
+
+func run(args []string) driverStatus {
+	if len(args) == 0 {
+		return driverStatus{Status: STATUS_FAILURE, Message: "Command is required"}
+	}
+	var err error
+	switch cmd := args[0]; cmd {
+	case "init":
+		return driverStatus{
+			Status:       STATUS_SUCCESS,
+			Capabilities: map[string]bool{"attach": false},
+		}
+	case "mount":
+		if len(args) != 3 {
+			return driverStatus{Status: STATUS_FAILURE, Message: "Usage: mount <mount dir> <json options>"}
+		}
+		err = newClient().mount(args[1], args[2])
+	case "unmount":
+		if len(args) != 2 {
+			return driverStatus{Status: STATUS_FAILURE, Message: "Usage: unmount <mount dir>"}
+		}
+		err = newClient().unmount(args[1])
+	default:
+		return driverStatus{Status: STATUS_NOT_SUPPORTED, Message: "Unsupported command " + cmd}
+	}
+	if err != nil {
+		return driverStatus{Status: STATUS_FAILURE, Message: err.Error()}
+	}
+	return driverStatus{Status: STATUS_SUCCESS}
+}
+
+func main() {
+	status := run(os.Args[1:])
+	output, err := json.Marshal(status)
+	if err != nil {
+		output = []byte(fmt.Sprintf(`{"status":%q,"message":%q}`, STATUS_FAILURE, err.Error()))
+	}
+	fmt.Println(string(output))
+	if status.Status == STATUS_FAILURE {
+		os.Exit(1)
+	}
+}
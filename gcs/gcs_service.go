@@ -0,0 +1,441 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	GCS_ENDPOINT = "https://storage.googleapis.com"
+	GCS_SCOPE    = "https://www.googleapis.com/auth/devstorage.read_write"
+
+	// ENV_CREDENTIALS points to service account JSON key file. Token of
+	// the instance's service account would be used if it's not set
+	ENV_CREDENTIALS = "GOOGLE_APPLICATION_CREDENTIALS"
+
+	GCE_TOKEN_URL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+	// Objects larger than the chunk would be uploaded with resumable
+	// upload. Chunk size must be multiple of 256KiB
+	GCS_UPLOAD_CHUNK_SIZE = 8 * 1024 * 1024
+	// Retries of each chunk before upload gives up
+	GCS_UPLOAD_RETRIES = 5
+)
+
+type GCSService struct {
+	Bucket string
+	// Endpoint of Cloud Storage JSON API, GCS_ENDPOINT by default
+	Endpoint string
+	// Client authorizes requests, created from credentials by default
+	Client *http.Client
+}
+
+type gcsObject struct {
+	Name string `json:"name"`
+	Size string `json:"size"`
+}
+
+type gcsObjectList struct {
+	Items         []gcsObject `json:"items"`
+	Prefixes      []string    `json:"prefixes"`
+	NextPageToken string      `json:"nextPageToken"`
+}
+
+type gcsError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+var (
+	// Wait before retrying a failed chunk grows by the interval each time
+	uploadRetryInterval = time.Second
+
+	// Token sources are shared by drivers, so tokens would be reused
+	// until they expire
+	tokenSources = make(map[string]oauth2.TokenSource)
+	tokenMutex   = &sync.Mutex{}
+)
+
+func getTokenSource() (oauth2.TokenSource, error) {
+	credentials := os.Getenv(ENV_CREDENTIALS)
+
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+
+	if ts, exists := tokenSources[credentials]; exists {
+		return ts, nil
+	}
+	var ts oauth2.TokenSource
+	if credentials == "" {
+		ts = oauth2.ReuseTokenSource(nil, gceTokenSource{})
+	} else {
+		data, err := ioutil.ReadFile(credentials)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read GCS credentials %v: %v", credentials, err)
+		}
+		key := &serviceAccountKey{}
+		if err := json.Unmarshal(data, key); err != nil {
+			return nil, fmt.Errorf("Invalid GCS credentials %v: %v", credentials, err)
+		}
+		if key.Type != "service_account" || key.ClientEmail == "" || key.PrivateKey == "" {
+			return nil, fmt.Errorf("GCS credentials %v is not a service account JSON key", credentials)
+		}
+		config := &jwt.Config{
+			Email:        key.ClientEmail,
+			PrivateKey:   []byte(key.PrivateKey),
+			PrivateKeyID: key.PrivateKeyID,
+			Scopes:       []string{GCS_SCOPE},
+			TokenURL:     key.TokenURI,
+		}
+		if config.TokenURL == "" {
+			config.TokenURL = "https://oauth2.googleapis.com/token"
+		}
+		ts = config.TokenSource(oauth2.NoContext)
+	}
+	tokenSources[credentials] = ts
+	return ts, nil
+}
+
+// gceTokenSource gets token of the default service account of the instance
+// from metadata server
+type gceTokenSource struct{}
+
+func (gceTokenSource) Token() (*oauth2.Token, error) {
+	req, err := http.NewRequest("GET", GCE_TOKEN_URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get GCS token from metadata server, %v should be set outside GCE: %v",
+			ENV_CREDENTIALS, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Cannot get GCS token from metadata server, status %v", resp.Status)
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken: token.AccessToken,
+		TokenType:   token.TokenType,
+		Expiry:      time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
+
+func (s *GCSService) New() error {
+	if s.Endpoint == "" {
+		s.Endpoint = GCS_ENDPOINT
+	}
+	if s.Client != nil {
+		return nil
+	}
+	ts, err := getTokenSource()
+	if err != nil {
+		return err
+	}
+	s.Client = oauth2.NewClient(oauth2.NoContext, ts)
+	return nil
+}
+
+func (s *GCSService) objectURL(key string) string {
+	return s.Endpoint + "/storage/v1/b/" + url.PathEscape(s.Bucket) + "/o/" + url.PathEscape(key)
+}
+
+func (s *GCSService) uploadURL(key, uploadType string) string {
+	v := url.Values{}
+	v.Add("uploadType", uploadType)
+	v.Add("name", key)
+	return s.Endpoint + "/upload/storage/v1/b/" + url.PathEscape(s.Bucket) + "/o?" + v.Encode()
+}
+
+func parseGCSError(resp *http.Response) error {
+	data, _ := ioutil.ReadAll(resp.Body)
+	gErr := &gcsError{}
+	if err := json.Unmarshal(data, gErr); err == nil && gErr.Error.Message != "" {
+		return fmt.Errorf("GCS Error: %v %v", gErr.Error.Code, gErr.Error.Message)
+	}
+	return fmt.Errorf("GCS Error: %v %v", resp.Status, strings.TrimSpace(string(data)))
+}
+
+func isNotFound(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "GCS Error: 404")
+}
+
+func (s *GCSService) do(method, u string, body io.Reader, header map[string]string) (*http.Response, error) {
+	if err := s.New(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return s.Client.Do(req)
+}
+
+// request returns response with successful status, caller needs to close
+// its body
+func (s *GCSService) request(method, u string, body io.Reader, header map[string]string) (*http.Response, error) {
+	resp, err := s.do(method, u, body, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, parseGCSError(resp)
+	}
+	return resp, nil
+}
+
+// ListObjects returns objects and prefixes under prefix, across all pages
+func (s *GCSService) ListObjects(prefix, delimiter string) ([]gcsObject, []string, error) {
+	if err := s.New(); err != nil {
+		return nil, nil, err
+	}
+	objects := []gcsObject{}
+	prefixes := []string{}
+	pageToken := ""
+	for {
+		v := url.Values{}
+		v.Add("prefix", prefix)
+		if delimiter != "" {
+			v.Add("delimiter", delimiter)
+		}
+		if pageToken != "" {
+			v.Add("pageToken", pageToken)
+		}
+		u := s.Endpoint + "/storage/v1/b/" + url.PathEscape(s.Bucket) + "/o?" + v.Encode()
+		resp, err := s.request("GET", u, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		list := &gcsObjectList{}
+		err = json.NewDecoder(resp.Body).Decode(list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, list.Items...)
+		prefixes = append(prefixes, list.Prefixes...)
+		if list.NextPageToken == "" {
+			break
+		}
+		pageToken = list.NextPageToken
+	}
+	return objects, prefixes, nil
+}
+
+func (s *GCSService) GetObjectSize(key string) (int64, error) {
+	if err := s.New(); err != nil {
+		return -1, err
+	}
+	resp, err := s.request("GET", s.objectURL(key), nil, nil)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	obj := &gcsObject{}
+	if err := json.NewDecoder(resp.Body).Decode(obj); err != nil {
+		return -1, err
+	}
+	return strconv.ParseInt(obj.Size, 10, 64)
+}
+
+func (s *GCSService) GetObject(key string) (io.ReadCloser, error) {
+	if err := s.New(); err != nil {
+		return nil, err
+	}
+	resp, err := s.request("GET", s.objectURL(key)+"?alt=media", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+/*
+PutObject uploads reader as key. Objects no larger than GCS_UPLOAD_CHUNK_SIZE
+are uploaded in a single request, larger ones with a resumable upload session,
+so a failed chunk can be retried without sending the whole object again.
+*/
+func (s *GCSService) PutObject(key string, reader io.ReadSeeker) error {
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.New(); err != nil {
+		return err
+	}
+	if size <= GCS_UPLOAD_CHUNK_SIZE {
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		resp, err := s.request("POST", s.uploadURL(key, "media"), bytes.NewReader(data), map[string]string{
+			"Content-Type": "application/octet-stream",
+		})
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	return s.resumableUpload(key, reader, size)
+}
+
+func (s *GCSService) resumableUpload(key string, reader io.ReadSeeker, size int64) error {
+	resp, err := s.request("POST", s.uploadURL(key, "resumable"), nil, map[string]string{
+		"X-Upload-Content-Type":   "application/octet-stream",
+		"X-Upload-Content-Length": strconv.FormatInt(size, 10),
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return fmt.Errorf("GCS didn't return resumable upload session for %v", key)
+	}
+
+	offset := int64(0)
+	retries := 0
+	buf := make([]byte, GCS_UPLOAD_CHUNK_SIZE)
+	for {
+		if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		header := map[string]string{
+			"Content-Range": fmt.Sprintf("bytes %v-%v/%v", offset, offset+int64(n)-1, size),
+		}
+		done, next, err := s.uploadChunk(session, bytes.NewReader(buf[:n]), header)
+		if err == nil {
+			if done {
+				return nil
+			}
+			if next > offset {
+				retries = 0
+			}
+			offset = next
+			continue
+		}
+		retries++
+		if retries > GCS_UPLOAD_RETRIES {
+			return fmt.Errorf("Failed to upload %v after %v retries: %v", key, GCS_UPLOAD_RETRIES, err)
+		}
+		log.Warnf("Failed to upload %v at offset %v, resuming: %v", key, offset, err)
+		time.Sleep(time.Duration(retries) * uploadRetryInterval)
+		// Ask GCS how much it has persisted
+		done, next, err = s.uploadChunk(session, nil, map[string]string{
+			"Content-Range": fmt.Sprintf("bytes */%v", size),
+		})
+		if err == nil {
+			if done {
+				return nil
+			}
+			offset = next
+		}
+	}
+}
+
+// uploadChunk returns whether the upload completed, or offset of the next
+// byte GCS expects
+func (s *GCSService) uploadChunk(session string, body io.Reader, header map[string]string) (bool, int64, error) {
+	if body == nil {
+		body = bytes.NewReader([]byte{})
+	}
+	resp, err := s.do("PUT", session, body, header)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+		return true, 0, nil
+	case resp.StatusCode == 308:
+		// Range would be "bytes=0-<last byte persisted>", or absent
+		// if nothing has been persisted
+		persisted := resp.Header.Get("Range")
+		if persisted == "" {
+			return false, 0, nil
+		}
+		parts := strings.SplitN(strings.TrimPrefix(persisted, "bytes="), "-", 2)
+		if len(parts) != 2 {
+			return false, 0, fmt.Errorf("Invalid range %v of resumable upload", persisted)
+		}
+		last, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return false, 0, err
+		}
+		return false, last + 1, nil
+	}
+	return false, 0, parseGCSError(resp)
+}
+
+func (s *GCSService) DeleteObject(key string) error {
+	if err := s.New(); err != nil {
+		return err
+	}
+	resp, err := s.request("DELETE", s.objectURL(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// DeleteObjects removes keys and all objects under them as directories
+func (s *GCSService) DeleteObjects(keys []string) error {
+	for _, key := range keys {
+		objects, _, err := s.ListObjects(key+"/", "")
+		if err != nil {
+			return err
+		}
+		objects = append(objects, gcsObject{Name: key})
+		for _, obj := range objects {
+			if err := s.DeleteObject(obj.Name); err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
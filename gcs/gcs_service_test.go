@@ -0,0 +1,232 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	server *httptest.Server
+	fake   *fakeGCS
+	driver *GCSObjectStoreDriver
+}
+
+var _ = Suite(&TestSuite{})
+
+const (
+	testBucket = "backups"
+)
+
+// fakeGCS serves the subset of Cloud Storage JSON API used by the driver,
+// and can fail a number of chunk uploads to test resuming
+type fakeGCS struct {
+	mutex      sync.Mutex
+	objects    map[string][]byte
+	sessions   map[string]*bytes.Buffer
+	names      map[string]string
+	failChunks int
+	pageSize   int
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	path := r.URL.EscapedPath()
+	query := r.URL.Query()
+	switch {
+	case strings.HasPrefix(path, "/session/"):
+		f.serveSession(w, r, strings.TrimPrefix(path, "/session/"))
+	case path == "/upload/storage/v1/b/"+testBucket+"/o":
+		name := query.Get("name")
+		if query.Get("uploadType") == "resumable" {
+			id := strconv.Itoa(len(f.sessions))
+			f.sessions[id] = &bytes.Buffer{}
+			f.names[id] = name
+			w.Header().Set("Location", "http://"+r.Host+"/session/"+id)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		f.objects[name] = data
+	case path == "/storage/v1/b/"+testBucket+"/o":
+		f.serveList(w, query)
+	case strings.HasPrefix(path, "/storage/v1/b/"+testBucket+"/o/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(path, "/storage/v1/b/"+testBucket+"/o/"))
+		data, exists := f.objects[name]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":404,"message":"No such object"}}`)
+			return
+		}
+		switch {
+		case r.Method == "DELETE":
+			delete(f.objects, name)
+		case query.Get("alt") == "media":
+			w.Write(data)
+		default:
+			json.NewEncoder(w).Encode(gcsObject{Name: name, Size: strconv.Itoa(len(data))})
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (f *fakeGCS) serveList(w http.ResponseWriter, query url.Values) {
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	names := []string{}
+	for name := range f.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := gcsObjectList{}
+	seen := make(map[string]bool)
+	start, _ := strconv.Atoi(query.Get("pageToken"))
+	matched := 0
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		matched++
+		if matched <= start {
+			continue
+		}
+		if len(list.Items)+len(list.Prefixes) == f.pageSize {
+			list.NextPageToken = strconv.Itoa(matched - 1)
+			break
+		}
+		rest := strings.TrimPrefix(name, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			p := prefix + rest[:i+1]
+			if !seen[p] {
+				seen[p] = true
+				list.Prefixes = append(list.Prefixes, p)
+			}
+			continue
+		}
+		list.Items = append(list.Items, gcsObject{Name: name, Size: strconv.Itoa(len(f.objects[name]))})
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+func (f *fakeGCS) serveSession(w http.ResponseWriter, r *http.Request, id string) {
+	buf := f.sessions[id]
+	data, _ := ioutil.ReadAll(r.Body)
+	var start, end, total int
+	if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes */%d", &total); err != nil {
+		fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total)
+		if f.failChunks > 0 {
+			f.failChunks--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if start != buf.Len() || end-start+1 != len(data) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		buf.Write(data)
+	}
+	if buf.Len() == total {
+		f.objects[f.names[id]] = buf.Bytes()
+		return
+	}
+	if buf.Len() != 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", buf.Len()-1))
+	}
+	w.WriteHeader(308)
+}
+
+func (s *TestSuite) SetUpSuite(c *C) {
+	uploadRetryInterval = time.Millisecond
+}
+
+func (s *TestSuite) SetUpTest(c *C) {
+	s.fake = &fakeGCS{
+		objects:  make(map[string][]byte),
+		sessions: make(map[string]*bytes.Buffer),
+		names:    make(map[string]string),
+		pageSize: 2,
+	}
+	s.server = httptest.NewServer(s.fake)
+	s.driver = &GCSObjectStoreDriver{
+		destURL: "gcs://" + testBucket + "/convoy",
+		path:    "convoy",
+		service: GCSService{
+			Bucket:   testBucket,
+			Endpoint: s.server.URL,
+			Client:   http.DefaultClient,
+		},
+	}
+}
+
+func (s *TestSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *TestSuite) TestObjects(c *C) {
+	d := s.driver
+	c.Assert(d.FileExists("volumes/vol1/volume.cfg"), Equals, false)
+
+	for _, name := range []string{"volumes/vol1/volume.cfg", "volumes/vol1/backups/backup_1.cfg",
+		"volumes/vol1/blocks/0a/bc/0abc.blk", "volumes/vol10/volume.cfg", "volumes/vol2/volume.cfg"} {
+		c.Assert(d.Write(name, bytes.NewReader([]byte(name))), IsNil)
+	}
+	c.Assert(d.FileSize("volumes/vol1/volume.cfg"), Equals, int64(len("volumes/vol1/volume.cfg")))
+
+	rc, err := d.Read("volumes/vol2/volume.cfg")
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "volumes/vol2/volume.cfg")
+
+	// Listing spans multiple pages
+	names, err := d.List("volumes")
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"vol1", "vol10", "vol2"})
+	names, err = d.List("volumes/vol1")
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"volume.cfg", "backups", "blocks"})
+
+	// Removing vol1 must not touch vol10
+	c.Assert(d.Remove("volumes/vol1"), IsNil)
+	names, err = d.List("volumes")
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"vol10", "vol2"})
+	c.Assert(d.Remove("volumes/vol2/volume.cfg", "volumes/nonexistent"), IsNil)
+	c.Assert(d.FileExists("volumes/vol2/volume.cfg"), Equals, false)
+
+	_, err = d.Read("volumes/vol2/volume.cfg")
+	c.Assert(err, ErrorMatches, "GCS Error: 404 No such object")
+}
+
+func (s *TestSuite) TestResumableUpload(c *C) {
+	data := make([]byte, 2*GCS_UPLOAD_CHUNK_SIZE+12345)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	s.fake.failChunks = 1
+	c.Assert(s.driver.Write("volumes/vol1/backup.bak", bytes.NewReader(data)), IsNil)
+	c.Assert(s.fake.sessions, HasLen, 1)
+	c.Assert(bytes.Equal(s.fake.objects["convoy/volumes/vol1/backup.bak"], data), Equals, true)
+
+	s.fake.failChunks = GCS_UPLOAD_RETRIES + 1
+	err := s.driver.Write("volumes/vol1/backup2.bak", bytes.NewReader(data))
+	c.Assert(err, ErrorMatches, "Failed to upload .* after .* retries.*")
+}
@@ -3,10 +3,13 @@ package glusterfs
 import (
 	"fmt"
 	"math/rand"
+	"net"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/convoy/util"
@@ -30,6 +33,9 @@ const (
 	GLUSTERFS_DEFAULT_VOLUME_POOL = "glusterfs.defaultvolumepool"
 	GLUSTERFS_DEFAULT_VOLUME_SIZE = "glusterfs.defaultvolumesize"
 	DEFAULT_VOLUME_SIZE           = "100G"
+
+	GLUSTERD_PORT         = "24007"
+	GLUSTERD_DIAL_TIMEOUT = 5 * time.Second
 )
 
 var (
@@ -187,7 +193,7 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 	}
 	// We would always mount the default volume pool
 	// TODO: Also need to mount any existing volume's pool
-	if _, err := util.VolumeMount(gVolume, "", true); err != nil {
+	if _, err := util.VolumeMount(gVolume, "", true, false, ""); err != nil {
 		return nil, err
 	}
 	d.gVolumes[d.DefaultVolumePool] = gVolume
@@ -207,6 +213,47 @@ func (d *Driver) Info() (map[string]string, error) {
 	}, nil
 }
 
+func (d *Driver) Capabilities() Capabilities {
+	return Capabilities{
+		CrossHostAttach: true,
+	}
+}
+
+/*
+CheckHealth would verify at least one of the GlusterFS servers is reachable,
+and the default volume pool is still mounted.
+*/
+func (d *Driver) CheckHealth() error {
+	reachable := false
+	for _, server := range d.Servers {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(server, GLUSTERD_PORT), GLUSTERD_DIAL_TIMEOUT)
+		if err != nil {
+			log.Debugf("GlusterFS server %v is unreachable: %v", server, err)
+			continue
+		}
+		conn.Close()
+		reachable = true
+		break
+	}
+	if !reachable {
+		return fmt.Errorf("None of GlusterFS servers %v is reachable", d.Servers)
+	}
+	// Mount point is stat'ed without the lock, since it may hang
+	d.mutex.RLock()
+	mountPoint := ""
+	if gVolume, exists := d.gVolumes[d.DefaultVolumePool]; exists {
+		mountPoint = gVolume.MountPoint
+	}
+	d.mutex.RUnlock()
+	if mountPoint == "" {
+		return fmt.Errorf("Default volume pool %v is not mounted", d.DefaultVolumePool)
+	}
+	if _, err := os.Stat(mountPoint); err != nil {
+		return fmt.Errorf("Default volume pool %v is inaccessible: %v", d.DefaultVolumePool, err)
+	}
+	return nil
+}
+
 func (d *Driver) VolumeOps() (VolumeOperations, error) {
 	return d, nil
 }
@@ -0,0 +1,441 @@
+// +build linux
+
+package hostdir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "hostdir"
+	DRIVER_CONFIG_FILE = "hostdir.cfg"
+
+	VOLUME_CFG_PREFIX  = "volume_"
+	HOSTDIR_CFG_PREFIX = DRIVER_NAME + "_"
+	CFG_POSTFIX        = ".json"
+
+	MOUNTS_DIR = "mounts"
+
+	HOSTDIR_PATHS = "hostdir.paths"
+
+	OPT_PATH = "Path"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "hostdir"})
+)
+
+/*
+Driver exposes directories already on the host, e.g. reference datasets baked
+into the image, as read-only volumes. Only directories whitelisted in Paths
+can be used, and volumes can't be created or deleted through convoy, but they
+are listed, mounted and audited like the ones of any other driver.
+*/
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+}
+
+type Device struct {
+	Root string
+	// Paths maps volume names to the whitelisted host directories
+	Paths map[string]string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+type Volume struct {
+	Name        string
+	Path        string
+	MountPoint  string
+	CreatedTime string
+
+	configPath string
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, HOSTDIR_CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	return v.Path, nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{"--bind"}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func (device *Device) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(device.Root, HOSTDIR_CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+// parsePaths parses whitelist in format of <name>:<path>[,<name>:<path>...]
+func parsePaths(spec string) (map[string]string, error) {
+	paths := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid %v entry %q, should be <name>:<path>", HOSTDIR_PATHS, entry)
+		}
+		name, path := parts[0], filepath.Clean(parts[1])
+		if !util.ValidateName(name) {
+			return nil, fmt.Errorf("Invalid volume name %v in %v", name, HOSTDIR_PATHS)
+		}
+		if _, exists := paths[name]; exists {
+			return nil, fmt.Errorf("Volume %v is specified more than once in %v", name, HOSTDIR_PATHS)
+		}
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("Path %v of volume %v must be absolute", path, name)
+		}
+		paths[name] = path
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%v is required, e.g. %v=refdata:/srv/refdata", HOSTDIR_PATHS, HOSTDIR_PATHS)
+	}
+	return paths, nil
+}
+
+func checkDirectory(path string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !st.IsDir() {
+		return fmt.Errorf("%v is not a directory", path)
+	}
+	return nil
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := util.MkdirIfNotExists(root); err != nil {
+			return nil, err
+		}
+	}
+	// Unlike other options, the whitelist would be replaced whenever it's
+	// specified, so access can be revoked without losing the volumes'
+	// state
+	if !exists || config[HOSTDIR_PATHS] != "" {
+		paths, err := parsePaths(config[HOSTDIR_PATHS])
+		if err != nil {
+			return nil, err
+		}
+		dev.Paths = paths
+	}
+	if err := util.ObjectSave(dev); err != nil {
+		return nil, err
+	}
+
+	d := &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+	if err := d.syncVolumes(); err != nil {
+		return nil, err
+	}
+	if err := d.remountVolumes(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+/*
+syncVolumes makes volumes match the whitelist. Volumes removed from the
+whitelist would be gone, unless they're still mounted, in which case they
+would be gone after umount. Missing directories are only warned about, they
+may be provisioned after daemon starts.
+*/
+func (d *Driver) syncVolumes() error {
+	for name, path := range d.Paths {
+		if err := checkDirectory(path); err != nil {
+			log.Warnf("Directory of volume %v is not available: %v", name, err)
+		}
+		volume := d.blankVolume(name)
+		exists, err := util.ObjectExists(volume)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if err := util.ObjectLoad(volume); err != nil {
+				return err
+			}
+			if volume.Path == path {
+				continue
+			}
+			if volume.MountPoint != "" {
+				log.Warnf("Path of volume %v changed from %v to %v, would take effect after it's umounted",
+					name, volume.Path, path)
+				continue
+			}
+		} else {
+			volume.CreatedTime = util.Now()
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON: LOG_REASON_START,
+			LOG_FIELD_EVENT:  LOG_EVENT_CREATE,
+			LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+			LOG_FIELD_VOLUME: name,
+		}).Debugf("Exposing %v as volume", path)
+		volume.Path = path
+		if err := util.ObjectSave(volume); err != nil {
+			return err
+		}
+	}
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		if _, whitelisted := d.Paths[id]; whitelisted {
+			continue
+		}
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint != "" {
+			log.Warnf("Volume %v is no longer whitelisted, but still mounted at %v. It would be removed after umount",
+				id, volume.MountPoint)
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON: LOG_REASON_START,
+			LOG_FIELD_EVENT:  LOG_EVENT_DELETE,
+			LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+			LOG_FIELD_VOLUME: id,
+		}).Debug("Removing volume no longer whitelisted")
+		if err := util.ObjectDelete(volume); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remountVolumes would bind mount volumes which were mounted before again,
+// e.g. after reboot
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		if _, err := util.VolumeMount(volume, volume.MountPoint, false, true, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	names := []string{}
+	for name := range d.Paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	paths := []string{}
+	for _, name := range names {
+		paths = append(paths, name+":"+d.Paths[name])
+	}
+	return map[string]string{
+		"Driver": d.Name(),
+		"Root":   d.Root,
+		"Paths":  strings.Join(paths, ","),
+	}, nil
+}
+
+func (d *Driver) Capabilities() Capabilities {
+	return Capabilities{
+		CustomMountPoint: true,
+		ReadOnlyMount:    true,
+	}
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	return fmt.Errorf("Volumes of hostdir cannot be created, they're configured by %v", HOSTDIR_PATHS)
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	return fmt.Errorf("Volume %v of hostdir cannot be deleted, remove it from %v instead", req.Name, HOSTDIR_PATHS)
+}
+
+// MountVolume always bind mounts the directory read-only, regardless of
+// OPT_READ_ONLY
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	if _, whitelisted := d.Paths[id]; !whitelisted {
+		return "", fmt.Errorf("Volume %v is no longer whitelisted by %v", id, HOSTDIR_PATHS)
+	}
+	if err := checkDirectory(volume.Path); err != nil {
+		return "", fmt.Errorf("Directory of volume %v is not available: %v", id, err)
+	}
+
+	mountPoint, err := util.VolumeMount(volume, opts[OPT_MOUNT_POINT], false, true, "")
+	if err != nil {
+		return "", err
+	}
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+	if _, whitelisted := d.Paths[req.Name]; !whitelisted {
+		log.Debugf("Removing volume %v no longer whitelisted after umount", req.Name)
+		return util.ObjectDelete(volume)
+	}
+	if path := d.Paths[req.Name]; path != volume.Path {
+		log.Debugf("Updating path of volume %v to %v after umount", req.Name, path)
+		volume.Path = path
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.getVolumeInfo(id)
+}
+
+func (d *Driver) getVolumeInfo(id string) (map[string]string, error) {
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		OPT_VOLUME_NAME:         volume.Name,
+		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
+		OPT_MOUNT_POINT:         volume.MountPoint,
+		OPT_PATH:                volume.Path,
+		OPT_READ_ONLY:           strconv.FormatBool(true),
+	}, nil
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]map[string]string)
+	for _, id := range volumeIDs {
+		volumes[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return nil, fmt.Errorf("Doesn't support snapshot operations")
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return nil, fmt.Errorf("Doesn't support backup operations")
+}
@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+
+	// LOG_FIELD_PKG is the field naming component of the entry, set by
+	// loggers of each package
+	LOG_FIELD_PKG = "pkg"
+)
+
+// Levels are the default log level, and the ones of components overriding
+// it, e.g. "info,ebs=debug,daemon=warn"
+type Levels struct {
+	Default    logrus.Level
+	Components map[string]logrus.Level
+}
+
+// ParseLevels parses levels in the form of "<level>,<pkg>=<level>,...",
+// where the default level can be left out to be info
+func ParseLevels(spec string) (*Levels, error) {
+	levels := &Levels{
+		Default:    logrus.InfoLevel,
+		Components: make(map[string]logrus.Level),
+	}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		level, err := logrus.ParseLevel(kv[len(kv)-1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid log level %q, should be one of debug, info, warning, error, fatal or panic", kv[len(kv)-1])
+		}
+		if len(kv) == 1 {
+			levels.Default = level
+			continue
+		}
+		if kv[0] == "" {
+			return nil, fmt.Errorf("Invalid log level %q with empty component", part)
+		}
+		levels.Components[kv[0]] = level
+	}
+	return levels, nil
+}
+
+func (l *Levels) String() string {
+	parts := []string{}
+	for pkg, level := range l.Components {
+		parts = append(parts, pkg+"="+level.String())
+	}
+	sort.Strings(parts)
+	return strings.Join(append([]string{l.Default.String()}, parts...), ",")
+}
+
+// Level returns log level of the component
+func (l *Levels) Level(pkg string) logrus.Level {
+	if level, exists := l.Components[pkg]; exists {
+		return level
+	}
+	return l.Default
+}
+
+// Max returns the most verbose level of all, which logrus should log at
+func (l *Levels) Max() logrus.Level {
+	max := l.Default
+	for _, level := range l.Components {
+		if level > max {
+			max = level
+		}
+	}
+	return max
+}
+
+/*
+LevelFormatter formats entries by Formatter, and drops the ones more verbose
+than level of their component, by field LOG_FIELD_PKG, so components can log
+at different levels. Levels can be changed at any time by SetLevels().
+*/
+type LevelFormatter struct {
+	Formatter logrus.Formatter
+
+	mutex  sync.RWMutex
+	levels *Levels
+}
+
+// NewLevelFormatter returns formatter of format, LOG_FORMAT_TEXT or
+// LOG_FORMAT_JSON
+func NewLevelFormatter(format string, levels *Levels) (*LevelFormatter, error) {
+	f := &LevelFormatter{}
+	switch format {
+	case LOG_FORMAT_TEXT:
+		f.Formatter = &logrus.TextFormatter{}
+	case LOG_FORMAT_JSON:
+		f.Formatter = &logrus.JSONFormatter{}
+	default:
+		return nil, fmt.Errorf("Invalid log format %v, should be %v or %v", format, LOG_FORMAT_TEXT, LOG_FORMAT_JSON)
+	}
+	f.SetLevels(levels)
+	return f, nil
+}
+
+// SetLevels changes levels of components, along with level of logrus
+func (f *LevelFormatter) SetLevels(levels *Levels) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.levels = levels
+	logrus.SetLevel(levels.Max())
+}
+
+func (f *LevelFormatter) Levels() *Levels {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	return f.levels
+}
+
+func (f *LevelFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	pkg, _ := entry.Data[LOG_FIELD_PKG].(string)
+	if entry.Level > f.Levels().Level(pkg) {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
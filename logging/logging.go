@@ -29,28 +29,49 @@ const (
 	LOG_FIELD_CONTEXT       = "context"
 	LOG_FIELD_OPTS          = "opts"
 
-	LOG_FIELD_EVENT      = "event"
-	LOG_EVENT_INIT       = "init"
-	LOG_EVENT_CREATE     = "create"
-	LOG_EVENT_DELETE     = "delete"
-	LOG_EVENT_FORMAT     = "format"
-	LOG_EVENT_LIST       = "list"
-	LOG_EVENT_MOUNT      = "mount"
-	LOG_EVENT_UMOUNT     = "umount"
-	LOG_EVENT_MOUNTPOINT = "mountpoint"
-	LOG_EVENT_ACTIVATE   = "activate"
-	LOG_EVENT_DEACTIVATE = "deactivate"
-	LOG_EVENT_REGISTER   = "register"
-	LOG_EVENT_DEREGISTER = "deregister"
-	LOG_EVENT_ADD        = "add"
-	LOG_EVENT_REMOVE     = "remove"
-	LOG_EVENT_BACKUP     = "backup"
-	LOG_EVENT_RESTORE    = "restore"
-	LOG_EVENT_LOAD       = "load"
-	LOG_EVENT_SAVE       = "save"
-	LOG_EVENT_COMPARE    = "compare"
-	LOG_EVENT_UPLOAD     = "upload"
-	LOG_EVENT_DOWNLOAD   = "download"
+	LOG_FIELD_EVENT       = "event"
+	LOG_EVENT_INIT        = "init"
+	LOG_EVENT_CREATE      = "create"
+	LOG_EVENT_DELETE      = "delete"
+	LOG_EVENT_FORMAT      = "format"
+	LOG_EVENT_LIST        = "list"
+	LOG_EVENT_MOUNT       = "mount"
+	LOG_EVENT_UMOUNT      = "umount"
+	LOG_EVENT_MOUNTPOINT  = "mountpoint"
+	LOG_EVENT_ACTIVATE    = "activate"
+	LOG_EVENT_DEACTIVATE  = "deactivate"
+	LOG_EVENT_REGISTER    = "register"
+	LOG_EVENT_DEREGISTER  = "deregister"
+	LOG_EVENT_ADD         = "add"
+	LOG_EVENT_REMOVE      = "remove"
+	LOG_EVENT_BACKUP      = "backup"
+	LOG_EVENT_RESTORE     = "restore"
+	LOG_EVENT_LOAD        = "load"
+	LOG_EVENT_SAVE        = "save"
+	LOG_EVENT_COMPARE     = "compare"
+	LOG_EVENT_UPLOAD      = "upload"
+	LOG_EVENT_DOWNLOAD    = "download"
+	LOG_EVENT_MONITOR     = "monitor"
+	LOG_EVENT_EXTEND      = "extend"
+	LOG_EVENT_ATTACH      = "attach"
+	LOG_EVENT_HEALTH      = "health"
+	LOG_EVENT_MISSED      = "missed"
+	LOG_EVENT_SLO         = "slo"
+	LOG_EVENT_HOOK        = "hook"
+	LOG_EVENT_VERIFY      = "verify"
+	LOG_EVENT_INVENTORY   = "inventory"
+	LOG_EVENT_REPLICATE   = "replicate"
+	LOG_EVENT_EXPORT      = "export"
+	LOG_EVENT_IMPORT      = "import"
+	LOG_EVENT_MIGRATE     = "migrate"
+	LOG_EVENT_AUTH        = "auth"
+	LOG_EVENT_ALERT       = "alert"
+	LOG_EVENT_LEASE       = "lease"
+	LOG_EVENT_MAINTENANCE = "maintenance"
+	LOG_EVENT_RECONCILE   = "reconcile"
+	LOG_EVENT_DRAIN       = "drain"
+	LOG_EVENT_CLONE       = "clone"
+	LOG_EVENT_STATE       = "state"
 
 	LOG_FIELD_REASON    = "reason"
 	LOG_REASON_PREPARE  = "prepare"
@@ -59,14 +80,18 @@ const (
 	LOG_REASON_FAILURE  = "failure"
 	LOG_REASON_ROLLBACK = "rollback"
 	LOG_REASON_FALLBACK = "fallback"
+	LOG_REASON_DEGRADED = "degraded"
+	LOG_REASON_RECOVERY = "recovery"
 
 	LOG_FIELD_OBJECT      = "object"
 	LOG_OBJECT_DRIVER     = "driver"
+	LOG_OBJECT_DAEMON     = "daemon"
 	LOG_OBJECT_VOLUME     = "volume"
 	LOG_OBJECT_SNAPSHOT   = "snapshot"
 	LOG_OBJECT_BACKUP_URL = "backup_url"
 	LOG_OBJECT_DEST_URL   = "dest_url"
 	LOG_OBJECT_CONFIG     = "config"
+	LOG_OBJECT_SCHEDULE   = "schedule"
 )
 
 // Error is a wrapper for a go error contains more details
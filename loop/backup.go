@@ -0,0 +1,297 @@
+// +build linux
+
+package loop
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/cbt"
+	"github.com/rancher/convoy/convoydriver"
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	// lseek(2) whence for sparse files, not defined in syscall package
+	SEEK_DATA = 3
+	SEEK_HOLE = 4
+)
+
+func (d *Driver) BackupOps() (convoydriver.BackupOperations, error) {
+	return d, nil
+}
+
+/*
+getDataBlocks returns offsets of the blocks in file containing data, in
+ascending order. Holes of sparse file would be skipped. The whole file would
+be reported if filesystem doesn't support SEEK_DATA.
+*/
+func getDataBlocks(file string, blockSize int64) ([]int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := st.Size()
+
+	blocks := []int64{}
+	fd := int(f.Fd())
+	for offset := int64(0); offset < size; {
+		start, err := syscall.Seek(fd, offset, SEEK_DATA)
+		if err == syscall.ENXIO {
+			// No more data
+			break
+		}
+		if err == syscall.EINVAL {
+			start, err = offset, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := syscall.Seek(fd, start, SEEK_HOLE)
+		if err == syscall.EINVAL {
+			end, err = size, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for block := start - start%blockSize; block < end; block += blockSize {
+			if len(blocks) == 0 || blocks[len(blocks)-1] < block {
+				blocks = append(blocks, block)
+			}
+		}
+		offset = end
+	}
+	return blocks, nil
+}
+
+func mergeBlocks(a, b []int64) []int64 {
+	result := []int64{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		var next int64
+		switch {
+		case j >= len(b) || (i < len(a) && a[i] < b[j]):
+			next = a[i]
+			i++
+		case i >= len(a) || b[j] < a[i]:
+			next = b[j]
+			j++
+		default:
+			next = a[i]
+			i++
+			j++
+		}
+		result = append(result, next)
+	}
+	return result
+}
+
+func readBlock(f *os.File, offset int64, data []byte) error {
+	n, err := f.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	// Beyond end of file reads as zero
+	for i := n; i < len(data); i++ {
+		data[i] = 0
+	}
+	return nil
+}
+
+/*
+getChangedBlocks returns mappings of blocks which differ between file and
+compareFile. Blocks tracked as unchanged, by extents shared between
+snapshots, won't be read. Otherwise only the blocks with data in either file
+would be compared. If compareFile is empty, all the blocks with data in file
+would be returned.
+*/
+func getChangedBlocks(file, compareFile string, blockSize int64) (*metadata.Mappings, error) {
+	if compareFile != "" {
+		bitmap, err := cbt.ChangedBlocks(file, compareFile, blockSize)
+		if err == nil {
+			log.Debugf("Found %v blocks of %v changed since %v by shared extents", bitmap.Count(), file, compareFile)
+			return bitmap.Mappings(), nil
+		}
+		if err != cbt.ErrNotTracked {
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON:   LOG_REASON_FALLBACK,
+				LOG_FIELD_FILEPATH: file,
+			}).Warnf("Failed to track changed blocks since %v, would compare them: %v", compareFile, err)
+		}
+	}
+
+	blocks, err := getDataBlocks(file, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	mappings := &metadata.Mappings{
+		Mappings:  []metadata.Mapping{},
+		BlockSize: blockSize,
+	}
+
+	var f, cf *os.File
+	if compareFile != "" {
+		compareBlocks, err := getDataBlocks(compareFile, blockSize)
+		if err != nil {
+			return nil, err
+		}
+		blocks = mergeBlocks(blocks, compareBlocks)
+
+		if f, err = os.Open(file); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if cf, err = os.Open(compareFile); err != nil {
+			return nil, err
+		}
+		defer cf.Close()
+	}
+
+	data := make([]byte, blockSize)
+	compareData := make([]byte, blockSize)
+	for _, offset := range blocks {
+		if compareFile != "" {
+			if err := readBlock(f, offset, data); err != nil {
+				return nil, err
+			}
+			if err := readBlock(cf, offset, compareData); err != nil {
+				return nil, err
+			}
+			if bytes.Equal(data, compareData) {
+				continue
+			}
+		}
+		last := len(mappings.Mappings) - 1
+		if last >= 0 && mappings.Mappings[last].Offset+mappings.Mappings[last].Size == offset {
+			mappings.Mappings[last].Size += blockSize
+			continue
+		}
+		mappings.Mappings = append(mappings.Mappings, metadata.Mapping{
+			Offset: offset,
+			Size:   blockSize,
+		})
+	}
+	return mappings, nil
+}
+
+func (d *Driver) HasSnapshot(id, volumeID string) bool {
+	_, _, err := d.getSnapshotAndVolume(id, volumeID)
+	return err == nil
+}
+
+func (d *Driver) CompareSnapshot(id, compareID, volumeID string) (*metadata.Mappings, error) {
+	snapshot, _, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	compareFile := ""
+	if compareID != "" && compareID != id {
+		compareSnapshot, _, err := d.getSnapshotAndVolume(compareID, volumeID)
+		if err != nil {
+			return nil, err
+		}
+		compareFile = compareSnapshot.File
+	}
+	return getChangedBlocks(snapshot.File, compareFile, objectstore.DEFAULT_BLOCK_SIZE)
+}
+
+// Snapshots are regular files, nothing to be activated
+func (d *Driver) OpenSnapshot(id, volumeID string) error {
+	_, _, err := d.getSnapshotAndVolume(id, volumeID)
+	return err
+}
+
+func (d *Driver) CloseSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (d *Driver) ReadSnapshot(id, volumeID string, offset int64, data []byte) error {
+	snapshot, _, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(snapshot.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.ReadAt(data, offset)
+	return err
+}
+
+func (d *Driver) CreateBackup(snapshotID, volumeID, destURL string, opts map[string]string) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	objVolume := &objectstore.Volume{
+		Name:        volumeID,
+		Driver:      d.Name(),
+		Size:        volume.Size,
+		CreatedTime: opts[convoydriver.OPT_VOLUME_CREATED_TIME],
+	}
+	labels, err := util.DecodeLabels(opts[convoydriver.OPT_BACKUP_LABELS])
+	if err != nil {
+		return "", err
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name:        snapshotID,
+		CreatedTime: opts[convoydriver.OPT_SNAPSHOT_CREATED_TIME],
+		Labels:      labels,
+	}
+	return objectstore.CreateDeltaBlockBackup(objVolume, objSnapshot, destURL, volume.BackupBlockSize, d)
+}
+
+func (d *Driver) DeleteBackup(backupURL string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	objVolume, err := objectstore.LoadVolume(backupURL)
+	if err != nil {
+		return err
+	}
+	if objVolume.Driver != d.Name() {
+		return fmt.Errorf("BUG: Wrong driver handling DeleteBackup(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	return objectstore.DeleteDeltaBlockBackup(backupURL)
+}
+
+func (d *Driver) GetBackupInfo(backupURL string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	objVolume, err := objectstore.LoadVolume(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	if objVolume.Driver != d.Name() {
+		return nil, fmt.Errorf("BUG: Wrong driver handling GetBackupInfo(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	return objectstore.GetBackupInfo(backupURL)
+}
+
+func (d *Driver) ListBackup(destURL string, opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return objectstore.List(opts[convoydriver.OPT_VOLUME_NAME], destURL, d.Name())
+}
@@ -0,0 +1,108 @@
+// +build linux
+
+package loop
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/convoy/metadata"
+
+	. "gopkg.in/check.v1"
+)
+
+const (
+	testBlockSize = 4096
+	testFileSize  = 16 * testBlockSize
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	dir string
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *TestSuite) createFile(c *C, name string, blocks map[int64]byte) string {
+	file := filepath.Join(s.dir, name)
+	f, err := os.Create(file)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	c.Assert(f.Truncate(testFileSize), IsNil)
+	for block, value := range blocks {
+		data := make([]byte, testBlockSize)
+		for i := range data {
+			data[i] = value
+		}
+		_, err := f.WriteAt(data, block*testBlockSize)
+		c.Assert(err, IsNil)
+	}
+	return file
+}
+
+func (s *TestSuite) TestGetChangedBlocks(c *C) {
+	file := s.createFile(c, "snap1", map[int64]byte{
+		1: 'a',
+		2: 'b',
+		7: 'c',
+	})
+	mappings, err := getChangedBlocks(file, "", testBlockSize)
+	c.Assert(err, IsNil)
+	c.Assert(mappings.BlockSize, Equals, int64(testBlockSize))
+	// Holes may not be reported if filesystem doesn't support SEEK_DATA
+	found := map[int64]bool{}
+	for _, m := range mappings.Mappings {
+		c.Assert(m.Size%testBlockSize, Equals, int64(0))
+		for offset := m.Offset; offset < m.Offset+m.Size; offset += testBlockSize {
+			found[offset/testBlockSize] = true
+		}
+	}
+	c.Assert(found[1], Equals, true)
+	c.Assert(found[2], Equals, true)
+	c.Assert(found[7], Equals, true)
+
+	compareFile := s.createFile(c, "snap2", map[int64]byte{
+		1: 'a',
+		2: 'x',
+		5: 'd',
+		7: 'c',
+	})
+	mappings, err = getChangedBlocks(compareFile, file, testBlockSize)
+	c.Assert(err, IsNil)
+	c.Assert(mappings.Mappings, DeepEquals, []metadata.Mapping{
+		{Offset: 2 * testBlockSize, Size: testBlockSize},
+		{Offset: 5 * testBlockSize, Size: testBlockSize},
+	})
+
+	// Block discarded in the newer file would be reported too
+	mappings, err = getChangedBlocks(file, compareFile, testBlockSize)
+	c.Assert(err, IsNil)
+	c.Assert(mappings.Mappings, DeepEquals, []metadata.Mapping{
+		{Offset: 2 * testBlockSize, Size: testBlockSize},
+		{Offset: 5 * testBlockSize, Size: testBlockSize},
+	})
+}
+
+func (s *TestSuite) TestMergeBlocks(c *C) {
+	c.Assert(mergeBlocks([]int64{0, 2, 4}, []int64{1, 2, 5}), DeepEquals, []int64{0, 1, 2, 4, 5})
+	c.Assert(mergeBlocks([]int64{}, []int64{3}), DeepEquals, []int64{3})
+	c.Assert(mergeBlocks([]int64{3}, []int64{}), DeepEquals, []int64{3})
+}
+
+func (s *TestSuite) TestGetAllocatedSize(c *C) {
+	file := s.createFile(c, "sparse", map[int64]byte{1: 1, 7: 2})
+	allocated, err := getAllocatedSize(file)
+	c.Assert(err, IsNil)
+	c.Assert(allocated >= 2*testBlockSize, Equals, true)
+	c.Assert(allocated < testFileSize, Equals, true)
+
+	_, err = getAllocatedSize(filepath.Join(s.dir, "missing"))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,960 @@
+// +build linux
+
+package loop
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "loop"
+	DRIVER_CONFIG_FILE = "loop.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	LOOP_CFG_PREFIX   = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	IMAGES_DIR    = "images"
+	SNAPSHOTS_DIR = "snapshots"
+	MOUNTS_DIR    = "mounts"
+	IMAGE_POSTFIX = ".img"
+
+	LOOP_PATH                = "loop.path"
+	LOOP_DEFAULT_VOLUME_SIZE = "loop.defaultvolumesize"
+	LOOP_DEFAULT_FS_TYPE     = "loop.fs"
+	LOOP_MKFS_OPTIONS        = "loop.mkfsoptions"
+	LOOP_MOUNT_OPTIONS       = "loop.mountoptions"
+
+	DEFAULT_VOLUME_SIZE = "10G"
+	DEFAULT_FS_TYPE     = "ext4"
+
+	LOSETUP_BINARY = "losetup"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "loop"})
+)
+
+/*
+Driver backs each volume with a sparse image file, attached through a loop
+device when mounted. It's meant for development and testing of the block
+device code path, where devicemapper or cloud volumes are not available.
+*/
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+}
+
+type Device struct {
+	Root              string
+	Path              string
+	DefaultVolumeSize int64
+	Filesystem        string
+	MkfsOptions       string
+	MountOptions      string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+type Snapshot struct {
+	Name        string
+	CreatedTime string
+	File        string
+}
+
+type Volume struct {
+	Name         string
+	Size         int64
+	File         string
+	Device       string
+	MountPoint   string
+	Filesystem   string
+	MkfsOptions  string
+	MountOptions string
+	// EncryptionKey is ID of the key, see util.InitEncryptionKeySources()
+	EncryptionKey string
+	// BackupBlockSize overrides the default block size of backups, if not 0
+	BackupBlockSize int64
+	CreatedTime     string
+	Snapshots       map[string]Snapshot
+
+	configPath string
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, LOOP_CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	if v.Device == "" {
+		return "", fmt.Errorf("Volume %v is not attached to a loop device", v.Name)
+	}
+	return v.Device, nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return util.GetFilesystemMountOpts(v.Filesystem, v.MountOptions)
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func (device *Device) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(device.Root, LOOP_CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func checkEnvironment() error {
+	if _, err := util.Execute(LOSETUP_BINARY, []string{"--version"}); err != nil {
+		return fmt.Errorf("Cannot find %v, which is required by loop driver: %v", LOSETUP_BINARY, err)
+	}
+	return nil
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	if err := checkEnvironment(); err != nil {
+		return nil, err
+	}
+
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := util.MkdirIfNotExists(root); err != nil {
+			return nil, err
+		}
+
+		path := config[LOOP_PATH]
+		if path == "" {
+			path = root
+		}
+
+		if _, exists := config[LOOP_DEFAULT_VOLUME_SIZE]; !exists {
+			config[LOOP_DEFAULT_VOLUME_SIZE] = DEFAULT_VOLUME_SIZE
+		}
+		volumeSize, err := util.ParseSize(config[LOOP_DEFAULT_VOLUME_SIZE])
+		if err != nil || volumeSize == 0 {
+			return nil, fmt.Errorf("Illegal default volume size specified")
+		}
+
+		if _, exists := config[LOOP_DEFAULT_FS_TYPE]; !exists {
+			config[LOOP_DEFAULT_FS_TYPE] = DEFAULT_FS_TYPE
+		}
+		if err := util.CheckFilesystem(config[LOOP_DEFAULT_FS_TYPE]); err != nil {
+			return nil, err
+		}
+		if err := util.CheckMountOptions(config[LOOP_MOUNT_OPTIONS]); err != nil {
+			return nil, err
+		}
+
+		dev = &Device{
+			Root:              root,
+			Path:              path,
+			DefaultVolumeSize: volumeSize,
+			Filesystem:        config[LOOP_DEFAULT_FS_TYPE],
+			MkfsOptions:       config[LOOP_MKFS_OPTIONS],
+			MountOptions:      config[LOOP_MOUNT_OPTIONS],
+		}
+	}
+
+	if err := util.MkdirIfNotExists(filepath.Join(dev.Path, IMAGES_DIR)); err != nil {
+		return nil, err
+	}
+	if err := util.MkdirIfNotExists(filepath.Join(dev.Path, SNAPSHOTS_DIR)); err != nil {
+		return nil, err
+	}
+	if err := util.ObjectSave(dev); err != nil {
+		return nil, err
+	}
+
+	d := &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+	return d, nil
+}
+
+/*
+Reconcile attaches and mounts volumes which were mounted before, since loop
+devices don't survive reboot, and detaches loop devices left attached to
+images of volumes not mounted.
+*/
+func (d *Driver) Reconcile() ([]Reconciliation, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := util.ListMounts()
+	if err != nil {
+		return nil, err
+	}
+	results := []Reconciliation{}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			results = append(results, Reconciliation{Volume: id, Err: err})
+			continue
+		}
+		actions, err := d.reconcileVolume(volume, mounts)
+		for _, action := range actions {
+			results = append(results, Reconciliation{Volume: id, Action: action})
+		}
+		if err != nil {
+			results = append(results, Reconciliation{Volume: id, Err: err})
+		}
+		if len(actions) != 0 {
+			if err := util.ObjectSave(volume); err != nil {
+				results = append(results, Reconciliation{Volume: id, Err: err})
+			}
+		}
+	}
+	return results, nil
+}
+
+func (d *Driver) reconcileVolume(volume *Volume, mounts string) ([]string, error) {
+	actions := []string{}
+	if volume.MountPoint != "" {
+		dev := volume.Device
+		if err := d.attachVolume(volume); err != nil {
+			return actions, err
+		}
+		if volume.Device != dev {
+			actions = append(actions, "attached image to "+volume.Device)
+		}
+	}
+	action, err := util.ReconcileVolumeMount(volume, mounts)
+	if action != "" {
+		actions = append(actions, action)
+	}
+	if err != nil || volume.MountPoint != "" {
+		return actions, err
+	}
+	dev, err := findLoopDevice(volume.File)
+	if err != nil || dev == "" {
+		return actions, err
+	}
+	if err := detachLoopDevice(dev); err != nil {
+		return actions, err
+	}
+	volume.Device = ""
+	return append(actions, "detached stale loop device "+dev), nil
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"Driver":            d.Name(),
+		"Root":              d.Root,
+		"Path":              d.Path,
+		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"Filesystem":        d.Filesystem,
+		"MkfsOptions":       d.MkfsOptions,
+		"MountOptions":      d.MountOptions,
+	}, nil
+}
+
+func (d *Driver) Capabilities() Capabilities {
+	return Capabilities{
+		Snapshot:         true,
+		Backup:           true,
+		CustomMountPoint: true,
+		ReadOnlyMount:    true,
+		Encryption:       true,
+		SELinuxLabel:     true,
+	}
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSize(opts map[string]string, defaultVolumeSize int64) (int64, error) {
+	size := opts[OPT_SIZE]
+	if size == "" || size == "0" {
+		size = strconv.FormatInt(defaultVolumeSize, 10)
+	}
+	return util.ParseSize(size)
+}
+
+func (d *Driver) getImageFilePath(volumeID string) string {
+	return filepath.Join(d.Path, IMAGES_DIR, volumeID+IMAGE_POSTFIX)
+}
+
+func (d *Driver) getSnapshotFilePath(snapshotID, volumeID string) string {
+	return filepath.Join(d.Path, SNAPSHOTS_DIR, volumeID+"_"+snapshotID+IMAGE_POSTFIX)
+}
+
+// findLoopDevice returns the loop device currently backed by file, or empty
+// string if there is none
+func findLoopDevice(file string) (string, error) {
+	out, err := util.Execute(LOSETUP_BINARY, []string{"-j", file})
+	if err != nil {
+		return "", err
+	}
+	// Output would be like "/dev/loop0: [2049]:1234 (/path/to/file)"
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == "" {
+			continue
+		}
+		return strings.SplitN(line, ":", 2)[0], nil
+	}
+	return "", nil
+}
+
+func attachLoopDevice(file string) (string, error) {
+	dev, err := findLoopDevice(file)
+	if err != nil {
+		return "", err
+	}
+	if dev != "" {
+		return dev, nil
+	}
+	out, err := util.Execute(LOSETUP_BINARY, []string{"--find", "--show", file})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// refreshLoopDevice makes loop device pick up the current size of its backing
+// file, e.g. after the file is extended
+func refreshLoopDevice(dev string) error {
+	_, err := util.Execute(LOSETUP_BINARY, []string{"-c", dev})
+	return err
+}
+
+func detachLoopDevice(dev string) error {
+	_, err := util.Execute(LOSETUP_BINARY, []string{"-d", dev})
+	return err
+}
+
+func (d *Driver) attachVolume(volume *Volume) error {
+	start := time.Now()
+	dev, err := attachLoopDevice(volume.File)
+	if err != nil {
+		return err
+	}
+	util.ObserveLatency(util.LATENCY_ATTACH, volume.Name, start)
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:      LOG_EVENT_ATTACH,
+		LOG_FIELD_OBJECT:     LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME:     volume.Name,
+		LOG_FIELD_VOLUME_DEV: dev,
+	}).Debugf("Attached volume image %v", volume.File)
+	volume.Device = dev
+	return nil
+}
+
+func (d *Driver) detachVolume(volume *Volume) error {
+	if volume.Device == "" {
+		return nil
+	}
+	// Device may have been released already, e.g. after reboot
+	dev, err := findLoopDevice(volume.File)
+	if err != nil {
+		return err
+	}
+	if dev != "" {
+		if err := detachLoopDevice(dev); err != nil {
+			return err
+		}
+	}
+	volume.Device = ""
+	return nil
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var (
+		size int64
+		err  error
+	)
+	id := req.Name
+	opts := req.Options
+
+	backupURL := opts[OPT_BACKUP_URL]
+	if backupURL != "" {
+		objVolume, err := objectstore.LoadVolume(backupURL)
+		if err != nil {
+			return err
+		}
+		if objVolume.Driver != d.Name() {
+			return fmt.Errorf("Cannot restore backup of %v to %v", objVolume.Driver, d.Name())
+		}
+		size, err = d.getSize(opts, objVolume.Size)
+		if err != nil {
+			return err
+		}
+		if size != objVolume.Size {
+			return fmt.Errorf("Volume size must match with backup's size")
+		}
+	} else {
+		size, err = d.getSize(opts, d.DefaultVolumeSize)
+		if err != nil {
+			return err
+		}
+	}
+	// Backups are taken in blocks of objectstore
+	if size%objectstore.DEFAULT_BLOCK_SIZE != 0 {
+		return fmt.Errorf("Size must be multiple of %v", objectstore.DEFAULT_BLOCK_SIZE)
+	}
+	fsType := ""
+	if backupURL == "" {
+		fsType = opts[OPT_FILESYSTEM]
+		if fsType == "" {
+			fsType = d.Filesystem
+		}
+		if err := util.CheckFilesystem(fsType); err != nil {
+			return err
+		}
+	} else if opts[OPT_FILESYSTEM] != "" {
+		return fmt.Errorf("Cannot specify filesystem for volume restored from backup")
+	}
+	mkfsOpts := ""
+	if backupURL == "" {
+		mkfsOpts = opts[OPT_MKFS_OPTIONS]
+		if mkfsOpts == "" {
+			mkfsOpts = d.MkfsOptions
+		}
+	} else if opts[OPT_MKFS_OPTIONS] != "" {
+		return fmt.Errorf("Cannot specify mkfs options for volume restored from backup")
+	}
+	mountOpts := opts[OPT_MOUNT_OPTIONS]
+	if mountOpts == "" {
+		mountOpts = d.MountOptions
+	}
+	if err := util.CheckMountOptions(mountOpts); err != nil {
+		return err
+	}
+	encryptionKey := opts[OPT_ENCRYPTION_KEY]
+	if encryptionKey != "" {
+		if err := util.CheckEncryptionKey(encryptionKey); err != nil {
+			return err
+		}
+	}
+	backupBlockSize, err := objectstore.ParseBlockSize(opts[OPT_BACKUP_BLOCK_SIZE])
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Volume %v already exists", id)
+	}
+
+	file := d.getImageFilePath(id)
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("Image file %v already exists", file)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME:   id,
+		LOG_FIELD_SIZE:     size,
+		LOG_FIELD_FILEPATH: file,
+	}).Debug("Creating volume image")
+	if backupURL != "" {
+		_, span := util.StartSpan(req.Context, "objectstore.RestoreDeltaBlockBackup")
+		err := objectstore.RestoreDeltaBlockBackup(backupURL, file)
+		span.End(err)
+		if err != nil {
+			os.Remove(file)
+			return err
+		}
+		// Filesystem of the backup is unknown until restored. For
+		// encrypted volume, it would be detected by mount once opened
+		if encryptionKey == "" {
+			if fsType, err = util.GetFilesystemType(file); err != nil {
+				log.Warnf("Cannot detect filesystem of volume %v restored from backup: %v", id, err)
+			}
+		}
+	} else {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		err = f.Truncate(size)
+		f.Close()
+		if err != nil {
+			os.Remove(file)
+			return err
+		}
+	}
+
+	volume.Name = id
+	volume.Size = size
+	volume.File = file
+	volume.Filesystem = fsType
+	volume.MkfsOptions = mkfsOpts
+	volume.MountOptions = mountOpts
+	volume.EncryptionKey = encryptionKey
+	volume.BackupBlockSize = backupBlockSize
+	volume.CreatedTime = util.Now()
+	volume.Snapshots = make(map[string]Snapshot)
+
+	if backupURL == "" {
+		if err := d.attachVolume(volume); err != nil {
+			os.Remove(file)
+			return err
+		}
+		_, span := util.StartSpan(req.Context, "mkfs")
+		span.SetAttribute("fs.type", volume.Filesystem)
+		err := util.FormatVolume(volume, volume.Filesystem, volume.MkfsOptions)
+		span.End(err)
+		if detachErr := d.detachVolume(volume); detachErr != nil && err == nil {
+			err = detachErr
+		}
+		if err != nil {
+			os.Remove(file)
+			return err
+		}
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete volume %v, it hasn't been umounted", id)
+	}
+	for snapshotID := range volume.Snapshots {
+		if err := d.deleteSnapshot(snapshotID, id); err != nil {
+			return err
+		}
+	}
+	// deleteSnapshot() has updated the config
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if err := d.detachVolume(volume); err != nil {
+		return err
+	}
+
+	referenceOnly, _ := strconv.ParseBool(opts[OPT_REFERENCE_ONLY])
+	if !referenceOnly {
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON:   LOG_REASON_START,
+			LOG_FIELD_EVENT:    LOG_EVENT_REMOVE,
+			LOG_FIELD_OBJECT:   LOG_OBJECT_VOLUME,
+			LOG_FIELD_VOLUME:   id,
+			LOG_FIELD_FILEPATH: volume.File,
+		}).Debug("Removing volume image")
+		if err := os.Remove(volume.File); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+	readOnly, _ := strconv.ParseBool(opts[OPT_READ_ONLY])
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	if err := d.attachVolume(volume); err != nil {
+		return "", err
+	}
+	mountPoint, err := util.VolumeMount(volume, opts[OPT_MOUNT_POINT], false, readOnly, opts[OPT_SELINUX_CONTEXT])
+	if err != nil {
+		if volume.MountPoint == "" {
+			d.detachVolume(volume)
+		}
+		return "", err
+	}
+
+	if _, err := d.growFilesystem(volume); err != nil {
+		log.Warnf("Failed to grow filesystem of volume %v: %v", id, err)
+	}
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+// growFilesystem grows filesystem of the mounted volume if its image file has
+// been extended. Caller should save the volume.
+func (d *Driver) growFilesystem(volume *Volume) (bool, error) {
+	dev, err := volume.GetDevice()
+	if err != nil {
+		return false, err
+	}
+	if err := refreshLoopDevice(dev); err != nil {
+		return false, err
+	}
+	size, err := util.GrowExpandedVolume(volume, volume.Filesystem, volume.Size)
+	if err != nil || size == volume.Size {
+		return false, err
+	}
+	volume.Size = size
+	return true, nil
+}
+
+// GrowFilesystems grows filesystems of mounted volumes whose devices have been
+// expanded, returns their new sizes
+func (d *Driver) GrowFilesystems() (map[string]int64, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	return util.GrowMountedFilesystems(volumeIDs, func(id string) (int64, error) {
+		volume := d.blankVolume(id)
+		grown, err := util.GrowMountedVolume(volume, func() (bool, error) {
+			return d.growFilesystem(volume)
+		})
+		if err != nil || !grown {
+			return 0, err
+		}
+		return volume.Size, nil
+	}), nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+	if err := d.detachVolume(volume); err != nil {
+		return err
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.getVolumeInfo(id)
+}
+
+func (d *Driver) getVolumeInfo(id string) (map[string]string, error) {
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"Device":                volume.Device,
+		"File":                  volume.File,
+		OPT_VOLUME_NAME:         volume.Name,
+		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
+		OPT_MOUNT_POINT:         volume.MountPoint,
+		OPT_SIZE:                strconv.FormatInt(volume.Size, 10),
+		OPT_FILESYSTEM:          volume.Filesystem,
+		OPT_MKFS_OPTIONS:        volume.MkfsOptions,
+		OPT_MOUNT_OPTIONS:       volume.MountOptions,
+		OPT_ENCRYPTION_KEY:      volume.EncryptionKey,
+		OPT_BACKUP_BLOCK_SIZE:   objectstore.FormatBlockSize(volume.BackupBlockSize),
+	}, nil
+}
+
+// VolumeUsage reports the space taken by the sparse image file of volume
+func (d *Driver) VolumeUsage(name string) (int64, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return 0, err
+	}
+	return util.DiskUsage(volume.File)
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]map[string]string)
+	for _, id := range volumeIDs {
+		volumes[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*Snapshot, *Volume, error) {
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, nil, err
+	}
+	snapshot, exists := volume.Snapshots[snapshotID]
+	if !exists {
+		return nil, nil, fmt.Errorf("Snapshot %v doesn't exists for volume %v", snapshotID, volumeID)
+	}
+	return &snapshot, volume, nil
+}
+
+/*
+CreateSnapshot would copy the image file of volume. The filesystem would be
+frozen during the copy if volume is mounted, so the snapshot is consistent.
+*/
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if _, exists := volume.Snapshots[id]; exists {
+		return fmt.Errorf("Snapshot %v already exists for volume %v", id, volumeID)
+	}
+
+	file := d.getSnapshotFilePath(id, volumeID)
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: id,
+		LOG_FIELD_VOLUME:   volumeID,
+		LOG_FIELD_FILEPATH: file,
+	}).Debug("Creating snapshot")
+	if volume.MountPoint != "" {
+		thaw, err := util.FreezeFilesystem(volume.MountPoint)
+		if err != nil {
+			return err
+		}
+		defer thaw()
+	}
+	if _, err := util.Execute("cp", []string{"--sparse=always", "--reflink=auto", volume.File, file}); err != nil {
+		os.Remove(file)
+		return err
+	}
+
+	volume.Snapshots[id] = Snapshot{
+		Name:        id,
+		CreatedTime: util.Now(),
+		File:        file,
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	return d.deleteSnapshot(req.Name, volumeID)
+}
+
+func (d *Driver) deleteSnapshot(id, volumeID string) error {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: id,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debug("Deleting snapshot")
+	if err := os.Remove(snapshot.File); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(volume.Snapshots, id)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	return d.getSnapshotInfo(req.Name, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	info := map[string]string{
+		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_CREATED_TIME: snapshot.CreatedTime,
+		OPT_SIZE:                  strconv.FormatInt(volume.Size, 10),
+		"VolumeUUID":              volumeID,
+		"File":                    snapshot.File,
+	}
+	// Snapshot files are sparse, so only the blocks allocated cost space.
+	// Blocks shared by reflink are counted by every file sharing them, so
+	// it's the size of the snapshot as a full copy, not what changed since
+	// the previous snapshot
+	if allocated, err := getAllocatedSize(snapshot.File); err != nil {
+		log.Warnf("Cannot get allocated size of snapshot %v: %v", id, err)
+	} else {
+		info["AllocatedSize"] = strconv.FormatInt(allocated, 10)
+	}
+	return info, nil
+}
+
+// getAllocatedSize returns bytes allocated on disk for the file, which is
+// less than its size if it's sparse
+func getAllocatedSize(file string) (int64, error) {
+	st, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("Cannot get allocated blocks of %v", file)
+	}
+	// st_blocks is always in 512-byte units
+	return sys.Blocks * 512, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var (
+		volumeIDs []string
+		err       error
+	)
+	snapshots := make(map[string]map[string]string)
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	if specifiedVolumeID != "" {
+		volumeIDs = []string{
+			specifiedVolumeID,
+		}
+	} else {
+		volumeIDs, err = d.listVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		for snapshotID := range volume.Snapshots {
+			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshots, nil
+}
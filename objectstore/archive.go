@@ -0,0 +1,332 @@
+package objectstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	ARCHIVE_VERSION          = 1
+	ARCHIVE_MANIFEST_FILE    = "backup.json"
+	ARCHIVE_BLOCKS_DIRECTORY = "blocks"
+	ARCHIVE_BACKUP_FILE      = "backup.bak"
+)
+
+/*
+archiveManifest is the first entry of an archive of backup, followed by
+blocks of delta block backup as "blocks/<checksum>.blk", each once, or the
+file of single file backup as "backup.bak". Blocks are as stored in
+objectstore, i.e. compressed but not encrypted.
+*/
+type archiveManifest struct {
+	Version int
+	Volume  *Volume
+	Backup  *Backup
+}
+
+var (
+	checksumPattern = regexp.MustCompile(fmt.Sprintf("^[0-9a-f]{%d}$", util.PRESERVED_CHECKSUM_LENGTH))
+)
+
+func getArchiveBlockName(checksum string) string {
+	return ARCHIVE_BLOCKS_DIRECTORY + "/" + checksum + ".blk"
+}
+
+func writeArchiveEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+/*
+ExportBackup writes the backup at backupURL to w as a tar archive, which can
+be imported into any destination by ImportBackup, e.g. one without network
+access to the source. Blocks are verified before they're written. Nothing is
+written to w if the backup cannot be loaded.
+*/
+func ExportBackup(backupURL string, w io.Writer) error {
+	driver, err := GetObjectStoreDriver(backupURL)
+	if err != nil {
+		return err
+	}
+	backupName, volumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return err
+	}
+	volume, err := loadVolume(volumeName, driver)
+	if err != nil {
+		return generateError(logrus.Fields{
+			LOG_FIELD_VOLUME:     volumeName,
+			LOG_FIELD_BACKUP_URL: backupURL,
+		}, "Volume doesn't exist in objectstore: %v", err)
+	}
+	backup, err := loadBackup(backupName, volumeName, driver)
+	if err != nil {
+		return err
+	}
+	volume.LastBackupName = ""
+	manifest, err := json.Marshal(&archiveManifest{
+		Version: ARCHIVE_VERSION,
+		Volume:  volume,
+		Backup:  backup,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_START,
+		LOG_FIELD_EVENT:      LOG_EVENT_EXPORT,
+		LOG_FIELD_OBJECT:     LOG_OBJECT_BACKUP_URL,
+		LOG_FIELD_BACKUP_URL: backupURL,
+	}).Debug("Exporting backup")
+
+	tw := tar.NewWriter(w)
+	if err := writeArchiveEntry(tw, ARCHIVE_MANIFEST_FILE, int64(len(manifest)), bytes.NewReader(manifest)); err != nil {
+		return err
+	}
+	if backup.SingleFile.FilePath != "" {
+		if err := exportSingleFile(tw, backup, driver); err != nil {
+			return err
+		}
+	} else {
+		checksums := getUniqueChecksums(backup)
+		for i, checksum := range checksums {
+			log.Debugf("Export backup %v: blocks %v/%v", backup.Name, i+1, len(checksums))
+			rc, err := driver.Read(getBackupBlockFilePath(backup, checksum))
+			if err != nil {
+				return err
+			}
+			data, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			if _, err := decompressBlock(data, checksum); err != nil {
+				return fmt.Errorf("Block %v of backup %v is corrupted: %v", checksum, backup.Name, err)
+			}
+			if err := writeArchiveEntry(tw, getArchiveBlockName(checksum), int64(len(data)), bytes.NewReader(data)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:      LOG_EVENT_EXPORT,
+		LOG_FIELD_OBJECT:     LOG_OBJECT_BACKUP_URL,
+		LOG_FIELD_BACKUP_URL: backupURL,
+	}).Debug("Exported backup")
+	return nil
+}
+
+// exportSingleFile writes the backup file through a temporary file, since
+// size of the entry is needed before its content
+func exportSingleFile(tw *tar.Writer, backup *Backup, driver ObjectStoreDriver) error {
+	tmp, err := ioutil.TempFile("", "convoy-export-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := driver.Download(backup.SingleFile.FilePath, tmp.Name()); err != nil {
+		return err
+	}
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeArchiveEntry(tw, ARCHIVE_BACKUP_FILE, st.Size(), f)
+}
+
+/*
+ImportBackup imports the archive written by ExportBackup from r into destURL,
+the same way as ReplicateBackup copies the backup, and returns its URL in
+destURL. Blocks already in destURL are skipped, and every block imported is
+verified against its checksum. The backup is only saved once the archive is
+read completely, so a truncated archive leaves no backup behind, only blocks
+the next import would reuse.
+*/
+func ImportBackup(destURL string, r io.Reader) (string, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return "", fmt.Errorf("Cannot read archive of backup: %v", err)
+	}
+	if hdr.Name != ARCHIVE_MANIFEST_FILE {
+		return "", fmt.Errorf("Invalid archive of backup, %v is not the first entry", ARCHIVE_MANIFEST_FILE)
+	}
+	manifest := &archiveManifest{}
+	if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+		return "", fmt.Errorf("Invalid archive of backup: %v", err)
+	}
+	if manifest.Version != ARCHIVE_VERSION {
+		return "", fmt.Errorf("Unsupported version %v of archive of backup", manifest.Version)
+	}
+	volume, backup := manifest.Volume, manifest.Backup
+	if volume == nil || backup == nil || volume.Name != backup.VolumeName ||
+		!util.ValidateName(backup.Name) || !util.ValidateName(backup.VolumeName) {
+		return "", fmt.Errorf("Invalid archive of backup, missing or mismatched volume and backup")
+	}
+	// Archives exported by older versions carry configs of older schemas
+	if _, err := upgradeVolume(volume, ARCHIVE_MANIFEST_FILE); err != nil {
+		return "", err
+	}
+	if _, err := upgradeBackup(backup, ARCHIVE_MANIFEST_FILE); err != nil {
+		return "", err
+	}
+	// Paths in objectstore are derived from the archive, so they're checked
+	// rather than trusted
+	for _, blk := range backup.Blocks {
+		if !checksumPattern.MatchString(blk.BlockChecksum) {
+			return "", fmt.Errorf("Invalid archive of backup, invalid block checksum %q", blk.BlockChecksum)
+		}
+	}
+	if backup.SingleFile.FilePath != "" {
+		backup.SingleFile.FilePath = getSingleFileBackupFilePath(backup)
+	}
+
+	destURL, err = ResolveDestURL(destURL, volume.Name)
+	if err != nil {
+		return "", err
+	}
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return "", err
+	}
+	backupURL := encodeBackupURL(backup.Name, volume.Name, destURL)
+	if backupExists(backup.Name, volume.Name, driver) {
+		log.Debugf("Backup %v of volume %v has been imported to %v already", backup.Name, volume.Name, destURL)
+		return backupURL, nil
+	}
+
+	rep, err := beginReplica(backup, volume, driver)
+	if err != nil {
+		return "", err
+	}
+	defer rep.end()
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_IMPORT,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_BACKUP_URL,
+		LOG_FIELD_VOLUME:   volume.Name,
+		LOG_FIELD_DEST_URL: destURL,
+	}).Debugf("Importing backup %v", backup.Name)
+
+	pending := make(map[string]bool)
+	for _, checksum := range getUniqueChecksums(backup) {
+		pending[checksum] = true
+	}
+	fileImported := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("Cannot read archive of backup: %v", err)
+		}
+		switch {
+		case hdr.Name == ARCHIVE_BACKUP_FILE && backup.SingleFile.FilePath != "":
+			if err := importSingleFile(rep, tr); err != nil {
+				return "", err
+			}
+			fileImported = true
+		case filepath.Dir(hdr.Name) == ARCHIVE_BLOCKS_DIRECTORY:
+			checksum := strings.TrimSuffix(filepath.Base(hdr.Name), ".blk")
+			if !pending[checksum] {
+				return "", fmt.Errorf("Invalid archive of backup, unexpected block %v", checksum)
+			}
+			delete(pending, checksum)
+			exists, err := rep.hasBlock(checksum)
+			if err != nil {
+				return "", err
+			}
+			if exists {
+				continue
+			}
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return "", fmt.Errorf("Cannot read archive of backup: %v", err)
+			}
+			if err := rep.writeBlock(checksum, data); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("Invalid archive of backup, unexpected entry %v", hdr.Name)
+		}
+	}
+	if backup.SingleFile.FilePath != "" && !fileImported {
+		return "", fmt.Errorf("Invalid archive of backup, missing %v", ARCHIVE_BACKUP_FILE)
+	}
+	// Blocks left out of the archive are fine if they're in destURL already
+	for checksum := range pending {
+		exists, err := rep.hasBlock(checksum)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", fmt.Errorf("Invalid archive of backup, missing block %v", checksum)
+		}
+	}
+	if err := rep.save(); err != nil {
+		return "", err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_IMPORT,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_BACKUP_URL,
+		LOG_FIELD_VOLUME:   volume.Name,
+		LOG_FIELD_DEST_URL: destURL,
+	}).Debugf("Imported backup %v, wrote %v blocks of %v bytes", backup.Name, rep.copied, rep.storedBytes)
+	return backupURL, nil
+}
+
+func importSingleFile(rep *replica, r io.Reader) error {
+	tmp, err := ioutil.TempFile("", "convoy-import-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot read archive of backup: %v", err)
+	}
+	return rep.writeFile(tmp.Name())
+}
@@ -0,0 +1,123 @@
+package objectstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestExportImportBackup(c *check.C) {
+	dr := s.addDest(drDestURL)
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL1, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	backupURL2, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap10"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+
+	archive := &bytes.Buffer{}
+	c.Assert(ExportBackup(encodeBackupURL("backup-missing", "vol1", memDestURL), archive), check.NotNil)
+	c.Assert(archive.Len(), check.Equals, 0)
+	c.Assert(ExportBackup(backupURL1, archive), check.IsNil)
+
+	// Truncated archive leaves no backup behind
+	_, err = ImportBackup(drDestURL, bytes.NewReader(archive.Bytes()[:archive.Len()/2]))
+	c.Assert(err, check.ErrorMatches, "(Cannot read archive of backup|Invalid archive of backup, missing block).*")
+	c.Assert(backupExists(s.backupName(c, backupURL1), "vol1", dr), check.Equals, false)
+
+	importedURL, err := ImportBackup(drDestURL, bytes.NewReader(archive.Bytes()))
+	c.Assert(err, check.IsNil)
+	c.Assert(importedURL, check.Equals, encodeBackupURL(s.backupName(c, backupURL1), "vol1", drDestURL))
+	c.Assert(countDriverBlocks(dr), check.Equals, 2)
+	url, err := ImportBackup(drDestURL, bytes.NewReader(archive.Bytes()))
+	c.Assert(err, check.IsNil)
+	c.Assert(url, check.Equals, importedURL)
+
+	// Blocks in destination already are skipped
+	archive.Reset()
+	c.Assert(ExportBackup(backupURL2, archive), check.IsNil)
+	importedURL2, err := ImportBackup(drDestURL, archive)
+	c.Assert(err, check.IsNil)
+	c.Assert(countDriverBlocks(dr), check.Equals, 3)
+	imported, err := loadBackup(s.backupName(c, backupURL2), "vol1", dr)
+	c.Assert(err, check.IsNil)
+	c.Assert(imported.Size.StoredBytes, check.Equals, int64(len(dr.files[filepath.Clean(getBlockFilePath("vol1", imported.Blocks[1].BlockChecksum))])))
+
+	dir, err := ioutil.TempDir("", "objectstore-archive")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "snap10")
+	c.Assert(RestoreDeltaBlockBackup(importedURL2, file), check.IsNil)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, check.IsNil)
+	expected := make([]byte, volume.Size)
+	(&memSnapshotOps{}).ReadSnapshot("snap10", "vol1", 0, expected)
+	c.Assert(bytes.Equal(data, expected), check.Equals, true)
+}
+
+// writeArchive writes an archive of the manifest and blocks in order
+func writeArchive(c *check.C, manifest *archiveManifest, blocks map[string][]byte) io.Reader {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	data, err := json.Marshal(manifest)
+	c.Assert(err, check.IsNil)
+	c.Assert(writeArchiveEntry(tw, ARCHIVE_MANIFEST_FILE, int64(len(data)), bytes.NewReader(data)), check.IsNil)
+	for checksum, data := range blocks {
+		c.Assert(writeArchiveEntry(tw, getArchiveBlockName(checksum), int64(len(data)), bytes.NewReader(data)), check.IsNil)
+	}
+	c.Assert(tw.Close(), check.IsNil)
+	return buf
+}
+
+func (s *TestSuite) TestImportInvalidBackup(c *check.C) {
+	dr := s.addDest(drDestURL)
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(s.backupName(c, backupURL), "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	blocks := map[string][]byte{}
+	for _, blk := range backup.Blocks {
+		blocks[blk.BlockChecksum] = s.driver.files[filepath.Clean(getBlockFilePath("vol1", blk.BlockChecksum))]
+	}
+
+	_, err = ImportBackup(drDestURL, bytes.NewReader([]byte("not an archive")))
+	c.Assert(err, check.ErrorMatches, "Cannot read archive of backup.*")
+	_, err = ImportBackup(drDestURL, writeArchive(c, &archiveManifest{Version: 2, Volume: volume, Backup: backup}, nil))
+	c.Assert(err, check.ErrorMatches, "Unsupported version 2.*")
+	_, err = ImportBackup(drDestURL, writeArchive(c, &archiveManifest{Version: ARCHIVE_VERSION, Volume: volume}, nil))
+	c.Assert(err, check.ErrorMatches, "Invalid archive of backup, missing or mismatched.*")
+
+	// Blocks missing, corrupted or out of the backup
+	_, err = ImportBackup(drDestURL, writeArchive(c, &archiveManifest{Version: ARCHIVE_VERSION, Volume: volume, Backup: backup}, nil))
+	c.Assert(err, check.ErrorMatches, "Invalid archive of backup, missing block.*")
+	corrupted := map[string][]byte{}
+	for checksum, data := range blocks {
+		corrupted[checksum] = append([]byte{}, data...)
+		corrupted[checksum][len(data)-1] ^= 0xff
+	}
+	_, err = ImportBackup(drDestURL, writeArchive(c, &archiveManifest{Version: ARCHIVE_VERSION, Volume: volume, Backup: backup}, corrupted))
+	c.Assert(err, check.ErrorMatches, "Block .* is corrupted.*")
+	extra := map[string][]byte{"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef": []byte("data")}
+	_, err = ImportBackup(drDestURL, writeArchive(c, &archiveManifest{Version: ARCHIVE_VERSION, Volume: volume, Backup: backup}, extra))
+	c.Assert(err, check.ErrorMatches, "Invalid archive of backup, unexpected block.*")
+
+	// Paths in objectstore cannot be taken from archive
+	backup.Blocks[0].BlockChecksum = "../../../../config"
+	_, err = ImportBackup(drDestURL, writeArchive(c, &archiveManifest{Version: ARCHIVE_VERSION, Volume: volume, Backup: backup}, nil))
+	c.Assert(err, check.ErrorMatches, "Invalid archive of backup, invalid block checksum.*")
+	c.Assert(backupExists(backup.Name, "vol1", dr), check.Equals, false)
+}
@@ -0,0 +1,119 @@
+package objectstore
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	MIN_BLOCK_SIZE = 64 * 1024
+	MAX_BLOCK_SIZE = 64 * 1024 * 1024
+)
+
+var (
+	// Block size of delta block backups, unless specified for the volume
+	defaultBlockSize      int64 = DEFAULT_BLOCK_SIZE
+	defaultBlockSizeMutex       = &sync.RWMutex{}
+)
+
+// CheckBlockSize validates block size of delta block backups, which must be
+// power of 2 between MIN_BLOCK_SIZE and MAX_BLOCK_SIZE
+func CheckBlockSize(size int64) error {
+	if size < MIN_BLOCK_SIZE || size > MAX_BLOCK_SIZE || size&(size-1) != 0 {
+		return fmt.Errorf("Invalid backup block size %v, should be power of 2 between %v and %v",
+			size, MIN_BLOCK_SIZE, MAX_BLOCK_SIZE)
+	}
+	return nil
+}
+
+/*
+SetDefaultBlockSize sets block size of delta block backups of volumes without
+their own. Smaller blocks upload less for random writes, e.g. databases, while
+larger blocks mean fewer objects for sequential writes, e.g. logs.
+*/
+func SetDefaultBlockSize(size int64) error {
+	if err := CheckBlockSize(size); err != nil {
+		return err
+	}
+
+	defaultBlockSizeMutex.Lock()
+	defer defaultBlockSizeMutex.Unlock()
+
+	defaultBlockSize = size
+	return nil
+}
+
+func getDefaultBlockSize() int64 {
+	defaultBlockSizeMutex.RLock()
+	defer defaultBlockSizeMutex.RUnlock()
+
+	return defaultBlockSize
+}
+
+// getBackupBlockSize returns the block size of backup, which is
+// DEFAULT_BLOCK_SIZE for backups created before it was recorded
+func getBackupBlockSize(backup *Backup) int64 {
+	if backup.BlockSize == 0 {
+		return DEFAULT_BLOCK_SIZE
+	}
+	return backup.BlockSize
+}
+
+/*
+getChangedBlockOffsets returns offsets of blocks of blockSize covering the
+changed ranges in delta, in ascending order. The granularity of delta is up to
+the driver, so a block would be included if any part of it has changed.
+*/
+func getChangedBlockOffsets(delta *metadata.Mappings, blockSize int64) ([]int64, error) {
+	offsets := []int64{}
+	seen := make(map[int64]bool)
+	for _, d := range delta.Mappings {
+		if d.Size%delta.BlockSize != 0 {
+			return nil, fmt.Errorf("Mapping's size %v is not multiples of backup block size %v",
+				d.Size, delta.BlockSize)
+		}
+		for offset := d.Offset - d.Offset%blockSize; offset < d.Offset+d.Size; offset += blockSize {
+			if !seen[offset] {
+				seen[offset] = true
+				offsets = append(offsets, offset)
+			}
+		}
+	}
+	sort.Sort(int64Slice(offsets))
+	return offsets, nil
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// ParseBlockSize parses block size of delta block backups specified for a
+// volume, e.g. "256k". Empty means the default block size, returned as 0.
+func ParseBlockSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	value, err := util.ParseSize(size)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid backup block size %v: %v", size, err)
+	}
+	if err := CheckBlockSize(value); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// FormatBlockSize is the reverse of ParseBlockSize
+func FormatBlockSize(size int64) string {
+	if size == 0 {
+		return ""
+	}
+	return strconv.FormatInt(size, 10)
+}
@@ -0,0 +1,117 @@
+package objectstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+
+	"github.com/rancher/convoy/metadata"
+)
+
+func (s *TestSuite) TestChangedBlockOffsets(c *check.C) {
+	c.Assert(CheckBlockSize(MIN_BLOCK_SIZE/2), check.ErrorMatches, "Invalid backup block size.*")
+	c.Assert(CheckBlockSize(3*MIN_BLOCK_SIZE), check.ErrorMatches, "Invalid backup block size.*")
+	c.Assert(CheckBlockSize(2*MAX_BLOCK_SIZE), check.ErrorMatches, "Invalid backup block size.*")
+	size, err := ParseBlockSize("256k")
+	c.Assert(err, check.IsNil)
+	c.Assert(size, check.Equals, int64(256*1024))
+
+	delta := &metadata.Mappings{
+		Mappings: []metadata.Mapping{
+			{Offset: 0, Size: 4096},
+			{Offset: 3 * 4096, Size: 2 * 4096},
+			{Offset: 20 * 4096, Size: 4096},
+		},
+		BlockSize: 4096,
+	}
+	offsets, err := getChangedBlockOffsets(delta, 8192)
+	c.Assert(err, check.IsNil)
+	c.Assert(offsets, check.DeepEquals, []int64{0, 2 * 4096, 4 * 4096, 20 * 4096})
+	offsets, err = getChangedBlockOffsets(delta, 16*4096)
+	c.Assert(err, check.IsNil)
+	c.Assert(offsets, check.DeepEquals, []int64{0, 16 * 4096})
+
+	delta.Mappings[0].Size = 100
+	_, err = getChangedBlockOffsets(delta, 8192)
+	c.Assert(err, check.ErrorMatches, "Mapping's size.*")
+}
+
+func (s *TestSuite) TestBackupBlockSizes(c *check.C) {
+	defer SetDefaultBlockSize(DEFAULT_BLOCK_SIZE)
+	c.Assert(SetDefaultBlockSize(DEFAULT_BLOCK_SIZE/2), check.IsNil)
+
+	dir, err := ioutil.TempDir("", "objectstore-restore")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	// Default block size, two of the four blocks are the same
+	backupURL1, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	backup1, err := loadBackup(s.backupName(c, backupURL1), "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup1.BlockSize, check.Equals, int64(DEFAULT_BLOCK_SIZE/2))
+	c.Assert(backup1.Blocks, check.HasLen, 4)
+	c.Assert(s.countBlocks(), check.Equals, 2)
+
+	// Larger than the volume, would be a full backup of one partial block
+	backupURL2, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap10"}, memDestURL, 4*DEFAULT_BLOCK_SIZE, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	backup2, err := loadBackup(s.backupName(c, backupURL2), "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup2.BlockSize, check.Equals, int64(4*DEFAULT_BLOCK_SIZE))
+	c.Assert(backup2.Blocks, check.HasLen, 1)
+	info, err := GetBackupInfo(backupURL2)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["BlockSize"], check.Equals, "8388608")
+
+	// Both restore with their own block sizes
+	for snapshot, backupURL := range map[string]string{"snap1": backupURL1, "snap10": backupURL2} {
+		file := filepath.Join(dir, snapshot)
+		c.Assert(RestoreDeltaBlockBackup(backupURL, file), check.IsNil)
+		data, err := ioutil.ReadFile(file)
+		c.Assert(err, check.IsNil)
+		c.Assert(int64(len(data)), check.Equals, volume.Size)
+		expected := make([]byte, DEFAULT_BLOCK_SIZE)
+		for offset := int64(0); offset < volume.Size; offset += DEFAULT_BLOCK_SIZE {
+			(&memSnapshotOps{}).ReadSnapshot(snapshot, "vol1", offset, expected)
+			c.Assert(string(data[offset:offset+DEFAULT_BLOCK_SIZE]) == string(expected), check.Equals, true)
+		}
+	}
+}
+
+func (s *TestSuite) TestBackupExpandedVolume(c *check.C) {
+	dir, err := ioutil.TempDir("", "objectstore-restore")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   DEFAULT_BLOCK_SIZE,
+	}
+	_, err = CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+
+	// Backup after expansion covers the whole volume
+	volume.Size = 2 * DEFAULT_BLOCK_SIZE
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap2"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	info, err := GetBackupInfo(backupURL)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["VolumeSize"], check.Equals, "4194304")
+
+	file := filepath.Join(dir, "snap2")
+	c.Assert(RestoreDeltaBlockBackup(backupURL, file), check.IsNil)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, check.IsNil)
+	expected := make([]byte, volume.Size)
+	(&memSnapshotOps{}).ReadSnapshot("snap2", "vol1", 0, expected)
+	c.Assert(string(data) == string(expected), check.Equals, true)
+}
@@ -0,0 +1,324 @@
+package objectstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	CATALOG_DIRECTORY         = "catalog"
+	CATALOG_CONFIG_FILE       = "catalog.cfg"
+	CATALOG_JOURNAL_DIRECTORY = "journal"
+	CATALOG_VERSION           = 1
+
+	// Catalog is rebuilt by walking every volume once it's older than
+	// CATALOG_WALK_INTERVAL, picking up changes made by hosts which don't
+	// journal them, e.g. older versions of Convoy
+	CATALOG_WALK_INTERVAL = 24 * time.Hour
+	// Journal entries are merged into catalog once there are as many
+	CATALOG_COMPACT_ENTRIES = 100
+	// Journal entries merged are removed once they're older, so hosts
+	// saving catalog concurrently won't lose entries the others merged
+	CATALOG_JOURNAL_RETENTION = time.Hour
+
+	CATALOG_OP_ADD_VOLUME    = "add-volume"
+	CATALOG_OP_REMOVE_VOLUME = "remove-volume"
+	CATALOG_OP_ADD_BACKUP    = "add-backup"
+	CATALOG_OP_REMOVE_BACKUP = "remove-backup"
+)
+
+var (
+	catalogMutex = &sync.Mutex{}
+)
+
+/*
+backupCatalog is the list of volumes and backups of a destination, kept in the
+destination itself, so listing backups reads one object rather than walking
+every volume. Every change made to the destination is written as an entry of
+the journal next to it, so hosts never rewrite catalog for a single change
+and cannot overwrite changes of each other. Readers apply entries not in
+Applied on top of catalog, and merge them into catalog once there are
+CATALOG_COMPACT_ENTRIES of them.
+*/
+type backupCatalog struct {
+	Version       int
+	SchemaVersion int
+	LastWalked    string
+	Volumes       map[string]*indexedVolume
+	// Names of journal entries merged into Volumes
+	Applied []string
+}
+
+type catalogEntry struct {
+	Op         string
+	VolumeName string
+	Volume     *Volume `json:",omitempty"`
+	Backup     *Backup `json:",omitempty"`
+}
+
+func getCatalogFilePath() string {
+	return filepath.Join(OBJECTSTORE_BASE, CATALOG_DIRECTORY, CATALOG_CONFIG_FILE)
+}
+
+func getCatalogJournalPath() string {
+	return filepath.Join(OBJECTSTORE_BASE, CATALOG_DIRECTORY, CATALOG_JOURNAL_DIRECTORY)
+}
+
+func getCatalogEntryFilePath(name string) string {
+	return filepath.Join(getCatalogJournalPath(), name+CFG_SUFFIX)
+}
+
+// getCatalogEntryNames returns names of journal entries in the order they're
+// written
+func getCatalogEntryNames(driver ObjectStoreDriver) ([]string, error) {
+	fileList, err := driver.List(getCatalogJournalPath())
+	if err != nil {
+		// path doesn't exist
+		return []string{}, nil
+	}
+	names, err := util.ExtractNames(fileList, "", CFG_SUFFIX)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// getCatalogEntryTime parses the time entry was written from its name
+func getCatalogEntryTime(name string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(strings.SplitN(name, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid catalog journal entry %v", name)
+	}
+	return time.Unix(0, nanos), nil
+}
+
+// writeCatalogEntry journals a change made to the destination. Catalog is
+// removed if it fails, so the next reader walks the destination rather than
+// missing the change.
+func writeCatalogEntry(entry *catalogEntry, driver ObjectStoreDriver) {
+	name := util.GenerateName(fmt.Sprintf("%020d", time.Now().UnixNano()))
+	err := saveConfigInObjectStore(getCatalogEntryFilePath(name), driver, entry)
+	if err == nil {
+		return
+	}
+	log.Warnf("Failed to journal change of volume %v to catalog of %v, would walk it next time: %v",
+		entry.VolumeName, driver.GetURL(), err)
+	if driver.FileExists(getCatalogFilePath()) {
+		if err := driver.Remove(getCatalogFilePath()); err != nil {
+			log.Warnf("Failed to remove catalog of %v: %v", driver.GetURL(), err)
+		}
+	}
+}
+
+func catalogAddVolume(volume *Volume, driver ObjectStoreDriver) {
+	writeCatalogEntry(&catalogEntry{
+		Op:         CATALOG_OP_ADD_VOLUME,
+		VolumeName: volume.Name,
+		Volume:     volume,
+	}, driver)
+}
+
+func catalogRemoveVolume(volumeName string, driver ObjectStoreDriver) {
+	writeCatalogEntry(&catalogEntry{
+		Op:         CATALOG_OP_REMOVE_VOLUME,
+		VolumeName: volumeName,
+	}, driver)
+}
+
+func catalogAddBackup(backup *Backup, driver ObjectStoreDriver) {
+	writeCatalogEntry(&catalogEntry{
+		Op:         CATALOG_OP_ADD_BACKUP,
+		VolumeName: backup.VolumeName,
+		Backup:     indexedBackup(backup),
+	}, driver)
+}
+
+func catalogRemoveBackup(backup *Backup, driver ObjectStoreDriver) {
+	writeCatalogEntry(&catalogEntry{
+		Op:         CATALOG_OP_REMOVE_BACKUP,
+		VolumeName: backup.VolumeName,
+		Backup:     &Backup{Name: backup.Name, VolumeName: backup.VolumeName},
+	}, driver)
+}
+
+func (cat *backupCatalog) isStale() bool {
+	if cat.Version != CATALOG_VERSION || cat.SchemaVersion != SCHEMA_VERSION {
+		return true
+	}
+	lastWalked, err := time.Parse(time.RubyDate, cat.LastWalked)
+	if err != nil {
+		return true
+	}
+	return time.Since(lastWalked) > CATALOG_WALK_INTERVAL
+}
+
+// apply applies a journal entry. Volumes added by hosts which don't journal
+// them are loaded from objectstore, and skipped if they're gone since.
+func (cat *backupCatalog) apply(entry *catalogEntry, driver ObjectStoreDriver) {
+	switch entry.Op {
+	case CATALOG_OP_ADD_VOLUME:
+		if _, exists := cat.Volumes[entry.VolumeName]; !exists && entry.Volume != nil {
+			cat.Volumes[entry.VolumeName] = &indexedVolume{
+				Volume:  *entry.Volume,
+				Backups: make(map[string]*Backup),
+			}
+		}
+	case CATALOG_OP_REMOVE_VOLUME:
+		delete(cat.Volumes, entry.VolumeName)
+	case CATALOG_OP_ADD_BACKUP:
+		if entry.Backup == nil {
+			return
+		}
+		vol, exists := cat.Volumes[entry.VolumeName]
+		if !exists {
+			volume, err := loadVolume(entry.VolumeName, driver)
+			if err != nil {
+				log.Debugf("Skip backup %v of volume %v in catalog journal: %v", entry.Backup.Name, entry.VolumeName, err)
+				return
+			}
+			vol = &indexedVolume{
+				Volume:  *volume,
+				Backups: make(map[string]*Backup),
+			}
+			cat.Volumes[entry.VolumeName] = vol
+		}
+		vol.Backups[entry.Backup.Name] = entry.Backup
+	case CATALOG_OP_REMOVE_BACKUP:
+		if vol, exists := cat.Volumes[entry.VolumeName]; exists && entry.Backup != nil {
+			delete(vol.Backups, entry.Backup.Name)
+		}
+	default:
+		log.Warnf("Unknown operation %v in catalog journal of %v", entry.Op, driver.GetURL())
+	}
+}
+
+// save saves catalog with entryNames merged, then removes entries merged long
+// enough ago
+func (cat *backupCatalog) save(entryNames []string, driver ObjectStoreDriver) {
+	cat.Version = CATALOG_VERSION
+	cat.SchemaVersion = SCHEMA_VERSION
+	cat.Applied = entryNames
+	if err := saveConfigInObjectStore(getCatalogFilePath(), driver, cat); err != nil {
+		log.Warnf("Failed to save catalog of %v: %v", driver.GetURL(), err)
+		return
+	}
+	expired := []string{}
+	for _, name := range entryNames {
+		t, err := getCatalogEntryTime(name)
+		if err != nil || time.Since(t) > CATALOG_JOURNAL_RETENTION {
+			expired = append(expired, getCatalogEntryFilePath(name))
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	if err := driver.Remove(expired...); err != nil {
+		log.Warnf("Failed to remove catalog journal entries of %v: %v", driver.GetURL(), err)
+	}
+}
+
+/*
+walkCatalog builds catalog by walking every volume in objectstore, then saves
+it. Configs of backups in volumes are only loaded if they're not there.
+Journal entries are listed before the walk, so changes journaled during the
+walk would be applied again on top of it, which is harmless. Caller should
+hold catalogMutex.
+*/
+func walkCatalog(volumes map[string]*indexedVolume, driver ObjectStoreDriver) (*backupCatalog, error) {
+	entryNames, err := getCatalogEntryNames(driver)
+	if err != nil {
+		return nil, err
+	}
+	if volumes == nil {
+		volumes = make(map[string]*indexedVolume)
+	}
+	idx := &backupIndex{
+		Volumes: volumes,
+	}
+	if err := idx.walk(driver); err != nil {
+		return nil, err
+	}
+	cat := &backupCatalog{
+		LastWalked: util.Now(),
+		Volumes:    idx.Volumes,
+	}
+	cat.save(entryNames, driver)
+	return cat, nil
+}
+
+/*
+readCatalog returns volumes and backups of the destination from its catalog,
+with journal entries applied. Destination is walked only if catalog is
+missing or stale.
+*/
+func readCatalog(driver ObjectStoreDriver) (*backupCatalog, error) {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+
+	cat := &backupCatalog{}
+	if driver.FileExists(getCatalogFilePath()) {
+		if err := loadConfigInObjectStore(getCatalogFilePath(), driver, cat); err != nil {
+			log.Warnf("Failed to load catalog of %v, would walk it: %v", driver.GetURL(), err)
+			cat = &backupCatalog{}
+		}
+	}
+	if cat.isStale() {
+		var volumes map[string]*indexedVolume
+		// Catalogs of other versions may not be compatible
+		if cat.Version == CATALOG_VERSION && cat.SchemaVersion == SCHEMA_VERSION {
+			volumes = cat.Volumes
+		}
+		log.Debugf("Catalog of %v is missing or stale, walking it", driver.GetURL())
+		return walkCatalog(volumes, driver)
+	}
+	if cat.Volumes == nil {
+		cat.Volumes = make(map[string]*indexedVolume)
+	}
+
+	entryNames, err := getCatalogEntryNames(driver)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[string]bool)
+	for _, name := range cat.Applied {
+		applied[name] = true
+	}
+	pending := 0
+	for _, name := range entryNames {
+		if applied[name] {
+			continue
+		}
+		entry := &catalogEntry{}
+		if err := loadConfigInObjectStore(getCatalogEntryFilePath(name), driver, entry); err != nil {
+			// Removed by another host merged it since listed
+			log.Debugf("Skip catalog journal entry %v of %v: %v", name, driver.GetURL(), err)
+			continue
+		}
+		cat.apply(entry, driver)
+		pending++
+	}
+	if pending >= CATALOG_COMPACT_ENTRIES {
+		cat.save(entryNames, driver)
+	}
+	return cat, nil
+}
+
+func listFromCatalog(volumeName string, driver ObjectStoreDriver, storageDriverName string) (map[string]map[string]string, error) {
+	cat, err := readCatalog(driver)
+	if err != nil {
+		return nil, err
+	}
+	idx := &backupIndex{
+		DestURL: driver.GetURL(),
+		Volumes: cat.Volumes,
+	}
+	return idx.list(volumeName, storageDriverName)
+}
@@ -0,0 +1,122 @@
+package objectstore
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestCatalogList(c *check.C) {
+	s.addBackup(c, "vol1", "backup1")
+	s.addBackup(c, "vol1", "backup2")
+
+	// First listing walks the destination and saves catalog
+	resp, err := List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, 2)
+	c.Assert(s.driver.FileExists(getCatalogFilePath()), check.Equals, true)
+
+	// Changes are journaled, and applied on top of catalog without walking
+	s.addBackup(c, "v2", "backup3")
+	backup, err := loadBackup("backup1", "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(removeBackup(backup, s.driver), check.IsNil)
+	s.driver.reads = 0
+	resp, err = List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, 2)
+	c.Assert(resp[encodeBackupURL("backup2", "vol1", memDestURL)], check.NotNil)
+	c.Assert(resp[encodeBackupURL("backup3", "v2", memDestURL)], check.NotNil)
+	// Catalog and the entries of volume v2, its backup and the removal
+	c.Assert(s.driver.reads, check.Equals, 4)
+
+	resp, err = List("v2", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, 1)
+	c.Assert(removeVolume("v2", s.driver), check.IsNil)
+	resp, err = List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, 1)
+
+	// Listed the same from local index
+	c.Assert(SetIndexDir(s.indexDir), check.IsNil)
+	indexed, err := List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(indexed, check.DeepEquals, resp)
+}
+
+func (s *TestSuite) TestCatalogStale(c *check.C) {
+	s.addBackup(c, "vol1", "backup1")
+	resp, err := List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, 1)
+
+	// Backups saved by hosts which don't journal them are missing until
+	// catalog is walked again
+	c.Assert(saveConfigInObjectStore(getBackupConfigPath("backup2", "vol1"), s.driver, &Backup{
+		Name:       "backup2",
+		VolumeName: "vol1",
+	}), check.IsNil)
+	resp, err = List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, 1)
+
+	cat := &backupCatalog{}
+	c.Assert(loadConfigInObjectStore(getCatalogFilePath(), s.driver, cat), check.IsNil)
+	cat.LastWalked = time.Now().Add(-CATALOG_WALK_INTERVAL - time.Minute).Format(time.RubyDate)
+	c.Assert(saveConfigInObjectStore(getCatalogFilePath(), s.driver, cat), check.IsNil)
+
+	// Only the backup config not in catalog is loaded by the walk, along
+	// with the volume config
+	s.driver.reads = 0
+	resp, err = List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, 2)
+	c.Assert(s.driver.reads, check.Equals, 3)
+
+	// Catalog is walked if it's gone, e.g. journal failed
+	s.driver.unavailable = true
+	catalogAddBackup(&Backup{Name: "backup3", VolumeName: "vol1"}, s.driver)
+	s.driver.unavailable = false
+	c.Assert(s.driver.FileExists(getCatalogFilePath()), check.Equals, false)
+	resp, err = List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, 2)
+	c.Assert(s.driver.FileExists(getCatalogFilePath()), check.Equals, true)
+}
+
+func (s *TestSuite) TestCatalogCompact(c *check.C) {
+	s.addBackup(c, "vol1", "backup0")
+	_, err := List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+
+	// Entry written long ago
+	expired := fmt.Sprintf("%020d-0123456789abcdef", time.Now().Add(-CATALOG_JOURNAL_RETENTION-time.Minute).UnixNano())
+	c.Assert(saveConfigInObjectStore(getCatalogEntryFilePath(expired), s.driver, &catalogEntry{
+		Op:         CATALOG_OP_REMOVE_BACKUP,
+		VolumeName: "vol1",
+		Backup:     &Backup{Name: "backup0", VolumeName: "vol1"},
+	}), check.IsNil)
+	for i := 1; i < CATALOG_COMPACT_ENTRIES; i++ {
+		s.addBackup(c, "vol1", fmt.Sprintf("backup%d", i))
+	}
+	resp, err := List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, CATALOG_COMPACT_ENTRIES-1)
+
+	// Entries are merged, and only expired ones are removed
+	cat := &backupCatalog{}
+	c.Assert(loadConfigInObjectStore(getCatalogFilePath(), s.driver, cat), check.IsNil)
+	c.Assert(cat.Applied, check.HasLen, CATALOG_COMPACT_ENTRIES+2)
+	c.Assert(cat.Volumes["vol1"].Backups, check.HasLen, CATALOG_COMPACT_ENTRIES-1)
+	names, err := getCatalogEntryNames(s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.HasLen, CATALOG_COMPACT_ENTRIES+1)
+
+	s.driver.reads = 0
+	resp, err = List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, CATALOG_COMPACT_ENTRIES-1)
+	c.Assert(s.driver.reads, check.Equals, 1)
+}
@@ -0,0 +1,268 @@
+package objectstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	COMPRESSION_NONE = "none"
+	COMPRESSION_GZIP = "gzip"
+	COMPRESSION_LZ4  = "lz4"
+	COMPRESSION_ZSTD = "zstd"
+
+	// Blocks of backups created before compression was recorded are gzip
+	DEFAULT_COMPRESSION = COMPRESSION_GZIP
+)
+
+var (
+	// Compression of blocks uploaded to destinations, keyed by their
+	// canonical URLs, or defaultCompression if not set
+	compressions       = make(map[string]string)
+	defaultCompression = DEFAULT_COMPRESSION
+	compressionsMutex  = &sync.RWMutex{}
+
+	zstdEncoders      = make(map[zstd.EncoderLevel]*zstd.Encoder)
+	zstdEncodersMutex = &sync.Mutex{}
+	zstdDecoder       *zstd.Decoder
+	zstdDecoderOnce   sync.Once
+
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	lz4Magic  = []byte{0x04, 0x22, 0x4d, 0x18}
+)
+
+/*
+parseCompression parses compression of blocks in the form of <method> or
+<method>-<level>:
+
+	none     blocks are stored as they are
+	gzip     levels 1 to 9, 6 by default
+	lz4      fastest to compress and decompress, no level
+	zstd     levels 1 to 22, 3 by default. Levels are mapped to speeds of
+	         the encoder, 1 and 2 fastest, 3 to 5 default, 6 to 9 better,
+	         and 10 or higher best
+*/
+func parseCompression(compression string) (string, int, error) {
+	parts := strings.SplitN(compression, "-", 2)
+	method := parts[0]
+	level := 0
+	if len(parts) == 2 {
+		var err error
+		if level, err = strconv.Atoi(parts[1]); err != nil {
+			return "", 0, fmt.Errorf("Invalid level of compression %v", compression)
+		}
+	}
+	switch method {
+	case COMPRESSION_NONE, COMPRESSION_LZ4:
+		if len(parts) == 2 {
+			return "", 0, fmt.Errorf("Compression %v doesn't have levels", method)
+		}
+	case COMPRESSION_GZIP:
+		if len(parts) == 1 {
+			level = gzip.DefaultCompression
+		} else if level < gzip.BestSpeed || level > gzip.BestCompression {
+			return "", 0, fmt.Errorf("Invalid level of compression %v, should be between %v and %v",
+				compression, gzip.BestSpeed, gzip.BestCompression)
+		}
+	case COMPRESSION_ZSTD:
+		if len(parts) == 1 {
+			level = 3
+		} else if level < 1 || level > 22 {
+			return "", 0, fmt.Errorf("Invalid level of compression %v, should be between 1 and 22", compression)
+		}
+	default:
+		return "", 0, fmt.Errorf("Invalid compression %v, should be %v, %v, %v or %v",
+			compression, COMPRESSION_NONE, COMPRESSION_GZIP, COMPRESSION_LZ4, COMPRESSION_ZSTD)
+	}
+	return method, level, nil
+}
+
+// CheckCompression validates compression of blocks, e.g. gzip-9 or zstd
+func CheckCompression(compression string) error {
+	_, _, err := parseCompression(compression)
+	return err
+}
+
+// SetDefaultCompression sets compression of blocks uploaded to destinations
+// without their own
+func SetDefaultCompression(compression string) error {
+	if err := CheckCompression(compression); err != nil {
+		return err
+	}
+
+	compressionsMutex.Lock()
+	defer compressionsMutex.Unlock()
+
+	defaultCompression = compression
+	return nil
+}
+
+/*
+SetCompression sets compression of blocks uploaded to destURL by delta block
+backups afterwards. Blocks already in the destination are left as they are,
+and would still be shared by later backups, since every block object records
+its own compression.
+*/
+func SetCompression(destURL, compression string) error {
+	if err := CheckCompression(compression); err != nil {
+		return err
+	}
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return err
+	}
+
+	compressionsMutex.Lock()
+	defer compressionsMutex.Unlock()
+
+	compressions[driver.GetURL()] = compression
+	return nil
+}
+
+func getCompression(destURL string) string {
+	compressionsMutex.RLock()
+	defer compressionsMutex.RUnlock()
+
+	if compression, exists := compressions[destURL]; exists {
+		return compression
+	}
+	return defaultCompression
+}
+
+func getZstdEncoder(level int) (*zstd.Encoder, error) {
+	encoderLevel := zstd.EncoderLevelFromZstd(level)
+
+	zstdEncodersMutex.Lock()
+	defer zstdEncodersMutex.Unlock()
+
+	if encoder, exists := zstdEncoders[encoderLevel]; exists {
+		return encoder, nil
+	}
+	// Encoder is safe for concurrent EncodeAll, and expensive to create
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(encoderLevel))
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoders[encoderLevel] = encoder
+	return encoder, nil
+}
+
+func getZstdDecoder() *zstd.Decoder {
+	zstdDecoderOnce.Do(func() {
+		// Only fails with invalid options
+		zstdDecoder, _ = zstd.NewReader(nil)
+	})
+	return zstdDecoder
+}
+
+// compressBlock compresses data of a block with compression
+func compressBlock(compression string, data []byte) ([]byte, error) {
+	method, level, err := parseCompression(compression)
+	if err != nil {
+		return nil, err
+	}
+	switch method {
+	case COMPRESSION_NONE:
+		return data, nil
+	case COMPRESSION_ZSTD:
+		encoder, err := getZstdEncoder(level)
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+	}
+
+	var b bytes.Buffer
+	if method == COMPRESSION_LZ4 {
+		w := lz4.NewWriter(&b)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+	w, err := gzip.NewWriterLevel(&b, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decompressBlockAs(method string, data []byte) ([]byte, error) {
+	switch method {
+	case COMPRESSION_ZSTD:
+		return getZstdDecoder().DecodeAll(data, nil)
+	case COMPRESSION_LZ4:
+		return ioutil.ReadAll(lz4.NewReader(bytes.NewReader(data)))
+	case COMPRESSION_GZIP:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	}
+	return data, nil
+}
+
+/*
+decompressBlock decompresses the block object and verifies its checksum.
+Compression is found by the magic number of the object, so blocks uploaded
+with different compressions can be shared by backups. Blocks uploaded without
+compression could start with a magic number by chance, so they're tried as
+they are if decompression fails, and the checksum decides.
+*/
+func decompressBlock(data []byte, checksum string) ([]byte, error) {
+	method := COMPRESSION_NONE
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		method = COMPRESSION_GZIP
+	case bytes.HasPrefix(data, zstdMagic):
+		method = COMPRESSION_ZSTD
+	case bytes.HasPrefix(data, lz4Magic):
+		method = COMPRESSION_LZ4
+	}
+	block, err := decompressBlockAs(method, data)
+	if err == nil && util.GetChecksum(block) == checksum {
+		return block, nil
+	}
+	if method != COMPRESSION_NONE && util.GetChecksum(data) == checksum {
+		return data, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decompress %v block: %v", method, err)
+	}
+	return nil, fmt.Errorf("Checksum verification failed for block!")
+}
+
+// readBlock reads and verifies the block of checksum at blkFile
+func readBlock(blkFile, checksum string, driver ObjectStoreDriver) ([]byte, error) {
+	rc, err := driver.Read(blkFile)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return decompressBlock(data, checksum)
+}
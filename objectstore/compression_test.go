@@ -0,0 +1,105 @@
+package objectstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+
+	"github.com/rancher/convoy/util"
+)
+
+func (s *TestSuite) TestCompressBlock(c *check.C) {
+	for _, compression := range []string{"gzip-0", "gzip-10", "lz4-1", "zstd-0", "zstd-23", "zstd-x", "brotli"} {
+		c.Assert(CheckCompression(compression), check.NotNil, check.Commentf("%v", compression))
+	}
+
+	data := bytes.Repeat([]byte("convoy block "), 10000)
+	checksum := util.GetChecksum(data)
+	magics := map[string][]byte{
+		"gzip":    gzipMagic,
+		"gzip-1":  gzipMagic,
+		"lz4":     lz4Magic,
+		"zstd":    zstdMagic,
+		"zstd-19": zstdMagic,
+	}
+	for compression, magic := range magics {
+		compressed, err := compressBlock(compression, data)
+		c.Assert(err, check.IsNil)
+		c.Assert(bytes.HasPrefix(compressed, magic), check.Equals, true, check.Commentf("%v", compression))
+		c.Assert(len(compressed) < len(data)/10, check.Equals, true, check.Commentf("%v", compression))
+		block, err := decompressBlock(compressed, checksum)
+		c.Assert(err, check.IsNil)
+		c.Assert(bytes.Equal(block, data), check.Equals, true)
+
+		_, err = decompressBlock(compressed[:len(compressed)/2], checksum)
+		c.Assert(err, check.NotNil)
+	}
+
+	// Uncompressed block happens to start with a magic number
+	raw := append(append([]byte{}, zstdMagic...), data...)
+	stored, err := compressBlock("none", raw)
+	c.Assert(err, check.IsNil)
+	block, err := decompressBlock(stored, util.GetChecksum(raw))
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(block, raw), check.Equals, true)
+	_, err = decompressBlock(stored, checksum)
+	c.Assert(err, check.ErrorMatches, "Cannot decompress zstd block.*")
+}
+
+func (s *TestSuite) TestBackupCompression(c *check.C) {
+	defer func() {
+		compressionsMutex.Lock()
+		delete(compressions, memDestURL)
+		compressionsMutex.Unlock()
+	}()
+
+	dir, err := ioutil.TempDir("", "objectstore-restore")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	c.Assert(SetCompression(memDestURL, "zstd"), check.IsNil)
+	backupURL1, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	backup1, err := loadBackup(s.backupName(c, backupURL1), "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup1.Compression, check.Equals, "zstd")
+
+	// One of the blocks of snap10 is shared with snap1, and stays zstd
+	c.Assert(SetCompression(memDestURL, "lz4"), check.IsNil)
+	backupURL2, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap10"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.countBlocks(), check.Equals, 3)
+	magics := map[string]int{}
+	for name, data := range s.driver.files {
+		if filepath.Ext(name) != ".blk" {
+			continue
+		}
+		if bytes.HasPrefix(data, zstdMagic) {
+			magics["zstd"]++
+		} else if bytes.HasPrefix(data, lz4Magic) {
+			magics["lz4"]++
+		}
+	}
+	c.Assert(magics, check.DeepEquals, map[string]int{"zstd": 2, "lz4": 1})
+	info, err := GetBackupInfo(backupURL2)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["Compression"], check.Equals, "lz4")
+
+	for snapshot, backupURL := range map[string]string{"snap1": backupURL1, "snap10": backupURL2} {
+		file := filepath.Join(dir, snapshot)
+		c.Assert(RestoreDeltaBlockBackup(backupURL, file), check.IsNil)
+		data, err := ioutil.ReadFile(file)
+		c.Assert(err, check.IsNil)
+		expected := make([]byte, volume.Size)
+		(&memSnapshotOps{}).ReadSnapshot(snapshot, "vol1", 0, expected)
+		c.Assert(bytes.Equal(data, expected), check.Equals, true)
+	}
+}
@@ -29,10 +29,26 @@ func getBackupConfigName(id string) string {
 	return BACKUP_CONFIG_PREFIX + id + CFG_SUFFIX
 }
 
+// notFoundError is returned for configs missing in objectstore, e.g. of
+// deleted backups
+type notFoundError struct {
+	filePath string
+}
+
+func (e notFoundError) Error() string {
+	return fmt.Sprintf("cannot find %v in objectstore", e.filePath)
+}
+
+// IsNotFound returns true if err is of a config missing in objectstore
+func IsNotFound(err error) bool {
+	_, ok := err.(notFoundError)
+	return ok
+}
+
 func loadConfigInObjectStore(filePath string, driver ObjectStoreDriver, v interface{}) error {
 	size := driver.FileSize(filePath)
 	if size < 0 {
-		return fmt.Errorf("cannot find %v in objectstore", filePath)
+		return notFoundError{filePath}
 	}
 	rc, err := driver.Read(filePath)
 	if err != nil {
@@ -136,10 +152,14 @@ func loadVolume(volumeName string, driver ObjectStoreDriver) (*Volume, error) {
 	if err := loadConfigInObjectStore(file, driver, v); err != nil {
 		return nil, err
 	}
+	if _, err := upgradeVolume(v, file); err != nil {
+		return nil, err
+	}
 	return v, nil
 }
 
 func saveVolume(v *Volume, driver ObjectStoreDriver) error {
+	v.SchemaVersion = SCHEMA_VERSION
 	file := getVolumeFilePath(v.Name)
 	if err := saveConfigInObjectStore(file, driver, v); err != nil {
 		return err
@@ -148,11 +168,11 @@ func saveVolume(v *Volume, driver ObjectStoreDriver) error {
 }
 
 func getBackupNamesForVolume(volumeName string, driver ObjectStoreDriver) ([]string, error) {
-	result := []string{}
+	// Nothing is listed if path doesn't exist, failures mean backups
+	// cannot be told, which garbage collection mustn't take as none
 	fileList, err := driver.List(getBackupPath(volumeName))
 	if err != nil {
-		// path doesn't exist
-		return result, nil
+		return nil, err
 	}
 	return util.ExtractNames(fileList, BACKUP_CONFIG_PREFIX, CFG_SUFFIX)
 }
@@ -173,13 +193,20 @@ func backupExists(backupName, volumeName string, bsDriver ObjectStoreDriver) boo
 
 func loadBackup(backupName, volumeName string, bsDriver ObjectStoreDriver) (*Backup, error) {
 	backup := &Backup{}
-	if err := loadConfigInObjectStore(getBackupConfigPath(backupName, volumeName), bsDriver, backup); err != nil {
+	file := getBackupConfigPath(backupName, volumeName)
+	if err := loadConfigInObjectStore(file, bsDriver, backup); err != nil {
+		return nil, err
+	}
+	if _, err := upgradeBackup(backup, file); err != nil {
 		return nil, err
 	}
 	return backup, nil
 }
 
 func saveBackup(backup *Backup, bsDriver ObjectStoreDriver) error {
+	backup.SchemaVersion = SCHEMA_VERSION
+	backup.EncryptionKeyVersion = getEncryptionKeyVersion(bsDriver)
+	lockBackup(backup, bsDriver)
 	filePath := getBackupConfigPath(backup.Name, backup.VolumeName)
 	if bsDriver.FileExists(filePath) {
 		log.Warnf("Snapshot configuration file %v already exists, would remove it\n", filePath)
@@ -190,6 +217,8 @@ func saveBackup(backup *Backup, bsDriver ObjectStoreDriver) error {
 	if err := saveConfigInObjectStore(filePath, bsDriver, backup); err != nil {
 		return err
 	}
+	indexAddBackup(backup, bsDriver)
+	catalogAddBackup(backup, bsDriver)
 	return nil
 }
 
@@ -199,5 +228,7 @@ func removeBackup(backup *Backup, bsDriver ObjectStoreDriver) error {
 		return err
 	}
 	log.Debugf("Removed %v on objectstore", filePath)
+	indexRemoveBackup(backup, bsDriver)
+	catalogRemoveBackup(backup, bsDriver)
 	return nil
 }
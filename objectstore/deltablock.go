@@ -1,10 +1,11 @@
 package objectstore
 
 import (
+	"bytes"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/convoy/metadata"
@@ -22,6 +23,7 @@ type DeltaBlockBackupOperations interface {
 	HasSnapshot(id, volumeID string) bool
 	CompareSnapshot(id, compareID, volumeID string) (*metadata.Mappings, error)
 	OpenSnapshot(id, volumeID string) error
+	// ReadSnapshot may be called concurrently for different blocks
 	ReadSnapshot(id, volumeID string, start int64, data []byte) error
 	CloseSnapshot(id, volumeID string) error
 }
@@ -34,25 +36,68 @@ const (
 	BLOCK_SEPARATE_LAYER2 = 4
 )
 
-func CreateDeltaBlockBackup(volume *Volume, snapshot *Snapshot, destURL string, deltaOps DeltaBlockBackupOperations) (string, error) {
+/*
+CreateDeltaBlockBackup backs up the blocks of snapshot changed since the last
+backup of the volume in destURL. Blocks are blockSize bytes, or the default
+block size if it's 0. A full backup would be created if blockSize differs from
+the last backup's, since blocks of different sizes cannot be merged.
+*/
+func CreateDeltaBlockBackup(volume *Volume, snapshot *Snapshot, destURL string, blockSize int64, deltaOps DeltaBlockBackupOperations) (string, error) {
 	if deltaOps == nil {
 		return "", fmt.Errorf("Missing DeltaBlockBackupOperations")
 	}
+	if blockSize == 0 {
+		blockSize = getDefaultBlockSize()
+	}
+	if err := CheckBlockSize(blockSize); err != nil {
+		return "", err
+	}
 
+	destURL, err := ResolveDestURL(destURL, volume.Name)
+	if err != nil {
+		return "", err
+	}
 	bsDriver, err := GetObjectStoreDriver(destURL)
 	if err != nil {
 		return "", err
 	}
 
+	compression := getCompression(bsDriver.GetURL())
+	sharedBlocks := useBlockPool(bsDriver.GetURL())
+
+	backupName := util.GenerateName("backup")
+	inflight, err := beginBackup(backupName, volume.Name, bsDriver)
+	if err != nil {
+		return "", err
+	}
+	defer endBackup(inflight, volume.Name, bsDriver)
+
+	var poolInflight *marker
+	if sharedBlocks {
+		if err := addPool(bsDriver); err != nil {
+			return "", err
+		}
+		if poolInflight, err = beginInflight(backupName, getPoolPath(), bsDriver); err != nil {
+			return "", err
+		}
+		defer endPoolBackup(poolInflight, bsDriver)
+	}
+
 	if err := addVolume(volume, bsDriver); err != nil {
 		return "", err
 	}
 
-	// Update volume from objectstore
+	// Update volume from objectstore, the volume may have been expanded
+	// since its last backup
+	size := volume.Size
 	volume, err = loadVolume(volume.Name, bsDriver)
 	if err != nil {
 		return "", err
 	}
+	if size > volume.Size {
+		log.Debugf("Volume %v has been expanded from %v to %v bytes", volume.Name, volume.Size, size)
+		volume.Size = size
+	}
 
 	lastBackupName := volume.LastBackupName
 
@@ -70,7 +115,24 @@ func CreateDeltaBlockBackup(volume *Volume, snapshot *Snapshot, destURL string,
 		}
 
 		lastSnapshotName = lastBackup.SnapshotName
-		if lastSnapshotName == snapshot.Name {
+		if lastBlockSize := getBackupBlockSize(lastBackup); lastBlockSize != blockSize {
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_FALLBACK,
+				LOG_FIELD_OBJECT: LOG_OBJECT_SNAPSHOT,
+				LOG_FIELD_VOLUME: volume.Name,
+			}).Debugf("Block size changed from %v to %v, would process with full backup", lastBlockSize, blockSize)
+			lastSnapshotName = ""
+			lastBackup = nil
+		} else if lastBackup.SharedBlocks != sharedBlocks {
+			// Blocks of last backup aren't where blocks of this backup are
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_FALLBACK,
+				LOG_FIELD_OBJECT: LOG_OBJECT_SNAPSHOT,
+				LOG_FIELD_VOLUME: volume.Name,
+			}).Debugf("Block pool changed from %v to %v, would process with full backup", lastBackup.SharedBlocks, sharedBlocks)
+			lastSnapshotName = ""
+			lastBackup = nil
+		} else if lastSnapshotName == snapshot.Name {
 			//Generate full snapshot if the snapshot has been backed up last time
 			lastSnapshotName = ""
 			log.Debug("Would create full snapshot metadata")
@@ -99,8 +161,9 @@ func CreateDeltaBlockBackup(volume *Volume, snapshot *Snapshot, destURL string,
 	if err != nil {
 		return "", err
 	}
-	if delta.BlockSize != DEFAULT_BLOCK_SIZE {
-		return "", fmt.Errorf("Currently doesn't support different block sizes driver other than %v", DEFAULT_BLOCK_SIZE)
+	offsets, err := getChangedBlockOffsets(delta, blockSize)
+	if err != nil {
+		return "", err
 	}
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:        LOG_REASON_COMPLETE,
@@ -118,54 +181,57 @@ func CreateDeltaBlockBackup(volume *Volume, snapshot *Snapshot, destURL string,
 	}).Debug("Creating backup")
 
 	deltaBackup := &Backup{
-		Name:         util.GenerateName("backup"),
+		Name:         backupName,
 		VolumeName:   volume.Name,
 		SnapshotName: snapshot.Name,
+		BlockSize:    blockSize,
+		Compression:  compression,
+		Size:         &BackupSize{},
+		SharedBlocks: sharedBlocks,
 		Blocks:       []BlockMapping{},
 	}
-	mCounts := len(delta.Mappings)
-	for m, d := range delta.Mappings {
-		if d.Size%delta.BlockSize != 0 {
-			return "", fmt.Errorf("Mapping's size %v is not multiples of backup block size %v",
-				d.Size, delta.BlockSize)
-		}
-		block := make([]byte, DEFAULT_BLOCK_SIZE)
-		blkCounts := d.Size / delta.BlockSize
-		for i := int64(0); i < blkCounts; i++ {
-			offset := d.Offset + i*delta.BlockSize
-			log.Debugf("Backup for %v: segment %v/%v, blocks %v/%v", snapshot.Name, m+1, mCounts, i+1, blkCounts)
-			err := deltaOps.ReadSnapshot(snapshot.Name, volume.Name, offset, block)
-			if err != nil {
-				return "", err
-			}
-			checksum := util.GetChecksum(block)
-			blkFile := getBlockFilePath(volume.Name, checksum)
-			if bsDriver.FileSize(blkFile) >= 0 {
-				blockMapping := BlockMapping{
-					Offset:        offset,
-					BlockChecksum: checksum,
-				}
-				deltaBackup.Blocks = append(deltaBackup.Blocks, blockMapping)
-				log.Debugf("Found existed block match at %v", blkFile)
-				continue
+	writer := newBlockWriter(deltaBackup, inflight, poolInflight, bsDriver)
+	saved := false
+	if sharedBlocks {
+		defer func() {
+			if !saved {
+				releaseFailedBackupRefs(backupName, volume.Name, writer.newRefs, bsDriver)
 			}
-
-			rs, err := util.CompressData(block)
-			if err != nil {
-				return "", err
-			}
-
-			if err := bsDriver.Write(blkFile, rs); err != nil {
-				return "", err
-			}
-			log.Debugf("Created new block file at %v", blkFile)
-
-			blockMapping := BlockMapping{
-				Offset:        offset,
-				BlockChecksum: checksum,
-			}
-			deltaBackup.Blocks = append(deltaBackup.Blocks, blockMapping)
+		}()
+	}
+	concurrency := getTransferConcurrency()
+	bufs := make([][]byte, concurrency)
+	blkCounts := len(offsets)
+	// Workers fill in their own mappings, so blocks stay in order of offsets
+	deltaBackup.Blocks = make([]BlockMapping, blkCounts)
+	err = runTransfers(blkCounts, concurrency, func(worker, i int) error {
+		offset := offsets[i]
+		log.Debugf("Backup for %v: blocks %v/%v", snapshot.Name, i+1, blkCounts)
+		if bufs[worker] == nil {
+			bufs[worker] = make([]byte, blockSize)
+		}
+		block := bufs[worker]
+		// The last block may be partial if volume size isn't multiple of
+		// block size
+		if volume.Size > 0 && offset+blockSize > volume.Size {
+			block = block[:volume.Size-offset]
+		}
+		err := deltaOps.ReadSnapshot(snapshot.Name, volume.Name, offset, block)
+		if err != nil {
+			return err
+		}
+		checksum, err := writer.write(block)
+		if err != nil {
+			return err
+		}
+		deltaBackup.Blocks[i] = BlockMapping{
+			Offset:        offset,
+			BlockChecksum: checksum,
 		}
+		return nil
+	})
+	if err != nil {
+		return "", err
 	}
 
 	log.WithFields(logrus.Fields{
@@ -173,16 +239,19 @@ func CreateDeltaBlockBackup(volume *Volume, snapshot *Snapshot, destURL string,
 		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
 		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
 		LOG_FIELD_SNAPSHOT: snapshot.Name,
-	}).Debug("Created snapshot changed blocks")
+	}).Debugf("Created snapshot changed blocks of %v bytes, uploaded %v blocks of %v bytes, verified %v of them",
+		deltaBackup.Size.ChangedBytes, writer.uploaded, deltaBackup.Size.StoredBytes, writer.verified)
 
 	backup := mergeSnapshotMap(deltaBackup, lastBackup)
 	backup.SnapshotName = snapshot.Name
 	backup.SnapshotCreatedAt = snapshot.CreatedTime
+	backup.Labels = snapshot.Labels
 	backup.CreatedTime = util.Now()
 
 	if err := saveBackup(backup, bsDriver); err != nil {
 		return "", err
 	}
+	saved = true
 
 	volume.LastBackupName = backup.Name
 	if err := saveVolume(volume, bsDriver); err != nil {
@@ -192,6 +261,100 @@ func CreateDeltaBlockBackup(volume *Volume, snapshot *Snapshot, destURL string,
 	return encodeBackupURL(backup.Name, volume.Name, destURL), nil
 }
 
+// blockWriter uploads blocks of a backup being created to the volume or the
+// block pool, skipping blocks already there
+type blockWriter struct {
+	backup       *Backup
+	inflight     *marker
+	poolInflight *marker
+	driver       ObjectStoreDriver
+
+	// Guards the counters, sizes, the inflight markers and blocks being
+	// uploaded, which are claimed by checksum so workers reading identical
+	// blocks won't upload them twice
+	mutex     sync.Mutex
+	uploading map[string]bool
+	// References added to the block pool by the backup, released if the
+	// backup fails
+	newRefs            map[string]bool
+	uploaded, verified int
+}
+
+func newBlockWriter(backup *Backup, inflight, poolInflight *marker, driver ObjectStoreDriver) *blockWriter {
+	return &blockWriter{
+		backup:       backup,
+		inflight:     inflight,
+		poolInflight: poolInflight,
+		driver:       driver,
+		uploading:    make(map[string]bool),
+		newRefs:      make(map[string]bool),
+	}
+}
+
+// write uploads block unless it's already in objectstore, and returns its
+// checksum. It may be called concurrently.
+func (w *blockWriter) write(block []byte) (string, error) {
+	checksum := util.GetChecksum(block)
+
+	w.mutex.Lock()
+	w.backup.Size.ChangedBytes += int64(len(block))
+	err := w.inflight.keepAlive(w.driver)
+	if err == nil && w.poolInflight != nil {
+		err = w.poolInflight.keepAlive(w.driver)
+	}
+	claimed := w.uploading[checksum]
+	w.uploading[checksum] = true
+	w.mutex.Unlock()
+	if err != nil {
+		return "", err
+	}
+	if claimed {
+		return checksum, nil
+	}
+	if w.backup.SharedBlocks {
+		added, err := addPoolRef(checksum, w.backup.VolumeName, w.driver)
+		if err != nil {
+			return "", err
+		}
+		if added {
+			w.mutex.Lock()
+			w.newRefs[checksum] = true
+			w.mutex.Unlock()
+		}
+	}
+	blkFile := getBackupBlockFilePath(w.backup, checksum)
+	if w.driver.FileSize(blkFile) >= 0 {
+		log.Debugf("Found existed block match at %v", blkFile)
+		return checksum, nil
+	}
+
+	data, err := compressBlock(w.backup.Compression, block)
+	if err != nil {
+		return "", err
+	}
+
+	if err := w.driver.Write(blkFile, bytes.NewReader(data)); err != nil {
+		return "", err
+	}
+	log.Debugf("Created new block file at %v", blkFile)
+	verify := shouldVerifyBlock()
+	if verify {
+		if err := verifyBlock(w.backup.VolumeName, blkFile, checksum, w.driver); err != nil {
+			return "", err
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.uploaded++
+	w.backup.Size.StoredBytes += int64(len(data))
+	if verify {
+		w.verified++
+	}
+	return checksum, nil
+}
+
 func mergeSnapshotMap(deltaBackup, lastBackup *Backup) *Backup {
 	if lastBackup == nil {
 		return deltaBackup
@@ -200,6 +363,10 @@ func mergeSnapshotMap(deltaBackup, lastBackup *Backup) *Backup {
 		Name:         deltaBackup.Name,
 		VolumeName:   deltaBackup.VolumeName,
 		SnapshotName: deltaBackup.SnapshotName,
+		BlockSize:    deltaBackup.BlockSize,
+		Compression:  deltaBackup.Compression,
+		Size:         deltaBackup.Size,
+		SharedBlocks: deltaBackup.SharedBlocks,
 		Blocks:       []BlockMapping{},
 	}
 	var d, l int
@@ -278,24 +445,20 @@ func RestoreDeltaBlockBackup(backupURL, volDevName string) error {
 		LOG_FIELD_BACKUP_URL:  backupURL,
 	}).Debug()
 	blkCounts := len(backup.Blocks)
-	for i, block := range backup.Blocks {
+	err = runTransfers(blkCounts, getTransferConcurrency(), func(worker, i int) error {
+		block := backup.Blocks[i]
 		log.Debugf("Restore for %v: block %v, %v/%v", volDevName, block.BlockChecksum, i+1, blkCounts)
-		blkFile := getBlockFilePath(srcVolumeName, block.BlockChecksum)
-		rc, err := bsDriver.Read(blkFile)
-		if err != nil {
-			return err
-		}
-		r, err := util.DecompressAndVerify(rc, block.BlockChecksum)
-		rc.Close()
+		data, err := readBlock(getBackupBlockFilePath(backup, block.BlockChecksum), block.BlockChecksum, bsDriver)
 		if err != nil {
 			return err
 		}
-		if _, err := volDev.Seek(block.Offset, 0); err != nil {
-			return err
-		}
-		if _, err := io.CopyN(volDev, r, DEFAULT_BLOCK_SIZE); err != nil {
-			return err
-		}
+		// Blocks are of the size recorded in the backup, or partial at
+		// the end of the volume
+		_, err = volDev.WriteAt(data, block.Offset)
+		return err
+	})
+	if err != nil {
+		return retrieveBackup(backup, bsDriver, err)
 	}
 
 	// We want to truncate regular files, but not device
@@ -329,11 +492,13 @@ func DeleteDeltaBlockBackup(backupURL string) error {
 	if err != nil {
 		return err
 	}
+	if err := checkBackupLock(backup); err != nil {
+		return err
+	}
 	discardBlockSet := make(map[string]bool)
 	for _, blk := range backup.Blocks {
 		discardBlockSet[blk.BlockChecksum] = true
 	}
-	discardBlockCounts := len(discardBlockSet)
 
 	if err := removeBackup(backup, bsDriver); err != nil {
 		return err
@@ -346,49 +511,9 @@ func DeleteDeltaBlockBackup(backupURL string) error {
 		}
 	}
 
-	backupNames, err := getBackupNamesForVolume(volumeName, bsDriver)
-	if err != nil {
-		return err
-	}
-	if len(backupNames) == 0 {
-		log.Debugf("No snapshot existed for the volume %v, removing volume", volumeName)
-		if err := removeVolume(volumeName, bsDriver); err != nil {
-			log.Warningf("Failed to remove volume %v due to: %v", volumeName, err.Error())
-		}
-		return nil
-	}
-
-	log.Debug("GC started")
-	for _, backupName := range backupNames {
-		backup, err := loadBackup(backupName, volumeName, bsDriver)
-		if err != nil {
-			return err
-		}
-		for _, blk := range backup.Blocks {
-			if _, exists := discardBlockSet[blk.BlockChecksum]; exists {
-				delete(discardBlockSet, blk.BlockChecksum)
-				discardBlockCounts--
-				if discardBlockCounts == 0 {
-					break
-				}
-			}
-		}
-		if discardBlockCounts == 0 {
-			break
-		}
-	}
-
-	var blkFileList []string
-	for blk := range discardBlockSet {
-		blkFileList = append(blkFileList, getBlockFilePath(volumeName, blk))
-		log.Debugf("Found unused blocks %v for volume %v", blk, volumeName)
-	}
-	if err := bsDriver.Remove(blkFileList...); err != nil {
+	if err := collectGarbage(volumeName, discardBlockSet, bsDriver); err != nil {
 		return err
 	}
-	log.Debug("Removed unused blocks for volume ", volumeName)
-
-	log.Debug("GC completed")
 	log.Debug("Removed objectstore backup ", backupName)
 
 	return nil
@@ -399,10 +524,18 @@ func getBlockPath(volumeName string) string {
 }
 
 func getBlockFilePath(volumeName, checksum string) string {
-	blockSubDirLayer1 := checksum[0:BLOCK_SEPARATE_LAYER1]
-	blockSubDirLayer2 := checksum[BLOCK_SEPARATE_LAYER1:BLOCK_SEPARATE_LAYER2]
+	blockSubDirLayer1, blockSubDirLayer2 := getBlockSubDirs(checksum)
 	path := filepath.Join(getBlockPath(volumeName), blockSubDirLayer1, blockSubDirLayer2)
-	fileName := checksum + ".blk"
+	fileName := checksum + BLOCK_FILE_SUFFIX
 
 	return filepath.Join(path, fileName)
 }
+
+// getBackupBlockFilePath returns path of the block of the backup, in the
+// block pool or the volume
+func getBackupBlockFilePath(backup *Backup, checksum string) string {
+	if backup.SharedBlocks {
+		return getPoolBlockFilePath(checksum)
+	}
+	return getBlockFilePath(backup.VolumeName, checksum)
+}
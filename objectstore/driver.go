@@ -4,14 +4,26 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"regexp"
+	"sort"
+	"sync"
 
 	"github.com/Sirupsen/logrus"
 
 	. "github.com/rancher/convoy/logging"
 )
 
+// InitFunc creates an ObjectStoreDriver for destURL, whose scheme is the kind
+// the driver was registered with
 type InitFunc func(destURL string) (ObjectStoreDriver, error)
 
+/*
+ObjectStoreDriver is the interface of a backup destination. Besides the
+built-in s3 and vfs drivers, a program embedding convoy can compile in its own
+destination by implementing this interface and calling RegisterDriver(),
+normally from init() of its package. All paths are relative to the
+destination, using "/" as separator.
+*/
 type ObjectStoreDriver interface {
 	Kind() string
 	GetURL() string
@@ -27,6 +39,10 @@ type ObjectStoreDriver interface {
 
 var (
 	initializers map[string]InitFunc
+	initMutex    = &sync.RWMutex{}
+
+	// Kind is used as URL scheme, see RFC 3986
+	kindRegexp = regexp.MustCompile(`^[a-z][a-z0-9+.-]*$`)
 )
 
 var (
@@ -41,7 +57,22 @@ func init() {
 	initializers = make(map[string]InitFunc)
 }
 
+/*
+RegisterDriver makes destination URLs with scheme kind handled by drivers
+created by initFunc. It's safe to be called at any time, and the driver would
+be used by following operations on such URLs.
+*/
 func RegisterDriver(kind string, initFunc InitFunc) error {
+	if !kindRegexp.MatchString(kind) {
+		return fmt.Errorf("Invalid objectstore driver kind %v, must be a valid URL scheme in lower case", kind)
+	}
+	if initFunc == nil {
+		return fmt.Errorf("Invalid empty initializer for objectstore driver %v", kind)
+	}
+
+	initMutex.Lock()
+	defer initMutex.Unlock()
+
 	if _, exists := initializers[kind]; exists {
 		return fmt.Errorf("%s has already been registered", kind)
 	}
@@ -49,6 +80,32 @@ func RegisterDriver(kind string, initFunc InitFunc) error {
 	return nil
 }
 
+// UnregisterDriver removes driver registered as kind, so URLs with scheme kind
+// would be no longer supported
+func UnregisterDriver(kind string) error {
+	initMutex.Lock()
+	defer initMutex.Unlock()
+
+	if _, exists := initializers[kind]; !exists {
+		return fmt.Errorf("%s hasn't been registered", kind)
+	}
+	delete(initializers, kind)
+	return nil
+}
+
+// ListDrivers returns the sorted kinds of registered drivers
+func ListDrivers() []string {
+	initMutex.RLock()
+	defer initMutex.RUnlock()
+
+	kinds := []string{}
+	for kind := range initializers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
 func GetObjectStoreDriver(destURL string) (ObjectStoreDriver, error) {
 	if destURL == "" {
 		return nil, fmt.Errorf("Destination URL hasn't been specified")
@@ -57,8 +114,15 @@ func GetObjectStoreDriver(destURL string) (ObjectStoreDriver, error) {
 	if err != nil {
 		return nil, err
 	}
-	if _, exists := initializers[u.Scheme]; !exists {
+	initMutex.RLock()
+	initFunc, exists := initializers[u.Scheme]
+	initMutex.RUnlock()
+	if !exists {
 		return nil, fmt.Errorf("Driver %v is not supported!", u.Scheme)
 	}
-	return initializers[u.Scheme](destURL)
+	driver, err := initFunc(destURL)
+	if err != nil {
+		return nil, err
+	}
+	return wrapEncryption(driver), nil
 }
@@ -0,0 +1,30 @@
+package objectstore
+
+import (
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestRegisterDriver(c *check.C) {
+	initFunc := func(destURL string) (ObjectStoreDriver, error) {
+		return s.driver, nil
+	}
+
+	c.Assert(RegisterDriver(memKind, initFunc), check.ErrorMatches, "mem has already been registered")
+	c.Assert(RegisterDriver("Blob Store", initFunc), check.ErrorMatches, "Invalid objectstore driver kind.*")
+	c.Assert(RegisterDriver("blob", nil), check.ErrorMatches, "Invalid empty initializer.*")
+
+	_, err := GetObjectStoreDriver("blob+v2://bucket/path")
+	c.Assert(err, check.ErrorMatches, "Driver blob\\+v2 is not supported!")
+
+	c.Assert(RegisterDriver("blob+v2", initFunc), check.IsNil)
+	c.Assert(ListDrivers(), check.DeepEquals, []string{"blob+v2", memKind})
+	driver, err := GetObjectStoreDriver("blob+v2://bucket/path")
+	c.Assert(err, check.IsNil)
+	c.Assert(driver, check.Equals, s.driver)
+
+	c.Assert(UnregisterDriver("blob+v2"), check.IsNil)
+	c.Assert(UnregisterDriver("blob+v2"), check.ErrorMatches, "blob\\+v2 hasn't been registered")
+	c.Assert(ListDrivers(), check.DeepEquals, []string{memKind})
+	_, err = GetObjectStoreDriver("blob+v2://bucket/path")
+	c.Assert(err, check.NotNil)
+}
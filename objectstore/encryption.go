@@ -0,0 +1,482 @@
+package objectstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+const (
+	ENCRYPTION_KEY_SIZE = 32
+
+	// Objects are encrypted in chunks, so large files can be decrypted as
+	// they're read, and truncated or reordered chunks would be detected
+	ENCRYPTION_CHUNK_SIZE = 64 * 1024
+
+	ENCRYPTION_PBKDF2_ITERATIONS = 100000
+
+	// Keys of a destination are numbered from ENCRYPTION_FIRST_KEY_VERSION,
+	// and the ones rotated from are kept in ENCRYPTION_KEYRING_FILE
+	ENCRYPTION_FIRST_KEY_VERSION = 1
+	ENCRYPTION_KEYRING_FILE      = "keyring.cfg"
+
+	encryptionMagic    = "CVYENC01"
+	encryptionMagicV2  = "CVYENC02"
+	encryptionSaltSize = 16
+	encryptionKDFKey   = 0
+	encryptionKDFPBKDF = 1
+	encryptionCheck    = "convoy-keyring"
+)
+
+var (
+	encryptions      = make(map[string]*encryption)
+	encryptionsMutex = &sync.RWMutex{}
+)
+
+/*
+Encryption of everything written to a destination, including blocks, backup
+configs and volume configs, with AES-256-GCM on the client side, so the
+objectstore only sees ciphertext. Either Key, which must be 32 bytes, or
+Passphrase, which a key would be derived from with PBKDF2, is required.
+*/
+type Encryption struct {
+	Key        []byte
+	Passphrase string
+}
+
+/*
+encryption holds the keys of a destination. Key derived from passphrase
+depends on the salt recorded in every object, so keys derived for salts
+found in objects are cached, and objects written share the salt chosen when
+encryption was set.
+*/
+type encryption struct {
+	kdf     byte
+	salt    []byte
+	key     []byte
+	derived map[string][]byte
+	mutex   sync.Mutex
+
+	passphrase string
+
+	// Version of the key, and keys of earlier versions, loaded from keyring
+	// of the destination before anything is read or written
+	version       int
+	previous      map[int]*encryption
+	keyringLoaded bool
+	keyringMutex  sync.Mutex
+}
+
+/*
+encryptionKeyring is kept in the destination as it is, and holds keys the
+destination has been rotated from, wrapped with the current key, so objects
+encrypted with them can still be read without being encrypted again. Check
+tells whether the key configured is the current one.
+*/
+type encryptionKeyring struct {
+	Version int
+	Salt    []byte
+	Check   []byte
+	Keys    []wrappedEncryptionKey
+}
+
+// wrappedEncryptionKey is a key of the destination, or the passphrase it's
+// derived from, sealed with the current key
+type wrappedEncryptionKey struct {
+	Version int
+	KDF     byte
+	Secret  []byte
+}
+
+/*
+Objects are encrypted in the format of:
+
+	magic | kdf | kdf salt | object salt | chunk...
+
+or, once the key of the destination has been rotated:
+
+	magic v2 | kdf | kdf salt | key version | object salt | chunk...
+
+Every object has its own key, derived from the destination key and object
+salt, so nonces would never be reused across objects. Each chunk is sealed
+with nonce of its index and whether it's the last one, and path of the object
+as additional data, so objects can't be swapped or truncated unnoticed. All
+chunks but the last hold ENCRYPTION_CHUNK_SIZE of data, the last one can be
+empty.
+*/
+type encryptionHeader struct {
+	kdf        byte
+	kdfSalt    []byte
+	version    int
+	objectSalt []byte
+}
+
+const (
+	encryptionHeaderSize   = len(encryptionMagic) + 1 + 2*encryptionSaltSize
+	encryptionHeaderV2Size = encryptionHeaderSize + 4
+)
+
+// pbkdf2 implements PBKDF2 with HMAC-SHA256 for a key of sha256.Size
+func pbkdf2(passphrase, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, passphrase)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+func newEncryption(e Encryption) (*encryption, error) {
+	if (len(e.Key) == 0) == (e.Passphrase == "") {
+		return nil, fmt.Errorf("Either encryption key or passphrase must be specified")
+	}
+	enc := &encryption{
+		salt:     make([]byte, encryptionSaltSize),
+		derived:  make(map[string][]byte),
+		version:  ENCRYPTION_FIRST_KEY_VERSION,
+		previous: make(map[int]*encryption),
+	}
+	if len(e.Key) != 0 {
+		if len(e.Key) != ENCRYPTION_KEY_SIZE {
+			return nil, fmt.Errorf("Invalid encryption key, should be %v bytes but got %v",
+				ENCRYPTION_KEY_SIZE, len(e.Key))
+		}
+		enc.kdf = encryptionKDFKey
+		enc.key = e.Key
+		return enc, nil
+	}
+	if _, err := rand.Read(enc.salt); err != nil {
+		return nil, err
+	}
+	enc.kdf = encryptionKDFPBKDF
+	enc.passphrase = e.Passphrase
+	enc.key = pbkdf2([]byte(e.Passphrase), enc.salt, ENCRYPTION_PBKDF2_ITERATIONS)
+	enc.derived[string(enc.salt)] = enc.key
+	return enc, nil
+}
+
+/*
+SetEncryption makes everything written to and read from destURL encrypted.
+The driver of destURL is initialized to find its canonical URL, which backup
+URLs of the destination would contain.
+*/
+func SetEncryption(destURL string, e Encryption) error {
+	enc, err := newEncryption(e)
+	if err != nil {
+		return err
+	}
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return err
+	}
+
+	encryptionsMutex.Lock()
+	defer encryptionsMutex.Unlock()
+
+	encryptions[driver.GetURL()] = enc
+	return nil
+}
+
+// RemoveEncryption stops encryption of destURL, which has to be its
+// canonical URL
+func RemoveEncryption(destURL string) {
+	encryptionsMutex.Lock()
+	defer encryptionsMutex.Unlock()
+
+	delete(encryptions, destURL)
+}
+
+func getEncryption(destURL string) *encryption {
+	encryptionsMutex.RLock()
+	defer encryptionsMutex.RUnlock()
+
+	return encryptions[destURL]
+}
+
+// destinationKey returns key of the destination objects with header were
+// encrypted with
+func (e *encryption) destinationKey(header *encryptionHeader) ([]byte, error) {
+	if header.kdf != e.kdf {
+		return nil, fmt.Errorf("Object was encrypted with a different kind of key")
+	}
+	if e.kdf == encryptionKDFKey {
+		return e.key, nil
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if key, exists := e.derived[string(header.kdfSalt)]; exists {
+		return key, nil
+	}
+	key := pbkdf2([]byte(e.passphrase), header.kdfSalt, ENCRYPTION_PBKDF2_ITERATIONS)
+	e.derived[string(header.kdfSalt)] = key
+	return key, nil
+}
+
+func newObjectCipher(destKey, objectSalt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, destKey)
+	mac.Write(objectSalt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, index uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce, index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encrypt writes content of r encrypted for the object at path to w
+func (e *encryption) encrypt(w io.Writer, r io.Reader, path string) error {
+	header := make([]byte, 0, encryptionHeaderV2Size)
+	if e.version == ENCRYPTION_FIRST_KEY_VERSION {
+		// Readable by versions not knowing rotation
+		header = append(header, encryptionMagic...)
+		header = append(header, e.kdf)
+		header = append(header, e.salt...)
+	} else {
+		header = append(header, encryptionMagicV2...)
+		header = append(header, e.kdf)
+		header = append(header, e.salt...)
+		header = append(header, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(header[len(header)-4:], uint32(e.version))
+	}
+	objectSalt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(objectSalt); err != nil {
+		return err
+	}
+	header = append(header, objectSalt...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	aead, err := newObjectCipher(e.key, objectSalt)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, ENCRYPTION_CHUNK_SIZE)
+	sealed := make([]byte, 0, ENCRYPTION_CHUNK_SIZE+aead.Overhead())
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := n < len(buf)
+		sealed = aead.Seal(sealed[:0], chunkNonce(aead, index, last), buf[:n], []byte(path))
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+func readEncryptionHeader(r io.Reader, path string) (*encryptionHeader, error) {
+	buf := make([]byte, encryptionHeaderV2Size)
+	if _, err := io.ReadFull(r, buf[:encryptionHeaderSize]); err != nil {
+		return nil, fmt.Errorf("%v is not encrypted, but encryption is enabled for the destination", path)
+	}
+	header := &encryptionHeader{
+		kdf:     buf[len(encryptionMagic)],
+		kdfSalt: buf[len(encryptionMagic)+1 : len(encryptionMagic)+1+encryptionSaltSize],
+		version: ENCRYPTION_FIRST_KEY_VERSION,
+	}
+	switch string(buf[:len(encryptionMagic)]) {
+	case encryptionMagic:
+		header.objectSalt = buf[len(encryptionMagic)+1+encryptionSaltSize : encryptionHeaderSize]
+	case encryptionMagicV2:
+		if _, err := io.ReadFull(r, buf[encryptionHeaderSize:]); err != nil {
+			return nil, fmt.Errorf("Cannot read encryption header of %v: %v", path, err)
+		}
+		versionStart := len(encryptionMagic) + 1 + encryptionSaltSize
+		header.version = int(binary.BigEndian.Uint32(buf[versionStart:]))
+		header.objectSalt = buf[versionStart+4:]
+	default:
+		return nil, fmt.Errorf("%v is not encrypted, but encryption is enabled for the destination", path)
+	}
+	return header, nil
+}
+
+// decryptReader decrypts chunks of an object as they're read
+type decryptReader struct {
+	rc    io.ReadCloser
+	aead  cipher.AEAD
+	path  string
+	index uint64
+	buf   []byte
+	plain []byte
+	done  bool
+}
+
+func (e *encryption) newDecryptReader(rc io.ReadCloser, path string) (*decryptReader, error) {
+	header, err := readEncryptionHeader(rc, path)
+	if err != nil {
+		return nil, err
+	}
+	if header.version != e.version {
+		previous, exists := e.previous[header.version]
+		if !exists {
+			return nil, fmt.Errorf("Cannot decrypt %v, encrypted with key version %v which is not in keyring of the destination",
+				path, header.version)
+		}
+		e = previous
+	}
+	key, err := e.destinationKey(header)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decrypt %v: %v", path, err)
+	}
+	aead, err := newObjectCipher(key, header.objectSalt)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		rc:   rc,
+		aead: aead,
+		path: path,
+		buf:  make([]byte, ENCRYPTION_CHUNK_SIZE+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(d.rc, d.buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		last := n < len(d.buf)
+		d.plain, err = d.aead.Open(d.buf[:0], chunkNonce(d.aead, d.index, last), d.buf[:n], []byte(d.path))
+		if err != nil {
+			return 0, fmt.Errorf("Cannot decrypt %v, wrong key or the object was corrupted or truncated", d.path)
+		}
+		d.index++
+		d.done = last
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.rc.Close()
+}
+
+// encryptedDriver encrypts everything written through ObjectStoreDriver, and
+// decrypts everything read
+type encryptedDriver struct {
+	ObjectStoreDriver
+	enc *encryption
+}
+
+func (d *encryptedDriver) Read(src string) (io.ReadCloser, error) {
+	if err := d.enc.loadKeyring(d.ObjectStoreDriver); err != nil {
+		return nil, err
+	}
+	rc, err := d.ObjectStoreDriver.Read(src)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := d.enc.newDecryptReader(rc, src)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (d *encryptedDriver) Write(dst string, rs io.ReadSeeker) error {
+	if err := d.enc.loadKeyring(d.ObjectStoreDriver); err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	if err := d.enc.encrypt(buf, rs, dst); err != nil {
+		return err
+	}
+	return d.ObjectStoreDriver.Write(dst, bytes.NewReader(buf.Bytes()))
+}
+
+// Upload encrypts src to a temporary file first, since src could be too large
+// to be encrypted in memory
+func (d *encryptedDriver) Upload(src, dst string) error {
+	if err := d.enc.loadKeyring(d.ObjectStoreDriver); err != nil {
+		return err
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	tmp, err := ioutil.TempFile(os.TempDir(), "convoy-encrypted-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := d.enc.encrypt(tmp, file, dst); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	return d.ObjectStoreDriver.Upload(tmp.Name(), dst)
+}
+
+func (d *encryptedDriver) Download(src, dst string) error {
+	rc, err := d.Read(src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, rc); err != nil {
+		file.Close()
+		os.Remove(dst)
+		return err
+	}
+	return file.Close()
+}
+
+// wrapEncryption returns driver encrypting everything if encryption is set
+// for the destination of driver
+func wrapEncryption(driver ObjectStoreDriver) ObjectStoreDriver {
+	enc := getEncryption(driver.GetURL())
+	if enc == nil {
+		return driver
+	}
+	return &encryptedDriver{
+		ObjectStoreDriver: driver,
+		enc:               enc,
+	}
+}
@@ -0,0 +1,105 @@
+package objectstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+const gcmTagSize = 16
+
+func (s *TestSuite) TestPBKDF2(c *check.C) {
+	// Test vector of PBKDF2-HMAC-SHA256 from RFC 7914
+	key := pbkdf2([]byte("passwd"), []byte("salt"), 1)
+	c.Assert(hex.EncodeToString(key), check.Equals, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc")
+}
+
+func (s *TestSuite) TestEncryptionChunks(c *check.C) {
+	enc, err := newEncryption(Encryption{Key: bytes.Repeat([]byte{'k'}, ENCRYPTION_KEY_SIZE)})
+	c.Assert(err, check.IsNil)
+
+	for _, size := range []int{0, 100, ENCRYPTION_CHUNK_SIZE, 2*ENCRYPTION_CHUNK_SIZE + 100} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i % 251)
+		}
+		buf := &bytes.Buffer{}
+		c.Assert(enc.encrypt(buf, bytes.NewReader(data), "volumes/vol1/blocks/a.blk"), check.IsNil)
+		encrypted := buf.Bytes()
+
+		r, err := enc.newDecryptReader(ioutil.NopCloser(bytes.NewReader(encrypted)), "volumes/vol1/blocks/a.blk")
+		c.Assert(err, check.IsNil)
+		decrypted, err := ioutil.ReadAll(r)
+		c.Assert(err, check.IsNil)
+		c.Assert(bytes.Equal(decrypted, data), check.Equals, true)
+
+		// Truncated, or moved to another path
+		truncated := encrypted[:len(encrypted)-gcmTagSize]
+		if size == ENCRYPTION_CHUNK_SIZE {
+			c.Assert(len(truncated), check.Equals, encryptionHeaderSize+ENCRYPTION_CHUNK_SIZE+gcmTagSize)
+		}
+		r, err = enc.newDecryptReader(ioutil.NopCloser(bytes.NewReader(truncated)), "volumes/vol1/blocks/a.blk")
+		c.Assert(err, check.IsNil)
+		_, err = ioutil.ReadAll(r)
+		c.Assert(err, check.ErrorMatches, "Cannot decrypt .*wrong key or the object was corrupted or truncated")
+		r, err = enc.newDecryptReader(ioutil.NopCloser(bytes.NewReader(encrypted)), "volumes/vol1/blocks/b.blk")
+		c.Assert(err, check.IsNil)
+		_, err = ioutil.ReadAll(r)
+		c.Assert(err, check.NotNil)
+	}
+}
+
+func (s *TestSuite) TestEncryptedBackup(c *check.C) {
+	defer RemoveEncryption(memDestURL)
+
+	c.Assert(SetEncryption(memDestURL, Encryption{}), check.ErrorMatches, "Either encryption key or passphrase.*")
+	c.Assert(SetEncryption(memDestURL, Encryption{Key: []byte("short")}), check.ErrorMatches, "Invalid encryption key.*")
+	c.Assert(SetEncryption(memDestURL, Encryption{Passphrase: "correct horse battery staple"}), check.IsNil)
+
+	dir, err := ioutil.TempDir("", "objectstore-restore")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	for name, data := range s.driver.files {
+		c.Assert(bytes.HasPrefix(data, []byte(encryptionMagic)), check.Equals, true, check.Commentf("%v", name))
+		c.Assert(bytes.Contains(data, []byte("vol1")), check.Equals, false, check.Commentf("%v", name))
+	}
+
+	// Key derived from the same passphrase with another salt, e.g. after
+	// daemon restarts, would still read the backup
+	c.Assert(SetEncryption(memDestURL, Encryption{Passphrase: "correct horse battery staple"}), check.IsNil)
+	file := filepath.Join(dir, "snap1")
+	c.Assert(RestoreDeltaBlockBackup(backupURL, file), check.IsNil)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, check.IsNil)
+	expected := make([]byte, DEFAULT_BLOCK_SIZE)
+	(&memSnapshotOps{}).ReadSnapshot("snap1", "vol1", 0, expected)
+	c.Assert(bytes.Equal(data[:DEFAULT_BLOCK_SIZE], expected), check.Equals, true)
+
+	c.Assert(SetEncryption(memDestURL, Encryption{Passphrase: "wrong"}), check.IsNil)
+	_, err = GetBackupInfo(backupURL)
+	c.Assert(err, check.ErrorMatches, "Cannot decrypt .*")
+	c.Assert(SetEncryption(memDestURL, Encryption{Key: bytes.Repeat([]byte{'k'}, ENCRYPTION_KEY_SIZE)}), check.IsNil)
+	_, err = GetBackupInfo(backupURL)
+	c.Assert(err, check.ErrorMatches, "Cannot decrypt .*different kind of key")
+
+	// Objects written before encryption was enabled are refused
+	RemoveEncryption(memDestURL)
+	s.driver.files = make(map[string][]byte)
+	backupURL, err = CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	c.Assert(SetEncryption(memDestURL, Encryption{Passphrase: "correct horse battery staple"}), check.IsNil)
+	_, err = GetBackupInfo(backupURL)
+	c.Assert(err, check.ErrorMatches, ".* is not encrypted, but encryption is enabled for the destination")
+}
@@ -0,0 +1,523 @@
+package objectstore
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	FILE_TYPE_DIR      = "dir"
+	FILE_TYPE_REGULAR  = "file"
+	FILE_TYPE_SYMLINK  = "symlink"
+	FILE_TYPE_HARDLINK = "hardlink"
+)
+
+/*
+BackupFileEntry is a file of a file set backup. Data of regular files is
+stored as blocks of the backup, as if the files were laid one after another
+in a volume, each starting at a block boundary at Offset.
+*/
+type BackupFileEntry struct {
+	Path    string
+	Type    string
+	Mode    int64
+	Uid     int `json:",omitempty"`
+	Gid     int `json:",omitempty"`
+	ModTime string
+	Size    int64  `json:",omitempty"`
+	Offset  int64  `json:",omitempty"`
+	Link    string `json:",omitempty"`
+}
+
+// fileSetBlock is a block of the file set being read, waiting to be uploaded
+type fileSetBlock struct {
+	index int
+	data  []byte
+}
+
+func isFileSetBackup(backup *Backup) bool {
+	return len(backup.Files) != 0
+}
+
+/*
+getReusableFileBlocks returns blocks of entry in lastBackup, if the file has
+the same size and modification time there, or nil if it has to be read.
+Offsets are of the file in lastBackup.
+*/
+func getReusableFileBlocks(entry *BackupFileEntry, lastFiles map[string]*BackupFileEntry, lastBlocks map[int64]string, blockSize int64) []string {
+	last, exists := lastFiles[entry.Path]
+	if !exists || last.Type != FILE_TYPE_REGULAR || last.Size != entry.Size || last.ModTime != entry.ModTime {
+		return nil
+	}
+	checksums := []string{}
+	for offset := int64(0); offset < entry.Size; offset += blockSize {
+		checksum, exists := lastBlocks[last.Offset+offset]
+		if !exists {
+			return nil
+		}
+		checksums = append(checksums, checksum)
+	}
+	return checksums
+}
+
+/*
+CreateFileSetBackup backs up the files in the tar.gz archive at filePath,
+e.g. a snapshot of vfs volume, as a file set. Like rsync, files with the same
+size and modification time as in the last backup of the volume in destURL are
+taken as unchanged, and their blocks are shared with the last backup without
+being read. Other files are split into blocks, which are deduplicated against
+blocks already in destURL like delta block backups, so each backup records
+the full set of files while uploading only what changed.
+*/
+func CreateFileSetBackup(volume *Volume, snapshot *Snapshot, filePath, destURL string) (string, error) {
+	blockSize := getDefaultBlockSize()
+
+	destURL, err := ResolveDestURL(destURL, volume.Name)
+	if err != nil {
+		return "", err
+	}
+	bsDriver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return "", err
+	}
+
+	compression := getCompression(bsDriver.GetURL())
+	sharedBlocks := useBlockPool(bsDriver.GetURL())
+
+	backupName := util.GenerateName("backup")
+	inflight, err := beginBackup(backupName, volume.Name, bsDriver)
+	if err != nil {
+		return "", err
+	}
+	defer endBackup(inflight, volume.Name, bsDriver)
+
+	var poolInflight *marker
+	if sharedBlocks {
+		if err := addPool(bsDriver); err != nil {
+			return "", err
+		}
+		if poolInflight, err = beginInflight(backupName, getPoolPath(), bsDriver); err != nil {
+			return "", err
+		}
+		defer endPoolBackup(poolInflight, bsDriver)
+	}
+
+	if err := addVolume(volume, bsDriver); err != nil {
+		return "", err
+	}
+
+	volume, err = loadVolume(volume.Name, bsDriver)
+	if err != nil {
+		return "", err
+	}
+
+	lastFiles := make(map[string]*BackupFileEntry)
+	lastBlocks := make(map[int64]string)
+	if volume.LastBackupName != "" {
+		lastBackup, err := loadBackup(volume.LastBackupName, volume.Name, bsDriver)
+		if err != nil {
+			return "", err
+		}
+		if !isFileSetBackup(lastBackup) || getBackupBlockSize(lastBackup) != blockSize || lastBackup.SharedBlocks != sharedBlocks {
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON: LOG_REASON_FALLBACK,
+				LOG_FIELD_OBJECT: LOG_OBJECT_SNAPSHOT,
+				LOG_FIELD_VOLUME: volume.Name,
+			}).Debugf("Last backup %v isn't a file set backup with the same blocks, would read every file", lastBackup.Name)
+		} else {
+			for i := range lastBackup.Files {
+				lastFiles[lastBackup.Files[i].Path] = &lastBackup.Files[i]
+			}
+			for _, blk := range lastBackup.Blocks {
+				lastBlocks[blk.Offset] = blk.BlockChecksum
+			}
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: snapshot.Name,
+		LOG_FIELD_FILEPATH: filePath,
+	}).Debug("Creating backup")
+
+	backup := &Backup{
+		Name:              backupName,
+		VolumeName:        volume.Name,
+		SnapshotName:      snapshot.Name,
+		SnapshotCreatedAt: snapshot.CreatedTime,
+		Labels:            snapshot.Labels,
+		BlockSize:         blockSize,
+		Compression:       compression,
+		Size:              &BackupSize{},
+		SharedBlocks:      sharedBlocks,
+		Blocks:            []BlockMapping{},
+		Files:             []BackupFileEntry{},
+	}
+	writer := newBlockWriter(backup, inflight, poolInflight, bsDriver)
+	saved := false
+	if sharedBlocks {
+		defer func() {
+			if !saved {
+				releaseFailedBackupRefs(backupName, volume.Name, writer.newRefs, bsDriver)
+			}
+		}()
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", fmt.Errorf("Cannot read file set of %v: %v", filePath, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	// Blocks read are uploaded in batches, by as many workers as transfers
+	// allowed
+	concurrency := getTransferConcurrency()
+	pending := []fileSetBlock{}
+	free := [][]byte{}
+	flush := func() error {
+		err := runTransfers(len(pending), concurrency, func(worker, i int) error {
+			checksum, err := writer.write(pending[i].data)
+			if err != nil {
+				return err
+			}
+			backup.Blocks[pending[i].index].BlockChecksum = checksum
+			return nil
+		})
+		for _, blk := range pending {
+			free = append(free, blk.data[:cap(blk.data)])
+		}
+		pending = pending[:0]
+		return err
+	}
+
+	var offset int64
+	unchanged := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("Cannot read file set of %v: %v", filePath, err)
+		}
+		entry := BackupFileEntry{
+			Path:    path.Clean(hdr.Name),
+			Mode:    hdr.Mode,
+			Uid:     hdr.Uid,
+			Gid:     hdr.Gid,
+			ModTime: hdr.ModTime.UTC().Format(time.RFC3339Nano),
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			entry.Type = FILE_TYPE_DIR
+		case tar.TypeSymlink:
+			entry.Type = FILE_TYPE_SYMLINK
+			entry.Link = hdr.Linkname
+		case tar.TypeLink:
+			entry.Type = FILE_TYPE_HARDLINK
+			entry.Link = path.Clean(hdr.Linkname)
+		case tar.TypeReg, tar.TypeRegA:
+			entry.Type = FILE_TYPE_REGULAR
+			entry.Size = hdr.Size
+			entry.Offset = offset
+		default:
+			log.Warnf("Skip %v of unsupported type %q in file set of snapshot %v", hdr.Name, hdr.Typeflag, snapshot.Name)
+			continue
+		}
+		backup.Files = append(backup.Files, entry)
+		if entry.Type != FILE_TYPE_REGULAR {
+			continue
+		}
+
+		if checksums := getReusableFileBlocks(&entry, lastFiles, lastBlocks, blockSize); checksums != nil {
+			for i, checksum := range checksums {
+				backup.Blocks = append(backup.Blocks, BlockMapping{
+					Offset:        offset + int64(i)*blockSize,
+					BlockChecksum: checksum,
+				})
+			}
+			unchanged++
+		} else {
+			for pos := int64(0); pos < entry.Size; pos += blockSize {
+				var data []byte
+				if n := len(free); n != 0 {
+					data, free = free[n-1], free[:n-1]
+				} else {
+					data = make([]byte, blockSize)
+				}
+				if entry.Size-pos < blockSize {
+					data = data[:entry.Size-pos]
+				}
+				if _, err := io.ReadFull(tr, data); err != nil {
+					return "", fmt.Errorf("Cannot read %v in file set of %v: %v", entry.Path, filePath, err)
+				}
+				backup.Blocks = append(backup.Blocks, BlockMapping{
+					Offset: offset + pos,
+				})
+				pending = append(pending, fileSetBlock{
+					index: len(backup.Blocks) - 1,
+					data:  data,
+				})
+				if len(pending) == concurrency {
+					if err := flush(); err != nil {
+						return "", err
+					}
+				}
+			}
+		}
+		offset += (entry.Size + blockSize - 1) / blockSize * blockSize
+	}
+	if err := flush(); err != nil {
+		return "", err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: snapshot.Name,
+	}).Debugf("Created file set of %v files, %v unchanged since last backup, read %v bytes, uploaded %v blocks of %v bytes, verified %v of them",
+		len(backup.Files), unchanged, backup.Size.ChangedBytes, writer.uploaded, backup.Size.StoredBytes, writer.verified)
+
+	backup.CreatedTime = util.Now()
+	if err := saveBackup(backup, bsDriver); err != nil {
+		return "", err
+	}
+	saved = true
+
+	volume.LastBackupName = backup.Name
+	if err := saveVolume(volume, bsDriver); err != nil {
+		return "", err
+	}
+
+	return encodeBackupURL(backup.Name, volume.Name, destURL), nil
+}
+
+// IsFileSetBackup returns whether backupURL was created by
+// CreateFileSetBackup
+func IsFileSetBackup(backupURL string) (bool, error) {
+	driver, err := GetObjectStoreDriver(backupURL)
+	if err != nil {
+		return false, err
+	}
+	backupName, volumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return false, err
+	}
+	backup, err := loadBackup(backupName, volumeName, driver)
+	if err != nil {
+		return false, err
+	}
+	return isFileSetBackup(backup), nil
+}
+
+// getFileSetEntryPath returns where entry would be restored in dir. Backups
+// may be imported from archives, so paths are checked rather than trusted.
+func getFileSetEntryPath(dir, name string) (string, error) {
+	if path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("Invalid path %q in file set backup", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
+
+// underSymlink returns whether name is under one of symlinks, which files
+// cannot be restored through
+func underSymlink(name string, symlinks map[string]bool) bool {
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if symlinks[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// getFileSetEntryMode converts mode bits of tar header to os.FileMode
+func getFileSetEntryMode(mode int64) os.FileMode {
+	m := os.FileMode(mode) & os.ModePerm
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+/*
+RestoreFileSetBackup restores files of backupURL into dir, which should be
+empty. Regular files are written block by block, by as many workers as
+transfers allowed, then modes, owners and modification times are set, of
+directories after their contents.
+*/
+func RestoreFileSetBackup(backupURL, dir string) error {
+	bsDriver, err := GetObjectStoreDriver(backupURL)
+	if err != nil {
+		return err
+	}
+
+	srcBackupName, srcVolumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return err
+	}
+
+	if _, err := loadVolume(srcVolumeName, bsDriver); err != nil {
+		return generateError(logrus.Fields{
+			LOG_FIELD_VOLUME:     srcVolumeName,
+			LOG_FIELD_BACKUP_URL: backupURL,
+		}, "Volume doesn't exist in objectstore: %v", err)
+	}
+
+	backup, err := loadBackup(srcBackupName, srcVolumeName, bsDriver)
+	if err != nil {
+		return err
+	}
+	if !isFileSetBackup(backup) {
+		return fmt.Errorf("Backup %v is not a file set backup", backupURL)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:      LOG_REASON_START,
+		LOG_FIELD_EVENT:       LOG_EVENT_RESTORE,
+		LOG_FIELD_OBJECT:      LOG_FIELD_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT:    srcBackupName,
+		LOG_FIELD_ORIN_VOLUME: srcVolumeName,
+		LOG_FIELD_FILEPATH:    dir,
+		LOG_FIELD_BACKUP_URL:  backupURL,
+	}).Debug()
+
+	paths := make([]string, len(backup.Files))
+	// Regular files with data, sorted by offset, for finding files of
+	// blocks
+	dataFiles := []int{}
+	symlinks := make(map[string]bool)
+	for i, entry := range backup.Files {
+		p, err := getFileSetEntryPath(dir, entry.Path)
+		if err != nil {
+			return err
+		}
+		if underSymlink(entry.Path, symlinks) {
+			return fmt.Errorf("Invalid path %q in file set backup, it's under a symbolic link", entry.Path)
+		}
+		paths[i] = p
+		switch entry.Type {
+		case FILE_TYPE_DIR:
+			err = os.MkdirAll(p, 0700)
+		case FILE_TYPE_SYMLINK:
+			symlinks[entry.Path] = true
+			err = os.Symlink(entry.Link, p)
+		case FILE_TYPE_HARDLINK:
+			var target string
+			if target, err = getFileSetEntryPath(dir, entry.Link); err == nil {
+				err = os.Link(target, p)
+			}
+		case FILE_TYPE_REGULAR:
+			var f *os.File
+			if f, err = os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err == nil {
+				err = f.Truncate(entry.Size)
+				f.Close()
+			}
+			if entry.Size != 0 {
+				dataFiles = append(dataFiles, i)
+			}
+		default:
+			err = fmt.Errorf("Unknown type %q of %v in file set backup", entry.Type, entry.Path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	blockSize := getBackupBlockSize(backup)
+	blkCounts := len(backup.Blocks)
+	err = runTransfers(blkCounts, getTransferConcurrency(), func(worker, i int) error {
+		block := backup.Blocks[i]
+		n := sort.Search(len(dataFiles), func(j int) bool {
+			return backup.Files[dataFiles[j]].Offset > block.Offset
+		})
+		if n == 0 {
+			return fmt.Errorf("Block at %v doesn't belong to any file of file set backup", block.Offset)
+		}
+		entry := &backup.Files[dataFiles[n-1]]
+		if block.Offset >= entry.Offset+entry.Size {
+			return fmt.Errorf("Block at %v doesn't belong to any file of file set backup", block.Offset)
+		}
+		log.Debugf("Restore for %v: block %v of %v, %v/%v", dir, block.BlockChecksum, entry.Path, i+1, blkCounts)
+		data, err := readBlock(getBackupBlockFilePath(backup, block.BlockChecksum), block.BlockChecksum, bsDriver)
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) > blockSize || block.Offset+int64(len(data)) > entry.Offset+entry.Size {
+			return fmt.Errorf("Block at %v of %v exceeds the file", block.Offset, entry.Path)
+		}
+		f, err := os.OpenFile(paths[dataFiles[n-1]], os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.WriteAt(data, block.Offset-entry.Offset)
+		return err
+	})
+	if err != nil {
+		return retrieveBackup(backup, bsDriver, err)
+	}
+
+	// Entries of directories come before their contents. Owners are only
+	// restored by root, like tar.
+	chown := os.Geteuid() == 0
+	for i := len(backup.Files) - 1; i >= 0; i-- {
+		entry := &backup.Files[i]
+		if entry.Type == FILE_TYPE_HARDLINK {
+			continue
+		}
+		if chown {
+			if err := os.Lchown(paths[i], entry.Uid, entry.Gid); err != nil {
+				return err
+			}
+		}
+		if entry.Type == FILE_TYPE_SYMLINK {
+			continue
+		}
+		// Mode is set after owner, which would clear setuid bits
+		if err := os.Chmod(paths[i], getFileSetEntryMode(entry.Mode)); err != nil {
+			return err
+		}
+		modTime, err := time.Parse(time.RFC3339Nano, entry.ModTime)
+		if err != nil {
+			return fmt.Errorf("Invalid modification time %v of %v in file set backup", entry.ModTime, entry.Path)
+		}
+		if err := os.Chtimes(paths[i], modTime, modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteFileSetBackup removes backupURL, along with blocks no other backup
+// references, the same way as delta block backups
+func DeleteFileSetBackup(backupURL string) error {
+	return DeleteDeltaBlockBackup(backupURL)
+}
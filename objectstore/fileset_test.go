@@ -0,0 +1,134 @@
+package objectstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher/convoy/util"
+	"gopkg.in/check.v1"
+)
+
+// snapshotFileSet archives dir like vfs snapshots
+func snapshotFileSet(c *check.C, dir string) string {
+	file, err := ioutil.TempFile("", "objectstore-fileset")
+	c.Assert(err, check.IsNil)
+	file.Close()
+	c.Assert(util.CompressDir(dir, file.Name()), check.IsNil)
+	return file.Name()
+}
+
+func (s *TestSuite) TestFileSetBackup(c *check.C) {
+	dir, err := ioutil.TempDir("", "objectstore-fileset")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+
+	big := make([]byte, DEFAULT_BLOCK_SIZE+100)
+	for i := range big {
+		big[i] = byte(i % 251)
+	}
+	c.Assert(os.MkdirAll(filepath.Join(dir, "sub", "empty"), 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "sub", "big"), big, 0640), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "small"), []byte("small"), 0600), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "zero"), []byte{}, 0644), check.IsNil)
+	c.Assert(os.Symlink("sub/big", filepath.Join(dir, "link")), check.IsNil)
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	c.Assert(os.Chtimes(filepath.Join(dir, "sub", "big"), old, old), check.IsNil)
+	c.Assert(os.Chtimes(filepath.Join(dir, "small"), old, old), check.IsNil)
+
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "vfs",
+	}
+	snap1 := snapshotFileSet(c, dir)
+	defer os.Remove(snap1)
+	backupURL1, err := CreateFileSetBackup(volume, &Snapshot{Name: "snap1"}, snap1, memDestURL)
+	c.Assert(err, check.IsNil)
+	backup1, err := loadBackup(s.backupName(c, backupURL1), "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(isFileSetBackup(backup1), check.Equals, true)
+	c.Assert(backup1.Size.ChangedBytes, check.Equals, int64(len(big)+len("small")))
+	c.Assert(backup1.Blocks, check.HasLen, 3)
+	c.Assert(s.countBlocks(), check.Equals, 3)
+	fileSet, err := IsFileSetBackup(backupURL1)
+	c.Assert(err, check.IsNil)
+	c.Assert(fileSet, check.Equals, true)
+
+	// Only the file with different modification time is read again
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "small"), []byte("SMALL"), 0600), check.IsNil)
+	snap2 := snapshotFileSet(c, dir)
+	defer os.Remove(snap2)
+	backupURL2, err := CreateFileSetBackup(volume, &Snapshot{Name: "snap2"}, snap2, memDestURL)
+	c.Assert(err, check.IsNil)
+	backup2, err := loadBackup(s.backupName(c, backupURL2), "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup2.Size.ChangedBytes, check.Equals, int64(len("SMALL")))
+	c.Assert(backup2.Blocks, check.HasLen, 3)
+	c.Assert(s.countBlocks(), check.Equals, 4)
+
+	// Each backup restores the full set of files
+	for _, t := range []struct {
+		backupURL string
+		small     string
+	}{
+		{backupURL1, "small"},
+		{backupURL2, "SMALL"},
+	} {
+		restored, err := ioutil.TempDir("", "objectstore-fileset")
+		c.Assert(err, check.IsNil)
+		defer os.RemoveAll(restored)
+		c.Assert(RestoreFileSetBackup(t.backupURL, restored), check.IsNil)
+
+		data, err := ioutil.ReadFile(filepath.Join(restored, "sub", "big"))
+		c.Assert(err, check.IsNil)
+		c.Assert(data, check.DeepEquals, big)
+		data, err = ioutil.ReadFile(filepath.Join(restored, "small"))
+		c.Assert(err, check.IsNil)
+		c.Assert(string(data), check.Equals, t.small)
+		st, err := os.Stat(filepath.Join(restored, "zero"))
+		c.Assert(err, check.IsNil)
+		c.Assert(st.Size(), check.Equals, int64(0))
+		st, err = os.Stat(filepath.Join(restored, "sub", "big"))
+		c.Assert(err, check.IsNil)
+		c.Assert(st.Mode().Perm(), check.Equals, os.FileMode(0640))
+		c.Assert(st.ModTime().Equal(old), check.Equals, true)
+		st, err = os.Stat(filepath.Join(restored, "sub", "empty"))
+		c.Assert(err, check.IsNil)
+		c.Assert(st.IsDir(), check.Equals, true)
+		link, err := os.Readlink(filepath.Join(restored, "link"))
+		c.Assert(err, check.IsNil)
+		c.Assert(link, check.Equals, "sub/big")
+	}
+
+	// Blocks still referenced by the other backup are kept
+	c.Assert(DeleteFileSetBackup(backupURL1), check.IsNil)
+	c.Assert(s.countBlocks(), check.Equals, 3)
+	c.Assert(DeleteFileSetBackup(backupURL2), check.IsNil)
+	c.Assert(s.countBlocks(), check.Equals, 0)
+}
+
+func (s *TestSuite) TestFileSetBackupInvalidPaths(c *check.C) {
+	for _, files := range [][]BackupFileEntry{
+		{{Path: "../escape", Type: FILE_TYPE_REGULAR}},
+		{{Path: "/etc/passwd", Type: FILE_TYPE_REGULAR}},
+		{{Path: "a/../../escape", Type: FILE_TYPE_REGULAR}},
+		{
+			{Path: "link", Type: FILE_TYPE_SYMLINK, Link: "/etc"},
+			{Path: "link/passwd", Type: FILE_TYPE_REGULAR},
+		},
+	} {
+		c.Assert(addVolume(&Volume{Name: "vol1", Driver: "vfs"}, s.driver), check.IsNil)
+		c.Assert(saveBackup(&Backup{
+			Name:       "backup-1",
+			VolumeName: "vol1",
+			Files:      files,
+		}, s.driver), check.IsNil)
+
+		restored, err := ioutil.TempDir("", "objectstore-fileset")
+		c.Assert(err, check.IsNil)
+		defer os.RemoveAll(restored)
+		err = RestoreFileSetBackup(encodeBackupURL("backup-1", "vol1", memDestURL), restored)
+		c.Assert(err, check.ErrorMatches, "Invalid path .*")
+	}
+}
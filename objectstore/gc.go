@@ -0,0 +1,305 @@
+package objectstore
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	// Markers of backups in progress and garbage collections running on a
+	// volume, and blocks whose collection has been deferred
+	INFLIGHT_DIRECTORY   = "inflight"
+	GC_DIRECTORY         = "gc"
+	GC_PENDING_DIRECTORY = "gc-pending"
+)
+
+var (
+	// Markers not refreshed within the grace period were left by crashed
+	// operations, and would be ignored
+	markerGracePeriod     = time.Hour
+	markerRefreshInterval = 10 * time.Minute
+	gcWaitInterval        = 5 * time.Second
+)
+
+/*
+Blocks are shared by backups of the same volume, and a backup only references
+a block in its config, which is saved after all the blocks are found or
+uploaded. Without coordination, garbage collection after deleting a backup
+could remove a block that a backup in progress has just found existing.
+
+The two coordinate through markers in the objectstore, using the same order on
+both sides: a backup writes its marker to INFLIGHT_DIRECTORY before it looks
+for existing blocks, then waits for markers in GC_DIRECTORY to go away.
+Garbage collection writes its marker to GC_DIRECTORY, then checks
+INFLIGHT_DIRECTORY. If it finds backups in progress, it saves the blocks to be
+collected to GC_PENDING_DIRECTORY instead of removing them, and the last backup
+to complete would collect them after its config is saved. Either side would
+always see the other, as long as the objectstore lists objects right after
+they're written.
+*/
+type marker struct {
+	Name      string
+	UpdatedAt string
+
+	path        string
+	refreshedAt time.Time
+}
+
+type pendingGarbage struct {
+	Name        string
+	CreatedTime string
+	Blocks      []string
+}
+
+// Markers are kept in dir under base, which is path of the volume, or of
+// the block pool for blocks shared by volumes
+func getMarkerDir(dir, base string) string {
+	return filepath.Join(base, dir) + "/"
+}
+
+func getMarkerPath(dir, base, name string) string {
+	return filepath.Join(getMarkerDir(dir, base), name+CFG_SUFFIX)
+}
+
+func createMarker(dir, base, name string, driver ObjectStoreDriver) (*marker, error) {
+	m := &marker{
+		Name: name,
+		path: getMarkerPath(dir, base, name),
+	}
+	if err := m.refresh(driver); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *marker) refresh(driver ObjectStoreDriver) error {
+	m.refreshedAt = time.Now()
+	m.UpdatedAt = m.refreshedAt.Format(time.RFC3339Nano)
+	return saveConfigInObjectStore(m.path, driver, m)
+}
+
+// keepAlive refreshes the marker if it's due, so long running operations
+// won't be taken as crashed
+func (m *marker) keepAlive(driver ObjectStoreDriver) error {
+	if time.Since(m.refreshedAt) < markerRefreshInterval {
+		return nil
+	}
+	return m.refresh(driver)
+}
+
+func (m *marker) remove(driver ObjectStoreDriver) {
+	if err := driver.Remove(m.path); err != nil {
+		log.Warnf("Failed to remove marker %v: %v", m.path, err)
+	}
+}
+
+// listConfigNames returns names of configs in path, none if it doesn't exist.
+// Failures to list are returned, since taking them as no marker would let
+// blocks still in use be collected.
+func listConfigNames(path string, driver ObjectStoreDriver) ([]string, error) {
+	fileList, err := driver.List(path)
+	if err != nil {
+		return nil, err
+	}
+	return util.ExtractNames(fileList, "", CFG_SUFFIX)
+}
+
+// listLiveMarkers returns markers in dir under base, removing the stale ones
+func listLiveMarkers(dir, base string, driver ObjectStoreDriver) ([]string, error) {
+	names, err := listConfigNames(getMarkerDir(dir, base), driver)
+	if err != nil {
+		return nil, err
+	}
+	live := []string{}
+	for _, name := range names {
+		m := &marker{
+			path: getMarkerPath(dir, base, name),
+		}
+		if err := loadConfigInObjectStore(m.path, driver, m); err != nil {
+			if !driver.FileExists(m.path) {
+				// Removed since listed
+				continue
+			}
+			return nil, err
+		}
+		updatedAt, err := time.Parse(time.RFC3339Nano, m.UpdatedAt)
+		if err == nil && time.Since(updatedAt) > markerGracePeriod {
+			log.Warnf("Removing stale marker %v, not updated since %v", m.path, m.UpdatedAt)
+			m.remove(driver)
+			continue
+		}
+		live = append(live, name)
+	}
+	return live, nil
+}
+
+/*
+beginBackup marks the backup in progress on the volume, and waits for garbage
+collection running on the volume to complete, so blocks found existing won't
+be removed before the backup config referencing them is saved.
+*/
+func beginBackup(backupName, volumeName string, driver ObjectStoreDriver) (*marker, error) {
+	return beginInflight(backupName, getVolumePath(volumeName), driver)
+}
+
+func beginInflight(backupName, base string, driver ObjectStoreDriver) (*marker, error) {
+	m, err := createMarker(INFLIGHT_DIRECTORY, base, backupName, driver)
+	if err != nil {
+		return nil, err
+	}
+	waited := false
+	for {
+		collecting, err := listLiveMarkers(GC_DIRECTORY, base, driver)
+		if err != nil {
+			m.remove(driver)
+			return nil, err
+		}
+		if len(collecting) == 0 {
+			break
+		}
+		log.Debugf("Backup %v waiting for garbage collection %v in %v", backupName, collecting, base)
+		time.Sleep(gcWaitInterval)
+		waited = true
+	}
+	// Garbage collection may have removed the volume along with the marker
+	if waited {
+		if err := m.refresh(driver); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// endBackup clears the mark of backup in progress, and collects the garbage
+// deferred because of the backup
+func endBackup(m *marker, volumeName string, driver ObjectStoreDriver) {
+	m.remove(driver)
+
+	pendings, err := listConfigNames(getMarkerDir(GC_PENDING_DIRECTORY, getVolumePath(volumeName)), driver)
+	if err != nil || len(pendings) == 0 {
+		return
+	}
+	if err := collectGarbage(volumeName, nil, driver); err != nil {
+		log.Warnf("Failed to collect deferred garbage of volume %v: %v", volumeName, err)
+	}
+}
+
+/*
+collectGarbage removes the blocks in discardBlockSet no longer referenced by
+any backup of the volume, along with blocks deferred by previous collections.
+The volume would be removed if there is no backup left. If backups are in
+progress on the volume, the blocks would be deferred instead.
+*/
+func collectGarbage(volumeName string, discardBlockSet map[string]bool, driver ObjectStoreDriver) error {
+	if discardBlockSet == nil {
+		discardBlockSet = make(map[string]bool)
+	}
+	base := getVolumePath(volumeName)
+	gcMarker, err := createMarker(GC_DIRECTORY, base, util.GenerateName("gc"), driver)
+	if err != nil {
+		return err
+	}
+	defer gcMarker.remove(driver)
+
+	inflight, err := listLiveMarkers(INFLIGHT_DIRECTORY, base, driver)
+	if err != nil {
+		return err
+	}
+	if len(inflight) != 0 {
+		if len(discardBlockSet) == 0 {
+			return nil
+		}
+		pending := &pendingGarbage{
+			Name:        gcMarker.Name,
+			CreatedTime: util.Now(),
+			Blocks:      []string{},
+		}
+		for blk := range discardBlockSet {
+			pending.Blocks = append(pending.Blocks, blk)
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON: LOG_REASON_FALLBACK,
+			LOG_FIELD_VOLUME: volumeName,
+		}).Debugf("Deferred collection of %v blocks, backups %v in progress", len(pending.Blocks), inflight)
+		return saveConfigInObjectStore(getMarkerPath(GC_PENDING_DIRECTORY, base, pending.Name), driver, pending)
+	}
+
+	pendings, err := listConfigNames(getMarkerDir(GC_PENDING_DIRECTORY, base), driver)
+	if err != nil {
+		return err
+	}
+	for _, name := range pendings {
+		pending := &pendingGarbage{}
+		if err := loadConfigInObjectStore(getMarkerPath(GC_PENDING_DIRECTORY, base, name), driver, pending); err != nil {
+			return err
+		}
+		for _, blk := range pending.Blocks {
+			discardBlockSet[blk] = true
+		}
+	}
+
+	backupNames, err := getBackupNamesForVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	if len(backupNames) == 0 {
+		log.Debugf("No snapshot existed for the volume %v, removing volume", volumeName)
+		if err := releasePoolBlocks(volumeName, discardBlockSet, driver); err != nil {
+			return err
+		}
+		if err := removeVolume(volumeName, driver); err != nil {
+			log.Warningf("Failed to remove volume %v due to: %v", volumeName, err.Error())
+		}
+		return nil
+	}
+
+	log.Debug("GC started")
+	discardBlockCounts := len(discardBlockSet)
+	for _, backupName := range backupNames {
+		if discardBlockCounts == 0 {
+			break
+		}
+		if err := gcMarker.keepAlive(driver); err != nil {
+			return err
+		}
+		backup, err := loadBackup(backupName, volumeName, driver)
+		if err != nil {
+			return err
+		}
+		for _, blk := range backup.Blocks {
+			if _, exists := discardBlockSet[blk.BlockChecksum]; exists {
+				delete(discardBlockSet, blk.BlockChecksum)
+				discardBlockCounts--
+				if discardBlockCounts == 0 {
+					break
+				}
+			}
+		}
+	}
+
+	var blkFileList []string
+	for blk := range discardBlockSet {
+		blkFileList = append(blkFileList, getBlockFilePath(volumeName, blk))
+		log.Debugf("Found unused blocks %v for volume %v", blk, volumeName)
+	}
+	if err := driver.Remove(blkFileList...); err != nil {
+		return err
+	}
+	if err := releasePoolBlocks(volumeName, discardBlockSet, driver); err != nil {
+		return err
+	}
+	log.Debug("Removed unused blocks for volume ", volumeName)
+
+	for _, name := range pendings {
+		if err := driver.Remove(getMarkerPath(GC_PENDING_DIRECTORY, base, name)); err != nil {
+			return err
+		}
+	}
+	log.Debug("GC completed")
+	return nil
+}
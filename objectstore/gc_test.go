@@ -0,0 +1,111 @@
+package objectstore
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestGarbageCollectionDeferred(c *check.C) {
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.countBlocks(), check.Equals, 2)
+	backup, err := loadBackup(s.backupName(c, backupURL), "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+
+	// Backup in progress has found one of the blocks existing, but hasn't
+	// saved its config yet
+	inflight, err := beginBackup("backup-inflight", "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(DeleteDeltaBlockBackup(backupURL), check.IsNil)
+	c.Assert(s.countBlocks(), check.Equals, 2)
+	c.Assert(volumeExists("vol1", s.driver), check.Equals, true)
+	pendings, err := listConfigNames(getMarkerDir(GC_PENDING_DIRECTORY, getVolumePath("vol1")), s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(pendings, check.HasLen, 1)
+
+	c.Assert(saveBackup(&Backup{
+		Name:       "backup-inflight",
+		VolumeName: "vol1",
+		Blocks:     backup.Blocks[:1],
+	}, s.driver), check.IsNil)
+	endBackup(inflight, "vol1", s.driver)
+	c.Assert(s.countBlocks(), check.Equals, 1)
+	c.Assert(s.driver.FileExists(getBlockFilePath("vol1", backup.Blocks[0].BlockChecksum)), check.Equals, true)
+	pendings, err = listConfigNames(getMarkerDir(GC_PENDING_DIRECTORY, getVolumePath("vol1")), s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(pendings, check.HasLen, 0)
+
+	// Nothing in progress, the last backup takes the volume with it
+	c.Assert(DeleteDeltaBlockBackup(encodeBackupURL("backup-inflight", "vol1", memDestURL)), check.IsNil)
+	c.Assert(volumeExists("vol1", s.driver), check.Equals, false)
+	c.Assert(s.countBlocks(), check.Equals, 0)
+}
+
+func (s *TestSuite) TestBackupWaitsForGarbageCollection(c *check.C) {
+	defer func(grace, wait time.Duration) {
+		markerGracePeriod = grace
+		gcWaitInterval = wait
+	}(markerGracePeriod, gcWaitInterval)
+	markerGracePeriod = 50 * time.Millisecond
+	gcWaitInterval = 10 * time.Millisecond
+
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	// Collection never completes, backup would proceed once its marker
+	// goes stale
+	_, err := createMarker(GC_DIRECTORY, getVolumePath("vol1"), "gc-crashed", s.driver)
+	c.Assert(err, check.IsNil)
+	start := time.Now()
+	_, err = CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	c.Assert(time.Since(start) >= markerGracePeriod, check.Equals, true)
+
+	for _, dir := range []string{GC_DIRECTORY, INFLIGHT_DIRECTORY} {
+		names, err := listConfigNames(getMarkerDir(dir, getVolumePath("vol1")), s.driver)
+		c.Assert(err, check.IsNil)
+		c.Assert(names, check.HasLen, 0)
+	}
+}
+
+func (s *TestSuite) backupName(c *check.C, backupURL string) string {
+	backupName, _, err := decodeBackupURL(backupURL)
+	c.Assert(err, check.IsNil)
+	return backupName
+}
+
+func (s *TestSuite) TestGarbageCollectionListFailure(c *check.C) {
+	names, err := listConfigNames(getMarkerDir(GC_PENDING_DIRECTORY, getVolumePath("vol1")), s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.HasLen, 0)
+
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.countBlocks(), check.Equals, 2)
+	_, err = CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap2"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+
+	// Markers and backups that cannot be listed are not taken as none,
+	// otherwise blocks of the other backup would be collected
+	s.driver.listFails = true
+	_, err = listConfigNames(getMarkerDir(GC_PENDING_DIRECTORY, getVolumePath("vol1")), s.driver)
+	c.Assert(err, check.ErrorMatches, "connection reset by peer")
+	c.Assert(collectGarbage("vol1", nil, s.driver), check.NotNil)
+	c.Assert(DeleteDeltaBlockBackup(backupURL), check.NotNil)
+	s.driver.listFails = false
+	c.Assert(s.countBlocks(), check.Equals, 2)
+	c.Assert(volumeExists("vol1", s.driver), check.Equals, true)
+}
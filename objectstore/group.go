@@ -0,0 +1,199 @@
+package objectstore
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// GROUP_SCHEME is used to refer a destination group as group://<name>
+	GROUP_SCHEME = "group"
+
+	// Points of each member on the hash ring, more points spread volumes
+	// more evenly
+	GROUP_RING_POINTS = 128
+)
+
+/*
+destinationGroup shards volumes across multiple destinations, e.g. buckets or
+prefixes, by consistent hashing of volume names. Every volume is always backed
+up to the same member, and adding or removing a member only moves the volumes
+of its share of the ring.
+*/
+type destinationGroup struct {
+	Name    string
+	Members []string
+
+	ring  []uint32
+	owner map[uint32]string
+}
+
+var (
+	groups      = make(map[string]*destinationGroup)
+	groupsMutex = &sync.RWMutex{}
+)
+
+func hashRingKey(key string) uint32 {
+	sum := sha1.Sum([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func newDestinationGroup(name string, members []string) (*destinationGroup, error) {
+	if name == "" {
+		return nil, fmt.Errorf("Invalid empty destination group name")
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("Destination group %v has no member", name)
+	}
+	g := &destinationGroup{
+		Name:  name,
+		ring:  []uint32{},
+		owner: make(map[uint32]string),
+	}
+	for _, member := range members {
+		if isGroupURL(member) {
+			return nil, fmt.Errorf("Destination group %v cannot contain another group %v", name, member)
+		}
+		if _, err := GetObjectStoreDriver(member); err != nil {
+			return nil, fmt.Errorf("Invalid member %v of destination group %v: %v", member, name, err)
+		}
+		for _, m := range g.Members {
+			if m == member {
+				return nil, fmt.Errorf("Duplicate member %v of destination group %v", member, name)
+			}
+		}
+		g.Members = append(g.Members, member)
+		for i := 0; i < GROUP_RING_POINTS; i++ {
+			point := hashRingKey(member + "#" + strconv.Itoa(i))
+			// Collision is unlikely, keep the first owner so result
+			// won't depend on the order of members
+			if _, exists := g.owner[point]; exists {
+				continue
+			}
+			g.owner[point] = member
+			g.ring = append(g.ring, point)
+		}
+	}
+	sort.Sort(uint32Slice(g.ring))
+	return g, nil
+}
+
+// locate returns the member owning the first point clockwise from the hash of
+// volumeName
+func (g *destinationGroup) locate(volumeName string) string {
+	key := hashRingKey(volumeName)
+	i := sort.Search(len(g.ring), func(i int) bool {
+		return g.ring[i] >= key
+	})
+	if i == len(g.ring) {
+		i = 0
+	}
+	return g.owner[g.ring[i]]
+}
+
+type uint32Slice []uint32
+
+func (s uint32Slice) Len() int           { return len(s) }
+func (s uint32Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint32Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+/*
+ParseDestinationGroup parses spec in the form of "<name>=<url>[,<url>...]" and
+registers the group, so "group://<name>" can be used as destination URL. An
+existing group of the same name would be replaced.
+*/
+func ParseDestinationGroup(spec string) error {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("Invalid destination group %q, should be <name>=<url>[,<url>...]", spec)
+	}
+	members := []string{}
+	for _, member := range strings.Split(parts[1], ",") {
+		if member = strings.TrimSpace(member); member != "" {
+			members = append(members, member)
+		}
+	}
+	return SetDestinationGroup(strings.TrimSpace(parts[0]), members)
+}
+
+// SetDestinationGroup registers the group of members as name
+func SetDestinationGroup(name string, members []string) error {
+	g, err := newDestinationGroup(name, members)
+	if err != nil {
+		return err
+	}
+
+	groupsMutex.Lock()
+	defer groupsMutex.Unlock()
+
+	groups[name] = g
+	return nil
+}
+
+// RemoveDestinationGroup unregisters group name
+func RemoveDestinationGroup(name string) {
+	groupsMutex.Lock()
+	defer groupsMutex.Unlock()
+
+	delete(groups, name)
+}
+
+func isGroupURL(destURL string) bool {
+	return strings.HasPrefix(destURL, GROUP_SCHEME+"://")
+}
+
+func getDestinationGroup(destURL string) (*destinationGroup, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	groupsMutex.RLock()
+	defer groupsMutex.RUnlock()
+
+	g, exists := groups[u.Host]
+	if !exists {
+		return nil, fmt.Errorf("Destination group %v doesn't exist", u.Host)
+	}
+	return g, nil
+}
+
+/*
+ResolveDestURL returns the member of destination group that volumeName is
+sharded to, if destURL refers a group. Otherwise destURL is returned as it is.
+*/
+func ResolveDestURL(destURL, volumeName string) (string, error) {
+	if !isGroupURL(destURL) {
+		return destURL, nil
+	}
+	if volumeName == "" {
+		return "", fmt.Errorf("Volume name is required to locate destination in group %v", destURL)
+	}
+	g, err := getDestinationGroup(destURL)
+	if err != nil {
+		return "", err
+	}
+	return g.locate(volumeName), nil
+}
+
+/*
+expandDestURL returns all members of destination group destURL refers, or
+destURL itself if it's not a group. Listing needs all of them since volumes
+could have been moved to another member when the group changed.
+*/
+func expandDestURL(destURL string) ([]string, error) {
+	if !isGroupURL(destURL) {
+		return []string{destURL}, nil
+	}
+	g, err := getDestinationGroup(destURL)
+	if err != nil {
+		return nil, err
+	}
+	return g.Members, nil
+}
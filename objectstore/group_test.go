@@ -0,0 +1,66 @@
+package objectstore
+
+import (
+	"fmt"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestDestinationGroup(c *check.C) {
+	members := []string{"mem:///shard0", "mem:///shard1", "mem:///shard2"}
+	c.Assert(ParseDestinationGroup("fleet="+members[0]+", "+members[1]+","+members[2]), check.IsNil)
+	defer RemoveDestinationGroup("fleet")
+
+	c.Assert(ParseDestinationGroup("fleet"), check.ErrorMatches, "Invalid destination group.*")
+	c.Assert(ParseDestinationGroup("empty="), check.ErrorMatches, "Destination group empty has no member")
+	c.Assert(ParseDestinationGroup("nested=group://fleet"), check.ErrorMatches, ".*cannot contain another group.*")
+	c.Assert(ParseDestinationGroup("bad=blob://bucket"), check.ErrorMatches, "Invalid member blob://bucket.*")
+	c.Assert(ParseDestinationGroup("dup=mem:///a,mem:///a"), check.ErrorMatches, "Duplicate member.*")
+
+	destURL, err := ResolveDestURL(memDestURL, "vol")
+	c.Assert(err, check.IsNil)
+	c.Assert(destURL, check.Equals, memDestURL)
+	_, err = ResolveDestURL("group://unknown", "vol")
+	c.Assert(err, check.ErrorMatches, "Destination group unknown doesn't exist")
+	_, err = ResolveDestURL("group://fleet", "")
+	c.Assert(err, check.ErrorMatches, "Volume name is required.*")
+
+	located := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		volumeName := fmt.Sprintf("volume-%d", i)
+		destURL, err := ResolveDestURL("group://fleet", volumeName)
+		c.Assert(err, check.IsNil)
+		located[volumeName] = destURL
+		counts[destURL]++
+	}
+	c.Assert(counts, check.HasLen, len(members))
+	for _, member := range members {
+		c.Assert(counts[member] > 500, check.Equals, true)
+	}
+
+	// Order of members doesn't matter
+	c.Assert(SetDestinationGroup("fleet", []string{members[2], members[0], members[1]}), check.IsNil)
+	for volumeName, destURL := range located {
+		resolved, err := ResolveDestURL("group://fleet", volumeName)
+		c.Assert(err, check.IsNil)
+		c.Assert(resolved, check.Equals, destURL)
+	}
+
+	// Volumes only move to the new member
+	c.Assert(SetDestinationGroup("fleet", append(members, "mem:///shard3")), check.IsNil)
+	moved := 0
+	for volumeName, destURL := range located {
+		resolved, err := ResolveDestURL("group://fleet", volumeName)
+		c.Assert(err, check.IsNil)
+		if resolved != destURL {
+			c.Assert(resolved, check.Equals, "mem:///shard3")
+			moved++
+		}
+	}
+	c.Assert(moved > 0 && moved < 1500, check.Equals, true)
+
+	destURLs, err := expandDestURL("group://fleet")
+	c.Assert(err, check.IsNil)
+	c.Assert(destURLs, check.HasLen, 4)
+}
@@ -0,0 +1,396 @@
+package objectstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	INDEX_CFG_PREFIX = "index_"
+	INDEX_CFG_SUFFIX = ".json"
+
+	INDEX_REFRESH_INTERVAL = 5 * time.Minute
+)
+
+var (
+	indexDir   string
+	indexMutex = &sync.Mutex{}
+)
+
+/*
+backupIndex is the local copy of volume and backup configs of one
+destination, so listing and inspecting backups won't need to read every
+backup config in objectstore. It's refreshed from the catalog of the
+destination, or on demand by listing the names in objectstore and only
+loading backup configs not seen before. Volume configs are loaded on every
+refresh, since they may change while their backups don't.
+*/
+type backupIndex struct {
+	DestURL       string
+	LastRefreshed string
+	Volumes       map[string]*indexedVolume
+
+	dir string
+}
+
+type indexedVolume struct {
+	Volume  Volume
+	Backups map[string]*Backup
+}
+
+func (idx *backupIndex) ConfigFile() (string, error) {
+	if idx.DestURL == "" {
+		return "", fmt.Errorf("BUG: Invalid empty index destination")
+	}
+	if idx.dir == "" {
+		return "", fmt.Errorf("BUG: Invalid empty index directory")
+	}
+	checksum := sha256.Sum256([]byte(idx.DestURL))
+	return filepath.Join(idx.dir, INDEX_CFG_PREFIX+hex.EncodeToString(checksum[:])+INDEX_CFG_SUFFIX), nil
+}
+
+// SetIndexDir enables local backup index in dir. Index is disabled if dir is
+// empty.
+func SetIndexDir(dir string) error {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+
+	if dir != "" {
+		if err := util.MkdirIfNotExists(dir); err != nil {
+			return err
+		}
+	}
+	indexDir = dir
+	return nil
+}
+
+// loadIndex returns nil if index is disabled, caller should hold indexMutex
+func loadIndex(driver ObjectStoreDriver) (*backupIndex, error) {
+	if indexDir == "" {
+		return nil, nil
+	}
+	idx := &backupIndex{
+		DestURL: driver.GetURL(),
+		Volumes: make(map[string]*indexedVolume),
+		dir:     indexDir,
+	}
+	if err := util.ObjectLoad(idx); err != nil && !util.IsNotExistsError(err) {
+		return nil, err
+	}
+	if idx.Volumes == nil {
+		idx.Volumes = make(map[string]*indexedVolume)
+	}
+	return idx, nil
+}
+
+func (idx *backupIndex) isStale() bool {
+	lastRefreshed, err := time.Parse(time.RubyDate, idx.LastRefreshed)
+	if err != nil {
+		return true
+	}
+	return time.Since(lastRefreshed) > INDEX_REFRESH_INTERVAL
+}
+
+// indexedBackup strips block mappings, which aren't needed for listing and
+// would make index as large as the backups' configs
+func indexedBackup(backup *Backup) *Backup {
+	b := *backup
+	b.Blocks = nil
+	return &b
+}
+
+// walk refreshes index by walking every volume in objectstore
+func (idx *backupIndex) walk(driver ObjectStoreDriver) error {
+	volumeNames, err := getVolumeNames(driver)
+	if err != nil {
+		return err
+	}
+	found := make(map[string]bool)
+	for _, volumeName := range volumeNames {
+		// Short volume names are padded with '!' in objectstore
+		volumeName = strings.TrimRight(volumeName, "!")
+		found[volumeName] = true
+		if err := idx.refreshVolume(volumeName, driver); err != nil {
+			return err
+		}
+	}
+	for volumeName := range idx.Volumes {
+		if !found[volumeName] {
+			delete(idx.Volumes, volumeName)
+		}
+	}
+	return nil
+}
+
+func (idx *backupIndex) refreshVolume(volumeName string, driver ObjectStoreDriver) error {
+	backupNames, err := getBackupNamesForVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	volume, err := loadVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	vol, exists := idx.Volumes[volumeName]
+	if !exists {
+		vol = &indexedVolume{
+			Backups: make(map[string]*Backup),
+		}
+		idx.Volumes[volumeName] = vol
+	}
+	vol.Volume = *volume
+	found := make(map[string]bool)
+	for _, backupName := range backupNames {
+		found[backupName] = true
+		if _, exists := vol.Backups[backupName]; exists {
+			continue
+		}
+		backup, err := loadBackup(backupName, volumeName, driver)
+		if err != nil {
+			return err
+		}
+		vol.Backups[backupName] = indexedBackup(backup)
+	}
+	for backupName := range vol.Backups {
+		if !found[backupName] {
+			delete(vol.Backups, backupName)
+		}
+	}
+	return nil
+}
+
+func (idx *backupIndex) list(volumeName, storageDriverName string) (map[string]map[string]string, error) {
+	if _, exists := idx.Volumes[volumeName]; volumeName != "" && !exists {
+		return nil, fmt.Errorf("Cannot find volume %v in %v", volumeName, idx.DestURL)
+	}
+	resp := make(map[string]map[string]string)
+	for name, vol := range idx.Volumes {
+		if volumeName != "" && name != volumeName {
+			continue
+		}
+		//Skip any volumes not owned by specified storage driver
+		if vol.Volume.Driver != storageDriverName {
+			continue
+		}
+		for _, backup := range vol.Backups {
+			r := fillBackupInfo(backup, &vol.Volume, idx.DestURL)
+			resp[r["BackupURL"]] = r
+		}
+	}
+	return resp, nil
+}
+
+// listFromIndex returns nil if index is disabled
+func listFromIndex(volumeName string, driver ObjectStoreDriver, storageDriverName string) (map[string]map[string]string, error) {
+	indexMutex.Lock()
+	idx, err := loadIndex(driver)
+	indexMutex.Unlock()
+	if err != nil || idx == nil {
+		return nil, err
+	}
+	if idx.isStale() {
+		// Catalog is read without holding index of every destination
+		cat, err := readCatalog(driver)
+		if err != nil {
+			return nil, err
+		}
+		if idx, err = saveRefreshedIndex(driver, cat.Volumes); err != nil {
+			return nil, err
+		}
+	}
+	return idx.list(volumeName, storageDriverName)
+}
+
+// saveRefreshedIndex replaces volumes in index with the ones refreshed from
+// objectstore
+func saveRefreshedIndex(driver ObjectStoreDriver, volumes map[string]*indexedVolume) (*backupIndex, error) {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+
+	idx, err := loadIndex(driver)
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil {
+		return nil, fmt.Errorf("Backup index is not enabled")
+	}
+	idx.Volumes = volumes
+	idx.LastRefreshed = util.Now()
+	if err := util.ObjectSave(idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// getBackupInfoFromIndex returns nil if backup isn't in index, or index is
+// stale
+func getBackupInfoFromIndex(backupName, volumeName string, driver ObjectStoreDriver) (map[string]string, error) {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+
+	idx, err := loadIndex(driver)
+	if err != nil || idx == nil || idx.isStale() {
+		return nil, err
+	}
+	vol, exists := idx.Volumes[volumeName]
+	if !exists {
+		return nil, nil
+	}
+	backup, exists := vol.Backups[backupName]
+	if !exists {
+		return nil, nil
+	}
+	return fillBackupInfo(backup, &vol.Volume, idx.DestURL), nil
+}
+
+// updateIndex applies changes made by this host to existing index, so they're
+// visible without waiting for next refresh
+func updateIndex(driver ObjectStoreDriver, update func(idx *backupIndex) error) {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+
+	idx, err := loadIndex(driver)
+	if err != nil {
+		log.Warnf("Failed to load backup index of %v: %v", driver.GetURL(), err)
+		return
+	}
+	if idx == nil || idx.LastRefreshed == "" {
+		return
+	}
+	err = update(idx)
+	if err == nil {
+		err = util.ObjectSave(idx)
+	}
+	if err != nil {
+		log.Warnf("Failed to update backup index of %v, would refresh it: %v", driver.GetURL(), err)
+		idx.LastRefreshed = ""
+		util.ObjectSave(idx)
+	}
+}
+
+func indexAddBackup(backup *Backup, driver ObjectStoreDriver) {
+	indexMutex.Lock()
+	enabled := indexDir != ""
+	indexMutex.Unlock()
+	if !enabled {
+		return
+	}
+	// Volume is loaded without holding index of every destination
+	volume, err := loadVolume(backup.VolumeName, driver)
+	updateIndex(driver, func(idx *backupIndex) error {
+		if err != nil {
+			return err
+		}
+		vol, exists := idx.Volumes[backup.VolumeName]
+		if !exists {
+			vol = &indexedVolume{
+				Backups: make(map[string]*Backup),
+			}
+			idx.Volumes[backup.VolumeName] = vol
+		}
+		vol.Volume = *volume
+		vol.Backups[backup.Name] = indexedBackup(backup)
+		return nil
+	})
+}
+
+func indexRemoveBackup(backup *Backup, driver ObjectStoreDriver) {
+	updateIndex(driver, func(idx *backupIndex) error {
+		if vol, exists := idx.Volumes[backup.VolumeName]; exists {
+			delete(vol.Backups, backup.Name)
+		}
+		return nil
+	})
+}
+
+func indexRemoveVolume(volumeName string, driver ObjectStoreDriver) {
+	updateIndex(driver, func(idx *backupIndex) error {
+		delete(idx.Volumes, volumeName)
+		return nil
+	})
+}
+
+/*
+RefreshIndex updates local backup index of destURL with backups added or
+removed in objectstore since last refresh, by walking every volume rather than
+trusting the catalog of destURL, which is saved from the walk as well. If
+rebuild is true, index would be discarded and built again by loading every
+backup config. Indexes of all members would be refreshed if destURL refers a
+destination group.
+*/
+func RefreshIndex(destURL string, rebuild bool) (map[string]string, error) {
+	if !isGroupURL(destURL) {
+		return refreshIndex(destURL, rebuild)
+	}
+	destURLs, err := expandDestURL(destURL)
+	if err != nil {
+		return nil, err
+	}
+	volumeCount, backupCount := 0, 0
+	for _, u := range destURLs {
+		info, err := refreshIndex(u, rebuild)
+		if err != nil {
+			return nil, err
+		}
+		volumes, _ := strconv.Atoi(info["Volumes"])
+		backups, _ := strconv.Atoi(info["Backups"])
+		volumeCount += volumes
+		backupCount += backups
+	}
+	return map[string]string{
+		"DestURL":       destURL,
+		"Members":       strings.Join(destURLs, ","),
+		"Volumes":       strconv.Itoa(volumeCount),
+		"Backups":       strconv.Itoa(backupCount),
+		"LastRefreshed": util.Now(),
+	}, nil
+}
+
+func refreshIndex(destURL string, rebuild bool) (map[string]string, error) {
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	indexMutex.Lock()
+	idx, err := loadIndex(driver)
+	indexMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil {
+		return nil, fmt.Errorf("Backup index is not enabled")
+	}
+	if rebuild {
+		idx.Volumes = make(map[string]*indexedVolume)
+	}
+	// Destination is walked without holding index of every destination
+	catalogMutex.Lock()
+	cat, err := walkCatalog(idx.Volumes, driver)
+	catalogMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if idx, err = saveRefreshedIndex(driver, cat.Volumes); err != nil {
+		return nil, err
+	}
+
+	backupCount := 0
+	for _, vol := range idx.Volumes {
+		backupCount += len(vol.Backups)
+	}
+	return map[string]string{
+		"DestURL":       idx.DestURL,
+		"Volumes":       strconv.Itoa(len(idx.Volumes)),
+		"Backups":       strconv.Itoa(backupCount),
+		"LastRefreshed": idx.LastRefreshed,
+	}, nil
+}
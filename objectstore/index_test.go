@@ -0,0 +1,390 @@
+package objectstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/convoy/util"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type TestSuite struct {
+	indexDir string
+	driver   *memObjectStoreDriver
+	// Drivers of other destinations, by their URLs
+	dests map[string]*memObjectStoreDriver
+}
+
+var _ = check.Suite(&TestSuite{})
+
+const (
+	memKind    = "mem"
+	memDestURL = "mem:///backups"
+)
+
+// memObjectStoreDriver keeps files in memory, counting reads to verify what
+// has been loaded from objectstore. Blocks written would be corrupted if
+// corruptBlocks is set, writes would fail if unavailable is set, and lists
+// would fail if listFails is set. Like S3, paths without files are empty.
+// It's safe for concurrent transfers of blocks.
+type memObjectStoreDriver struct {
+	url           string
+	mutex         sync.Mutex
+	files         map[string][]byte
+	reads         int
+	corruptBlocks bool
+	unavailable   bool
+	listFails     bool
+	lockPeriod    time.Duration
+	// Archived files cannot be read until they're retrieved
+	archived   map[string]bool
+	retrievals int
+}
+
+func (m *memObjectStoreDriver) Kind() string {
+	return memKind
+}
+
+func (m *memObjectStoreDriver) GetURL() string {
+	if m.url != "" {
+		return m.url
+	}
+	return memDestURL
+}
+
+func (m *memObjectStoreDriver) GetLockPeriod() time.Duration {
+	return m.lockPeriod
+}
+
+func (m *memObjectStoreDriver) FileExists(filePath string) bool {
+	return m.FileSize(filePath) >= 0
+}
+
+func (m *memObjectStoreDriver) FileSize(filePath string) int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	data, exists := m.files[filepath.Clean(filePath)]
+	if !exists {
+		return -1
+	}
+	return int64(len(data))
+}
+
+func (m *memObjectStoreDriver) Remove(names ...string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, name := range names {
+		name = filepath.Clean(name)
+		for file := range m.files {
+			if file == name || strings.HasPrefix(file, name+"/") {
+				delete(m.files, file)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	data, exists := m.files[filepath.Clean(src)]
+	if !exists {
+		return nil, fmt.Errorf("%v doesn't exist", src)
+	}
+	if m.archived[filepath.Clean(src)] {
+		return nil, &RetrievalRequiredError{Path: src}
+	}
+	m.reads++
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	if m.unavailable {
+		return fmt.Errorf("connection refused")
+	}
+	data, err := ioutil.ReadAll(rs)
+	if err != nil {
+		return err
+	}
+	if m.corruptBlocks && strings.HasSuffix(dst, ".blk") {
+		data[len(data)-1] ^= 0xff
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.files[filepath.Clean(dst)] = data
+	return nil
+}
+
+func (m *memObjectStoreDriver) List(path string) ([]string, error) {
+	if m.listFails {
+		return nil, fmt.Errorf("connection reset by peer")
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	path = filepath.Clean(path) + "/"
+	names := []string{}
+	seen := make(map[string]bool)
+	for file := range m.files {
+		if !strings.HasPrefix(file, path) {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(file, path), "/", 2)[0]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (m *memObjectStoreDriver) Upload(src, dst string) error {
+	return fmt.Errorf("Not supported")
+}
+
+func (m *memObjectStoreDriver) RetrieveObject(path string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.archived[filepath.Clean(path)] {
+		return true, nil
+	}
+	m.retrievals++
+	return false, nil
+}
+
+func (m *memObjectStoreDriver) Download(src, dst string) error {
+	return fmt.Errorf("Not supported")
+}
+
+func (s *TestSuite) SetUpSuite(c *check.C) {
+	err := RegisterDriver(memKind, func(destURL string) (ObjectStoreDriver, error) {
+		u, err := url.Parse(destURL)
+		if err != nil {
+			return nil, err
+		}
+		if driver, ok := s.dests[u.Scheme+"://"+u.Path]; ok {
+			return driver, nil
+		}
+		return s.driver, nil
+	})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *TestSuite) SetUpTest(c *check.C) {
+	var err error
+	s.driver = &memObjectStoreDriver{
+		files: make(map[string][]byte),
+	}
+	s.dests = make(map[string]*memObjectStoreDriver)
+	s.indexDir, err = ioutil.TempDir("", "objectstore-index")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *TestSuite) TearDownTest(c *check.C) {
+	c.Assert(SetIndexDir(""), check.IsNil)
+	c.Assert(os.RemoveAll(s.indexDir), check.IsNil)
+}
+
+// addDest adds another destination at destURL, separated from memDestURL
+func (s *TestSuite) addDest(destURL string) *memObjectStoreDriver {
+	s.dests[destURL] = &memObjectStoreDriver{
+		url:   destURL,
+		files: make(map[string][]byte),
+	}
+	return s.dests[destURL]
+}
+
+func (s *TestSuite) addBackup(c *check.C, volumeName, backupName string) {
+	volume := &Volume{
+		Name:   volumeName,
+		Driver: "vfs",
+		Size:   1024,
+	}
+	c.Assert(addVolume(volume, s.driver), check.IsNil)
+	c.Assert(saveBackup(&Backup{
+		Name:       backupName,
+		Driver:     "vfs",
+		VolumeName: volumeName,
+		Blocks:     []BlockMapping{{Offset: 0, BlockChecksum: "checksum"}},
+	}, s.driver), check.IsNil)
+}
+
+func (s *TestSuite) TestIndexList(c *check.C) {
+	s.addBackup(c, "vol1", "backup1")
+	s.addBackup(c, "vol1", "backup2")
+	s.addBackup(c, "v2", "backup3")
+
+	expected, err := List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(expected, check.HasLen, 3)
+
+	c.Assert(SetIndexDir(s.indexDir), check.IsNil)
+	resp, err := List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.DeepEquals, expected)
+
+	// Served from index without reading objectstore
+	s.driver.reads = 0
+	resp, err = List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.DeepEquals, expected)
+	resp, err = List("v2", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, 1)
+	resp, err = List("", memDestURL, "devicemapper")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, 0)
+	backupURL := encodeBackupURL("backup1", "vol1", memDestURL)
+	info, err := GetBackupInfo(backupURL)
+	c.Assert(err, check.IsNil)
+	c.Assert(info, check.DeepEquals, expected[backupURL])
+	c.Assert(s.driver.reads, check.Equals, 0)
+
+	// Changes made by this host are applied to index
+	s.addBackup(c, "vol3", "backup4")
+	backup, err := loadBackup("backup1", "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(removeBackup(backup, s.driver), check.IsNil)
+	c.Assert(removeVolume("v2", s.driver), check.IsNil)
+	resp, err = List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, 2)
+	c.Assert(resp[encodeBackupURL("backup2", "vol1", memDestURL)], check.NotNil)
+	c.Assert(resp[encodeBackupURL("backup4", "vol3", memDestURL)], check.NotNil)
+
+	_, err = List("v2", memDestURL, "vfs")
+	c.Assert(err, check.ErrorMatches, "Cannot find volume v2 in "+memDestURL)
+}
+
+func (s *TestSuite) TestIndexVolumeChanged(c *check.C) {
+	s.addBackup(c, "vol1", "backup1")
+	c.Assert(SetIndexDir(s.indexDir), check.IsNil)
+	_, err := RefreshIndex(memDestURL, false)
+	c.Assert(err, check.IsNil)
+
+	// Volume config changed by other hosts is picked up by the next refresh
+	volume, err := loadVolume("vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	volume.Size = 2048
+	c.Assert(saveVolume(volume, s.driver), check.IsNil)
+	_, err = RefreshIndex(memDestURL, false)
+	c.Assert(err, check.IsNil)
+	resp, err := List("vol1", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp[encodeBackupURL("backup1", "vol1", memDestURL)]["VolumeSize"], check.Equals, "2048")
+
+	// Stale index isn't used for inspecting backups
+	idx, err := loadIndex(s.driver)
+	c.Assert(err, check.IsNil)
+	idx.LastRefreshed = time.Now().Add(-INDEX_REFRESH_INTERVAL - time.Minute).Format(time.RubyDate)
+	c.Assert(util.ObjectSave(idx), check.IsNil)
+	volume.Size = 4096
+	c.Assert(saveVolume(volume, s.driver), check.IsNil)
+	info, err := GetBackupInfo(encodeBackupURL("backup1", "vol1", memDestURL))
+	c.Assert(err, check.IsNil)
+	c.Assert(info["VolumeSize"], check.Equals, "4096")
+}
+
+func (s *TestSuite) TestBackupNotFound(c *check.C) {
+	s.addBackup(c, "vol1", "backup1")
+
+	_, err := GetBackupInfo(encodeBackupURL("backup1", "vol1", memDestURL))
+	c.Assert(err, check.IsNil)
+	_, err = GetBackupInfo(encodeBackupURL("backup2", "vol1", memDestURL))
+	c.Assert(IsNotFound(err), check.Equals, true)
+	_, err = GetBackupInfo(encodeBackupURL("backup1", "vol2", memDestURL))
+	c.Assert(IsNotFound(err), check.Equals, true)
+	_, err = GetBackupInfo(memDestURL)
+	c.Assert(err, check.NotNil)
+	c.Assert(IsNotFound(err), check.Equals, false)
+}
+
+func (s *TestSuite) TestIndexRefresh(c *check.C) {
+	s.addBackup(c, "vol1", "backup1")
+	s.addBackup(c, "vol1", "backup2")
+
+	_, err := RefreshIndex(memDestURL, false)
+	c.Assert(err, check.ErrorMatches, "Backup index is not enabled")
+
+	c.Assert(SetIndexDir(s.indexDir), check.IsNil)
+	info, err := RefreshIndex(memDestURL, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["Volumes"], check.Equals, "1")
+	c.Assert(info["Backups"], check.Equals, "2")
+
+	// Changes made by other hosts, bypassing index
+	c.Assert(SetIndexDir(""), check.IsNil)
+	s.addBackup(c, "vol1", "backup3")
+	s.addBackup(c, "vol2", "backup4")
+	backup, err := loadBackup("backup1", "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(removeBackup(backup, s.driver), check.IsNil)
+	c.Assert(SetIndexDir(s.indexDir), check.IsNil)
+
+	// Only backup configs not in index are loaded, along with volume
+	// configs
+	s.driver.reads = 0
+	info, err = RefreshIndex(memDestURL, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["Volumes"], check.Equals, "2")
+	c.Assert(info["Backups"], check.Equals, "3")
+	c.Assert(s.driver.reads, check.Equals, 4)
+
+	resp, err := List("", memDestURL, "vfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp, check.HasLen, 3)
+	c.Assert(resp[encodeBackupURL("backup1", "vol1", memDestURL)], check.IsNil)
+
+	s.driver.reads = 0
+	info, err = RefreshIndex(memDestURL, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["Backups"], check.Equals, "3")
+	c.Assert(s.driver.reads, check.Equals, 5)
+}
+
+func (s *TestSuite) TestBackupLabels(c *check.C) {
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	labels := map[string]string{"release": "v1.2", "tier": ""}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1", Labels: labels}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(s.backupName(c, backupURL), "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.Labels, check.DeepEquals, labels)
+
+	// Labels are in info, listed from index as well
+	c.Assert(SetIndexDir(s.indexDir), check.IsNil)
+	resp, err := List("", memDestURL, "loop")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp[backupURL][BACKUP_INFO_LABEL_PREFIX+"release"], check.Equals, "v1.2")
+	info, ok := resp[backupURL][BACKUP_INFO_LABEL_PREFIX+"tier"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(info, check.Equals, "")
+
+	// Replicas keep labels of the backup
+	dr := s.addDest(drDestURL)
+	replicaURL, err := ReplicateBackup(backupURL, drDestURL)
+	c.Assert(err, check.IsNil)
+	replica, err := loadBackup(s.backupName(c, replicaURL), "vol1", dr)
+	c.Assert(err, check.IsNil)
+	c.Assert(replica.Labels, check.DeepEquals, labels)
+}
@@ -0,0 +1,215 @@
+package objectstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+func getKeyringFilePath() string {
+	return filepath.Join(OBJECTSTORE_BASE, ENCRYPTION_KEYRING_FILE)
+}
+
+// wrappingKey returns the key which keys in keyring of salt are sealed with
+func (e *encryption) wrappingKey(salt []byte) []byte {
+	if e.kdf == encryptionKDFKey {
+		return e.key
+	}
+	return pbkdf2([]byte(e.passphrase), salt, ENCRYPTION_PBKDF2_ITERATIONS)
+}
+
+// secret returns the key, or the passphrase it's derived from
+func (e *encryption) secret() []byte {
+	if e.kdf == encryptionKDFKey {
+		return e.key
+	}
+	return []byte(e.passphrase)
+}
+
+func keyringCheck(wrappingKey []byte) []byte {
+	mac := hmac.New(sha256.New, wrappingKey)
+	mac.Write([]byte(encryptionCheck))
+	return mac.Sum(nil)
+}
+
+// sealKey seals the key of version with wrappingKey, along with a salt of
+// its own, so no nonce is reused
+func sealKey(wrappingKey []byte, version int, secret []byte) ([]byte, error) {
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newObjectCipher(wrappingKey, salt)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(salt, chunkNonce(aead, 0, true), secret, []byte(strconv.Itoa(version))), nil
+}
+
+func openKey(wrappingKey []byte, version int, sealed []byte) ([]byte, error) {
+	if len(sealed) < encryptionSaltSize {
+		return nil, fmt.Errorf("Invalid key version %v in keyring", version)
+	}
+	aead, err := newObjectCipher(wrappingKey, sealed[:encryptionSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	secret, err := aead.Open(nil, chunkNonce(aead, 0, true), sealed[encryptionSaltSize:], []byte(strconv.Itoa(version)))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unwrap key version %v in keyring: %v", version, err)
+	}
+	return secret, nil
+}
+
+/*
+loadKeyring loads the version of the key and keys of earlier versions from
+keyring of the destination, through driver which doesn't encrypt. It's only
+loaded once, and destinations without keyring have never been rotated. Key
+not matching the keyring is refused, so objects won't be written with a key
+which has been rotated from.
+*/
+func (e *encryption) loadKeyring(driver ObjectStoreDriver) error {
+	e.keyringMutex.Lock()
+	defer e.keyringMutex.Unlock()
+
+	if e.keyringLoaded {
+		return nil
+	}
+	if !driver.FileExists(getKeyringFilePath()) {
+		e.keyringLoaded = true
+		return nil
+	}
+	keyring := &encryptionKeyring{}
+	if err := loadConfigInObjectStore(getKeyringFilePath(), driver, keyring); err != nil {
+		return err
+	}
+	wrappingKey := e.wrappingKey(keyring.Salt)
+	if !hmac.Equal(keyringCheck(wrappingKey), keyring.Check) {
+		return fmt.Errorf("Encryption key of %v is not key version %v in its keyring, it may have been rotated",
+			driver.GetURL(), keyring.Version)
+	}
+	previous := make(map[int]*encryption)
+	for _, wrapped := range keyring.Keys {
+		secret, err := openKey(wrappingKey, wrapped.Version, wrapped.Secret)
+		if err != nil {
+			return err
+		}
+		enc, err := newEncryptionFromSecret(wrapped.KDF, secret)
+		if err != nil {
+			return err
+		}
+		enc.version = wrapped.Version
+		enc.keyringLoaded = true
+		previous[wrapped.Version] = enc
+	}
+	e.version = keyring.Version
+	e.previous = previous
+	e.keyringLoaded = true
+	return nil
+}
+
+func newEncryptionFromSecret(kdf byte, secret []byte) (*encryption, error) {
+	switch kdf {
+	case encryptionKDFKey:
+		return newEncryption(Encryption{Key: secret})
+	case encryptionKDFPBKDF:
+		return newEncryption(Encryption{Passphrase: string(secret)})
+	}
+	return nil, fmt.Errorf("Unknown kind %v of key in keyring", kdf)
+}
+
+// getEncryptionKeyVersion returns version of the key objects are written
+// with through driver, 0 if they're not encrypted
+func getEncryptionKeyVersion(driver ObjectStoreDriver) int {
+	d, ok := driver.(*encryptedDriver)
+	if !ok {
+		return 0
+	}
+	if err := d.enc.loadKeyring(d.ObjectStoreDriver); err != nil {
+		return 0
+	}
+	return d.enc.version
+}
+
+/*
+RotateEncryptionKey makes objects written to destURL encrypted with e from
+now on, rather than the key set by SetEncryption, and returns version of the
+new key. Keys rotated from are wrapped with the new key in keyring of the
+destination, so backups encrypted with them can still be read, without
+encrypting their blocks again. The new key has to be set for the destination
+afterwards, e.g. when daemon restarts, the earlier ones are refused.
+*/
+func RotateEncryptionKey(destURL string, e Encryption) (int, error) {
+	newEnc, err := newEncryption(e)
+	if err != nil {
+		return 0, err
+	}
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return 0, err
+	}
+	d, ok := driver.(*encryptedDriver)
+	if !ok {
+		return 0, fmt.Errorf("Encryption is not enabled for %v", destURL)
+	}
+	current := d.enc
+	if err := current.loadKeyring(d.ObjectStoreDriver); err != nil {
+		return 0, err
+	}
+	if current.kdf == newEnc.kdf && bytes.Equal(current.secret(), newEnc.secret()) {
+		return 0, fmt.Errorf("New encryption key of %v is the same as the current one", destURL)
+	}
+
+	keyring := &encryptionKeyring{
+		Version: current.version + 1,
+		Salt:    make([]byte, encryptionSaltSize),
+	}
+	if _, err := rand.Read(keyring.Salt); err != nil {
+		return 0, err
+	}
+	wrappingKey := newEnc.wrappingKey(keyring.Salt)
+	keyring.Check = keyringCheck(wrappingKey)
+
+	previous := map[int]*encryption{
+		current.version: current,
+	}
+	for version, enc := range current.previous {
+		previous[version] = enc
+	}
+	versions := []int{}
+	for version := range previous {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	for _, version := range versions {
+		enc := previous[version]
+		sealed, err := sealKey(wrappingKey, version, enc.secret())
+		if err != nil {
+			return 0, err
+		}
+		keyring.Keys = append(keyring.Keys, wrappedEncryptionKey{
+			Version: version,
+			KDF:     enc.kdf,
+			Secret:  sealed,
+		})
+	}
+	if err := saveConfigInObjectStore(getKeyringFilePath(), d.ObjectStoreDriver, keyring); err != nil {
+		return 0, err
+	}
+
+	newEnc.version = keyring.Version
+	newEnc.previous = previous
+	newEnc.keyringLoaded = true
+
+	encryptionsMutex.Lock()
+	defer encryptionsMutex.Unlock()
+
+	encryptions[driver.GetURL()] = newEnc
+	log.Infof("Rotated encryption key of %v to version %v", driver.GetURL(), keyring.Version)
+	return keyring.Version, nil
+}
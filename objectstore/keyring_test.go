@@ -0,0 +1,84 @@
+package objectstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestRotateEncryptionKey(c *check.C) {
+	defer RemoveEncryption(memDestURL)
+
+	key1 := bytes.Repeat([]byte{'1'}, ENCRYPTION_KEY_SIZE)
+	key3 := bytes.Repeat([]byte{'3'}, ENCRYPTION_KEY_SIZE)
+	_, err := RotateEncryptionKey(memDestURL, Encryption{Key: key3})
+	c.Assert(err, check.ErrorMatches, "Encryption is not enabled.*")
+
+	dir, err := ioutil.TempDir("", "objectstore-restore")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	c.Assert(SetEncryption(memDestURL, Encryption{Key: key1}), check.IsNil)
+	backupURL1, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	info, err := GetBackupInfo(backupURL1)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["EncryptionKeyVersion"], check.Equals, "1")
+
+	_, err = RotateEncryptionKey(memDestURL, Encryption{Key: key1})
+	c.Assert(err, check.ErrorMatches, "New encryption key .* is the same as the current one")
+	version, err := RotateEncryptionKey(memDestURL, Encryption{Passphrase: "correct horse battery staple"})
+	c.Assert(err, check.IsNil)
+	c.Assert(version, check.Equals, 2)
+	keyring := s.driver.files[getKeyringFilePath()]
+	c.Assert(keyring, check.NotNil)
+	c.Assert(bytes.Contains(keyring, key1), check.Equals, false)
+
+	// Blocks of the last backup are reused rather than encrypted again
+	blocks := s.countBlocks()
+	backupURL2, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap2"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	info, err = GetBackupInfo(backupURL2)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["EncryptionKeyVersion"], check.Equals, "2")
+	driver, err := GetObjectStoreDriver(memDestURL)
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(s.backupName(c, backupURL2), "vol1", driver)
+	c.Assert(err, check.IsNil)
+	newBlocks := 0
+	for _, block := range backup.Blocks {
+		data := s.driver.files[filepath.Clean(getBlockFilePath("vol1", block.BlockChecksum))]
+		if bytes.HasPrefix(data, []byte(encryptionMagicV2)) {
+			newBlocks++
+		}
+	}
+	c.Assert(s.countBlocks()-blocks, check.Equals, newBlocks)
+
+	version, err = RotateEncryptionKey(memDestURL, Encryption{Key: key3})
+	c.Assert(err, check.IsNil)
+	c.Assert(version, check.Equals, 3)
+
+	// Earlier keys are refused once rotated from, e.g. after daemon restarts
+	c.Assert(SetEncryption(memDestURL, Encryption{Key: key1}), check.IsNil)
+	_, err = GetBackupInfo(backupURL1)
+	c.Assert(err, check.ErrorMatches, "Encryption key of .* is not key version 3 in its keyring.*")
+
+	c.Assert(SetEncryption(memDestURL, Encryption{Key: key3}), check.IsNil)
+	for i, backupURL := range []string{backupURL1, backupURL2} {
+		file := filepath.Join(dir, "restored")
+		c.Assert(RestoreDeltaBlockBackup(backupURL, file), check.IsNil, check.Commentf("backup %v", i+1))
+		data, err := ioutil.ReadFile(file)
+		c.Assert(err, check.IsNil)
+		expected := make([]byte, DEFAULT_BLOCK_SIZE)
+		(&memSnapshotOps{}).ReadSnapshot([]string{"snap1", "snap2"}[i], "vol1", 0, expected)
+		c.Assert(bytes.Equal(data[:DEFAULT_BLOCK_SIZE], expected), check.Equals, true)
+	}
+}
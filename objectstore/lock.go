@@ -0,0 +1,51 @@
+package objectstore
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+ObjectLocker is implemented by drivers whose objects are locked against
+deletion and overwrite for a period after they're written, e.g. S3 with Object
+Lock. Backups saved to such destinations record when their locks expire, and
+delete and prune keep them until then, so they stay listed and restorable for
+as long as their objects cannot be removed.
+*/
+type ObjectLocker interface {
+	// GetLockPeriod returns how long objects are locked after they're
+	// written, 0 if they're not
+	GetLockPeriod() time.Duration
+}
+
+func getLockPeriod(driver ObjectStoreDriver) time.Duration {
+	if locker, ok := driver.(ObjectLocker); ok {
+		return locker.GetLockPeriod()
+	}
+	return 0
+}
+
+// lockBackup records the lock of the backup about to be saved. The config is
+// written last, so it's locked at least as long as the rest of the backup.
+func lockBackup(backup *Backup, driver ObjectStoreDriver) {
+	if period := getLockPeriod(driver); period > 0 {
+		backup.LockedUntil = time.Now().Add(period).Format(time.RubyDate)
+	}
+}
+
+// isBackupLocked returns whether the lock of the backup expires after now.
+// Backups whose lock cannot be parsed are taken as locked.
+func isBackupLocked(backup *Backup, now time.Time) bool {
+	if backup.LockedUntil == "" {
+		return false
+	}
+	t, err := time.Parse(time.RubyDate, backup.LockedUntil)
+	return err != nil || t.After(now)
+}
+
+func checkBackupLock(backup *Backup) error {
+	if isBackupLocked(backup, time.Now()) {
+		return fmt.Errorf("Backup %v of volume %v is locked until %v", backup.Name, backup.VolumeName, backup.LockedUntil)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package objectstore
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestLockedBackups(c *check.C) {
+	dr := s.addDest(drDestURL)
+	s.driver.lockPeriod = time.Hour
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURLs := []string{}
+	for _, snapshotName := range []string{"snap1", "snap10"} {
+		backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: snapshotName}, memDestURL, 0, &memSnapshotOps{})
+		c.Assert(err, check.IsNil)
+		backupURLs = append(backupURLs, backupURL)
+	}
+	backupName := s.backupName(c, backupURLs[0])
+	backup, err := loadBackup(backupName, "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	lockedUntil, err := time.Parse(time.RubyDate, backup.LockedUntil)
+	c.Assert(err, check.IsNil)
+	c.Assert(lockedUntil.After(time.Now().Add(59*time.Minute)), check.Equals, true)
+	info, err := GetBackupInfo(backupURLs[0])
+	c.Assert(err, check.IsNil)
+	c.Assert(info["LockedUntil"], check.Equals, backup.LockedUntil)
+
+	// The first backup is the older one for retention
+	backup.CreatedTime = time.Now().Add(-time.Hour).Format(time.RubyDate)
+	c.Assert(saveConfigInObjectStore(getBackupConfigPath(backupName, "vol1"), s.driver, backup), check.IsNil)
+
+	c.Assert(DeleteDeltaBlockBackup(backupURLs[0]), check.ErrorMatches, "Backup .* of volume vol1 is locked until .*")
+	c.Assert(backupExists(backupName, "vol1", s.driver), check.Equals, true)
+
+	// Expired backups are kept until their locks expire
+	policy := &RetentionPolicy{Last: 1}
+	result, err := PruneBackups(memDestURL, "vol1", policy, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Pruned, check.HasLen, 0)
+	c.Assert(result.Locked, check.DeepEquals, []string{backupURLs[0]})
+	c.Assert(backupExists(backupName, "vol1", s.driver), check.Equals, true)
+
+	backup.LockedUntil = time.Now().Add(-time.Minute).Format(time.RubyDate)
+	c.Assert(saveConfigInObjectStore(getBackupConfigPath(backupName, "vol1"), s.driver, backup), check.IsNil)
+	result, err = PruneBackups(memDestURL, "vol1", policy, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Pruned, check.DeepEquals, []string{backupURLs[0]})
+	c.Assert(result.Locked, check.HasLen, 0)
+
+	// Locks don't follow replicas to destinations without them
+	replicaURL, err := ReplicateBackup(backupURLs[1], drDestURL)
+	c.Assert(err, check.IsNil)
+	replica, err := loadBackup(s.backupName(c, replicaURL), "vol1", dr)
+	c.Assert(err, check.IsNil)
+	c.Assert(replica.LockedUntil, check.Equals, "")
+	c.Assert(DeleteDeltaBlockBackup(replicaURL), check.IsNil)
+}
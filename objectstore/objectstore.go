@@ -8,7 +8,14 @@ import (
 	"github.com/rancher/convoy/util"
 )
 
+const (
+	// Labels of backup are in its info as "Label.<key>"
+	BACKUP_INFO_LABEL_PREFIX = "Label."
+)
+
 type Volume struct {
+	// Version of the config, see SCHEMA_VERSION
+	SchemaVersion  int
 	Name           string
 	Driver         string
 	Size           int64
@@ -19,18 +26,54 @@ type Volume struct {
 type Snapshot struct {
 	Name        string
 	CreatedTime string
+	// Labels recorded in the backup of the snapshot
+	Labels map[string]string
 }
 
 type Backup struct {
+	// Version of the config, see SCHEMA_VERSION
+	SchemaVersion     int
 	Name              string
 	Driver            string
 	VolumeName        string
 	SnapshotName      string
 	SnapshotCreatedAt string
 	CreatedTime       string
+	// BlockSize of delta block backup, 0 for DEFAULT_BLOCK_SIZE
+	BlockSize int64 `json:",omitempty"`
+	// Compression of blocks uploaded by delta block backup, empty for
+	// DEFAULT_COMPRESSION. Blocks shared with earlier backups may differ
+	Compression string `json:",omitempty"`
+	// Size of the backup, nil for backups created before it's recorded
+	Size *BackupSize `json:",omitempty"`
+	// Blocks of delta block backup are stored in the block pool of the
+	// destination rather than the volume
+	SharedBlocks bool `json:",omitempty"`
+	// The backup cannot be removed before it, see ObjectLocker
+	LockedUntil string `json:",omitempty"`
+	// Labels set when the backup is created
+	Labels map[string]string `json:",omitempty"`
+	// Version of the key the backup is encrypted with, 0 if it's not.
+	// Blocks shared with earlier backups may have been encrypted with
+	// earlier versions
+	EncryptionKeyVersion int `json:",omitempty"`
 
 	Blocks     []BlockMapping `json:",omitempty"`
 	SingleFile BackupFile     `json:",omitempty"`
+	// Files of file set backup, whose data are in Blocks
+	Files []BackupFileEntry `json:",omitempty"`
+}
+
+/*
+BackupSize is what a backup actually costs, rather than the size of the
+volume. ChangedBytes is data of the snapshot read and backed up, i.e. blocks
+changed since the last backup for delta block backups, or the whole file for
+single file backups. StoredBytes is what the backup added to the objectstore,
+after compression and deduplication against blocks already there.
+*/
+type BackupSize struct {
+	ChangedBytes int64
+	StoredBytes  int64
 }
 
 func addVolume(volume *Volume, driver ObjectStoreDriver) error {
@@ -42,6 +85,7 @@ func addVolume(volume *Volume, driver ObjectStoreDriver) error {
 		log.Error("Fail add volume ", volume.Name)
 		return err
 	}
+	catalogAddVolume(volume, driver)
 	log.Debug("Added objectstore volume ", volume.Name)
 
 	return nil
@@ -57,6 +101,8 @@ func removeVolume(volumeName string, driver ObjectStoreDriver) error {
 		return err
 	}
 	log.Debug("Removed volume directory in objectstore: ", volumeDir)
+	indexRemoveVolume(volumeName, driver)
+	catalogRemoveVolume(volumeName, driver)
 	log.Debug("Removed objectstore volume ", volumeName)
 
 	return nil
@@ -83,62 +129,42 @@ func decodeBackupURL(backupURL string) (string, string, error) {
 	return backupName, volumeName, nil
 }
 
-func addListVolume(resp map[string]map[string]string, volumeName string, driver ObjectStoreDriver, storageDriverName string) error {
-	if volumeName == "" {
-		return fmt.Errorf("Invalid empty volume Name")
-	}
-
-	backupNames, err := getBackupNamesForVolume(volumeName, driver)
-	if err != nil {
-		return err
-	}
-
-	volume, err := loadVolume(volumeName, driver)
+/*
+List returns backups at destURL, of volumeName if specified. Backups in all
+members would be returned if destURL refers a destination group.
+*/
+func List(volumeName, destURL, storageDriverName string) (map[string]map[string]string, error) {
+	destURLs, err := expandDestURL(destURL)
 	if err != nil {
-		return err
-	}
-	//Skip any volumes not owned by specified storage driver
-	if volume.Driver != storageDriverName {
-		return nil
+		return nil, err
 	}
-
-	for _, backupName := range backupNames {
-		backup, err := loadBackup(backupName, volumeName, driver)
+	resp := make(map[string]map[string]string)
+	for _, u := range destURLs {
+		infos, err := listDest(volumeName, u, storageDriverName)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		for k, v := range infos {
+			resp[k] = v
 		}
-		r := fillBackupInfo(backup, volume, driver.GetURL())
-		resp[r["BackupURL"]] = r
 	}
-	return nil
+	return resp, nil
 }
 
-func List(volumeName, destURL, storageDriverName string) (map[string]map[string]string, error) {
+func listDest(volumeName, destURL, storageDriverName string) (map[string]map[string]string, error) {
 	driver, err := GetObjectStoreDriver(destURL)
 	if err != nil {
 		return nil, err
 	}
-	resp := make(map[string]map[string]string)
-	if volumeName != "" {
-		if err = addListVolume(resp, volumeName, driver, storageDriverName); err != nil {
-			return nil, err
-		}
-	} else {
-		volumeNames, err := getVolumeNames(driver)
-		if err != nil {
-			return nil, err
-		}
-		for _, volumeName := range volumeNames {
-			if err := addListVolume(resp, volumeName, driver, storageDriverName); err != nil {
-				return nil, err
-			}
-		}
+	resp, err := listFromIndex(volumeName, driver, storageDriverName)
+	if err != nil || resp != nil {
+		return resp, err
 	}
-	return resp, nil
+	return listFromCatalog(volumeName, driver, storageDriverName)
 }
 
 func fillBackupInfo(backup *Backup, volume *Volume, destURL string) map[string]string {
-	return map[string]string{
+	info := map[string]string{
 		"BackupName":        backup.Name,
 		"BackupURL":         encodeBackupURL(backup.Name, backup.VolumeName, destURL),
 		"DriverName":        volume.Driver,
@@ -149,6 +175,32 @@ func fillBackupInfo(backup *Backup, volume *Volume, destURL string) map[string]s
 		"SnapshotCreatedAt": backup.SnapshotCreatedAt,
 		"CreatedTime":       backup.CreatedTime,
 	}
+	if backup.BlockSize != 0 {
+		info["BlockSize"] = strconv.FormatInt(backup.BlockSize, 10)
+	}
+	if backup.Compression != "" {
+		info["Compression"] = backup.Compression
+	}
+	if backup.SharedBlocks {
+		info["SharedBlocks"] = "true"
+	}
+	if backup.LockedUntil != "" {
+		info["LockedUntil"] = backup.LockedUntil
+	}
+	if backup.EncryptionKeyVersion != 0 {
+		info["EncryptionKeyVersion"] = strconv.Itoa(backup.EncryptionKeyVersion)
+	}
+	if isFileSetBackup(backup) {
+		info["Files"] = strconv.Itoa(len(backup.Files))
+	}
+	for k, v := range backup.Labels {
+		info[BACKUP_INFO_LABEL_PREFIX+k] = v
+	}
+	if backup.Size != nil {
+		info["ChangedSize"] = strconv.FormatInt(backup.Size.ChangedBytes, 10)
+		info["StoredSize"] = strconv.FormatInt(backup.Size.StoredBytes, 10)
+	}
+	return info
 }
 
 func GetBackupInfo(backupURL string) (map[string]string, error) {
@@ -160,6 +212,9 @@ func GetBackupInfo(backupURL string) (map[string]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	if info, err := getBackupInfoFromIndex(backupName, volumeName, driver); err != nil || info != nil {
+		return info, err
+	}
 
 	volume, err := loadVolume(volumeName, driver)
 	if err != nil {
@@ -0,0 +1,269 @@
+package objectstore
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	POOL_DIRECTORY      = "pool"
+	POOL_REFS_DIRECTORY = "refs"
+	POOL_CONFIG_FILE    = "pool.cfg"
+)
+
+var (
+	// Destinations whose delta block backups store blocks in the block pool,
+	// keyed by their canonical URLs
+	blockPools      = make(map[string]bool)
+	blockPoolsMutex = &sync.RWMutex{}
+)
+
+/*
+Blocks of volumes are stored under their own paths by default, so identical
+blocks of different volumes, e.g. volumes created from the same image, are
+stored once per volume. The block pool of a destination stores blocks by
+checksum for all volumes in it instead, so they're stored once per
+destination.
+
+A block in the pool is referenced by a volume if there's a reference object
+of the volume under the block in POOL_REFS_DIRECTORY, which is written by
+backups of the volume before their configs are saved, and removed by garbage
+collection of the volume once no backup of the volume references the block.
+The block is removed after its last reference is. Backups and garbage
+collection of the pool coordinate through markers in the pool, the same way
+as they do in volumes.
+*/
+type blockPool struct {
+	CreatedTime string
+}
+
+type poolRef struct {
+	VolumeName  string
+	CreatedTime string
+}
+
+// SetBlockPool makes delta block backups to destURL store blocks in the
+// block pool shared by all volumes in destURL
+func SetBlockPool(destURL string) error {
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return err
+	}
+
+	blockPoolsMutex.Lock()
+	defer blockPoolsMutex.Unlock()
+
+	blockPools[driver.GetURL()] = true
+	return nil
+}
+
+func useBlockPool(destURL string) bool {
+	blockPoolsMutex.RLock()
+	defer blockPoolsMutex.RUnlock()
+
+	return blockPools[destURL]
+}
+
+func getPoolPath() string {
+	return filepath.Join(OBJECTSTORE_BASE, POOL_DIRECTORY)
+}
+
+func getPoolConfigPath() string {
+	return filepath.Join(getPoolPath(), POOL_CONFIG_FILE)
+}
+
+func getBlockSubDirs(checksum string) (string, string) {
+	return checksum[0:BLOCK_SEPARATE_LAYER1], checksum[BLOCK_SEPARATE_LAYER1:BLOCK_SEPARATE_LAYER2]
+}
+
+func getPoolBlockFilePath(checksum string) string {
+	layer1, layer2 := getBlockSubDirs(checksum)
+	return filepath.Join(getPoolPath(), BLOCKS_DIRECTORY, layer1, layer2, checksum+BLOCK_FILE_SUFFIX)
+}
+
+func getPoolRefDir(checksum string) string {
+	layer1, layer2 := getBlockSubDirs(checksum)
+	return filepath.Join(getPoolPath(), POOL_REFS_DIRECTORY, layer1, layer2, checksum) + "/"
+}
+
+func getPoolRefPath(checksum, volumeName string) string {
+	return filepath.Join(getPoolRefDir(checksum), volumeName+CFG_SUFFIX)
+}
+
+// addPool marks the destination as having a block pool, so garbage
+// collection of volumes would look for their references
+func addPool(driver ObjectStoreDriver) error {
+	if driver.FileExists(getPoolConfigPath()) {
+		return nil
+	}
+	return saveConfigInObjectStore(getPoolConfigPath(), driver, &blockPool{
+		CreatedTime: util.Now(),
+	})
+}
+
+// addPoolRef references the block by the volume, returns false if it's
+// been referenced already
+func addPoolRef(checksum, volumeName string, driver ObjectStoreDriver) (bool, error) {
+	refPath := getPoolRefPath(checksum, volumeName)
+	if driver.FileExists(refPath) {
+		return false, nil
+	}
+	if err := saveConfigInObjectStore(refPath, driver, &poolRef{
+		VolumeName:  volumeName,
+		CreatedTime: util.Now(),
+	}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+/*
+releasePoolBlocks removes references of the volume to blocks in
+discardBlockSet, which are no longer referenced by any backup of the volume,
+then collects the blocks no longer referenced by any volume.
+*/
+func releasePoolBlocks(volumeName string, discardBlockSet map[string]bool, driver ObjectStoreDriver) error {
+	if len(discardBlockSet) == 0 || !driver.FileExists(getPoolConfigPath()) {
+		return nil
+	}
+	released := make(map[string]bool)
+	for blk := range discardBlockSet {
+		refPath := getPoolRefPath(blk, volumeName)
+		if !driver.FileExists(refPath) {
+			continue
+		}
+		if err := driver.Remove(refPath); err != nil {
+			return err
+		}
+		released[blk] = true
+	}
+	if len(released) == 0 {
+		return nil
+	}
+	log.Debugf("Released %v blocks in pool referenced by volume %v", len(released), volumeName)
+	return collectPoolGarbage(released, driver)
+}
+
+/*
+releaseFailedBackupRefs releases references added by the failed backup, so
+blocks it uploaded won't be kept forever. They're kept if other backups of the
+volume are in progress, which may have found the references existing.
+*/
+func releaseFailedBackupRefs(backupName, volumeName string, refs map[string]bool, driver ObjectStoreDriver) {
+	if len(refs) == 0 {
+		return
+	}
+	inflight, err := listLiveMarkers(INFLIGHT_DIRECTORY, getVolumePath(volumeName), driver)
+	if err != nil {
+		log.Warnf("Failed to release references of failed backup %v of volume %v: %v", backupName, volumeName, err)
+		return
+	}
+	for _, name := range inflight {
+		if name != backupName {
+			log.Debugf("Keep references of failed backup %v, backups %v of volume %v in progress", backupName, inflight, volumeName)
+			return
+		}
+	}
+	if err := releasePoolBlocks(volumeName, refs, driver); err != nil {
+		log.Warnf("Failed to release references of failed backup %v of volume %v: %v", backupName, volumeName, err)
+	}
+}
+
+// endPoolBackup clears the mark of backup in progress in the pool, and
+// collects the garbage of the pool deferred because of the backup
+func endPoolBackup(m *marker, driver ObjectStoreDriver) {
+	m.remove(driver)
+
+	pendings, err := listConfigNames(getMarkerDir(GC_PENDING_DIRECTORY, getPoolPath()), driver)
+	if err != nil || len(pendings) == 0 {
+		return
+	}
+	if err := collectPoolGarbage(nil, driver); err != nil {
+		log.Warnf("Failed to collect deferred garbage of block pool: %v", err)
+	}
+}
+
+/*
+collectPoolGarbage removes the blocks in candidates without references, along
+with blocks deferred by previous collections. If backups are in progress on
+the pool, the blocks would be deferred instead, since they may have found the
+blocks existing without having referenced them yet.
+*/
+func collectPoolGarbage(candidates map[string]bool, driver ObjectStoreDriver) error {
+	if candidates == nil {
+		candidates = make(map[string]bool)
+	}
+	base := getPoolPath()
+	gcMarker, err := createMarker(GC_DIRECTORY, base, util.GenerateName("gc"), driver)
+	if err != nil {
+		return err
+	}
+	defer gcMarker.remove(driver)
+
+	inflight, err := listLiveMarkers(INFLIGHT_DIRECTORY, base, driver)
+	if err != nil {
+		return err
+	}
+	if len(inflight) != 0 {
+		if len(candidates) == 0 {
+			return nil
+		}
+		pending := &pendingGarbage{
+			Name:        gcMarker.Name,
+			CreatedTime: util.Now(),
+			Blocks:      []string{},
+		}
+		for blk := range candidates {
+			pending.Blocks = append(pending.Blocks, blk)
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON: LOG_REASON_FALLBACK,
+		}).Debugf("Deferred collection of %v blocks in pool, backups %v in progress", len(pending.Blocks), inflight)
+		return saveConfigInObjectStore(getMarkerPath(GC_PENDING_DIRECTORY, base, pending.Name), driver, pending)
+	}
+
+	pendings, err := listConfigNames(getMarkerDir(GC_PENDING_DIRECTORY, base), driver)
+	if err != nil {
+		return err
+	}
+	for _, name := range pendings {
+		pending := &pendingGarbage{}
+		if err := loadConfigInObjectStore(getMarkerPath(GC_PENDING_DIRECTORY, base, name), driver, pending); err != nil {
+			return err
+		}
+		for _, blk := range pending.Blocks {
+			candidates[blk] = true
+		}
+	}
+
+	blkFileList := []string{}
+	for blk := range candidates {
+		if err := gcMarker.keepAlive(driver); err != nil {
+			return err
+		}
+		refs, err := listConfigNames(getPoolRefDir(blk), driver)
+		if err != nil {
+			return err
+		}
+		if len(refs) != 0 {
+			continue
+		}
+		blkFileList = append(blkFileList, getPoolBlockFilePath(blk))
+	}
+	if err := driver.Remove(blkFileList...); err != nil {
+		return err
+	}
+	log.Debugf("Removed %v unreferenced blocks in pool", len(blkFileList))
+
+	for _, name := range pendings {
+		if err := driver.Remove(getMarkerPath(GC_PENDING_DIRECTORY, base, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
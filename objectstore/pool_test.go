@@ -0,0 +1,114 @@
+package objectstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestBlockPool(c *check.C) {
+	c.Assert(SetBlockPool(memDestURL), check.IsNil)
+	defer func() {
+		blockPoolsMutex.Lock()
+		delete(blockPools, memDestURL)
+		blockPoolsMutex.Unlock()
+	}()
+
+	// Volumes with identical data share blocks
+	backupURLs := map[string]string{}
+	for _, volumeName := range []string{"vol1", "vol2"} {
+		volume := &Volume{
+			Name:   volumeName,
+			Driver: "loop",
+			Size:   2 * DEFAULT_BLOCK_SIZE,
+		}
+		backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+		c.Assert(err, check.IsNil)
+		backupURLs[volumeName] = backupURL
+	}
+	c.Assert(s.countBlocks(), check.Equals, 2)
+	backup, err := loadBackup(s.backupName(c, backupURLs["vol2"]), "vol2", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.SharedBlocks, check.Equals, true)
+	for _, blk := range backup.Blocks {
+		c.Assert(s.driver.FileExists(getPoolBlockFilePath(blk.BlockChecksum)), check.Equals, true)
+		refs, err := listConfigNames(getPoolRefDir(blk.BlockChecksum), s.driver)
+		c.Assert(err, check.IsNil)
+		sort.Strings(refs)
+		c.Assert(refs, check.DeepEquals, []string{"vol1", "vol2"})
+	}
+	info, err := GetBackupInfo(backupURLs["vol2"])
+	c.Assert(err, check.IsNil)
+	c.Assert(info["SharedBlocks"], check.Equals, "true")
+	c.Assert(info["StoredSize"], check.Equals, "0")
+
+	dir, err := ioutil.TempDir("", "objectstore-pool")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "vol2")
+	c.Assert(RestoreDeltaBlockBackup(backupURLs["vol2"], file), check.IsNil)
+	verified, err := VerifyRestoredBackup(backupURLs["vol2"], file)
+	c.Assert(err, check.IsNil)
+	c.Assert(verified, check.Equals, 2)
+
+	// Blocks referenced by another volume are kept
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap10"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	// The first block of snap10 is the same as the second one of snap1
+	c.Assert(s.countBlocks(), check.Equals, 3)
+	c.Assert(DeleteDeltaBlockBackup(backupURLs["vol1"]), check.IsNil)
+	c.Assert(DeleteDeltaBlockBackup(backupURL), check.IsNil)
+	c.Assert(volumeExists("vol1", s.driver), check.Equals, false)
+	c.Assert(s.countBlocks(), check.Equals, 2)
+	refs, err := listConfigNames(getPoolRefDir(backup.Blocks[0].BlockChecksum), s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(refs, check.DeepEquals, []string{"vol2"})
+
+	// Backup in progress in the pool defers collection of the last ones
+	inflight, err := beginInflight("backup-inflight", getPoolPath(), s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(DeleteDeltaBlockBackup(backupURLs["vol2"]), check.IsNil)
+	c.Assert(s.countBlocks(), check.Equals, 2)
+	endPoolBackup(inflight, s.driver)
+	c.Assert(s.countBlocks(), check.Equals, 0)
+	pendings, err := listConfigNames(getMarkerDir(GC_PENDING_DIRECTORY, getPoolPath()), s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(pendings, check.HasLen, 0)
+}
+
+func (s *TestSuite) TestBlockPoolSwitch(c *check.C) {
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	_, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+
+	c.Assert(SetBlockPool(memDestURL), check.IsNil)
+	defer func() {
+		blockPoolsMutex.Lock()
+		delete(blockPools, memDestURL)
+		blockPoolsMutex.Unlock()
+	}()
+	// Blocks of the last backup are in the volume, so it's a full backup
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap2"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(s.backupName(c, backupURL), "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.SharedBlocks, check.Equals, true)
+	c.Assert(backup.Blocks, check.HasLen, 2)
+	for _, blk := range backup.Blocks {
+		c.Assert(s.driver.FileExists(getPoolBlockFilePath(blk.BlockChecksum)), check.Equals, true)
+		c.Assert(s.driver.FileExists(getBlockFilePath("vol1", blk.BlockChecksum)), check.Equals, true)
+	}
+	c.Assert(s.countBlocks(), check.Equals, 4)
+}
@@ -0,0 +1,267 @@
+package objectstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	PROBE_DIRECTORY = "probes"
+
+	DEFAULT_PROBE_FAILURE_THRESHOLD = 3
+)
+
+// probeCanary is written to the destination by every probe, one object per
+// host
+type probeCanary struct {
+	Host     string
+	ProbedAt string
+}
+
+/*
+destinationHealth is the circuit breaker of a destination. It opens after
+threshold consecutive failed probes, so backups to the destination fail fast
+rather than hanging until they time out, and closes on the first successful
+probe afterwards.
+*/
+type destinationHealth struct {
+	Available bool
+	Failures  int
+	Error     string
+	CheckedAt string
+	// Since is the time destination entered current state
+	Since string
+}
+
+var (
+	// Health of destinations probed, keyed by their canonical URLs
+	destHealth            = make(map[string]*destinationHealth)
+	destProbes            = make(map[string]*util.Probe)
+	probeFailureThreshold = DEFAULT_PROBE_FAILURE_THRESHOLD
+	destHealthMutex       = &sync.RWMutex{}
+)
+
+// SetProbeFailureThreshold sets how many consecutive failed probes make a
+// destination unavailable
+func SetProbeFailureThreshold(threshold int) error {
+	if threshold < 1 {
+		return fmt.Errorf("Invalid probe failure threshold %v, must be positive", threshold)
+	}
+
+	destHealthMutex.Lock()
+	defer destHealthMutex.Unlock()
+
+	probeFailureThreshold = threshold
+	return nil
+}
+
+func getProbePath(host string) string {
+	return filepath.Join(OBJECTSTORE_BASE, PROBE_DIRECTORY, host+CFG_SUFFIX)
+}
+
+// probeDestination writes the canary object of the host, and checks it can be
+// found afterwards
+func probeDestination(driver ObjectStoreDriver) error {
+	host, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	path := getProbePath(host)
+	canary := &probeCanary{
+		Host:     host,
+		ProbedAt: util.Now(),
+	}
+	if err := saveConfigInObjectStore(path, driver, canary); err != nil {
+		return fmt.Errorf("Cannot write canary object %v: %v", path, err)
+	}
+	if driver.FileSize(path) < 0 {
+		return fmt.Errorf("Cannot find canary object %v after writing it", path)
+	}
+	return nil
+}
+
+// getDestinationDrivers returns drivers of destURLs and every member of
+// destination groups, by their canonical URLs. Destinations which cannot be
+// opened are logged and skipped.
+func getDestinationDrivers(destURLs []string) map[string]ObjectStoreDriver {
+	groupsMutex.RLock()
+	for _, g := range groups {
+		destURLs = append(destURLs, g.Members...)
+	}
+	groupsMutex.RUnlock()
+
+	drivers := make(map[string]ObjectStoreDriver)
+	for _, destURL := range destURLs {
+		members, err := expandDestURL(destURL)
+		if err != nil {
+			log.Warnf("Skip destination %v: %v", destURL, err)
+			continue
+		}
+		for _, member := range members {
+			driver, err := GetObjectStoreDriver(member)
+			if err != nil {
+				log.Warnf("Skip destination %v: %v", member, err)
+				continue
+			}
+			drivers[driver.GetURL()] = driver
+		}
+	}
+	return drivers
+}
+
+// ListDestinations returns canonical URLs of destURLs and every member of
+// destination groups, sorted
+func ListDestinations(destURLs []string) []string {
+	urls := []string{}
+	for url := range getDestinationDrivers(destURLs) {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+/*
+ProbeDestinations probes destURLs, along with every member of destination
+groups, at the same time. A probe not returning within timeout is taken as
+failure, so a hung destination won't hold up the others, and would be waited
+for by the next probe rather than probed again. Destinations are deduplicated
+by their canonical URLs.
+*/
+func ProbeDestinations(destURLs []string, timeout time.Duration) {
+	drivers := getDestinationDrivers(destURLs)
+
+	wg := sync.WaitGroup{}
+	for url, driver := range drivers {
+		wg.Add(1)
+		go func(url string, driver ObjectStoreDriver) {
+			defer wg.Done()
+
+			updateDestinationHealth(url, getDestinationProbe(url, driver).Run(timeout))
+		}(url, driver)
+	}
+	wg.Wait()
+}
+
+// getDestinationProbe returns probe of the destination, so a hung destination
+// is probed at most once at a time
+func getDestinationProbe(url string, driver ObjectStoreDriver) *util.Probe {
+	destHealthMutex.Lock()
+	defer destHealthMutex.Unlock()
+
+	probe, exists := destProbes[url]
+	if !exists {
+		probe = util.NewProbe(func() error {
+			return probeDestination(driver)
+		})
+		destProbes[url] = probe
+	}
+	return probe
+}
+
+func updateDestinationHealth(url string, err error) {
+	destHealthMutex.Lock()
+	defer destHealthMutex.Unlock()
+
+	now := util.Now()
+	health, exists := destHealth[url]
+	if !exists {
+		health = &destinationHealth{
+			Available: true,
+			Since:     now,
+		}
+		destHealth[url] = health
+	}
+	health.CheckedAt = now
+	if err == nil {
+		if !health.Available {
+			log.WithFields(logrus.Fields{
+				LOG_FIELD_REASON:   LOG_REASON_RECOVERY,
+				LOG_FIELD_EVENT:    LOG_EVENT_HEALTH,
+				LOG_FIELD_OBJECT:   LOG_OBJECT_DEST_URL,
+				LOG_FIELD_DEST_URL: url,
+			}).Infof("Destination %v recovered, was unavailable since %v", url, health.Since)
+			health.Since = now
+		}
+		health.Available = true
+		health.Failures = 0
+		health.Error = ""
+		return
+	}
+	health.Failures++
+	health.Error = err.Error()
+	if !health.Available {
+		return
+	}
+	if health.Failures < probeFailureThreshold {
+		log.Warnf("Probe of destination %v failed, %v of %v failures before it's taken as unavailable: %v",
+			url, health.Failures, probeFailureThreshold, err)
+		return
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_DEGRADED,
+		LOG_FIELD_EVENT:    LOG_EVENT_HEALTH,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_DEST_URL,
+		LOG_FIELD_DEST_URL: url,
+	}).Errorf("Destination %v is unavailable after %v failed probes: %v", url, health.Failures, err)
+	health.Available = false
+	health.Since = now
+}
+
+/*
+CheckDestinationHealth fails fast if the destination volumeName would be
+backed up to in destURL has been found unavailable by probes. Destinations
+never probed are taken as available.
+*/
+func CheckDestinationHealth(destURL, volumeName string) error {
+	destURL, err := ResolveDestURL(destURL, volumeName)
+	if err != nil {
+		return err
+	}
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return err
+	}
+	url := driver.GetURL()
+
+	destHealthMutex.RLock()
+	defer destHealthMutex.RUnlock()
+
+	health, exists := destHealth[url]
+	if !exists || health.Available {
+		return nil
+	}
+	return fmt.Errorf("Destination %v is unavailable since %v after %v failed probes: %v",
+		url, health.Since, health.Failures, health.Error)
+}
+
+// ListDestinationHealth returns health of destinations probed, keyed by
+// their canonical URLs
+func ListDestinationHealth() map[string]map[string]string {
+	destHealthMutex.RLock()
+	defer destHealthMutex.RUnlock()
+
+	result := make(map[string]map[string]string)
+	for url, health := range destHealth {
+		info := map[string]string{
+			"Available": strconv.FormatBool(health.Available),
+			"Since":     health.Since,
+			"CheckedAt": health.CheckedAt,
+		}
+		if health.Failures != 0 {
+			info["Failures"] = strconv.Itoa(health.Failures)
+			info["Error"] = health.Error
+		}
+		result[url] = info
+	}
+	return result
+}
@@ -0,0 +1,55 @@
+package objectstore
+
+import (
+	"os"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestProbeDestinations(c *check.C) {
+	c.Assert(SetProbeFailureThreshold(0), check.ErrorMatches, "Invalid probe failure threshold.*")
+	c.Assert(SetProbeFailureThreshold(2), check.IsNil)
+	defer func() {
+		SetProbeFailureThreshold(DEFAULT_PROBE_FAILURE_THRESHOLD)
+		destHealthMutex.Lock()
+		delete(destHealth, memDestURL)
+		destHealthMutex.Unlock()
+	}()
+
+	// Never probed
+	c.Assert(CheckDestinationHealth(memDestURL, "vol1"), check.IsNil)
+	c.Assert(ListDestinationHealth(), check.HasLen, 0)
+
+	ProbeDestinations([]string{memDestURL, memDestURL}, time.Second)
+	c.Assert(CheckDestinationHealth(memDestURL, "vol1"), check.IsNil)
+	host, err := os.Hostname()
+	c.Assert(err, check.IsNil)
+	c.Assert(s.driver.FileExists(getProbePath(host)), check.Equals, true)
+	health := ListDestinationHealth()
+	c.Assert(health, check.HasLen, 1)
+	c.Assert(health[memDestURL]["Available"], check.Equals, "true")
+
+	// Breaker opens after threshold
+	s.driver.unavailable = true
+	ProbeDestinations([]string{memDestURL}, time.Second)
+	c.Assert(CheckDestinationHealth(memDestURL, "vol1"), check.IsNil)
+	ProbeDestinations([]string{memDestURL}, time.Second)
+	c.Assert(CheckDestinationHealth(memDestURL, "vol1"), check.ErrorMatches,
+		"Destination mem:///backups is unavailable since .* after 2 failed probes: Cannot write canary object.*connection refused")
+	health = ListDestinationHealth()
+	c.Assert(health[memDestURL]["Available"], check.Equals, "false")
+	c.Assert(health[memDestURL]["Failures"], check.Equals, "2")
+
+	// Members of groups are probed, and checked for the volume
+	c.Assert(SetDestinationGroup("fleet", []string{memDestURL}), check.IsNil)
+	defer RemoveDestinationGroup("fleet")
+	c.Assert(CheckDestinationHealth("group://fleet", "vol1"), check.NotNil)
+
+	s.driver.unavailable = false
+	ProbeDestinations([]string{}, time.Second)
+	c.Assert(CheckDestinationHealth("group://fleet", "vol1"), check.IsNil)
+	health = ListDestinationHealth()
+	c.Assert(health[memDestURL]["Available"], check.Equals, "true")
+	c.Assert(health[memDestURL]["Failures"], check.Equals, "")
+}
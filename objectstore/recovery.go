@@ -0,0 +1,32 @@
+package objectstore
+
+import (
+	"path/filepath"
+)
+
+const (
+	RECOVERY_DIRECTORY = "recovery"
+)
+
+func getRecoveryFilePath(name string) string {
+	return filepath.Join(OBJECTSTORE_BASE, RECOVERY_DIRECTORY, name+CFG_SUFFIX)
+}
+
+// SaveRecoveryConfig would store driver state v as name at destURL, for
+// rebuilding the state when local config is lost
+func SaveRecoveryConfig(destURL, name string, v interface{}) error {
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return err
+	}
+	return saveConfigInObjectStore(getRecoveryFilePath(name), driver, v)
+}
+
+// LoadRecoveryConfig would load driver state saved by SaveRecoveryConfig()
+func LoadRecoveryConfig(destURL, name string, v interface{}) error {
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return err
+	}
+	return loadConfigInObjectStore(getRecoveryFilePath(name), driver, v)
+}
@@ -0,0 +1,362 @@
+package objectstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+var (
+	// Destinations backups would be mirrored to once created, keyed by
+	// canonical URLs of the destinations they're created in
+	mirrors      = make(map[string][]string)
+	mirrorsMutex = &sync.RWMutex{}
+)
+
+// SetMirror makes backups created in destURL mirrored to mirrorURL
+func SetMirror(destURL, mirrorURL string) error {
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return err
+	}
+	if isGroupURL(mirrorURL) {
+		if _, err := getDestinationGroup(mirrorURL); err != nil {
+			return err
+		}
+	} else {
+		mirrorDriver, err := GetObjectStoreDriver(mirrorURL)
+		if err != nil {
+			return err
+		}
+		if mirrorDriver.GetURL() == driver.GetURL() {
+			return fmt.Errorf("Cannot mirror %v to itself", destURL)
+		}
+	}
+
+	mirrorsMutex.Lock()
+	defer mirrorsMutex.Unlock()
+
+	for _, url := range mirrors[driver.GetURL()] {
+		if url == mirrorURL {
+			return nil
+		}
+	}
+	mirrors[driver.GetURL()] = append(mirrors[driver.GetURL()], mirrorURL)
+	return nil
+}
+
+// GetMirrors returns destinations backups at backupURL would be mirrored to
+func GetMirrors(backupURL string) []string {
+	driver, err := GetObjectStoreDriver(backupURL)
+	if err != nil {
+		return nil
+	}
+
+	mirrorsMutex.RLock()
+	defer mirrorsMutex.RUnlock()
+
+	return append([]string{}, mirrors[driver.GetURL()]...)
+}
+
+/*
+replica writes a copy of a backup into a destination the same way as backups
+are written: blocks under markers of backup in progress first, then the
+config, so garbage collection in the destination won't race it. Blocks may be
+written concurrently.
+*/
+type replica struct {
+	backup       *Backup
+	driver       ObjectStoreDriver
+	inflight     *marker
+	poolInflight *marker
+	// Guards the counters, references and the markers
+	mutex       sync.Mutex
+	newRefs     map[string]bool
+	copied      int
+	storedBytes int64
+	saved       bool
+}
+
+// beginReplica adds the volume to the destination if it's not there, and
+// marks the backup in progress. end must be called afterwards.
+func beginReplica(backup *Backup, volume *Volume, driver ObjectStoreDriver) (*replica, error) {
+	r := &replica{
+		backup:  backup,
+		driver:  driver,
+		newRefs: make(map[string]bool),
+	}
+	// Lock of the source doesn't apply, the destination locks it if it
+	// locks objects
+	backup.LockedUntil = ""
+	var err error
+	if r.inflight, err = beginBackup(backup.Name, backup.VolumeName, driver); err != nil {
+		return nil, err
+	}
+	if backup.SharedBlocks {
+		if err = addPool(driver); err == nil {
+			r.poolInflight, err = beginInflight(backup.Name, getPoolPath(), driver)
+		}
+	}
+	if err == nil {
+		// Snapshots of the last backup are only known to the host created
+		// it, so the replica won't be the base of incremental backups to
+		// the destination
+		volume.LastBackupName = ""
+		err = addVolume(volume, driver)
+	}
+	if err != nil {
+		r.end()
+		return nil, err
+	}
+	return r, nil
+}
+
+// end releases references added if the replica isn't saved, and clears the
+// marks of backup in progress
+func (r *replica) end() {
+	if !r.saved {
+		releaseFailedBackupRefs(r.backup.Name, r.backup.VolumeName, r.newRefs, r.driver)
+	}
+	if r.poolInflight != nil {
+		endPoolBackup(r.poolInflight, r.driver)
+	}
+	endBackup(r.inflight, r.backup.VolumeName, r.driver)
+}
+
+func (r *replica) keepAlive() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.inflight.keepAlive(r.driver); err != nil {
+		return err
+	}
+	if r.poolInflight != nil {
+		return r.poolInflight.keepAlive(r.driver)
+	}
+	return nil
+}
+
+// hasBlock references the block by the volume if the backup shares blocks,
+// and returns whether the block is in the destination already
+func (r *replica) hasBlock(checksum string) (bool, error) {
+	if err := r.keepAlive(); err != nil {
+		return false, err
+	}
+	if r.backup.SharedBlocks {
+		added, err := addPoolRef(checksum, r.backup.VolumeName, r.driver)
+		if err != nil {
+			return false, err
+		}
+		if added {
+			r.mutex.Lock()
+			r.newRefs[checksum] = true
+			r.mutex.Unlock()
+		}
+	}
+	blkFile := getBackupBlockFilePath(r.backup, checksum)
+	if r.driver.FileSize(blkFile) >= 0 {
+		log.Debugf("Found existed block match at %v", blkFile)
+		return true, nil
+	}
+	return false, nil
+}
+
+// writeBlock writes the block object of checksum, which must have been
+// checked by hasBlock. Corrupted blocks are refused, so they won't spread to
+// other destinations.
+func (r *replica) writeBlock(checksum string, data []byte) error {
+	if _, err := decompressBlock(data, checksum); err != nil {
+		return fmt.Errorf("Block %v of backup %v is corrupted: %v", checksum, r.backup.Name, err)
+	}
+	blkFile := getBackupBlockFilePath(r.backup, checksum)
+	if err := r.driver.Write(blkFile, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if shouldVerifyBlock() {
+		if err := verifyBlock(r.backup.VolumeName, blkFile, checksum, r.driver); err != nil {
+			return err
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.copied++
+	r.storedBytes += int64(len(data))
+	return nil
+}
+
+// writeFile uploads the file of single file backup
+func (r *replica) writeFile(filePath string) error {
+	st, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if err := r.driver.Upload(filePath, r.backup.SingleFile.FilePath); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.storedBytes += st.Size()
+	return nil
+}
+
+// save saves config of the backup once all of its blocks are written, with
+// StoredBytes of what the replica has written
+func (r *replica) save() error {
+	if r.backup.Size != nil {
+		r.backup.Size = &BackupSize{
+			ChangedBytes: r.backup.Size.ChangedBytes,
+			StoredBytes:  r.storedBytes,
+		}
+	}
+	if err := saveBackup(r.backup, r.driver); err != nil {
+		return err
+	}
+	r.saved = true
+	return nil
+}
+
+/*
+ReplicateBackup copies the backup at backupURL to destURL, along with its
+volume if it's not there yet, so it can be restored from destURL without
+restoring and backing it up again. Only blocks missing in destURL are copied,
+so replicating backups of a volume one after another only copies blocks
+changed between them. Blocks are verified before they're copied, and stored
+with destURL's encryption. The replica keeps the name of the backup, so
+replicating it again does nothing.
+*/
+func ReplicateBackup(backupURL, destURL string) (string, error) {
+	srcDriver, err := GetObjectStoreDriver(backupURL)
+	if err != nil {
+		return "", err
+	}
+	backupName, volumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return "", err
+	}
+	destURL, err = ResolveDestURL(destURL, volumeName)
+	if err != nil {
+		return "", err
+	}
+	dstDriver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return "", err
+	}
+	if srcDriver.GetURL() == dstDriver.GetURL() {
+		return "", fmt.Errorf("Backup %v is in %v already", backupName, destURL)
+	}
+
+	volume, err := loadVolume(volumeName, srcDriver)
+	if err != nil {
+		return "", generateError(logrus.Fields{
+			LOG_FIELD_VOLUME:     volumeName,
+			LOG_FIELD_BACKUP_URL: backupURL,
+		}, "Volume doesn't exist in objectstore: %v", err)
+	}
+	backup, err := loadBackup(backupName, volumeName, srcDriver)
+	if err != nil {
+		return "", err
+	}
+	replicaURL := encodeBackupURL(backupName, volumeName, destURL)
+	if backupExists(backupName, volumeName, dstDriver) {
+		log.Debugf("Backup %v of volume %v has been replicated to %v already", backupName, volumeName, destURL)
+		return replicaURL, nil
+	}
+
+	r, err := beginReplica(backup, volume, dstDriver)
+	if err != nil {
+		return "", err
+	}
+	defer r.end()
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_START,
+		LOG_FIELD_EVENT:      LOG_EVENT_REPLICATE,
+		LOG_FIELD_OBJECT:     LOG_OBJECT_BACKUP_URL,
+		LOG_FIELD_BACKUP_URL: backupURL,
+		LOG_FIELD_DEST_URL:   destURL,
+	}).Debug("Replicating backup")
+
+	if backup.SingleFile.FilePath != "" {
+		err = replicateSingleFile(r, srcDriver)
+	} else {
+		err = replicateBlocks(r, srcDriver)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := r.save(); err != nil {
+		return "", err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:      LOG_EVENT_REPLICATE,
+		LOG_FIELD_OBJECT:     LOG_OBJECT_BACKUP_URL,
+		LOG_FIELD_BACKUP_URL: backupURL,
+		LOG_FIELD_DEST_URL:   destURL,
+	}).Debugf("Replicated backup, copied %v blocks of %v bytes", r.copied, r.storedBytes)
+	return replicaURL, nil
+}
+
+// getUniqueChecksums returns checksums of blocks of the backup, each once
+func getUniqueChecksums(backup *Backup) []string {
+	checksums := []string{}
+	seen := make(map[string]bool)
+	for _, blk := range backup.Blocks {
+		if !seen[blk.BlockChecksum] {
+			seen[blk.BlockChecksum] = true
+			checksums = append(checksums, blk.BlockChecksum)
+		}
+	}
+	return checksums
+}
+
+// replicateBlocks copies blocks of the backup missing in the destination
+func replicateBlocks(r *replica, srcDriver ObjectStoreDriver) error {
+	checksums := getUniqueChecksums(r.backup)
+	return runTransfers(len(checksums), getTransferConcurrency(), func(worker, i int) error {
+		checksum := checksums[i]
+		log.Debugf("Replicate backup %v: blocks %v/%v", r.backup.Name, i+1, len(checksums))
+
+		exists, err := r.hasBlock(checksum)
+		if err != nil || exists {
+			return err
+		}
+		rc, err := srcDriver.Read(getBackupBlockFilePath(r.backup, checksum))
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		return r.writeBlock(checksum, data)
+	})
+}
+
+// replicateSingleFile copies the backup file through a temporary file, since
+// it could be too large to be kept in memory
+func replicateSingleFile(r *replica, srcDriver ObjectStoreDriver) error {
+	tmp, err := ioutil.TempFile("", "convoy-replicate-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := srcDriver.Download(r.backup.SingleFile.FilePath, tmp.Name()); err != nil {
+		return err
+	}
+	return r.writeFile(tmp.Name())
+}
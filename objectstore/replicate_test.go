@@ -0,0 +1,154 @@
+package objectstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+const (
+	drDestURL = "mem:///dr"
+)
+
+func countDriverBlocks(driver *memObjectStoreDriver) int {
+	count := 0
+	for file := range driver.files {
+		if strings.HasSuffix(file, ".blk") {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *TestSuite) TestReplicateBackup(c *check.C) {
+	dr := s.addDest(drDestURL)
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL1, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	backupURL2, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap10"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+
+	_, err = ReplicateBackup(backupURL1, memDestURL)
+	c.Assert(err, check.ErrorMatches, "Backup .* is in mem:///backups already")
+
+	replicaURL1, err := ReplicateBackup(backupURL1, drDestURL)
+	c.Assert(err, check.IsNil)
+	c.Assert(replicaURL1, check.Equals, encodeBackupURL(s.backupName(c, backupURL1), "vol1", drDestURL))
+	c.Assert(countDriverBlocks(dr), check.Equals, 2)
+	replica, err := loadVolume("vol1", dr)
+	c.Assert(err, check.IsNil)
+	c.Assert(replica.LastBackupName, check.Equals, "")
+
+	// Only blocks changed since the replicated backup are copied
+	replicaURL2, err := ReplicateBackup(backupURL2, drDestURL)
+	c.Assert(err, check.IsNil)
+	c.Assert(countDriverBlocks(dr), check.Equals, 3)
+	backup, err := loadBackup(s.backupName(c, backupURL2), "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	replicaBackup, err := loadBackup(s.backupName(c, backupURL2), "vol1", dr)
+	c.Assert(err, check.IsNil)
+	c.Assert(replicaBackup.Blocks, check.DeepEquals, backup.Blocks)
+	c.Assert(replicaBackup.Size.ChangedBytes, check.Equals, backup.Size.ChangedBytes)
+	// Only the block uploaded by the backup is copied
+	c.Assert(replicaBackup.Size.StoredBytes, check.Equals, backup.Size.StoredBytes)
+
+	// Replicating again copies nothing
+	writes := len(dr.files)
+	url, err := ReplicateBackup(backupURL2, drDestURL)
+	c.Assert(err, check.IsNil)
+	c.Assert(url, check.Equals, replicaURL2)
+	c.Assert(dr.files, check.HasLen, writes)
+
+	dir, err := ioutil.TempDir("", "objectstore-replicate")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	for i, snapshotName := range []string{"snap1", "snap10"} {
+		file := filepath.Join(dir, snapshotName)
+		c.Assert(RestoreDeltaBlockBackup([]string{replicaURL1, replicaURL2}[i], file), check.IsNil)
+		data, err := ioutil.ReadFile(file)
+		c.Assert(err, check.IsNil)
+		expected := make([]byte, volume.Size)
+		(&memSnapshotOps{}).ReadSnapshot(snapshotName, "vol1", 0, expected)
+		c.Assert(bytes.Equal(data, expected), check.Equals, true)
+	}
+
+	// Blocks of replicas are collected in the destination as usual
+	c.Assert(DeleteDeltaBlockBackup(replicaURL1), check.IsNil)
+	c.Assert(countDriverBlocks(dr), check.Equals, 2)
+	c.Assert(countDriverBlocks(s.driver), check.Equals, 3)
+}
+
+func (s *TestSuite) TestReplicateCorruptedBackup(c *check.C) {
+	dr := s.addDest(drDestURL)
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	s.driver.corruptBlocks = true
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+
+	_, err = ReplicateBackup(backupURL, drDestURL)
+	c.Assert(err, check.ErrorMatches, "Block .* of backup .* is corrupted.*")
+	c.Assert(backupExists(s.backupName(c, backupURL), "vol1", dr), check.Equals, false)
+}
+
+func (s *TestSuite) TestReplicateBlockPool(c *check.C) {
+	dr := s.addDest(drDestURL)
+	c.Assert(SetBlockPool(memDestURL), check.IsNil)
+	defer func() {
+		blockPoolsMutex.Lock()
+		delete(blockPools, memDestURL)
+		blockPoolsMutex.Unlock()
+	}()
+
+	backupURLs := []string{}
+	for _, volumeName := range []string{"vol1", "vol2"} {
+		volume := &Volume{
+			Name:   volumeName,
+			Driver: "loop",
+			Size:   2 * DEFAULT_BLOCK_SIZE,
+		}
+		backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+		c.Assert(err, check.IsNil)
+		_, err = ReplicateBackup(backupURL, drDestURL)
+		c.Assert(err, check.IsNil)
+		backupURLs = append(backupURLs, backupURL)
+	}
+	// Replicas share blocks in the pool of the destination as well
+	c.Assert(countDriverBlocks(dr), check.Equals, 2)
+	backup, err := loadBackup(s.backupName(c, backupURLs[1]), "vol2", dr)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.SharedBlocks, check.Equals, true)
+	for _, blk := range backup.Blocks {
+		c.Assert(dr.FileExists(getPoolBlockFilePath(blk.BlockChecksum)), check.Equals, true)
+		c.Assert(dr.FileExists(getPoolRefPath(blk.BlockChecksum, "vol1")), check.Equals, true)
+		c.Assert(dr.FileExists(getPoolRefPath(blk.BlockChecksum, "vol2")), check.Equals, true)
+	}
+}
+
+func (s *TestSuite) TestMirror(c *check.C) {
+	s.addDest(drDestURL)
+	defer func() {
+		mirrorsMutex.Lock()
+		delete(mirrors, memDestURL)
+		mirrorsMutex.Unlock()
+	}()
+
+	c.Assert(GetMirrors(memDestURL), check.HasLen, 0)
+	c.Assert(SetMirror(memDestURL, memDestURL), check.ErrorMatches, "Cannot mirror .* to itself")
+	c.Assert(SetMirror(memDestURL, "group://unknown"), check.ErrorMatches, "Destination group unknown doesn't exist")
+	c.Assert(SetMirror(memDestURL, drDestURL), check.IsNil)
+	c.Assert(SetMirror(memDestURL, drDestURL), check.IsNil)
+	c.Assert(GetMirrors(encodeBackupURL("backup1", "vol1", memDestURL)), check.DeepEquals, []string{drDestURL})
+	c.Assert(GetMirrors(drDestURL), check.HasLen, 0)
+}
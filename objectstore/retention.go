@@ -0,0 +1,320 @@
+package objectstore
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+/*
+RetentionPolicy decides which backups of a volume are kept by prune. A backup
+is kept if it's one of the Last most recent backups, or the most recent backup
+of one of the Daily most recent days, Weekly most recent weeks or Monthly most
+recent months having backups. The most recent backup is always kept.
+*/
+type RetentionPolicy struct {
+	Last    int
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// PruneResult lists URLs of backups of a volume kept, pruned, and expired but
+// kept until their locks expire, from newest to oldest
+type PruneResult struct {
+	Kept   []string
+	Pruned []string
+	Locked []string
+}
+
+var (
+	// Retention of backups in destinations, keyed by their canonical URLs,
+	// or defaultRetention if not set. nil means backups are kept forever
+	retentions       = make(map[string]*RetentionPolicy)
+	defaultRetention *RetentionPolicy
+	retentionsMutex  = &sync.RWMutex{}
+)
+
+// ParseRetentionPolicy parses policy in the form of comma separated
+// <rule>=<count>, where rule is last, daily, weekly or monthly
+func ParseRetentionPolicy(policy string) (*RetentionPolicy, error) {
+	p := &RetentionPolicy{}
+	for _, rule := range strings.Split(policy, ",") {
+		parts := strings.SplitN(strings.TrimSpace(rule), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid retention rule %q, should be <rule>=<count>", rule)
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("Invalid count of retention rule %q", rule)
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "last":
+			p.Last = count
+		case "daily":
+			p.Daily = count
+		case "weekly":
+			p.Weekly = count
+		case "monthly":
+			p.Monthly = count
+		default:
+			return nil, fmt.Errorf("Invalid retention rule %q, should be last, daily, weekly or monthly", rule)
+		}
+	}
+	return p, nil
+}
+
+func (p *RetentionPolicy) String() string {
+	return fmt.Sprintf("last=%v,daily=%v,weekly=%v,monthly=%v", p.Last, p.Daily, p.Weekly, p.Monthly)
+}
+
+// SetDefaultRetention sets retention of backups in destinations without
+// their own
+func SetDefaultRetention(policy string) error {
+	p, err := ParseRetentionPolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	retentionsMutex.Lock()
+	defer retentionsMutex.Unlock()
+
+	defaultRetention = p
+	return nil
+}
+
+// SetRetention sets retention of backups in destURL. Members of destination
+// groups are set individually.
+func SetRetention(destURL, policy string) error {
+	p, err := ParseRetentionPolicy(policy)
+	if err != nil {
+		return err
+	}
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return err
+	}
+
+	retentionsMutex.Lock()
+	defer retentionsMutex.Unlock()
+
+	retentions[driver.GetURL()] = p
+	return nil
+}
+
+// GetRetention returns retention of backups of volumeName in destURL, nil if
+// backups are kept forever
+func GetRetention(destURL, volumeName string) (*RetentionPolicy, error) {
+	destURL, err := ResolveDestURL(destURL, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	retentionsMutex.RLock()
+	defer retentionsMutex.RUnlock()
+
+	if p, exists := retentions[driver.GetURL()]; exists {
+		return p, nil
+	}
+	return defaultRetention, nil
+}
+
+type backupsByCreatedTime struct {
+	backups []*Backup
+	times   []time.Time
+}
+
+func (b backupsByCreatedTime) Len() int { return len(b.backups) }
+func (b backupsByCreatedTime) Swap(i, j int) {
+	b.backups[i], b.backups[j] = b.backups[j], b.backups[i]
+	b.times[i], b.times[j] = b.times[j], b.times[i]
+}
+func (b backupsByCreatedTime) Less(i, j int) bool {
+	return b.times[i].After(b.times[j])
+}
+
+/*
+applyRetention splits backups into kept and expired by policy, both from
+newest to oldest. Days, weeks and months are of the time zone backups were
+created in. Backups whose creation time cannot be parsed are always kept.
+*/
+func applyRetention(backups []*Backup, policy *RetentionPolicy) ([]*Backup, []*Backup) {
+	sorted := backupsByCreatedTime{
+		backups: []*Backup{},
+		times:   []time.Time{},
+	}
+	kept := []*Backup{}
+	for _, backup := range backups {
+		t, err := time.Parse(time.RubyDate, backup.CreatedTime)
+		if err != nil {
+			kept = append(kept, backup)
+			continue
+		}
+		sorted.backups = append(sorted.backups, backup)
+		sorted.times = append(sorted.times, t)
+	}
+	sort.Stable(sorted)
+
+	buckets := []struct {
+		count int
+		key   func(t time.Time) string
+		seen  map[string]bool
+	}{
+		{policy.Daily, func(t time.Time) string { return t.Format("2006-01-02") }, map[string]bool{}},
+		{policy.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%v-%v", year, week)
+		}, map[string]bool{}},
+		{policy.Monthly, func(t time.Time) string { return t.Format("2006-01") }, map[string]bool{}},
+	}
+	expired := []*Backup{}
+	for i, backup := range sorted.backups {
+		keep := i == 0 || i < policy.Last
+		for _, b := range buckets {
+			key := b.key(sorted.times[i])
+			if len(b.seen) < b.count && !b.seen[key] {
+				b.seen[key] = true
+				keep = true
+			}
+		}
+		if keep {
+			kept = append(kept, backup)
+		} else {
+			expired = append(expired, backup)
+		}
+	}
+	return kept, expired
+}
+
+// ListVolumeNames returns names of volumes with backups in destURL, or in
+// all members if destURL refers a destination group
+func ListVolumeNames(destURL string) ([]string, error) {
+	destURLs, err := expandDestURL(destURL)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, u := range destURLs {
+		driver, err := GetObjectStoreDriver(u)
+		if err != nil {
+			return nil, err
+		}
+		volumeNames, err := getVolumeNames(driver)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range volumeNames {
+			name = strings.TrimRight(name, "!")
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+/*
+PruneBackups deletes backups of volumeName in destURL expired by policy, then
+collects blocks no longer referenced by any backup left, once for all of
+them. Expired backups still locked are kept until a prune after their locks
+expire. Backups are only listed if dryRun is true.
+*/
+func PruneBackups(destURL, volumeName string, policy *RetentionPolicy, dryRun bool) (*PruneResult, error) {
+	destURL, err := ResolveDestURL(destURL, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return nil, err
+	}
+	volume, err := loadVolume(volumeName, driver)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot find volume %v in objectstore: %v", volumeName, err)
+	}
+	backupNames, err := getBackupNamesForVolume(volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+	backups := []*Backup{}
+	for _, backupName := range backupNames {
+		backup, err := loadBackup(backupName, volumeName, driver)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backup)
+	}
+
+	kept, retentionExpired := applyRetention(backups, policy)
+	result := &PruneResult{
+		Kept:   []string{},
+		Pruned: []string{},
+	}
+	for _, backup := range kept {
+		result.Kept = append(result.Kept, encodeBackupURL(backup.Name, volumeName, destURL))
+	}
+	expired := []*Backup{}
+	now := time.Now()
+	for _, backup := range retentionExpired {
+		if isBackupLocked(backup, now) {
+			result.Locked = append(result.Locked, encodeBackupURL(backup.Name, volumeName, destURL))
+			continue
+		}
+		expired = append(expired, backup)
+	}
+	if dryRun || len(expired) == 0 {
+		for _, backup := range expired {
+			result.Pruned = append(result.Pruned, encodeBackupURL(backup.Name, volumeName, destURL))
+		}
+		return result, nil
+	}
+
+	discardBlockSet := make(map[string]bool)
+	for _, backup := range expired {
+		if backup.SingleFile.FilePath != "" {
+			if err := driver.Remove(backup.SingleFile.FilePath); err != nil {
+				return result, err
+			}
+		}
+		if err := removeBackup(backup, driver); err != nil {
+			return result, err
+		}
+		for _, blk := range backup.Blocks {
+			discardBlockSet[blk.BlockChecksum] = true
+		}
+		if backup.Name == volume.LastBackupName {
+			volume.LastBackupName = ""
+			if err := saveVolume(volume, driver); err != nil {
+				return result, err
+			}
+		}
+		result.Pruned = append(result.Pruned, encodeBackupURL(backup.Name, volumeName, destURL))
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_REMOVE,
+		LOG_FIELD_VOLUME:   volumeName,
+		LOG_FIELD_DEST_URL: destURL,
+	}).Infof("Pruned %v backups of volume %v by retention %v, kept %v", len(result.Pruned), volumeName, policy, len(result.Kept))
+
+	if len(discardBlockSet) != 0 {
+		if err := collectGarbage(volumeName, discardBlockSet, driver); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
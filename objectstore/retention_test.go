@@ -0,0 +1,149 @@
+package objectstore
+
+import (
+	"bytes"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/rancher/convoy/util"
+)
+
+func (s *TestSuite) TestParseRetentionPolicy(c *check.C) {
+	p, err := ParseRetentionPolicy("last=3, daily=7,weekly=4,monthly=12")
+	c.Assert(err, check.IsNil)
+	c.Assert(p, check.DeepEquals, &RetentionPolicy{Last: 3, Daily: 7, Weekly: 4, Monthly: 12})
+	c.Assert(p.String(), check.Equals, "last=3,daily=7,weekly=4,monthly=12")
+
+	for _, policy := range []string{"", "last", "last=-1", "last=x", "yearly=1"} {
+		_, err := ParseRetentionPolicy(policy)
+		c.Assert(err, check.NotNil, check.Commentf("%v", policy))
+	}
+}
+
+func retentionBackups(times ...string) []*Backup {
+	backups := []*Backup{}
+	for i, t := range times {
+		created, err := time.Parse("2006-01-02 15:04", t)
+		if err != nil {
+			panic(err)
+		}
+		backups = append(backups, &Backup{
+			Name:        "backup-" + string(rune('a'+i)),
+			CreatedTime: created.Format(time.RubyDate),
+		})
+	}
+	return backups
+}
+
+func backupNames(backups []*Backup) []string {
+	names := []string{}
+	for _, backup := range backups {
+		names = append(names, backup.Name)
+	}
+	return names
+}
+
+func (s *TestSuite) TestApplyRetention(c *check.C) {
+	backups := retentionBackups(
+		"2026-03-02 10:00", // a, Monday
+		"2026-03-02 22:00", // b
+		"2026-03-01 22:00", // c, Sunday of previous week
+		"2026-02-28 22:00", // d
+		"2026-02-20 22:00", // e
+		"2026-01-15 22:00", // f
+	)
+	backups = append(backups, &Backup{Name: "broken", CreatedTime: "yesterday"})
+
+	kept, expired := applyRetention(backups, &RetentionPolicy{Last: 2})
+	c.Assert(backupNames(kept), check.DeepEquals, []string{"broken", "backup-b", "backup-a"})
+	c.Assert(backupNames(expired), check.DeepEquals, []string{"backup-c", "backup-d", "backup-e", "backup-f"})
+
+	kept, expired = applyRetention(backups, &RetentionPolicy{Daily: 3})
+	c.Assert(backupNames(kept), check.DeepEquals, []string{"broken", "backup-b", "backup-c", "backup-d"})
+	c.Assert(backupNames(expired), check.DeepEquals, []string{"backup-a", "backup-e", "backup-f"})
+
+	kept, _ = applyRetention(backups, &RetentionPolicy{Weekly: 2, Monthly: 2})
+	c.Assert(backupNames(kept), check.DeepEquals, []string{"broken", "backup-b", "backup-c", "backup-d"})
+
+	// Most recent backup is always kept
+	kept, _ = applyRetention(backups, &RetentionPolicy{})
+	c.Assert(backupNames(kept), check.DeepEquals, []string{"broken", "backup-b"})
+}
+
+func (s *TestSuite) TestPruneBackups(c *check.C) {
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   DEFAULT_BLOCK_SIZE,
+	}
+	c.Assert(addVolume(volume, s.driver), check.IsNil)
+	shared := util.GetChecksum([]byte("shared"))
+	blocks := map[string]string{}
+	for i, backup := range retentionBackups("2026-03-04 10:00", "2026-03-03 10:00", "2026-03-02 10:00") {
+		checksum := util.GetChecksum([]byte(backup.Name))
+		blocks[backup.Name] = checksum
+		backup.VolumeName = "vol1"
+		backup.Blocks = []BlockMapping{
+			{Offset: 0, BlockChecksum: checksum},
+			{Offset: DEFAULT_BLOCK_SIZE, BlockChecksum: shared},
+		}
+		c.Assert(saveBackup(backup, s.driver), check.IsNil)
+		for _, blk := range backup.Blocks {
+			c.Assert(s.driver.Write(getBlockFilePath("vol1", blk.BlockChecksum), bytes.NewReader([]byte{byte(i)})), check.IsNil)
+		}
+	}
+	c.Assert(s.countBlocks(), check.Equals, 4)
+
+	policy := &RetentionPolicy{Last: 2}
+	result, err := PruneBackups(memDestURL, "vol1", policy, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, &PruneResult{
+		Kept: []string{
+			encodeBackupURL("backup-a", "vol1", memDestURL),
+			encodeBackupURL("backup-b", "vol1", memDestURL),
+		},
+		Pruned: []string{encodeBackupURL("backup-c", "vol1", memDestURL)},
+	})
+	c.Assert(backupExists("backup-c", "vol1", s.driver), check.Equals, true)
+
+	result, err = PruneBackups(memDestURL, "vol1", policy, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Pruned, check.DeepEquals, []string{encodeBackupURL("backup-c", "vol1", memDestURL)})
+	c.Assert(backupExists("backup-c", "vol1", s.driver), check.Equals, false)
+	// Only the block not shared with backups kept is collected
+	c.Assert(s.countBlocks(), check.Equals, 3)
+	c.Assert(s.driver.FileExists(getBlockFilePath("vol1", blocks["backup-c"])), check.Equals, false)
+	c.Assert(s.driver.FileExists(getBlockFilePath("vol1", shared)), check.Equals, true)
+
+	// Short names are padded in objectstore
+	c.Assert(addVolume(&Volume{Name: "v0", Driver: "loop", Size: DEFAULT_BLOCK_SIZE}, s.driver), check.IsNil)
+	names, err := ListVolumeNames(memDestURL)
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{"v0", "vol1"})
+}
+
+func (s *TestSuite) TestGetRetention(c *check.C) {
+	defer func() {
+		retentionsMutex.Lock()
+		delete(retentions, memDestURL)
+		defaultRetention = nil
+		retentionsMutex.Unlock()
+	}()
+
+	p, err := GetRetention(memDestURL, "vol1")
+	c.Assert(err, check.IsNil)
+	c.Assert(p, check.IsNil)
+
+	c.Assert(SetDefaultRetention("daily=7"), check.IsNil)
+	p, err = GetRetention(memDestURL, "vol1")
+	c.Assert(err, check.IsNil)
+	c.Assert(p, check.DeepEquals, &RetentionPolicy{Daily: 7})
+
+	c.Assert(SetRetention(memDestURL, "last=3"), check.IsNil)
+	c.Assert(SetDestinationGroup("fleet", []string{memDestURL}), check.IsNil)
+	defer RemoveDestinationGroup("fleet")
+	p, err = GetRetention("group://fleet", "vol1")
+	c.Assert(err, check.IsNil)
+	c.Assert(p, check.DeepEquals, &RetentionPolicy{Last: 3})
+}
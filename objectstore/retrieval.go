@@ -0,0 +1,95 @@
+package objectstore
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	BLOCK_FILE_SUFFIX       = ".blk"
+	SINGLE_FILE_FILE_SUFFIX = ".bak"
+)
+
+// IsBackupData returns whether the object at path is data of backups, i.e.
+// a block or the file of a single file backup, rather than a config. Drivers
+// may store data differently, e.g. in a cheaper storage class.
+func IsBackupData(path string) bool {
+	return strings.HasSuffix(path, BLOCK_FILE_SUFFIX) || strings.HasSuffix(path, SINGLE_FILE_FILE_SUFFIX)
+}
+
+/*
+ObjectRetriever is implemented by drivers whose objects may be archived, e.g.
+S3 objects in GLACIER storage class, which cannot be read until they're
+retrieved. Read of archived objects returns RetrievalRequiredError.
+*/
+type ObjectRetriever interface {
+	// RetrieveObject requests retrieval of the object if it's archived,
+	// returns true if it can be read already
+	RetrieveObject(path string) (bool, error)
+}
+
+type RetrievalRequiredError struct {
+	Path string
+}
+
+func (e *RetrievalRequiredError) Error() string {
+	return fmt.Sprintf("%v is archived and has to be retrieved before it can be read", e.Path)
+}
+
+func isRetrievalRequired(err error) bool {
+	_, ok := err.(*RetrievalRequiredError)
+	return ok
+}
+
+// getBackupDataPaths returns paths of blocks or the file of the backup
+func getBackupDataPaths(backup *Backup) []string {
+	if backup.SingleFile.FilePath != "" {
+		return []string{backup.SingleFile.FilePath}
+	}
+	paths := []string{}
+	for _, checksum := range getUniqueChecksums(backup) {
+		paths = append(paths, getBackupBlockFilePath(backup, checksum))
+	}
+	return paths
+}
+
+/*
+retrieveBackup handles err of reading the backup. If objects of the backup
+are archived, retrieval of all of them is requested at once, rather than one
+by one as restores run into them, and an error telling how many are still
+being retrieved is returned, so the restore can be run again once they're
+available. Otherwise err is returned as it is.
+*/
+func retrieveBackup(backup *Backup, driver ObjectStoreDriver, err error) error {
+	if !isRetrievalRequired(err) {
+		return err
+	}
+	retriever, ok := driver.(ObjectRetriever)
+	if !ok {
+		return err
+	}
+	paths := getBackupDataPaths(backup)
+	pending := 0
+	mutex := &sync.Mutex{}
+	if err := runTransfers(len(paths), getTransferConcurrency(), func(worker, i int) error {
+		ready, err := retriever.RetrieveObject(paths[i])
+		if err != nil {
+			return err
+		}
+		if !ready {
+			mutex.Lock()
+			pending++
+			mutex.Unlock()
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Failed to request retrieval of archived backup %v: %v", backup.Name, err)
+	}
+	if pending == 0 {
+		return fmt.Errorf("Backup %v has been retrieved from archive, try again: %v", backup.Name, err)
+	}
+	log.Infof("Requested retrieval of %v of %v objects of archived backup %v", pending, len(paths), backup.Name)
+	return fmt.Errorf("Backup %v is archived, %v of %v objects are being retrieved, try again once they're retrieved, which may take hours",
+		backup.Name, pending, len(paths))
+}
@@ -0,0 +1,56 @@
+package objectstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestIsBackupData(c *check.C) {
+	backup := &Backup{Name: "backup1", VolumeName: "vol1"}
+	c.Assert(IsBackupData(getBlockFilePath("vol1", "0123456789abcdef")), check.Equals, true)
+	c.Assert(IsBackupData(getPoolBlockFilePath("0123456789abcdef")), check.Equals, true)
+	c.Assert(IsBackupData(getSingleFileBackupFilePath(backup)), check.Equals, true)
+	c.Assert(IsBackupData(getBackupConfigPath("backup1", "vol1")), check.Equals, false)
+	c.Assert(IsBackupData(getVolumeFilePath("vol1")), check.Equals, false)
+	c.Assert(IsBackupData(getCatalogFilePath()), check.Equals, false)
+}
+
+func (s *TestSuite) TestRestoreArchivedBackup(c *check.C) {
+	dir, err := ioutil.TempDir("", "objectstore-retrieval")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   4 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+
+	// Configs are never archived, blocks are
+	s.driver.archived = make(map[string]bool)
+	blocks := 0
+	for name := range s.driver.files {
+		if strings.HasSuffix(name, BLOCK_FILE_SUFFIX) {
+			s.driver.archived[name] = true
+			blocks++
+		}
+	}
+	info, err := GetBackupInfo(backupURL)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["BackupName"], check.Not(check.Equals), "")
+
+	// Retrieval of every block is requested by the first restore
+	file := filepath.Join(dir, "snap1")
+	err = RestoreDeltaBlockBackup(backupURL, file)
+	c.Assert(err, check.ErrorMatches, ".*is archived, [0-9]+ of [0-9]+ objects are being retrieved.*")
+	c.Assert(s.driver.retrievals, check.Equals, blocks)
+
+	s.driver.archived = nil
+	c.Assert(RestoreDeltaBlockBackup(backupURL, file), check.IsNil)
+}
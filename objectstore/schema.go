@@ -0,0 +1,220 @@
+package objectstore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	// SCHEMA_VERSION is the version of configs of volumes and backups
+	// written to objectstore. Configs written before they're versioned are
+	// version 1.
+	SCHEMA_VERSION = 2
+)
+
+/*
+schemaMigration upgrades configs of the version before to version. Configs
+are upgraded as they're loaded, so every version of them can be read, and
+MigrateObjectStore writes upgraded configs back to objectstore, so they won't
+depend on defaults of older versions, which may change.
+*/
+type schemaMigration struct {
+	version     int
+	description string
+	volume      func(v *Volume)
+	backup      func(b *Backup)
+}
+
+var schemaMigrations = []schemaMigration{
+	{
+		version:     2,
+		description: "block size and compression of delta block backups are recorded",
+		backup: func(b *Backup) {
+			if b.SingleFile.FilePath != "" {
+				return
+			}
+			if b.BlockSize == 0 {
+				b.BlockSize = DEFAULT_BLOCK_SIZE
+			}
+			if b.Compression == "" {
+				b.Compression = DEFAULT_COMPRESSION
+			}
+		},
+	},
+}
+
+func checkSchemaVersion(version int, path string) (int, error) {
+	if version == 0 {
+		version = 1
+	}
+	if version > SCHEMA_VERSION {
+		return 0, fmt.Errorf("%v is of schema version %v, newer than version %v supported, Convoy needs to be upgraded", path, version, SCHEMA_VERSION)
+	}
+	return version, nil
+}
+
+// upgradeVolume upgrades the config of volume to SCHEMA_VERSION, returns
+// false if it's up to date
+func upgradeVolume(v *Volume, path string) (bool, error) {
+	version, err := checkSchemaVersion(v.SchemaVersion, path)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range schemaMigrations {
+		if m.version > version && m.volume != nil {
+			m.volume(v)
+		}
+	}
+	v.SchemaVersion = SCHEMA_VERSION
+	return version != SCHEMA_VERSION, nil
+}
+
+// upgradeBackup upgrades the config of backup to SCHEMA_VERSION, returns
+// false if it's up to date
+func upgradeBackup(b *Backup, path string) (bool, error) {
+	version, err := checkSchemaVersion(b.SchemaVersion, path)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range schemaMigrations {
+		if m.version > version && m.backup != nil {
+			m.backup(b)
+		}
+	}
+	b.SchemaVersion = SCHEMA_VERSION
+	return version != SCHEMA_VERSION, nil
+}
+
+type migrationResult struct {
+	volumes, backups   int
+	migratedVolumes    int
+	migratedBackups    int
+	unsupportedConfigs int
+}
+
+/*
+MigrateObjectStore upgrades configs of volumes and backups in destURL to
+SCHEMA_VERSION in place, or all members of destination group destURL, and
+returns the numbers of configs found and migrated. Configs are overwritten
+rather than removed and saved again, so backups stay readable while they're
+migrated. Configs of versions newer than supported are left as they are.
+*/
+func MigrateObjectStore(destURL string, dryRun bool) (map[string]string, error) {
+	destURLs, err := expandDestURL(destURL)
+	if err != nil {
+		return nil, err
+	}
+	result := &migrationResult{}
+	for _, u := range destURLs {
+		driver, err := GetObjectStoreDriver(u)
+		if err != nil {
+			return nil, err
+		}
+		if err := migrateObjectStore(driver, dryRun, result); err != nil {
+			return nil, err
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_MIGRATE,
+		LOG_FIELD_DEST_URL: destURL,
+	}).Infof("Migrated %v of %v volumes and %v of %v backups to schema version %v, dry run %v",
+		result.migratedVolumes, result.volumes, result.migratedBackups, result.backups, SCHEMA_VERSION, dryRun)
+	info := map[string]string{
+		"DestURL":            destURL,
+		"SchemaVersion":      strconv.Itoa(SCHEMA_VERSION),
+		"Volumes":            strconv.Itoa(result.volumes),
+		"Backups":            strconv.Itoa(result.backups),
+		"MigratedVolumes":    strconv.Itoa(result.migratedVolumes),
+		"MigratedBackups":    strconv.Itoa(result.migratedBackups),
+		"UnsupportedConfigs": strconv.Itoa(result.unsupportedConfigs),
+	}
+	if dryRun {
+		info["DryRun"] = "true"
+	}
+	return info, nil
+}
+
+func migrateObjectStore(driver ObjectStoreDriver, dryRun bool, result *migrationResult) error {
+	volumeNames, err := getVolumeNames(driver)
+	if err != nil {
+		return err
+	}
+	for _, volumeName := range volumeNames {
+		volumeName = strings.TrimRight(volumeName, "!")
+		volumePath := getVolumeFilePath(volumeName)
+		if !driver.FileExists(volumePath) {
+			// Removed since listed
+			continue
+		}
+		volume := &Volume{}
+		if err := loadConfigInObjectStore(volumePath, driver, volume); err != nil {
+			return err
+		}
+		result.volumes++
+		migrated := false
+		upgraded, err := upgradeVolume(volume, volumePath)
+		if err != nil {
+			log.Warnf("Skip migration of volume %v: %v", volumeName, err)
+			result.unsupportedConfigs++
+		} else if upgraded {
+			result.migratedVolumes++
+			migrated = true
+			if err := saveMigratedConfig(volumePath, volume, driver, dryRun); err != nil {
+				return err
+			}
+		}
+
+		backupNames, err := getBackupNamesForVolume(volumeName, driver)
+		if err != nil {
+			return err
+		}
+		for _, backupName := range backupNames {
+			backupPath := getBackupConfigPath(backupName, volumeName)
+			if !driver.FileExists(backupPath) {
+				continue
+			}
+			backup := &Backup{}
+			if err := loadConfigInObjectStore(backupPath, driver, backup); err != nil {
+				return err
+			}
+			result.backups++
+			upgraded, err := upgradeBackup(backup, backupPath)
+			if err != nil {
+				log.Warnf("Skip migration of backup %v of volume %v: %v", backupName, volumeName, err)
+				result.unsupportedConfigs++
+			} else if upgraded {
+				result.migratedBackups++
+				migrated = true
+				if err := saveMigratedConfig(backupPath, backup, driver, dryRun); err != nil {
+					return err
+				}
+			}
+		}
+		if migrated && !dryRun {
+			updateIndex(driver, func(idx *backupIndex) error {
+				return idx.refreshVolume(volumeName, driver)
+			})
+		}
+	}
+	return nil
+}
+
+// saveMigratedConfig overwrites the config at path unless it's a dry run
+func saveMigratedConfig(path string, v interface{}, driver ObjectStoreDriver, dryRun bool) error {
+	if dryRun {
+		log.Debugf("Would migrate %v to schema version %v", path, SCHEMA_VERSION)
+		return nil
+	}
+	if err := saveConfigInObjectStore(path, driver, v); err != nil {
+		return err
+	}
+	log.Debugf("Migrated %v to schema version %v", path, SCHEMA_VERSION)
+	return nil
+}
@@ -0,0 +1,93 @@
+package objectstore
+
+import (
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestMigrateObjectStore(c *check.C) {
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	backupName := s.backupName(c, backupURL)
+	backup, err := loadBackup(backupName, "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.SchemaVersion, check.Equals, SCHEMA_VERSION)
+
+	// Configs written before versioning, without block size and compression
+	backupPath := getBackupConfigPath(backupName, "vol1")
+	volumePath := getVolumeFilePath("vol1")
+	legacyVolume, err := loadVolume("vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	legacyVolume.SchemaVersion = 0
+	c.Assert(saveConfigInObjectStore(volumePath, s.driver, legacyVolume), check.IsNil)
+	backup.SchemaVersion = 0
+	backup.BlockSize = 0
+	backup.Compression = ""
+	c.Assert(saveConfigInObjectStore(backupPath, s.driver, backup), check.IsNil)
+
+	// Legacy configs are upgraded as they're loaded
+	loaded, err := loadBackup(backupName, "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(loaded.SchemaVersion, check.Equals, SCHEMA_VERSION)
+	c.Assert(loaded.BlockSize, check.Equals, int64(DEFAULT_BLOCK_SIZE))
+	c.Assert(loaded.Compression, check.Equals, DEFAULT_COMPRESSION)
+
+	info, err := MigrateObjectStore(memDestURL, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["MigratedVolumes"], check.Equals, "1")
+	c.Assert(info["MigratedBackups"], check.Equals, "1")
+	c.Assert(info["DryRun"], check.Equals, "true")
+	raw := &Backup{}
+	c.Assert(loadConfigInObjectStore(backupPath, s.driver, raw), check.IsNil)
+	c.Assert(raw.SchemaVersion, check.Equals, 0)
+
+	info, err = MigrateObjectStore(memDestURL, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["Volumes"], check.Equals, "1")
+	c.Assert(info["Backups"], check.Equals, "1")
+	c.Assert(info["MigratedVolumes"], check.Equals, "1")
+	c.Assert(info["MigratedBackups"], check.Equals, "1")
+	raw = &Backup{}
+	c.Assert(loadConfigInObjectStore(backupPath, s.driver, raw), check.IsNil)
+	c.Assert(raw.SchemaVersion, check.Equals, SCHEMA_VERSION)
+	c.Assert(raw.BlockSize, check.Equals, int64(DEFAULT_BLOCK_SIZE))
+	c.Assert(raw.Compression, check.Equals, DEFAULT_COMPRESSION)
+
+	// Migrating again writes nothing
+	info, err = MigrateObjectStore(memDestURL, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["MigratedVolumes"], check.Equals, "0")
+	c.Assert(info["MigratedBackups"], check.Equals, "0")
+}
+
+func (s *TestSuite) TestNewerSchemaVersion(c *check.C) {
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	backupName := s.backupName(c, backupURL)
+	backupPath := getBackupConfigPath(backupName, "vol1")
+	backup, err := loadBackup(backupName, "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	backup.SchemaVersion = SCHEMA_VERSION + 1
+	c.Assert(saveConfigInObjectStore(backupPath, s.driver, backup), check.IsNil)
+
+	_, err = loadBackup(backupName, "vol1", s.driver)
+	c.Assert(err, check.ErrorMatches, ".* is of schema version .*, newer than version .* supported.*")
+
+	// Configs of newer versions are left as they are
+	info, err := MigrateObjectStore(memDestURL, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["UnsupportedConfigs"], check.Equals, "1")
+	c.Assert(info["MigratedBackups"], check.Equals, "0")
+	raw := &Backup{}
+	c.Assert(loadConfigInObjectStore(backupPath, s.driver, raw), check.IsNil)
+	c.Assert(raw.SchemaVersion, check.Equals, SCHEMA_VERSION+1)
+}
@@ -0,0 +1,233 @@
+package objectstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+var (
+	scrubRand  = rand.New(rand.NewSource(time.Now().UnixNano()))
+	scrubMutex = &sync.Mutex{}
+)
+
+// ScrubDamage is a block or file of backups found missing or corrupted
+type ScrubDamage struct {
+	Path    string
+	Missing bool
+	Reason  string
+	// Backups referencing the object, which cannot be restored, and their
+	// volumes
+	BackupURLs  []string
+	VolumeNames []string
+}
+
+type ScrubResult struct {
+	DestURL string
+	// Objects read and verified, or only checked to exist for files of
+	// single file backups
+	Checked int
+	// Archived objects, which cannot be read without retrieval
+	Skipped int
+	Damages []ScrubDamage
+}
+
+// scrubObject is an object referenced by backups, with the checksum of its
+// data, or empty for files of single file backups
+type scrubObject struct {
+	path        string
+	checksum    string
+	backupURLs  []string
+	volumeNames []string
+}
+
+// listScrubObjects returns objects referenced by every backup in destination,
+// sorted by path
+func listScrubObjects(driver ObjectStoreDriver) ([]*scrubObject, error) {
+	volumeNames, err := getVolumeNames(driver)
+	if err != nil {
+		return nil, err
+	}
+	objects := make(map[string]*scrubObject)
+	for _, volumeName := range volumeNames {
+		// Short volume names are padded with '!' in objectstore
+		volumeName = strings.TrimRight(volumeName, "!")
+		backupNames, err := getBackupNamesForVolume(volumeName, driver)
+		if err != nil {
+			return nil, err
+		}
+		for _, backupName := range backupNames {
+			backup, err := loadBackup(backupName, volumeName, driver)
+			if err != nil {
+				// Removed since listed
+				if !backupExists(backupName, volumeName, driver) {
+					continue
+				}
+				return nil, err
+			}
+			backupURL := encodeBackupURL(backupName, volumeName, driver.GetURL())
+			checksums := map[string]string{}
+			if backup.SingleFile.FilePath != "" {
+				checksums[backup.SingleFile.FilePath] = ""
+			}
+			for _, checksum := range getUniqueChecksums(backup) {
+				checksums[getBackupBlockFilePath(backup, checksum)] = checksum
+			}
+			for path, checksum := range checksums {
+				obj, exists := objects[path]
+				if !exists {
+					obj = &scrubObject{
+						path:     path,
+						checksum: checksum,
+					}
+					objects[path] = obj
+				}
+				obj.backupURLs = append(obj.backupURLs, backupURL)
+				// Backups are listed volume by volume
+				if n := len(obj.volumeNames); n == 0 || obj.volumeNames[n-1] != volumeName {
+					obj.volumeNames = append(obj.volumeNames, volumeName)
+				}
+			}
+		}
+	}
+	paths := []string{}
+	for path := range objects {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	result := []*scrubObject{}
+	for _, path := range paths {
+		result = append(result, objects[path])
+	}
+	return result, nil
+}
+
+// sampleScrubObjects returns a random sample of percent of objects, at least
+// one of them if there's any
+func sampleScrubObjects(objects []*scrubObject, percent int) []*scrubObject {
+	if percent >= 100 {
+		return objects
+	}
+	count := len(objects) * percent / 100
+	if count == 0 && len(objects) != 0 {
+		count = 1
+	}
+	scrubMutex.Lock()
+	perm := scrubRand.Perm(len(objects))
+	scrubMutex.Unlock()
+
+	sample := []*scrubObject{}
+	for _, i := range perm[:count] {
+		sample = append(sample, objects[i])
+	}
+	return sample
+}
+
+/*
+scrub verifies the object exists, and the checksum of its data if recorded.
+It returns the damage found, or nil if there's none, and whether the object
+is archived. Failures to open objects which exist are returned as errors,
+since they're not known to be damaged, while failures to read their data,
+e.g. decryption, are taken as damages.
+*/
+func (obj *scrubObject) scrub(driver ObjectStoreDriver) (*ScrubDamage, bool, error) {
+	damage := &ScrubDamage{
+		Path:        obj.path,
+		BackupURLs:  obj.backupURLs,
+		VolumeNames: obj.volumeNames,
+	}
+	if !driver.FileExists(obj.path) {
+		damage.Missing = true
+		damage.Reason = "missing"
+		return damage, false, nil
+	}
+	if obj.checksum == "" {
+		return nil, false, nil
+	}
+	rc, err := driver.Read(obj.path)
+	if err != nil {
+		if isRetrievalRequired(err) {
+			return nil, true, nil
+		}
+		if !driver.FileExists(obj.path) {
+			// Collected since listed
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("Cannot read %v for scrubbing: %v", obj.path, err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err == nil {
+		_, err = decompressBlock(data, obj.checksum)
+	}
+	if err == nil {
+		return nil, false, nil
+	}
+	damage.Reason = err.Error()
+	return damage, false, nil
+}
+
+/*
+ScrubDestination verifies blocks of backups in destURL against the checksums
+recorded in the backups, and that files of single file backups exist, so
+objects lost or corrupted in objectstore would be found before restores need
+them. A random sample of percent of the objects is verified, or all of them
+if percent is 100. Damaged objects are only reported, since other backups
+may still be restorable from them.
+*/
+func ScrubDestination(destURL string, percent int) (*ScrubResult, error) {
+	if percent <= 0 || percent > 100 {
+		return nil, fmt.Errorf("Invalid scrub percentage %v, should be between 1 and 100", percent)
+	}
+	driver, err := GetObjectStoreDriver(destURL)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := listScrubObjects(driver)
+	if err != nil {
+		return nil, err
+	}
+	sample := sampleScrubObjects(objects, percent)
+
+	result := &ScrubResult{
+		DestURL: driver.GetURL(),
+	}
+	damages := make([]*ScrubDamage, len(sample))
+	skipped := make([]bool, len(sample))
+	if err := runTransfers(len(sample), getTransferConcurrency(), func(worker, i int) error {
+		damage, archived, err := sample[i].scrub(driver)
+		if err != nil {
+			return err
+		}
+		damages[i] = damage
+		skipped[i] = archived
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for i, damage := range damages {
+		if skipped[i] {
+			result.Skipped++
+			continue
+		}
+		result.Checked++
+		if damage == nil {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON:   LOG_REASON_FAILURE,
+			LOG_FIELD_EVENT:    LOG_EVENT_VERIFY,
+			LOG_FIELD_DEST_URL: result.DestURL,
+		}).Errorf("Scrub found %v damaged, referenced by %v backups: %v", damage.Path, len(damage.BackupURLs), damage.Reason)
+		result.Damages = append(result.Damages, *damage)
+	}
+	return result, nil
+}
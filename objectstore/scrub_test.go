@@ -0,0 +1,67 @@
+package objectstore
+
+import (
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestScrubDestination(c *check.C) {
+	volume := &Volume{
+		Name:   "v1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(s.backupName(c, backupURL), "v1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.countBlocks(), check.Equals, 2)
+
+	_, err = ScrubDestination(memDestURL, 0)
+	c.Assert(err, check.ErrorMatches, "Invalid scrub percentage.*")
+
+	result, err := ScrubDestination(memDestURL, 100)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Checked, check.Equals, 2)
+	c.Assert(result.Damages, check.HasLen, 0)
+
+	// Sample has at least one block
+	result, err = ScrubDestination(memDestURL, 1)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Checked, check.Equals, 1)
+
+	corrupted := getBlockFilePath("v1", backup.Blocks[0].BlockChecksum)
+	missing := getBlockFilePath("v1", backup.Blocks[1].BlockChecksum)
+	s.driver.files[filepath.Clean(corrupted)][0] ^= 0xff
+	c.Assert(s.driver.Remove(missing), check.IsNil)
+	s.driver.archived = map[string]bool{}
+	result, err = ScrubDestination(memDestURL, 100)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Checked, check.Equals, 2)
+	c.Assert(result.Damages, check.HasLen, 2)
+	for _, damage := range result.Damages {
+		c.Assert(damage.BackupURLs, check.DeepEquals, []string{backupURL})
+		c.Assert(damage.VolumeNames, check.DeepEquals, []string{"v1"})
+		switch damage.Path {
+		case corrupted:
+			c.Assert(damage.Missing, check.Equals, false)
+			c.Assert(damage.Reason, check.Matches, ".*[Cc]hecksum.*|Cannot decompress.*")
+		case missing:
+			c.Assert(damage.Missing, check.Equals, true)
+		default:
+			c.Fatalf("Unexpected damage of %v", damage.Path)
+		}
+	}
+	// Damaged blocks are kept
+	c.Assert(s.countBlocks(), check.Equals, 1)
+
+	// Archived blocks are skipped rather than retrieved
+	s.driver.archived[filepath.Clean(corrupted)] = true
+	result, err = ScrubDestination(memDestURL, 100)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Checked, check.Equals, 1)
+	c.Assert(result.Skipped, check.Equals, 1)
+	c.Assert(result.Damages, check.HasLen, 1)
+	c.Assert(s.driver.retrievals, check.Equals, 0)
+}
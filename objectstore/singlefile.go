@@ -2,6 +2,7 @@ package objectstore
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 
 	"github.com/Sirupsen/logrus"
@@ -19,11 +20,15 @@ type BackupFile struct {
 }
 
 func getSingleFileBackupFilePath(sfBackup *Backup) string {
-	backupFileName := sfBackup.Name + ".bak"
+	backupFileName := sfBackup.Name + SINGLE_FILE_FILE_SUFFIX
 	return filepath.Join(getVolumePath(sfBackup.VolumeName), BACKUP_FILES_DIRECTORY, backupFileName)
 }
 
 func CreateSingleFileBackup(volume *Volume, snapshot *Snapshot, filePath, destURL string) (string, error) {
+	destURL, err := ResolveDestURL(destURL, volume.Name)
+	if err != nil {
+		return "", err
+	}
 	driver, err := GetObjectStoreDriver(destURL)
 	if err != nil {
 		return "", err
@@ -51,9 +56,18 @@ func CreateSingleFileBackup(volume *Volume, snapshot *Snapshot, filePath, destUR
 		VolumeName:        volume.Name,
 		SnapshotName:      snapshot.Name,
 		SnapshotCreatedAt: snapshot.CreatedTime,
+		Labels:            snapshot.Labels,
 	}
 	backup.SingleFile.FilePath = getSingleFileBackupFilePath(backup)
 
+	st, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	backup.Size = &BackupSize{
+		ChangedBytes: st.Size(),
+		StoredBytes:  st.Size(),
+	}
 	if err := driver.Upload(filePath, backup.SingleFile.FilePath); err != nil {
 		return "", err
 	}
@@ -98,7 +112,7 @@ func RestoreSingleFileBackup(backupURL, path string) (string, error) {
 
 	dstFile := filepath.Join(path, filepath.Base(backup.SingleFile.FilePath))
 	if err := driver.Download(backup.SingleFile.FilePath, dstFile); err != nil {
-		return "", err
+		return "", retrieveBackup(backup, driver, err)
 	}
 
 	return dstFile, nil
@@ -124,6 +138,9 @@ func DeleteSingleFileBackup(backupURL string) error {
 	if err != nil {
 		return err
 	}
+	if err := checkBackupLock(backup); err != nil {
+		return err
+	}
 
 	if err := driver.Remove(backup.SingleFile.FilePath); err != nil {
 		return err
@@ -0,0 +1,95 @@
+package objectstore
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	DEFAULT_TRANSFER_CONCURRENCY = 4
+	MAX_TRANSFER_CONCURRENCY     = 64
+)
+
+var (
+	// Blocks transferred at the same time by a backup or restore
+	transferConcurrency      = DEFAULT_TRANSFER_CONCURRENCY
+	transferConcurrencyMutex = &sync.RWMutex{}
+)
+
+/*
+SetTransferConcurrency sets how many blocks a delta block backup uploads, or a
+restore downloads, at the same time. Latency of objectstores rather than
+bandwidth usually limits serial transfers, so large volumes benefit from more
+blocks in flight, at the cost of a block sized buffer for each of them.
+*/
+func SetTransferConcurrency(concurrency int) error {
+	if concurrency < 1 || concurrency > MAX_TRANSFER_CONCURRENCY {
+		return fmt.Errorf("Invalid transfer concurrency %v, should be between 1 and %v",
+			concurrency, MAX_TRANSFER_CONCURRENCY)
+	}
+
+	transferConcurrencyMutex.Lock()
+	defer transferConcurrencyMutex.Unlock()
+
+	transferConcurrency = concurrency
+	return nil
+}
+
+func getTransferConcurrency() int {
+	transferConcurrencyMutex.RLock()
+	defer transferConcurrencyMutex.RUnlock()
+
+	return transferConcurrency
+}
+
+/*
+runTransfers calls transfer for 0 to count-1 from a pool of at most
+concurrency workers, numbered from 0, so workers can own buffers. Transfers
+not started yet are skipped after the first failure, and its error is
+returned once running transfers complete.
+*/
+func runTransfers(count, concurrency int, transfer func(worker, i int) error) error {
+	workers := concurrency
+	if workers > count {
+		workers = count
+	}
+
+	var (
+		mutex    sync.Mutex
+		next     int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	claim := func() (int, bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if firstErr != nil || next == count {
+			return 0, false
+		}
+		next++
+		return next - 1, true
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for {
+				i, ok := claim()
+				if !ok {
+					return
+				}
+				if err := transfer(worker, i); err != nil {
+					mutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mutex.Unlock()
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return firstErr
+}
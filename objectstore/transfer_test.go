@@ -0,0 +1,96 @@
+package objectstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestRunTransfers(c *check.C) {
+	c.Assert(SetTransferConcurrency(0), check.ErrorMatches, "Invalid transfer concurrency.*")
+	c.Assert(SetTransferConcurrency(MAX_TRANSFER_CONCURRENCY+1), check.ErrorMatches, "Invalid transfer concurrency.*")
+
+	done := make([]int32, 100)
+	err := runTransfers(len(done), 8, func(worker, i int) error {
+		c.Check(worker >= 0 && worker < 8, check.Equals, true)
+		atomic.AddInt32(&done[i], 1)
+		return nil
+	})
+	c.Assert(err, check.IsNil)
+	for i := range done {
+		c.Assert(done[i], check.Equals, int32(1))
+	}
+
+	// Transfers after the failure are skipped
+	var started int32
+	err = runTransfers(1000, 4, func(worker, i int) error {
+		atomic.AddInt32(&started, 1)
+		if i == 10 {
+			return fmt.Errorf("block %v failed", i)
+		}
+		return nil
+	})
+	c.Assert(err, check.ErrorMatches, "block 10 failed")
+	c.Assert(started < 1000, check.Equals, true)
+
+	c.Assert(runTransfers(0, 4, func(worker, i int) error {
+		return fmt.Errorf("unexpected transfer")
+	}), check.IsNil)
+}
+
+func (s *TestSuite) TestConcurrentBackup(c *check.C) {
+	c.Assert(SetTransferConcurrency(8), check.IsNil)
+	defer SetTransferConcurrency(DEFAULT_TRANSFER_CONCURRENCY)
+
+	dir, err := ioutil.TempDir("", "objectstore-restore")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+
+	// Blocks of each half of the volume are identical, so workers would
+	// race on uploading them
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	blockSize := int64(MIN_BLOCK_SIZE)
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, blockSize, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.countBlocks(), check.Equals, 2)
+
+	backup, err := loadBackup(s.backupName(c, backupURL), "vol1", s.driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.Blocks, check.HasLen, int(volume.Size/blockSize))
+	for i, block := range backup.Blocks {
+		c.Assert(block.Offset, check.Equals, int64(i)*blockSize)
+	}
+	stored := 0
+	for name, data := range s.driver.files {
+		if strings.HasSuffix(name, ".blk") {
+			stored += len(data)
+		}
+	}
+	c.Assert(backup.Size, check.DeepEquals, &BackupSize{
+		ChangedBytes: volume.Size,
+		StoredBytes:  int64(stored),
+	})
+	info, err := GetBackupInfo(backupURL)
+	c.Assert(err, check.IsNil)
+	c.Assert(info["ChangedSize"], check.Equals, strconv.FormatInt(volume.Size, 10))
+	c.Assert(info["StoredSize"], check.Equals, strconv.Itoa(stored))
+
+	file := filepath.Join(dir, "snap1")
+	c.Assert(RestoreDeltaBlockBackup(backupURL, file), check.IsNil)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, check.IsNil)
+	expected := make([]byte, volume.Size)
+	(&memSnapshotOps{}).ReadSnapshot("snap1", "vol1", 0, expected)
+	c.Assert(bytes.Equal(data, expected), check.Equals, true)
+}
@@ -0,0 +1,133 @@
+package objectstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+var (
+	// Percentage of uploaded blocks to be read back and verified
+	verifyPercent int
+	verifyRand    = rand.New(rand.NewSource(time.Now().UnixNano()))
+	verifyMutex   = &sync.Mutex{}
+)
+
+/*
+SetVerifyPercent makes delta block backups read back a random sample of
+percent of the blocks they upload, and compare the checksums, so corruption in
+transport or objectstore would be caught at backup time rather than restore
+time. 0 disables the verification.
+*/
+func SetVerifyPercent(percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("Invalid verification percentage %v, should be between 0 and 100", percent)
+	}
+
+	verifyMutex.Lock()
+	defer verifyMutex.Unlock()
+
+	verifyPercent = percent
+	return nil
+}
+
+func shouldVerifyBlock() bool {
+	verifyMutex.Lock()
+	defer verifyMutex.Unlock()
+
+	if verifyPercent == 0 {
+		return false
+	}
+	return verifyRand.Intn(100) < verifyPercent
+}
+
+/*
+verifyBlock reads back the block just uploaded and compares its checksum. A
+corrupted block would be removed, otherwise later backups would reuse it since
+blocks are deduplicated by checksum.
+*/
+func verifyBlock(volumeName, blkFile, checksum string, driver ObjectStoreDriver) error {
+	rc, err := driver.Read(blkFile)
+	if err != nil {
+		return fmt.Errorf("Cannot read back block %v for verification: %v", blkFile, err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("Cannot read back block %v for verification: %v", blkFile, err)
+	}
+	if _, err = decompressBlock(data, checksum); err == nil {
+		return nil
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_FAILURE,
+		LOG_FIELD_EVENT:    LOG_EVENT_VERIFY,
+		LOG_FIELD_VOLUME:   volumeName,
+		LOG_FIELD_DEST_URL: driver.GetURL(),
+	}).Errorf("Block %v is corrupted after upload: %v", blkFile, err)
+	if rmErr := driver.Remove(blkFile); rmErr != nil {
+		log.Warnf("Failed to remove corrupted block %v: %v", blkFile, rmErr)
+	}
+	return fmt.Errorf("Block %v failed read-after-write verification at %v: %v", blkFile, driver.GetURL(), err)
+}
+
+/*
+VerifyRestoredBackup reads the blocks of the delta block backup back from
+volDevName it has been restored to, and compares their checksums with the ones
+recorded in the backup, so the whole path from objectstore to the device is
+verified rather than only the objects. It returns the number of blocks
+verified, which is 0 for single file backups since they have no checksums.
+*/
+func VerifyRestoredBackup(backupURL, volDevName string) (int, error) {
+	bsDriver, err := GetObjectStoreDriver(backupURL)
+	if err != nil {
+		return 0, err
+	}
+	backupName, volumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return 0, err
+	}
+	volume, err := loadVolume(volumeName, bsDriver)
+	if err != nil {
+		return 0, err
+	}
+	backup, err := loadBackup(backupName, volumeName, bsDriver)
+	if err != nil {
+		return 0, err
+	}
+	if backup.SingleFile.FilePath != "" || isFileSetBackup(backup) {
+		return 0, nil
+	}
+
+	volDev, err := os.Open(volDevName)
+	if err != nil {
+		return 0, err
+	}
+	defer volDev.Close()
+
+	blockSize := getBackupBlockSize(backup)
+	buf := make([]byte, blockSize)
+	for _, block := range backup.Blocks {
+		data := buf
+		if block.Offset+blockSize > volume.Size {
+			data = buf[:volume.Size-block.Offset]
+		}
+		if _, err := volDev.ReadAt(data, block.Offset); err != nil {
+			return 0, fmt.Errorf("Cannot read block at %v of %v: %v", block.Offset, volDevName, err)
+		}
+		if checksum := util.GetChecksum(data); checksum != block.BlockChecksum {
+			return 0, fmt.Errorf("Block at %v of %v restored from %v has checksum %v, expected %v",
+				block.Offset, volDevName, backupURL, checksum, block.BlockChecksum)
+		}
+	}
+	return len(backup.Blocks), nil
+}
@@ -0,0 +1,111 @@
+package objectstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/check.v1"
+
+	"github.com/rancher/convoy/metadata"
+)
+
+// memSnapshotOps serves snapshots of two blocks of DEFAULT_BLOCK_SIZE, whose
+// content depends on the snapshot
+type memSnapshotOps struct{}
+
+func (m *memSnapshotOps) HasSnapshot(id, volumeID string) bool {
+	return true
+}
+
+func (m *memSnapshotOps) CompareSnapshot(id, compareID, volumeID string) (*metadata.Mappings, error) {
+	return &metadata.Mappings{
+		Mappings:  []metadata.Mapping{{Offset: 0, Size: 2 * DEFAULT_BLOCK_SIZE}},
+		BlockSize: DEFAULT_BLOCK_SIZE,
+	}, nil
+}
+
+func (m *memSnapshotOps) OpenSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (m *memSnapshotOps) ReadSnapshot(id, volumeID string, start int64, data []byte) error {
+	for i := range data {
+		data[i] = byte(int((start+int64(i))/DEFAULT_BLOCK_SIZE) + len(id))
+	}
+	return nil
+}
+
+func (m *memSnapshotOps) CloseSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (s *TestSuite) TestVerifyBlocks(c *check.C) {
+	c.Assert(SetVerifyPercent(101), check.ErrorMatches, "Invalid verification percentage.*")
+	defer SetVerifyPercent(0)
+
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+
+	// Corruption goes unnoticed without verification
+	s.driver.corruptBlocks = true
+	_, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+
+	c.Assert(SetVerifyPercent(100), check.IsNil)
+	_, err = CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap10"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.ErrorMatches, "Block .* failed read-after-write verification at mem:///backups.*")
+	// Block shared with snap1 is reused, the new one is corrupted and
+	// removed
+	c.Assert(s.countBlocks(), check.Equals, 2)
+
+	s.driver.corruptBlocks = false
+	reads := s.driver.reads
+	_, err = CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap10"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.countBlocks(), check.Equals, 3)
+	// Volume and last backup are loaded besides the new block verified
+	c.Assert(s.driver.reads-reads, check.Equals, 3)
+}
+
+func (s *TestSuite) TestVerifyRestoredBackup(c *check.C) {
+	dir, err := ioutil.TempDir("", "objectstore-restore")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+
+	volume := &Volume{
+		Name:   "vol1",
+		Driver: "loop",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snap1"}, memDestURL, 0, &memSnapshotOps{})
+	c.Assert(err, check.IsNil)
+
+	file := filepath.Join(dir, "vol1")
+	c.Assert(RestoreDeltaBlockBackup(backupURL, file), check.IsNil)
+	verified, err := VerifyRestoredBackup(backupURL, file)
+	c.Assert(err, check.IsNil)
+	c.Assert(verified, check.Equals, 2)
+
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	c.Assert(err, check.IsNil)
+	_, err = f.WriteAt([]byte{0xff}, DEFAULT_BLOCK_SIZE+1)
+	c.Assert(err, check.IsNil)
+	c.Assert(f.Close(), check.IsNil)
+	_, err = VerifyRestoredBackup(backupURL, file)
+	c.Assert(err, check.ErrorMatches, "Block at 2097152 of .* has checksum .*, expected .*")
+}
+
+func (s *TestSuite) countBlocks() int {
+	count := 0
+	for file := range s.driver.files {
+		if strings.HasSuffix(file, ".blk") {
+			count++
+		}
+	}
+	return count
+}
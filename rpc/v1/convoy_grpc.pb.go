@@ -0,0 +1,669 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: convoy.proto
+
+package convoyv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Convoy_CreateVolume_FullMethodName    = "/convoy.v1.Convoy/CreateVolume"
+	Convoy_DeleteVolume_FullMethodName    = "/convoy.v1.Convoy/DeleteVolume"
+	Convoy_MountVolume_FullMethodName     = "/convoy.v1.Convoy/MountVolume"
+	Convoy_UmountVolume_FullMethodName    = "/convoy.v1.Convoy/UmountVolume"
+	Convoy_ListVolumes_FullMethodName     = "/convoy.v1.Convoy/ListVolumes"
+	Convoy_InspectVolume_FullMethodName   = "/convoy.v1.Convoy/InspectVolume"
+	Convoy_CreateSnapshot_FullMethodName  = "/convoy.v1.Convoy/CreateSnapshot"
+	Convoy_DeleteSnapshot_FullMethodName  = "/convoy.v1.Convoy/DeleteSnapshot"
+	Convoy_InspectSnapshot_FullMethodName = "/convoy.v1.Convoy/InspectSnapshot"
+	Convoy_CreateBackup_FullMethodName    = "/convoy.v1.Convoy/CreateBackup"
+	Convoy_DeleteBackup_FullMethodName    = "/convoy.v1.Convoy/DeleteBackup"
+	Convoy_ListBackups_FullMethodName     = "/convoy.v1.Convoy/ListBackups"
+	Convoy_InspectBackup_FullMethodName   = "/convoy.v1.Convoy/InspectBackup"
+	Convoy_WatchEvents_FullMethodName     = "/convoy.v1.Convoy/WatchEvents"
+)
+
+// ConvoyClient is the client API for Convoy service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ConvoyClient interface {
+	CreateVolume(ctx context.Context, in *CreateVolumeRequest, opts ...grpc.CallOption) (*Volume, error)
+	DeleteVolume(ctx context.Context, in *DeleteVolumeRequest, opts ...grpc.CallOption) (*DeleteVolumeResponse, error)
+	MountVolume(ctx context.Context, in *MountVolumeRequest, opts ...grpc.CallOption) (*MountVolumeResponse, error)
+	UmountVolume(ctx context.Context, in *UmountVolumeRequest, opts ...grpc.CallOption) (*UmountVolumeResponse, error)
+	ListVolumes(ctx context.Context, in *ListVolumesRequest, opts ...grpc.CallOption) (*ListVolumesResponse, error)
+	InspectVolume(ctx context.Context, in *InspectVolumeRequest, opts ...grpc.CallOption) (*Volume, error)
+	CreateSnapshot(ctx context.Context, in *CreateSnapshotRequest, opts ...grpc.CallOption) (*Snapshot, error)
+	DeleteSnapshot(ctx context.Context, in *DeleteSnapshotRequest, opts ...grpc.CallOption) (*DeleteSnapshotResponse, error)
+	InspectSnapshot(ctx context.Context, in *InspectSnapshotRequest, opts ...grpc.CallOption) (*Snapshot, error)
+	// CreateBackup reports the backup running every few seconds until it
+	// completes, and the URL of the backup at last
+	CreateBackup(ctx context.Context, in *CreateBackupRequest, opts ...grpc.CallOption) (Convoy_CreateBackupClient, error)
+	DeleteBackup(ctx context.Context, in *DeleteBackupRequest, opts ...grpc.CallOption) (*DeleteBackupResponse, error)
+	ListBackups(ctx context.Context, in *ListBackupsRequest, opts ...grpc.CallOption) (*ListBackupsResponse, error)
+	InspectBackup(ctx context.Context, in *InspectBackupRequest, opts ...grpc.CallOption) (*Backup, error)
+	// WatchEvents streams events recorded in timelines of volumes from now on,
+	// e.g. snapshots, backups, failures and SLO breaches, and events of
+	// drivers, e.g. health and alerts of their backends
+	WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (Convoy_WatchEventsClient, error)
+}
+
+type convoyClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewConvoyClient(cc grpc.ClientConnInterface) ConvoyClient {
+	return &convoyClient{cc}
+}
+
+func (c *convoyClient) CreateVolume(ctx context.Context, in *CreateVolumeRequest, opts ...grpc.CallOption) (*Volume, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Volume)
+	err := c.cc.Invoke(ctx, Convoy_CreateVolume_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyClient) DeleteVolume(ctx context.Context, in *DeleteVolumeRequest, opts ...grpc.CallOption) (*DeleteVolumeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteVolumeResponse)
+	err := c.cc.Invoke(ctx, Convoy_DeleteVolume_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyClient) MountVolume(ctx context.Context, in *MountVolumeRequest, opts ...grpc.CallOption) (*MountVolumeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MountVolumeResponse)
+	err := c.cc.Invoke(ctx, Convoy_MountVolume_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyClient) UmountVolume(ctx context.Context, in *UmountVolumeRequest, opts ...grpc.CallOption) (*UmountVolumeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UmountVolumeResponse)
+	err := c.cc.Invoke(ctx, Convoy_UmountVolume_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyClient) ListVolumes(ctx context.Context, in *ListVolumesRequest, opts ...grpc.CallOption) (*ListVolumesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListVolumesResponse)
+	err := c.cc.Invoke(ctx, Convoy_ListVolumes_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyClient) InspectVolume(ctx context.Context, in *InspectVolumeRequest, opts ...grpc.CallOption) (*Volume, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Volume)
+	err := c.cc.Invoke(ctx, Convoy_InspectVolume_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyClient) CreateSnapshot(ctx context.Context, in *CreateSnapshotRequest, opts ...grpc.CallOption) (*Snapshot, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Snapshot)
+	err := c.cc.Invoke(ctx, Convoy_CreateSnapshot_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyClient) DeleteSnapshot(ctx context.Context, in *DeleteSnapshotRequest, opts ...grpc.CallOption) (*DeleteSnapshotResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteSnapshotResponse)
+	err := c.cc.Invoke(ctx, Convoy_DeleteSnapshot_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyClient) InspectSnapshot(ctx context.Context, in *InspectSnapshotRequest, opts ...grpc.CallOption) (*Snapshot, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Snapshot)
+	err := c.cc.Invoke(ctx, Convoy_InspectSnapshot_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyClient) CreateBackup(ctx context.Context, in *CreateBackupRequest, opts ...grpc.CallOption) (Convoy_CreateBackupClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Convoy_ServiceDesc.Streams[0], Convoy_CreateBackup_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &convoyCreateBackupClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Convoy_CreateBackupClient interface {
+	Recv() (*BackupProgress, error)
+	grpc.ClientStream
+}
+
+type convoyCreateBackupClient struct {
+	grpc.ClientStream
+}
+
+func (x *convoyCreateBackupClient) Recv() (*BackupProgress, error) {
+	m := new(BackupProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *convoyClient) DeleteBackup(ctx context.Context, in *DeleteBackupRequest, opts ...grpc.CallOption) (*DeleteBackupResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteBackupResponse)
+	err := c.cc.Invoke(ctx, Convoy_DeleteBackup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyClient) ListBackups(ctx context.Context, in *ListBackupsRequest, opts ...grpc.CallOption) (*ListBackupsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListBackupsResponse)
+	err := c.cc.Invoke(ctx, Convoy_ListBackups_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyClient) InspectBackup(ctx context.Context, in *InspectBackupRequest, opts ...grpc.CallOption) (*Backup, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Backup)
+	err := c.cc.Invoke(ctx, Convoy_InspectBackup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyClient) WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (Convoy_WatchEventsClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Convoy_ServiceDesc.Streams[1], Convoy_WatchEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &convoyWatchEventsClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Convoy_WatchEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type convoyWatchEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *convoyWatchEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ConvoyServer is the server API for Convoy service.
+// All implementations must embed UnimplementedConvoyServer
+// for forward compatibility
+type ConvoyServer interface {
+	CreateVolume(context.Context, *CreateVolumeRequest) (*Volume, error)
+	DeleteVolume(context.Context, *DeleteVolumeRequest) (*DeleteVolumeResponse, error)
+	MountVolume(context.Context, *MountVolumeRequest) (*MountVolumeResponse, error)
+	UmountVolume(context.Context, *UmountVolumeRequest) (*UmountVolumeResponse, error)
+	ListVolumes(context.Context, *ListVolumesRequest) (*ListVolumesResponse, error)
+	InspectVolume(context.Context, *InspectVolumeRequest) (*Volume, error)
+	CreateSnapshot(context.Context, *CreateSnapshotRequest) (*Snapshot, error)
+	DeleteSnapshot(context.Context, *DeleteSnapshotRequest) (*DeleteSnapshotResponse, error)
+	InspectSnapshot(context.Context, *InspectSnapshotRequest) (*Snapshot, error)
+	// CreateBackup reports the backup running every few seconds until it
+	// completes, and the URL of the backup at last
+	CreateBackup(*CreateBackupRequest, Convoy_CreateBackupServer) error
+	DeleteBackup(context.Context, *DeleteBackupRequest) (*DeleteBackupResponse, error)
+	ListBackups(context.Context, *ListBackupsRequest) (*ListBackupsResponse, error)
+	InspectBackup(context.Context, *InspectBackupRequest) (*Backup, error)
+	// WatchEvents streams events recorded in timelines of volumes from now on,
+	// e.g. snapshots, backups, failures and SLO breaches, and events of
+	// drivers, e.g. health and alerts of their backends
+	WatchEvents(*WatchEventsRequest, Convoy_WatchEventsServer) error
+	mustEmbedUnimplementedConvoyServer()
+}
+
+// UnimplementedConvoyServer must be embedded to have forward compatible implementations.
+type UnimplementedConvoyServer struct {
+}
+
+func (UnimplementedConvoyServer) CreateVolume(context.Context, *CreateVolumeRequest) (*Volume, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateVolume not implemented")
+}
+func (UnimplementedConvoyServer) DeleteVolume(context.Context, *DeleteVolumeRequest) (*DeleteVolumeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteVolume not implemented")
+}
+func (UnimplementedConvoyServer) MountVolume(context.Context, *MountVolumeRequest) (*MountVolumeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MountVolume not implemented")
+}
+func (UnimplementedConvoyServer) UmountVolume(context.Context, *UmountVolumeRequest) (*UmountVolumeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UmountVolume not implemented")
+}
+func (UnimplementedConvoyServer) ListVolumes(context.Context, *ListVolumesRequest) (*ListVolumesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVolumes not implemented")
+}
+func (UnimplementedConvoyServer) InspectVolume(context.Context, *InspectVolumeRequest) (*Volume, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InspectVolume not implemented")
+}
+func (UnimplementedConvoyServer) CreateSnapshot(context.Context, *CreateSnapshotRequest) (*Snapshot, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSnapshot not implemented")
+}
+func (UnimplementedConvoyServer) DeleteSnapshot(context.Context, *DeleteSnapshotRequest) (*DeleteSnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteSnapshot not implemented")
+}
+func (UnimplementedConvoyServer) InspectSnapshot(context.Context, *InspectSnapshotRequest) (*Snapshot, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InspectSnapshot not implemented")
+}
+func (UnimplementedConvoyServer) CreateBackup(*CreateBackupRequest, Convoy_CreateBackupServer) error {
+	return status.Errorf(codes.Unimplemented, "method CreateBackup not implemented")
+}
+func (UnimplementedConvoyServer) DeleteBackup(context.Context, *DeleteBackupRequest) (*DeleteBackupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteBackup not implemented")
+}
+func (UnimplementedConvoyServer) ListBackups(context.Context, *ListBackupsRequest) (*ListBackupsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBackups not implemented")
+}
+func (UnimplementedConvoyServer) InspectBackup(context.Context, *InspectBackupRequest) (*Backup, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InspectBackup not implemented")
+}
+func (UnimplementedConvoyServer) WatchEvents(*WatchEventsRequest, Convoy_WatchEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedConvoyServer) mustEmbedUnimplementedConvoyServer() {}
+
+// UnsafeConvoyServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ConvoyServer will
+// result in compilation errors.
+type UnsafeConvoyServer interface {
+	mustEmbedUnimplementedConvoyServer()
+}
+
+func RegisterConvoyServer(s grpc.ServiceRegistrar, srv ConvoyServer) {
+	s.RegisterService(&Convoy_ServiceDesc, srv)
+}
+
+func _Convoy_CreateVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateVolumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyServer).CreateVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convoy_CreateVolume_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyServer).CreateVolume(ctx, req.(*CreateVolumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convoy_DeleteVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteVolumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyServer).DeleteVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convoy_DeleteVolume_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyServer).DeleteVolume(ctx, req.(*DeleteVolumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convoy_MountVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MountVolumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyServer).MountVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convoy_MountVolume_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyServer).MountVolume(ctx, req.(*MountVolumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convoy_UmountVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UmountVolumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyServer).UmountVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convoy_UmountVolume_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyServer).UmountVolume(ctx, req.(*UmountVolumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convoy_ListVolumes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVolumesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyServer).ListVolumes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convoy_ListVolumes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyServer).ListVolumes(ctx, req.(*ListVolumesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convoy_InspectVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InspectVolumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyServer).InspectVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convoy_InspectVolume_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyServer).InspectVolume(ctx, req.(*InspectVolumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convoy_CreateSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyServer).CreateSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convoy_CreateSnapshot_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyServer).CreateSnapshot(ctx, req.(*CreateSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convoy_DeleteSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyServer).DeleteSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convoy_DeleteSnapshot_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyServer).DeleteSnapshot(ctx, req.(*DeleteSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convoy_InspectSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InspectSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyServer).InspectSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convoy_InspectSnapshot_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyServer).InspectSnapshot(ctx, req.(*InspectSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convoy_CreateBackup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(CreateBackupRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConvoyServer).CreateBackup(m, &convoyCreateBackupServer{ServerStream: stream})
+}
+
+type Convoy_CreateBackupServer interface {
+	Send(*BackupProgress) error
+	grpc.ServerStream
+}
+
+type convoyCreateBackupServer struct {
+	grpc.ServerStream
+}
+
+func (x *convoyCreateBackupServer) Send(m *BackupProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Convoy_DeleteBackup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteBackupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyServer).DeleteBackup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convoy_DeleteBackup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyServer).DeleteBackup(ctx, req.(*DeleteBackupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convoy_ListBackups_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBackupsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyServer).ListBackups(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convoy_ListBackups_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyServer).ListBackups(ctx, req.(*ListBackupsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convoy_InspectBackup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InspectBackupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyServer).InspectBackup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convoy_InspectBackup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyServer).InspectBackup(ctx, req.(*InspectBackupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convoy_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConvoyServer).WatchEvents(m, &convoyWatchEventsServer{ServerStream: stream})
+}
+
+type Convoy_WatchEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type convoyWatchEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *convoyWatchEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Convoy_ServiceDesc is the grpc.ServiceDesc for Convoy service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Convoy_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "convoy.v1.Convoy",
+	HandlerType: (*ConvoyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateVolume",
+			Handler:    _Convoy_CreateVolume_Handler,
+		},
+		{
+			MethodName: "DeleteVolume",
+			Handler:    _Convoy_DeleteVolume_Handler,
+		},
+		{
+			MethodName: "MountVolume",
+			Handler:    _Convoy_MountVolume_Handler,
+		},
+		{
+			MethodName: "UmountVolume",
+			Handler:    _Convoy_UmountVolume_Handler,
+		},
+		{
+			MethodName: "ListVolumes",
+			Handler:    _Convoy_ListVolumes_Handler,
+		},
+		{
+			MethodName: "InspectVolume",
+			Handler:    _Convoy_InspectVolume_Handler,
+		},
+		{
+			MethodName: "CreateSnapshot",
+			Handler:    _Convoy_CreateSnapshot_Handler,
+		},
+		{
+			MethodName: "DeleteSnapshot",
+			Handler:    _Convoy_DeleteSnapshot_Handler,
+		},
+		{
+			MethodName: "InspectSnapshot",
+			Handler:    _Convoy_InspectSnapshot_Handler,
+		},
+		{
+			MethodName: "DeleteBackup",
+			Handler:    _Convoy_DeleteBackup_Handler,
+		},
+		{
+			MethodName: "ListBackups",
+			Handler:    _Convoy_ListBackups_Handler,
+		},
+		{
+			MethodName: "InspectBackup",
+			Handler:    _Convoy_InspectBackup_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "CreateBackup",
+			Handler:       _Convoy_CreateBackup_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _Convoy_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "convoy.proto",
+}
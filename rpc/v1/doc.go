@@ -0,0 +1,8 @@
+/*
+Package convoyv1 is version 1 of the gRPC API of Convoy daemon, served with
+--grpc-listen. Requests and responses mirror the ones of the HTTP API, and are
+handled the same way, e.g. authorized by --auth-config.
+*/
+package convoyv1
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative convoy.proto
@@ -0,0 +1,140 @@
+package s3
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+var (
+	endpoint       Endpoint
+	endpointClient *http.Client
+	endpointMutex  sync.RWMutex
+)
+
+/*
+Endpoint of S3 compatible service, e.g. MinIO or Ceph RGW, used instead of
+AWS. TLS options apply to AWS as well if URL is empty:
+
+	CACert              PEM bundle trusted in addition to the system's,
+	                    for endpoints with private PKI
+	ClientCert          PEM certificate and key presented to endpoints
+	ClientKey           requiring mutual TLS
+	InsecureSkipVerify  accept any certificate of the endpoint, which
+	                    exposes backups and credentials to anyone in the
+	                    middle. Only meant for testing
+*/
+type Endpoint struct {
+	URL                string
+	CACert             string
+	ClientCert         string
+	ClientKey          string
+	InsecureSkipVerify bool
+}
+
+func (e *Endpoint) validate() error {
+	if e.URL != "" {
+		u, err := url.Parse(e.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid S3 endpoint %q, should be http:// or https://", e.URL)
+		}
+	}
+	if (e.ClientCert == "") != (e.ClientKey == "") {
+		return fmt.Errorf("S3 client certificate and key must be specified together")
+	}
+	return nil
+}
+
+func (e *Endpoint) tlsConfig() (*tls.Config, error) {
+	if e.CACert == "" && e.ClientCert == "" && !e.InsecureSkipVerify {
+		return nil, nil
+	}
+	config := &tls.Config{
+		InsecureSkipVerify: e.InsecureSkipVerify,
+	}
+	if e.CACert != "" {
+		pem, err := ioutil.ReadFile(e.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read S3 CA certificate %v: %v", e.CACert, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No PEM certificate found in S3 CA certificate %v", e.CACert)
+		}
+		config.RootCAs = pool
+	}
+	if e.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(e.ClientCert, e.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load S3 client certificate %v and key %v: %v",
+				e.ClientCert, e.ClientKey, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+/*
+SetEndpoint applies to S3 objectstore drivers created afterwards. Certificates
+are loaded here, so errors would be found before any backup, and the files
+won't be read again until it's called next time.
+*/
+func SetEndpoint(e Endpoint) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+	config, err := e.tlsConfig()
+	if err != nil {
+		return err
+	}
+	var client *http.Client
+	if config != nil {
+		client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: config,
+			},
+		}
+		if e.InsecureSkipVerify {
+			log.Warn("Certificate verification of S3 endpoints is disabled")
+		}
+	}
+
+	endpointMutex.Lock()
+	defer endpointMutex.Unlock()
+
+	endpoint = e
+	endpointClient = client
+	return nil
+}
+
+// getEndpoint returns URL of the endpoint and the client to reach it, nil for
+// the default client of SDK
+func getEndpoint() (string, *http.Client) {
+	endpointMutex.RLock()
+	defer endpointMutex.RUnlock()
+
+	return endpoint.URL, endpointClient
+}
+
+// applyEndpoint points config at the endpoint of the service. Buckets are
+// addressed by path, since S3 compatible services may not serve them as
+// subdomains
+func (s *S3Service) applyEndpoint(config *aws.Config) {
+	if s.Endpoint != "" {
+		config.Endpoint = aws.String(s.Endpoint)
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+	if s.Client != nil {
+		config.HTTPClient = s.Client
+	}
+}
@@ -0,0 +1,140 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type EndpointTestSuite struct {
+	dir    string
+	fake   *fakeS3
+	server *httptest.Server
+}
+
+var _ = Suite(&EndpointTestSuite{})
+
+func (s *EndpointTestSuite) SetUpSuite(c *C) {
+	uploadRetryInterval = time.Millisecond
+	os.Setenv("AWS_ACCESS_KEY_ID", "test")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+}
+
+func (s *EndpointTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	s.fake = &fakeS3{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+	s.server = httptest.NewUnstartedServer(s.fake)
+	s.server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	s.server.StartTLS()
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, "ca.pem"), pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: s.server.Certificate().Raw,
+	}), 0600), IsNil)
+	s.writeClientCert(c)
+}
+
+func (s *EndpointTestSuite) TearDownTest(c *C) {
+	s.server.Close()
+	c.Assert(SetEndpoint(Endpoint{}), IsNil)
+}
+
+func (s *EndpointTestSuite) writeClientCert(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "convoy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	c.Assert(err, IsNil)
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, "client.pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, "client-key.pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600), IsNil)
+}
+
+func (s *EndpointTestSuite) newService() *S3Service {
+	service := &S3Service{
+		Region: "us-east-1",
+		Bucket: testBucket,
+		UploadOptions: UploadOptions{
+			PartSize:    DEFAULT_PART_SIZE,
+			Concurrency: DEFAULT_UPLOAD_CONCURRENCY,
+		},
+	}
+	service.Endpoint, service.Client = getEndpoint()
+	return service
+}
+
+func (s *EndpointTestSuite) TestSetEndpoint(c *C) {
+	c.Assert(SetEndpoint(Endpoint{URL: "minio:9000"}), ErrorMatches, "Invalid S3 endpoint.*")
+	c.Assert(SetEndpoint(Endpoint{URL: s.server.URL, ClientCert: filepath.Join(s.dir, "client.pem")}),
+		ErrorMatches, "S3 client certificate and key must be specified together")
+	c.Assert(SetEndpoint(Endpoint{URL: s.server.URL, CACert: filepath.Join(s.dir, "nonexistent.pem")}),
+		ErrorMatches, "Cannot read S3 CA certificate.*")
+	c.Assert(SetEndpoint(Endpoint{URL: s.server.URL, CACert: filepath.Join(s.dir, "client-key.pem")}),
+		ErrorMatches, "No PEM certificate found.*")
+	c.Assert(SetEndpoint(Endpoint{
+		URL:        s.server.URL,
+		ClientCert: filepath.Join(s.dir, "client.pem"),
+		ClientKey:  filepath.Join(s.dir, "ca.pem"),
+	}), ErrorMatches, "Cannot load S3 client certificate.*")
+
+	// Default client of SDK if no TLS option is specified
+	c.Assert(SetEndpoint(Endpoint{URL: s.server.URL}), IsNil)
+	url, client := getEndpoint()
+	c.Assert(url, Equals, s.server.URL)
+	c.Assert(client, IsNil)
+}
+
+func (s *EndpointTestSuite) TestPrivatePKI(c *C) {
+	c.Assert(SetEndpoint(Endpoint{
+		URL:        s.server.URL,
+		CACert:     filepath.Join(s.dir, "ca.pem"),
+		ClientCert: filepath.Join(s.dir, "client.pem"),
+		ClientKey:  filepath.Join(s.dir, "client-key.pem"),
+	}), IsNil)
+	c.Assert(s.newService().PutObject("convoy/volume.cfg", bytes.NewReader([]byte("config"))), IsNil)
+	c.Assert(string(s.fake.objects["convoy/volume.cfg"]), Equals, "config")
+
+	c.Assert(SetEndpoint(Endpoint{
+		URL:                s.server.URL,
+		ClientCert:         filepath.Join(s.dir, "client.pem"),
+		ClientKey:          filepath.Join(s.dir, "client-key.pem"),
+		InsecureSkipVerify: true,
+	}), IsNil)
+	c.Assert(s.newService().PutObject("convoy/volume2.cfg", bytes.NewReader([]byte("config"))), IsNil)
+	c.Assert(s.fake.objects, HasLen, 2)
+
+	// Unknown CA
+	c.Assert(SetEndpoint(Endpoint{
+		URL:        s.server.URL,
+		ClientCert: filepath.Join(s.dir, "client.pem"),
+		ClientKey:  filepath.Join(s.dir, "client-key.pem"),
+	}), IsNil)
+	_, err := s.newService().HeadObject("convoy/volume.cfg")
+	c.Assert(err, ErrorMatches, "(?s).*certificate.*")
+}
@@ -0,0 +1,120 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	OBJECT_LOCK_GOVERNANCE = "governance"
+	OBJECT_LOCK_COMPLIANCE = "compliance"
+
+	// SDK predates Object Lock, so its headers are set on requests directly
+	HEADER_OBJECT_LOCK_MODE         = "x-amz-object-lock-mode"
+	HEADER_OBJECT_LOCK_RETAIN_UNTIL = "x-amz-object-lock-retain-until-date"
+	HEADER_CONTENT_MD5              = "Content-MD5"
+)
+
+var (
+	objectLock      ObjectLock
+	objectLockMutex sync.RWMutex
+)
+
+/*
+ObjectLock retains objects written to S3 with Object Lock for Period after
+they're written, so they cannot be deleted or overwritten until then, even
+with the credentials of the daemon. Mode is one of:
+
+	governance    users with s3:BypassGovernanceRetention can still remove
+	              the lock
+	compliance    nobody can remove the lock, including the root account
+
+Empty Mode leaves it to the default retention of the bucket. The bucket must
+have Object Lock enabled, which requires versioning.
+*/
+type ObjectLock struct {
+	Mode   string
+	Period time.Duration
+}
+
+func (l *ObjectLock) validate() error {
+	switch l.Mode {
+	case "":
+		if l.Period != 0 {
+			return fmt.Errorf("S3 object lock period can only be specified with %v or %v",
+				OBJECT_LOCK_GOVERNANCE, OBJECT_LOCK_COMPLIANCE)
+		}
+	case OBJECT_LOCK_GOVERNANCE, OBJECT_LOCK_COMPLIANCE:
+		if l.Period <= 0 {
+			return fmt.Errorf("Invalid S3 object lock period %v, should be positive", l.Period)
+		}
+	default:
+		return fmt.Errorf("Invalid S3 object lock mode %v, should be %v or %v",
+			l.Mode, OBJECT_LOCK_GOVERNANCE, OBJECT_LOCK_COMPLIANCE)
+	}
+	return nil
+}
+
+// SetObjectLock applies to S3 objectstore drivers created afterwards
+func SetObjectLock(l ObjectLock) error {
+	if err := l.validate(); err != nil {
+		return err
+	}
+
+	objectLockMutex.Lock()
+	defer objectLockMutex.Unlock()
+
+	objectLock = l
+	return nil
+}
+
+func getObjectLock() ObjectLock {
+	objectLockMutex.RLock()
+	defer objectLockMutex.RUnlock()
+
+	return objectLock
+}
+
+func (l *ObjectLock) enabled() bool {
+	return l.Mode != ""
+}
+
+// applyWrite sets the lock on the object written by req, retained for Period
+// from the time req is built
+func (l *ObjectLock) applyWrite(req *request.Request) {
+	if !l.enabled() {
+		return
+	}
+	req.Handlers.Build.PushBack(func(r *request.Request) {
+		retainUntil := time.Now().Add(l.Period).UTC().Format(time.RFC3339)
+		r.HTTPRequest.Header.Set(HEADER_OBJECT_LOCK_MODE, strings.ToUpper(l.Mode))
+		r.HTTPRequest.Header.Set(HEADER_OBJECT_LOCK_RETAIN_UNTIL, retainUntil)
+	})
+}
+
+// applyContentMD5 sets MD5 of the body, which S3 requires for uploads of
+// objects with Object Lock
+func (l *ObjectLock) applyContentMD5(req *request.Request, body io.ReadSeeker) error {
+	if !l.enabled() {
+		return nil
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sum := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	req.Handlers.Build.PushBack(func(r *request.Request) {
+		r.HTTPRequest.Header.Set(HEADER_CONTENT_MD5, sum)
+	})
+	return nil
+}
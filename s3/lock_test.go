@@ -0,0 +1,54 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MultipartTestSuite) TestSetObjectLock(c *C) {
+	defer SetObjectLock(ObjectLock{})
+
+	c.Assert(SetObjectLock(ObjectLock{Mode: "legal-hold", Period: time.Hour}), ErrorMatches, "Invalid S3 object lock mode.*")
+	c.Assert(SetObjectLock(ObjectLock{Mode: OBJECT_LOCK_COMPLIANCE}), ErrorMatches, "Invalid S3 object lock period.*")
+	c.Assert(SetObjectLock(ObjectLock{Period: time.Hour}), ErrorMatches, "S3 object lock period can only be specified.*")
+	c.Assert(SetObjectLock(ObjectLock{Mode: OBJECT_LOCK_GOVERNANCE, Period: 24 * time.Hour}), IsNil)
+	c.Assert(getObjectLock().Period, Equals, 24*time.Hour)
+}
+
+func (s *MultipartTestSuite) TestObjectLock(c *C) {
+	c.Assert(s.service.PutObject("convoy/volume.cfg", bytes.NewReader([]byte("config"))), IsNil)
+	c.Assert(s.fake.writeHeaders, HasLen, 1)
+	c.Assert(s.fake.writeHeaders[0].Get(HEADER_OBJECT_LOCK_MODE), Equals, "")
+	c.Assert(s.fake.writeHeaders[0].Get(HEADER_CONTENT_MD5), Equals, "")
+
+	s.service.ObjectLock = ObjectLock{Mode: OBJECT_LOCK_COMPLIANCE, Period: 7 * 24 * time.Hour}
+	data := []byte("backup config")
+	sum := md5.Sum(data)
+	s.fake.writeHeaders = nil
+	s.fake.failPuts = 1
+	c.Assert(s.service.PutObject("convoy/backup.cfg", bytes.NewReader(data)), IsNil)
+	// Headers are set again on retries
+	c.Assert(s.fake.writeHeaders, HasLen, 1)
+	header := s.fake.writeHeaders[0]
+	c.Assert(header.Get(HEADER_OBJECT_LOCK_MODE), Equals, "COMPLIANCE")
+	c.Assert(header.Get(HEADER_CONTENT_MD5), Equals, base64.StdEncoding.EncodeToString(sum[:]))
+	retainUntil, err := time.Parse(time.RFC3339, header.Get(HEADER_OBJECT_LOCK_RETAIN_UNTIL))
+	c.Assert(err, IsNil)
+	c.Assert(retainUntil.Sub(time.Now()) > 7*24*time.Hour-time.Minute, Equals, true)
+	c.Assert(string(s.fake.objects["convoy/backup.cfg"]), Equals, "backup config")
+
+	// Lock is set when multipart upload is created, parts carry their MD5
+	data = make([]byte, MIN_PART_SIZE+1)
+	s.fake.writeHeaders = nil
+	c.Assert(s.service.PutObject("convoy/backup.bak", bytes.NewReader(data)), IsNil)
+	c.Assert(s.fake.writeHeaders, HasLen, 3)
+	c.Assert(s.fake.writeHeaders[0].Get(HEADER_OBJECT_LOCK_MODE), Equals, "COMPLIANCE")
+	c.Assert(s.fake.writeHeaders[0].Get(HEADER_OBJECT_LOCK_RETAIN_UNTIL), Not(Equals), "")
+	for _, header := range s.fake.writeHeaders[1:] {
+		c.Assert(header.Get(HEADER_CONTENT_MD5), Not(Equals), "")
+	}
+}
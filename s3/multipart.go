@@ -0,0 +1,287 @@
+package s3
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// S3 requires all parts but the last to be at least 5MiB, and allows
+	// up to 10000 parts
+	MIN_PART_SIZE = 5 * 1024 * 1024
+	MAX_PARTS     = 10000
+
+	DEFAULT_PART_SIZE          = 8 * 1024 * 1024
+	DEFAULT_UPLOAD_CONCURRENCY = 4
+
+	// Retries of each part, or the whole object if it's uploaded with single
+	// PUT, before upload gives up
+	S3_UPLOAD_RETRIES = 5
+)
+
+var (
+	// Wait before retrying a failed part grows by the interval each time
+	uploadRetryInterval = time.Second
+
+	uploadOptions = UploadOptions{
+		PartSize:    DEFAULT_PART_SIZE,
+		Concurrency: DEFAULT_UPLOAD_CONCURRENCY,
+	}
+	uploadOptionsMutex sync.RWMutex
+)
+
+/*
+UploadOptions of objects written to S3. Objects larger than PartSize are
+uploaded with multipart upload, Concurrency parts at a time, so a transient
+network error only costs the part it interrupted. Memory used by an upload is
+up to PartSize * Concurrency.
+*/
+type UploadOptions struct {
+	PartSize    int64
+	Concurrency int
+}
+
+func (o *UploadOptions) validate() error {
+	if o.PartSize < MIN_PART_SIZE {
+		return fmt.Errorf("Invalid S3 part size %v, should be at least %v", o.PartSize, MIN_PART_SIZE)
+	}
+	if o.Concurrency < 1 {
+		return fmt.Errorf("Invalid S3 upload concurrency %v, should be at least 1", o.Concurrency)
+	}
+	return nil
+}
+
+// SetUploadOptions applies to S3 objectstore drivers created afterwards
+func SetUploadOptions(o UploadOptions) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	uploadOptionsMutex.Lock()
+	defer uploadOptionsMutex.Unlock()
+
+	uploadOptions = o
+	return nil
+}
+
+func getUploadOptions() UploadOptions {
+	uploadOptionsMutex.RLock()
+	defer uploadOptionsMutex.RUnlock()
+
+	return uploadOptions
+}
+
+func (s *S3Service) putObjectWithRetry(svc *s3.S3, key string, reader io.ReadSeeker) error {
+	params := &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Body:   reader,
+	}
+	s.Encryption.applyPut(params)
+	if class := s.dataStorageClass(key); class != "" {
+		params.StorageClass = aws.String(class)
+	}
+
+	retries := 0
+	for {
+		req, resp := svc.PutObjectRequest(params)
+		sendOnce(req)
+		s.ObjectLock.applyWrite(req)
+		s.applyDataTag(req, key)
+		if err := s.ObjectLock.applyContentMD5(req, reader); err != nil {
+			return err
+		}
+		err := req.Send()
+		if err == nil {
+			return nil
+		}
+		err = parseAwsError(resp.String(), err)
+		retries++
+		if retries > S3_UPLOAD_RETRIES {
+			return fmt.Errorf("Failed to upload %v after %v retries: %v", key, S3_UPLOAD_RETRIES, err)
+		}
+		log.Warnf("Failed to upload %v, retrying: %v", key, err)
+		time.Sleep(time.Duration(retries) * uploadRetryInterval)
+		if _, err := reader.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+}
+
+// sendOnce disables retries of SDK for the request, since uploads are retried
+// by S3_UPLOAD_RETRIES, which would be multiplied by retries of SDK otherwise
+func sendOnce(req *request.Request) {
+	req.Retryer = client.DefaultRetryer{NumMaxRetries: 0}
+}
+
+type uploadPart struct {
+	number int64
+	buf    []byte
+	size   int
+}
+
+/*
+multipartUpload reads parts of the object in sequence, and uploads them with
+a pool of workers. A failed part is retried on its own, and the upload would
+be aborted if any part gives up, so the parts uploaded won't be left in the
+bucket.
+*/
+func (s *S3Service) multipartUpload(svc *s3.S3, key string, reader io.ReadSeeker, size int64) error {
+	partSize := s.UploadOptions.PartSize
+	if (size+partSize-1)/partSize > MAX_PARTS {
+		partSize = (size + MAX_PARTS - 1) / MAX_PARTS
+	}
+	concurrency := s.UploadOptions.Concurrency
+
+	createParams := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}
+	s.Encryption.applyCreateMultipart(createParams)
+	if class := s.dataStorageClass(key); class != "" {
+		createParams.StorageClass = aws.String(class)
+	}
+	createReq, createResp := svc.CreateMultipartUploadRequest(createParams)
+	s.ObjectLock.applyWrite(createReq)
+	s.applyDataTag(createReq, key)
+	if err := createReq.Send(); err != nil {
+		return parseAwsError(createResp.String(), err)
+	}
+	uploadID := createResp.UploadId
+
+	var (
+		completed []*s3.CompletedPart
+		uploadErr error
+		mutex     sync.Mutex
+		wg        sync.WaitGroup
+	)
+	failed := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return uploadErr != nil
+	}
+	// Buffers are recycled, so at most concurrency parts are in memory
+	buffers := make(chan []byte, concurrency)
+	for i := 0; i < concurrency; i++ {
+		buffers <- make([]byte, partSize)
+	}
+	parts := make(chan *uploadPart)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range parts {
+				etag, err := s.uploadPartWithRetry(svc, key, uploadID, part)
+				buffers <- part.buf
+
+				mutex.Lock()
+				if err != nil && uploadErr == nil {
+					uploadErr = err
+				}
+				if err == nil {
+					completed = append(completed, &s3.CompletedPart{
+						ETag:       etag,
+						PartNumber: aws.Int64(part.number),
+					})
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	var readErr error
+	if _, readErr = reader.Seek(0, io.SeekStart); readErr == nil {
+		for number, offset := int64(1), int64(0); offset < size && !failed(); number++ {
+			buf := <-buffers
+			n, err := io.ReadFull(reader, buf)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				readErr = err
+				break
+			}
+			parts <- &uploadPart{
+				number: number,
+				buf:    buf,
+				size:   n,
+			}
+			offset += int64(n)
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	if uploadErr == nil {
+		uploadErr = readErr
+	}
+	if uploadErr != nil {
+		if resp, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.Bucket),
+			Key:      aws.String(key),
+			UploadId: uploadID,
+		}); err != nil {
+			log.Warnf("Failed to abort multipart upload of %v: %v", key, parseAwsError(resp.String(), err))
+		}
+		return uploadErr
+	}
+
+	sort.Sort(completedParts(completed))
+	completeResp, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.Bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return parseAwsError(completeResp.String(), err)
+	}
+	return nil
+}
+
+func (s *S3Service) uploadPartWithRetry(svc *s3.S3, key string, uploadID *string, part *uploadPart) (*string, error) {
+	data := part.buf[:part.size]
+	params := &s3.UploadPartInput{
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(key),
+		UploadId:      uploadID,
+		PartNumber:    aws.Int64(part.number),
+		ContentLength: aws.Int64(int64(part.size)),
+	}
+	s.Encryption.applyUploadPart(params)
+
+	retries := 0
+	for {
+		params.Body = bytes.NewReader(data)
+		req, resp := svc.UploadPartRequest(params)
+		sendOnce(req)
+		if err := s.ObjectLock.applyContentMD5(req, params.Body); err != nil {
+			return nil, err
+		}
+		err := req.Send()
+		if err == nil {
+			return resp.ETag, nil
+		}
+		err = parseAwsError(resp.String(), err)
+		retries++
+		if retries > S3_UPLOAD_RETRIES {
+			return nil, fmt.Errorf("Failed to upload part %v of %v after %v retries: %v",
+				part.number, key, S3_UPLOAD_RETRIES, err)
+		}
+		log.Warnf("Failed to upload part %v of %v, retrying: %v", part.number, key, err)
+		time.Sleep(time.Duration(retries) * uploadRetryInterval)
+	}
+}
+
+// Parts complete out of order, but have to be listed in order
+type completedParts []*s3.CompletedPart
+
+func (p completedParts) Len() int           { return len(p) }
+func (p completedParts) Less(i, j int) bool { return *p[i].PartNumber < *p[j].PartNumber }
+func (p completedParts) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
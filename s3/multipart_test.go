@@ -0,0 +1,215 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+const (
+	testBucket = "convoy-test"
+)
+
+// fakeS3 serves path-style object and multipart upload requests of a single
+// bucket in memory
+type fakeS3 struct {
+	mutex    sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	aborted  int
+	puts     int
+	uploadID int
+	// Requests uploading data to fail before succeeding, forever if
+	// negative
+	failPuts int
+	// Headers of requests creating objects or uploading parts
+	writeHeaders []http.Header
+	// Objects archived by their storage class, with the state of their
+	// retrieval as x-amz-restore
+	archived map[string]string
+	restores int
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	key := r.URL.Path[len("/"+testBucket+"/"):]
+	query := r.URL.Query()
+	data, _ := ioutil.ReadAll(r.Body)
+	if r.Method == "PUT" {
+		if f.failPuts != 0 {
+			f.failPuts--
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "<Error><Code>RequestTimeout</Code><Message>Connection reset</Message></Error>")
+			return
+		}
+		f.puts++
+	}
+	if r.Method == "PUT" || (r.Method == "POST" && query["uploads"] != nil) {
+		f.writeHeaders = append(f.writeHeaders, r.Header)
+	}
+	switch {
+	case r.Method == "POST" && query["uploads"] != nil:
+		f.uploadID++
+		id := strconv.Itoa(f.uploadID)
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%v</Bucket><Key>%v</Key><UploadId>%v</UploadId></InitiateMultipartUploadResult>",
+			testBucket, key, id)
+	case r.Method == "PUT" && query.Get("uploadId") != "":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")][number] = data
+		w.Header().Set("ETag", fmt.Sprintf("\"etag-%v\"", number))
+	case r.Method == "POST" && query.Get("uploadId") != "":
+		complete := &completeMultipartUpload{}
+		if err := xml.Unmarshal(data, complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		parts := f.uploads[query.Get("uploadId")]
+		object := []byte{}
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf("\"etag-%v\"", i+1) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			object = append(object, parts[part.PartNumber]...)
+		}
+		f.objects[key] = object
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult><ETag>\"etag\"</ETag></CompleteMultipartUploadResult>")
+	case r.Method == "DELETE" && query.Get("uploadId") != "":
+		delete(f.uploads, query.Get("uploadId"))
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PUT":
+		f.objects[key] = data
+	case r.Method == "POST" && query["restore"] != nil:
+		f.restores++
+		f.archived[key] = `ongoing-request="true"`
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == "HEAD" || r.Method == "GET":
+		object, exists := f.objects[key]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		restore, archived := f.archived[key]
+		if archived {
+			w.Header().Set("x-amz-storage-class", STORAGE_CLASS_GLACIER)
+			if restore != "" {
+				w.Header().Set("x-amz-restore", restore)
+			}
+		}
+		if r.Method == "GET" && archived && !strings.Contains(restore, `ongoing-request="false"`) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<Error><Code>InvalidObjectState</Code><Message>The operation is not valid for the object's storage class</Message></Error>")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+		if r.Method == "GET" {
+			w.Write(object)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+type MultipartTestSuite struct {
+	fake    *fakeS3
+	server  *httptest.Server
+	service *S3Service
+}
+
+var _ = Suite(&MultipartTestSuite{})
+
+func (s *MultipartTestSuite) SetUpSuite(c *C) {
+	uploadRetryInterval = time.Millisecond
+	os.Setenv("AWS_ACCESS_KEY_ID", "test")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+}
+
+func (s *MultipartTestSuite) SetUpTest(c *C) {
+	s.fake = &fakeS3{
+		objects:  make(map[string][]byte),
+		uploads:  make(map[string]map[int][]byte),
+		archived: make(map[string]string),
+	}
+	s.server = httptest.NewServer(s.fake)
+	s.service = &S3Service{
+		Region: "us-east-1",
+		Bucket: testBucket,
+		UploadOptions: UploadOptions{
+			PartSize:    MIN_PART_SIZE,
+			Concurrency: 2,
+		},
+		Endpoint: s.server.URL,
+	}
+}
+
+func (s *MultipartTestSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *MultipartTestSuite) TestSetUploadOptions(c *C) {
+	defer SetUploadOptions(UploadOptions{
+		PartSize:    DEFAULT_PART_SIZE,
+		Concurrency: DEFAULT_UPLOAD_CONCURRENCY,
+	})
+
+	c.Assert(SetUploadOptions(UploadOptions{PartSize: 1024 * 1024, Concurrency: 1}), ErrorMatches, "Invalid S3 part size.*")
+	c.Assert(SetUploadOptions(UploadOptions{PartSize: MIN_PART_SIZE}), ErrorMatches, "Invalid S3 upload concurrency.*")
+	c.Assert(SetUploadOptions(UploadOptions{PartSize: 16 * 1024 * 1024, Concurrency: 8}), IsNil)
+	c.Assert(getUploadOptions().PartSize, Equals, int64(16*1024*1024))
+}
+
+func (s *MultipartTestSuite) TestPutObject(c *C) {
+	s.fake.failPuts = 2
+	c.Assert(s.service.PutObject("convoy/volume.cfg", bytes.NewReader([]byte("config"))), IsNil)
+	c.Assert(string(s.fake.objects["convoy/volume.cfg"]), Equals, "config")
+	c.Assert(s.fake.uploadID, Equals, 0)
+
+	s.fake.failPuts = -1
+	err := s.service.PutObject("convoy/volume2.cfg", bytes.NewReader([]byte("config")))
+	c.Assert(err, ErrorMatches, "(?s)Failed to upload convoy/volume2.cfg after .* retries.*")
+	// Retries of SDK are not multiplied by the retries of upload
+	c.Assert(s.fake.failPuts, Equals, -1-(S3_UPLOAD_RETRIES+1))
+}
+
+func (s *MultipartTestSuite) TestMultipartUpload(c *C) {
+	data := make([]byte, 3*MIN_PART_SIZE+12345)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	s.fake.failPuts = 1
+	c.Assert(s.service.PutObject("convoy/backup.bak", bytes.NewReader(data)), IsNil)
+	c.Assert(s.fake.puts, Equals, 4)
+	c.Assert(s.fake.uploads, HasLen, 0)
+	c.Assert(bytes.Equal(s.fake.objects["convoy/backup.bak"], data), Equals, true)
+
+	// Parts uploaded would be discarded when giving up
+	s.fake.failPuts = -1
+	err := s.service.PutObject("convoy/backup2.bak", bytes.NewReader(data))
+	c.Assert(err, ErrorMatches, "(?s)Failed to upload part .* of convoy/backup2.bak after .* retries.*")
+	c.Assert(s.fake.aborted, Equals, 1)
+	c.Assert(s.fake.uploads, HasLen, 0)
+	_, exists := s.fake.objects["convoy/backup2.bak"]
+	c.Assert(exists, Equals, false)
+}
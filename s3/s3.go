@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/convoy/objectstore"
@@ -51,6 +52,10 @@ func initFunc(destURL string) (objectstore.ObjectStoreDriver, error) {
 		//We would depends on AWS_REGION environment variable
 		b.service.Bucket = u.Host
 	}
+	b.service.Encryption = getEncryption()
+	b.service.UploadOptions = getUploadOptions()
+	b.service.ObjectLock = getObjectLock()
+	b.service.Endpoint, b.service.Client = getEndpoint()
 	b.path = u.Path
 	if b.service.Bucket == "" || b.path == "" {
 		return nil, fmt.Errorf("Invalid URL. Must be either s3://bucket@region/path/, or s3://bucket/path")
@@ -69,6 +74,7 @@ func initFunc(destURL string) (objectstore.ObjectStoreDriver, error) {
 		b.destURL += "@" + b.service.Region
 	}
 	b.destURL += "/" + b.path
+	b.service.DataStorageClass = getStorageClass(b.destURL)
 
 	log.Debug("Loaded driver for %v", b.destURL)
 	return b, nil
@@ -82,6 +88,18 @@ func (s *S3ObjectStoreDriver) GetURL() string {
 	return s.destURL
 }
 
+// GetLockPeriod implements objectstore.ObjectLocker, objects written are
+// retained by S3 Object Lock for the period
+func (s *S3ObjectStoreDriver) GetLockPeriod() time.Duration {
+	return s.service.ObjectLock.Period
+}
+
+// RetrieveObject implements objectstore.ObjectRetriever, archived objects are
+// retrieved for S3_RETRIEVAL_DAYS
+func (s *S3ObjectStoreDriver) RetrieveObject(path string) (bool, error) {
+	return s.service.RetrieveObject(s.updatePath(path), S3_RETRIEVAL_DAYS)
+}
+
 func (s *S3ObjectStoreDriver) updatePath(path string) string {
 	return filepath.Join(s.path, path)
 }
@@ -149,6 +167,9 @@ func (s *S3ObjectStoreDriver) Remove(names ...string) error {
 func (s *S3ObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
 	path := s.updatePath(src)
 	rc, err := s.service.GetObject(path)
+	if err == errObjectArchived {
+		return nil, &objectstore.RetrievalRequiredError{Path: src}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -163,7 +184,7 @@ func (s *S3ObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
 func (s *S3ObjectStoreDriver) Upload(src, dst string) error {
 	file, err := os.Open(src)
 	if err != nil {
-		return nil
+		return err
 	}
 	defer file.Close()
 	path := s.updatePath(dst)
@@ -181,6 +202,9 @@ func (s *S3ObjectStoreDriver) Download(src, dst string) error {
 	defer f.Close()
 	path := s.updatePath(src)
 	rc, err := s.service.GetObject(path)
+	if err == errObjectArchived {
+		return &objectstore.RetrievalRequiredError{Path: src}
+	}
 	if err != nil {
 		return err
 	}
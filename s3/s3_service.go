@@ -1,22 +1,48 @@
 package s3
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	ERR_CODE_INVALID_OBJECT_STATE      = "InvalidObjectState"
+	ERR_CODE_RESTORE_ALREADY_INPROCESS = "RestoreAlreadyInProgress"
+)
+
+var (
+	// errObjectArchived is returned reading objects archived by their
+	// storage class, which have to be retrieved first
+	errObjectArchived = errors.New("object is archived")
 )
 
 type S3Service struct {
-	Region string
-	Bucket string
+	Region        string
+	Bucket        string
+	Encryption    Encryption
+	UploadOptions UploadOptions
+	ObjectLock    ObjectLock
+	// Storage class of blocks and files of backups, the default of the
+	// bucket if empty
+	DataStorageClass string
+	// Endpoint of S3 compatible service, AWS by default
+	Endpoint string
+	// Client sends requests, the default client of SDK if nil
+	Client *http.Client
 }
 
 func (s *S3Service) New() (*s3.S3, error) {
-	return s3.New(session.New(), &aws.Config{Region: &s.Region}), nil
+	config := &aws.Config{Region: &s.Region}
+	s.applyEndpoint(config)
+	return s3.New(util.NewAWSSession(), config), nil
 }
 
 func (s *S3Service) Close() {
@@ -64,6 +90,7 @@ func (s *S3Service) HeadObject(key string) (*s3.HeadObjectOutput, error) {
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(key),
 	}
+	s.Encryption.applyHead(params)
 	resp, err := svc.HeadObject(params)
 	if err != nil {
 		return nil, parseAwsError(resp.String(), err)
@@ -78,17 +105,17 @@ func (s *S3Service) PutObject(key string, reader io.ReadSeeker) error {
 	}
 	defer s.Close()
 
-	params := &s3.PutObjectInput{
-		Bucket: aws.String(s.Bucket),
-		Key:    aws.String(key),
-		Body:   reader,
-	}
-
-	resp, err := svc.PutObject(params)
+	size, err := reader.Seek(0, io.SeekEnd)
 	if err != nil {
-		return parseAwsError(resp.String(), err)
+		return err
 	}
-	return nil
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if size <= s.UploadOptions.PartSize {
+		return s.putObjectWithRetry(svc, key, reader)
+	}
+	return s.multipartUpload(svc, key, reader, size)
 }
 
 func (s *S3Service) GetObject(key string) (io.ReadCloser, error) {
@@ -102,15 +129,63 @@ func (s *S3Service) GetObject(key string) (io.ReadCloser, error) {
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(key),
 	}
+	s.Encryption.applyGet(params)
 
 	resp, err := svc.GetObject(params)
 	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == ERR_CODE_INVALID_OBJECT_STATE {
+			return nil, errObjectArchived
+		}
 		return nil, parseAwsError(resp.String(), err)
 	}
 
 	return resp.Body, nil
 }
 
+/*
+RetrieveObject requests the object at key to be retrieved for days if it's
+archived, and returns true if it can be read already. Retrieval takes hours,
+the object stays archived, and a readable copy of it is kept for days.
+*/
+func (s *S3Service) RetrieveObject(key string, days int64) (bool, error) {
+	head, err := s.HeadObject(key)
+	if err != nil {
+		return false, err
+	}
+	if head.StorageClass == nil || !isArchivedStorageClass(*head.StorageClass) {
+		return true, nil
+	}
+	if head.Restore != nil {
+		if strings.Contains(*head.Restore, `ongoing-request="false"`) {
+			return true, nil
+		}
+		if strings.Contains(*head.Restore, `ongoing-request="true"`) {
+			return false, nil
+		}
+	}
+
+	svc, err := s.New()
+	if err != nil {
+		return false, err
+	}
+	defer s.Close()
+	params := &s3.RestoreObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		RestoreRequest: &s3.RestoreRequest{
+			Days: aws.Int64(days),
+		},
+	}
+	resp, err := svc.RestoreObject(params)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == ERR_CODE_RESTORE_ALREADY_INPROCESS {
+			return false, nil
+		}
+		return false, parseAwsError(resp.String(), err)
+	}
+	return false, nil
+}
+
 func (s *S3Service) DeleteObjects(keys []string) error {
 	var keyList []string
 	totalSize := 0
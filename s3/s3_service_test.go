@@ -6,15 +6,12 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
-	"testing"
 
 	"github.com/Sirupsen/logrus"
 
 	. "gopkg.in/check.v1"
 )
 
-func Test(t *testing.T) { TestingT(t) }
-
 type TestSuite struct {
 	service S3Service
 }
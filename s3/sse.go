@@ -0,0 +1,162 @@
+package s3
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	SSE_S3  = "sse-s3"
+	SSE_KMS = "sse-kms"
+	SSE_C   = "sse-c"
+
+	SSE_CUSTOMER_ALGORITHM = "AES256"
+	SSE_CUSTOMER_KEY_SIZE  = 32
+)
+
+var (
+	cannedACLs = []string{
+		s3.ObjectCannedACLPrivate,
+		s3.ObjectCannedACLPublicRead,
+		s3.ObjectCannedACLPublicReadWrite,
+		s3.ObjectCannedACLAuthenticatedRead,
+		s3.ObjectCannedACLAwsExecRead,
+		s3.ObjectCannedACLBucketOwnerRead,
+		s3.ObjectCannedACLBucketOwnerFullControl,
+	}
+
+	encryption      Encryption
+	encryptionMutex sync.RWMutex
+)
+
+/*
+Encryption of objects written to S3. Mode is one of:
+
+	sse-s3    encrypted by S3 with keys managed by S3
+	sse-kms   encrypted by S3 with KMS key KMSKeyID, or the default key of
+	          S3 in KMS if it's empty
+	sse-c     encrypted by S3 with CustomerKey, which has to be provided
+	          to read the objects as well
+
+Empty Mode leaves it to the default encryption of the bucket. Headers are set
+explicitly on every write, so bucket policies denying unencrypted uploads
+would be satisfied. ACL is a canned ACL set on every write, e.g.
+bucket-owner-full-control for buckets owned by another account.
+*/
+type Encryption struct {
+	Mode        string
+	KMSKeyID    string
+	CustomerKey []byte
+	ACL         string
+}
+
+func (e *Encryption) validate() error {
+	switch e.Mode {
+	case "", SSE_S3:
+	case SSE_KMS:
+	case SSE_C:
+		if len(e.CustomerKey) != SSE_CUSTOMER_KEY_SIZE {
+			return fmt.Errorf("Invalid customer key for %v, should be %v bytes but got %v",
+				SSE_C, SSE_CUSTOMER_KEY_SIZE, len(e.CustomerKey))
+		}
+	default:
+		return fmt.Errorf("Invalid S3 server-side encryption %v, should be one of %v, %v and %v",
+			e.Mode, SSE_S3, SSE_KMS, SSE_C)
+	}
+	if e.KMSKeyID != "" && e.Mode != SSE_KMS {
+		return fmt.Errorf("KMS key ID can only be specified with %v", SSE_KMS)
+	}
+	if len(e.CustomerKey) != 0 && e.Mode != SSE_C {
+		return fmt.Errorf("Customer key can only be specified with %v", SSE_C)
+	}
+	if e.ACL != "" {
+		for _, acl := range cannedACLs {
+			if e.ACL == acl {
+				return nil
+			}
+		}
+		return fmt.Errorf("Invalid S3 canned ACL %v, should be one of %v", e.ACL, cannedACLs)
+	}
+	return nil
+}
+
+// SetEncryption applies to S3 objectstore drivers created afterwards
+func SetEncryption(e Encryption) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	encryptionMutex.Lock()
+	defer encryptionMutex.Unlock()
+
+	encryption = e
+	return nil
+}
+
+func getEncryption() Encryption {
+	encryptionMutex.RLock()
+	defer encryptionMutex.RUnlock()
+
+	return encryption
+}
+
+func (e *Encryption) customerKey() (*string, *string) {
+	if e.Mode != SSE_C {
+		return nil, nil
+	}
+	// SDK would encode the key and add its MD5
+	return aws.String(SSE_CUSTOMER_ALGORITHM), aws.String(string(e.CustomerKey))
+}
+
+func (e *Encryption) applyPut(params *s3.PutObjectInput) {
+	switch e.Mode {
+	case SSE_S3:
+		params.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAes256)
+	case SSE_KMS:
+		params.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+		if e.KMSKeyID != "" {
+			params.SSEKMSKeyId = aws.String(e.KMSKeyID)
+		}
+	case SSE_C:
+		params.SSECustomerAlgorithm, params.SSECustomerKey = e.customerKey()
+	}
+	if e.ACL != "" {
+		params.ACL = aws.String(e.ACL)
+	}
+}
+
+func (e *Encryption) applyCreateMultipart(params *s3.CreateMultipartUploadInput) {
+	switch e.Mode {
+	case SSE_S3:
+		params.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAes256)
+	case SSE_KMS:
+		params.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+		if e.KMSKeyID != "" {
+			params.SSEKMSKeyId = aws.String(e.KMSKeyID)
+		}
+	case SSE_C:
+		params.SSECustomerAlgorithm, params.SSECustomerKey = e.customerKey()
+	}
+	if e.ACL != "" {
+		params.ACL = aws.String(e.ACL)
+	}
+}
+
+// Every part of multipart upload has to carry the customer key used to create
+// the upload
+func (e *Encryption) applyUploadPart(params *s3.UploadPartInput) {
+	params.SSECustomerAlgorithm, params.SSECustomerKey = e.customerKey()
+}
+
+// Objects encrypted with customer key can only be read or inspected with the
+// same key
+func (e *Encryption) applyGet(params *s3.GetObjectInput) {
+	params.SSECustomerAlgorithm, params.SSECustomerKey = e.customerKey()
+}
+
+func (e *Encryption) applyHead(params *s3.HeadObjectInput) {
+	params.SSECustomerAlgorithm, params.SSECustomerKey = e.customerKey()
+}
@@ -0,0 +1,74 @@
+package s3
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type EncryptionTestSuite struct{}
+
+var _ = Suite(&EncryptionTestSuite{})
+
+func (s *EncryptionTestSuite) TearDownTest(c *C) {
+	c.Assert(SetEncryption(Encryption{}), IsNil)
+}
+
+func (s *EncryptionTestSuite) TestSetEncryption(c *C) {
+	key := bytes.Repeat([]byte{'k'}, SSE_CUSTOMER_KEY_SIZE)
+
+	c.Assert(SetEncryption(Encryption{Mode: "aes"}), ErrorMatches, "Invalid S3 server-side encryption.*")
+	c.Assert(SetEncryption(Encryption{Mode: SSE_C, CustomerKey: key[1:]}), ErrorMatches, "Invalid customer key.*")
+	c.Assert(SetEncryption(Encryption{Mode: SSE_S3, KMSKeyID: "alias/backups"}), ErrorMatches, "KMS key ID can only.*")
+	c.Assert(SetEncryption(Encryption{Mode: SSE_KMS, CustomerKey: key}), ErrorMatches, "Customer key can only.*")
+	c.Assert(SetEncryption(Encryption{ACL: "owner"}), ErrorMatches, "Invalid S3 canned ACL.*")
+
+	c.Assert(SetEncryption(Encryption{Mode: SSE_KMS, KMSKeyID: "alias/backups", ACL: "bucket-owner-full-control"}), IsNil)
+	c.Assert(getEncryption().KMSKeyID, Equals, "alias/backups")
+}
+
+func (s *EncryptionTestSuite) TestApplyEncryption(c *C) {
+	key := bytes.Repeat([]byte{'k'}, SSE_CUSTOMER_KEY_SIZE)
+
+	put := &s3.PutObjectInput{}
+	get := &s3.GetObjectInput{}
+	e := &Encryption{}
+	e.applyPut(put)
+	e.applyGet(get)
+	c.Assert(put, DeepEquals, &s3.PutObjectInput{})
+	c.Assert(get, DeepEquals, &s3.GetObjectInput{})
+
+	e = &Encryption{Mode: SSE_S3, ACL: s3.ObjectCannedACLBucketOwnerFullControl}
+	e.applyPut(put)
+	c.Assert(*put.ServerSideEncryption, Equals, "AES256")
+	c.Assert(*put.ACL, Equals, "bucket-owner-full-control")
+	c.Assert(put.SSEKMSKeyId, IsNil)
+
+	put = &s3.PutObjectInput{}
+	e = &Encryption{Mode: SSE_KMS, KMSKeyID: "alias/backups"}
+	e.applyPut(put)
+	c.Assert(*put.ServerSideEncryption, Equals, "aws:kms")
+	c.Assert(*put.SSEKMSKeyId, Equals, "alias/backups")
+	c.Assert(put.ACL, IsNil)
+
+	put = &s3.PutObjectInput{}
+	head := &s3.HeadObjectInput{}
+	e = &Encryption{Mode: SSE_C, CustomerKey: key}
+	e.applyPut(put)
+	e.applyGet(get)
+	e.applyHead(head)
+	c.Assert(put.ServerSideEncryption, IsNil)
+	for _, p := range [][]*string{
+		{put.SSECustomerAlgorithm, put.SSECustomerKey},
+		{get.SSECustomerAlgorithm, get.SSECustomerKey},
+		{head.SSECustomerAlgorithm, head.SSECustomerKey},
+	} {
+		c.Assert(*p[0], Equals, "AES256")
+		c.Assert(*p[1], Equals, string(key))
+	}
+}
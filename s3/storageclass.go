@@ -0,0 +1,131 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/rancher/convoy/objectstore"
+)
+
+const (
+	STORAGE_CLASS_STANDARD            = "STANDARD"
+	STORAGE_CLASS_STANDARD_IA         = "STANDARD_IA"
+	STORAGE_CLASS_ONEZONE_IA          = "ONEZONE_IA"
+	STORAGE_CLASS_INTELLIGENT_TIERING = "INTELLIGENT_TIERING"
+	STORAGE_CLASS_GLACIER_IR          = "GLACIER_IR"
+	STORAGE_CLASS_GLACIER             = "GLACIER"
+	STORAGE_CLASS_DEEP_ARCHIVE        = "DEEP_ARCHIVE"
+
+	// Data objects are tagged, so lifecycle rules of the bucket can
+	// select them apart from configs
+	HEADER_TAGGING  = "x-amz-tagging"
+	DATA_OBJECT_TAG = "convoy-object=data"
+
+	// Days archived objects stay readable once they're retrieved
+	S3_RETRIEVAL_DAYS = 7
+)
+
+var (
+	storageClasses = []string{
+		STORAGE_CLASS_STANDARD,
+		STORAGE_CLASS_STANDARD_IA,
+		STORAGE_CLASS_ONEZONE_IA,
+		STORAGE_CLASS_INTELLIGENT_TIERING,
+		STORAGE_CLASS_GLACIER_IR,
+		STORAGE_CLASS_GLACIER,
+		STORAGE_CLASS_DEEP_ARCHIVE,
+	}
+
+	// Storage classes of blocks and files of backups, keyed by canonical
+	// URLs of destinations
+	dataStorageClasses = make(map[string]string)
+	storageClassMutex  sync.RWMutex
+)
+
+// isArchivedStorageClass returns whether objects of class have to be
+// retrieved before they can be read
+func isArchivedStorageClass(class string) bool {
+	return class == STORAGE_CLASS_GLACIER || class == STORAGE_CLASS_DEEP_ARCHIVE
+}
+
+/*
+SetStorageClass makes blocks and files of backups uploaded to S3 destination
+destURL stored in class, e.g. STANDARD_IA, while configs stay in STANDARD, so
+listing and inspecting backups won't pay for retrieval. It applies to S3
+objectstore drivers created afterwards.
+*/
+func SetStorageClass(destURL, class string) error {
+	valid := false
+	for _, c := range storageClasses {
+		if class == c {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("Invalid S3 storage class %v, should be one of %v", class, storageClasses)
+	}
+	u, err := getCanonicalURL(destURL)
+	if err != nil {
+		return err
+	}
+
+	storageClassMutex.Lock()
+	defer storageClassMutex.Unlock()
+
+	dataStorageClasses[u] = class
+	return nil
+}
+
+func getStorageClass(destURL string) string {
+	storageClassMutex.RLock()
+	defer storageClassMutex.RUnlock()
+
+	return dataStorageClasses[destURL]
+}
+
+// getCanonicalURL returns destURL in the form of GetURL() of its driver
+func getCanonicalURL(destURL string) (string, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != KIND {
+		return "", fmt.Errorf("Invalid S3 destination %v", destURL)
+	}
+	bucket, region := u.Host, ""
+	if u.User != nil {
+		bucket, region = u.User.Username(), u.Host
+	}
+	path := strings.TrimLeft(u.Path, "/")
+	if bucket == "" || path == "" {
+		return "", fmt.Errorf("Invalid URL. Must be either s3://bucket@region/path/, or s3://bucket/path")
+	}
+	canonical := KIND + "://" + bucket
+	if region != "" {
+		canonical += "@" + region
+	}
+	return canonical + "/" + path, nil
+}
+
+// dataStorageClass returns the storage class the object at key would be
+// written in, empty for the default of the bucket
+func (s *S3Service) dataStorageClass(key string) string {
+	if s.DataStorageClass == "" || !objectstore.IsBackupData(key) {
+		return ""
+	}
+	return s.DataStorageClass
+}
+
+// applyDataTag tags the object written by req if it's stored in the storage
+// class of data
+func (s *S3Service) applyDataTag(req *request.Request, key string) {
+	if s.dataStorageClass(key) == "" {
+		return
+	}
+	req.Handlers.Build.PushBack(func(r *request.Request) {
+		r.HTTPRequest.Header.Set(HEADER_TAGGING, DATA_OBJECT_TAG)
+	})
+}
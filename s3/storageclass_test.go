@@ -0,0 +1,71 @@
+package s3
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MultipartTestSuite) TestSetStorageClass(c *C) {
+	defer func() {
+		dataStorageClasses = make(map[string]string)
+	}()
+
+	c.Assert(SetStorageClass("s3://bucket@us-west-2/convoy", "COLD"), ErrorMatches, "Invalid S3 storage class.*")
+	c.Assert(SetStorageClass("vfs:///backups", STORAGE_CLASS_STANDARD_IA), ErrorMatches, "Invalid S3 destination.*")
+	c.Assert(SetStorageClass("s3://bucket@us-west-2/", STORAGE_CLASS_STANDARD_IA), ErrorMatches, "Invalid URL.*")
+	c.Assert(SetStorageClass("s3://bucket@us-west-2//convoy/", STORAGE_CLASS_GLACIER_IR), IsNil)
+	c.Assert(SetStorageClass("s3://other/convoy", STORAGE_CLASS_DEEP_ARCHIVE), IsNil)
+	c.Assert(getStorageClass("s3://bucket@us-west-2/convoy/"), Equals, STORAGE_CLASS_GLACIER_IR)
+	c.Assert(getStorageClass("s3://other/convoy"), Equals, STORAGE_CLASS_DEEP_ARCHIVE)
+	c.Assert(getStorageClass("s3://bucket@us-east-1/convoy/"), Equals, "")
+}
+
+func (s *MultipartTestSuite) TestDataStorageClass(c *C) {
+	s.service.DataStorageClass = STORAGE_CLASS_STANDARD_IA
+	c.Assert(s.service.PutObject("convoy/volume.cfg", bytes.NewReader([]byte("config"))), IsNil)
+	c.Assert(s.service.PutObject("convoy/blocks/0123.blk", bytes.NewReader([]byte("block"))), IsNil)
+	c.Assert(s.service.PutObject("convoy/BackupFiles/backup.bak", bytes.NewReader(make([]byte, MIN_PART_SIZE+1))), IsNil)
+	c.Assert(s.fake.writeHeaders, HasLen, 5)
+
+	// Configs stay in the default class of the bucket
+	c.Assert(s.fake.writeHeaders[0].Get("x-amz-storage-class"), Equals, "")
+	c.Assert(s.fake.writeHeaders[0].Get(HEADER_TAGGING), Equals, "")
+	c.Assert(s.fake.writeHeaders[1].Get("x-amz-storage-class"), Equals, STORAGE_CLASS_STANDARD_IA)
+	c.Assert(s.fake.writeHeaders[1].Get(HEADER_TAGGING), Equals, DATA_OBJECT_TAG)
+	// Class of multipart uploads is set when they're created
+	c.Assert(s.fake.writeHeaders[2].Get("x-amz-storage-class"), Equals, STORAGE_CLASS_STANDARD_IA)
+	c.Assert(s.fake.writeHeaders[2].Get(HEADER_TAGGING), Equals, DATA_OBJECT_TAG)
+}
+
+func (s *MultipartTestSuite) TestRetrieveObject(c *C) {
+	c.Assert(s.service.PutObject("convoy/blocks/0123.blk", bytes.NewReader([]byte("block"))), IsNil)
+	ready, err := s.service.RetrieveObject("convoy/blocks/0123.blk", S3_RETRIEVAL_DAYS)
+	c.Assert(err, IsNil)
+	c.Assert(ready, Equals, true)
+
+	s.fake.archived["convoy/blocks/0123.blk"] = ""
+	_, err = s.service.GetObject("convoy/blocks/0123.blk")
+	c.Assert(err, Equals, errObjectArchived)
+
+	// Retrieval is requested once
+	ready, err = s.service.RetrieveObject("convoy/blocks/0123.blk", S3_RETRIEVAL_DAYS)
+	c.Assert(err, IsNil)
+	c.Assert(ready, Equals, false)
+	ready, err = s.service.RetrieveObject("convoy/blocks/0123.blk", S3_RETRIEVAL_DAYS)
+	c.Assert(err, IsNil)
+	c.Assert(ready, Equals, false)
+	c.Assert(s.fake.restores, Equals, 1)
+
+	s.fake.archived["convoy/blocks/0123.blk"] = `ongoing-request="false", expiry-date="Fri, 23 Dec 2026 00:00:00 GMT"`
+	ready, err = s.service.RetrieveObject("convoy/blocks/0123.blk", S3_RETRIEVAL_DAYS)
+	c.Assert(err, IsNil)
+	c.Assert(ready, Equals, true)
+	rc, err := s.service.GetObject("convoy/blocks/0123.blk")
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "block")
+}
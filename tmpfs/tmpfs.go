@@ -0,0 +1,383 @@
+// +build linux
+
+package tmpfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "tmpfs"
+	DRIVER_CONFIG_FILE = "tmpfs.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	TMPFS_CFG_PREFIX  = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	MOUNTS_DIR = "mounts"
+
+	TMPFS_DEFAULT_VOLUME_SIZE = "tmpfs.defaultvolumesize"
+	TMPFS_MOUNT_OPTIONS       = "tmpfs.mountoptions"
+
+	DEFAULT_VOLUME_SIZE = "1G"
+
+	TMPFS_DEVICE = "tmpfs"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "tmpfs"})
+)
+
+/*
+Driver provides size bounded tmpfs mounts as volumes, for scratch data which
+doesn't need to survive. Content of a volume lives in memory(or swap) from
+mount to umount, and would be lost afterwards.
+*/
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+}
+
+type Device struct {
+	Root              string
+	DefaultVolumeSize int64
+	MountOptions      string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+type Volume struct {
+	Name         string
+	Size         int64
+	MountPoint   string
+	MountOptions string
+	CreatedTime  string
+
+	configPath string
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, TMPFS_CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	return TMPFS_DEVICE, nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	opts := "size=" + strconv.FormatInt(v.Size, 10)
+	if v.MountOptions != "" {
+		opts += "," + v.MountOptions
+	}
+	return []string{"-t", "tmpfs", "-o", opts}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func (device *Device) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(device.Root, TMPFS_CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := util.MkdirIfNotExists(root); err != nil {
+			return nil, err
+		}
+
+		if _, exists := config[TMPFS_DEFAULT_VOLUME_SIZE]; !exists {
+			config[TMPFS_DEFAULT_VOLUME_SIZE] = DEFAULT_VOLUME_SIZE
+		}
+		volumeSize, err := util.ParseSize(config[TMPFS_DEFAULT_VOLUME_SIZE])
+		if err != nil || volumeSize == 0 {
+			return nil, fmt.Errorf("Illegal default volume size specified")
+		}
+
+		if err := util.CheckMountOptions(config[TMPFS_MOUNT_OPTIONS]); err != nil {
+			return nil, err
+		}
+
+		dev = &Device{
+			Root:              root,
+			DefaultVolumeSize: volumeSize,
+			MountOptions:      config[TMPFS_MOUNT_OPTIONS],
+		}
+	}
+	if err := util.ObjectSave(dev); err != nil {
+		return nil, err
+	}
+
+	d := &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+	if err := d.remountVolumes(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// remountVolumes would mount volumes which were mounted before again, e.g.
+// after reboot. Their content is gone by then, so they would start empty.
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		req := Request{
+			Name: id,
+			Options: map[string]string{
+				OPT_MOUNT_POINT: volume.MountPoint,
+			},
+		}
+		if _, err := d.MountVolume(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"Driver":            d.Name(),
+		"Root":              d.Root,
+		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"MountOptions":      d.MountOptions,
+	}, nil
+}
+
+func (d *Driver) Capabilities() Capabilities {
+	return Capabilities{
+		CustomMountPoint: true,
+		ReadOnlyMount:    true,
+		SELinuxLabel:     true,
+	}
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSize(opts map[string]string, defaultVolumeSize int64) (int64, error) {
+	size := opts[OPT_SIZE]
+	if size == "" || size == "0" {
+		size = strconv.FormatInt(defaultVolumeSize, 10)
+	}
+	return util.ParseSize(size)
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	if opts[OPT_BACKUP_URL] != "" {
+		return fmt.Errorf("tmpfs doesn't support creating volume from backup")
+	}
+	size, err := d.getSize(opts, d.DefaultVolumeSize)
+	if err != nil {
+		return err
+	}
+	if size <= 0 {
+		return fmt.Errorf("Invalid volume size %v", size)
+	}
+	mountOpts := opts[OPT_MOUNT_OPTIONS]
+	if mountOpts == "" {
+		mountOpts = d.MountOptions
+	}
+	if err := util.CheckMountOptions(mountOpts); err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Volume %v already exists", id)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+		LOG_FIELD_SIZE:   size,
+	}).Debug("Creating volume")
+	volume.Size = size
+	volume.MountOptions = mountOpts
+	volume.CreatedTime = util.Now()
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete volume %v, it hasn't been umounted", id)
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+	readOnly, _ := strconv.ParseBool(opts[OPT_READ_ONLY])
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	mountPoint, err := util.VolumeMount(volume, opts[OPT_MOUNT_POINT], false, readOnly, opts[OPT_SELINUX_CONTEXT])
+	if err != nil {
+		return "", err
+	}
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.getVolumeInfo(id)
+}
+
+func (d *Driver) getVolumeInfo(id string) (map[string]string, error) {
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		OPT_VOLUME_NAME:         volume.Name,
+		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
+		OPT_MOUNT_POINT:         volume.MountPoint,
+		OPT_SIZE:                strconv.FormatInt(volume.Size, 10),
+		OPT_MOUNT_OPTIONS:       volume.MountOptions,
+	}, nil
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]map[string]string)
+	for _, id := range volumeIDs {
+		volumes[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return nil, fmt.Errorf("Doesn't support snapshot operations")
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return nil, fmt.Errorf("Doesn't support backup operations")
+}
@@ -0,0 +1,88 @@
+package util
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// APICallCount counts requests sent to an operation of AWS API, including
+// retries, and the ones throttled
+type APICallCount struct {
+	Service   string
+	Operation string
+	Calls     int64
+	Throttled int64
+}
+
+var (
+	// Error codes of AWS APIs for requests throttled
+	awsThrottleCodes = map[string]bool{
+		"Throttling":                             true,
+		"ThrottlingException":                    true,
+		"RequestLimitExceeded":                   true,
+		"RequestThrottled":                       true,
+		"TooManyRequestsException":               true,
+		"ProvisionedThroughputExceededException": true,
+		"SlowDown":                               true,
+	}
+
+	apiCallMutex sync.Mutex
+	apiCalls     = make(map[string]*APICallCount)
+)
+
+func countAPICall(service, operation string, throttled bool) {
+	apiCallMutex.Lock()
+	defer apiCallMutex.Unlock()
+
+	key := service + "." + operation
+	count, exists := apiCalls[key]
+	if !exists {
+		count = &APICallCount{
+			Service:   service,
+			Operation: operation,
+		}
+		apiCalls[key] = count
+	}
+	if throttled {
+		count.Throttled++
+	} else {
+		count.Calls++
+	}
+}
+
+// NewAWSSession returns session of AWS SDK whose clients count requests they
+// send, see APICallCounts()
+func NewAWSSession() *session.Session {
+	sess := session.New()
+	sess.Handlers.Send.PushBack(func(r *request.Request) {
+		countAPICall(r.ClientInfo.ServiceName, r.Operation.Name, false)
+	})
+	sess.Handlers.Retry.PushFront(func(r *request.Request) {
+		if err, ok := r.Error.(awserr.Error); ok && awsThrottleCodes[err.Code()] {
+			countAPICall(r.ClientInfo.ServiceName, r.Operation.Name, true)
+		}
+	})
+	return sess
+}
+
+// APICallCounts returns counts of AWS API calls since daemon started, sorted
+// by service and operation
+func APICallCounts() []APICallCount {
+	apiCallMutex.Lock()
+	defer apiCallMutex.Unlock()
+
+	keys := []string{}
+	for key := range apiCalls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	counts := []APICallCount{}
+	for _, key := range keys {
+		counts = append(counts, *apiCalls[key])
+	}
+	return counts
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	. "gopkg.in/check.v1"
+)
+
+func getAPICallCount(service, operation string) APICallCount {
+	for _, count := range APICallCounts() {
+		if count.Service == service && count.Operation == operation {
+			return count
+		}
+	}
+	return APICallCount{}
+}
+
+func (s *TestSuite) TestAWSAPICallCounts(c *C) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`<Error><Code>SlowDown</Code><Message>Reduce your request rate.</Message></Error>`))
+			return
+		}
+	}))
+	defer server.Close()
+
+	before := getAPICallCount("s3", "ListObjects")
+	client := s3.New(NewAWSSession(), &aws.Config{
+		Region:           aws.String("us-west-2"),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	_, err := client.ListObjects(&s3.ListObjectsInput{
+		Bucket: aws.String("bucket"),
+	})
+	c.Assert(err, IsNil)
+
+	after := getAPICallCount("s3", "ListObjects")
+	c.Assert(after.Calls-before.Calls, Equals, int64(2))
+	c.Assert(after.Throttled-before.Throttled, Equals, int64(1))
+}
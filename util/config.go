@@ -1,87 +1,39 @@
 package util
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
-	"path"
-	"path/filepath"
 	"reflect"
 )
 
 var errDoesNotExist = errors.New("No such volume")
 
+// LoadConfig loads config of fileName from metadata store into v
 func LoadConfig(fileName string, v interface{}) error {
-	if _, err := os.Stat(fileName); err != nil {
-		return err
-	}
-
-	file, err := os.Open(fileName)
-	if err != nil {
-		return err
-	}
-
-	defer file.Close()
-
-	if err = json.NewDecoder(file).Decode(v); err != nil {
-		return err
-	}
-	return nil
+	return getMetadataStore().Load(fileName, v)
 }
 
+// SaveConfig saves v as config of fileName into metadata store
 func SaveConfig(fileName string, v interface{}) error {
-	tmpFileName := fileName + ".tmp"
-
-	f, err := os.Create(tmpFileName)
-	if err != nil {
-		return err
-	}
-
-	if err := json.NewEncoder(f).Encode(v); err != nil {
-		f.Close()
-		return err
-	}
-	f.Close()
-
-	if _, err = os.Stat(fileName); err == nil {
-		if err = os.Remove(fileName); err != nil {
-			return err
-		}
-	}
-
-	if err := os.Rename(tmpFileName, fileName); err != nil {
-		return err
-	}
-
-	return nil
+	return getMetadataStore().Save(fileName, v)
 }
 
 func ConfigExists(fileName string) bool {
-	_, err := os.Stat(fileName)
-	return err == nil
+	exists, err := getMetadataStore().Exists(fileName)
+	if err != nil {
+		log.Errorf("Failed to check config %v: %v", fileName, err)
+		return false
+	}
+	return exists
 }
 
 func RemoveConfig(fileName string) error {
-	if _, err := Execute("rm", []string{"-f", fileName}); err != nil {
-		return err
-	}
-	return nil
+	return getMetadataStore().Remove(fileName)
 }
 
+// ListConfigIDs lists IDs of configs in root named <prefix><ID><suffix>
 func ListConfigIDs(root, prefix, suffix string) ([]string, error) {
-	pattern := path.Join(root, fmt.Sprintf("%s*%s", prefix, suffix))
-	out, err := filepath.Glob(pattern)
-	if err != nil {
-		return nil, err
-	}
-	if len(out) == 0 {
-		return []string{}, nil
-	}
-	for i := range out {
-		out[i] = path.Base(out[i])
-	}
-	return ExtractNames(out, prefix, suffix)
+	return getMetadataStore().List(root, prefix, suffix)
 }
 
 type ObjectOperations interface {
@@ -117,7 +69,11 @@ func ObjectLoad(obj interface{}) error {
 	if err != nil {
 		return err
 	}
-	if !ConfigExists(config) {
+	exists, err := getMetadataStore().Exists(config)
+	if err != nil {
+		return err
+	}
+	if !exists {
 		return errDoesNotExist
 	}
 	if err := LoadConfig(config, obj); err != nil {
@@ -131,7 +87,7 @@ func ObjectExists(obj interface{}) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	return ConfigExists(config), nil
+	return getMetadataStore().Exists(config)
 }
 
 func ObjectSave(obj interface{}) error {
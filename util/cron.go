@@ -0,0 +1,177 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Next run of a cron schedule is looked up within this period, so
+	// expressions which never match, e.g. "0 0 30 2 *", won't loop forever
+	CRON_LOOKAHEAD = 5 * 366 * 24 * time.Hour
+)
+
+var (
+	cronAliases = map[string]string{
+		"@hourly":   "0 * * * *",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@weekly":   "0 0 * * 0",
+		"@monthly":  "0 0 1 * *",
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+	}
+	cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDays   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+type cronField struct {
+	min   int
+	max   int
+	names []string
+}
+
+var cronFields = []cronField{
+	{0, 59, nil},        // minute
+	{0, 23, nil},        // hour
+	{1, 31, nil},        // day of month
+	{1, 12, cronMonths}, // month
+	{0, 7, cronDays},    // day of week, 7 is Sunday as well
+}
+
+/*
+CronSchedule is a standard 5-field cron expression: minute, hour, day of
+month, month and day of week. Fields accept "*", values, names of months and
+days, ranges "a-b", steps "*\/n" or "a-b/n", and lists of them separated by
+",". As in cron, if both day of month and day of week are restricted, a day
+matching either of them matches.
+*/
+type CronSchedule struct {
+	expr   string
+	fields [5]map[int]bool
+	// Whether day of month or day of week is "*"
+	anyDom bool
+	anyDow bool
+}
+
+func parseCronValue(s string, f cronField) (int, error) {
+	for i, name := range f.names {
+		if strings.ToLower(s) == name {
+			return i + f.min, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("Invalid value %v, should be %v-%v", s, f.min, f.max)
+	}
+	return v, nil
+}
+
+func parseCronField(spec string, f cronField) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("Invalid step of %v", part)
+			}
+			part = part[:i]
+		}
+		start, end := f.min, f.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = parseCronValue(bounds[0], f); err != nil {
+				return nil, err
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = parseCronValue(bounds[1], f); err != nil {
+					return nil, err
+				}
+			} else if step != 1 {
+				// "a/n" means from a to the end
+				end = f.max
+			}
+			if end < start {
+				return nil, fmt.Errorf("Invalid range %v", part)
+			}
+		}
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// ParseCron parses a 5-field cron expression, or one of @hourly, @daily,
+// @weekly, @monthly and @yearly
+func ParseCron(expr string) (*CronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if alias, ok := cronAliases[strings.ToLower(spec)]; ok {
+		spec = alias
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("Invalid cron expression %q, should have 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+	c := &CronSchedule{
+		expr:   expr,
+		anyDom: parts[2] == "*",
+		anyDow: parts[4] == "*",
+	}
+	for i, part := range parts {
+		values, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cron expression %q: %v", expr, err)
+		}
+		c.fields[i] = values
+	}
+	if c.fields[4][7] {
+		c.fields[4][0] = true
+	}
+	return c, nil
+}
+
+func (c *CronSchedule) String() string {
+	return c.expr
+}
+
+func (c *CronSchedule) matchDay(t time.Time) bool {
+	dom := c.fields[2][t.Day()]
+	dow := c.fields[4][int(t.Weekday())]
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time matching the schedule after t, in the time
+// zone of t, or zero time if there's none within CRON_LOOKAHEAD
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	deadline := t.Add(CRON_LOOKAHEAD)
+	for t.Before(deadline) {
+		if !c.fields[3][int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.fields[1][t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.fields[0][t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
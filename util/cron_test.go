@@ -0,0 +1,65 @@
+package util
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestParseCron(c *C) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "x * * * *", "@often"} {
+		_, err := ParseCron(expr)
+		c.Assert(err, NotNil, Commentf("%v", expr))
+	}
+
+	sc, err := ParseCron("@daily")
+	c.Assert(err, IsNil)
+	c.Assert(sc.String(), Equals, "@daily")
+
+	sc, err = ParseCron("*/15 1-3,22 * jan-mar,dec mon-fri")
+	c.Assert(err, IsNil)
+	c.Assert(sc.fields[0], DeepEquals, map[int]bool{0: true, 15: true, 30: true, 45: true})
+	c.Assert(sc.fields[1], DeepEquals, map[int]bool{1: true, 2: true, 3: true, 22: true})
+	c.Assert(sc.fields[3], HasLen, 4)
+	c.Assert(sc.fields[4], HasLen, 5)
+
+	sc, err = ParseCron("10/20 * * * 7")
+	c.Assert(err, IsNil)
+	c.Assert(sc.fields[0], DeepEquals, map[int]bool{10: true, 30: true, 50: true})
+	c.Assert(sc.fields[4][0], Equals, true)
+}
+
+func (s *TestSuite) TestCronNext(c *C) {
+	at := func(s string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	cases := []struct {
+		expr string
+		from string
+		next string
+	}{
+		{"*/15 * * * *", "2026-03-02 10:00", "2026-03-02 10:15"},
+		{"*/15 * * * *", "2026-03-02 10:59", "2026-03-02 11:00"},
+		{"30 2 * * *", "2026-03-02 02:30", "2026-03-03 02:30"},
+		{"0 0 * * 0", "2026-03-02 10:00", "2026-03-08 00:00"},
+		{"0 0 1 * *", "2026-12-15 00:00", "2027-01-01 00:00"},
+		{"0 12 29 2 *", "2026-03-01 00:00", "2028-02-29 12:00"},
+		// Either day of month or day of week matches if both restricted
+		{"0 0 15 * mon", "2026-03-02 10:00", "2026-03-09 00:00"},
+		{"0 0 3 * mon", "2026-03-02 10:00", "2026-03-03 00:00"},
+	}
+	for _, tc := range cases {
+		sc, err := ParseCron(tc.expr)
+		c.Assert(err, IsNil)
+		c.Assert(sc.Next(at(tc.from)), Equals, at(tc.next), Commentf("%v from %v", tc.expr, tc.from))
+	}
+
+	sc, err := ParseCron("0 0 30 2 *")
+	c.Assert(err, IsNil)
+	c.Assert(sc.Next(at("2026-03-02 10:00")).IsZero(), Equals, true)
+}
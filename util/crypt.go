@@ -0,0 +1,283 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	CRYPTSETUP_BINARY = "cryptsetup"
+
+	CRYPT_DEVICE_DIR    = "/dev/mapper"
+	CRYPT_DEVICE_PREFIX = "convoy-crypt-"
+	CRYPT_FS_TYPE       = "crypto_LUKS"
+
+	ENCRYPTION_KEY_ID_PATTERN = "[a-zA-Z0-9][a-zA-Z0-9_.-]*"
+)
+
+var (
+	encryptionKeyIDRegex = regexp.MustCompile("^" + ENCRYPTION_KEY_ID_PATTERN + "$")
+
+	encryptionKeyDir  string
+	encryptionKeyHook string
+)
+
+/*
+Encryption key of a volume is specified by its ID, and only the ID is saved
+with the volume, never the key itself. Where keys come from is configured for
+daemon, so requests can't make it read arbitrary files or run arbitrary
+executables:
+
+	dir    <dir>/<id>, content of the file
+	hook   stdout of the executable, called with the key ID and volume name
+	       as arguments, e.g. a hook fetching the key from external KMS.
+	       Trailing newline would be stripped
+
+If both are configured, the hook is called for IDs without a file in dir.
+The key would be used as LUKS passphrase of the volume's block device.
+*/
+func InitEncryptionKeySources(dir, hook string) error {
+	if dir != "" {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("Invalid encryption key directory %v, path must be absolute", dir)
+		}
+		st, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !st.IsDir() {
+			return fmt.Errorf("Encryption key directory %v is not a directory", dir)
+		}
+	}
+	if hook != "" {
+		if !filepath.IsAbs(hook) {
+			return fmt.Errorf("Invalid encryption key hook %v, path must be absolute", hook)
+		}
+		st, err := os.Stat(hook)
+		if err != nil {
+			return err
+		}
+		if st.IsDir() || st.Mode()&0111 == 0 {
+			return fmt.Errorf("Encryption key hook %v is not executable", hook)
+		}
+	}
+	encryptionKeyDir = dir
+	encryptionKeyHook = hook
+	return nil
+}
+
+// CheckEncryptionKey validates ID of encryption key, e.g. "db"
+func CheckEncryptionKey(id string) error {
+	if !encryptionKeyIDRegex.MatchString(id) {
+		return fmt.Errorf("Invalid encryption key ID %q, should match %v", id, ENCRYPTION_KEY_ID_PATTERN)
+	}
+	return nil
+}
+
+// CheckEncryptionKeySource returns error if keys cannot be retrieved by
+// daemon, i.e. no source of them is configured
+func CheckEncryptionKeySource() error {
+	if encryptionKeyDir == "" && encryptionKeyHook == "" {
+		return fmt.Errorf("No source of encryption keys is configured for daemon, see --encryption-key-dir and --encryption-key-hook")
+	}
+	return nil
+}
+
+// getEncryptionKey retrieves the key id of volume name from the configured
+// sources
+func getEncryptionKey(id, name string) ([]byte, error) {
+	if err := CheckEncryptionKey(id); err != nil {
+		return nil, err
+	}
+	if err := CheckEncryptionKeySource(); err != nil {
+		return nil, err
+	}
+	var (
+		key []byte
+		err error
+	)
+	if encryptionKeyDir != "" {
+		key, err = ioutil.ReadFile(filepath.Join(encryptionKeyDir, id))
+		if err != nil && (!os.IsNotExist(err) || encryptionKeyHook == "") {
+			return nil, err
+		}
+	}
+	if key == nil && encryptionKeyHook != "" {
+		output, err := Execute(encryptionKeyHook, []string{id, name})
+		if err != nil {
+			return nil, err
+		}
+		key = []byte(strings.TrimSuffix(output, "\n"))
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("Empty encryption key %v for volume %v", id, name)
+	}
+	return key, nil
+}
+
+// callCryptsetup runs cryptsetup with key passed through stdin, so it won't
+// show up in process list or be left in a file
+func callCryptsetup(key []byte, args ...string) error {
+	if key != nil {
+		args = append([]string{"--key-file=-"}, args...)
+	}
+	cmd := exec.Command(CRYPTSETUP_BINARY, args...)
+	cmd.Stdin = bytes.NewReader(key)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to execute: %v %v, output %v, error %v", CRYPTSETUP_BINARY, args, string(output), err)
+	}
+	return nil
+}
+
+// EncryptedDevicePath returns the device of opened encrypted volume name
+func EncryptedDevicePath(name string) string {
+	return filepath.Join(CRYPT_DEVICE_DIR, CRYPT_DEVICE_PREFIX+name)
+}
+
+func isEncryptedDeviceOpened(name string) bool {
+	_, err := os.Stat(EncryptedDevicePath(name))
+	return err == nil
+}
+
+// EncryptDevice formats dev as LUKS with the key of volume name. All data on
+// dev would be lost.
+func EncryptDevice(dev, name, keyID string) error {
+	key, err := getEncryptionKey(keyID, name)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Formatting device %v of volume %v as LUKS", dev, name)
+	return callCryptsetup(key, "--batch-mode", "luksFormat", dev)
+}
+
+// OpenEncryptedDevice unlocks LUKS device dev of volume name, and returns the
+// device of decrypted data. It's no-op if the device has been opened.
+func OpenEncryptedDevice(dev, name, keyID string) (string, error) {
+	if isEncryptedDeviceOpened(name) {
+		return EncryptedDevicePath(name), nil
+	}
+	key, err := getEncryptionKey(keyID, name)
+	if err != nil {
+		return "", err
+	}
+	if err := callCryptsetup(key, "luksOpen", dev, CRYPT_DEVICE_PREFIX+name); err != nil {
+		return "", err
+	}
+	return EncryptedDevicePath(name), nil
+}
+
+// CloseEncryptedDevice locks the encrypted volume name. It's no-op if the
+// device isn't opened.
+func CloseEncryptedDevice(name string) error {
+	if !isEncryptedDeviceOpened(name) {
+		return nil
+	}
+	return callCryptsetup(nil, "luksClose", CRYPT_DEVICE_PREFIX+name)
+}
+
+// resizeEncryptedDevice grows opened encrypted volume to the size of its
+// underlying device
+func resizeEncryptedDevice(name, keyID string) error {
+	key, err := getEncryptionKey(keyID, name)
+	if err != nil {
+		return err
+	}
+	return callCryptsetup(key, "resize", CRYPT_DEVICE_PREFIX+name)
+}
+
+// getVolumeEncryptionKey returns ID of encryption key of the
+// volume, from optional field "EncryptionKey". Empty means not encrypted.
+func getVolumeEncryptionKey(v VolumeHelper) string {
+	value, err := getFieldString(v, "EncryptionKey")
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// getVolumeFilesystemDevice returns the device containing filesystem of the
+// volume, opening it first if the volume is encrypted
+func getVolumeFilesystemDevice(v VolumeHelper) (string, error) {
+	dev, err := v.GetDevice()
+	if err != nil {
+		return "", err
+	}
+	keyID := getVolumeEncryptionKey(v)
+	if keyID == "" {
+		return dev, nil
+	}
+	return OpenEncryptedDevice(dev, getVolumeName(v), keyID)
+}
+
+/*
+FormatVolume creates filesystem on the device of the volume. If the volume has
+an encryption key, the device would be formatted as LUKS first, and the
+filesystem is created on the decrypted device, which is closed afterwards.
+*/
+func FormatVolume(v interface{}, fsType, mkfsOpts string) error {
+	vol, err := getVolumeOps(v)
+	if err != nil {
+		return err
+	}
+	dev, err := vol.GetDevice()
+	if err != nil {
+		return err
+	}
+	keyID := getVolumeEncryptionKey(vol)
+	if keyID == "" {
+		return FormatDevice(dev, fsType, mkfsOpts)
+	}
+	if err := CheckFilesystem(fsType); err != nil {
+		return err
+	}
+	name := getVolumeName(vol)
+	if err := EncryptDevice(dev, name, keyID); err != nil {
+		return err
+	}
+	fsDev, err := OpenEncryptedDevice(dev, name, keyID)
+	if err != nil {
+		return err
+	}
+	err = FormatDevice(fsDev, fsType, mkfsOpts)
+	if closeErr := CloseEncryptedDevice(name); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+/*
+GetVolumeFilesystemType detects filesystem of the volume, e.g. restored from
+backup. Encrypted volume would be opened to detect the filesystem inside, and
+closed afterwards if it wasn't opened before.
+*/
+func GetVolumeFilesystemType(v interface{}) (string, error) {
+	vol, err := getVolumeOps(v)
+	if err != nil {
+		return "", err
+	}
+	name := getVolumeName(vol)
+	opened := isEncryptedDeviceOpened(name)
+	dev, err := getVolumeFilesystemDevice(vol)
+	if err != nil {
+		return "", err
+	}
+	fsType, err := GetFilesystemType(dev)
+	if getVolumeEncryptionKey(vol) != "" && !opened {
+		if closeErr := CloseEncryptedDevice(name); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if fsType == CRYPT_FS_TYPE {
+		return "", fmt.Errorf("Volume %v is encrypted, encryption key must be specified", name)
+	}
+	return fsType, nil
+}
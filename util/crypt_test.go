@@ -0,0 +1,66 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestEncryptionKey(c *C) {
+	keyDir := filepath.Join(testRoot, "keys")
+	c.Assert(os.MkdirAll(keyDir, 0700), IsNil)
+	err := ioutil.WriteFile(filepath.Join(keyDir, "db"), []byte("file key\n"), 0600)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(keyDir, "empty"), []byte{}, 0600)
+	c.Assert(err, IsNil)
+	hook := filepath.Join(testRoot, "kms-hook")
+	err = ioutil.WriteFile(hook, []byte("#!/bin/sh\necho \"kms key $1 of $2\"\n"), 0700)
+	c.Assert(err, IsNil)
+	defer InitEncryptionKeySources("", "")
+
+	c.Assert(CheckEncryptionKey("db"), IsNil)
+	c.Assert(CheckEncryptionKey("db-key_1.v2"), IsNil)
+	c.Assert(CheckEncryptionKey(""), NotNil)
+	c.Assert(CheckEncryptionKey("file:/etc/convoy/key"), NotNil)
+	c.Assert(CheckEncryptionKey("../db"), NotNil)
+	c.Assert(CheckEncryptionKey(".db"), NotNil)
+	c.Assert(CheckEncryptionKey("keys/db"), NotNil)
+
+	c.Assert(InitEncryptionKeySources("", ""), IsNil)
+	c.Assert(CheckEncryptionKeySource(), NotNil)
+	_, err = getEncryptionKey("db", "vol1")
+	c.Assert(err, ErrorMatches, "No source of encryption keys.*")
+
+	c.Assert(InitEncryptionKeySources("keys", ""), NotNil)
+	c.Assert(InitEncryptionKeySources(filepath.Join(testRoot, "nokeys"), ""), NotNil)
+	c.Assert(InitEncryptionKeySources("", "kms-hook"), NotNil)
+	c.Assert(InitEncryptionKeySources("", filepath.Join(keyDir, "db")), ErrorMatches, ".*is not executable")
+
+	c.Assert(InitEncryptionKeySources(keyDir, ""), IsNil)
+	c.Assert(CheckEncryptionKeySource(), IsNil)
+	key, err := getEncryptionKey("db", "vol1")
+	c.Assert(err, IsNil)
+	c.Assert(string(key), Equals, "file key\n")
+	_, err = getEncryptionKey("nokey", "vol1")
+	c.Assert(err, NotNil)
+	_, err = getEncryptionKey("empty", "vol1")
+	c.Assert(err, ErrorMatches, "Empty encryption key.*")
+	_, err = getEncryptionKey("../kms-hook", "vol1")
+	c.Assert(err, ErrorMatches, "Invalid encryption key ID.*")
+
+	c.Assert(InitEncryptionKeySources("", hook), IsNil)
+	key, err = getEncryptionKey("db", "vol1")
+	c.Assert(err, IsNil)
+	c.Assert(string(key), Equals, "kms key db of vol1")
+
+	// Hook is called for keys without file in the directory
+	c.Assert(InitEncryptionKeySources(keyDir, hook), IsNil)
+	key, err = getEncryptionKey("db", "vol1")
+	c.Assert(err, IsNil)
+	c.Assert(string(key), Equals, "file key\n")
+	key, err = getEncryptionKey("nokey", "vol1")
+	c.Assert(err, IsNil)
+	c.Assert(string(key), Equals, "kms key nokey of vol1")
+}
@@ -0,0 +1,834 @@
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	ETCD_REQUEST_TIMEOUT = 10 * time.Second
+
+	// Methods of etcdserverpb used by EtcdClient
+	ETCD_METHOD_RANGE           = "/etcdserverpb.KV/Range"
+	ETCD_METHOD_PUT             = "/etcdserverpb.KV/Put"
+	ETCD_METHOD_DELETE_RANGE    = "/etcdserverpb.KV/DeleteRange"
+	ETCD_METHOD_TXN             = "/etcdserverpb.KV/Txn"
+	ETCD_METHOD_LEASE_GRANT     = "/etcdserverpb.Lease/LeaseGrant"
+	ETCD_METHOD_LEASE_KEEPALIVE = "/etcdserverpb.Lease/LeaseKeepAlive"
+	ETCD_METHOD_AUTHENTICATE    = "/etcdserverpb.Auth/Authenticate"
+
+	// Metadata of requests carrying the auth token
+	ETCD_TOKEN_METADATA = "token"
+
+	// Targets and results of etcdserverpb.Compare
+	etcdCompareEqual  = 0
+	etcdCompareCreate = 1
+	etcdCompareValue  = 3
+)
+
+/*
+EtcdClient talks to etcd v3 by its gRPC API, trying endpoints in turn until
+one answers. Requests are authenticated by a token of username and password,
+if specified, which is renewed once it expires. Messages of etcdserverpb are
+encoded by protowire, for the few fields used here, rather than vendoring
+etcd and its generated code.
+*/
+type EtcdClient struct {
+	endpoints []string
+	conns     []*grpc.ClientConn
+	username  string
+	password  string
+
+	mutex   sync.Mutex
+	current int
+	token   string
+}
+
+// EtcdKeyValue is a key and its value in etcd
+type EtcdKeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// EtcdError is an error returned by etcd, rather than failing to reach it
+type EtcdError struct {
+	Code    codes.Code
+	Message string
+}
+
+func (e *EtcdError) Error() string {
+	return fmt.Sprintf("etcd returned %v: %v", e.Code, e.Message)
+}
+
+// etcdCodec passes messages encoded by protowire through as they are
+type etcdCodec struct{}
+
+func (etcdCodec) Marshal(v interface{}) ([]byte, error) {
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("BUG: Invalid etcd message type %T", v)
+	}
+	return data, nil
+}
+
+func (etcdCodec) Unmarshal(data []byte, v interface{}) error {
+	p, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("BUG: Invalid etcd message type %T", v)
+	}
+	*p = append([]byte(nil), data...)
+	return nil
+}
+
+func (etcdCodec) Name() string {
+	return "proto"
+}
+
+// NewEtcdClient returns client of etcd at endpoints, e.g.
+// https://10.0.0.1:2379. tlsConfig is used for https endpoints.
+func NewEtcdClient(endpoints []string, tlsConfig *tls.Config, username, password string) (*EtcdClient, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("No etcd endpoint specified")
+	}
+	if username == "" && password != "" {
+		return nil, fmt.Errorf("etcd password is specified without username")
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	client := &EtcdClient{
+		username: username,
+		password: password,
+	}
+	for _, endpoint := range endpoints {
+		var creds credentials.TransportCredentials
+		switch {
+		case strings.HasPrefix(endpoint, "http://"):
+			creds = insecure.NewCredentials()
+		case strings.HasPrefix(endpoint, "https://"):
+			creds = credentials.NewTLS(tlsConfig)
+		default:
+			return nil, fmt.Errorf("Invalid etcd endpoint %v, should be http://<host>:<port> or https://<host>:<port>", endpoint)
+		}
+		endpoint = strings.TrimSuffix(endpoint, "/")
+		target := endpoint[strings.Index(endpoint, "://")+3:]
+		// Connections are made on the first request
+		conn, err := grpc.NewClient(target,
+			grpc.WithTransportCredentials(creds),
+			grpc.WithDefaultCallOptions(grpc.ForceCodec(etcdCodec{})))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid etcd endpoint %v: %v", endpoint, err)
+		}
+		client.endpoints = append(client.endpoints, endpoint)
+		client.conns = append(client.conns, conn)
+	}
+	return client, nil
+}
+
+// invoke calls method of etcd by conn, returning EtcdError if etcd answers
+// with an error
+func (c *EtcdClient) invoke(conn *grpc.ClientConn, method, token string, req []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ETCD_REQUEST_TIMEOUT)
+	defer cancel()
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, ETCD_TOKEN_METADATA, token)
+	}
+
+	var resp []byte
+	var err error
+	if method == ETCD_METHOD_LEASE_KEEPALIVE {
+		// Keepalive is a stream of requests and responses, of which one
+		// of each is enough
+		var stream grpc.ClientStream
+		stream, err = conn.NewStream(ctx, &grpc.StreamDesc{
+			ServerStreams: true,
+			ClientStreams: true,
+		}, method)
+		if err == nil {
+			if err = stream.SendMsg(req); err == nil {
+				if err = stream.CloseSend(); err == nil {
+					err = stream.RecvMsg(&resp)
+				}
+			}
+		}
+	} else {
+		err = conn.Invoke(ctx, method, req, &resp)
+	}
+	if err == nil {
+		return resp, nil
+	}
+	if s, ok := status.FromError(err); ok && s.Code() != codes.Unavailable && s.Code() != codes.DeadlineExceeded {
+		return nil, &EtcdError{
+			Code:    s.Code(),
+			Message: s.Message(),
+		}
+	}
+	return nil, err
+}
+
+func (c *EtcdClient) authenticate(conn *grpc.ClientConn) (string, error) {
+	var req []byte
+	req = appendEtcdBytes(req, 1, []byte(c.username))
+	req = appendEtcdBytes(req, 2, []byte(c.password))
+	resp, err := c.invoke(conn, ETCD_METHOD_AUTHENTICATE, "", req)
+	if e, ok := err.(*EtcdError); ok {
+		return "", &EtcdError{
+			Code:    e.Code,
+			Message: fmt.Sprintf("failed to authenticate as %v: %v", c.username, e.Message),
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	token := ""
+	if err := parseEtcdMessage(resp, func(num protowire.Number, v []byte, n uint64) error {
+		if num == 2 {
+			token = string(v)
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+/*
+Call calls method of etcd, e.g. ETCD_METHOD_PUT, with request encoded,
+returning the response encoded. Endpoints are tried in turn until one
+answers, starting from the one answering last time. Token expired is renewed
+once.
+*/
+func (c *EtcdClient) Call(method string, req []byte) ([]byte, error) {
+	c.mutex.Lock()
+	current := c.current
+	token := c.token
+	c.mutex.Unlock()
+
+	var resp []byte
+	var err error
+	for i := 0; i < len(c.conns); i++ {
+		index := (current + i) % len(c.conns)
+		conn := c.conns[index]
+		if c.username != "" && token == "" {
+			if token, err = c.authenticate(conn); err != nil {
+				if isEtcdError(err) {
+					return nil, err
+				}
+				continue
+			}
+		}
+		resp, err = c.invoke(conn, method, token, req)
+		if e, ok := err.(*EtcdError); ok && e.Code == codes.Unauthenticated && c.username != "" {
+			if token, err = c.authenticate(conn); err == nil {
+				resp, err = c.invoke(conn, method, token, req)
+			}
+		}
+		if err != nil && !isEtcdError(err) {
+			// Endpoint unreachable, try the next one with a new token
+			token = ""
+			continue
+		}
+		c.mutex.Lock()
+		c.current = index
+		c.token = token
+		c.mutex.Unlock()
+		return resp, err
+	}
+	return nil, fmt.Errorf("Cannot reach etcd at %v: %v", strings.Join(c.endpoints, ","), err)
+}
+
+func isEtcdError(err error) bool {
+	_, ok := err.(*EtcdError)
+	return ok
+}
+
+// appendEtcdBytes appends field num of bytes to message b, unless it's empty
+func appendEtcdBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// appendEtcdVarint appends field num of integer or bool to message b, unless
+// it's 0
+func appendEtcdVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+/*
+parseEtcdMessage calls fn with each field of message b, by its number and
+either the bytes of length delimited fields, e.g. strings and messages, or
+the integer of varint ones. Fields of other types are skipped, since etcd
+uses none of them for the fields used here.
+*/
+func parseEtcdMessage(b []byte, fn func(num protowire.Number, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return fmt.Errorf("Invalid etcd message: %v", protowire.ParseError(l))
+		}
+		b = b[l:]
+		var v []byte
+		var n uint64
+		switch typ {
+		case protowire.BytesType:
+			v, l = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			n, l = protowire.ConsumeVarint(b)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if l < 0 {
+			return fmt.Errorf("Invalid etcd message: %v", protowire.ParseError(l))
+		}
+		b = b[l:]
+		if typ != protowire.BytesType && typ != protowire.VarintType {
+			continue
+		}
+		if err := fn(num, v, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixEnd returns the end of range of keys starting with prefix
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// All keys
+	return []byte{0}
+}
+
+type etcdRangeRequest struct {
+	Key       []byte
+	RangeEnd  []byte
+	Limit     int64
+	KeysOnly  bool
+	CountOnly bool
+}
+
+func (r *etcdRangeRequest) marshal() []byte {
+	var b []byte
+	b = appendEtcdBytes(b, 1, r.Key)
+	b = appendEtcdBytes(b, 2, r.RangeEnd)
+	b = appendEtcdVarint(b, 3, uint64(r.Limit))
+	b = appendEtcdVarint(b, 8, protowire.EncodeBool(r.KeysOnly))
+	b = appendEtcdVarint(b, 9, protowire.EncodeBool(r.CountOnly))
+	return b
+}
+
+type etcdRangeResponse struct {
+	Kvs   []EtcdKeyValue
+	Count int64
+}
+
+func parseEtcdRangeResponse(b []byte) (*etcdRangeResponse, error) {
+	resp := &etcdRangeResponse{}
+	if err := parseEtcdMessage(b, func(num protowire.Number, v []byte, n uint64) error {
+		switch num {
+		case 2:
+			kv := EtcdKeyValue{}
+			if err := parseEtcdMessage(v, func(num protowire.Number, v []byte, n uint64) error {
+				switch num {
+				case 1:
+					kv.Key = v
+				case 5:
+					kv.Value = v
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			resp.Kvs = append(resp.Kvs, kv)
+		case 4:
+			resp.Count = int64(n)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *EtcdClient) callRange(req *etcdRangeRequest) (*etcdRangeResponse, error) {
+	resp, err := c.Call(ETCD_METHOD_RANGE, req.marshal())
+	if err != nil {
+		return nil, err
+	}
+	return parseEtcdRangeResponse(resp)
+}
+
+// Get returns value of key, or false if it doesn't exist
+func (c *EtcdClient) Get(key string) ([]byte, bool, error) {
+	resp, err := c.callRange(&etcdRangeRequest{
+		Key: []byte(key),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, false, nil
+	}
+	return resp.Kvs[0].Value, true, nil
+}
+
+// Exists returns whether key exists, without getting its value
+func (c *EtcdClient) Exists(key string) (bool, error) {
+	resp, err := c.callRange(&etcdRangeRequest{
+		Key:       []byte(key),
+		CountOnly: true,
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.Count != 0, nil
+}
+
+// ListKeys returns keys starting with prefix, up to limit of them unless
+// limit is 0
+func (c *EtcdClient) ListKeys(prefix string, limit int64) ([]string, error) {
+	resp, err := c.callRange(&etcdRangeRequest{
+		Key:      []byte(prefix),
+		RangeEnd: prefixEnd(prefix),
+		Limit:    limit,
+		KeysOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys, nil
+}
+
+type etcdPutRequest struct {
+	Key   []byte
+	Value []byte
+	Lease int64
+}
+
+func (r *etcdPutRequest) marshal() []byte {
+	var b []byte
+	b = appendEtcdBytes(b, 1, r.Key)
+	b = appendEtcdBytes(b, 2, r.Value)
+	b = appendEtcdVarint(b, 3, uint64(r.Lease))
+	return b
+}
+
+type etcdDeleteRangeRequest struct {
+	Key []byte
+}
+
+func (r *etcdDeleteRangeRequest) marshal() []byte {
+	return appendEtcdBytes(nil, 1, r.Key)
+}
+
+func (c *EtcdClient) Put(key string, value []byte) error {
+	_, err := c.Call(ETCD_METHOD_PUT, (&etcdPutRequest{
+		Key:   []byte(key),
+		Value: value,
+	}).marshal())
+	return err
+}
+
+func (c *EtcdClient) Delete(key string) error {
+	_, err := c.Call(ETCD_METHOD_DELETE_RANGE, (&etcdDeleteRangeRequest{
+		Key: []byte(key),
+	}).marshal())
+	return err
+}
+
+// etcdCompare compares key by its revision of creation, or its value
+type etcdCompare struct {
+	Target         int
+	Key            []byte
+	CreateRevision int64
+	Value          []byte
+}
+
+func (cmp *etcdCompare) marshal() []byte {
+	var b []byte
+	b = appendEtcdVarint(b, 1, etcdCompareEqual)
+	b = appendEtcdVarint(b, 2, uint64(cmp.Target))
+	b = appendEtcdBytes(b, 3, cmp.Key)
+	switch cmp.Target {
+	case etcdCompareCreate:
+		// Field of oneof is encoded even if it's 0
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(cmp.CreateRevision))
+	case etcdCompareValue:
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendBytes(b, cmp.Value)
+	}
+	return b
+}
+
+// etcdRequestOp is one of the operations of a transaction
+type etcdRequestOp struct {
+	RequestRange       *etcdRangeRequest
+	RequestPut         *etcdPutRequest
+	RequestDeleteRange *etcdDeleteRangeRequest
+}
+
+func (op *etcdRequestOp) marshal() []byte {
+	switch {
+	case op.RequestRange != nil:
+		return appendEtcdBytes(nil, 1, op.RequestRange.marshal())
+	case op.RequestPut != nil:
+		return appendEtcdBytes(nil, 2, op.RequestPut.marshal())
+	case op.RequestDeleteRange != nil:
+		return appendEtcdBytes(nil, 3, op.RequestDeleteRange.marshal())
+	}
+	return nil
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare
+	Success []etcdRequestOp
+	Failure []etcdRequestOp
+}
+
+func (r *etcdTxnRequest) marshal() []byte {
+	var b []byte
+	for i := range r.Compare {
+		b = appendEtcdBytes(b, 1, r.Compare[i].marshal())
+	}
+	for i := range r.Success {
+		b = appendEtcdBytes(b, 2, r.Success[i].marshal())
+	}
+	for i := range r.Failure {
+		b = appendEtcdBytes(b, 3, r.Failure[i].marshal())
+	}
+	return b
+}
+
+// etcdTxnResponse keeps responses of range operations of the transaction,
+// nil for the other operations
+type etcdTxnResponse struct {
+	Succeeded bool
+	Responses []*etcdRangeResponse
+}
+
+func (c *EtcdClient) callTxn(req *etcdTxnRequest) (*etcdTxnResponse, error) {
+	b, err := c.Call(ETCD_METHOD_TXN, req.marshal())
+	if err != nil {
+		return nil, err
+	}
+	resp := &etcdTxnResponse{}
+	if err := parseEtcdMessage(b, func(num protowire.Number, v []byte, n uint64) error {
+		switch num {
+		case 2:
+			resp.Succeeded = protowire.DecodeBool(n)
+		case 3:
+			var rr *etcdRangeResponse
+			if err := parseEtcdMessage(v, func(num protowire.Number, v []byte, n uint64) error {
+				if num != 1 {
+					return nil
+				}
+				var err error
+				rr, err = parseEtcdRangeResponse(v)
+				return err
+			}); err != nil {
+				return err
+			}
+			resp.Responses = append(resp.Responses, rr)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+/*
+CreateOrGet puts value at key attached to lease, unless key exists already,
+in which case its current value is returned instead, all in one transaction.
+Lease 0 means key never expires.
+*/
+func (c *EtcdClient) CreateOrGet(key string, value []byte, lease int64) (bool, []byte, error) {
+	resp, err := c.callTxn(&etcdTxnRequest{
+		// Keys not existing have no revision of creation
+		Compare: []etcdCompare{{
+			Target:         etcdCompareCreate,
+			Key:            []byte(key),
+			CreateRevision: 0,
+		}},
+		Success: []etcdRequestOp{{
+			RequestPut: &etcdPutRequest{Key: []byte(key), Value: value, Lease: lease},
+		}},
+		Failure: []etcdRequestOp{{
+			RequestRange: &etcdRangeRequest{Key: []byte(key)},
+		}},
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	if resp.Succeeded {
+		return true, nil, nil
+	}
+	// Key may be gone by now
+	if len(resp.Responses) == 0 || resp.Responses[0] == nil || len(resp.Responses[0].Kvs) == 0 {
+		return false, nil, nil
+	}
+	return false, resp.Responses[0].Kvs[0].Value, nil
+}
+
+// PutIfValue puts value at key attached to lease, if key has value expected,
+// returning whether it did
+func (c *EtcdClient) PutIfValue(key string, expected, value []byte, lease int64) (bool, error) {
+	resp, err := c.callTxn(&etcdTxnRequest{
+		Compare: []etcdCompare{{
+			Target: etcdCompareValue,
+			Key:    []byte(key),
+			Value:  expected,
+		}},
+		Success: []etcdRequestOp{{
+			RequestPut: &etcdPutRequest{Key: []byte(key), Value: value, Lease: lease},
+		}},
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// DeleteIfValue deletes key if it has value expected, returning whether it
+// did
+func (c *EtcdClient) DeleteIfValue(key string, expected []byte) (bool, error) {
+	resp, err := c.callTxn(&etcdTxnRequest{
+		Compare: []etcdCompare{{
+			Target: etcdCompareValue,
+			Key:    []byte(key),
+			Value:  expected,
+		}},
+		Success: []etcdRequestOp{{
+			RequestDeleteRange: &etcdDeleteRangeRequest{Key: []byte(key)},
+		}},
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// etcdLeaseResponse is response of both grant and keepalive of a lease
+type etcdLeaseResponse struct {
+	ID    int64
+	TTL   int64
+	Error string
+}
+
+func (c *EtcdClient) callLease(method string, req []byte) (*etcdLeaseResponse, error) {
+	b, err := c.Call(method, req)
+	if err != nil {
+		return nil, err
+	}
+	resp := &etcdLeaseResponse{}
+	if err := parseEtcdMessage(b, func(num protowire.Number, v []byte, n uint64) error {
+		switch num {
+		case 2:
+			resp.ID = int64(n)
+		case 3:
+			resp.TTL = int64(n)
+		case 4:
+			resp.Error = string(v)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// GrantLease returns ID of a new lease expiring after ttl unless kept alive
+func (c *EtcdClient) GrantLease(ttl time.Duration) (int64, error) {
+	resp, err := c.callLease(ETCD_METHOD_LEASE_GRANT, appendEtcdVarint(nil, 1, uint64(ttl/time.Second)))
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != "" {
+		return 0, fmt.Errorf("etcd granted no lease: %v", resp.Error)
+	}
+	if resp.ID == 0 {
+		return 0, fmt.Errorf("etcd granted no lease")
+	}
+	return resp.ID, nil
+}
+
+// KeepAliveLease renews lease for its TTL, returning false if it has expired
+// already, along with keys attached to it
+func (c *EtcdClient) KeepAliveLease(id int64) (bool, error) {
+	resp, err := c.callLease(ETCD_METHOD_LEASE_KEEPALIVE, appendEtcdVarint(nil, 1, uint64(id)))
+	if err != nil {
+		return false, err
+	}
+	return resp.TTL > 0, nil
+}
+
+/*
+EtcdMetadataStore keeps configs in etcd, keyed by prefix followed by their
+paths, so they outlive the host, and a daemon started on another host with
+the same prefix and root finds them all. Configs in directories of local are
+kept in local files, e.g. caches too large for etcd. Configs found in local
+files but not in etcd, e.g. the ones saved before switching to etcd, are
+copied into etcd once they're read, and removed along with their keys.
+*/
+type EtcdMetadataStore struct {
+	client *EtcdClient
+	prefix string
+	local  []string
+	file   *FileMetadataStore
+}
+
+func NewEtcdMetadataStore(client *EtcdClient, prefix string, local []string) *EtcdMetadataStore {
+	return &EtcdMetadataStore{
+		client: client,
+		prefix: strings.TrimSuffix(prefix, "/"),
+		local:  local,
+		file:   &FileMetadataStore{},
+	}
+}
+
+// key returns key of config of fileName, or false if it's kept locally
+func (e *EtcdMetadataStore) key(fileName string) (string, bool) {
+	fileName, err := filepath.Abs(fileName)
+	if err != nil {
+		return "", false
+	}
+	for _, dir := range e.local {
+		if fileName == dir || strings.HasPrefix(fileName, strings.TrimSuffix(dir, "/")+"/") {
+			return "", false
+		}
+	}
+	return e.prefix + fileName, true
+}
+
+func (e *EtcdMetadataStore) Load(fileName string, v interface{}) error {
+	key, ok := e.key(fileName)
+	if !ok {
+		return e.file.Load(fileName, v)
+	}
+	data, exists, err := e.client.Get(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if data, err = ioutil.ReadFile(fileName); err != nil {
+			return err
+		}
+		log.Debugf("Copying config %v into etcd", fileName)
+		if err := e.client.Put(key, data); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (e *EtcdMetadataStore) Save(fileName string, v interface{}) error {
+	key, ok := e.key(fileName)
+	if !ok {
+		return e.file.Save(fileName, v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return e.client.Put(key, data)
+}
+
+func (e *EtcdMetadataStore) Exists(fileName string) (bool, error) {
+	key, ok := e.key(fileName)
+	if !ok {
+		return e.file.Exists(fileName)
+	}
+	exists, err := e.client.Exists(key)
+	if err != nil || exists {
+		return exists, err
+	}
+	return e.file.Exists(fileName)
+}
+
+func (e *EtcdMetadataStore) Remove(fileName string) error {
+	key, ok := e.key(fileName)
+	if ok {
+		if err := e.client.Delete(key); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(fileName); err == nil {
+		return e.file.Remove(fileName)
+	}
+	return nil
+}
+
+func (e *EtcdMetadataStore) List(dir, prefix, suffix string) ([]string, error) {
+	ids, err := e.file.List(dir, prefix, suffix)
+	if err != nil {
+		return nil, err
+	}
+	dirKey, ok := e.key(dir)
+	if !ok {
+		return ids, nil
+	}
+	dirKey = strings.TrimSuffix(dirKey, "/") + "/"
+	keys, err := e.client.ListKeys(dirKey+prefix, 0)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	for _, id := range ids {
+		found[id] = true
+	}
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, dirKey)
+		// Configs in subdirectories are not in dir
+		if strings.Contains(name, "/") || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
+		if id == "" || found[id] {
+			continue
+		}
+		found[id] = true
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Check verifies configs in etcd can be read, with the credentials
+func (e *EtcdMetadataStore) Check() error {
+	_, err := e.client.ListKeys(e.prefix+"/", 1)
+	return err
+}
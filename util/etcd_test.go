@@ -0,0 +1,471 @@
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	. "gopkg.in/check.v1"
+)
+
+// fakeEtcd serves the part of etcd v3 gRPC API used by EtcdClient
+type fakeEtcd struct {
+	mutex    sync.Mutex
+	kvs      map[string][]byte
+	password string
+	token    string
+	calls    int
+
+	// Leases alive, and keys attached to leases
+	leases    map[int64]bool
+	keyLeases map[string]int64
+	lastLease int64
+}
+
+// serveFakeEtcd serves f at a local port, returning the endpoint and the
+// server to stop
+func serveFakeEtcd(c *C, f *fakeEtcd) (string, *grpc.Server) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	server := grpc.NewServer(grpc.ForceServerCodec(etcdCodec{}), grpc.UnknownServiceHandler(f.handle))
+	go server.Serve(l)
+	return "http://" + l.Addr().String(), server
+}
+
+// expire revokes lease with keys attached to it
+func (f *fakeEtcd) expire(lease int64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	delete(f.leases, lease)
+	for k, l := range f.keyLeases {
+		if l == lease {
+			delete(f.kvs, k)
+			delete(f.keyLeases, k)
+		}
+	}
+}
+
+func (f *fakeEtcd) put(op *etcdPutRequest) {
+	f.kvs[string(op.Key)] = op.Value
+	if op.Lease != 0 {
+		f.keyLeases[string(op.Key)] = op.Lease
+	} else {
+		delete(f.keyLeases, string(op.Key))
+	}
+}
+
+func parseFakeRangeRequest(b []byte) *etcdRangeRequest {
+	req := &etcdRangeRequest{}
+	parseEtcdMessage(b, func(num protowire.Number, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			req.Key = v
+		case 2:
+			req.RangeEnd = v
+		case 3:
+			req.Limit = int64(n)
+		case 8:
+			req.KeysOnly = protowire.DecodeBool(n)
+		case 9:
+			req.CountOnly = protowire.DecodeBool(n)
+		}
+		return nil
+	})
+	return req
+}
+
+func parseFakePutRequest(b []byte) *etcdPutRequest {
+	req := &etcdPutRequest{}
+	parseEtcdMessage(b, func(num protowire.Number, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			req.Key = v
+		case 2:
+			req.Value = v
+		case 3:
+			req.Lease = int64(n)
+		}
+		return nil
+	})
+	return req
+}
+
+func parseFakeTxnRequest(b []byte) *etcdTxnRequest {
+	req := &etcdTxnRequest{}
+	parseOp := func(b []byte) etcdRequestOp {
+		op := etcdRequestOp{}
+		parseEtcdMessage(b, func(num protowire.Number, v []byte, n uint64) error {
+			switch num {
+			case 1:
+				op.RequestRange = parseFakeRangeRequest(v)
+			case 2:
+				op.RequestPut = parseFakePutRequest(v)
+			case 3:
+				op.RequestDeleteRange = &etcdDeleteRangeRequest{Key: parseFakeRangeRequest(v).Key}
+			}
+			return nil
+		})
+		return op
+	}
+	parseEtcdMessage(b, func(num protowire.Number, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			cmp := etcdCompare{CreateRevision: -1}
+			parseEtcdMessage(v, func(num protowire.Number, v []byte, n uint64) error {
+				switch num {
+				case 2:
+					cmp.Target = int(n)
+				case 3:
+					cmp.Key = v
+				case 5:
+					cmp.CreateRevision = int64(n)
+				case 7:
+					cmp.Value = v
+				}
+				return nil
+			})
+			req.Compare = append(req.Compare, cmp)
+		case 2:
+			req.Success = append(req.Success, parseOp(v))
+		case 3:
+			req.Failure = append(req.Failure, parseOp(v))
+		}
+		return nil
+	})
+	return req
+}
+
+func marshalFakeRangeResponse(keys []string, values map[string][]byte, keysOnly bool) []byte {
+	var b []byte
+	for _, k := range keys {
+		var kv []byte
+		kv = appendEtcdBytes(kv, 1, []byte(k))
+		if !keysOnly {
+			kv = appendEtcdBytes(kv, 5, values[k])
+		}
+		b = appendEtcdBytes(b, 2, kv)
+	}
+	return appendEtcdVarint(b, 4, uint64(len(keys)))
+}
+
+func (f *fakeEtcd) handle(srv interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	var req []byte
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	resp, err := f.call(stream.Context(), method, req)
+	if err != nil {
+		return err
+	}
+	return stream.SendMsg(resp)
+}
+
+func (f *fakeEtcd) call(ctx context.Context, method string, req []byte) ([]byte, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.calls++
+	if method == ETCD_METHOD_AUTHENTICATE {
+		password := ""
+		parseEtcdMessage(req, func(num protowire.Number, v []byte, n uint64) error {
+			if num == 2 {
+				password = string(v)
+			}
+			return nil
+		})
+		if password != f.password {
+			return nil, status.Error(codes.InvalidArgument, "etcdserver: authentication failed, invalid user ID or password")
+		}
+		return appendEtcdBytes(nil, 2, []byte(f.token)), nil
+	}
+	if f.password != "" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if tokens := md.Get(ETCD_TOKEN_METADATA); len(tokens) != 1 || tokens[0] != f.token {
+			return nil, status.Error(codes.Unauthenticated, "etcdserver: invalid auth token")
+		}
+	}
+	switch method {
+	case ETCD_METHOD_RANGE:
+		r := parseFakeRangeRequest(req)
+		keys := []string{}
+		for k := range f.kvs {
+			if k == string(r.Key) || (r.RangeEnd != nil && k >= string(r.Key) && k < string(r.RangeEnd)) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		if r.Limit != 0 && int64(len(keys)) > r.Limit {
+			keys = keys[:r.Limit]
+		}
+		if r.CountOnly {
+			return appendEtcdVarint(nil, 4, uint64(len(keys))), nil
+		}
+		return marshalFakeRangeResponse(keys, f.kvs, r.KeysOnly), nil
+	case ETCD_METHOD_PUT:
+		r := parseFakePutRequest(req)
+		f.kvs[string(r.Key)] = r.Value
+		return nil, nil
+	case ETCD_METHOD_DELETE_RANGE:
+		delete(f.kvs, string(parseFakeRangeRequest(req).Key))
+		return nil, nil
+	case ETCD_METHOD_TXN:
+		r := parseFakeTxnRequest(req)
+		cmp := r.Compare[0]
+		value, exists := f.kvs[string(cmp.Key)]
+		succeeded := false
+		switch cmp.Target {
+		case etcdCompareCreate:
+			succeeded = !exists && cmp.CreateRevision == 0
+		case etcdCompareValue:
+			succeeded = exists && string(value) == string(cmp.Value)
+		}
+		ops := r.Failure
+		if succeeded {
+			ops = r.Success
+		}
+		resp := appendEtcdVarint(nil, 2, protowire.EncodeBool(succeeded))
+		for _, op := range ops {
+			var rr []byte
+			switch {
+			case op.RequestPut != nil:
+				if op.RequestPut.Lease != 0 && !f.leases[op.RequestPut.Lease] {
+					return nil, status.Error(codes.NotFound, "etcdserver: requested lease not found")
+				}
+				f.put(op.RequestPut)
+				rr = appendEtcdBytes(nil, 2, []byte{})
+			case op.RequestDeleteRange != nil:
+				delete(f.kvs, string(op.RequestDeleteRange.Key))
+				delete(f.keyLeases, string(op.RequestDeleteRange.Key))
+				rr = appendEtcdBytes(nil, 3, []byte{})
+			case op.RequestRange != nil:
+				keys := []string{}
+				if _, ok := f.kvs[string(op.RequestRange.Key)]; ok {
+					keys = append(keys, string(op.RequestRange.Key))
+				}
+				rr = appendEtcdBytes(nil, 1, marshalFakeRangeResponse(keys, f.kvs, false))
+			}
+			resp = append(resp, appendEtcdBytes(nil, 3, rr)...)
+		}
+		return resp, nil
+	case ETCD_METHOD_LEASE_GRANT:
+		ttl := uint64(0)
+		parseEtcdMessage(req, func(num protowire.Number, v []byte, n uint64) error {
+			if num == 1 {
+				ttl = n
+			}
+			return nil
+		})
+		f.lastLease++
+		f.leases[f.lastLease] = true
+		return appendEtcdVarint(appendEtcdVarint(nil, 2, uint64(f.lastLease)), 3, ttl), nil
+	case ETCD_METHOD_LEASE_KEEPALIVE:
+		id := uint64(0)
+		parseEtcdMessage(req, func(num protowire.Number, v []byte, n uint64) error {
+			if num == 1 {
+				id = n
+			}
+			return nil
+		})
+		resp := appendEtcdVarint(nil, 2, id)
+		if f.leases[int64(id)] {
+			resp = appendEtcdVarint(resp, 3, 60)
+		}
+		return resp, nil
+	}
+	return nil, status.Errorf(codes.Unimplemented, "unknown method %v", method)
+}
+
+func (s *TestSuite) TestEtcdMetadataStore(c *C) {
+	fake := &fakeEtcd{
+		kvs:       make(map[string][]byte),
+		password:  "secret",
+		token:     "token1",
+		leases:    make(map[int64]bool),
+		keyLeases: make(map[string]int64),
+	}
+	endpoint, server := serveFakeEtcd(c, fake)
+	defer server.Stop()
+
+	dir, err := ioutil.TempDir("", "convoy-etcd")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	localDir := filepath.Join(dir, "local")
+	c.Assert(os.MkdirAll(localDir, 0700), IsNil)
+
+	_, err = NewEtcdClient([]string{"localhost:2379"}, nil, "", "")
+	c.Assert(err, ErrorMatches, "Invalid etcd endpoint.*")
+
+	// The first endpoint is unreachable
+	client, err := NewEtcdClient([]string{"http://127.0.0.1:1", endpoint}, nil, "convoy", "wrong")
+	c.Assert(err, IsNil)
+	store := NewEtcdMetadataStore(client, "/convoy/host1/", []string{localDir})
+	c.Assert(store.Check(), ErrorMatches, ".*authentication failed.*")
+
+	client, err = NewEtcdClient([]string{"http://127.0.0.1:1", endpoint}, nil, "convoy", "secret")
+	c.Assert(err, IsNil)
+	store = NewEtcdMetadataStore(client, "/convoy/host1/", []string{localDir})
+	c.Assert(store.Check(), IsNil)
+
+	vol1 := &Volume{ID: "123", DevID: 1, Size: 1000}
+	vol2 := &Volume{ID: "456", DevID: 2, Size: 2000}
+	file1 := filepath.Join(dir, "volume-123.json")
+	file2 := filepath.Join(dir, "volume-456.json")
+
+	exists, err := store.Exists(file1)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	c.Assert(store.Save(file1, vol1), IsNil)
+	c.Assert(fake.kvs["/convoy/host1"+file1], NotNil)
+	_, err = os.Stat(file1)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	exists, err = store.Exists(file1)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	v := &Volume{}
+	c.Assert(store.Load(file1, v), IsNil)
+	c.Assert(v, DeepEquals, vol1)
+
+	// Configs saved in local files before are copied into etcd once read
+	c.Assert((&FileMetadataStore{}).Save(file2, vol2), IsNil)
+	ids, err := store.List(dir, "volume-", ".json")
+	c.Assert(err, IsNil)
+	c.Assert(ids, DeepEquals, []string{"123", "456"})
+	exists, err = store.Exists(file2)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	c.Assert(store.Load(file2, v), IsNil)
+	c.Assert(v, DeepEquals, vol2)
+	c.Assert(fake.kvs["/convoy/host1"+file2], NotNil)
+
+	// Configs in subdirectories are not listed
+	c.Assert(store.Save(filepath.Join(dir, "sub", "volume-789.json"), vol1), IsNil)
+	ids, err = store.List(dir, "volume-", ".json")
+	c.Assert(err, IsNil)
+	c.Assert(ids, DeepEquals, []string{"123", "456"})
+
+	// Configs in local directories stay local
+	localFile := filepath.Join(localDir, "index.json")
+	c.Assert(store.Save(localFile, vol1), IsNil)
+	_, err = os.Stat(localFile)
+	c.Assert(err, IsNil)
+	for k := range fake.kvs {
+		c.Assert(k, Not(Equals), "/convoy/host1"+localFile)
+	}
+
+	c.Assert(store.Remove(file2), IsNil)
+	_, err = os.Stat(file2)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	exists, err = store.Exists(file2)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+	ids, err = store.List(dir, "volume-", ".json")
+	c.Assert(err, IsNil)
+	c.Assert(ids, DeepEquals, []string{"123"})
+
+	// Token expired is renewed
+	fake.token = "token2"
+	c.Assert(store.Load(file1, v), IsNil)
+	c.Assert(v, DeepEquals, vol1)
+
+	// Objects are kept in the store set
+	SetMetadataStore(store)
+	defer SetMetadataStore(&FileMetadataStore{})
+	c.Assert(ObjectSave(vol2), IsNil)
+	exists, err = ObjectExists(vol2)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	c.Assert(fake.kvs["/convoy/host1"+filepath.Join(testRoot, "volume-456.cfg")], NotNil)
+	c.Assert(ObjectDelete(vol2), IsNil)
+	exists, err = ObjectExists(vol2)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	server.Stop()
+	c.Assert(store.Check(), ErrorMatches, "Cannot reach etcd.*")
+}
+
+func (s *TestSuite) TestEtcdLease(c *C) {
+	fake := &fakeEtcd{
+		kvs:       make(map[string][]byte),
+		leases:    make(map[int64]bool),
+		keyLeases: make(map[string]int64),
+	}
+	endpoint, server := serveFakeEtcd(c, fake)
+	defer server.Stop()
+
+	client, err := NewEtcdClient([]string{endpoint}, nil, "", "")
+	c.Assert(err, IsNil)
+
+	lease1, err := client.GrantLease(60 * time.Second)
+	c.Assert(err, IsNil)
+	lease2, err := client.GrantLease(60 * time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(lease1, Not(Equals), lease2)
+
+	created, value, err := client.CreateOrGet("/leases/vol1", []byte("host1"), lease1)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, true)
+	c.Assert(value, IsNil)
+	c.Assert(fake.keyLeases["/leases/vol1"], Equals, lease1)
+
+	created, value, err = client.CreateOrGet("/leases/vol1", []byte("host2"), lease2)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, false)
+	c.Assert(string(value), Equals, "host1")
+
+	// Held by others
+	ok, err := client.PutIfValue("/leases/vol1", []byte("host2"), []byte("host2"), lease2)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+	ok, err = client.DeleteIfValue("/leases/vol1", []byte("host2"))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+
+	// Moved to another lease of the holder
+	ok, err = client.PutIfValue("/leases/vol1", []byte("host1"), []byte("host1"), lease2)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Assert(fake.keyLeases["/leases/vol1"], Equals, lease2)
+
+	alive, err := client.KeepAliveLease(lease1)
+	c.Assert(err, IsNil)
+	c.Assert(alive, Equals, true)
+
+	// Keys are gone along with their lease
+	fake.expire(lease2)
+	alive, err = client.KeepAliveLease(lease2)
+	c.Assert(err, IsNil)
+	c.Assert(alive, Equals, false)
+	_, exists, err := client.Get("/leases/vol1")
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	created, _, err = client.CreateOrGet("/leases/vol1", []byte("host2"), lease2)
+	c.Assert(err, ErrorMatches, ".*lease not found.*")
+	c.Assert(created, Equals, false)
+
+	created, _, err = client.CreateOrGet("/leases/vol1", []byte("host2"), lease1)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, true)
+	ok, err = client.DeleteIfValue("/leases/vol1", []byte("host2"))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	_, exists, err = client.Get("/leases/vol1")
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+}
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// NAME_FILTER matches names by glob, e.g. "name=db-*"
+	NAME_FILTER   = "name"
+	DRIVER_FILTER = "driver"
+	STATE_FILTER  = "state"
+)
+
+// ListFilter filters a list by a field as "<field>=<value>", or by label as
+// LabelFilter if Field is LABEL_FILTER
+type ListFilter struct {
+	Field string
+	Value string
+	Label *LabelFilter
+}
+
+/*
+ParseListFilter parses filter in the form of "<field>=<value>", where field
+is one of fields, or label filter in the form of "label=<key>" or
+"label=<key>=<value>".
+*/
+func ParseListFilter(filter string, fields []string) (*ListFilter, error) {
+	parts := strings.SplitN(filter, "=", 2)
+	if parts[0] == LABEL_FILTER {
+		label, err := ParseLabelFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		return &ListFilter{
+			Field: LABEL_FILTER,
+			Label: label,
+		}, nil
+	}
+	valid := false
+	for _, field := range fields {
+		if parts[0] == field {
+			valid = true
+			break
+		}
+	}
+	if len(parts) != 2 || !valid {
+		return nil, fmt.Errorf("Invalid filter %q, should be <field>=<value> of fields %v, or %v=<key> or %v=<key>=<value>",
+			filter, strings.Join(fields, ", "), LABEL_FILTER, LABEL_FILTER)
+	}
+	if parts[1] == "" {
+		return nil, fmt.Errorf("Invalid filter %q with empty value", filter)
+	}
+	if parts[0] == NAME_FILTER {
+		if _, err := path.Match(parts[1], ""); err != nil {
+			return nil, fmt.Errorf("Invalid name pattern %q: %v", parts[1], err)
+		}
+	}
+	return &ListFilter{
+		Field: parts[0],
+		Value: parts[1],
+	}, nil
+}
+
+// Match returns true if value of the field matches, by glob for name
+func (f *ListFilter) Match(value string) bool {
+	if f.Field == NAME_FILTER {
+		matched, _ := path.Match(f.Value, value)
+		return matched
+	}
+	return f.Value == value
+}
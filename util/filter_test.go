@@ -0,0 +1,39 @@
+package util
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestListFilter(c *C) {
+	fields := []string{NAME_FILTER, DRIVER_FILTER, STATE_FILTER}
+
+	_, err := ParseListFilter("size=1G", fields)
+	c.Assert(err, ErrorMatches, "Invalid filter.*")
+	_, err = ParseListFilter("driver", fields)
+	c.Assert(err, ErrorMatches, "Invalid filter.*")
+	_, err = ParseListFilter("driver=", fields)
+	c.Assert(err, ErrorMatches, "Invalid filter .* with empty value")
+	_, err = ParseListFilter("name=db-[", fields)
+	c.Assert(err, ErrorMatches, "Invalid name pattern.*")
+	_, err = ParseListFilter("label=", fields)
+	c.Assert(err, ErrorMatches, "Invalid label with empty key")
+	_, err = ParseListFilter("state=mounted", []string{NAME_FILTER})
+	c.Assert(err, ErrorMatches, "Invalid filter.*")
+
+	f, err := ParseListFilter("name=db-*", fields)
+	c.Assert(err, IsNil)
+	c.Assert(f.Field, Equals, NAME_FILTER)
+	c.Assert(f.Match("db-1"), Equals, true)
+	c.Assert(f.Match("db-"), Equals, true)
+	c.Assert(f.Match("web-1"), Equals, false)
+
+	f, err = ParseListFilter("driver=ebs", fields)
+	c.Assert(err, IsNil)
+	c.Assert(f.Match("ebs"), Equals, true)
+	c.Assert(f.Match("eb*"), Equals, false)
+
+	f, err = ParseListFilter("label=team=payments", fields)
+	c.Assert(err, IsNil)
+	c.Assert(f.Field, Equals, LABEL_FILTER)
+	c.Assert(f.Label.Match(map[string]string{"team": "payments"}), Equals, true)
+}
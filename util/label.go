@@ -0,0 +1,104 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// LABEL_FILTER filters by label as "label=<key>" or "label=<key>=<value>",
+	// the same as Docker
+	LABEL_FILTER = "label"
+)
+
+// CheckLabel validates key and value of a label. Keys are compatible with
+// Docker's, so they can be used as Docker volume labels as well.
+func CheckLabel(key, value string) error {
+	if key == "" {
+		return fmt.Errorf("Invalid label with empty key")
+	}
+	if strings.ContainsAny(key, "= \t\n,") {
+		return fmt.Errorf("Invalid label key %q, cannot contain '=', ',' or whitespace", key)
+	}
+	if strings.ContainsAny(value, "\n") {
+		return fmt.Errorf("Invalid value of label %v, cannot contain newline", key)
+	}
+	return nil
+}
+
+// ParseLabels parses labels in the form of "<key>=<value>". Value can be
+// empty, as "<key>" or "<key>=".
+func ParseLabels(specs []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		value := ""
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+		if err := CheckLabel(parts[0], value); err != nil {
+			return nil, err
+		}
+		labels[parts[0]] = value
+	}
+	return labels, nil
+}
+
+// EncodeLabels encodes labels as a string of "<key>=<value>" lines sorted by
+// keys, which can be passed along as an option and parsed by DecodeLabels
+func EncodeLabels(labels map[string]string) string {
+	specs := []string{}
+	for k, v := range labels {
+		specs = append(specs, k+"="+v)
+	}
+	sort.Strings(specs)
+	return strings.Join(specs, "\n")
+}
+
+// DecodeLabels returns nil if encoded is empty
+func DecodeLabels(encoded string) (map[string]string, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	return ParseLabels(strings.Split(encoded, "\n"))
+}
+
+// LabelFilter matches labels having Key, and Value too if HasValue is true
+type LabelFilter struct {
+	Key      string
+	Value    string
+	HasValue bool
+}
+
+/*
+ParseLabelFilter parses filter in the form of "label=<key>" or
+"label=<key>=<value>", the same as "docker volume ls --filter".
+*/
+func ParseLabelFilter(filter string) (*LabelFilter, error) {
+	parts := strings.SplitN(filter, "=", 2)
+	if len(parts) != 2 || parts[0] != LABEL_FILTER {
+		return nil, fmt.Errorf("Invalid filter %q, should be %v=<key> or %v=<key>=<value>",
+			filter, LABEL_FILTER, LABEL_FILTER)
+	}
+	kv := strings.SplitN(parts[1], "=", 2)
+	f := &LabelFilter{
+		Key: kv[0],
+	}
+	if len(kv) == 2 {
+		f.Value = kv[1]
+		f.HasValue = true
+	}
+	if err := CheckLabel(f.Key, f.Value); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *LabelFilter) Match(labels map[string]string) bool {
+	value, exists := labels[f.Key]
+	if !exists {
+		return false
+	}
+	return !f.HasValue || value == f.Value
+}
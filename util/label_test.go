@@ -0,0 +1,59 @@
+package util
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestLabels(c *C) {
+	labels, err := ParseLabels([]string{"team=payments", "tier=", "pii", "url=http://a/?b=c"})
+	c.Assert(err, IsNil)
+	c.Assert(labels, DeepEquals, map[string]string{
+		"team": "payments",
+		"tier": "",
+		"pii":  "",
+		"url":  "http://a/?b=c",
+	})
+
+	_, err = ParseLabels([]string{"=payments"})
+	c.Assert(err, ErrorMatches, "Invalid label with empty key")
+	_, err = ParseLabels([]string{"my team=payments"})
+	c.Assert(err, ErrorMatches, "Invalid label key.*")
+
+	_, err = ParseLabelFilter("team=payments")
+	c.Assert(err, ErrorMatches, "Invalid filter.*")
+	_, err = ParseLabelFilter("label=")
+	c.Assert(err, ErrorMatches, "Invalid label with empty key")
+
+	f, err := ParseLabelFilter("label=team=payments")
+	c.Assert(err, IsNil)
+	c.Assert(f.Match(labels), Equals, true)
+	c.Assert(f.Match(map[string]string{"team": "search"}), Equals, false)
+	c.Assert(f.Match(nil), Equals, false)
+
+	f, err = ParseLabelFilter("label=tier")
+	c.Assert(err, IsNil)
+	c.Assert(f.Match(labels), Equals, true)
+	c.Assert(f.Match(map[string]string{"team": "payments"}), Equals, false)
+
+	f, err = ParseLabelFilter("label=tier=")
+	c.Assert(err, IsNil)
+	c.Assert(f.Match(labels), Equals, true)
+	c.Assert(f.Match(map[string]string{"tier": "gold"}), Equals, false)
+}
+
+func (s *TestSuite) TestEncodeLabels(c *C) {
+	labels := map[string]string{
+		"release": "v1.2",
+		"tier":    "",
+		"url":     "http://a/?b=c,d",
+	}
+	encoded := EncodeLabels(labels)
+	c.Assert(encoded, Equals, "release=v1.2\ntier=\nurl=http://a/?b=c,d")
+	decoded, err := DecodeLabels(encoded)
+	c.Assert(err, IsNil)
+	c.Assert(decoded, DeepEquals, labels)
+
+	decoded, err = DecodeLabels(EncodeLabels(nil))
+	c.Assert(err, IsNil)
+	c.Assert(decoded, IsNil)
+}
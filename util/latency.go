@@ -0,0 +1,170 @@
+package util
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	LATENCY_CREATE   = "create"
+	LATENCY_ATTACH   = "attach"
+	LATENCY_MOUNT    = "mount"
+	LATENCY_SNAPSHOT = "snapshot"
+	LATENCY_BACKUP   = "backup"
+
+	// Oldest samples of an operation would be dropped once exceeded, even if
+	// they're still in the window
+	MAX_LATENCY_SAMPLES = 10000
+)
+
+var (
+	LatencyOperations = []string{
+		LATENCY_CREATE,
+		LATENCY_ATTACH,
+		LATENCY_MOUNT,
+		LATENCY_SNAPSHOT,
+		LATENCY_BACKUP,
+	}
+
+	// LatencyPercentiles are the percentiles tracked for every operation
+	LatencyPercentiles = []int{50, 95, 99}
+
+	latencyObserver func(op, volumeName string, latency time.Duration)
+)
+
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// LatencyStats summarizes latencies of an operation in the window
+type LatencyStats struct {
+	Count       int
+	Sum         time.Duration
+	Percentiles map[int]time.Duration
+}
+
+/*
+LatencyTracker keeps latencies of operations completed within the sliding
+window, to calculate their percentiles.
+*/
+type LatencyTracker struct {
+	mutex   sync.Mutex
+	window  time.Duration
+	samples map[string][]latencySample
+}
+
+func NewLatencyTracker(window time.Duration) *LatencyTracker {
+	return &LatencyTracker{
+		window:  window,
+		samples: make(map[string][]latencySample),
+	}
+}
+
+func (t *LatencyTracker) Window() time.Duration {
+	return t.window
+}
+
+// Observe adds latency of an operation completed now, and returns stats of
+// the operation including it
+func (t *LatencyTracker) Observe(op string, latency time.Duration) LatencyStats {
+	return t.add(op, time.Now(), latency)
+}
+
+func (t *LatencyTracker) add(op string, at time.Time, latency time.Duration) LatencyStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	samples := append(t.samples[op], latencySample{
+		at:      at,
+		latency: latency,
+	})
+	if len(samples) > MAX_LATENCY_SAMPLES {
+		samples = samples[len(samples)-MAX_LATENCY_SAMPLES:]
+	}
+	t.samples[op] = samples
+	return t.stats(op, at)
+}
+
+// Stats returns stats of all the tracked operations, including the ones
+// without samples in the window
+func (t *LatencyTracker) Stats() map[string]LatencyStats {
+	return t.statsAt(time.Now())
+}
+
+func (t *LatencyTracker) statsAt(now time.Time) map[string]LatencyStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	result := make(map[string]LatencyStats)
+	for _, op := range LatencyOperations {
+		result[op] = t.stats(op, now)
+	}
+	for op := range t.samples {
+		result[op] = t.stats(op, now)
+	}
+	return result
+}
+
+// stats expires samples out of the window, caller should hold the mutex
+func (t *LatencyTracker) stats(op string, now time.Time) LatencyStats {
+	samples := t.samples[op]
+	start := now.Add(-t.window)
+	i := 0
+	for i < len(samples) && samples[i].at.Before(start) {
+		i++
+	}
+	samples = samples[i:]
+	t.samples[op] = samples
+
+	stats := LatencyStats{
+		Count:       len(samples),
+		Percentiles: make(map[int]time.Duration),
+	}
+	if len(samples) == 0 {
+		return stats
+	}
+	latencies := make([]time.Duration, len(samples))
+	for i, sample := range samples {
+		latencies[i] = sample.latency
+		stats.Sum += sample.latency
+	}
+	sort.Sort(durations(latencies))
+	for _, p := range LatencyPercentiles {
+		stats.Percentiles[p] = percentile(latencies, p)
+	}
+	return stats
+}
+
+// percentile uses nearest-rank method on sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+
+// SetLatencyObserver sets where ObserveLatency() reports to. It should be
+// called before any driver is initialized.
+func SetLatencyObserver(observer func(op, volumeName string, latency time.Duration)) {
+	latencyObserver = observer
+}
+
+// ObserveLatency reports latency of successful operation on the volume,
+// started at start. Drivers use it for steps daemon cannot time by itself,
+// e.g. attach.
+func ObserveLatency(op, volumeName string, start time.Time) {
+	if latencyObserver == nil {
+		return
+	}
+	latencyObserver(op, volumeName, time.Since(start))
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestLatencyTracker(c *C) {
+	tracker := NewLatencyTracker(time.Hour)
+	start := time.Now().Add(-2 * time.Hour)
+
+	// Only expired by the time of the last sample
+	tracker.add(LATENCY_MOUNT, start, time.Minute)
+	for i := 1; i <= 100; i++ {
+		at := start.Add(time.Hour + time.Duration(i)*time.Second)
+		stats := tracker.add(LATENCY_MOUNT, at, time.Duration(i)*time.Millisecond)
+		c.Assert(stats.Count, Equals, i)
+	}
+
+	all := tracker.statsAt(start.Add(time.Hour + 100*time.Second))
+	c.Assert(all, HasLen, len(LatencyOperations))
+	c.Assert(all[LATENCY_BACKUP].Count, Equals, 0)
+	stats := all[LATENCY_MOUNT]
+	c.Assert(stats.Count, Equals, 100)
+	c.Assert(stats.Sum, Equals, 5050*time.Millisecond)
+	c.Assert(stats.Percentiles[50], Equals, 50*time.Millisecond)
+	c.Assert(stats.Percentiles[95], Equals, 95*time.Millisecond)
+	c.Assert(stats.Percentiles[99], Equals, 99*time.Millisecond)
+
+	// Window slides past the first 89 samples
+	stats = tracker.statsAt(start.Add(2*time.Hour + 90*time.Second))[LATENCY_MOUNT]
+	c.Assert(stats.Count, Equals, 11)
+	c.Assert(stats.Percentiles[50], Equals, 95*time.Millisecond)
+	c.Assert(stats.Percentiles[99], Equals, 100*time.Millisecond)
+
+	stats = tracker.statsAt(start.Add(3 * time.Hour))[LATENCY_MOUNT]
+	c.Assert(stats.Count, Equals, 0)
+
+	observed := ""
+	SetLatencyObserver(func(op, volumeName string, latency time.Duration) {
+		observed = op + " " + volumeName
+	})
+	defer SetLatencyObserver(nil)
+	ObserveLatency(LATENCY_ATTACH, "vol1", time.Now())
+	c.Assert(observed, Equals, "attach vol1")
+}
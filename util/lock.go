@@ -0,0 +1,79 @@
+package util
+
+import (
+	"sync"
+)
+
+/*
+LockMap holds a reader/writer lock for every key, e.g. name of a volume, so
+operations on the same key are serialized while the ones on different keys
+run concurrently. Locks are created on demand and removed once no one holds
+or waits for them.
+*/
+type LockMap struct {
+	mutex sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.RWMutex
+	// Holders and waiters of the lock
+	refs int
+}
+
+func NewLockMap() *LockMap {
+	return &LockMap{
+		locks: make(map[string]*keyLock),
+	}
+}
+
+func (m *LockMap) get(key string) *keyLock {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, exists := m.locks[key]
+	if !exists {
+		l = &keyLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	return l
+}
+
+func (m *LockMap) put(key string) *keyLock {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, exists := m.locks[key]
+	if !exists {
+		panic("BUG: Unlock of key " + key + " not locked")
+	}
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, key)
+	}
+	return l
+}
+
+// size returns the number of keys locked or waited for
+func (m *LockMap) size() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.locks)
+}
+
+func (m *LockMap) Lock(key string) {
+	m.get(key).Lock()
+}
+
+func (m *LockMap) Unlock(key string) {
+	m.put(key).Unlock()
+}
+
+func (m *LockMap) RLock(key string) {
+	m.get(key).RLock()
+}
+
+func (m *LockMap) RUnlock(key string) {
+	m.put(key).RUnlock()
+}
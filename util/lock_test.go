@@ -0,0 +1,105 @@
+package util
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestLockMapSameKey(c *C) {
+	m := NewLockMap()
+
+	// Unsynchronized read-modify-write, only correct if serialized
+	counter := 0
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Lock("vol1")
+			defer m.Unlock("vol1")
+			v := counter
+			time.Sleep(time.Millisecond)
+			counter = v + 1
+		}()
+	}
+	wg.Wait()
+	c.Assert(counter, Equals, 50)
+	c.Assert(m.size(), Equals, 0)
+}
+
+func (s *TestSuite) TestLockMapDifferentKeys(c *C) {
+	m := NewLockMap()
+
+	m.Lock("vol1")
+	done := make(chan bool)
+	go func() {
+		m.Lock("vol2")
+		m.Unlock("vol2")
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("Lock of another key blocked")
+	}
+
+	// Waiters keep the lock of the key around
+	acquired := make(chan bool)
+	go func() {
+		m.Lock("vol1")
+		m.Unlock("vol1")
+		acquired <- true
+	}()
+	select {
+	case <-acquired:
+		c.Fatal("Lock of the same key not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	m.Unlock("vol1")
+	<-acquired
+	c.Assert(m.size(), Equals, 0)
+}
+
+func (s *TestSuite) TestLockMapReaders(c *C) {
+	m := NewLockMap()
+
+	m.RLock("dest")
+	m.RLock("dest")
+	locked := make(chan bool)
+	go func() {
+		m.Lock("dest")
+		m.Unlock("dest")
+		locked <- true
+	}()
+	select {
+	case <-locked:
+		c.Fatal("Lock not blocked by readers")
+	case <-time.After(50 * time.Millisecond):
+	}
+	m.RUnlock("dest")
+	select {
+	case <-locked:
+		c.Fatal("Lock not blocked by the remaining reader")
+	case <-time.After(50 * time.Millisecond):
+	}
+	m.RUnlock("dest")
+	<-locked
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "vol" + strconv.Itoa(i%3)
+			m.RLock(key)
+			m.RUnlock(key)
+		}(i)
+	}
+	wg.Wait()
+	c.Assert(m.size(), Equals, 0)
+
+	c.Assert(func() { m.Unlock("vol1") }, PanicMatches, "BUG: Unlock of key vol1 not locked")
+}
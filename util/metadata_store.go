@@ -0,0 +1,138 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	METADATA_STORE_FILE = "file"
+	METADATA_STORE_ETCD = "etcd"
+)
+
+/*
+MetadataStore keeps configs of daemon, drivers and volumes, identified by
+the paths of their files, whatever the store actually keeps them in. Configs
+are encoded in JSON.
+*/
+type MetadataStore interface {
+	Load(fileName string, v interface{}) error
+	Save(fileName string, v interface{}) error
+	Exists(fileName string) (bool, error)
+	Remove(fileName string) error
+	// List returns IDs of configs in dir named <prefix><ID><suffix>
+	List(dir, prefix, suffix string) ([]string, error)
+	// Check verifies the store can be read and written
+	Check() error
+}
+
+var (
+	metadataStoreMutex sync.RWMutex
+	metadataStore      MetadataStore = &FileMetadataStore{}
+)
+
+// SetMetadataStore replaces the store configs are kept in, which is
+// FileMetadataStore by default
+func SetMetadataStore(store MetadataStore) {
+	metadataStoreMutex.Lock()
+	defer metadataStoreMutex.Unlock()
+
+	metadataStore = store
+}
+
+func getMetadataStore() MetadataStore {
+	metadataStoreMutex.RLock()
+	defer metadataStoreMutex.RUnlock()
+
+	return metadataStore
+}
+
+// CheckMetadataStore verifies the store configs are kept in is available
+func CheckMetadataStore() error {
+	return getMetadataStore().Check()
+}
+
+// FileMetadataStore keeps configs in local JSON files at their paths
+type FileMetadataStore struct{}
+
+func (f *FileMetadataStore) Load(fileName string, v interface{}) error {
+	if _, err := os.Stat(fileName); err != nil {
+		return err
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if err = json.NewDecoder(file).Decode(v); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FileMetadataStore) Save(fileName string, v interface{}) error {
+	tmpFileName := fileName + ".tmp"
+
+	file, err := os.Create(tmpFileName)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(file).Encode(v); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+
+	if _, err = os.Stat(fileName); err == nil {
+		if err = os.Remove(fileName); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpFileName, fileName); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (f *FileMetadataStore) Exists(fileName string) (bool, error) {
+	_, err := os.Stat(fileName)
+	return err == nil, nil
+}
+
+func (f *FileMetadataStore) Remove(fileName string) error {
+	if _, err := Execute("rm", []string{"-f", fileName}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FileMetadataStore) List(dir, prefix, suffix string) ([]string, error) {
+	pattern := path.Join(dir, fmt.Sprintf("%s*%s", prefix, suffix))
+	out, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return []string{}, nil
+	}
+	for i := range out {
+		out[i] = path.Base(out[i])
+	}
+	return ExtractNames(out, prefix, suffix)
+}
+
+// Check of local files is left to callers, who know the directories they
+// write to
+func (f *FileMetadataStore) Check() error {
+	return nil
+}
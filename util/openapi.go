@@ -0,0 +1,286 @@
+package util
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OPENAPI_VERSION = "3.0.3"
+
+	OPENAPI_JSON = "application/json"
+	OPENAPI_TEXT = "text/plain"
+)
+
+// OpenAPIParameter is a query parameter of an operation, of type string,
+// integer or boolean
+type OpenAPIParameter struct {
+	Name        string
+	Type        string
+	Description string
+	// Parameters given more than once, e.g. filter=a&filter=b
+	Repeated bool
+}
+
+// OpenAPIResponse is a response of an operation. Body is a value of the
+// type responded, or nil if there's no body
+type OpenAPIResponse struct {
+	Status      int
+	Description string
+	ContentType string
+	Body        interface{}
+}
+
+/*
+OpenAPIOperation describes an operation for NewOpenAPISpec(). Request is a
+value of the type of request body, or nil if there's none. Requests and
+responses without ContentType are JSON.
+*/
+type OpenAPIOperation struct {
+	ID                 string
+	Method             string
+	Path               string
+	Summary            string
+	Description        string
+	Parameters         []OpenAPIParameter
+	Request            interface{}
+	RequestContentType string
+	Responses          []OpenAPIResponse
+}
+
+/*
+NewOpenAPISpec generates OpenAPI document of operations, with schemas of
+their requests and responses generated from their Go types the same way
+encoding/json encodes them. Named struct types become components referred by
+their names. Every operation answers errors by default as plain text, as
+http.Error() does. The document is a tree of maps and slices, ready to be
+encoded as JSON.
+*/
+func NewOpenAPISpec(title, version, server string, ops []OpenAPIOperation) map[string]interface{} {
+	g := &openAPIGenerator{
+		schemas: make(map[string]interface{}),
+	}
+	paths := make(map[string]interface{})
+	for _, op := range ops {
+		item, ok := paths[op.Path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = g.operation(op)
+	}
+	spec := map[string]interface{}{
+		"openapi": OPENAPI_VERSION,
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+		},
+	}
+	if server != "" {
+		spec["servers"] = []interface{}{
+			map[string]interface{}{"url": server},
+		}
+	}
+	return spec
+}
+
+type openAPIGenerator struct {
+	schemas map[string]interface{}
+}
+
+func (g *openAPIGenerator) operation(op OpenAPIOperation) map[string]interface{} {
+	o := map[string]interface{}{
+		"operationId": op.ID,
+	}
+	if op.Summary != "" {
+		o["summary"] = op.Summary
+	}
+	if op.Description != "" {
+		o["description"] = op.Description
+	}
+	if len(op.Parameters) != 0 {
+		params := []interface{}{}
+		for _, p := range op.Parameters {
+			schema := map[string]interface{}{"type": p.Type}
+			if p.Repeated {
+				schema = map[string]interface{}{
+					"type":  "array",
+					"items": schema,
+				}
+			}
+			param := map[string]interface{}{
+				"name":   p.Name,
+				"in":     "query",
+				"schema": schema,
+			}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+			params = append(params, param)
+		}
+		o["parameters"] = params
+	}
+	if op.Request != nil {
+		contentType := op.RequestContentType
+		if contentType == "" {
+			contentType = OPENAPI_JSON
+		}
+		o["requestBody"] = map[string]interface{}{
+			"content": g.content(contentType, op.Request),
+		}
+	}
+	responses := map[string]interface{}{
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content":     g.content(OPENAPI_TEXT, ""),
+		},
+	}
+	// Responses of the same status are of different content types
+	for _, resp := range op.Responses {
+		status := strconv.Itoa(resp.Status)
+		r, exists := responses[status].(map[string]interface{})
+		if !exists {
+			r = map[string]interface{}{
+				"description": resp.Description,
+			}
+			if resp.Description == "" {
+				r["description"] = http.StatusText(resp.Status)
+			}
+			responses[status] = r
+		}
+		if resp.Body == nil {
+			continue
+		}
+		contentType := resp.ContentType
+		if contentType == "" {
+			contentType = OPENAPI_JSON
+		}
+		content, exists := r["content"].(map[string]interface{})
+		if !exists {
+			content = make(map[string]interface{})
+			r["content"] = content
+		}
+		for k, v := range g.content(contentType, resp.Body) {
+			content[k] = v
+		}
+	}
+	o["responses"] = responses
+	return o
+}
+
+func (g *openAPIGenerator) content(contentType string, v interface{}) map[string]interface{} {
+	schema := g.schema(reflect.TypeOf(v))
+	if contentType != OPENAPI_JSON {
+		// Bodies of other types are sent as they are
+		schema = map[string]interface{}{"type": "string"}
+		if _, ok := v.([]byte); ok {
+			schema["format"] = "binary"
+		}
+	}
+	return map[string]interface{}{
+		contentType: map[string]interface{}{
+			"schema": schema,
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *openAPIGenerator) schema(t reflect.Type) map[string]interface{} {
+	t = indirectType(t)
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{
+			"type":  "array",
+			"items": g.schema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": g.schema(t.Elem()),
+		}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, exists := g.schemas[t.Name()]; !exists {
+			// Placeholder for types referring to themselves
+			g.schemas[t.Name()] = nil
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	// Anything, e.g. interface{}
+	return map[string]interface{}{}
+}
+
+func (g *openAPIGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	g.addFields(t, properties)
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// addFields adds fields of struct t encoded by encoding/json to properties,
+// including the ones of embedded structs, which are hidden by fields of t of
+// the same names
+func (g *openAPIGenerator) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if isEmbeddedStruct(f) {
+			g.addFields(indirectType(f.Type), properties)
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.PkgPath != "" || tag == "-" || isEmbeddedStruct(f) {
+			continue
+		}
+		name := strings.SplitN(tag, ",", 2)[0]
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = g.schema(f.Type)
+	}
+}
+
+// isEmbeddedStruct tells whether fields of f are encoded as fields of the
+// struct embedding it
+func isEmbeddedStruct(f reflect.StructField) bool {
+	name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+	return f.Anonymous && name == "" && indirectType(f.Type).Kind() == reflect.Struct
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,132 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type openAPITestBase struct {
+	ID      string
+	Created time.Time
+}
+
+type openAPITestItem struct {
+	openAPITestBase
+	Name     string
+	Size     int64             `json:",omitempty"`
+	Labels   map[string]string `json:"labels"`
+	Children []*openAPITestItem
+	Data     []byte
+	Skipped  string `json:"-"`
+	internal string
+}
+
+func (s *TestSuite) TestOpenAPISpec(c *C) {
+	spec := NewOpenAPISpec("Test", "1.2", "/v1", []OpenAPIOperation{
+		{
+			ID:      "ItemCreate",
+			Method:  "POST",
+			Path:    "/items/create",
+			Summary: "Create item",
+			Request: openAPITestItem{},
+			Responses: []OpenAPIResponse{
+				{Status: http.StatusOK, Body: openAPITestItem{}},
+				{Status: http.StatusOK, ContentType: OPENAPI_TEXT, Body: ""},
+			},
+		},
+		{
+			ID:     "ItemList",
+			Method: "GET",
+			Path:   "/items",
+			Parameters: []OpenAPIParameter{
+				{Name: "limit", Type: "integer"},
+				{Name: "filter", Type: "string", Repeated: true},
+			},
+			Responses: []OpenAPIResponse{
+				{Status: http.StatusOK, Body: map[string]openAPITestItem{}},
+				{Status: http.StatusAccepted, Description: "Pending", ContentType: OPENAPI_TEXT, Body: ""},
+			},
+		},
+		{
+			ID:     "ItemDelete",
+			Method: "DELETE",
+			Path:   "/items",
+			Responses: []OpenAPIResponse{
+				{Status: http.StatusOK},
+			},
+		},
+	})
+
+	// Decoded as clients would see it
+	data, err := json.Marshal(spec)
+	c.Assert(err, IsNil)
+	doc := map[string]interface{}{}
+	c.Assert(json.Unmarshal(data, &doc), IsNil)
+	c.Assert(doc["openapi"], Equals, OPENAPI_VERSION)
+	c.Assert(doc["info"].(map[string]interface{})["version"], Equals, "1.2")
+	c.Assert(doc["servers"].([]interface{})[0].(map[string]interface{})["url"], Equals, "/v1")
+
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	c.Assert(schemas, HasLen, 1)
+	props := schemas["openAPITestItem"].(map[string]interface{})["properties"].(map[string]interface{})
+	c.Assert(props, HasLen, 7)
+	c.Assert(props["ID"], DeepEquals, map[string]interface{}{"type": "string"})
+	c.Assert(props["Created"], DeepEquals, map[string]interface{}{"type": "string", "format": "date-time"})
+	c.Assert(props["Size"], DeepEquals, map[string]interface{}{"type": "integer", "format": "int64"})
+	c.Assert(props["labels"], DeepEquals, map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": map[string]interface{}{"type": "string"},
+	})
+	c.Assert(props["Children"], DeepEquals, map[string]interface{}{
+		"type":  "array",
+		"items": map[string]interface{}{"$ref": "#/components/schemas/openAPITestItem"},
+	})
+	c.Assert(props["Data"], DeepEquals, map[string]interface{}{"type": "string", "format": "byte"})
+
+	paths := doc["paths"].(map[string]interface{})
+	c.Assert(paths, HasLen, 2)
+	create := paths["/items/create"].(map[string]interface{})["post"].(map[string]interface{})
+	c.Assert(create["operationId"], Equals, "ItemCreate")
+	c.Assert(create["summary"], Equals, "Create item")
+	body := create["requestBody"].(map[string]interface{})["content"].(map[string]interface{})[OPENAPI_JSON]
+	c.Assert(body, DeepEquals, map[string]interface{}{
+		"schema": map[string]interface{}{"$ref": "#/components/schemas/openAPITestItem"},
+	})
+	responses := create["responses"].(map[string]interface{})
+	c.Assert(responses, HasLen, 2)
+	c.Assert(responses["200"].(map[string]interface{})["description"], Equals, "OK")
+	c.Assert(responses["200"].(map[string]interface{})["content"], HasLen, 2)
+	c.Assert(responses["default"].(map[string]interface{})["content"], DeepEquals, map[string]interface{}{
+		OPENAPI_TEXT: map[string]interface{}{
+			"schema": map[string]interface{}{"type": "string"},
+		},
+	})
+
+	items := paths["/items"].(map[string]interface{})
+	c.Assert(items, HasLen, 2)
+	list := items["get"].(map[string]interface{})
+	c.Assert(list["requestBody"], IsNil)
+	params := list["parameters"].([]interface{})
+	c.Assert(params, HasLen, 2)
+	c.Assert(params[1], DeepEquals, map[string]interface{}{
+		"name": "filter",
+		"in":   "query",
+		"schema": map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"type": "string"},
+		},
+	})
+	responses = list["responses"].(map[string]interface{})
+	c.Assert(responses["200"].(map[string]interface{})["content"].(map[string]interface{})[OPENAPI_JSON], DeepEquals, map[string]interface{}{
+		"schema": map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"$ref": "#/components/schemas/openAPITestItem"},
+		},
+	})
+	c.Assert(responses["202"].(map[string]interface{})["description"], Equals, "Pending")
+	deleted := items["delete"].(map[string]interface{})["responses"].(map[string]interface{})["200"]
+	c.Assert(deleted, DeepEquals, map[string]interface{}{"description": "OK"})
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type probeRun struct {
+	done    chan struct{}
+	err     error
+	started string
+}
+
+/*
+Probe runs check with timeout, at most one at a time. A check not returning
+within timeout cannot be cancelled, so it's left running, and probes
+afterwards wait for it rather than starting another one, until it returns.
+This way a hung backend holds one goroutine instead of one per probe.
+*/
+type Probe struct {
+	check   func() error
+	mutex   sync.Mutex
+	current *probeRun
+}
+
+func NewProbe(check func() error) *Probe {
+	return &Probe{
+		check: check,
+	}
+}
+
+// Run returns result of the check, or error if it didn't return within
+// timeout
+func (p *Probe) Run(timeout time.Duration) error {
+	p.mutex.Lock()
+	run := p.current
+	if run == nil {
+		run = &probeRun{
+			done:    make(chan struct{}),
+			started: Now(),
+		}
+		p.current = run
+		go func() {
+			run.err = p.check()
+			p.mutex.Lock()
+			p.current = nil
+			p.mutex.Unlock()
+			close(run.done)
+		}()
+	}
+	p.mutex.Unlock()
+
+	select {
+	case <-run.done:
+		return run.err
+	case <-time.After(timeout):
+		return fmt.Errorf("probe timed out after %v, still running since %v", timeout, run.started)
+	}
+}
@@ -0,0 +1,36 @@
+package util
+
+import (
+	"fmt"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestProbe(c *C) {
+	calls := 0
+	release := make(chan error)
+	p := NewProbe(func() error {
+		calls++
+		return <-release
+	})
+
+	// A hung check is waited for by probes afterwards, not started again
+	c.Assert(p.Run(10*time.Millisecond), ErrorMatches, "probe timed out after 10ms, still running since .*")
+	c.Assert(p.Run(10*time.Millisecond), ErrorMatches, "probe timed out after 10ms, still running since .*")
+	result := make(chan error)
+	go func() {
+		result <- p.Run(time.Second)
+	}()
+	// Result is delivered to probes waiting for the check when it returns
+	time.Sleep(10 * time.Millisecond)
+	release <- fmt.Errorf("backend is down")
+	c.Assert(<-result, ErrorMatches, "backend is down")
+	c.Assert(calls, Equals, 1)
+
+	go func() {
+		release <- nil
+	}()
+	c.Assert(p.Run(time.Second), IsNil)
+	c.Assert(calls, Equals, 2)
+}
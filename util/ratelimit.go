@@ -0,0 +1,203 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Buckets of clients idle for the interval are dropped, since they'd be
+	// full anyway
+	RATE_LIMIT_SWEEP_INTERVAL = 10 * time.Minute
+)
+
+// RateLimit allows Rate requests per second on average, and up to Burst at
+// once. Zero Rate means unlimited
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) String() string {
+	if l.Rate == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%v/s, burst %v", l.Rate, l.Burst)
+}
+
+/*
+ParseRateLimits parses specs in the form of [<client>=]<rate>[:<burst>], e.g.
+10:20, or uid=0=0. Specs without client set the default limit of clients,
+others override it for the clients named. Burst defaults to rate rounded up.
+The default limit is nil if there's none, i.e. unlimited.
+*/
+func ParseRateLimits(specs []string) (*RateLimit, map[string]RateLimit, error) {
+	var defaultLimit *RateLimit
+	limits := make(map[string]RateLimit)
+	for _, spec := range specs {
+		client, value := "", spec
+		if i := strings.LastIndex(spec, "="); i >= 0 {
+			client, value = spec[:i], spec[i+1:]
+			if client == "" {
+				return nil, nil, fmt.Errorf("Invalid rate limit %q, client is empty", spec)
+			}
+		}
+		parts := strings.SplitN(value, ":", 2)
+		rate, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil || rate < 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+			return nil, nil, fmt.Errorf("Invalid rate limit %q, should be [<client>=]<rate>[:<burst>], e.g. 10:20", spec)
+		}
+		limit := RateLimit{
+			Rate:  rate,
+			Burst: int(math.Ceil(rate)),
+		}
+		if len(parts) == 2 {
+			burst, err := strconv.Atoi(parts[1])
+			if err != nil || burst < 1 {
+				return nil, nil, fmt.Errorf("Invalid burst of rate limit %q, must be a positive integer", spec)
+			}
+			limit.Burst = burst
+		}
+		if client == "" {
+			defaultLimit = &limit
+		} else {
+			limits[client] = limit
+		}
+	}
+	return defaultLimit, limits, nil
+}
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+/*
+RateLimiter limits requests of every client by a token bucket, refilled at
+the rate of limit of the client up to its burst.
+*/
+type RateLimiter struct {
+	mutex        sync.Mutex
+	defaultLimit *RateLimit
+	limits       map[string]RateLimit
+	buckets      map[string]*tokenBucket
+	lastSweep    time.Time
+}
+
+func NewRateLimiter(defaultLimit *RateLimit, limits map[string]RateLimit) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetLimits(defaultLimit, limits)
+	return l
+}
+
+// SetLimits replaces limits of clients, who start with full buckets
+func (l *RateLimiter) SetLimits(defaultLimit *RateLimit, limits map[string]RateLimit) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.defaultLimit = defaultLimit
+	l.limits = limits
+	l.buckets = make(map[string]*tokenBucket)
+}
+
+// Limit returns limit of client, false if it's unlimited
+func (l *RateLimiter) Limit(client string) (RateLimit, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.limitOf(client)
+}
+
+func (l *RateLimiter) limitOf(client string) (RateLimit, bool) {
+	limit, exists := l.limits[client]
+	if !exists {
+		if l.defaultLimit == nil {
+			return RateLimit{}, false
+		}
+		limit = *l.defaultLimit
+	}
+	return limit, limit.Rate != 0
+}
+
+// Allow takes a token of client, or tells how long to wait before one is
+// available if there's none
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	return l.allowAt(client, time.Now())
+}
+
+func (l *RateLimiter) allowAt(client string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sweep(now)
+	limit, limited := l.limitOf(client)
+	if !limited {
+		return true, 0
+	}
+	b, exists := l.buckets[client]
+	if !exists {
+		b = &tokenBucket{
+			limit:  limit,
+			tokens: float64(limit.Burst),
+			last:   now,
+		}
+		l.buckets[client] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed.Seconds()*limit.Rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// Wait tells how long client has to wait before a token is available,
+// without taking it. It's 0 if there's one already.
+func (l *RateLimiter) Wait(client string) time.Duration {
+	return l.waitAt(client, time.Now())
+}
+
+func (l *RateLimiter) waitAt(client string, now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	limit, limited := l.limitOf(client)
+	if !limited {
+		return 0
+	}
+	b, exists := l.buckets[client]
+	if !exists {
+		return 0
+	}
+	tokens := b.tokens
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		tokens = math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+	}
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+}
+
+// sweep drops buckets which would have been refilled to full
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < RATE_LIMIT_SWEEP_INTERVAL {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.buckets {
+		full := time.Duration((float64(b.limit.Burst) - b.tokens) / b.limit.Rate * float64(time.Second))
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, client)
+		}
+	}
+}
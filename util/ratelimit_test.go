@@ -0,0 +1,86 @@
+package util
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestParseRateLimits(c *C) {
+	defaultLimit, limits, err := ParseRateLimits([]string{"2.5", "principal=ci=1:5", "uid=0=0", "10.0.0.1=4:8"})
+	c.Assert(err, IsNil)
+	c.Assert(*defaultLimit, Equals, RateLimit{Rate: 2.5, Burst: 3})
+	c.Assert(limits, HasLen, 3)
+	c.Assert(limits["principal=ci"], Equals, RateLimit{Rate: 1, Burst: 5})
+	c.Assert(limits["uid=0"], Equals, RateLimit{})
+	c.Assert(limits["10.0.0.1"], Equals, RateLimit{Rate: 4, Burst: 8})
+
+	defaultLimit, limits, err = ParseRateLimits(nil)
+	c.Assert(err, IsNil)
+	c.Assert(defaultLimit, IsNil)
+	c.Assert(limits, HasLen, 0)
+
+	for _, spec := range []string{"", "x", "-1", "1:0", "1:x", "=1", "ci=", "NaN"} {
+		_, _, err := ParseRateLimits([]string{spec})
+		c.Assert(err, NotNil, Commentf("%q", spec))
+	}
+}
+
+func (s *TestSuite) TestRateLimiter(c *C) {
+	l := NewRateLimiter(&RateLimit{Rate: 2, Burst: 3}, map[string]RateLimit{
+		"uid=0": {},
+		"ci":    {Rate: 0.5, Burst: 1},
+	})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.allowAt("a", now)
+		c.Assert(ok, Equals, true)
+	}
+	ok, wait := l.allowAt("a", now)
+	c.Assert(ok, Equals, false)
+	c.Assert(wait, Equals, 500*time.Millisecond)
+
+	// Clients have their own buckets
+	ok, _ = l.allowAt("b", now)
+	c.Assert(ok, Equals, true)
+
+	// Refilled at rate
+	ok, _ = l.allowAt("a", now.Add(500*time.Millisecond))
+	c.Assert(ok, Equals, true)
+	ok, wait = l.allowAt("a", now.Add(750*time.Millisecond))
+	c.Assert(ok, Equals, false)
+	c.Assert(wait, Equals, 250*time.Millisecond)
+
+	for i := 0; i < 100; i++ {
+		ok, _ = l.allowAt("uid=0", now)
+		c.Assert(ok, Equals, true)
+	}
+
+	ok, _ = l.allowAt("ci", now)
+	c.Assert(ok, Equals, true)
+	ok, wait = l.allowAt("ci", now)
+	c.Assert(ok, Equals, false)
+	c.Assert(wait, Equals, 2*time.Second)
+
+	// Waiting doesn't take tokens
+	c.Assert(l.waitAt("ci", now), Equals, 2*time.Second)
+	c.Assert(l.waitAt("ci", now.Add(time.Second)), Equals, time.Second)
+	c.Assert(l.waitAt("ci", now.Add(2*time.Second)), Equals, time.Duration(0))
+	c.Assert(l.waitAt("new", now), Equals, time.Duration(0))
+
+	// Buckets full by now are dropped
+	l.allowAt("b", now.Add(time.Second))
+	c.Assert(l.buckets, HasLen, 3)
+	l.allowAt("b", now.Add(RATE_LIMIT_SWEEP_INTERVAL+time.Second))
+	c.Assert(l.buckets, HasLen, 1)
+
+	// Unlimited once limits are removed
+	l.SetLimits(nil, map[string]RateLimit{})
+	for i := 0; i < 100; i++ {
+		ok, _ = l.allowAt("ci", now)
+		c.Assert(ok, Equals, true)
+	}
+	_, limited := l.Limit("a")
+	c.Assert(limited, Equals, false)
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// SELINUX_LABEL_SHARED labels the volume to be shared by all containers,
+	// as ":z" of Docker
+	SELINUX_LABEL_SHARED = "z"
+	// SELINUX_LABEL_PRIVATE labels the volume to be private to a container,
+	// as ":Z" of Docker, which needs MCS categories of the container
+	SELINUX_LABEL_PRIVATE = "Z"
+	// SELINUX_LABEL_NONE overrides default label of daemon
+	SELINUX_LABEL_NONE = "none"
+
+	// svirt_sandbox_file_t is an alias of container_file_t in newer
+	// policies, so it works on both
+	SELINUX_SHARED_CONTEXT = "system_u:object_r:svirt_sandbox_file_t:s0"
+)
+
+/*
+SELinuxMountContext translates SELinux label of a mount to the context the
+filesystem would be mounted with. Label can be "z" for shared context, "none"
+or empty for no context, or an explicit context like
+"system_u:object_r:svirt_sandbox_file_t:s0:c1,c2". Private label "Z" cannot
+be translated since containers using the volume are unknown to convoy, the
+container's context should be specified instead.
+*/
+func SELinuxMountContext(label string) (string, error) {
+	switch label {
+	case "", SELINUX_LABEL_NONE:
+		return "", nil
+	case SELINUX_LABEL_SHARED:
+		return SELINUX_SHARED_CONTEXT, nil
+	case SELINUX_LABEL_PRIVATE:
+		return "", fmt.Errorf("SELinux label %v needs MCS categories of the container, specify its context instead, e.g. %v:c1,c2",
+			label, SELINUX_SHARED_CONTEXT)
+	}
+	parts := strings.Split(label, ":")
+	if len(parts) < 4 || strings.ContainsAny(label, "\" \t\n") {
+		return "", fmt.Errorf("Invalid SELinux label %q, should be %v, %v or context as user:role:type:level",
+			label, SELINUX_LABEL_SHARED, SELINUX_LABEL_NONE)
+	}
+	for _, part := range parts[:4] {
+		if part == "" {
+			return "", fmt.Errorf("Invalid SELinux context %q", label)
+		}
+	}
+	return label, nil
+}
+
+// getSELinuxMountOpts returns mount options labeling the whole filesystem as
+// context. Quotes are needed since level of context may contain comma.
+func getSELinuxMountOpts(context string) []string {
+	if context == "" {
+		return []string{}
+	}
+	return []string{"-o", "context=\"" + context + "\""}
+}
@@ -0,0 +1,31 @@
+package util
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestSELinuxMountContext(c *C) {
+	context, err := SELinuxMountContext("")
+	c.Assert(err, IsNil)
+	c.Assert(context, Equals, "")
+	context, err = SELinuxMountContext("none")
+	c.Assert(err, IsNil)
+	c.Assert(context, Equals, "")
+	context, err = SELinuxMountContext("z")
+	c.Assert(err, IsNil)
+	c.Assert(context, Equals, SELINUX_SHARED_CONTEXT)
+	context, err = SELinuxMountContext("system_u:object_r:svirt_sandbox_file_t:s0:c1,c2")
+	c.Assert(err, IsNil)
+	c.Assert(getSELinuxMountOpts(context), DeepEquals,
+		[]string{"-o", "context=\"system_u:object_r:svirt_sandbox_file_t:s0:c1,c2\""})
+
+	_, err = SELinuxMountContext("Z")
+	c.Assert(err, ErrorMatches, "SELinux label Z needs MCS categories.*")
+	_, err = SELinuxMountContext("shared")
+	c.Assert(err, NotNil)
+	_, err = SELinuxMountContext("system_u::svirt_sandbox_file_t:s0")
+	c.Assert(err, NotNil)
+	_, err = SELinuxMountContext("system_u:object_r:svirt_sandbox_file_t:s0\" ro")
+	c.Assert(err, NotNil)
+	c.Assert(getSELinuxMountOpts(""), HasLen, 0)
+}
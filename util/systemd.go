@@ -0,0 +1,113 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	SD_NOTIFY_READY     = "READY=1"
+	SD_NOTIFY_WATCHDOG  = "WATCHDOG=1"
+	SD_NOTIFY_STOPPING  = "STOPPING=1"
+	SD_NOTIFY_RELOADING = "RELOADING=1"
+
+	// First file descriptor passed by systemd socket activation
+	SD_LISTEN_FDS_START = 3
+)
+
+/*
+SdNotify sends state to systemd through $NOTIFY_SOCKET, the same as
+sd_notify(3). It returns false if the process wasn't started by systemd with
+notify access, i.e. $NOTIFY_SOCKET isn't set.
+*/
+func SdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// Abstract socket starts with '@'
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{
+		Name: socket,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+/*
+SdWatchdogInterval returns the interval systemd expects WATCHDOG=1 within,
+by $WATCHDOG_USEC, or zero if watchdog isn't enabled for the process, as
+sd_watchdog_enabled(3).
+*/
+func SdWatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("Invalid WATCHDOG_USEC %v", usec)
+	}
+	return time.Duration(value) * time.Microsecond, nil
+}
+
+/*
+SdListeners returns listeners of the sockets passed by systemd socket
+activation through $LISTEN_FDS, as sd_listen_fds(3), by their addresses, e.g.
+paths of unix domain sockets. It returns nothing if the process wasn't socket
+activated. The environment is unset, so child processes won't take the sockets
+as theirs. Closing the listeners won't remove the sockets, which are kept by
+systemd for the next start.
+*/
+func SdListeners() (map[string]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	fds := os.Getenv("LISTEN_FDS")
+	if fds == "" || os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return map[string]net.Listener{}, nil
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("Invalid LISTEN_FDS %v", fds)
+	}
+	return fileListeners(SD_LISTEN_FDS_START, count)
+}
+
+// fileListeners returns listeners of count file descriptors from start by
+// their addresses
+func fileListeners(start, count int) (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+	for fd := start; fd < start+count; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		// FileListener has its own copy of fd
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("Socket %v passed by systemd isn't a listening socket: %v", fd, err)
+		}
+		listeners[l.Addr().String()] = l
+	}
+	return listeners, nil
+}
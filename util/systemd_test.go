@@ -0,0 +1,124 @@
+package util
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestSdNotify(c *C) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	sent, err := SdNotify(SD_NOTIFY_READY)
+	c.Assert(err, IsNil)
+	c.Assert(sent, Equals, false)
+
+	dir, err := ioutil.TempDir("", "convoy-sdnotify")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	sent, err = SdNotify(SD_NOTIFY_READY)
+	c.Assert(err, IsNil)
+	c.Assert(sent, Equals, true)
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, SD_NOTIFY_READY)
+}
+
+func (s *TestSuite) TestSdWatchdogInterval(c *C) {
+	os.Unsetenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+	interval, err := SdWatchdogInterval()
+	c.Assert(err, IsNil)
+	c.Assert(interval, Equals, time.Duration(0))
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+	interval, err = SdWatchdogInterval()
+	c.Assert(err, IsNil)
+	c.Assert(interval, Equals, 30*time.Second)
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	defer os.Unsetenv("WATCHDOG_PID")
+	interval, err = SdWatchdogInterval()
+	c.Assert(err, IsNil)
+	c.Assert(interval, Equals, time.Duration(0))
+
+	os.Unsetenv("WATCHDOG_PID")
+	os.Setenv("WATCHDOG_USEC", "soon")
+	_, err = SdWatchdogInterval()
+	c.Assert(err, ErrorMatches, "Invalid WATCHDOG_USEC.*")
+}
+
+func (s *TestSuite) TestSdListeners(c *C) {
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	listeners, err := SdListeners()
+	c.Assert(err, IsNil)
+	c.Assert(listeners, HasLen, 0)
+	// Unset for child processes
+	c.Assert(os.Getenv("LISTEN_FDS"), Equals, "")
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "many")
+	_, err = SdListeners()
+	c.Assert(err, ErrorMatches, "Invalid LISTEN_FDS.*")
+
+	dir, err := ioutil.TempDir("", "convoy-sdlisten")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "convoy.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	c.Assert(err, IsNil)
+	defer l.Close()
+	// Passed fds are owned by fileListeners
+	fd, err := dupFd(l)
+	c.Assert(err, IsNil)
+
+	listeners, err = fileListeners(fd, 1)
+	c.Assert(err, IsNil)
+	c.Assert(listeners, HasLen, 1)
+	activated := listeners[socket]
+	c.Assert(activated, NotNil)
+	go func() {
+		conn, err := net.Dial("unix", socket)
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := activated.Accept()
+	c.Assert(err, IsNil)
+	conn.Close()
+
+	// Socket is kept for the next start
+	c.Assert(activated.Close(), IsNil)
+	_, err = os.Stat(socket)
+	c.Assert(err, IsNil)
+
+	// Not a socket
+	fd, err = syscall.Open(dir, syscall.O_RDONLY, 0)
+	c.Assert(err, IsNil)
+	_, err = fileListeners(fd, 1)
+	c.Assert(err, ErrorMatches, ".*isn't a listening socket.*")
+}
+
+func dupFd(l *net.UnixListener) (int, error) {
+	f, err := l.File()
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return syscall.Dup(int(f.Fd()))
+}
@@ -0,0 +1,118 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// writeTestCert writes certificate and key signed by parent, or self-signed
+// if parent is nil, and returns them
+func writeTestCert(c *C, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(filepath.Join(testRoot, name+".crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(testRoot, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	c.Assert(err, IsNil)
+	return cert, key
+}
+
+func (s *TestSuite) TestTLSConfig(c *C) {
+	path := func(name string) string {
+		return filepath.Join(testRoot, name)
+	}
+	ca, caKey := writeTestCert(c, "tls-ca", true, nil, nil)
+	writeTestCert(c, "tls-server", false, ca, caKey)
+	writeTestCert(c, "tls-client", false, ca, caKey)
+	// Not signed by the CA
+	writeTestCert(c, "tls-other", false, nil, nil)
+
+	_, err := NewServerTLSConfig(path("tls-server.crt"), "", "")
+	c.Assert(err, NotNil)
+	_, err = NewServerTLSConfig(path("tls-server.crt"), path("tls-server.key"), path("tls-server.key"))
+	c.Assert(err, ErrorMatches, "Cannot load TLS client CA: No PEM encoded certificate found in .*")
+	_, err = NewClientTLSConfig(path("tls-ca.crt"), path("tls-client.crt"), "")
+	c.Assert(err, NotNil)
+
+	serverConfig, err := NewServerTLSConfig(path("tls-server.crt"), path("tls-server.key"), path("tls-ca.crt"))
+	c.Assert(err, IsNil)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = serverConfig
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(config *tls.Config) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	clientConfig, err := NewClientTLSConfig(path("tls-ca.crt"), path("tls-client.crt"), path("tls-client.key"))
+	c.Assert(err, IsNil)
+	body, err := get(clientConfig)
+	c.Assert(err, IsNil)
+	c.Assert(body, Equals, "tls-client")
+
+	// Without client certificate
+	clientConfig, err = NewClientTLSConfig(path("tls-ca.crt"), "", "")
+	c.Assert(err, IsNil)
+	_, err = get(clientConfig)
+	c.Assert(err, NotNil)
+
+	// Client certificate not signed by the CA
+	clientConfig, err = NewClientTLSConfig(path("tls-ca.crt"), path("tls-other.crt"), path("tls-other.key"))
+	c.Assert(err, IsNil)
+	_, err = get(clientConfig)
+	c.Assert(err, NotNil)
+
+	// Server not trusted by client
+	clientConfig, err = NewClientTLSConfig(path("tls-other.crt"), path("tls-client.crt"), path("tls-client.key"))
+	c.Assert(err, IsNil)
+	_, err = get(clientConfig)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,499 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TRACE_PARENT_HEADER = "traceparent"
+
+	SPAN_KIND_INTERNAL = 1
+	SPAN_KIND_SERVER   = 2
+
+	// Spans waiting to be exported, the ones ending beyond it are dropped
+	TRACE_QUEUE_SIZE  = 4096
+	TRACE_BATCH_SIZE  = 512
+	TRACE_BATCH_DELAY = 5 * time.Second
+	TRACE_TIMEOUT     = 10 * time.Second
+
+	// Status codes of OTLP
+	otlpStatusError = 2
+)
+
+// TracingConfig configures export of spans by OTLP over HTTP in JSON
+type TracingConfig struct {
+	// URL spans are posted to, e.g. http://localhost:4318/v1/traces
+	Endpoint  string
+	Headers   map[string]string
+	TLSConfig *tls.Config
+	// Attributes of the resource of spans, e.g. service.name
+	Attributes map[string]string
+	// Ratio of traces started here which are recorded, those continuing
+	// traces of callers follow their decisions
+	SampleRatio float64
+}
+
+// TraceStats counts spans ended since tracing started
+type TraceStats struct {
+	Exported int64
+	Dropped  int64
+	Failed   int64
+}
+
+/*
+Tracer records spans of operations, and exports them in batches to an OTLP
+collector in background. Spans are kept in contexts, so spans started with
+the context of another are its children.
+*/
+type Tracer struct {
+	config TracingConfig
+	client *http.Client
+	queue  chan *Span
+	stop   chan struct{}
+	done   chan struct{}
+
+	mutex sync.Mutex
+	stats TraceStats
+}
+
+var (
+	tracerMutex sync.RWMutex
+	tracer      *Tracer
+)
+
+func NewTracer(config TracingConfig) (*Tracer, error) {
+	if !strings.HasPrefix(config.Endpoint, "http://") && !strings.HasPrefix(config.Endpoint, "https://") {
+		return nil, fmt.Errorf("Invalid OTLP endpoint %v, should be http:// or https:// URL of traces, e.g. http://localhost:4318/v1/traces", config.Endpoint)
+	}
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		return nil, fmt.Errorf("Invalid sample ratio %v, should be between 0 and 1", config.SampleRatio)
+	}
+	t := &Tracer{
+		config: config,
+		client: &http.Client{
+			Timeout: TRACE_TIMEOUT,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: config.TLSConfig,
+			},
+		},
+		queue: make(chan *Span, TRACE_QUEUE_SIZE),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go t.run()
+	return t, nil
+}
+
+// SetTracer sets where spans are recorded, nil to disable tracing, which is
+// the default
+func SetTracer(t *Tracer) {
+	tracerMutex.Lock()
+	defer tracerMutex.Unlock()
+
+	tracer = t
+}
+
+func getTracer() *Tracer {
+	tracerMutex.RLock()
+	defer tracerMutex.RUnlock()
+
+	return tracer
+}
+
+// Stats returns counts of spans exported, dropped for full queue, and
+// failed to be exported
+func (t *Tracer) Stats() TraceStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.stats
+}
+
+// Stop exports spans ended so far, and stops exporting
+func (t *Tracer) Stop() {
+	close(t.stop)
+	<-t.done
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(TRACE_BATCH_DELAY)
+	defer ticker.Stop()
+	batch := []*Span{}
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) < TRACE_BATCH_SIZE {
+				continue
+			}
+		case <-ticker.C:
+		case <-t.stop:
+			for len(t.queue) != 0 {
+				batch = append(batch, <-t.queue)
+			}
+			t.exportBatch(batch)
+			return
+		}
+		t.exportBatch(batch)
+		batch = []*Span{}
+	}
+}
+
+func (t *Tracer) exportBatch(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+	err := t.export(spans)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err != nil {
+		t.stats.Failed += int64(len(spans))
+		log.Warnf("Failed to export %v spans to %v: %v", len(spans), t.config.Endpoint, err)
+		return
+	}
+	t.stats.Exported += int64(len(spans))
+}
+
+func (t *Tracer) export(spans []*Span) error {
+	data, err := json.Marshal(otlpRequest(t.config.Attributes, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", t.config.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case t.queue <- span:
+	default:
+		t.mutex.Lock()
+		t.stats.Dropped++
+		t.mutex.Unlock()
+	}
+}
+
+// spanContext identifies a span, which may be of a caller
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type spanContextKey struct{}
+
+func contextSpan(ctx context.Context) (spanContext, bool) {
+	if ctx == nil {
+		return spanContext{}, false
+	}
+	sc, ok := ctx.Value(spanContextKey{}).(spanContext)
+	return sc, ok
+}
+
+/*
+Span is an operation being traced. Methods of nil Span do nothing, which is
+what StartSpan() returns if tracing is disabled or the trace isn't sampled,
+so callers don't need to check.
+*/
+type Span struct {
+	tracer   *Tracer
+	context  spanContext
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mutex      sync.Mutex
+	end        time.Time
+	attributes map[string]interface{}
+	err        string
+}
+
+/*
+StartSpan starts span name as child of the span of ctx, or as root of a new
+trace if there's none, returning context of the new span for its children.
+ctx can be nil. The span must be ended by End().
+*/
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	return startSpan(ctx, name, SPAN_KIND_INTERNAL)
+}
+
+/*
+StartServerSpan starts span name of serving request of a caller, which
+continues the trace of caller if traceParent, the traceparent header of W3C
+trace context, is valid, e.g. 00-<trace ID>-<span ID>-01.
+*/
+func StartServerSpan(ctx context.Context, name, traceParent string) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if parent, err := parseTraceParent(traceParent); err == nil {
+		ctx = context.WithValue(ctx, spanContextKey{}, parent)
+	}
+	return startSpan(ctx, name, SPAN_KIND_SERVER)
+}
+
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	t := getTracer()
+	if t == nil {
+		return ctx, nil
+	}
+	parent, hasParent := contextSpan(ctx)
+	sc := spanContext{
+		traceID: parent.traceID,
+		sampled: parent.sampled,
+	}
+	if !hasParent {
+		randomBytes(sc.traceID[:])
+		sc.sampled = sampleTrace(sc.traceID, t.config.SampleRatio)
+	}
+	randomBytes(sc.spanID[:])
+	ctx = context.WithValue(ctx, spanContextKey{}, sc)
+	if !sc.sampled {
+		// Children know the trace isn't sampled
+		return ctx, nil
+	}
+	return ctx, &Span{
+		tracer:   t,
+		context:  sc,
+		parentID: parent.spanID,
+		name:     name,
+		kind:     kind,
+		start:    time.Now(),
+	}
+}
+
+// sampleTrace decides by trace ID, so the same trace gets the same decision
+// wherever it's made
+func sampleTrace(traceID [16]byte, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>11)/float64(1<<53) < ratio
+}
+
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic("BUG: Cannot read random bytes: " + err.Error())
+	}
+}
+
+/*
+parseTraceParent parses traceparent header of W3C trace context, e.g.
+00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+*/
+func parseTraceParent(value string) (spanContext, error) {
+	sc := spanContext{}
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("Invalid traceparent %q", value)
+	}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.traceID) || isZero(traceID) {
+		return sc, fmt.Errorf("Invalid trace ID of traceparent %q", value)
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.spanID) || isZero(spanID) {
+		return sc, fmt.Errorf("Invalid span ID of traceparent %q", value)
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("Invalid flags of traceparent %q", value)
+	}
+	copy(sc.traceID[:], traceID)
+	copy(sc.spanID[:], spanID)
+	sc.sampled = flags&1 == 1
+	return sc, nil
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TraceParent returns traceparent header of W3C trace context for ctx,
+// empty if it has no span
+func TraceParent(ctx context.Context) string {
+	sc, ok := contextSpan(ctx)
+	if !ok {
+		return ""
+	}
+	flags := "00"
+	if sc.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.traceID[:]) + "-" + hex.EncodeToString(sc.spanID[:]) + "-" + flags
+}
+
+// DetachTrace returns context continuing the trace of ctx, without its
+// deadline and cancellation, for work outliving ctx, e.g. jobs of requests
+func DetachTrace(ctx context.Context) context.Context {
+	detached := context.Background()
+	if sc, ok := contextSpan(ctx); ok {
+		detached = context.WithValue(detached, spanContextKey{}, sc)
+	}
+	return detached
+}
+
+// TraceID returns ID of the trace of span, empty for nil span
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.context.traceID[:])
+}
+
+// SetAttribute sets attribute of span, whose value is a string, integer,
+// float or bool, anything else is formatted as string
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+// End ends span, failed if err isn't nil, and queues it for export
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if !s.end.IsZero() {
+		s.mutex.Unlock()
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	s.mutex.Unlock()
+
+	s.tracer.enqueue(s)
+}
+
+// otlpRequest encodes spans as ExportTraceServiceRequest of OTLP in JSON
+func otlpRequest(attributes map[string]string, spans []*Span) map[string]interface{} {
+	resource := map[string]interface{}{}
+	for k, v := range attributes {
+		resource[k] = v
+	}
+	encoded := []interface{}{}
+	for _, s := range spans {
+		encoded = append(encoded, s.otlp())
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(resource),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{
+							"name": "github.com/rancher/convoy",
+						},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (s *Span) otlp() map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	span := map[string]interface{}{
+		"traceId":           hex.EncodeToString(s.context.traceID[:]),
+		"spanId":            hex.EncodeToString(s.context.spanID[:]),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attributes),
+	}
+	if !isZero(s.parentID[:]) {
+		span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != "" {
+		span["status"] = map[string]interface{}{
+			"code":    otlpStatusError,
+			"message": s.err,
+		}
+	}
+	return span
+}
+
+func otlpAttributes(attributes map[string]interface{}) []interface{} {
+	encoded := []interface{}{}
+	for k, v := range attributes {
+		var value map[string]interface{}
+		switch v := v.(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, map[string]interface{}{
+			"key":   k,
+			"value": value,
+		})
+	}
+	return encoded
+}
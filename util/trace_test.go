@@ -0,0 +1,171 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestparseTraceParent(c *C) {
+	sc, err := parseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	c.Assert(err, IsNil)
+	c.Assert(sc.sampled, Equals, true)
+	c.Assert(TraceParent(context.WithValue(context.Background(), spanContextKey{}, sc)), Equals,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	sc, err = parseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	c.Assert(err, IsNil)
+	c.Assert(sc.sampled, Equals, false)
+
+	// Later versions may have more fields
+	_, err = parseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	c.Assert(err, IsNil)
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902zz-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+	} {
+		_, err := parseTraceParent(value)
+		c.Assert(err, NotNil, Commentf("traceparent %q", value))
+	}
+}
+
+func (s *TestSuite) TestTracer(c *C) {
+	var (
+		mutex    sync.Mutex
+		requests []map[string]interface{}
+		headers  []http.Header
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request := map[string]interface{}{}
+		if err := json.Unmarshal(data, &request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		requests = append(requests, request)
+		headers = append(headers, r.Header)
+		mutex.Unlock()
+	}))
+	defer collector.Close()
+
+	// Disabled by default
+	ctx, span := StartSpan(nil, "disabled")
+	c.Assert(span, IsNil)
+	c.Assert(TraceParent(ctx), Equals, "")
+	span.SetAttribute("key", "value")
+	span.End(nil)
+
+	_, err := NewTracer(TracingConfig{Endpoint: "localhost:4318"})
+	c.Assert(err, NotNil)
+	_, err = NewTracer(TracingConfig{Endpoint: collector.URL, SampleRatio: 2})
+	c.Assert(err, NotNil)
+
+	tracer, err := NewTracer(TracingConfig{
+		Endpoint:    collector.URL,
+		Headers:     map[string]string{"Api-Key": "secret"},
+		Attributes:  map[string]string{"service.name": "test"},
+		SampleRatio: 1,
+	})
+	c.Assert(err, IsNil)
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx, root := StartServerSpan(context.Background(), "POST /volumes/create", parent)
+	c.Assert(root, NotNil)
+	c.Assert(root.TraceID(), Equals, "4bf92f3577b34da6a3ce929d0e0e4736")
+	root.SetAttribute("http.status_code", 500)
+	childCtx, child := StartSpan(ctx, "driver.CreateVolume")
+	child.SetAttribute("convoy.volume", "vol1")
+	child.SetAttribute("retried", true)
+	child.End(fmt.Errorf("no space"))
+	// Ended once only
+	child.End(nil)
+	root.End(nil)
+
+	// Jobs outlive their requests
+	cancelled, cancel := context.WithCancel(childCtx)
+	cancel()
+	detached := DetachTrace(cancelled)
+	c.Assert(detached.Err(), IsNil)
+	c.Assert(TraceParent(detached), Equals, TraceParent(childCtx))
+
+	// Callers not sampling are followed
+	ctx, span = StartServerSpan(context.Background(), "unsampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	c.Assert(span, IsNil)
+	_, span = StartSpan(ctx, "child")
+	c.Assert(span, IsNil)
+
+	tracer.Stop()
+	c.Assert(tracer.Stats(), Equals, TraceStats{Exported: 2})
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	c.Assert(requests, HasLen, 1)
+	c.Assert(headers[0].Get("Api-Key"), Equals, "secret")
+	c.Assert(headers[0].Get("Content-Type"), Equals, "application/json")
+
+	resourceSpans := requests[0]["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	c.Assert(resourceSpans["resource"], DeepEquals, map[string]interface{}{
+		"attributes": []interface{}{
+			map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "test"}},
+		},
+	})
+	spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	c.Assert(spans, HasLen, 2)
+	exportedChild := spans[0].(map[string]interface{})
+	exportedRoot := spans[1].(map[string]interface{})
+
+	c.Assert(exportedRoot["name"], Equals, "POST /volumes/create")
+	c.Assert(exportedRoot["traceId"], Equals, "4bf92f3577b34da6a3ce929d0e0e4736")
+	c.Assert(exportedRoot["parentSpanId"], Equals, "00f067aa0ba902b7")
+	c.Assert(exportedRoot["kind"], Equals, float64(SPAN_KIND_SERVER))
+	c.Assert(exportedRoot["status"], IsNil)
+	c.Assert(exportedRoot["attributes"], DeepEquals, []interface{}{
+		map[string]interface{}{"key": "http.status_code", "value": map[string]interface{}{"intValue": "500"}},
+	})
+
+	c.Assert(exportedChild["name"], Equals, "driver.CreateVolume")
+	c.Assert(exportedChild["traceId"], Equals, exportedRoot["traceId"])
+	c.Assert(exportedChild["parentSpanId"], Equals, exportedRoot["spanId"])
+	c.Assert(exportedChild["kind"], Equals, float64(SPAN_KIND_INTERNAL))
+	c.Assert(exportedChild["status"], DeepEquals, map[string]interface{}{
+		"code":    float64(otlpStatusError),
+		"message": "no space",
+	})
+	c.Assert(exportedChild["attributes"], HasLen, 2)
+	c.Assert(exportedChild["startTimeUnixNano"].(string) <= exportedChild["endTimeUnixNano"].(string), Equals, true)
+}
+
+func (s *TestSuite) TestSampleTrace(c *C) {
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		var traceID [16]byte
+		randomBytes(traceID[:])
+		if sampleTrace(traceID, 0.25) {
+			sampled++
+		}
+		c.Assert(sampleTrace(traceID, 0), Equals, false)
+		c.Assert(sampleTrace(traceID, 1), Equals, true)
+	}
+	c.Assert(sampled > 150 && sampled < 350, Equals, true, Commentf("sampled %v of 1000", sampled))
+}
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// FilesystemUsage returns the size and used bytes of the filesystem the path
+// is on, as df does
+func FilesystemUsage(path string) (int64, int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	size := int64(st.Blocks) * int64(st.Bsize)
+	used := int64(st.Blocks-st.Bfree) * int64(st.Bsize)
+	return size, used, nil
+}
+
+// IsMountPoint returns true if a filesystem is mounted at the path, i.e. it's
+// on a device other than the one of its parent directory
+func IsMountPoint(path string) (bool, error) {
+	var st, parent syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return false, err
+	}
+	if err := syscall.Stat(filepath.Dir(filepath.Clean(path)), &parent); err != nil {
+		return false, err
+	}
+	return st.Dev != parent.Dev, nil
+}
+
+// DiskUsage returns the bytes allocated for the file, or for everything under
+// the directory, as du does. Holes of sparse files don't count, and hard
+// links are counted once.
+func DiskUsage(path string) (int64, error) {
+	var total int64
+	seen := make(map[uint64]bool)
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			total += info.Size()
+			return nil
+		}
+		if st.Nlink > 1 && !info.IsDir() {
+			if seen[st.Ino] {
+				return nil
+			}
+			seen[st.Ino] = true
+		}
+		total += st.Blocks * 512
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
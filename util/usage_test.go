@@ -0,0 +1,63 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestDiskUsage(c *C) {
+	dir, err := ioutil.TempDir(testRoot, "usage")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	empty, err := DiskUsage(dir)
+	c.Assert(err, IsNil)
+
+	data := make([]byte, 1<<20)
+	for i := range data {
+		data[i] = 1
+	}
+	file := filepath.Join(dir, "data")
+	c.Assert(ioutil.WriteFile(file, data, 0644), IsNil)
+	// Hard link doesn't take space again
+	c.Assert(os.Link(file, filepath.Join(dir, "link")), IsNil)
+	// Neither does hole of sparse file
+	c.Assert(s.createFile(filepath.Join(dir, "sparse"), 1<<30), IsNil)
+
+	used, err := DiskUsage(dir)
+	c.Assert(err, IsNil)
+	c.Assert(used-empty >= 1<<20, Equals, true)
+	c.Assert(used-empty < 2<<20, Equals, true)
+
+	used, err = DiskUsage(file)
+	c.Assert(err, IsNil)
+	c.Assert(used >= 1<<20, Equals, true)
+
+	_, err = DiskUsage(filepath.Join(dir, "nonexistent"))
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestFilesystemUsage(c *C) {
+	size, used, err := FilesystemUsage(testRoot)
+	c.Assert(err, IsNil)
+	c.Assert(size > 0, Equals, true)
+	c.Assert(used <= size, Equals, true)
+
+	_, _, err = FilesystemUsage(filepath.Join(testRoot, "nonexistent"))
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestIsMountPoint(c *C) {
+	mounted, err := IsMountPoint("/proc")
+	c.Assert(err, IsNil)
+	c.Assert(mounted, Equals, true)
+	mounted, err = IsMountPoint(testRoot)
+	c.Assert(err, IsNil)
+	c.Assert(mounted, Equals, false)
+
+	_, err = IsMountPoint(filepath.Join(testRoot, "nonexistent"))
+	c.Assert(err, NotNil)
+}
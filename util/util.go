@@ -107,19 +107,43 @@ func Sync() error {
 }
 
 func Freeze(mountpoint string) error {
-	if _, err := Execute("fsfreeze", []string{"-f", mountpoint}); err != nil {
+	cmdName, cmdArgs := updateMountNamespace("fsfreeze", []string{"-f", mountpoint})
+	if _, err := Execute(cmdName, cmdArgs); err != nil {
 		return err
 	}
 	return nil
 }
 
 func UnFreeze(mountpoint string) error {
-	if _, err := Execute("fsfreeze", []string{"-u", mountpoint}); err != nil {
+	cmdName, cmdArgs := updateMountNamespace("fsfreeze", []string{"-u", mountpoint})
+	if _, err := Execute(cmdName, cmdArgs); err != nil {
 		return err
 	}
 	return nil
 }
 
+/*
+FreezeFilesystem flushes and freezes the filesystem mounted at mountPoint, so
+snapshot of its device taken before calling the returned thaw function would
+be crash-consistent. Read-only mount is left as is since it has nothing in
+flight.
+*/
+func FreezeFilesystem(mountPoint string) (func(), error) {
+	if isMountedReadOnly(mountPoint) {
+		return func() {}, nil
+	}
+	log.Debugf("Freezing filesystem at %v", mountPoint)
+	if err := Freeze(mountPoint); err != nil {
+		return nil, err
+	}
+	return func() {
+		log.Debugf("Thawing filesystem at %v", mountPoint)
+		if err := UnFreeze(mountPoint); err != nil {
+			log.Errorf("Failed to thaw filesystem at %v: %v", mountPoint, err)
+		}
+	}, nil
+}
+
 func SliceToMap(slices []string) map[string]string {
 	result := map[string]string{}
 	for _, v := range slices {
@@ -196,6 +220,15 @@ func Copy(src, dst string) error {
 	return nil
 }
 
+// CopyDir copies everything in sourceDir into targetDir, keeping ownership,
+// permissions and timestamps
+func CopyDir(sourceDir, targetDir string) error {
+	if _, err := Execute("cp", []string{"-a", sourceDir + "/.", targetDir}); err != nil {
+		return err
+	}
+	return nil
+}
+
 func AttachLoopbackDevice(file string, readonly bool) (string, error) {
 	params := []string{"--show", "-f"}
 	if readonly {
@@ -307,6 +340,25 @@ func ParseSize(size string) (int64, error) {
 	return value, err
 }
 
+// ParseDuration extends time.ParseDuration with "d" for days and "w" for
+// weeks, e.g. "30d"
+func ParseDuration(duration string) (time.Duration, error) {
+	readableDuration := regexp.MustCompile(`^([0-9]+)([dw])$`)
+	matches := readableDuration.FindStringSubmatch(strings.ToLower(duration))
+	if matches == nil {
+		return time.ParseDuration(duration)
+	}
+	value, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	day := 24 * time.Hour
+	if matches[2] == "w" {
+		return time.Duration(value) * 7 * day, nil
+	}
+	return time.Duration(value) * day, nil
+}
+
 func CheckBinaryVersion(binaryName, minVersion string, args []string) error {
 	output, err := exec.Command(binaryName, args...).CombinedOutput()
 	if err != nil {
@@ -321,9 +373,29 @@ func CheckBinaryVersion(binaryName, minVersion string, args []string) error {
 }
 
 func Execute(binary string, args []string) (string, error) {
+	return executeCmd(exec.Command(binary, args...), cmdTimeout)
+}
+
+// ExecuteWithEnv runs binary with extra environment variables, in addition to
+// the daemon's, and kills it after timeout
+func ExecuteWithEnv(binary string, args, env []string, timeout time.Duration) (string, error) {
+	cmd := exec.Command(binary, args...)
+	cmd.Env = append(os.Environ(), env...)
+	return executeCmd(cmd, timeout)
+}
+
+// ExecuteWithInput is ExecuteWithEnv with input fed to stdin of binary
+func ExecuteWithInput(binary string, args, env []string, input []byte, timeout time.Duration) (string, error) {
+	cmd := exec.Command(binary, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(input)
+	return executeCmd(cmd, timeout)
+}
+
+func executeCmd(cmd *exec.Cmd, timeout time.Duration) (string, error) {
 	var output []byte
 	var err error
-	cmd := exec.Command(binary, args...)
+	binary, args := cmd.Path, cmd.Args[1:]
 	done := make(chan struct{})
 
 	go func() {
@@ -333,7 +405,7 @@ func Execute(binary string, args []string) (string, error) {
 
 	select {
 	case <-done:
-	case <-time.After(cmdTimeout):
+	case <-time.After(timeout):
 		if cmd.Process != nil {
 			if err := cmd.Process.Kill(); err != nil {
 				log.Warnf("Problem killing process pid=%v: %s", cmd.Process.Pid, err)
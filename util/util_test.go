@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 
 	. "gopkg.in/check.v1"
 )
@@ -222,6 +223,26 @@ func (s *TestSuite) TestParseSize(c *C) {
 	c.Assert(err, ErrorMatches, "strconv.ParseInt: parsing .*: invalid syntax")
 }
 
+func (s *TestSuite) TestParseDuration(c *C) {
+	value, err := ParseDuration("30d")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, 30*24*time.Hour)
+
+	value, err = ParseDuration("2W")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, 14*24*time.Hour)
+
+	value, err = ParseDuration("90m")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, 90*time.Minute)
+
+	_, err = ParseDuration("d")
+	c.Assert(err, NotNil)
+
+	_, err = ParseDuration("1.5d")
+	c.Assert(err, NotNil)
+}
+
 func (s *TestSuite) TestIndex(c *C) {
 	var err error
 	index := NewIndex()
@@ -324,6 +345,35 @@ func (s *TestSuite) TestCompressDir(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *TestSuite) TestCopyDir(c *C) {
+	tmpdir, err := ioutil.TempDir("/tmp", "convoy")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(tmpdir)
+
+	source := filepath.Join(tmpdir, "source")
+	c.Assert(os.MkdirAll(filepath.Join(source, "dir"), 0700), IsNil)
+	data := []byte("Some random string")
+	c.Assert(ioutil.WriteFile(filepath.Join(source, "dir", "file"), data, 0600), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(source, ".hidden"), data, 0644), IsNil)
+
+	target := filepath.Join(tmpdir, "target")
+	c.Assert(os.Mkdir(target, 0700), IsNil)
+	c.Assert(CopyDir(source, target), IsNil)
+
+	result, err := ioutil.ReadFile(filepath.Join(target, "dir", "file"))
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, data)
+	info, err := os.Stat(filepath.Join(target, ".hidden"))
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0644))
+
+	// Copies are independent
+	c.Assert(ioutil.WriteFile(filepath.Join(target, "dir", "file"), []byte("changed"), 0600), IsNil)
+	result, err = ioutil.ReadFile(filepath.Join(source, "dir", "file"))
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, data)
+}
+
 var (
 	firstLetters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 	letters      = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_.-")
@@ -10,9 +10,10 @@ import (
 )
 
 const (
-	MOUNT_BINARY   = "mount"
-	UMOUNT_BINARY  = "umount"
-	NSENTER_BINARY = "nsenter"
+	MOUNT_BINARY    = "mount"
+	UMOUNT_BINARY   = "umount"
+	NSENTER_BINARY  = "nsenter"
+	BLOCKDEV_BINARY = "blockdev"
 
 	IMAGE_FILE_NAME = "disk.img"
 	BLOCK_DEV_NAME  = "disk.dev"
@@ -30,7 +31,11 @@ var (
 	mountNamespaceFD = ""
 )
 
-/* Caller must implement VolumeHelper interface, and must have fields "Name" and "MountPoint" */
+/*
+Caller must implement VolumeHelper interface, and must have fields "Name" and
+"MountPoint". Optional field "EncryptionKey" means volume is encrypted with the
+key, see InitEncryptionKeySources().
+*/
 type VolumeHelper interface {
 	GetDevice() (string, error)
 	GetMountOpts() []string
@@ -127,16 +132,76 @@ func isMounted(mountPoint string) bool {
 	return false
 }
 
-func VolumeMount(v interface{}, mountPoint string, remount bool) (string, error) {
+/*
+mountedReadOnly parses output of mount, in format of:
+
+<device> on <mount point> type <fs type> (<options>)
+
+and returns whether mountPoint is mounted read-only. The last entry wins if
+mountPoint was mounted multiple times.
+*/
+func mountedReadOnly(output, mountPoint string) bool {
+	readOnly := false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[2] != mountPoint {
+			continue
+		}
+		readOnly = false
+		for _, opt := range strings.Split(strings.Trim(fields[5], "()"), ",") {
+			if opt == "ro" {
+				readOnly = true
+			}
+		}
+	}
+	return readOnly
+}
+
+// mountedAt parses output of mount, and returns whether mountPoint is
+// exactly a mount point, unlike isMounted matching any part of the entries
+func mountedAt(output, mountPoint string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[2] == mountPoint {
+			return true
+		}
+	}
+	return false
+}
+
+func isMountedReadOnly(mountPoint string) bool {
+	output, err := callMount([]string{}, []string{})
+	if err != nil {
+		return false
+	}
+	return mountedReadOnly(output, mountPoint)
+}
+
+/*
+VolumeMount mounts the volume at mountPoint, or the default mount point if
+it's empty. If readOnly is true, the filesystem would be mounted read-only,
+and the block device of the volume would be set read-only as well once
+mounted. Device is set read-only after mount, so the filesystem of a volume
+derived from a snapshot of a mounted volume can still recover its journal.
+Existing mount would be returned as is, unless it's mounted read-write but
+asked for read-only, or the other way around. Encrypted volume would be opened and mounted from decrypted device.
+If seLinuxContext is specified, the whole filesystem would be labeled as it
+when mounted, see SELinuxMountContext().
+*/
+func VolumeMount(v interface{}, mountPoint string, remount, readOnly bool, seLinuxContext string) (string, error) {
 	vol, err := getVolumeOps(v)
 	if err != nil {
 		return "", err
 	}
-	dev, err := vol.GetDevice()
+	dev, err := getVolumeFilesystemDevice(vol)
 	if err != nil {
 		return "", err
 	}
 	opts := vol.GetMountOpts()
+	if readOnly {
+		opts = append(opts, "-o", "ro")
+	}
+	opts = append(opts, getSELinuxMountOpts(seLinuxContext)...)
 	createMountpoint := false
 	if mountPoint == "" {
 		mountPoint = vol.GenerateDefaultMountPoint()
@@ -160,11 +225,29 @@ func VolumeMount(v interface{}, mountPoint string, remount bool) (string, error)
 		}
 	}
 	if !isMounted(mountPoint) {
+		if isBlockDevicePath(dev) {
+			// Device may be left read-only by a previous read-only mount
+			if err := setBlockDeviceReadOnly(dev, false); err != nil {
+				log.Warnf("Cannot set device %v of volume %v read-write: %v", dev, getVolumeName(vol), err)
+			}
+		}
 		log.Debugf("Volume %v is being mounted it to %v, with option %v", getVolumeName(vol), mountPoint, opts)
 		_, err = callMount(opts, []string{dev, mountPoint})
 		if err != nil {
+			if getVolumeEncryptionKey(vol) != "" {
+				CloseEncryptedDevice(getVolumeName(vol))
+			}
 			return "", err
 		}
+		if readOnly && isBlockDevicePath(dev) {
+			if err := setBlockDeviceReadOnly(dev, true); err != nil {
+				log.Warnf("Volume %v is mounted read-only, but failed to set device %v read-only: %v", getVolumeName(vol), dev, err)
+			}
+		}
+	} else if mountedReadOnly := isMountedReadOnly(mountPoint); readOnly && !mountedReadOnly {
+		return "", fmt.Errorf("Volume %v was already mounted read-write at %v, but asked to mount read-only", getVolumeName(vol), mountPoint)
+	} else if !readOnly && mountedReadOnly {
+		return "", fmt.Errorf("Volume %v was already mounted read-only at %v, but asked to mount read-write", getVolumeName(vol), mountPoint)
 	}
 	setVolumeMountPoint(vol, mountPoint)
 	return mountPoint, nil
@@ -183,6 +266,16 @@ func VolumeUmount(v interface{}) error {
 	if err := callUmount([]string{mountPoint}); err != nil {
 		return err
 	}
+	if getVolumeEncryptionKey(vol) != "" {
+		if err := CloseEncryptedDevice(getVolumeName(vol)); err != nil {
+			return err
+		}
+	}
+	if dev, err := vol.GetDevice(); err == nil && isBlockDevicePath(dev) {
+		if err := setBlockDeviceReadOnly(dev, false); err != nil {
+			log.Warnf("Cannot set device %v of volume %v back to read-write: %v", dev, getVolumeName(vol), err)
+		}
+	}
 	if mountPoint == vol.GenerateDefaultMountPoint() {
 		if err := os.Remove(mountPoint); err != nil {
 			log.Warnf("Cannot cleanup mount point directory %v due to %v\n", mountPoint, err)
@@ -192,6 +285,118 @@ func VolumeUmount(v interface{}) error {
 	return nil
 }
 
+// ListMounts returns mounts of the host, in the mount namespace of volumes,
+// for ReconcileVolumeMount()
+func ListMounts() (string, error) {
+	return callMount([]string{}, []string{})
+}
+
+/*
+ReconcileVolumeMount reconciles mount of the volume with mounts of the host
+listed by ListMounts(), e.g. after an unclean shutdown or reboot. Volume not
+mounted at its mount point would be mounted there again, read-write.
+Volume without mount point would have its default mount point unmounted if
+it's still mounted, e.g. daemon crashed before recording the mount, and
+removed if it's left behind. Caller should save the volume. It returns what
+has been done, empty if nothing.
+*/
+func ReconcileVolumeMount(v interface{}, mounts string) (string, error) {
+	vol, err := getVolumeOps(v)
+	if err != nil {
+		return "", err
+	}
+	mountPoint := getVolumeMountPoint(vol)
+	defaultMountPoint := vol.GenerateDefaultMountPoint()
+	if mountPoint != "" {
+		if mountedAt(mounts, mountPoint) {
+			return "", nil
+		}
+		// Default mount point would be created again by VolumeMount
+		target := mountPoint
+		if target == defaultMountPoint {
+			target = ""
+		}
+		if _, err := VolumeMount(v, target, false, false, ""); err != nil {
+			return "", err
+		}
+		return "remounted at " + mountPoint, nil
+	}
+	if mountedAt(mounts, defaultMountPoint) {
+		if err := callUmount([]string{defaultMountPoint}); err != nil {
+			return "", err
+		}
+		if getVolumeEncryptionKey(vol) != "" {
+			if err := CloseEncryptedDevice(getVolumeName(vol)); err != nil {
+				return "", err
+			}
+		}
+		if err := os.Remove(defaultMountPoint); err != nil {
+			log.Warnf("Cannot cleanup mount point directory %v due to %v", defaultMountPoint, err)
+		}
+		return "unmounted stale mount at " + defaultMountPoint, nil
+	}
+	if _, err := os.Stat(defaultMountPoint); err != nil {
+		return "", nil
+	}
+	if err := os.Remove(defaultMountPoint); err != nil {
+		return "", err
+	}
+	return "removed stale mount point " + defaultMountPoint, nil
+}
+
+/*
+BindMount mounts directory source at target, which is created if absent, so
+the same volume can be exposed at multiple paths. The bind mount is
+read-only if readOnly is true, even if source is read-write. Existing mount at
+target is kept as is.
+*/
+func BindMount(source, target string, readOnly bool) error {
+	output, err := callMount([]string{}, []string{})
+	if err != nil {
+		return err
+	}
+	if mountedAt(output, target) {
+		if readOnly && !mountedReadOnly(output, target) {
+			return fmt.Errorf("%v was already mounted read-write, but asked to mount read-only", target)
+		}
+		return nil
+	}
+	if err := callMkdirIfNotExists(target); err != nil {
+		return err
+	}
+	if _, err := callMount([]string{"--bind"}, []string{source, target}); err != nil {
+		return err
+	}
+	if readOnly {
+		// Bind mounts ignore ro until remounted
+		if _, err := callMount([]string{"-o", "remount,bind,ro"}, []string{target}); err != nil {
+			callUmount([]string{target})
+			return err
+		}
+	}
+	return nil
+}
+
+// UmountPath umounts target if mounted, and removes the directory
+func UmountPath(target string) error {
+	output, err := callMount([]string{}, []string{})
+	if err != nil {
+		return err
+	}
+	if mountedAt(output, target) {
+		if err := callUmount([]string{target}); err != nil {
+			return err
+		}
+	}
+	cmdName, cmdArgs := updateMountNamespace("rmdir", []string{"--ignore-fail-on-non-empty", target})
+	if _, err := Execute(cmdName, cmdArgs); err != nil {
+		if _, statErr := os.Stat(target); !os.IsNotExist(statErr) {
+			return err
+		}
+	}
+	return nil
+}
+
 func callMkdirIfNotExists(dirName string) error {
 	cmdName := "mkdir"
 	cmdArgs := []string{"-p", dirName}
@@ -215,6 +420,21 @@ func callMount(opts, args []string) (string, error) {
 	return output, nil
 }
 
+// isBlockDevicePath excludes devices like tmpfs or GlusterFS volumes
+func isBlockDevicePath(dev string) bool {
+	return strings.HasPrefix(dev, "/dev/")
+}
+
+func setBlockDeviceReadOnly(dev string, readOnly bool) error {
+	flag := "--setrw"
+	if readOnly {
+		flag = "--setro"
+	}
+	cmdName, cmdArgs := updateMountNamespace(BLOCKDEV_BINARY, []string{flag, dev})
+	_, err := Execute(cmdName, cmdArgs)
+	return err
+}
+
 func callUmount(args []string) error {
 	cmdName := UMOUNT_BINARY
 	cmdArgs := args
@@ -417,6 +637,70 @@ func makeBlockDeviceNode(file, major, minor string) error {
 	return nil
 }
 
+// CheckSubPath validates subPath as a relative path within a volume
+func CheckSubPath(subPath string) error {
+	if filepath.IsAbs(subPath) {
+		return fmt.Errorf("Invalid subpath %v, must be relative to the volume", subPath)
+	}
+	if strings.ContainsAny(subPath, "\x00\n") {
+		return fmt.Errorf("Invalid subpath %q", subPath)
+	}
+	for _, elem := range strings.Split(subPath, "/") {
+		if elem == ".." {
+			return fmt.Errorf("Invalid subpath %v, cannot contain ..", subPath)
+		}
+	}
+	if filepath.Clean(subPath) == "." {
+		return fmt.Errorf("Invalid subpath %q, cannot be the root of the volume", subPath)
+	}
+	return nil
+}
+
+// resolvePath resolves symlinks in path, whose components may not exist yet
+func resolvePath(path string) (string, error) {
+	cmdName, cmdArgs := updateMountNamespace("readlink", []string{"-m", path})
+	output, err := Execute(cmdName, cmdArgs)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+func resolvePathWithin(mp, path string) (string, error) {
+	resolvedMP, err := resolvePath(mp)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(resolved, strings.TrimSuffix(resolvedMP, "/")+"/") {
+		return "", fmt.Errorf("Subpath %v resolves to %v, which is outside of volume mounted at %v", path, resolved, mp)
+	}
+	return resolved, nil
+}
+
+/*
+MountPointSubPath creates subPath within mount point mp if absent, and returns
+its path. Symlinks in subPath are resolved, and rejected if they lead out of
+mp, so files out of the volume cannot be exposed through it.
+*/
+func MountPointSubPath(mp, subPath string) (string, error) {
+	if err := CheckSubPath(subPath); err != nil {
+		return "", err
+	}
+	path, err := resolvePathWithin(mp, filepath.Join(mp, subPath))
+	if err != nil {
+		return "", err
+	}
+	if err := callMkdirIfNotExists(path); err != nil {
+		return "", err
+	}
+	// Check again in case any component was replaced by symlink meanwhile
+	return resolvePathWithin(mp, path)
+}
+
 func MountPointRemoveFile(file string) error {
 	cmdName := "rm"
 	cmdArgs := []string{
@@ -457,3 +741,251 @@ func MountPointPrepareBlockDevice(mp string, dev string) error {
 	}
 	return nil
 }
+
+const (
+	FS_EXT4  = "ext4"
+	FS_XFS   = "xfs"
+	FS_BTRFS = "btrfs"
+)
+
+// CheckFilesystem validates filesystem type which volumes can be formatted
+// with
+func CheckFilesystem(fsType string) error {
+	switch fsType {
+	case FS_EXT4, FS_XFS, FS_BTRFS:
+		return nil
+	}
+	return fmt.Errorf("Unsupported filesystem %v, should be one of %v, %v and %v", fsType, FS_EXT4, FS_XFS, FS_BTRFS)
+}
+
+// CheckMountOptions validates comma separated mount options, e.g.
+// "noatime,discard"
+func CheckMountOptions(mountOpts string) error {
+	if mountOpts == "" {
+		return nil
+	}
+	for _, opt := range strings.Split(mountOpts, ",") {
+		if opt == "" || strings.ContainsAny(opt, " \t\n") {
+			return fmt.Errorf("Invalid mount options %q, should be comma separated like noatime,discard", mountOpts)
+		}
+	}
+	return nil
+}
+
+// FormatDevice creates filesystem on the device. mkfsOpts are extra flags
+// passed to mkfs of the filesystem, e.g. "-E lazy_itable_init=0 -I 512"
+func FormatDevice(dev, fsType, mkfsOpts string) error {
+	if err := CheckFilesystem(fsType); err != nil {
+		return err
+	}
+	args := []string{"-t", fsType}
+	args = append(args, strings.Fields(mkfsOpts)...)
+	args = append(args, dev)
+	log.Debugf("Formatting device %v with %v filesystem, options %v", dev, fsType, mkfsOpts)
+	if _, err := Execute("mkfs", args); err != nil {
+		log.Errorf("Formatting device %v failed", dev)
+		return err
+	}
+	log.Debugf("Formatting device %v done", dev)
+	return nil
+}
+
+// GetFilesystemType detects filesystem on the device, e.g. of a volume
+// restored from backup
+func GetFilesystemType(dev string) (string, error) {
+	output, err := Execute("blkid", []string{"-o", "value", "-s", "TYPE", dev})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// GetFilesystemMountOpts returns options for mounting a volume with known
+// filesystem type, otherwise mount would detect it. mountOpts are comma
+// separated options passed with "-o".
+func GetFilesystemMountOpts(fsType, mountOpts string) []string {
+	opts := []string{}
+	if fsType != "" {
+		opts = append(opts, "-t", fsType)
+	}
+	if mountOpts != "" {
+		opts = append(opts, "-o", mountOpts)
+	}
+	return opts
+}
+
+// CloneMountOptions returns mount options of a volume cloned from one with
+// fsType and mountOpts. xfs refuses to mount a filesystem with the same UUID
+// as one already mounted, e.g. the source of the clone, unless with "nouuid"
+func CloneMountOptions(fsType, mountOpts string) string {
+	if fsType != FS_XFS {
+		return mountOpts
+	}
+	if mountOpts == "" {
+		return "nouuid"
+	}
+	for _, opt := range strings.Split(mountOpts, ",") {
+		if opt == "nouuid" {
+			return mountOpts
+		}
+	}
+	return mountOpts + ",nouuid"
+}
+
+// GrowFilesystem would grow the filesystem to the size of device. xfs and
+// btrfs can only be grown when mounted.
+func GrowFilesystem(dev, mountPoint, fsType string) error {
+	var (
+		cmdName string
+		cmdArgs []string
+	)
+	switch fsType {
+	case FS_EXT4:
+		cmdName, cmdArgs = "resize2fs", []string{dev}
+	case FS_XFS:
+		cmdName, cmdArgs = "xfs_growfs", []string{mountPoint}
+	case FS_BTRFS:
+		cmdName, cmdArgs = "btrfs", []string{"filesystem", "resize", "max", mountPoint}
+	default:
+		return fmt.Errorf("Cannot grow unsupported filesystem %v of %v", fsType, dev)
+	}
+	if fsType != FS_EXT4 {
+		if mountPoint == "" {
+			return fmt.Errorf("Filesystem %v of %v can only be grown when mounted", fsType, dev)
+		}
+		cmdName, cmdArgs = updateMountNamespace(cmdName, cmdArgs)
+	}
+	_, err := Execute(cmdName, cmdArgs)
+	return err
+}
+
+// GetDeviceSize returns size of the block device in bytes
+func GetDeviceSize(dev string) (int64, error) {
+	output, err := Execute(BLOCKDEV_BINARY, []string{"--getsize64", dev})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+}
+
+/*
+GrowExpandedFilesystem grows filesystem of a mounted volume to the size of its
+device, if the device has been expanded beyond knownSize outside of Convoy,
+e.g. by EBS ModifyVolume or extending the devicemapper device. Filesystem type
+would be detected if fsType is empty. It returns the size of device, or
+knownSize if the device hasn't been expanded.
+*/
+func GrowExpandedFilesystem(dev, mountPoint, fsType string, knownSize int64) (int64, error) {
+	return growExpandedFilesystem(dev, mountPoint, fsType, knownSize, func() (string, error) {
+		return dev, nil
+	})
+}
+
+/*
+GrowExpandedVolume is GrowExpandedFilesystem() for the mounted volume. If the
+volume is encrypted, decrypted device would be resized before growing the
+filesystem on it.
+*/
+func GrowExpandedVolume(v interface{}, fsType string, knownSize int64) (int64, error) {
+	vol, err := getVolumeOps(v)
+	if err != nil {
+		return knownSize, err
+	}
+	dev, err := vol.GetDevice()
+	if err != nil {
+		return knownSize, err
+	}
+	keyID := getVolumeEncryptionKey(vol)
+	return growExpandedFilesystem(dev, getVolumeMountPoint(vol), fsType, knownSize, func() (string, error) {
+		if keyID == "" {
+			return dev, nil
+		}
+		name := getVolumeName(vol)
+		if err := resizeEncryptedDevice(name, keyID); err != nil {
+			return "", err
+		}
+		return EncryptedDevicePath(name), nil
+	})
+}
+
+/*
+GrowMountedVolume loads the volume, then grows its filesystem by grow() if
+it's mounted, e.g. by GrowExpandedVolume(), and saves the volume if grow()
+returns true. volume should be blank other than its name and config path.
+*/
+func GrowMountedVolume(volume interface{}, grow func() (bool, error)) (bool, error) {
+	vol, err := getVolumeOps(volume)
+	if err != nil {
+		return false, err
+	}
+	if err := ObjectLoad(volume); err != nil {
+		return false, err
+	}
+	if getVolumeMountPoint(vol) == "" {
+		return false, nil
+	}
+	grown, err := grow()
+	if err != nil || !grown {
+		return false, err
+	}
+	return true, ObjectSave(volume)
+}
+
+/*
+GrowMountedFilesystems is the loop of GrowFilesystems() of drivers, calling
+grow() for each of the volumes, which returns the new size of the volume if
+its filesystem is grown, or 0 otherwise. Failure of one volume is logged and
+doesn't stop the others. It returns the new sizes of volumes grown.
+*/
+func GrowMountedFilesystems(volumeIDs []string, grow func(id string) (int64, error)) map[string]int64 {
+	grown := make(map[string]int64)
+	for _, id := range volumeIDs {
+		size, err := grow(id)
+		if err != nil {
+			log.Warnf("Failed to grow filesystem of volume %v: %v", id, err)
+			continue
+		}
+		if size != 0 {
+			grown[id] = size
+		}
+	}
+	return grown
+}
+
+// growExpandedFilesystem calls fsDevice() for the device containing
+// filesystem, once device has been found expanded
+func growExpandedFilesystem(dev, mountPoint, fsType string, knownSize int64, fsDevice func() (string, error)) (int64, error) {
+	size, err := GetDeviceSize(dev)
+	if err != nil {
+		return knownSize, err
+	}
+	if size <= knownSize {
+		return knownSize, nil
+	}
+	if isMountedReadOnly(mountPoint) {
+		log.Debugf("Device %v has been expanded, would grow filesystem when mounted read-write", dev)
+		return knownSize, nil
+	}
+	fsDev, err := fsDevice()
+	if err != nil {
+		return knownSize, err
+	}
+	if fsType == "" {
+		if fsType, err = GetFilesystemType(fsDev); err != nil {
+			return knownSize, err
+		}
+	}
+	if knownSize == 0 {
+		// Size filesystem was grown to wasn't recorded, e.g. by config of
+		// older version, so it's grown in case
+		log.Debugf("Growing %v filesystem mounted at %v to %v bytes of device %v",
+			fsType, mountPoint, size, dev)
+	} else {
+		log.Infof("Device %v has been expanded from %v to %v bytes, growing %v filesystem mounted at %v",
+			dev, knownSize, size, fsType, mountPoint)
+	}
+	if err := GrowFilesystem(fsDev, mountPoint, fsType); err != nil {
+		return knownSize, err
+	}
+	return size, nil
+}
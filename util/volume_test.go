@@ -1,6 +1,7 @@
 package util
 
 import (
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -39,17 +40,17 @@ func (s *TestSuite) TestVolumeHelper(c *C) {
 		Device: dev,
 	}
 
-	m, err := VolumeMount(r, "", false)
+	m, err := VolumeMount(r, "", false, false, "")
 	c.Assert(err, IsNil)
 	c.Assert(strings.HasPrefix(m, testMountPath), Equals, true)
 	c.Assert(r.MountPoint, Equals, m)
 
-	m2, err := VolumeMount(r, "", false)
+	m2, err := VolumeMount(r, "", false, false, "")
 	c.Assert(err, IsNil)
 	c.Assert(m2, Equals, m)
 
 	newMountPoint := "/tmp/util/mnt"
-	_, err = VolumeMount(r, newMountPoint, false)
+	_, err = VolumeMount(r, newMountPoint, false, false, "")
 	c.Assert(err, ErrorMatches, "Volume "+r.Name+" was already mounted at "+r.MountPoint+".*")
 
 	err = VolumeUmount(r)
@@ -60,7 +61,7 @@ func (s *TestSuite) TestVolumeHelper(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(r.MountPoint, Equals, "")
 
-	m, err = VolumeMount(r, newMountPoint, false)
+	m, err = VolumeMount(r, newMountPoint, false, false, "")
 	c.Assert(err, IsNil)
 	c.Assert(m, Equals, newMountPoint)
 	c.Assert(r.MountPoint, Equals, newMountPoint)
@@ -134,3 +135,132 @@ func testVolumeVMSupport(r *HelperVolume, s *TestSuite, c *C) {
 	c.Assert(err, IsNil)
 
 }
+
+func (s *TestSuite) TestFilesystemMountOpts(c *C) {
+	c.Assert(CheckMountOptions(""), IsNil)
+	c.Assert(CheckMountOptions("noatime"), IsNil)
+	c.Assert(CheckMountOptions("noatime,nobarrier,discard"), IsNil)
+	c.Assert(CheckMountOptions("noatime,,discard"), NotNil)
+	c.Assert(CheckMountOptions("noatime, discard"), NotNil)
+
+	c.Assert(GetFilesystemMountOpts("", ""), DeepEquals, []string{})
+	c.Assert(GetFilesystemMountOpts("xfs", ""), DeepEquals, []string{"-t", "xfs"})
+	c.Assert(GetFilesystemMountOpts("ext4", "noatime,discard"), DeepEquals,
+		[]string{"-t", "ext4", "-o", "noatime,discard"})
+}
+
+func (s *TestSuite) TestCloneMountOptions(c *C) {
+	c.Assert(CloneMountOptions(FS_EXT4, ""), Equals, "")
+	c.Assert(CloneMountOptions(FS_EXT4, "noatime"), Equals, "noatime")
+	c.Assert(CloneMountOptions(FS_XFS, ""), Equals, "nouuid")
+	c.Assert(CloneMountOptions(FS_XFS, "noatime"), Equals, "noatime,nouuid")
+	c.Assert(CloneMountOptions(FS_XFS, "nouuid,noatime"), Equals, "nouuid,noatime")
+}
+
+func (s *TestSuite) TestMountedReadOnly(c *C) {
+	output := `/dev/loop0 on /mnt/vol1 type ext4 (rw,relatime,data=ordered)
+/dev/loop1 on /mnt/vol2 type ext4 (ro,relatime,data=ordered)
+tmpfs on /mnt/vol3 type tmpfs (rw,relatime,size=1024k)
+tmpfs on /mnt/vol3 type tmpfs (ro,relatime,size=1024k)
+/dev/loop2 on /mnt/vol1-ro type xfs (ro,relatime)
+`
+	c.Assert(mountedReadOnly(output, "/mnt/vol1"), Equals, false)
+	c.Assert(mountedReadOnly(output, "/mnt/vol2"), Equals, true)
+	c.Assert(mountedReadOnly(output, "/mnt/vol3"), Equals, true)
+	c.Assert(mountedReadOnly(output, "/mnt/vol4"), Equals, false)
+}
+
+func (s *TestSuite) TestMountedAt(c *C) {
+	output := `/dev/loop0 on /mnt/vol1 type ext4 (rw,relatime,data=ordered)
+/dev/loop0 on /pods/pod1/vol1 type ext4 (ro,relatime,data=ordered)
+`
+	c.Assert(mountedAt(output, "/mnt/vol1"), Equals, true)
+	c.Assert(mountedAt(output, "/pods/pod1/vol1"), Equals, true)
+	c.Assert(mountedAt(output, "/mnt/vol"), Equals, false)
+	c.Assert(mountedAt(output, "/pods/pod1"), Equals, false)
+	c.Assert(mountedAt(output, "/dev/loop0"), Equals, false)
+}
+
+func (s *TestSuite) TestReconcileVolumeMount(c *C) {
+	mounts := `/dev/loop0 on /mnt/vol1 type ext4 (rw,relatime,data=ordered)
+`
+	v := &HelperVolume{
+		Name:       "reconcile1",
+		MountPoint: "/mnt/vol1",
+	}
+	action, err := ReconcileVolumeMount(v, mounts)
+	c.Assert(err, IsNil)
+	c.Assert(action, Equals, "")
+	c.Assert(v.MountPoint, Equals, "/mnt/vol1")
+
+	// Mount point left behind by volume not mounted
+	v = &HelperVolume{
+		Name: "reconcile2",
+	}
+	c.Assert(MkdirIfNotExists(v.GenerateDefaultMountPoint()), IsNil)
+	action, err = ReconcileVolumeMount(v, mounts)
+	c.Assert(err, IsNil)
+	c.Assert(action, Equals, "removed stale mount point "+v.GenerateDefaultMountPoint())
+	_, err = os.Stat(v.GenerateDefaultMountPoint())
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	action, err = ReconcileVolumeMount(v, mounts)
+	c.Assert(err, IsNil)
+	c.Assert(action, Equals, "")
+}
+
+func (s *TestSuite) TestCheckSubPath(c *C) {
+	c.Assert(CheckSubPath("data"), IsNil)
+	c.Assert(CheckSubPath("app/data/"), IsNil)
+	c.Assert(CheckSubPath("./data"), IsNil)
+	c.Assert(CheckSubPath("data..1"), IsNil)
+	c.Assert(CheckSubPath("/data"), NotNil)
+	c.Assert(CheckSubPath("../data"), NotNil)
+	c.Assert(CheckSubPath("app/../../data"), NotNil)
+	c.Assert(CheckSubPath("."), NotNil)
+	c.Assert(CheckSubPath(""), NotNil)
+	c.Assert(CheckSubPath("data\n"), NotNil)
+}
+
+type configHelperVolume struct {
+	HelperVolume
+	Size int64
+
+	root string
+}
+
+func (v *configHelperVolume) ConfigFile() (string, error) {
+	return filepath.Join(v.root, "volume_"+v.Name+".json"), nil
+}
+
+func (s *TestSuite) TestGrowMountedFilesystems(c *C) {
+	root := c.MkDir()
+	for _, v := range []*configHelperVolume{
+		{HelperVolume: HelperVolume{Name: "mounted", MountPoint: "/mnt/a"}, Size: 1},
+		{HelperVolume: HelperVolume{Name: "unmounted"}, Size: 1},
+	} {
+		v.root = root
+		c.Assert(ObjectSave(v), IsNil)
+	}
+
+	called := []string{}
+	grown := GrowMountedFilesystems([]string{"mounted", "unmounted", "missing"}, func(id string) (int64, error) {
+		volume := &configHelperVolume{HelperVolume: HelperVolume{Name: id}, root: root}
+		grown, err := GrowMountedVolume(volume, func() (bool, error) {
+			called = append(called, id)
+			volume.Size = 2
+			return true, nil
+		})
+		if err != nil || !grown {
+			return 0, err
+		}
+		return volume.Size, nil
+	})
+	// Volume failed to load doesn't stop the others
+	c.Assert(grown, DeepEquals, map[string]int64{"mounted": 2})
+	c.Assert(called, DeepEquals, []string{"mounted"})
+
+	volume := &configHelperVolume{HelperVolume: HelperVolume{Name: "mounted"}, root: root}
+	c.Assert(ObjectLoad(volume), IsNil)
+	c.Assert(volume.Size, Equals, int64(2))
+}
@@ -0,0 +1,373 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+/*
+DecodeYAML decodes a subset of YAML into v, which would be handled as if it's
+decoded from JSON, so the keys are matched with field names case-insensitively
+or by json tags. Supported are block mappings and sequences, plain and quoted
+scalars, and comments. All scalars are decoded as strings. Flow style, anchors,
+aliases, tags and multi-line scalars are not supported, and rejected rather
+than read as strings, except "{}" and "[]" of empty collections written by
+EncodeYAML. Since JSON is valid YAML, input starting with "{" or "[" would be
+decoded as JSON.
+*/
+func DecodeYAML(data []byte, v interface{}) error {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return json.Unmarshal(data, v)
+	}
+
+	lines, err := splitYAMLLines(string(data))
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if len(lines) != 0 {
+		next := 0
+		value, next, err = parseYAMLNode(lines, 0, lines[0].indent)
+		if err != nil {
+			return err
+		}
+		if next != len(lines) {
+			return fmt.Errorf("Invalid YAML at line %v: unexpected indentation", lines[next].number)
+		}
+	}
+	j, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, v)
+}
+
+func splitYAMLLines(data string) ([]yamlLine, error) {
+	lines := []yamlLine{}
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("Invalid YAML at line %v: tab cannot be used for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{
+			number: i + 1,
+			indent: len(line) - len(text),
+			text:   text,
+		})
+	}
+	return lines, nil
+}
+
+// stripYAMLComment removes comment starting with "#" at beginning of line or
+// after a space, outside of quotes
+func stripYAMLComment(line string) string {
+	quote := rune(0)
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func parseYAMLNode(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	if isYAMLSequenceItem(lines[i].text) {
+		return parseYAMLSequence(lines, i, indent)
+	}
+	if _, _, isPair := splitYAMLPair(lines[i].text); isPair {
+		return parseYAMLMapping(lines, i, indent)
+	}
+	value, err := parseYAMLScalar(lines[i])
+	return value, i + 1, err
+}
+
+func parseYAMLSequence(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	result := []interface{}{}
+	for i < len(lines) && lines[i].indent == indent && isYAMLSequenceItem(lines[i].text) {
+		var (
+			value interface{}
+			err   error
+		)
+		content := strings.TrimLeft(strings.TrimPrefix(lines[i].text, "-"), " ")
+		if content == "" {
+			value, i, err = parseYAMLChild(lines, i, indent)
+		} else {
+			// Treat content of item as the first line of a nested node,
+			// e.g. "- name: a" followed by "  size: 1G"
+			lines[i].indent += len(lines[i].text) - len(content)
+			lines[i].text = content
+			value, i, err = parseYAMLNode(lines, i, lines[i].indent)
+		}
+		if err != nil {
+			return nil, i, err
+		}
+		result = append(result, value)
+	}
+	return result, i, nil
+}
+
+func parseYAMLMapping(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	result := map[string]interface{}{}
+	for i < len(lines) && lines[i].indent == indent {
+		key, value, isPair := splitYAMLPair(lines[i].text)
+		if !isPair {
+			return nil, i, fmt.Errorf("Invalid YAML at line %v: expect key: value", lines[i].number)
+		}
+		key, err := unquoteYAMLScalar(key, lines[i].number)
+		if err != nil {
+			return nil, i, err
+		}
+		if _, exists := result[key]; exists {
+			return nil, i, fmt.Errorf("Invalid YAML at line %v: duplicate key %v", lines[i].number, key)
+		}
+		if value != "" {
+			result[key], err = parseYAMLValue(value, lines[i].number)
+			if err != nil {
+				return nil, i, err
+			}
+			i++
+			continue
+		}
+		// Sequence is allowed at the same indentation as its key
+		if i+1 < len(lines) && lines[i+1].indent == indent && isYAMLSequenceItem(lines[i+1].text) {
+			result[key], i, err = parseYAMLSequence(lines, i+1, indent)
+		} else {
+			result[key], i, err = parseYAMLChild(lines, i, indent)
+		}
+		if err != nil {
+			return nil, i, err
+		}
+	}
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, i, fmt.Errorf("Invalid YAML at line %v: unexpected indentation", lines[i].number)
+	}
+	return result, i, nil
+}
+
+// parseYAMLChild parses the node nested under line i, or returns nil if
+// there is none
+func parseYAMLChild(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	if i+1 >= len(lines) || lines[i+1].indent <= indent {
+		return nil, i + 1, nil
+	}
+	return parseYAMLNode(lines, i+1, lines[i+1].indent)
+}
+
+func splitYAMLPair(text string) (string, string, bool) {
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		// Quoted key
+		end := strings.IndexRune(text[1:], rune(text[0]))
+		if end < 0 {
+			return "", "", false
+		}
+		key := text[:end+2]
+		rest := text[end+2:]
+		if rest == ":" || strings.HasPrefix(rest, ": ") {
+			return key, strings.TrimSpace(strings.TrimPrefix(rest, ":")), true
+		}
+		return "", "", false
+	}
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSpace(strings.TrimSuffix(text, ":")), "", true
+	}
+	if idx := strings.Index(text, ": "); idx > 0 {
+		return strings.TrimSpace(text[:idx]), strings.TrimSpace(text[idx+2:]), true
+	}
+	return "", "", false
+}
+
+func parseYAMLScalar(line yamlLine) (interface{}, error) {
+	return parseYAMLValue(line.text, line.number)
+}
+
+// parseYAMLValue parses value inline after key or "-", which is either a
+// scalar or an empty flow collection
+func parseYAMLValue(value string, number int) (interface{}, error) {
+	switch value {
+	case "{}":
+		return map[string]interface{}{}, nil
+	case "[]":
+		return []interface{}{}, nil
+	}
+	return unquoteYAMLScalar(value, number)
+}
+
+func unquoteYAMLScalar(value string, number int) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	switch value[0] {
+	case '[', '{':
+		return "", fmt.Errorf("Invalid YAML at line %v: flow style %v is not supported", number, value)
+	case '&', '*':
+		return "", fmt.Errorf("Invalid YAML at line %v: anchor or alias %v is not supported", number, value)
+	case '!':
+		return "", fmt.Errorf("Invalid YAML at line %v: tag %v is not supported", number, value)
+	case '|', '>':
+		return "", fmt.Errorf("Invalid YAML at line %v: multi-line scalar %v is not supported", number, value)
+	case '@', '`', '%':
+		return "", fmt.Errorf("Invalid YAML at line %v: %v cannot start a plain scalar", number, value[:1])
+	case '"':
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("Invalid YAML at line %v: bad double quoted string %v", number, value)
+		}
+		return unquoted, nil
+	case '\'':
+		if len(value) < 2 || value[len(value)-1] != '\'' {
+			return "", fmt.Errorf("Invalid YAML at line %v: bad single quoted string %v", number, value)
+		}
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1), nil
+	}
+	return value, nil
+}
+
+/*
+EncodeYAML encodes v as block style YAML, as it would be encoded to JSON, so
+the keys are field names or json tags, and the keys of mappings are sorted.
+Strings which would be read as other scalars, or cannot be plain scalars, are
+double quoted, so the output can be read by DecodeYAML.
+*/
+func EncodeYAML(v interface{}) ([]byte, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(j))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	b := &bytes.Buffer{}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		if isEmptyYAMLCollection(value) {
+			b.WriteString(formatYAMLScalar(value) + "\n")
+		} else {
+			writeYAMLNode(b, value, 0)
+		}
+	default:
+		b.WriteString(formatYAMLScalar(value) + "\n")
+	}
+	return b.Bytes(), nil
+}
+
+func isEmptyYAMLCollection(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// writeYAMLNode writes non-empty mapping or sequence with lines indented by
+// indent
+func writeYAMLNode(b *bytes.Buffer, value interface{}, indent int) {
+	prefix := strings.Repeat(" ", indent)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := []string{}
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			b.WriteString(prefix + quoteYAMLString(key) + ":")
+			writeYAMLChild(b, v[key], indent+2)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok && len(m) != 0 {
+				// First key of mapping follows "-"
+				nested := &bytes.Buffer{}
+				writeYAMLNode(nested, m, indent+2)
+				b.WriteString(prefix + "- " + strings.TrimPrefix(nested.String(), prefix+"  "))
+				continue
+			}
+			b.WriteString(prefix + "-")
+			writeYAMLChild(b, item, indent+2)
+		}
+	}
+}
+
+// writeYAMLChild writes value after key or "-" of its parent, inline if it's
+// scalar or empty, or nested below otherwise
+func writeYAMLChild(b *bytes.Buffer, value interface{}, indent int) {
+	if isEmptyYAMLCollection(value) {
+		b.WriteString(" " + formatYAMLScalar(value) + "\n")
+		return
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		b.WriteString("\n")
+		writeYAMLNode(b, value, indent)
+	default:
+		b.WriteString(" " + formatYAMLScalar(value) + "\n")
+	}
+}
+
+func formatYAMLScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		return quoteYAMLString(v)
+	case map[string]interface{}:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	}
+	return fmt.Sprint(value)
+}
+
+// quoteYAMLString double quotes s if it cannot be a plain scalar of string
+func quoteYAMLString(s string) string {
+	if s == "" || strings.TrimSpace(s) != s ||
+		strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") ||
+		strings.IndexFunc(s, unicode.IsControl) >= 0 {
+		return strconv.Quote(s)
+	}
+	switch strings.ToLower(s) {
+	case "null", "~", "true", "false", "yes", "no", "on", "off":
+		return strconv.Quote(s)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	return s
+}
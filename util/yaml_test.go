@@ -0,0 +1,153 @@
+package util
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type yamlTestVolume struct {
+	Name   string
+	Size   string
+	Labels map[string]string
+}
+
+type yamlTestManifest struct {
+	Version string
+	Volumes []yamlTestVolume
+	Hosts   []string
+}
+
+func (s *TestSuite) TestDecodeYAML(c *C) {
+	data := `
+# manifest
+---
+version: "1"
+volumes:
+  - name: db # primary
+    size: 100G
+    labels:
+      tier: 'it''s db'
+      "url": s3://bucket@us-west-2/path
+  - name: cache
+hosts:
+- host1
+-   host2
+`
+	manifest := &yamlTestManifest{}
+	c.Assert(DecodeYAML([]byte(data), manifest), IsNil)
+	c.Assert(manifest, DeepEquals, &yamlTestManifest{
+		Version: "1",
+		Volumes: []yamlTestVolume{
+			{
+				Name: "db",
+				Size: "100G",
+				Labels: map[string]string{
+					"tier": "it's db",
+					"url":  "s3://bucket@us-west-2/path",
+				},
+			},
+			{
+				Name: "cache",
+			},
+		},
+		Hosts: []string{"host1", "host2"},
+	})
+
+	manifest = &yamlTestManifest{}
+	c.Assert(DecodeYAML([]byte(`{"volumes": [{"name": "json"}]}`), manifest), IsNil)
+	c.Assert(manifest.Volumes, DeepEquals, []yamlTestVolume{{Name: "json"}})
+
+	manifest = &yamlTestManifest{}
+	c.Assert(DecodeYAML([]byte(""), manifest), IsNil)
+	c.Assert(manifest, DeepEquals, &yamlTestManifest{})
+
+	err := DecodeYAML([]byte("volumes:\n  - name: a\n     size: 1G\n"), manifest)
+	c.Assert(err, ErrorMatches, "Invalid YAML at line 3.*")
+
+	err = DecodeYAML([]byte("version: 1\nversion: 2\n"), manifest)
+	c.Assert(err, ErrorMatches, ".*duplicate key version")
+
+	err = DecodeYAML([]byte("version: \"1\n"), manifest)
+	c.Assert(err, ErrorMatches, ".*bad double quoted string.*")
+
+	// Unsupported syntax is rejected rather than read as strings
+	for _, data := range []string{
+		"hosts: [host1, host2]\n",
+		"volumes:\n  - {name: a}\n",
+		"version: &v 1\n",
+		"version: *v\n",
+		"version: !!str 1\n",
+		"version: |\n  1\n",
+		"version: >\n  1\n",
+		"version: 1\n  2\n",
+		"version: '\n",
+	} {
+		err = DecodeYAML([]byte(data), &yamlTestManifest{})
+		c.Assert(err, ErrorMatches, "Invalid YAML at line .*", Commentf(data))
+	}
+
+	// Empty collections are written by EncodeYAML
+	manifest = &yamlTestManifest{}
+	c.Assert(DecodeYAML([]byte("version: \"1\"\nvolumes: []\nhosts:\n- host1\n"), manifest), IsNil)
+	c.Assert(manifest.Volumes, HasLen, 0)
+	c.Assert(manifest.Hosts, DeepEquals, []string{"host1"})
+	volume := &yamlTestVolume{}
+	c.Assert(DecodeYAML([]byte("name: a\nlabels: {}\n"), volume), IsNil)
+	c.Assert(volume.Labels, DeepEquals, map[string]string{})
+}
+
+func (s *TestSuite) TestEncodeYAML(c *C) {
+	value := map[string]interface{}{
+		"Name":    "db",
+		"Size":    107374182400,
+		"Mounted": true,
+		"Labels": map[string]string{
+			"tier": "it's db",
+			"url":  "s3://bucket@us-west-2/path",
+		},
+		"Snapshots": []map[string]interface{}{
+			{"Name": "snap1", "Size": "100"},
+			{"Name": "- dash"},
+		},
+		"Hosts": []string{"host1", ""},
+		"Empty": map[string]string{},
+		"None":  nil,
+	}
+	out, err := EncodeYAML(value)
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, `Empty: {}
+Hosts:
+  - host1
+  - ""
+Labels:
+  tier: it's db
+  url: s3://bucket@us-west-2/path
+Mounted: true
+Name: db
+None: null
+Size: 107374182400
+Snapshots:
+  - Name: snap1
+    Size: "100"
+  - Name: "- dash"
+`)
+
+	manifest := &yamlTestManifest{
+		Version: "1",
+		Volumes: []yamlTestVolume{
+			{Name: "db", Size: "100G", Labels: map[string]string{"note": "key: value # not a comment"}},
+		},
+		Hosts: []string{"true", "host2"},
+	}
+	out, err = EncodeYAML(manifest)
+	c.Assert(err, IsNil)
+	decoded := &yamlTestManifest{}
+	c.Assert(DecodeYAML(out, decoded), IsNil)
+	c.Assert(decoded, DeepEquals, manifest)
+
+	out, err = EncodeYAML("plain")
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "plain\n")
+	out, err = EncodeYAML([]string{})
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "[]\n")
+}
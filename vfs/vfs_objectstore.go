@@ -151,7 +151,12 @@ func (v *VfsObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
 	return os.Rename(v.updatePath(tmpFile), v.updatePath(dst))
 }
 
+// List returns nothing if path doesn't exist, the same as prefixes without
+// objects of S3
 func (v *VfsObjectStoreDriver) List(path string) ([]string, error) {
+	if _, err := os.Stat(v.updatePath(path)); os.IsNotExist(err) {
+		return nil, nil
+	}
 	out, err := util.Execute("ls", []string{"-1", v.updatePath(path)})
 	if err != nil {
 		return nil, err
@@ -0,0 +1,120 @@
+package vfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	VFS_QUOTA = "vfs.quota"
+
+	QUOTA_XFS  = "xfs"
+	QUOTA_EXT4 = "ext4"
+
+	XFS_QUOTA_BINARY = "xfs_quota"
+
+	// Project IDs below this are left for administrators to assign by hand
+	QUOTA_PROJECT_ID_BASE = 10000
+)
+
+func validateQuotaType(quota string) error {
+	switch quota {
+	case "", QUOTA_XFS, QUOTA_EXT4:
+		return nil
+	}
+	return fmt.Errorf("Invalid %v %v, should be %v or %v", VFS_QUOTA, quota, QUOTA_XFS, QUOTA_EXT4)
+}
+
+// xfsQuota runs a xfs_quota expert command against the filesystem backing
+// vfs.path. ext4 project quotas are managed by xfs_quota in foreign mode.
+func (d *Driver) xfsQuota(command string) (string, error) {
+	args := []string{"-x"}
+	if d.Quota == QUOTA_EXT4 {
+		args = append(args, "-f")
+	}
+	args = append(args, "-c", command, d.Path)
+	return util.Execute(XFS_QUOTA_BINARY, args)
+}
+
+func (d *Driver) allocateProjectID() (uint32, error) {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return 0, err
+	}
+	id := uint32(QUOTA_PROJECT_ID_BASE)
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return 0, err
+		}
+		if volume.ProjectID >= id {
+			id = volume.ProjectID + 1
+		}
+	}
+	return id, nil
+}
+
+func (d *Driver) setupQuota(volume *Volume) error {
+	projectID, err := d.allocateProjectID()
+	if err != nil {
+		return err
+	}
+	id := strconv.FormatUint(uint64(projectID), 10)
+	if _, err := d.xfsQuota(fmt.Sprintf("project -s -p %v %v", volume.Path, id)); err != nil {
+		return err
+	}
+	if err := d.setQuotaLimit(projectID, volume.Size); err != nil {
+		return err
+	}
+	volume.ProjectID = projectID
+	log.Debugf("Set project quota %v for volume %v with project ID %v", volume.Size, volume.Name, projectID)
+	return nil
+}
+
+func (d *Driver) setQuotaLimit(projectID uint32, size int64) error {
+	_, err := d.xfsQuota(fmt.Sprintf("limit -p bhard=%v %v", size, projectID))
+	return err
+}
+
+// resizeQuota raises the limit of the volume's project, so the volume can
+// grow to size
+func (d *Driver) resizeQuota(volume *Volume, size int64) error {
+	if volume.ProjectID == 0 {
+		return fmt.Errorf("Volume %v has no quota to resize, it's created without %v", volume.Name, VFS_QUOTA)
+	}
+	if err := d.setQuotaLimit(volume.ProjectID, size); err != nil {
+		return err
+	}
+	log.Debugf("Resized project quota of volume %v from %v to %v", volume.Name, volume.Size, size)
+	volume.Size = size
+	return nil
+}
+
+func (d *Driver) cleanupQuota(volume *Volume) error {
+	if volume.ProjectID == 0 {
+		return nil
+	}
+	return d.setQuotaLimit(volume.ProjectID, 0)
+}
+
+// getQuotaUsage returns the bytes used by the volume's project, as accounted
+// by the filesystem
+func (d *Driver) getQuotaUsage(volume *Volume) (int64, error) {
+	out, err := d.xfsQuota(fmt.Sprintf("quota -p -N -b %v", volume.ProjectID))
+	if err != nil {
+		return 0, err
+	}
+	// Output: <filesystem> <blocks> <quota> <limit> <warn/time> <mounted on>
+	fields := strings.Fields(out)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("Cannot parse quota output for project %v: %v", volume.ProjectID, out)
+	}
+	blocks, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot parse quota output for project %v: %v", volume.ProjectID, out)
+	}
+	return blocks * 1024, nil
+}
@@ -46,6 +46,7 @@ type Device struct {
 	Path              string
 	ConfigPath        string
 	DefaultVolumeSize int64
+	Quota             string
 }
 
 func (dev *Device) ConfigFile() (string, error) {
@@ -69,6 +70,7 @@ type Volume struct {
 	MountPoint   string
 	PrepareForVM bool
 	CreatedTime  string
+	ProjectID    uint32
 	Snapshots    map[string]Snapshot
 
 	configPath string
@@ -131,6 +133,11 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 			return nil, fmt.Errorf("Illegal default volume size specified")
 		}
 		dev.DefaultVolumeSize = volumeSize
+
+		if err := validateQuotaType(config[VFS_QUOTA]); err != nil {
+			return nil, err
+		}
+		dev.Quota = config[VFS_QUOTA]
 	}
 
 	// For upgrade case
@@ -153,13 +160,68 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 }
 
 func (d *Driver) Info() (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
 	return map[string]string{
 		"Root":              d.Root,
 		"Path":              d.Path,
 		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"Quota":             d.Quota,
 	}, nil
 }
 
+// ReloadDefaults changes default size of new volumes, the only default of
+// vfs
+func (d *Driver) ReloadDefaults(opts map[string]string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	dev := d.Device
+	for k, v := range opts {
+		switch k {
+		case VFS_DEFAULT_VOLUME_SIZE:
+			size, err := util.ParseSize(v)
+			if err != nil || size == 0 {
+				return fmt.Errorf("Illegal default volume size specified")
+			}
+			dev.DefaultVolumeSize = size
+		default:
+			return fmt.Errorf("Driver option %v cannot be changed without restart", k)
+		}
+	}
+	if err := util.ObjectSave(&dev); err != nil {
+		return err
+	}
+	d.Device = dev
+	return nil
+}
+
+func (d *Driver) Capabilities() Capabilities {
+	return Capabilities{
+		Snapshot: true,
+		Backup:   true,
+		Clone:    true,
+		// Volumes are limited only by project quotas
+		Resize: d.Quota != "",
+		// Only if vfs.path is shared storage, e.g. NFS
+		CrossHostAttach: true,
+	}
+}
+
+// CheckHealth would fail if vfs.path is no longer accessible, e.g. NFS server
+// is gone
+func (d *Driver) CheckHealth() error {
+	st, err := os.Stat(d.Path)
+	if err != nil {
+		return err
+	}
+	if !st.IsDir() {
+		return fmt.Errorf("%v is not a directory", d.Path)
+	}
+	return nil
+}
+
 func (d *Driver) VolumeOps() (VolumeOperations, error) {
 	return d, nil
 }
@@ -203,6 +265,16 @@ func (d *Driver) CreateVolume(req Request) error {
 			return fmt.Errorf("Cannot restore backup of %v to %v", objVolume.Driver, d.Name())
 		}
 	}
+	var source *Volume
+	if sourceName := opts[OPT_CLONE_VOLUME]; sourceName != "" {
+		if backupURL != "" {
+			return fmt.Errorf("Cannot clone volume and restore backup at the same time")
+		}
+		source = d.blankVolume(sourceName)
+		if err := util.ObjectLoad(source); err != nil {
+			return err
+		}
+	}
 
 	exists, err := util.ObjectExists(volume)
 	if err != nil {
@@ -216,7 +288,10 @@ func (d *Driver) CreateVolume(req Request) error {
 	if err != nil {
 		return err
 	}
-	if volume.PrepareForVM {
+	if source != nil {
+		volume.PrepareForVM = source.PrepareForVM
+	}
+	if volume.PrepareForVM || d.Quota != "" {
 		volume.Size, err = d.getSize(opts, d.DefaultVolumeSize)
 		if err != nil {
 			return err
@@ -233,18 +308,53 @@ func (d *Driver) CreateVolume(req Request) error {
 	volume.Name = id
 
 	if backupURL != "" {
-		file, err := objectstore.RestoreSingleFileBackup(backupURL, volumePath)
+		_, span := util.StartSpan(req.Context, "objectstore.restore")
+		err := restoreBackup(backupURL, volumePath)
+		span.End(err)
+		if err != nil {
+			return err
+		}
+	}
+	if source != nil {
+		if source.MountPoint != "" {
+			log.Debugf("syncing filesystems...")
+			if err := util.Sync(); err != nil {
+				return err
+			}
+		}
+		_, span := util.StartSpan(req.Context, "copy")
+		err := util.CopyDir(source.Path, volumePath)
+		span.End(err)
 		if err != nil {
 			return err
 		}
-		// file would be removed after this because it's under volumePath
-		if err := util.DecompressDir(file, volumePath); err != nil {
+	}
+	if d.Quota != "" {
+		if err := d.setupQuota(volume); err != nil {
 			return err
 		}
 	}
 	return util.ObjectSave(volume)
 }
 
+// restoreBackup restores backupURL to volumePath, from file set, or the single
+// file of backups created before file set backups
+func restoreBackup(backupURL, volumePath string) error {
+	fileSet, err := objectstore.IsFileSetBackup(backupURL)
+	if err != nil {
+		return err
+	}
+	if fileSet {
+		return objectstore.RestoreFileSetBackup(backupURL, volumePath)
+	}
+	file, err := objectstore.RestoreSingleFileBackup(backupURL, volumePath)
+	if err != nil {
+		return err
+	}
+	// file would be removed after this because it's under volumePath
+	return util.DecompressDir(file, volumePath)
+}
+
 func (d *Driver) DeleteVolume(req Request) error {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
@@ -274,6 +384,9 @@ func (d *Driver) DeleteVolume(req Request) error {
 			return fmt.Errorf("Fail to cleanup the volume, output: %v, error: %v", out, err.Error())
 		}
 	}
+	if err := d.cleanupQuota(volume); err != nil {
+		return err
+	}
 	return util.ObjectDelete(volume)
 }
 
@@ -337,6 +450,40 @@ func (d *Driver) UmountVolume(req Request) error {
 	return util.ObjectSave(volume)
 }
 
+// ResizeVolume raises project quota of the volume. There's no filesystem of
+// the volume's own to grow.
+func (d *Driver) ResizeVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+
+	lockFile, err := flock(volume)
+	if err != nil {
+		return fmt.Errorf("Coudln't get flock. Error: %v", err)
+	}
+	defer util.UnlockFile(lockFile)
+
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if volume.PrepareForVM {
+		return fmt.Errorf("Cannot resize volume %v prepared for VM, whose image file has fixed size", id)
+	}
+	size, err := util.ParseSize(req.Options[OPT_SIZE])
+	if err != nil {
+		return err
+	}
+	if size <= volume.Size {
+		return fmt.Errorf("Volume %v of %v bytes cannot be resized to %v bytes, it can only be expanded", id, volume.Size, size)
+	}
+	if err := d.resizeQuota(volume, size); err != nil {
+		return err
+	}
+	return util.ObjectSave(volume)
+}
+
 func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
 	d.mutex.RLock()
 	defer d.mutex.RUnlock()
@@ -369,17 +516,43 @@ func (d *Driver) getVolumeInfo(name string) (map[string]string, error) {
 
 	size := "0"
 	prepareForVM := strconv.FormatBool(volume.PrepareForVM)
-	if volume.PrepareForVM {
+	if volume.PrepareForVM || volume.ProjectID != 0 {
 		size = strconv.FormatInt(volume.Size, 10)
 	}
-	return map[string]string{
+	info := map[string]string{
 		"Path":                  volume.Path,
 		OPT_MOUNT_POINT:         volume.MountPoint,
 		OPT_SIZE:                size,
 		OPT_PREPARE_FOR_VM:      prepareForVM,
 		OPT_VOLUME_NAME:         volume.Name,
 		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
-	}, nil
+	}
+	if volume.ProjectID != 0 {
+		info["ProjectID"] = strconv.FormatUint(uint64(volume.ProjectID), 10)
+		usage, err := d.getQuotaUsage(volume)
+		if err != nil {
+			log.Warnf("Failed to get quota usage of volume %v: %v", name, err)
+		} else {
+			info["Usage"] = strconv.FormatInt(usage, 10)
+		}
+	}
+	return info, nil
+}
+
+// VolumeUsage reports usage of the project quota of volume if there is one,
+// otherwise the space taken by files in the directory of volume
+func (d *Driver) VolumeUsage(name string) (int64, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return 0, err
+	}
+	if volume.ProjectID != 0 {
+		return d.getQuotaUsage(volume)
+	}
+	return util.DiskUsage(volume.Path)
 }
 
 func (d *Driver) MountPoint(req Request) (string, error) {
@@ -566,11 +739,16 @@ func (d *Driver) CreateBackup(snapshotID, volumeID, destURL string, opts map[str
 		Driver:      d.Name(),
 		CreatedTime: opts[OPT_VOLUME_CREATED_TIME],
 	}
+	labels, err := util.DecodeLabels(opts[OPT_BACKUP_LABELS])
+	if err != nil {
+		return "", err
+	}
 	objSnapshot := &objectstore.Snapshot{
 		Name:        snapshotID,
 		CreatedTime: opts[OPT_SNAPSHOT_CREATED_TIME],
+		Labels:      labels,
 	}
-	return objectstore.CreateSingleFileBackup(objVolume, objSnapshot, snapshot.FilePath, destURL)
+	return objectstore.CreateFileSetBackup(objVolume, objSnapshot, snapshot.FilePath, destURL)
 }
 
 func (d *Driver) DeleteBackup(backupURL string) error {
@@ -581,6 +759,13 @@ func (d *Driver) DeleteBackup(backupURL string) error {
 	if objVolume.Driver != d.Name() {
 		return fmt.Errorf("BUG: Wrong driver handling DeleteBackup(), driver should be %v but is %v", objVolume.Driver, d.Name())
 	}
+	fileSet, err := objectstore.IsFileSetBackup(backupURL)
+	if err != nil {
+		return err
+	}
+	if fileSet {
+		return objectstore.DeleteFileSetBackup(backupURL)
+	}
 	return objectstore.DeleteSingleFileBackup(backupURL)
 }
 